@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/restic/restic/internal/backend/selftest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/ui/table"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdBackend = &cobra.Command{
+	Use:   "backend check",
+	Short: "Check that the backend is usable",
+	Long: `
+The "backend check" command verifies that restic can use the backend at the
+repository location. It stores a small probe file with a random name next to
+the lock files, reads it back, lists it and removes it again, and reports how
+long each operation took. The probe is ignored by all other commands, the
+files of a repository at the location are not touched, and no password is
+needed. The location does not need to contain a repository yet,
+in that case it is prepared the same way as by the "init" command.
+
+The probe file is removed even when one of the operations fails.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBackend(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdBackend)
+}
+
+func runBackend(gopts GlobalOptions, args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return errors.Fatal("usage: restic backend check")
+	}
+
+	if err := checkAppendOnly(gopts, "backend check"); err != nil {
+		return err
+	}
+
+	if gopts.Repo == "" {
+		return errors.Fatal("Please specify repository location (-r)")
+	}
+
+	be, err := open(gopts.Repo, gopts, gopts.extended)
+	if err != nil {
+		// there may be no repository at the location yet
+		debug.Log("open failed, trying to create the backend: %v", err)
+		var cerr error
+		be, cerr = create(gopts.Repo, gopts.extended)
+		if cerr != nil {
+			debug.Log("create failed: %v", cerr)
+			return err
+		}
+	}
+	defer be.Close()
+
+	steps, err := selftest.Run(gopts.ctx, be)
+	if gopts.JSON {
+		if jerr := printBackendCheckJSON(gopts, steps, err); jerr != nil {
+			return jerr
+		}
+	} else if werr := printBackendCheck(gopts, steps); werr != nil {
+		return werr
+	}
+
+	if err != nil {
+		return errors.Fatalf("backend check failed: %v", err)
+	}
+
+	if !gopts.JSON {
+		Verbosef("backend check passed\n")
+	}
+	return nil
+}
+
+func printBackendCheck(gopts GlobalOptions, steps []selftest.Step) error {
+	type stepInfo struct {
+		Name     string
+		Duration string
+		Result   string
+	}
+
+	tab := table.New()
+	tab.AddColumn("Operation", "{{ .Name }}")
+	tab.AddColumn("Duration", "{{ .Duration }}")
+	tab.AddColumn("Result", "{{ .Result }}")
+
+	for _, step := range steps {
+		info := stepInfo{
+			Name:     step.Name,
+			Duration: step.Duration.Round(time.Millisecond).String(),
+			Result:   "ok",
+		}
+		if step.Err != nil {
+			info.Result = step.Err.Error()
+		}
+		tab.AddRow(info)
+	}
+
+	return tab.Write(gopts.stdout)
+}
+
+func printBackendCheckJSON(gopts GlobalOptions, steps []selftest.Step, err error) error {
+	type stepInfo struct {
+		Name     string  `json:"name"`
+		Duration float64 `json:"duration"`
+		Error    string  `json:"error,omitempty"`
+	}
+
+	type result struct {
+		Success bool       `json:"success"`
+		Steps   []stepInfo `json:"steps"`
+	}
+
+	res := result{
+		Success: err == nil,
+		Steps:   []stepInfo{},
+	}
+	for _, step := range steps {
+		info := stepInfo{
+			Name:     step.Name,
+			Duration: step.Duration.Seconds(),
+		}
+		if step.Err != nil {
+			info.Error = step.Err.Error()
+		}
+		res.Steps = append(res.Steps, info)
+	}
+
+	return json.NewEncoder(gopts.stdout).Encode(res)
+}
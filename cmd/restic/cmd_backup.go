@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +27,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/textfile"
 	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/eventsocket"
 	"github.com/restic/restic/internal/ui/jsonstatus"
 	"github.com/restic/restic/internal/ui/termstatus"
 )
@@ -36,16 +39,6 @@ var cmdBackup = &cobra.Command{
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
 `,
-	PreRun: func(cmd *cobra.Command, args []string) {
-		if backupOptions.Host == "" {
-			hostname, err := os.Hostname()
-			if err != nil {
-				debug.Log("os.Hostname() returned err: %v", err)
-				return
-			}
-			backupOptions.Host = hostname
-		}
-	},
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if backupOptions.Stdin {
@@ -54,6 +47,11 @@ given as the arguments.
 					return errors.Fatal("cannot use both `--stdin` and `--files-from -`")
 				}
 			}
+			for _, filename := range backupOptions.FilesFromRaw {
+				if filename == "-" {
+					return errors.Fatal("cannot use both `--stdin` and `--files-from-raw -`")
+				}
+			}
 		}
 
 		var t tomb.Tomb
@@ -72,21 +70,45 @@ given as the arguments.
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
 	Parent              string
+	ParentHost          string
+	ParentPaths         []string
 	Force               bool
 	Excludes            []string
 	InsensitiveExcludes []string
+	ExcludeRegexps      []string
+	InsensitiveRegexps  []string
 	ExcludeFiles        []string
 	ExcludeOtherFS      bool
+	CrossMounts         []string
 	ExcludeIfPresent    []string
+	IgnoreFiles         []string
 	ExcludeCaches       bool
+	ExcludeContentTypes []string
+	ExcludeLargerThan   string
+	ExcludeSmallerThan  string
 	Stdin               bool
 	StdinFilename       string
 	Tags                []string
+	Description         string
 	Host                string
 	FilesFrom           []string
+	FilesFromRaw        []string
 	TimeStamp           string
 	WithAtime           bool
 	IgnoreInode         bool
+	StoreContentHash    bool
+	MetadataOnly        bool
+	SkipBindMounts      bool
+	FollowSymlinks      []string
+	NoScan              bool
+	SplitTrees          bool
+	UseVSS              bool
+	WindowsAttributes   bool
+	Deterministic       bool
+	CheckpointInterval  time.Duration
+	VerifyUploads       bool
+	EventSocket         string
+	EventSocketWait     bool
 }
 
 var backupOptions BackupOptions
@@ -96,25 +118,49 @@ func init() {
 
 	f := cmdBackup.Flags()
 	f.StringVar(&backupOptions.Parent, "parent", "", "use this parent snapshot (default: last snapshot in the repo that has the same target files/directories)")
+	f.StringVar(&backupOptions.ParentHost, "parent-host", "", "look for the parent snapshot among the snapshots of `host` instead of the host of the new snapshot")
+	f.StringArrayVar(&backupOptions.ParentPaths, "parent-path", nil, "look for the parent snapshot among the snapshots of `path` instead of the target files/directories (can be specified multiple times)")
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
 	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.InsensitiveExcludes, "iexclude", nil, "same as `--exclude` but ignores the casing of filenames")
+	f.StringArrayVar(&backupOptions.ExcludeRegexps, "exclude-regex", nil, "exclude paths matching the regular `expression` (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.InsensitiveRegexps, "iexclude-regex", nil, "same as `--exclude-regex` but ignores the casing of filenames")
 	f.StringArrayVar(&backupOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
 	f.BoolVarP(&backupOptions.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems")
+	f.StringArrayVar(&backupOptions.CrossMounts, "cross-mount", nil, "with --one-file-system, also save the file system mounted at `path` (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.IgnoreFiles, "ignore-files", nil, "read exclude patterns from files with `name` in each directory, the patterns apply to the directory and are relative to it (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes filename[:header], exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See http://bford.info/cachedir/spec.html for the Cache Directory Tagging Standard`)
+	f.StringArrayVar(&backupOptions.ExcludeContentTypes, "exclude-content-type", nil, "exclude files whose content matches the MIME `type` (e.g. image/jpeg or image/*), regardless of the filename (can be specified multiple times)")
+	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "exclude files larger than `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringVar(&backupOptions.ExcludeSmallerThan, "exclude-smaller-than", "", "exclude files smaller than `size` (allowed suffixes: k/K, m/M, g/G, t/T), use 1 to exclude empty files")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "file name to use when reading from stdin")
 	f.StringArrayVar(&backupOptions.Tags, "tag", nil, "add a `tag` for the new snapshot (can be specified multiple times)")
+	f.StringVar(&backupOptions.Description, "description", "", "set a free-form `description` for the new snapshot")
 
-	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
+	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually (default: the repository's default host or the hostname). To prevent an expensive rescan use the \"parent\" flag")
 	f.StringVar(&backupOptions.Host, "hostname", "", "set the `hostname` for the snapshot manually")
 	f.MarkDeprecated("hostname", "use --host")
 
 	f.StringArrayVar(&backupOptions.FilesFrom, "files-from", nil, "read the files to backup from file (can be combined with file args/can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.FilesFromRaw, "files-from-raw", nil, "read the files to backup from file, separated by NUL bytes and used verbatim (can be combined with file args/can be specified multiple times)")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "time of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.BoolVar(&backupOptions.StoreContentHash, "store-content-hash", false, "store the SHA-256 hash of the content of each file (see verify-manifest)")
+	f.BoolVar(&backupOptions.MetadataOnly, "metadata-only", false, "save only the metadata of files (names, sizes, modes, times) but not their content, such snapshots cannot be restored")
+	f.BoolVar(&backupOptions.SkipBindMounts, "skip-bind-mounts", false, "save directories which are mounted at several paths (e.g. bind mounts) only once, as a symlink for the other paths")
+	f.StringArrayVar(&backupOptions.FollowSymlinks, "follow-symlink", nil, "save the target of the symlink at `path` in its place instead of the symlink (can be specified multiple times)")
+	f.BoolVar(&backupOptions.NoScan, "no-scan", false, "do not scan the targets to estimate the total size, the progress is reported without a total")
+	f.BoolVar(&backupOptions.UseVSS, "use-vss", false, "read files from Volume Shadow Copy snapshots, so that files opened by other programs can be saved (Windows only, requires administrator privileges)")
+	f.BoolVar(&backupOptions.WindowsAttributes, "windows-attributes", false, "store the file attributes (e.g. hidden, system) and alternate data streams of files and directories (Windows only)")
+	f.BoolVar(&backupOptions.SplitTrees, "split-trees", false, "split the metadata of large directories into chunks, so that a change only saves the changed chunk (must be enabled for the repository)")
+	f.BoolVar(&backupOptions.Deterministic, "deterministic", false, "derive the snapshot ID from the snapshot only, so backing up the same data with the same --time and parent yields the same ID")
+	f.BoolVar(&backupOptions.VerifyUploads, "verify-uploads", false, "read back the header of each uploaded pack file and check that it lists the saved blobs (slower, one additional request per pack file)")
+	f.StringVar(&backupOptions.EventSocket, "event-socket", "", "send the progress as JSON events to all clients connected to the Unix domain socket at `path`")
+	f.BoolVar(&backupOptions.EventSocketWait, "event-socket-wait", false, "wait until a client has connected to --event-socket before starting the backup")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 0, "save the uploaded data, the index and a partial checkpoint snapshot every `interval` (e.g. 10m), so an interrupted backup does not read the saved files again when restarted (default: disabled)")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -184,10 +230,63 @@ func readLinesFromFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
+// readFilenamesFromFileRaw reads a list of NUL-separated filenames from the
+// given file, or from the standard input if filename is a dash (-).
+func readFilenamesFromFileRaw(filename string) (names []string, err error) {
+	f := os.Stdin
+	if filename != "-" {
+		if f, err = os.Open(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	names, err = readFilenamesRaw(f)
+	if err != nil {
+		// ignore subsequent errors
+		_ = f.Close()
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// readFilenamesRaw returns the NUL-terminated filenames read from r. The
+// names are returned exactly as read, without any trimming or expansion.
+func readFilenamesRaw(r io.Reader) (names []string, err error) {
+	br := bufio.NewReader(r)
+	for {
+		name, err := br.ReadString(0)
+		switch err {
+		case nil:
+		case io.EOF:
+			if name == "" {
+				return names, nil
+			}
+			return nil, errors.Fatal("--files-from-raw: trailing NUL byte missing")
+		default:
+			return nil, err
+		}
+
+		name = name[:len(name)-1]
+		if name == "" {
+			// The empty filename is never valid, and filepath.Clean("")
+			// would turn it into the current directory.
+			return nil, errors.Fatal("--files-from-raw: empty filename in listing")
+		}
+		names = append(names, name)
+	}
+}
+
 // Check returns an error when an invalid combination of options was set.
 func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 	if gopts.password == "" {
-		for _, filename := range opts.FilesFrom {
+		filesFrom := append(append([]string(nil), opts.FilesFrom...), opts.FilesFromRaw...)
+		for _, filename := range filesFrom {
 			if filename == "-" {
 				return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 			}
@@ -198,12 +297,57 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		if len(opts.FilesFrom) > 0 {
 			return errors.Fatal("--stdin and --files-from cannot be used together")
 		}
+		if len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--stdin and --files-from-raw cannot be used together")
+		}
 
 		if len(args) > 0 {
 			return errors.Fatal("--stdin was specified and files/dirs were listed as arguments")
 		}
 	}
 
+	if opts.UseVSS {
+		if runtime.GOOS != "windows" {
+			return errors.Fatal("--use-vss is only supported on Windows")
+		}
+		if opts.Stdin {
+			return errors.Fatal("--use-vss and --stdin cannot be used together")
+		}
+	}
+
+	if len(opts.FollowSymlinks) > 0 && opts.Stdin {
+		return errors.Fatal("--follow-symlink and --stdin cannot be used together")
+	}
+
+	if opts.WindowsAttributes && runtime.GOOS != "windows" {
+		return errors.Fatal("--windows-attributes is only supported on Windows")
+	}
+
+	if opts.CheckpointInterval < 0 {
+		return errors.Fatal("--checkpoint-interval must not be negative")
+	}
+
+	if opts.Parent != "" && (opts.ParentHost != "" || len(opts.ParentPaths) > 0) {
+		return errors.Fatal("--parent cannot be used together with --parent-host or --parent-path")
+	}
+
+	if len(opts.CrossMounts) > 0 && !opts.ExcludeOtherFS {
+		return errors.Fatal("--cross-mount can only be used together with --one-file-system")
+	}
+
+	if opts.MetadataOnly {
+		if opts.Stdin {
+			return errors.Fatal("--metadata-only and --stdin cannot be used together")
+		}
+		if opts.StoreContentHash {
+			return errors.Fatal("--metadata-only and --store-content-hash cannot be used together")
+		}
+	}
+
+	if opts.EventSocketWait && opts.EventSocket == "" {
+		return errors.Fatal("--event-socket-wait can only be used together with --event-socket")
+	}
+
 	return nil
 }
 
@@ -237,6 +381,15 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 		fs = append(fs, rejectByPattern(opts.Excludes))
 	}
 
+	if len(opts.ExcludeRegexps) > 0 || len(opts.InsensitiveRegexps) > 0 {
+		f, err := rejectByRegexp(opts.ExcludeRegexps, opts.InsensitiveRegexps)
+		if err != nil {
+			return nil, err
+		}
+
+		fs = append(fs, f)
+	}
+
 	if opts.ExcludeCaches {
 		opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
 	}
@@ -250,15 +403,82 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 		fs = append(fs, f)
 	}
 
+	if len(opts.IgnoreFiles) > 0 && !opts.Stdin {
+		f, err := rejectByIgnoreFiles(opts.IgnoreFiles, targets)
+		if err != nil {
+			return nil, err
+		}
+
+		fs = append(fs, f)
+	}
+
 	return fs, nil
 }
 
+// resolveFollowSymlinks returns the absolute paths of the symlinks which
+// are followed. All paths must refer to symlinks.
+func resolveFollowSymlinks(paths []string) ([]string, error) {
+	var result []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, errors.Fatalf("invalid --follow-symlink %v: %v", p, err)
+		}
+
+		fi, err := fs.Lstat(abs)
+		if err != nil {
+			return nil, errors.Fatalf("invalid --follow-symlink %v: %v", p, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return nil, errors.Fatalf("invalid --follow-symlink %v: not a symlink", p)
+		}
+
+		result = append(result, abs)
+	}
+	return result, nil
+}
+
 // collectRejectFuncs returns a list of all functions which may reject data
 // from being saved in a snapshot based on path and file info
 func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectFunc, err error) {
 	// allowed devices
 	if opts.ExcludeOtherFS && !opts.Stdin {
-		f, err := rejectByDevice(targets)
+		f, err := rejectByDevice(targets, opts.CrossMounts)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if (opts.ExcludeLargerThan != "" || opts.ExcludeSmallerThan != "") && !opts.Stdin {
+		minSize, maxSize := int64(0), int64(math.MaxInt64)
+		if opts.ExcludeSmallerThan != "" {
+			minSize, err = parseSizeStr(opts.ExcludeSmallerThan)
+			if err != nil {
+				return nil, errors.Fatalf("invalid --exclude-smaller-than: %v", err)
+			}
+		}
+		if opts.ExcludeLargerThan != "" {
+			maxSize, err = parseSizeStr(opts.ExcludeLargerThan)
+			if err != nil {
+				return nil, errors.Fatalf("invalid --exclude-larger-than: %v", err)
+			}
+		}
+		if minSize > maxSize {
+			return nil, errors.Fatal("--exclude-smaller-than is larger than --exclude-larger-than, all files would be excluded")
+		}
+
+		fs = append(fs, rejectBySize(minSize, maxSize))
+	}
+
+	return fs, nil
+}
+
+// collectRejectByContentFuncs returns a list of all functions which may reject
+// files from being saved in a snapshot based on the start of their content
+func collectRejectByContentFuncs(opts BackupOptions) (fs []RejectByContentFunc, err error) {
+	if len(opts.ExcludeContentTypes) > 0 && !opts.Stdin {
+		f, err := rejectByContentType(opts.ExcludeContentTypes)
 		if err != nil {
 			return nil, err
 		}
@@ -342,6 +562,14 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 		}
 	}
 
+	for _, file := range opts.FilesFromRaw {
+		fromfile, err := readFilenamesFromFileRaw(file)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fromfile...)
+	}
+
 	// merge files from files-from into normal args so we can reuse the normal
 	// args checks and have the ability to use both files-from and args at the
 	// same time
@@ -359,8 +587,10 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	return targets, nil
 }
 
-// parent returns the ID of the parent snapshot. If there is none, nil is
-// returned.
+// findParentSnapshot returns the ID of the parent snapshot. If there is none,
+// nil is returned. Unless a parent is given explicitly, the last snapshot of
+// the host with the targets is used, --parent-host and --parent-path replace
+// the host and the paths to look for.
 func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string) (parentID *restic.ID, err error) {
 	// Force using a parent
 	if !opts.Force && opts.Parent != "" {
@@ -374,7 +604,19 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 
 	// Find last snapshot to set it as parent, if not already set
 	if !opts.Force && parentID == nil {
-		id, err := restic.FindLatestSnapshot(ctx, repo, targets, []restic.TagList{}, opts.Host)
+		host := opts.Host
+		if opts.ParentHost != "" {
+			host = opts.ParentHost
+		}
+
+		paths := targets
+		if len(opts.ParentPaths) > 0 {
+			paths = opts.ParentPaths
+		}
+
+		// the checkpoint of an interrupted backup is resolved to its parent
+		// by the archiver, the files saved before are not read again
+		id, err := restic.FindLatestSnapshotOrCheckpoint(ctx, repo, paths, []restic.TagList{}, host)
 		if err == nil {
 			parentID = &id
 		} else if err != restic.ErrNoSnapshotFound {
@@ -415,15 +657,30 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	if opts.SplitTrees && !repo.Config().SplitTrees {
+		return errors.Fatal("--split-trees is not enabled for this repository, enable it with `restic config --enable-split-trees`")
+	}
+
+	if opts.VerifyUploads {
+		repo.VerifyUploads()
+	}
+
+	if opts.Host == "" {
+		opts.Host = repo.Config().DefaultHost
+	}
+	if opts.Host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			debug.Log("os.Hostname() returned err: %v", err)
+		}
+		opts.Host = hostname
+	}
+
 	type ArchiveProgressReporter interface {
-		CompleteItem(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration)
-		StartFile(filename string)
-		CompleteBlob(filename string, bytes uint64)
-		ScannerError(item string, fi os.FileInfo, err error) error
-		ReportTotal(item string, s archiver.ScanStats)
+		archiver.ProgressReporter
+		archiver.ScanProgressReporter
 		SetMinUpdatePause(d time.Duration)
 		Run(ctx context.Context) error
-		Error(item string, fi os.FileInfo, err error) error
 		Finish(snapshotID restic.ID)
 
 		// ui.StdioWrapper
@@ -438,7 +695,27 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	var p ArchiveProgressReporter
-	if gopts.JSON {
+	if opts.EventSocket != "" {
+		events, err := eventsocket.Listen(opts.EventSocket)
+		if err != nil {
+			return errors.Fatalf("unable to create event socket: %v", err)
+		}
+		defer func() {
+			_ = events.Close()
+		}()
+
+		if opts.EventSocketWait {
+			Verbosef("waiting for a client to connect to %v\n", opts.EventSocket)
+			err = events.WaitForClient(gopts.ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		jp := jsonstatus.NewBackup(term, gopts.verbosity)
+		jp.SetOutput(events)
+		p = jp
+	} else if gopts.JSON {
 		p = jsonstatus.NewBackup(term, gopts.verbosity)
 	} else {
 		p = ui.NewBackup(term, gopts.verbosity)
@@ -484,6 +761,17 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	// rejectByContentFuncs collect functions that can reject files from the backup based on their content
+	rejectByContentFuncs, err := collectRejectByContentFuncs(opts)
+	if err != nil {
+		return err
+	}
+
+	followSymlinks, err := resolveFollowSymlinks(opts.FollowSymlinks)
+	if err != nil {
+		return err
+	}
+
 	if !gopts.JSON {
 		p.V("load index files")
 	}
@@ -534,26 +822,66 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		targets = []string{filename}
 	}
 
-	sc := archiver.NewScanner(targetFS)
-	sc.SelectByName = selectByNameFilter
-	sc.Select = selectFilter
-	sc.Error = p.ScannerError
-	sc.Result = p.ReportTotal
+	if opts.UseVSS {
+		vssFS := fs.NewLocalVSS(fs.NewVSSProvider(), func(volume string, err error) {
+			Warnf("unable to create shadow copy of %v, reading files from the volume: %v\n", volume, err)
+		})
+		// the shadow copies are deleted when the backup is finished or
+		// aborted, even if an error occurred
+		deleteSnapshots := func() error {
+			if err := vssFS.DeleteSnapshots(); err != nil {
+				Warnf("%v\n", err)
+			}
+			return nil
+		}
+		AddCleanupHandler(deleteSnapshots)
+		defer deleteSnapshots()
 
-	if !gopts.JSON {
-		p.V("start scan on %v", targets)
+		targetFS = vssFS
+	}
+
+	// the scan runs concurrently to the backup, the total is updated while
+	// the scan proceeds
+	if !opts.NoScan {
+		sc := archiver.NewScanner(targetFS)
+		sc.SelectByName = selectByNameFilter
+		sc.Select = selectFilter
+		sc.SetProgressReporter(p)
+
+		if !gopts.JSON {
+			p.V("start scan on %v", targets)
+		}
+		t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 	}
-	t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 
-	arch := archiver.New(repo, targetFS, archiver.Options{})
+	archiverOpts := archiver.Options{}
+	if opts.SplitTrees {
+		archiverOpts.TreeSplitThreshold = restic.DefaultTreeSplitThreshold
+	}
+
+	arch := archiver.New(repo, targetFS, archiverOpts)
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
+	if len(rejectByContentFuncs) > 0 {
+		arch.SelectByContent = func(item string, fi os.FileInfo, head []byte) bool {
+			for _, reject := range rejectByContentFuncs {
+				if reject(item, fi, head) {
+					return false
+				}
+			}
+			return true
+		}
+	}
 	arch.WithAtime = opts.WithAtime
-	arch.Error = p.Error
-	arch.CompleteItem = p.CompleteItem
-	arch.StartFile = p.StartFile
-	arch.CompleteBlob = p.CompleteBlob
+	arch.SetProgressReporter(p)
 	arch.IgnoreInode = opts.IgnoreInode
+	arch.WindowsMetadata = opts.WindowsAttributes
+	arch.StoreContentHash = opts.StoreContentHash
+	arch.MetadataOnly = opts.MetadataOnly
+	arch.SkipBindMounts = opts.SkipBindMounts
+	arch.BindMountMarker = opts.SkipBindMounts
+	arch.FollowSymlinks = followSymlinks
+	arch.Checkpoints = opts.CheckpointInterval > 0
 
 	if parentSnapshotID == nil {
 		parentSnapshotID = &restic.ID{}
@@ -562,13 +890,16 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	snapshotOpts := archiver.SnapshotOptions{
 		Excludes:       opts.Excludes,
 		Tags:           opts.Tags,
+		Description:    opts.Description,
 		Time:           timeStamp,
 		Hostname:       opts.Host,
 		ParentSnapshot: *parentSnapshotID,
+		Deterministic:  opts.Deterministic,
 	}
 
 	uploader := archiver.IndexUploader{
-		Repository: repo,
+		Repository:         repo,
+		CheckpointInterval: opts.CheckpointInterval,
 		Start: func() {
 			if !gopts.JSON {
 				p.VV("uploading intermediate index")
@@ -579,6 +910,12 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 				p.V("uploaded intermediate index %v", id.Str())
 			}
 		},
+		Checkpointer: arch,
+		CompleteCheckpoint: func(id restic.ID) {
+			if !gopts.JSON {
+				p.V("saved checkpoint snapshot %v", id.Str())
+			}
+		},
 	}
 
 	t.Go(func() error {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestReadFilenamesRaw(t *testing.T) {
+	// These should all be returned exactly as-is.
+	expected := []string{
+		"\xef\xbb\xbf/utf-8-bom",
+		"/absolute",
+		"../.././relative",
+		"\t\t leading and trailing space \t\t",
+		"newline\nin filename",
+		"not UTF-8: \x80\xff/simple",
+		` / *[]* \ `,
+	}
+
+	var buf strings.Builder
+	for _, name := range expected {
+		buf.WriteString(name)
+		buf.WriteByte(0)
+	}
+
+	got, err := readFilenamesRaw(strings.NewReader(buf.String()))
+	rtest.OK(t, err)
+	rtest.Equals(t, expected, got)
+
+	// empty input is ok
+	got, err = readFilenamesRaw(strings.NewReader(""))
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(got))
+
+	// empty filenames and missing terminators are errors
+	for _, input := range []string{"\x00", "foo\x00\x00", "foo", "foo\x00bar"} {
+		_, err = readFilenamesRaw(strings.NewReader(input))
+		rtest.Assert(t, err != nil, "no error for input %q", input)
+	}
+}
@@ -1,24 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 )
 
 var cmdCache = &cobra.Command{
-	Use:   "cache",
+	Use:   "cache [warm [snapshotID ...]]",
 	Short: "Operate on local cache directories",
 	Long: `
 The "cache" command allows listing and cleaning local cache directories.
+
+The "warm" subcommand downloads all index files of the repository and all
+packs which contain trees of the given snapshots into the cache, so that
+browsing and restoring them later does not need to fetch metadata from the
+repository. When no snapshot ID is given, the trees of all snapshots are
+downloaded. Files which are already cached are skipped.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -45,6 +57,10 @@ func init() {
 }
 
 func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 && args[0] == "warm" {
+		return runCacheWarm(gopts, args[1:])
+	}
+
 	if len(args) > 0 {
 		return errors.Fatal("the cache command has no arguments")
 	}
@@ -164,3 +180,200 @@ func dirSize(path string) (int64, error) {
 	})
 	return size, err
 }
+
+// cacheWarmWorkers is the number of trees which are loaded concurrently while
+// warming the cache.
+const cacheWarmWorkers = 8
+
+func runCacheWarm(gopts GlobalOptions, args []string) error {
+	if gopts.NoCache {
+		return errors.Fatal("Refusing to do anything, the cache is disabled")
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if repo.Cache == nil {
+		return errors.Fatal("the cache could not be opened")
+	}
+
+	lock, err := lockRepo(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	indexFiles, indexCached := 0, 0
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		indexFiles++
+		if repo.Cache.Has(restic.Handle{Type: restic.IndexFile, Name: id.String()}) {
+			indexCached++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// loading the index stores all index files in the cache
+	Verbosef("loading %d index files, %d are already cached\n", indexFiles, indexCached)
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	// remember which tree packs have been cached before
+	cachedBefore := restic.NewIDSet()
+	for blob := range repo.Index().Each(ctx) {
+		if blob.Type == restic.TreeBlob && repo.Cache.Has(restic.Handle{Type: restic.DataFile, Name: blob.PackID.String()}) {
+			cachedBefore.Insert(blob.PackID)
+		}
+	}
+
+	var trees restic.IDs
+	for sn := range FindFilteredSnapshots(ctx, repo, "", nil, nil, nil, args) {
+		trees = append(trees, *sn.Tree)
+	}
+	if len(trees) == 0 {
+		return errors.Fatal("no snapshots found")
+	}
+
+	Verbosef("loading the trees of %d snapshots\n", len(trees))
+	packs, err := findTreePacks(ctx, repo, trees)
+	if err != nil {
+		return err
+	}
+
+	// loading the trees usually caches their packs, download the remaining
+	// ones explicitly
+	var missing restic.IDs
+	for id := range packs {
+		if !repo.Cache.Has(restic.Handle{Type: restic.DataFile, Name: id.String()}) {
+			missing = append(missing, id)
+		}
+	}
+
+	if err = cachePacks(ctx, repo, missing); err != nil {
+		return err
+	}
+
+	Verbosef("cached %d tree packs, %d were already cached\n", len(packs), len(packs.Intersect(cachedBefore)))
+	return nil
+}
+
+// findTreePacks loads all trees reachable from roots and returns the IDs of
+// the packs which contain them. The trees of each level are loaded
+// concurrently.
+func findTreePacks(ctx context.Context, repo restic.Repository, roots restic.IDs) (restic.IDSet, error) {
+	seen := restic.NewIDSet()
+	var level restic.IDs
+	for _, id := range roots {
+		if !seen.Has(id) {
+			seen.Insert(id)
+			level = append(level, id)
+		}
+	}
+
+	for len(level) > 0 {
+		var (
+			m    sync.Mutex
+			next restic.IDs
+		)
+
+		ch := make(chan restic.ID)
+		wg, wctx := errgroup.WithContext(ctx)
+		wg.Go(func() error {
+			defer close(ch)
+			for _, id := range level {
+				select {
+				case ch <- id:
+				case <-wctx.Done():
+					return wctx.Err()
+				}
+			}
+			return nil
+		})
+
+		for i := 0; i < cacheWarmWorkers; i++ {
+			wg.Go(func() error {
+				for id := range ch {
+					tree, err := repo.LoadTree(wctx, id)
+					if err != nil {
+						return err
+					}
+
+					m.Lock()
+					for _, id := range tree.Chunks {
+						seen.Insert(id)
+					}
+					for _, node := range tree.Nodes {
+						if node.Subtree != nil && !seen.Has(*node.Subtree) {
+							seen.Insert(*node.Subtree)
+							next = append(next, *node.Subtree)
+						}
+					}
+					m.Unlock()
+				}
+				return nil
+			})
+		}
+
+		if err := wg.Wait(); err != nil {
+			return nil, err
+		}
+		level = next
+	}
+
+	packs := restic.NewIDSet()
+	for id := range seen {
+		blobs, found := repo.Index().Lookup(id, restic.TreeBlob)
+		if !found {
+			return nil, errors.Errorf("tree %v not found in index", id.Str())
+		}
+		for _, blob := range blobs {
+			packs.Insert(blob.PackID)
+		}
+	}
+
+	return packs, nil
+}
+
+// cachePacks downloads the packs into the cache of repo concurrently.
+func cachePacks(ctx context.Context, repo *repository.Repository, packs restic.IDs) error {
+	ch := make(chan restic.ID)
+	wg, wctx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		defer close(ch)
+		for _, id := range packs {
+			select {
+			case ch <- id:
+			case <-wctx.Done():
+				return wctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < cacheWarmWorkers; i++ {
+		wg.Go(func() error {
+			for id := range ch {
+				h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+				err := repo.Backend().Load(wctx, h, 0, 0, func(rd io.Reader) error {
+					return repo.Cache.Save(h, rd)
+				})
+				if err != nil {
+					// do not leave partial files behind
+					_ = repo.Cache.Remove(h)
+					return errors.Wrapf(err, "caching pack %v", id.Str())
+				}
+			}
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/spf13/cobra"
 
@@ -14,22 +14,48 @@ import (
 )
 
 var cmdCat = &cobra.Command{
-	Use:   "cat [flags] [pack|blob|snapshot|index|key|masterkey|config|lock] ID",
+	Use:   "cat [flags] [pack|blob|tree|snapshot|index|key|masterkey|config|lock] ID",
 	Short: "Print internal objects to stdout",
 	Long: `
-The "cat" command is used to print internal objects to stdout.
+The "cat" command is used to print internal objects to stdout. Objects are
+decrypted, metadata such as snapshots, trees and locks is printed as JSON,
+data blobs and packs are printed as raw bytes.
+
+Blobs are located using the index. With --pack, the blob is read from the
+given pack file instead, using the header of the pack file.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runCat(globalOptions, args)
+		return runCat(catOptions, globalOptions, args)
 	},
 }
 
+// CatOptions collects all options for the cat command.
+type CatOptions struct {
+	Pack string
+}
+
+var catOptions CatOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdCat)
+
+	f := cmdCat.Flags()
+	f.StringVar(&catOptions.Pack, "pack", "", "read the blob from the pack with this `ID` instead of looking it up in the index")
 }
 
-func runCat(gopts GlobalOptions, args []string) error {
+// printJSON prints item as indented JSON to stdout.
+func printJSON(item interface{}) error {
+	buf, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	Printf("%s\n", buf)
+	return nil
+}
+
+func runCat(opts CatOptions, gopts GlobalOptions, args []string) error {
 	if len(args) < 1 || (args[0] != "masterkey" && args[0] != "config" && len(args) != 2) {
 		return errors.Fatal("type or ID not specified")
 	}
@@ -63,23 +89,21 @@ func runCat(gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.Pack != "" && tpe != "blob" {
+		return errors.Fatal("--pack can only be used for blobs")
+	}
+
 	// handle all types that don't need an index
 	switch tpe {
 	case "config":
-		buf, err := json.MarshalIndent(repo.Config(), "", "  ")
-		if err != nil {
-			return err
-		}
-
-		fmt.Println(string(buf))
-		return nil
+		return printJSON(repo.Config())
 	case "index":
 		buf, err := repo.LoadAndDecrypt(gopts.ctx, nil, restic.IndexFile, id)
 		if err != nil {
 			return err
 		}
 
-		_, err = os.Stdout.Write(append(buf, '\n'))
+		_, err = globalOptions.stdout.Write(append(buf, '\n'))
 		return err
 
 	case "snapshot":
@@ -89,14 +113,7 @@ func runCat(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		buf, err := json.MarshalIndent(&sn, "", "  ")
-		if err != nil {
-			return err
-		}
-
-		fmt.Println(string(buf))
-
-		return nil
+		return printJSON(&sn)
 	case "key":
 		h := restic.Handle{Type: restic.KeyFile, Name: id.String()}
 		buf, err := backend.LoadAll(gopts.ctx, nil, repo.Backend(), h)
@@ -110,35 +127,33 @@ func runCat(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		buf, err = json.MarshalIndent(&key, "", "  ")
+		return printJSON(&key)
+	case "masterkey":
+		return printJSON(repo.Key())
+	case "lock":
+		lock, err := restic.LoadLock(gopts.ctx, repo, id)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(string(buf))
-		return nil
-	case "masterkey":
-		buf, err := json.MarshalIndent(repo.Key(), "", "  ")
-		if err != nil {
-			return err
+		return printJSON(&lock)
+	case "blob":
+		if opts.Pack == "" {
+			break
 		}
 
-		fmt.Println(string(buf))
-		return nil
-	case "lock":
-		lock, err := restic.LoadLock(gopts.ctx, repo, id)
+		packID, err := restic.ParseID(opts.Pack)
 		if err != nil {
-			return err
+			return errors.Fatalf("unable to parse pack ID: %v\n", err)
 		}
 
-		buf, err := json.MarshalIndent(&lock, "", "  ")
+		buf, err := loadBlobFromPack(gopts.ctx, repo, packID, id)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(string(buf))
-
-		return nil
+		_, err = globalOptions.stdout.Write(buf)
+		return err
 	}
 
 	// load index, handle all the other types
@@ -160,9 +175,17 @@ func runCat(gopts GlobalOptions, args []string) error {
 			fmt.Fprintf(stderr, "Warning: hash of data does not match ID, want\n  %v\ngot:\n  %v\n", id.String(), hash.String())
 		}
 
-		_, err = os.Stdout.Write(buf)
+		_, err = globalOptions.stdout.Write(buf)
 		return err
 
+	case "tree":
+		tree, err := repo.LoadTree(gopts.ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return printJSON(tree)
+
 	case "blob":
 		for _, t := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
 			list, found := repo.Index().Lookup(id, t)
@@ -178,7 +201,7 @@ func runCat(gopts GlobalOptions, args []string) error {
 			}
 			buf = buf[:n]
 
-			_, err = os.Stdout.Write(buf)
+			_, err = globalOptions.stdout.Write(buf)
 			return err
 		}
 
@@ -188,3 +211,49 @@ func runCat(gopts GlobalOptions, args []string) error {
 		return errors.Fatal("invalid type")
 	}
 }
+
+// loadBlobFromPack reads the blob id from the pack packID without using the
+// index. The blob is located using the header of the pack, decrypted and
+// its hash is verified.
+func loadBlobFromPack(ctx context.Context, repo *repository.Repository, packID, id restic.ID) ([]byte, error) {
+	h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+	fi, err := repo.Backend().Stat(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, _, err := repo.ListPack(ctx, packID, fi.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range blobs {
+		if !blob.ID.Equal(id) {
+			continue
+		}
+
+		buf := make([]byte, blob.Length)
+		n, err := restic.ReadAt(ctx, repo.Backend(), h, int64(blob.Offset), buf)
+		if err != nil {
+			return nil, err
+		}
+		if n != len(buf) {
+			return nil, errors.Errorf("pack %v is too short, want %d bytes, got %d", packID.Str(), len(buf), n)
+		}
+
+		key := repo.Key()
+		nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+		plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, repo.Config().BlobAdditionalData(id))
+		if err != nil {
+			return nil, errors.Errorf("decrypting blob %v failed: %v", id.Str(), err)
+		}
+
+		if !restic.Hash(plaintext).Equal(id) {
+			return nil, errors.Errorf("blob %v has an invalid hash", id.Str())
+		}
+
+		return plaintext, nil
+	}
+
+	return nil, errors.Fatalf("blob %v not found in pack %v", id.Str(), packID.Str())
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
@@ -37,10 +40,13 @@ repository and not use a local cache.
 
 // CheckOptions bundles all options for the 'check' command.
 type CheckOptions struct {
-	ReadData       bool
-	ReadDataSubset string
-	CheckUnused    bool
-	WithCache      bool
+	ReadData        bool
+	ReadDataSubset  string
+	ReadDataWorkers int
+	CheckUnused     bool
+	WithCache       bool
+
+	ReadDataSkipVerifiedWithin restic.Duration
 }
 
 var checkOptions CheckOptions
@@ -51,6 +57,8 @@ func init() {
 	f := cmdCheck.Flags()
 	f.BoolVar(&checkOptions.ReadData, "read-data", false, "read all data blobs")
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read subset n of m data packs (format: `n/m`)")
+	f.IntVar(&checkOptions.ReadDataWorkers, "read-data-workers", checker.DefaultReadWorkers(), "read and verify `n` data packs concurrently")
+	f.Var(&checkOptions.ReadDataSkipVerifiedWithin, "read-data-skip-verified-within", "skip reading data packs verified successfully within `duration` (eg. 1y5m7d2h)")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
 }
@@ -59,6 +67,12 @@ func checkFlags(opts CheckOptions) error {
 	if opts.ReadData && opts.ReadDataSubset != "" {
 		return errors.Fatalf("check flags --read-data and --read-data-subset cannot be used together")
 	}
+	if !opts.ReadDataSkipVerifiedWithin.Zero() && !opts.ReadData && opts.ReadDataSubset == "" {
+		return errors.Fatalf("check flag --read-data-skip-verified-within requires --read-data or --read-data-subset")
+	}
+	if opts.ReadDataWorkers < 0 {
+		return errors.Fatalf("check flag --read-data-workers must not be negative")
+	}
 	if opts.ReadDataSubset != "" {
 		dataSubset, err := stringToIntSlice(opts.ReadDataSubset)
 		if err != nil || len(dataSubset) != 2 {
@@ -167,11 +181,29 @@ func prepareCheckCache(opts CheckOptions, gopts *GlobalOptions) (cleanup func())
 	return cleanup
 }
 
+// verifiedPacksFile returns the path of the file recording verified packs for
+// the repository. It is stored in the repository's directory below the
+// cache base directory, so it must be determined before prepareCheckCache
+// replaces the cache directory with a temporary one.
+func verifiedPacksFile(cachedir string, repoID string) (string, error) {
+	if cachedir == "" {
+		var err error
+		cachedir, err = cache.DefaultDir()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(cachedir, repoID, checker.VerifiedPacksFilename), nil
+}
+
 func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	if len(args) != 0 {
 		return errors.Fatal("check has no arguments")
 	}
 
+	basedir := gopts.CacheDir
+
 	cleanup := prepareCheckCache(opts, &gopts)
 	AddCleanupHandler(func() error {
 		cleanup()
@@ -183,6 +215,9 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	// the cached listings may lack pack files, even if no lock is taken
+	disableListingCache(repo)
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(repo)
@@ -193,6 +228,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	chkr := checker.New(repo)
+	chkr.ReadWorkers = opts.ReadDataWorkers
 
 	Verbosef("load indexes\n")
 	hints, errs := chkr.LoadIndex(gopts.ctx)
@@ -206,7 +242,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	if dupFound {
-		Printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
+		Printf("This is non-critical, you can run `restic repair index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
@@ -261,16 +297,49 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	doReadData := func(bucket, totalBuckets uint) {
+		var verified *checker.VerifiedPacks
+		var verifiedFile string
+		var cutoff time.Time
+		now := time.Now()
+
+		if d := opts.ReadDataSkipVerifiedWithin; !d.Zero() {
+			cutoff = now.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Hour * time.Duration(-d.Hours))
+
+			var err error
+			verifiedFile, err = verifiedPacksFile(basedir, repo.Config().ID)
+			if err == nil {
+				verified, err = checker.LoadVerifiedPacks(verifiedFile, repo.Config().ID)
+			}
+			if err != nil {
+				Warnf("unable to load verified packs, reading all packs: %v\n", err)
+				verified = checker.NewVerifiedPacks(repo.Config().ID)
+			}
+
+			if n := verified.Prune(chkr.GetPacks()); n > 0 {
+				debug.Log("removed %d packs no longer in the repo from verified packs", n)
+			}
+		}
+
 		packs := restic.IDSet{}
+		skipped := 0
 		for pack := range chkr.GetPacks() {
 			// If we ever check more than the first byte
 			// of pack, update totalBucketsMax.
-			if (uint(pack[0]) % totalBuckets) == (bucket - 1) {
-				packs.Insert(pack)
+			if (uint(pack[0]) % totalBuckets) != (bucket - 1) {
+				continue
 			}
+			if verified != nil && verified.VerifiedSince(pack, cutoff) {
+				skipped++
+				continue
+			}
+			packs.Insert(pack)
 		}
 		packCount := uint64(len(packs))
 
+		if skipped > 0 {
+			Verbosef("skipping %d data packs verified within %v\n", skipped, opts.ReadDataSkipVerifiedWithin)
+		}
+
 		if packCount < chkr.CountPacks() {
 			Verbosef(fmt.Sprintf("read group #%d of %d data packs (out of total %d packs in %d groups)\n", bucket, packCount, chkr.CountPacks(), totalBuckets))
 		} else {
@@ -282,9 +351,29 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
 
+		failed := restic.NewIDSet()
 		for err := range errChan {
 			errorsFound = true
 			fmt.Fprintf(os.Stderr, "%v\n", err)
+			if e, ok := err.(checker.PackError); ok {
+				failed.Insert(e.ID)
+			}
+		}
+
+		// only record the result if all packs have been read
+		if verified == nil || gopts.ctx.Err() != nil {
+			return
+		}
+
+		for pack := range packs {
+			if !failed.Has(pack) {
+				verified.Mark(pack, now)
+			}
+		}
+
+		err := verified.Save(verifiedFile)
+		if err != nil {
+			Warnf("unable to save verified packs: %v\n", err)
 		}
 	}
 
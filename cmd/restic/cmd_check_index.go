@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdCheckIndex = &cobra.Command{
+	Use:   "check-index",
+	Short: "Find index entries of missing pack files and unindexed pack files",
+	Long: `
+The "check-index" command compares the index files with the pack files in the
+repository. It reports index entries which reference pack files that do not
+exist, together with the index files containing them, and pack files which are
+not referenced by any index file. If any are found, the command exits with an
+error. The repository is not modified, use "repair index" to build a new index.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCheckIndex(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdCheckIndex)
+}
+
+func runCheckIndex(gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the check-index command expects no arguments")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	// the cached listings may lack pack files, even if no lock is taken
+	disableListingCache(repo)
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	missing, unindexed, err := index.CrossReference(gopts.ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	// packs pending removal are expected to be unindexed
+	pending := 0
+	for _, pack := range unindexed {
+		if pack.PendingRemoval {
+			pending++
+		}
+	}
+
+	if gopts.JSON {
+		err = printCheckIndexJSON(gopts, missing, unindexed)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, pack := range missing {
+			Printf("pack %v is missing, referenced by %d blobs in index files %v\n",
+				pack.ID.Str(), pack.Blobs, pack.Indexes)
+		}
+		for _, pack := range unindexed {
+			if pack.PendingRemoval {
+				Verbosef("pack %v (%v) is pending removal by a later prune\n",
+					pack.ID.Str(), formatBytes(uint64(pack.Size)))
+				continue
+			}
+			Printf("pack %v (%v) is not referenced by any index\n",
+				pack.ID.Str(), formatBytes(uint64(pack.Size)))
+		}
+
+		Verbosef("%d missing packs, %d unindexed packs, %d packs pending removal\n",
+			len(missing), len(unindexed)-pending, pending)
+	}
+
+	if len(missing) > 0 || len(unindexed) > pending {
+		return errors.Fatal("the index does not match the pack files in the repository")
+	}
+
+	return nil
+}
+
+func printCheckIndexJSON(gopts GlobalOptions, missing []index.MissingPack, unindexed []index.UnindexedPack) error {
+	type missingPack struct {
+		ID      restic.ID  `json:"id"`
+		Blobs   int        `json:"blobs"`
+		Indexes restic.IDs `json:"indexes"`
+	}
+
+	type unindexedPack struct {
+		ID   restic.ID `json:"id"`
+		Size int64     `json:"size"`
+	}
+
+	result := struct {
+		MissingPacks        []missingPack   `json:"missing_packs"`
+		UnindexedPacks      []unindexedPack `json:"unindexed_packs"`
+		PendingRemovalPacks []unindexedPack `json:"pending_removal_packs"`
+	}{
+		MissingPacks:        []missingPack{},
+		UnindexedPacks:      []unindexedPack{},
+		PendingRemovalPacks: []unindexedPack{},
+	}
+
+	for _, pack := range missing {
+		result.MissingPacks = append(result.MissingPacks, missingPack{ID: pack.ID, Blobs: pack.Blobs, Indexes: pack.Indexes})
+	}
+	for _, pack := range unindexed {
+		if pack.PendingRemoval {
+			result.PendingRemovalPacks = append(result.PendingRemovalPacks, unindexedPack{ID: pack.ID, Size: pack.Size})
+			continue
+		}
+		result.UnindexedPacks = append(result.UnindexedPacks, unindexedPack{ID: pack.ID, Size: pack.Size})
+	}
+
+	return json.NewEncoder(gopts.stdout).Encode(result)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/spf13/cobra"
+)
+
+var cmdConfig = &cobra.Command{
+	Use:   "config [flags]",
+	Short: "Show or change the repository config",
+	Long: `
+The "config" command prints the settings stored in the repository config. The
+default host and the default tags for new snapshots can be changed with the
+flags below. Splitting large trees can be enabled for an existing repository,
+which upgrades it to version 2. All other settings are fixed when the
+repository is created.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfig(configOptions, globalOptions)
+	},
+}
+
+// ConfigOptions bundles all options for the config command.
+type ConfigOptions struct {
+	DefaultHost      string
+	ClearDefaultHost bool
+	DefaultTags      []string
+	ClearDefaultTags bool
+	EnableSplitTrees bool
+}
+
+var configOptions ConfigOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdConfig)
+
+	f := cmdConfig.Flags()
+	f.StringVar(&configOptions.DefaultHost, "default-host", "", "use `hostname` for all new snapshots for which --host is not given")
+	f.BoolVar(&configOptions.ClearDefaultHost, "clear-default-host", false, "remove the default hostname")
+	f.StringArrayVar(&configOptions.DefaultTags, "default-tag", nil, "replace the default tags with `tag` (can be specified multiple times)")
+	f.BoolVar(&configOptions.ClearDefaultTags, "clear-default-tags", false, "remove all default tags")
+	f.BoolVar(&configOptions.EnableSplitTrees, "enable-split-trees", false, "allow backup --split-trees, upgrades the repository to version 2 which older versions of restic refuse to open")
+}
+
+func (opts ConfigOptions) changesConfig() bool {
+	return opts.DefaultHost != "" || opts.ClearDefaultHost || len(opts.DefaultTags) > 0 || opts.ClearDefaultTags || opts.EnableSplitTrees
+}
+
+func runConfig(opts ConfigOptions, gopts GlobalOptions) error {
+	if opts.DefaultHost != "" && opts.ClearDefaultHost {
+		return errors.Fatal("--default-host and --clear-default-host cannot be used together")
+	}
+	if len(opts.DefaultTags) > 0 && opts.ClearDefaultTags {
+		return errors.Fatal("--default-tag and --clear-default-tags cannot be used together")
+	}
+
+	if opts.changesConfig() {
+		if err := checkAppendOnly(gopts, "config"); err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if opts.changesConfig() {
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		cfg := repo.Config()
+		if opts.DefaultHost != "" {
+			cfg.DefaultHost = opts.DefaultHost
+		}
+		if opts.ClearDefaultHost {
+			cfg.DefaultHost = ""
+		}
+		if len(opts.DefaultTags) > 0 {
+			cfg.DefaultTags = opts.DefaultTags
+		}
+		if opts.ClearDefaultTags {
+			cfg.DefaultTags = nil
+		}
+		if opts.EnableSplitTrees && !cfg.SplitTrees {
+			cfg.SplitTrees = true
+			if cfg.Version < cfg.RequiredVersion() {
+				Verbosef("upgrading repository from version %d to %d\n", cfg.Version, cfg.RequiredVersion())
+				cfg.Version = cfg.RequiredVersion()
+			}
+		}
+
+		err = repo.SaveConfig(gopts.ctx, cfg)
+		if err != nil {
+			return errors.Fatalf("unable to save config: %v", err)
+		}
+		Verbosef("saved repository config\n")
+	}
+
+	cfg := repo.Config()
+	if gopts.JSON {
+		return json.NewEncoder(gopts.stdout).Encode(cfg)
+	}
+
+	Printf("repository %v\n", cfg.ID)
+	Printf("  version:      %d\n", cfg.Version)
+	Printf("  split trees:  %v\n", cfg.SplitTrees)
+	Printf("  default host: %s\n", cfg.DefaultHost)
+	Printf("  default tags: %s\n", strings.Join(cfg.DefaultTags, ","))
+	return nil
+}
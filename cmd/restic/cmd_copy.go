@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var cmdCopy = &cobra.Command{
+	Use:   "copy [flags] [snapshotID ...]",
+	Short: "Copy snapshots from one repository to another",
+	Long: `
+The "copy" command copies one or more snapshots from the repository given with
+--repo to the destination repository given with --repo2. If no snapshot IDs
+are specified, all snapshots matching the filters are copied.
+
+Only the blobs which are not yet contained in the destination repository are
+transferred, data which is already present there is reused. The trees and data
+blobs keep their IDs, so the copied snapshots have the same structure as the
+original ones. Snapshots which have already been copied to the destination
+repository are skipped.
+
+With --latest, only the newest snapshot of each group of snapshots is copied,
+the snapshots are grouped by host unless --group-by is given. This can be used
+to seed a new repository with the latest state of each host. The destination
+repository contains all data referenced by the copied snapshots, so it is
+complete on its own.
+
+The data blobs are read from the source repository by --read-concurrency
+workers and saved to the destination repository by --write-concurrency
+workers. The number of blobs held in memory is bounded by the number of
+workers.
+
+The password for the destination repository is read from --password-file2,
+--password-command2, the environment variable RESTIC_PASSWORD2, or prompted
+for.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCopy(copyOptions, globalOptions, args)
+	},
+}
+
+// CopyOptions bundles all options for the copy command.
+type CopyOptions struct {
+	Repo            string
+	PasswordFile    string
+	PasswordCommand string
+	KeyHint         string
+	password        string
+
+	Host  string
+	Tags  restic.TagLists
+	Paths []string
+
+	Latest  bool
+	GroupBy string
+
+	ReadConcurrency  int
+	WriteConcurrency int
+}
+
+var copyOptions CopyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCopy)
+
+	f := cmdCopy.Flags()
+	f.StringVarP(&copyOptions.Repo, "repo2", "", os.Getenv("RESTIC_REPOSITORY2"), "destination repository to copy snapshots to (default: $RESTIC_REPOSITORY2)")
+	f.StringVarP(&copyOptions.PasswordFile, "password-file2", "", os.Getenv("RESTIC_PASSWORD_FILE2"), "read the destination repository password from a file (default: $RESTIC_PASSWORD_FILE2)")
+	f.StringVarP(&copyOptions.PasswordCommand, "password-command2", "", os.Getenv("RESTIC_PASSWORD_COMMAND2"), "specify a shell command to obtain the destination repository password (default: $RESTIC_PASSWORD_COMMAND2)")
+	f.StringVarP(&copyOptions.KeyHint, "key-hint2", "", os.Getenv("RESTIC_KEY_HINT2"), "key ID of key to try decrypting the destination repository first (default: $RESTIC_KEY_HINT2)")
+
+	f.StringVarP(&copyOptions.Host, "host", "H", "", "only consider snapshots for this `host`")
+	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	f.BoolVar(&copyOptions.Latest, "latest", false, "only copy the latest snapshot of each group")
+	f.StringVarP(&copyOptions.GroupBy, "group-by", "g", "host", "string for grouping snapshots by host,paths,tags for --latest")
+	f.IntVar(&copyOptions.ReadConcurrency, "read-concurrency", defaultCopyReadWorkers, "read `n` blobs from the source repository concurrently")
+	f.IntVar(&copyOptions.WriteConcurrency, "write-concurrency", defaultCopyWriteWorkers, "save `n` blobs to the destination repository concurrently")
+}
+
+const (
+	defaultCopyReadWorkers  = 4
+	defaultCopyWriteWorkers = 2
+)
+
+// copyStats counts the blobs transferred to the destination repository.
+type copyStats struct {
+	Blobs uint64
+	Bytes uint64
+}
+
+func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
+	if opts.Repo == "" {
+		return errors.Fatal("Please specify a destination repository location (--repo2)")
+	}
+	if opts.ReadConcurrency < 0 || opts.WriteConcurrency < 0 {
+		return errors.Fatal("--read-concurrency and --write-concurrency must not be negative")
+	}
+
+	dstGopts := gopts
+	dstGopts.Repo = opts.Repo
+	dstGopts.PasswordFile = opts.PasswordFile
+	dstGopts.PasswordCommand = opts.PasswordCommand
+	dstGopts.KeyHint = opts.KeyHint
+
+	dstGopts.password = opts.password
+	if dstGopts.password == "" {
+		var err error
+		dstGopts.password, err = resolvePassword(dstGopts, "RESTIC_PASSWORD2")
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	srcRepo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := OpenRepository(dstGopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		srcLock, err := lockRepo(srcRepo)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	dstLock, err := lockRepo(dstRepo)
+	defer unlockRepo(dstLock)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("loading source index")
+	if err := srcRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	debug.Log("loading destination index")
+	if err := dstRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	dstSnapshots, err := restic.LoadAllSnapshots(ctx, dstRepo)
+	if err != nil {
+		return err
+	}
+
+	c := newSnapshotCopier(srcRepo, dstRepo, opts.ReadConcurrency, opts.WriteConcurrency)
+
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Host, opts.Tags, opts.Paths, nil, args) {
+		snapshots = append(snapshots, sn)
+	}
+
+	if opts.Latest {
+		snapshots, err = latestSnapshots(snapshots, opts.GroupBy)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sn := range snapshots {
+		Verbosef("snapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if dup := findCopiedSnapshot(sn, dstSnapshots); dup != nil {
+			Verbosef("  skipping, already copied as snapshot %s\n", dup.ID().Str())
+			continue
+		}
+
+		before := c.stats
+		if err := c.copySnapshotTree(ctx, *sn.Tree); err != nil {
+			return err
+		}
+
+		// make sure all blobs are stored before the snapshot references them
+		if err := dstRepo.Flush(ctx); err != nil {
+			return err
+		}
+		if err := dstRepo.SaveIndex(ctx); err != nil {
+			return err
+		}
+
+		newSn := *sn
+		if sn.Original == nil {
+			newSn.Original = sn.ID()
+		}
+		id, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, newSn)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("  copied as snapshot %s, transferred %d new blobs (%s)\n",
+			id.Str(), c.stats.Blobs-before.Blobs, formatBytes(c.stats.Bytes-before.Bytes))
+	}
+
+	return ctx.Err()
+}
+
+// latestSnapshots groups the snapshots according to groupBy and returns the
+// newest snapshot of each group, checkpoint snapshots are ignored. The newest
+// snapshots are listed first.
+func latestSnapshots(snapshots restic.Snapshots, groupBy string) (restic.Snapshots, error) {
+	// checkpoints of interrupted backups are incomplete
+	var complete restic.Snapshots
+	for _, sn := range snapshots {
+		if !sn.Checkpoint {
+			complete = append(complete, sn)
+		}
+	}
+
+	groups, _, err := restic.GroupSnapshots(complete, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest restic.Snapshots
+	for _, group := range groups {
+		newest := group[0]
+		for _, sn := range group[1:] {
+			if sn.Time.After(newest.Time) {
+				newest = sn
+			}
+		}
+		latest = append(latest, newest)
+	}
+
+	sort.Sort(latest)
+	return latest, nil
+}
+
+// findCopiedSnapshot returns the snapshot in dstSnapshots which is a copy of
+// sn, or nil if sn has not been copied yet.
+func findCopiedSnapshot(sn *restic.Snapshot, dstSnapshots restic.Snapshots) *restic.Snapshot {
+	original := sn.ID()
+	if sn.Original != nil {
+		original = sn.Original
+	}
+
+	for _, dst := range dstSnapshots {
+		if dst.Original != nil && dst.Original.Equal(*original) {
+			return dst
+		}
+	}
+
+	return nil
+}
+
+// snapshotCopier copies trees and data blobs between two repositories. The
+// trees are traversed by a single goroutine, which also decides which data
+// blobs need to be copied. The data blobs are loaded and saved by worker
+// pools.
+type snapshotCopier struct {
+	src, dst *repository.Repository
+
+	readWorkers, writeWorkers int
+
+	// visited contains all trees which have been processed
+	visited restic.IDSet
+	// copied contains the blobs saved to dst which may not be in its index yet
+	copied restic.BlobSet
+
+	buf []byte
+
+	m     sync.Mutex
+	stats copyStats
+}
+
+// loadedBlob is a data blob which has been read from the source repository.
+type loadedBlob struct {
+	id  restic.ID
+	buf []byte
+}
+
+// newSnapshotCopier returns a copier for the repositories. If the number of
+// read or write workers is zero, the default is used.
+func newSnapshotCopier(src, dst *repository.Repository, readWorkers, writeWorkers int) *snapshotCopier {
+	if readWorkers == 0 {
+		readWorkers = defaultCopyReadWorkers
+	}
+	if writeWorkers == 0 {
+		writeWorkers = defaultCopyWriteWorkers
+	}
+
+	return &snapshotCopier{
+		src:          src,
+		dst:          dst,
+		readWorkers:  readWorkers,
+		writeWorkers: writeWorkers,
+		visited:      restic.NewIDSet(),
+		copied:       restic.NewBlobSet(),
+	}
+}
+
+// copySnapshotTree copies the tree with the given ID and everything it
+// references to the destination repository. When it returns, all blobs have
+// been passed to the destination repository, which still needs to be flushed.
+func (c *snapshotCopier) copySnapshotTree(ctx context.Context, treeID restic.ID) error {
+	wg, wctx := errgroup.WithContext(ctx)
+
+	// buffers are passed from the readers to the writers and back, so at
+	// most len(bufs) blobs are held in memory
+	bufs := make(chan []byte, c.readWorkers+2*c.writeWorkers)
+	for i := 0; i < cap(bufs); i++ {
+		bufs <- nil
+	}
+
+	jobs := make(chan restic.ID)
+	loaded := make(chan loadedBlob, c.writeWorkers)
+
+	wg.Go(func() error {
+		defer close(jobs)
+		return c.copyTree(wctx, treeID, jobs)
+	})
+
+	var readers sync.WaitGroup
+	for i := 0; i < c.readWorkers; i++ {
+		readers.Add(1)
+		wg.Go(func() error {
+			defer readers.Done()
+			for id := range jobs {
+				var buf []byte
+				select {
+				case buf = <-bufs:
+				case <-wctx.Done():
+					return wctx.Err()
+				}
+
+				buf, err := c.loadBlob(wctx, restic.DataBlob, id, buf)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case loaded <- loadedBlob{id: id, buf: buf}:
+				case <-wctx.Done():
+					return wctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		readers.Wait()
+		close(loaded)
+		return nil
+	})
+
+	for i := 0; i < c.writeWorkers; i++ {
+		wg.Go(func() error {
+			for blob := range loaded {
+				if err := c.saveBlob(wctx, restic.DataBlob, blob.id, blob.buf); err != nil {
+					return err
+				}
+				bufs <- blob.buf
+			}
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+// copyTree copies the tree with the given ID and the trees it references,
+// except for the blobs which are already stored in the destination. The IDs
+// of the data blobs which need to be copied are sent to jobs.
+func (c *snapshotCopier) copyTree(ctx context.Context, treeID restic.ID, jobs chan<- restic.ID) error {
+	if c.visited.Has(treeID) {
+		return nil
+	}
+
+	h := restic.BlobHandle{ID: treeID, Type: restic.TreeBlob}
+	buf, err := c.loadBlob(ctx, restic.TreeBlob, treeID, c.buf)
+	if err != nil {
+		return err
+	}
+	c.buf = buf
+
+	tree := &restic.Tree{}
+	if err := json.Unmarshal(buf, tree); err != nil {
+		return errors.Wrapf(err, "unable to decode tree %v", treeID.Str())
+	}
+
+	// older versions of restic cannot read split trees, so the destination
+	// must allow them
+	if len(tree.Chunks) > 0 && !c.dst.Config().SplitTrees {
+		return errors.Errorf("tree %v is split into chunks, which is not enabled for the destination repository", treeID.Str())
+	}
+
+	if !c.exists(h) {
+		if err := c.saveBlob(ctx, restic.TreeBlob, treeID, buf); err != nil {
+			return err
+		}
+		c.copied.Insert(h)
+	}
+
+	// the chunks of a split tree are copied like subtrees
+	for _, id := range tree.Chunks {
+		if err := c.copyTree(ctx, id, jobs); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range tree.Nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch {
+		case node.Type == "dir" && node.Subtree != nil:
+			if err := c.copyTree(ctx, *node.Subtree, jobs); err != nil {
+				return err
+			}
+		case node.Type == "file":
+			for _, id := range node.Content {
+				h := restic.BlobHandle{ID: id, Type: restic.DataBlob}
+				if c.exists(h) {
+					continue
+				}
+				c.copied.Insert(h)
+
+				select {
+				case jobs <- id:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+
+	c.visited.Insert(treeID)
+	return nil
+}
+
+// exists returns true if the blob is already stored in the destination
+// repository or has been scheduled to be copied.
+func (c *snapshotCopier) exists(h restic.BlobHandle) bool {
+	return c.copied.Has(h) || c.dst.Index().Has(h.ID, h.Type)
+}
+
+// loadBlob loads the blob from the source repository into buf, which is
+// grown as needed, and returns the plaintext.
+func (c *snapshotCopier) loadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
+	size, found := c.src.LookupBlobSize(id, t)
+	if !found {
+		return nil, errors.Errorf("%v blob %v not found in source repository", t, id.Str())
+	}
+
+	if cap(buf) < restic.CiphertextLength(int(size)) {
+		buf = restic.NewBlobBuffer(int(size))
+	}
+
+	n, err := c.src.LoadBlob(ctx, t, id, buf[:cap(buf)])
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// saveBlob saves the blob to the destination repository.
+func (c *snapshotCopier) saveBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) error {
+	debug.Log("copying %v blob %v", t, id.Str())
+	_, err := c.dst.SaveBlob(ctx, t, buf, id)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	c.stats.Blobs++
+	c.stats.Bytes += uint64(len(buf))
+	c.m.Unlock()
+
+	return nil
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"path"
 	"reflect"
 	"sort"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -26,6 +30,11 @@ directory:
 * U  The metadata (access mode, timestamps, ...) for the item was updated
 * M  The file's content was modified
 * T  The type was changed, e.g. a file was made a symlink
+
+With --json, one JSON object is printed for each changed item, followed by an
+object containing the statistics. The field "change" is one of "added",
+"removed", "modified", "type-changed" or "metadata". Changes of the metadata
+only are reported when --metadata is given.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -58,15 +67,122 @@ func loadSnapshot(ctx context.Context, repo *repository.Repository, desc string)
 
 // Comparer collects all things needed to compare two snapshots.
 type Comparer struct {
-	repo restic.Repository
-	opts DiffOptions
+	repo        restic.Repository
+	opts        DiffOptions
+	printChange func(change *Change)
+}
+
+// Change describes a changed item, it is printed for each item with --json.
+type Change struct {
+	MessageType string       `json:"message_type"` // "change"
+	Path        string       `json:"path"`
+	Modifier    string       `json:"modifier"`
+	Change      string       `json:"change"`
+	Type        string       `json:"type"`
+	SizeDelta   int64        `json:"size_delta"`
+	Mode        *ModeChange  `json:"mode,omitempty"`
+	Owner       *OwnerChange `json:"owner,omitempty"`
+	ModTime     *TimeChange  `json:"mtime,omitempty"`
+}
+
+// ModeChange records the old and new access mode of an item.
+type ModeChange struct {
+	Old os.FileMode `json:"old"`
+	New os.FileMode `json:"new"`
+}
+
+// OwnerChange records the old and new owner of an item.
+type OwnerChange struct {
+	OldUID uint32 `json:"old_uid"`
+	NewUID uint32 `json:"new_uid"`
+	OldGID uint32 `json:"old_gid"`
+	NewGID uint32 `json:"new_gid"`
+}
+
+// TimeChange records the old and new modification time of an item.
+type TimeChange struct {
+	Old time.Time `json:"old"`
+	New time.Time `json:"new"`
+}
+
+// changeNames maps the modifiers printed in the text output to the change
+// reported in the JSON output.
+var changeNames = map[string]string{
+	"+": "added",
+	"-": "removed",
+	"M": "modified",
+	"T": "type-changed",
+	"U": "metadata",
+}
+
+// sameMetadata returns true if node1 and node2 only differ in their content,
+// changes within a directory are not changes of the directory's metadata.
+func sameMetadata(node1, node2 *restic.Node) bool {
+	n1, n2 := *node1, *node2
+	n1.Content, n2.Content = nil, nil
+	n1.ContentHash, n2.ContentHash = "", ""
+	n1.Subtree, n2.Subtree = nil, nil
+	return n1.Equals(n2)
+}
+
+// fileSize returns the size of node if it is a file, and zero otherwise.
+func fileSize(node *restic.Node) int64 {
+	if node == nil || node.Type != "file" {
+		return 0
+	}
+	return int64(node.Size)
+}
+
+// newChange describes the change from node1 to node2, either of them may be
+// nil if the item was added or removed.
+func newChange(mod, name string, node1, node2 *restic.Node) *Change {
+	change := &Change{
+		MessageType: "change",
+		Path:        name,
+		Modifier:    mod,
+		Change:      changeNames[mod[:1]],
+		SizeDelta:   fileSize(node2) - fileSize(node1),
+	}
+
+	switch {
+	case node2 != nil:
+		change.Type = node2.Type
+	case node1 != nil:
+		change.Type = node1.Type
+	}
+
+	if node1 == nil || node2 == nil {
+		return change
+	}
+
+	if node1.Mode != node2.Mode {
+		change.Mode = &ModeChange{Old: node1.Mode, New: node2.Mode}
+	}
+
+	if node1.UID != node2.UID || node1.GID != node2.GID {
+		change.Owner = &OwnerChange{
+			OldUID: node1.UID,
+			NewUID: node2.UID,
+			OldGID: node1.GID,
+			NewGID: node2.GID,
+		}
+	}
+
+	if !node1.ModTime.Equal(node2.ModTime) {
+		change.ModTime = &TimeChange{Old: node1.ModTime, New: node2.ModTime}
+	}
+
+	return change
 }
 
 // DiffStat collects stats for all types of items.
 type DiffStat struct {
-	Files, Dirs, Others  int
-	DataBlobs, TreeBlobs int
-	Bytes                uint64
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Others    int    `json:"others"`
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	Bytes     uint64 `json:"bytes"`
 }
 
 // Add adds stats information for node to s.
@@ -111,15 +227,17 @@ func addBlobs(bs restic.BlobSet, node *restic.Node) {
 
 // DiffStats collects the differences between two snapshots.
 type DiffStats struct {
-	ChangedFiles            int
-	Added                   DiffStat
-	Removed                 DiffStat
-	BlobsBefore, BlobsAfter restic.BlobSet
+	MessageType             string         `json:"message_type"` // "statistics"
+	ChangedFiles            int            `json:"changed_files"`
+	Added                   DiffStat       `json:"added"`
+	Removed                 DiffStat       `json:"removed"`
+	BlobsBefore, BlobsAfter restic.BlobSet `json:"-"`
 }
 
 // NewDiffStats creates new stats for a diff run.
 func NewDiffStats() *DiffStats {
 	return &DiffStats{
+		MessageType: "statistics",
 		BlobsBefore: restic.NewBlobSet(),
 		BlobsAfter:  restic.NewBlobSet(),
 	}
@@ -157,7 +275,11 @@ func (c *Comparer) printDir(ctx context.Context, mode string, stats *DiffStat, b
 		if node.Type == "dir" {
 			name += "/"
 		}
-		Printf("%-5s%v\n", mode, name)
+		if mode == "-" {
+			c.printChange(newChange(mode, name, node, nil))
+		} else {
+			c.printChange(newChange(mode, name, nil, node))
+		}
 		stats.Add(node)
 		addBlobs(blobs, node)
 
@@ -234,12 +356,12 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 				!reflect.DeepEqual(node1.Content, node2.Content) {
 				mod += "M"
 				stats.ChangedFiles++
-			} else if c.opts.ShowMetadata && !node1.Equals(*node2) {
+			} else if c.opts.ShowMetadata && !sameMetadata(node1, node2) {
 				mod += "U"
 			}
 
 			if mod != "" {
-				Printf("%-5s%v\n", mod, name)
+				c.printChange(newChange(mod, name, node1, node2))
 			}
 
 			if node1.Type == "dir" && node2.Type == "dir" {
@@ -253,7 +375,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node1.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "-", prefix)
+			c.printChange(newChange("-", prefix, node1, nil))
 			stats.Removed.Add(node1)
 
 			if node1.Type == "dir" {
@@ -267,7 +389,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node2.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "+", prefix)
+			c.printChange(newChange("+", prefix, nil, node2))
 			stats.Added.Add(node2)
 
 			if node2.Type == "dir" {
@@ -317,7 +439,9 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
-	Verbosef("comparing snapshot %v to %v:\n\n", sn1.ID().Str(), sn2.ID().Str())
+	if !gopts.JSON {
+		Verbosef("comparing snapshot %v to %v:\n\n", sn1.ID().Str(), sn2.ID().Str())
+	}
 
 	if sn1.Tree == nil {
 		return errors.Errorf("snapshot %v has nil tree", sn1.ID().Str())
@@ -329,7 +453,22 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 
 	c := &Comparer{
 		repo: repo,
-		opts: diffOptions,
+		opts: opts,
+	}
+
+	var enc *json.Encoder
+	if gopts.JSON {
+		enc = json.NewEncoder(gopts.stdout)
+		c.printChange = func(change *Change) {
+			err := enc.Encode(change)
+			if err != nil {
+				Warnf("JSON encode failed: %v\n", err)
+			}
+		}
+	} else {
+		c.printChange = func(change *Change) {
+			Printf("%-5s%v\n", change.Modifier, change.Path)
+		}
 	}
 
 	stats := NewDiffStats()
@@ -343,6 +482,14 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 	updateBlobs(repo, stats.BlobsBefore.Sub(both), &stats.Removed)
 	updateBlobs(repo, stats.BlobsAfter.Sub(both), &stats.Added)
 
+	if gopts.JSON {
+		err = enc.Encode(stats)
+		if err != nil {
+			return fmt.Errorf("encoding output: %v", err)
+		}
+		return nil
+	}
+
 	Printf("\n")
 	Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
 	Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
@@ -1,19 +1,16 @@
 package main
 
 import (
-	"archive/tar"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dump"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
-	"github.com/restic/restic/internal/walker"
 
 	"github.com/spf13/cobra"
 )
@@ -22,8 +19,14 @@ var cmdDump = &cobra.Command{
 	Use:   "dump [flags] snapshotID file",
 	Short: "Print a backed-up file to stdout",
 	Long: `
-The "dump" command extracts a single file from a snapshot from the repository and
-prints its contents to stdout.
+The "dump" command extracts files from a snapshot from the repository. If a
+single file is selected, it prints its contents to stdout. Folders are output
+as a tar (default) or zip file containing the contents of the specified
+folder. Pass "/" as file name to dump the whole snapshot as an archive file.
+
+With --image, the selected folder or file is written to a file system image
+instead, the folder becomes the root of the image. Squashfs images are built
+by piping the contents into "sqfstar", which must be installed.
 
 The special snapshot "latest" can be used to use the latest snapshot in the
 repository.
@@ -36,9 +39,12 @@ repository.
 
 // DumpOptions collects all options for the dump command.
 type DumpOptions struct {
-	Host  string
-	Paths []string
-	Tags  restic.TagLists
+	Host        string
+	Paths       []string
+	Tags        restic.TagLists
+	Archive     string
+	Image       string
+	ImageFormat string
 }
 
 var dumpOptions DumpOptions
@@ -50,6 +56,9 @@ func init() {
 	flags.StringVarP(&dumpOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
 	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` as \"tar\" or \"zip\"")
+	flags.StringVar(&dumpOptions.Image, "image", "", "write a file system image to `file` instead of an archive to stdout")
+	flags.StringVar(&dumpOptions.ImageFormat, "image-format", "squashfs", "set image `format`, only \"squashfs\" is supported")
 }
 
 func splitPath(p string) []string {
@@ -64,7 +73,7 @@ func splitPath(p string) []string {
 	return append(s, f)
 }
 
-func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string) error {
+func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string, opts DumpOptions) error {
 
 	if tree == nil {
 		return fmt.Errorf("called with a nil tree")
@@ -79,18 +88,26 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 	item := filepath.Join(prefix, pathComponents[0])
 	for _, node := range tree.Nodes {
 		if node.Name == pathComponents[0] || pathComponents[0] == "/" {
+			nodePath := path.Join(prefix, node.Name)
 			switch {
-			case l == 1 && node.Type == "file":
-				return getNodeData(ctx, os.Stdout, repo, node)
+			case l == 1 && node.Type == "file" && opts.Image == "":
+				return dump.GetNodeData(ctx, os.Stdout, repo, node)
 			case l > 1 && node.Type == "dir":
 				subtree, err := repo.LoadTree(ctx, *node.Subtree)
 				if err != nil {
 					return errors.Wrapf(err, "cannot load subtree for %q", item)
 				}
-				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], pathToPrint)
+				return printFromTree(ctx, subtree, repo, nodePath, pathComponents[1:], pathToPrint, opts)
+			case l == 1 && node.Type == "file":
+				return dumpImage(ctx, repo, &restic.Tree{Nodes: []*restic.Node{node}}, opts)
+			case node.Type == "dir" && opts.Image != "":
+				subtree, err := repo.LoadTree(ctx, *node.Subtree)
+				if err != nil {
+					return errors.Wrapf(err, "cannot load subtree for %q", item)
+				}
+				return dumpImage(ctx, repo, subtree, opts)
 			case node.Type == "dir":
-				node.Path = pathToPrint
-				return tarTree(ctx, repo, node, pathToPrint)
+				return dumpTree(ctx, repo, &restic.Tree{Nodes: []*restic.Node{node}}, path.Dir(nodePath), opts.Archive)
 			case l > 1:
 				return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
 			case node.Type != "file":
@@ -108,6 +125,16 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("no file and no snapshot ID specified")
 	}
 
+	switch opts.Archive {
+	case "tar", "zip":
+	default:
+		return errors.Fatalf("unknown archive format %q", opts.Archive)
+	}
+
+	if opts.Image != "" && opts.ImageFormat != "squashfs" {
+		return errors.Fatalf("unknown image format %q", opts.ImageFormat)
+	}
+
 	snapshotIDString := args[0]
 	pathToPrint := args[1]
 
@@ -152,12 +179,23 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "loading snapshot %q failed: %v", snapshotIDString, err)
 	}
 
+	if sn.MetadataOnly {
+		return errors.Fatalf("snapshot %v contains only metadata, the content of its files cannot be dumped", sn.ID().Str())
+	}
+
 	tree, err := repo.LoadTree(ctx, *sn.Tree)
 	if err != nil {
 		Exitf(2, "loading tree for snapshot %q failed: %v", snapshotIDString, err)
 	}
 
-	err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint)
+	switch {
+	case path.Clean(pathToPrint) == "/" && opts.Image != "":
+		err = dumpImage(ctx, repo, tree, opts)
+	case path.Clean(pathToPrint) == "/":
+		err = dumpTree(ctx, repo, tree, "/", opts.Archive)
+	default:
+		err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint, opts)
+	}
 	if err != nil {
 		Exitf(2, "cannot dump file: %v", err)
 	}
@@ -165,135 +203,30 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
-func getNodeData(ctx context.Context, output io.Writer, repo restic.Repository, node *restic.Node) error {
-	var buf []byte
-	for _, id := range node.Content {
-
-		size, found := repo.LookupBlobSize(id, restic.DataBlob)
-		if !found {
-			return errors.Errorf("id %v not found in repository", id)
-		}
-
-		buf = buf[:cap(buf)]
-		if len(buf) < restic.CiphertextLength(int(size)) {
-			buf = restic.NewBlobBuffer(int(size))
-		}
-
-		n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
-		if err != nil {
-			return err
-		}
-		buf = buf[:n]
-
-		_, err = output.Write(buf)
-		if err != nil {
-			return errors.Wrap(err, "Write")
-		}
-
-	}
-	return nil
-}
-
-func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string) error {
-
+// dumpTree writes the nodes in tree and everything below them as an archive
+// in the given format to stdout.
+func dumpTree(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, archive string) error {
 	if stdoutIsTerminal() {
 		return fmt.Errorf("stdout is the terminal, please redirect output")
 	}
 
-	tw := tar.NewWriter(os.Stdout)
-	defer tw.Close()
-
-	// If we want to dump "/" we'll need to add the name of the first node, too
-	// as it would get lost otherwise.
-	if rootNode.Path == "/" {
-		rootNode.Path = path.Join(rootNode.Path, rootNode.Name)
-		rootPath = rootNode.Path
-	}
-
-	// we know that rootNode is a folder and walker.Walk will already process
-	// the next node, so we have to tar this one first, too
-	if err := tarNode(ctx, tw, rootNode, repo); err != nil {
-		return err
+	if archive == "zip" {
+		return dump.WriteZip(ctx, repo, tree, rootPath, os.Stdout)
 	}
-
-	err := walker.Walk(ctx, repo, *rootNode.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
-		if err != nil {
-			return false, err
-		}
-		if node == nil {
-			return false, nil
-		}
-
-		node.Path = path.Join(rootPath, nodepath)
-
-		if node.Type == "file" || node.Type == "symlink" || node.Type == "dir" {
-			err := tarNode(ctx, tw, node, repo)
-			if err != err {
-				return false, err
-			}
-		}
-
-		return false, nil
-	})
-
-	return err
+	return dump.WriteTar(ctx, repo, tree, rootPath, os.Stdout)
 }
 
-func tarNode(ctx context.Context, tw *tar.Writer, node *restic.Node, repo restic.Repository) error {
-
-	header := &tar.Header{
-		Name:       node.Path,
-		Size:       int64(node.Size),
-		Mode:       int64(node.Mode),
-		Uid:        int(node.UID),
-		Gid:        int(node.GID),
-		ModTime:    node.ModTime,
-		AccessTime: node.AccessTime,
-		ChangeTime: node.ChangeTime,
-		PAXRecords: parseXattrs(node.ExtendedAttributes),
-	}
-
-	if node.Type == "symlink" {
-		header.Typeflag = tar.TypeSymlink
-		header.Linkname = node.LinkTarget
-	}
-
-	if node.Type == "dir" {
-		header.Typeflag = tar.TypeDir
-	}
-
-	err := tw.WriteHeader(header)
-
+// dumpImage writes the nodes in tree and everything below them to the file
+// system image opts.Image, the nodes are placed in the root of the image.
+func dumpImage(ctx context.Context, repo restic.Repository, tree *restic.Tree, opts DumpOptions) error {
+	sink, err := dump.NewSquashfsImageSink(opts.Image)
 	if err != nil {
-		return errors.Wrap(err, "TarHeader ")
+		return err
 	}
 
-	return getNodeData(ctx, tw, repo, node)
-
-}
-
-func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
-	tmpMap := make(map[string]string)
-
-	for _, attr := range xattrs {
-		attrString := string(attr.Value)
-
-		if strings.HasPrefix(attr.Name, "system.posix_acl_") {
-			na := acl{}
-			na.decode(attr.Value)
-
-			if na.String() != "" {
-				if strings.Contains(attr.Name, "system.posix_acl_access") {
-					tmpMap["SCHILY.acl.access"] = na.String()
-				} else if strings.Contains(attr.Name, "system.posix_acl_default") {
-					tmpMap["SCHILY.acl.default"] = na.String()
-				}
-			}
-
-		} else {
-			tmpMap["SCHILY.xattr."+attr.Name] = attrString
-		}
+	err = dump.WriteImage(ctx, repo, tree, "/", sink)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
 	}
-
-	return tmpMap
+	return err
 }
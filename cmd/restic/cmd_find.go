@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -21,13 +23,18 @@ var cmdFind = &cobra.Command{
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
-It can also be used to search for restic blobs or trees for troubleshooting.`,
+It can also be used to search for restic blobs or trees for troubleshooting.
+
+With --content, the patterns are local files. Their content is split into
+blobs in the same way as during backup, and all files in the snapshots with
+exactly the same list of blobs are reported.`,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
 restic find --json --blob 420f620f b46ebe8a ddd38656
 restic find --show-pack-id --blob 420f620f
 restic find --tree 577c2bc9 f81f2e22 a62827a9
-restic find --pack 025c1d06`,
+restic find --pack 025c1d06
+restic find --content /home/user/work/report.pdf`,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runFind(findOptions, globalOptions, args)
@@ -43,11 +50,13 @@ type FindOptions struct {
 	Snapshots          []string
 	BlobID, TreeID     bool
 	PackID, ShowPackID bool
+	Content            bool
 	CaseInsensitive    bool
 	ListLong           bool
 	Host               string
 	Paths              []string
 	Tags               restic.TagLists
+	Filter             string
 }
 
 var findOptions FindOptions
@@ -62,6 +71,7 @@ func init() {
 	f.BoolVar(&findOptions.BlobID, "blob", false, "pattern is a blob-ID")
 	f.BoolVar(&findOptions.TreeID, "tree", false, "pattern is a tree-ID")
 	f.BoolVar(&findOptions.PackID, "pack", false, "pattern is a pack-ID")
+	f.BoolVar(&findOptions.Content, "content", false, "pattern is a local file, find files with the same content")
 	f.BoolVar(&findOptions.ShowPackID, "show-pack-id", false, "display the pack-ID the blobs belong to (with --blob or --tree)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
@@ -69,6 +79,7 @@ func init() {
 	f.StringVarP(&findOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
 	f.Var(&findOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	f.StringArrayVar(&findOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+	f.StringVar(&findOptions.Filter, "filter", "", "only consider snapshots matching the filter `expression`, when no snapshot-ID is given")
 }
 
 type findPattern struct {
@@ -247,9 +258,66 @@ type Finder struct {
 	ignoreTrees restic.IDSet
 	blobIDs     map[string]struct{}
 	treeIDs     map[string]struct{}
+	contents    map[string]string
 	itemsFound  int
 }
 
+// contentKey returns a string which identifies the content ids of a file.
+func contentKey(ids restic.IDs) string {
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.Write(id[:])
+	}
+	return sb.String()
+}
+
+// fileContent returns the IDs of the data blobs of the file filename, split
+// into chunks as configured in cfg like during backup.
+func fileContent(filename string, cfg restic.Config) (restic.IDs, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to open %v: %v", filename, err)
+	}
+	defer f.Close()
+
+	ids := restic.IDs{}
+	chnker := cfg.NewChunker(f)
+	min, _ := cfg.ChunkerBoundaries()
+	buf := make([]byte, min)
+	for {
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Fatalf("unable to read %v: %v", filename, err)
+		}
+
+		ids = append(ids, restic.Hash(chunk.Data))
+		buf = chunk.Data
+	}
+
+	return ids, nil
+}
+
+// contentsFromFiles returns a map from the content key of each file to its
+// name.
+func contentsFromFiles(files []string, cfg restic.Config) (map[string]string, error) {
+	contents := make(map[string]string)
+	for _, filename := range files {
+		ids, err := fileContent(filename, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		debug.Log("file %v consists of %d blobs", filename, len(ids))
+		contents[contentKey(ids)] = filename
+	}
+
+	return contents, nil
+}
+
 func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error {
 	debug.Log("searching in snapshot %s\n  for entries within [%s %s]", sn.ID(), f.pat.oldest, f.pat.newest)
 
@@ -392,6 +460,12 @@ func (f *Finder) findIDs(ctx context.Context, sn *restic.Snapshot) error {
 			}
 		}
 
+		if node.Type == "file" && f.contents != nil {
+			if filename, ok := f.contents[contentKey(node.Content)]; ok {
+				f.out.PrintObject("file", filename, nodepath, parentTreeID.String(), sn)
+			}
+		}
+
 		return false, nil
 	})
 }
@@ -488,8 +562,12 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("wrong number of arguments")
 	}
 
-	var err error
-	pat := findPattern{pattern: args}
+	snapshotFilter, err := parseSnapshotFilter(opts.Filter)
+	if err != nil {
+		return err
+	}
+
+	pat := findPattern{pattern: append([]string(nil), args...)}
 	if opts.CaseInsensitive {
 		for i := range pat.pattern {
 			pat.pattern[i] = strings.ToLower(pat.pattern[i])
@@ -513,7 +591,8 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 	// can't mix types
 	if (opts.BlobID && opts.TreeID) ||
 		(opts.BlobID && opts.PackID) ||
-		(opts.TreeID && opts.PackID) {
+		(opts.TreeID && opts.PackID) ||
+		(opts.Content && (opts.BlobID || opts.TreeID || opts.PackID)) {
 		return errors.Fatal("cannot have several ID types")
 	}
 
@@ -560,9 +639,15 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 	if opts.PackID {
 		f.packsToBlobs(ctx, []string{f.pat.pattern[0]}) // TODO: support multiple packs
 	}
+	if opts.Content {
+		f.contents, err = contentsFromFiles(args, repo.Config())
+		if err != nil {
+			return err
+		}
+	}
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, opts.Snapshots) {
-		if f.blobIDs != nil || f.treeIDs != nil {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, snapshotFilter, opts.Snapshots) {
+		if f.blobIDs != nil || f.treeIDs != nil || f.contents != nil {
 			if err = f.findIDs(ctx, sn); err != nil && err.Error() != "OK" {
 				return err
 			}
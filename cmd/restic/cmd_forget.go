@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	"github.com/spf13/cobra"
 )
@@ -31,12 +32,18 @@ type ForgetOptions struct {
 	Weekly   int
 	Monthly  int
 	Yearly   int
+	Weekday  int
 	Within   restic.Duration
 	KeepTags restic.TagLists
 
+	// KeepSelect is "first" or "last", it selects the snapshot which is kept
+	// for each hour, day, week, month and year
+	KeepSelect string
+
 	Host    string
 	Tags    restic.TagLists
 	Paths   []string
+	Filter  string
 	Compact bool
 
 	// Grouping
@@ -57,6 +64,8 @@ func init() {
 	f.IntVarP(&forgetOptions.Weekly, "keep-weekly", "w", 0, "keep the last `n` weekly snapshots")
 	f.IntVarP(&forgetOptions.Monthly, "keep-monthly", "m", 0, "keep the last `n` monthly snapshots")
 	f.IntVarP(&forgetOptions.Yearly, "keep-yearly", "y", 0, "keep the last `n` yearly snapshots")
+	f.IntVar(&forgetOptions.Weekday, "keep-weekday", 0, "keep the last `n` daily snapshots for each day of the week")
+	f.StringVar(&forgetOptions.KeepSelect, "keep-select", "last", "keep the `first` or last snapshot of each hour, day, week, month and year")
 	f.VarP(&forgetOptions.Within, "keep-within", "", "keep snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
 
 	f.Var(&forgetOptions.KeepTags, "keep-tag", "keep snapshots with this `taglist` (can be specified multiple times)")
@@ -67,6 +76,7 @@ func init() {
 	f.Var(&forgetOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
 
 	f.StringArrayVar(&forgetOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
+	f.StringVar(&forgetOptions.Filter, "filter", "", "only consider snapshots matching the filter `expression`, e.g. \"tag:daily AND NOT host:web1\"")
 	f.BoolVarP(&forgetOptions.Compact, "compact", "c", false, "use compact format")
 
 	f.StringVarP(&forgetOptions.GroupBy, "group-by", "g", "host,paths", "string for grouping snapshots by host,paths,tags")
@@ -77,6 +87,25 @@ func init() {
 }
 
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	if !opts.DryRun {
+		if err := checkAppendOnly(gopts, "forget"); err != nil {
+			return err
+		}
+	}
+
+	snapshotFilter, err := parseSnapshotFilter(opts.Filter)
+	if err != nil {
+		return err
+	}
+
+	keepSelect := restic.SelectLast
+	if opts.KeepSelect != "" {
+		keepSelect, err = restic.ParseBucketSelection(opts.KeepSelect)
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -95,13 +124,21 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 
 	var snapshots restic.Snapshots
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, snapshotFilter, args) {
 		snapshots = append(snapshots, sn)
 	}
 
 	if len(args) > 0 {
 		// When explicit snapshots args are given, remove them immediately.
 		for _, sn := range snapshots {
+			// snapshots with one of the tags from --keep-tag are never removed
+			if len(opts.KeepTags) > 0 && sn.HasTagList(opts.KeepTags) {
+				if !gopts.JSON {
+					Verbosef("not removing snapshot %v, it has tags %v\n", sn.ID().Str(), sn.Tags)
+				}
+				continue
+			}
+
 			if !opts.DryRun {
 				h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 				if err = repo.Backend().Remove(gopts.ctx, h); err != nil {
@@ -130,8 +167,10 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 			Weekly:  opts.Weekly,
 			Monthly: opts.Monthly,
 			Yearly:  opts.Yearly,
+			Weekday: opts.Weekday,
 			Within:  opts.Within,
 			Tags:    opts.KeepTags,
+			Select:  keepSelect,
 		}
 
 		if policy.Empty() && len(args) == 0 {
@@ -212,7 +251,8 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("%d snapshots have been removed, running prune\n", removeSnapshots)
 		}
 		if !opts.DryRun {
-			return pruneRepository(gopts, repo)
+			_, err = pruneRepository(gopts, PruneOptions{}, repo)
+			return err
 		}
 	}
 
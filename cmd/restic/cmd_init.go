@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
 )
@@ -12,22 +13,85 @@ var cmdInit = &cobra.Command{
 	Short: "Initialize a new repository",
 	Long: `
 The "init" command initializes a new repository.
+
+The option --chunker-avg-size sets the average size of the chunks files are
+split into for all backups to the repository. It must be a power of two
+between 64K and 8M, the default is 1M. The minimal and maximal chunk sizes
+scale accordingly. Smaller chunks improve deduplication for small or
+frequently changing files, larger chunks reduce the size of the index for
+large files. The setting cannot be changed after the repository has been
+created.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit(globalOptions, args)
+		return runInit(initOptions, globalOptions, args)
 	},
 }
 
+// InitOptions bundles all options for the init command.
+type InitOptions struct {
+	ChunkerAverageSize  string
+	PackSize            string
+	AuthenticateBlobIDs bool
+	SplitTrees          bool
+	DefaultHost         string
+	DefaultTags         []string
+	KDF                 KDFOptions
+}
+
+var initOptions InitOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdInit)
+
+	f := cmdInit.Flags()
+	f.StringVar(&initOptions.ChunkerAverageSize, "chunker-avg-size", "", "average `size` of data chunks, e.g. 512K or 4M (default: 1M)")
+	f.StringVar(&initOptions.PackSize, "pack-size", "", "target `size` of pack files, between 4M and 128M (default: 4M)")
+	f.BoolVar(&initOptions.AuthenticateBlobIDs, "authenticate-blob-ids", false, "bind each encrypted blob to its ID, creates a repository with version 2 which older versions of restic refuse to open")
+	f.BoolVar(&initOptions.SplitTrees, "split-trees", false, "allow backup --split-trees, creates a repository with version 2 which older versions of restic refuse to open")
+	f.StringVar(&initOptions.DefaultHost, "default-host", "", "use `hostname` for all new snapshots for which --host is not given")
+	f.StringArrayVar(&initOptions.DefaultTags, "default-tag", nil, "add `tag` to all new snapshots (can be specified multiple times)")
+	addKDFFlags(cmdInit, &initOptions.KDF)
 }
 
-func runInit(gopts GlobalOptions, args []string) error {
+func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	if gopts.Repo == "" {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
+	var chunkerAverageSize uint
+	if opts.ChunkerAverageSize != "" {
+		size, err := parseSizeStr(opts.ChunkerAverageSize)
+		if err != nil {
+			return errors.Fatalf("invalid --chunker-avg-size: %v", err)
+		}
+
+		err = restic.CheckChunkerAverageSize(uint(size))
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+		chunkerAverageSize = uint(size)
+	}
+
+	var packSize uint
+	if opts.PackSize != "" {
+		size, err := parseSizeStr(opts.PackSize)
+		if err != nil {
+			return errors.Fatalf("invalid --pack-size: %v", err)
+		}
+
+		err = restic.CheckPackSize(uint(size))
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+		packSize = uint(size)
+	}
+
+	keyOpts, err := opts.KDF.keyOptions()
+	if err != nil {
+		return err
+	}
+
 	be, err := create(gopts.Repo, gopts.extended)
 	if err != nil {
 		return errors.Fatalf("create repository at %s failed: %v\n", gopts.Repo, err)
@@ -42,7 +106,15 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	s := repository.New(be)
 
-	err = s.Init(gopts.ctx, gopts.password)
+	err = s.Init(gopts.ctx, gopts.password, repository.InitOptions{
+		ChunkerAverageSize:  chunkerAverageSize,
+		PackSize:            packSize,
+		AuthenticateBlobIDs: opts.AuthenticateBlobIDs,
+		SplitTrees:          opts.SplitTrees,
+		DefaultHost:         opts.DefaultHost,
+		DefaultTags:         opts.DefaultTags,
+		Key:                 keyOpts,
+	})
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", gopts.Repo, err)
 	}
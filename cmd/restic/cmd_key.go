@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -16,10 +17,21 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [list|add|remove|passwd] [ID]",
+	Use:   "key [list|add|remove|passwd|rotate] [ID]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
+
+The "rotate" subcommand replaces the master key of the repository, which is
+used to encrypt all data, by a new random key. All files in the repository
+are rewritten using the new key, and a new key is added for the password that
+is asked for. All other keys are removed afterwards, as they can only decrypt
+the old master key. When the rotation is interrupted, the repository cannot be
+used until the rotation is resumed by running "key rotate" again.
+
+New keys are protected with the key derivation function scrypt by default.
+Pass --kdf argon2id to use Argon2id instead, its parameters can be tuned with
+the --argon2-* options. Existing keys keep working regardless of their KDF.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -27,13 +39,58 @@ The "key" command manages keys (passwords) for accessing the repository.
 	},
 }
 
-var newPasswordFile string
+// KDFOptions collects the options selecting the key derivation function for
+// new keys.
+type KDFOptions struct {
+	KDF           string
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+var (
+	newPasswordFile string
+	keyKDFOptions   KDFOptions
+)
 
 func init() {
 	cmdRoot.AddCommand(cmdKey)
 
 	flags := cmdKey.Flags()
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "the file from which to load a new password")
+	addKDFFlags(cmdKey, &keyKDFOptions)
+}
+
+// addKDFFlags adds the flags for the key derivation function to cmd.
+func addKDFFlags(cmd *cobra.Command, opts *KDFOptions) {
+	flags := cmd.Flags()
+	flags.StringVar(&opts.KDF, "kdf", crypto.KDFScrypt, "key derivation `function` for new keys (scrypt, argon2id)")
+	flags.Uint32Var(&opts.Argon2Time, "argon2-time", crypto.DefaultArgon2Params.Time, "number of iterations for Argon2id")
+	flags.Uint32Var(&opts.Argon2Memory, "argon2-memory", crypto.DefaultArgon2Params.Memory/1024, "memory in `MiB` used by Argon2id")
+	flags.Uint8Var(&opts.Argon2Threads, "argon2-parallelism", crypto.DefaultArgon2Params.Threads, "number of threads used by Argon2id")
+}
+
+// keyOptions checks the options and returns the key options for the
+// repository. An empty KDF selects scrypt.
+func (opts KDFOptions) keyOptions() (repository.KeyOptions, error) {
+	switch opts.KDF {
+	case "", crypto.KDFScrypt:
+		return repository.KeyOptions{KDF: crypto.KDFScrypt}, nil
+	case crypto.KDFArgon2id:
+		params := crypto.Argon2Params{
+			Time:    opts.Argon2Time,
+			Memory:  opts.Argon2Memory * 1024,
+			Threads: opts.Argon2Threads,
+		}
+
+		if err := params.Check(); err != nil {
+			return repository.KeyOptions{}, errors.Fatal(err.Error())
+		}
+
+		return repository.KeyOptions{KDF: crypto.KDFArgon2id, Argon2Params: params}, nil
+	default:
+		return repository.KeyOptions{}, errors.Fatalf("unknown KDF %q, must be scrypt or argon2id", opts.KDF)
+	}
 }
 
 func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
@@ -109,13 +166,13 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 		"enter password again: ")
 }
 
-func addKey(gopts GlobalOptions, repo *repository.Repository) error {
+func addKey(gopts GlobalOptions, repo *repository.Repository, keyOpts repository.KeyOptions) error {
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key())
+	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key(), keyOpts)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -140,13 +197,13 @@ func deleteKey(ctx context.Context, repo *repository.Repository, name string) er
 	return nil
 }
 
-func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
+func changePassword(gopts GlobalOptions, repo *repository.Repository, keyOpts repository.KeyOptions) error {
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key())
+	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key(), keyOpts)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -162,11 +219,58 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 	return nil
 }
 
+func rotateKey(gopts GlobalOptions, repo *repository.Repository, keyOpts repository.KeyOptions) error {
+	ctx := gopts.ctx
+
+	pw, err := getNewPassword(gopts)
+	if err != nil {
+		return err
+	}
+
+	kr, err := repository.NewKeyRotation(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if kr.Resumed() {
+		Verbosef("resuming interrupted key rotation\n")
+	}
+
+	packs := kr.Packs()
+	Verbosef("rewriting %d packs with the new master key\n", len(packs))
+	err = kr.RewritePacks(ctx, packs, newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs rewritten"))
+	if err != nil {
+		return err
+	}
+
+	Verbosef("rewriting snapshots and removing old files\n")
+	key, err := kr.Finish(ctx, pw, keyOpts)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("saved new key as %s, all other keys have been removed\n", key)
+
+	return nil
+}
+
 func runKey(gopts GlobalOptions, args []string) error {
 	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
 		return errors.Fatal("wrong number of arguments")
 	}
 
+	switch args[0] {
+	case "remove", "passwd", "rotate":
+		if err := checkAppendOnly(gopts, "key "+args[0]); err != nil {
+			return err
+		}
+	}
+
+	keyOpts, err := keyKDFOptions.keyOptions()
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
@@ -191,7 +295,7 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		return addKey(gopts, repo)
+		return addKey(gopts, repo, keyOpts)
 	case "remove":
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
@@ -212,7 +316,15 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		return changePassword(gopts, repo)
+		return changePassword(gopts, repo, keyOpts)
+	case "rotate":
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return rotateKey(gopts, repo, keyOpts)
 	}
 
 	return nil
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/table"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdLocks = &cobra.Command{
+	Use:   "locks",
+	Short: "List and remove locks",
+	Long: `
+The "locks" command contains subcommands to inspect and remove the locks in
+the repository.
+`,
+	DisableAutoGenTag: true,
+}
+
+var cmdLocksList = &cobra.Command{
+	Use:   "list",
+	Short: "List all locks",
+	Long: `
+The "locks list" command prints the locks in the repository, together with the
+host and process which created them, whether they are stale and exclusive and
+their scope, if any. The repository is not modified.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLocksList(globalOptions, args)
+	},
+}
+
+var cmdLocksRemove = &cobra.Command{
+	Use:   "remove [flags] [ID...]",
+	Short: "Remove locks",
+	Long: `
+The "locks remove" command removes the locks with the given IDs. Locks which
+are not stale are only removed with --force, as the process holding them may
+still be running. Instead of IDs, --stale removes all stale locks and --all
+removes all locks.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLocksRemove(locksRemoveOptions, globalOptions, args)
+	},
+}
+
+// LocksRemoveOptions collects all options for the locks remove command.
+type LocksRemoveOptions struct {
+	Stale bool
+	All   bool
+	Force bool
+}
+
+var locksRemoveOptions LocksRemoveOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdLocks)
+	cmdLocks.AddCommand(cmdLocksList)
+	cmdLocks.AddCommand(cmdLocksRemove)
+
+	f := cmdLocksRemove.Flags()
+	f.BoolVar(&locksRemoveOptions.Stale, "stale", false, "remove all stale locks")
+	f.BoolVar(&locksRemoveOptions.All, "all", false, "remove all locks, even non-stale ones")
+	f.BoolVarP(&locksRemoveOptions.Force, "force", "f", false, "remove the given locks even if they are not stale")
+}
+
+func runLocksList(gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the locks list command expects no arguments")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	locks, err := restic.ListLocks(gopts.ctx, repo, lockOptions(gopts))
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(locks, func(i, j int) bool {
+		return locks[i].Time.Before(locks[j].Time)
+	})
+
+	if gopts.JSON {
+		return printLocksJSON(gopts, locks)
+	}
+	return printLocks(gopts, locks)
+}
+
+func printLocks(gopts GlobalOptions, locks []restic.LockInfo) error {
+	type lockInfo struct {
+		ID        string
+		Host      string
+		PID       string
+		Time      string
+		Exclusive string
+		Stale     string
+		Scope     string
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	tab := table.New()
+	tab.AddColumn("ID", "{{ .ID }}")
+	tab.AddColumn("Host", "{{ .Host }}")
+	tab.AddColumn("PID", "{{ .PID }}")
+	tab.AddColumn("Time", "{{ .Time }}")
+	tab.AddColumn("Exclusive", "{{ .Exclusive }}")
+	tab.AddColumn("Stale", "{{ .Stale }}")
+	tab.AddColumn("Scope", "{{ .Scope }}")
+
+	for _, lock := range locks {
+		if lock.Err != nil {
+			Warnf("unable to load lock %v: %v\n", lock.ID.Str(), lock.Err)
+			continue
+		}
+		if lock.FutureDated {
+			Warnf("lock %v is dated %v in the future, the clock of host %v is probably wrong\n",
+				lock.ID.Str(), time.Until(lock.Time).Round(time.Second), lock.Hostname)
+		}
+
+		tab.AddRow(lockInfo{
+			ID:        lock.ID.Str(),
+			Host:      lock.Hostname,
+			PID:       fmt.Sprintf("%d", lock.PID),
+			Time:      lock.Time.Format(TimeFormat),
+			Exclusive: yesNo(lock.Exclusive),
+			Stale:     yesNo(lock.Stale),
+			Scope:     lock.Scope,
+		})
+	}
+
+	return tab.Write(gopts.stdout)
+}
+
+func printLocksJSON(gopts GlobalOptions, locks []restic.LockInfo) error {
+	type lockInfo struct {
+		ID        restic.ID  `json:"id"`
+		Time      *time.Time `json:"time,omitempty"`
+		Hostname  string     `json:"hostname,omitempty"`
+		PID       int        `json:"pid,omitempty"`
+		Exclusive bool       `json:"exclusive"`
+		Stale     bool       `json:"stale"`
+		Scope     string     `json:"scope,omitempty"`
+		Future    bool       `json:"future_dated,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}
+
+	result := []lockInfo{}
+	for _, lock := range locks {
+		info := lockInfo{ID: lock.ID}
+		if lock.Err != nil {
+			info.Error = lock.Err.Error()
+		} else {
+			t := lock.Time
+			info.Time = &t
+			info.Hostname = lock.Hostname
+			info.PID = lock.PID
+			info.Exclusive = lock.Exclusive
+			info.Stale = lock.Stale
+			info.Scope = lock.Scope
+			info.Future = lock.FutureDated
+		}
+		result = append(result, info)
+	}
+
+	return json.NewEncoder(gopts.stdout).Encode(result)
+}
+
+func runLocksRemove(opts LocksRemoveOptions, gopts GlobalOptions, args []string) error {
+	switch {
+	case opts.Stale && opts.All:
+		return errors.Fatal("--stale and --all cannot be used together")
+	case (opts.Stale || opts.All) && len(args) > 0:
+		return errors.Fatal("lock IDs cannot be given together with --stale or --all")
+	case !opts.Stale && !opts.All && len(args) == 0:
+		return errors.Fatal("no lock IDs given, use --stale or --all to remove several locks")
+	}
+
+	if err := checkAppendOnly(gopts, "locks remove"); err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if opts.All {
+		err = restic.RemoveAllLocks(gopts.ctx, repo)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("successfully removed locks\n")
+		return nil
+	}
+
+	if opts.Stale {
+		removed, err := restic.RemoveStaleLocks(gopts.ctx, repo, lockOptions(gopts))
+		if err != nil {
+			return err
+		}
+
+		Verbosef("removed %d stale locks\n", len(removed))
+		return nil
+	}
+
+	failed := false
+	for _, arg := range args {
+		err := removeLock(gopts, repo, arg, opts.Force)
+		if errors.Cause(err) == restic.ErrLockNotStale {
+			Warnf("%v, use --force to remove it anyway\n", err)
+			failed = true
+			continue
+		}
+		if err != nil {
+			Warnf("unable to remove lock %v: %v\n", arg, err)
+			failed = true
+			continue
+		}
+
+		Verbosef("removed lock %v\n", arg)
+	}
+
+	if failed {
+		return errors.Fatal("not all locks could be removed")
+	}
+	return nil
+}
+
+// removeLock removes the lock with the ID or ID prefix arg.
+func removeLock(gopts GlobalOptions, repo restic.Repository, arg string, force bool) error {
+	name, err := restic.Find(repo.Backend(), restic.LockFile, arg)
+	if err != nil {
+		return err
+	}
+
+	id, err := restic.ParseID(name)
+	if err != nil {
+		return err
+	}
+
+	return restic.RemoveLock(gopts.ctx, repo, id, force, lockOptions(gopts))
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
@@ -33,6 +36,17 @@ will be listed. If the --recursive flag is used, then the filter
 will allow traversing into matching directories' subfolders.
 Any directory paths specified must be absolute (starting with
 a path separator); paths use the forward slash '/' as separator.
+
+The --pattern option only lists files and directories whose path
+matches the pattern, it can be specified multiple times. Patterns
+use the same syntax as for --exclude of the "backup" command.
+
+With --json and --recursive, each snapshot is printed as a single
+JSON document which contains the listed files and directories as
+nested tree in the field "nodes". Directories which contain listed
+nodes are included in the tree, even if they do not match themselves.
+Subtrees are only loaded from the repository when they can contain
+listed nodes.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -47,6 +61,7 @@ type LsOptions struct {
 	Tags      restic.TagLists
 	Paths     []string
 	Recursive bool
+	Patterns  []string
 }
 
 var lsOptions LsOptions
@@ -60,6 +75,7 @@ func init() {
 	flags.Var(&lsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
 	flags.StringArrayVar(&lsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
 	flags.BoolVar(&lsOptions.Recursive, "recursive", false, "include files in subfolders of the listed directories")
+	flags.StringArrayVar(&lsOptions.Patterns, "pattern", nil, "only list files and directories matching `pattern` (can be specified multiple times)")
 }
 
 type lsSnapshot struct {
@@ -83,6 +99,113 @@ type lsNode struct {
 	StructType string      `json:"struct_type"` // "node"
 }
 
+func newLsNode(path string, node *restic.Node) lsNode {
+	return lsNode{
+		Name:       node.Name,
+		Type:       node.Type,
+		Path:       path,
+		UID:        node.UID,
+		GID:        node.GID,
+		Size:       node.Size,
+		Mode:       node.Mode,
+		ModTime:    node.ModTime,
+		AccessTime: node.AccessTime,
+		ChangeTime: node.ChangeTime,
+		StructType: "node",
+	}
+}
+
+// lsTreeNode is a node in the nested JSON output of ls.
+type lsTreeNode struct {
+	lsNode
+	Children []*lsTreeNode `json:"children,omitempty"`
+}
+
+// lsSnapshotTree is printed for each snapshot in the nested JSON output of ls.
+type lsSnapshotTree struct {
+	lsSnapshot
+	Nodes []*lsTreeNode `json:"nodes"`
+}
+
+// lsMatchFunc returns whether the node at path is listed, and for dir nodes
+// whether the walk should descend into the subtree.
+type lsMatchFunc func(path string, node *restic.Node) (list bool, descend bool, err error)
+
+// lsWalk calls fn for all nodes in the tree id which are listed according to
+// match. In contrast to walker.Walk, a subtree is only loaded when the walk
+// descends into it.
+func lsWalk(ctx context.Context, repo walker.TreeLoader, prefix string, id restic.ID, match lsMatchFunc, fn func(path string, node *restic.Node) error) error {
+	tree, err := repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range sortedNodes(tree) {
+		p := path.Join(prefix, node.Name)
+		list, descend, err := match(p, node)
+		if err != nil {
+			return err
+		}
+
+		if list {
+			if err = fn(p, node); err != nil {
+				return err
+			}
+		}
+
+		if descend {
+			if err = lsWalk(ctx, repo, p, *node.Subtree, match, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// lsBuildTree returns the nodes in the tree id which are listed according to
+// match as nested tree. Dirs which are not listed are included if they
+// contain listed nodes. Subtrees are only loaded when the walk descends into
+// them.
+func lsBuildTree(ctx context.Context, repo walker.TreeLoader, prefix string, id restic.ID, match lsMatchFunc) ([]*lsTreeNode, error) {
+	tree, err := repo.LoadTree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*lsTreeNode{}
+	for _, node := range sortedNodes(tree) {
+		p := path.Join(prefix, node.Name)
+		list, descend, err := match(p, node)
+		if err != nil {
+			return nil, err
+		}
+
+		var children []*lsTreeNode
+		if descend {
+			children, err = lsBuildTree(ctx, repo, p, *node.Subtree, match)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if list || len(children) > 0 {
+			nodes = append(nodes, &lsTreeNode{lsNode: newLsNode(p, node), Children: children})
+		}
+	}
+
+	return nodes, nil
+}
+
+// sortedNodes returns the nodes of tree sorted by name.
+func sortedNodes(tree *restic.Tree) []*restic.Node {
+	nodes := append([]*restic.Node{}, tree.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
 func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 	if len(args) == 0 && opts.Host == "" && len(opts.Tags) == 0 && len(opts.Paths) == 0 {
 		return errors.Fatal("Invalid arguments, either give one or more snapshot IDs or set filters.")
@@ -133,6 +256,30 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 		return false
 	}
 
+	match := func(nodepath string, node *restic.Node) (list bool, descend bool, err error) {
+		list, childMayMatch := true, true
+		if len(opts.Patterns) > 0 {
+			list, childMayMatch, err = filter.List(opts.Patterns, nodepath)
+			if err != nil {
+				return false, false, err
+			}
+		}
+
+		within := withinDir(nodepath)
+		list = list && within
+
+		if node.Type == "dir" {
+			// if recursive listing is requested, descend into the listed
+			// dirs, and into all dirs which lead to one of the listed dirs
+			descend = childMayMatch && ((within && opts.Recursive) || approachingMatchingTree(nodepath))
+			if descend && node.Subtree == nil {
+				return false, false, errors.Errorf("subtree for node %v is nil", nodepath)
+			}
+		}
+
+		return list, descend, nil
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -145,9 +292,36 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	snapshots := FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, nil, args[:1])
+
+	if gopts.JSON && opts.Recursive {
+		enc := json.NewEncoder(gopts.stdout)
+		for sn := range snapshots {
+			nodes, err := lsBuildTree(ctx, repo, "/", *sn.Tree, match)
+			if err != nil {
+				return err
+			}
+
+			err = enc.Encode(lsSnapshotTree{
+				lsSnapshot: lsSnapshot{
+					Snapshot:   sn,
+					ID:         sn.ID(),
+					ShortID:    sn.ID().Str(),
+					StructType: "snapshot",
+				},
+				Nodes: nodes,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	var (
 		printSnapshot func(sn *restic.Snapshot)
-		printNode     func(path string, node *restic.Node)
+		printNode     func(path string, node *restic.Node) error
 	)
 
 	if gopts.JSON {
@@ -162,66 +336,23 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 			})
 		}
 
-		printNode = func(path string, node *restic.Node) {
-			enc.Encode(lsNode{
-				Name:       node.Name,
-				Type:       node.Type,
-				Path:       path,
-				UID:        node.UID,
-				GID:        node.GID,
-				Size:       node.Size,
-				Mode:       node.Mode,
-				ModTime:    node.ModTime,
-				AccessTime: node.AccessTime,
-				ChangeTime: node.ChangeTime,
-				StructType: "node",
-			})
+		printNode = func(path string, node *restic.Node) error {
+			return enc.Encode(newLsNode(path, node))
 		}
 	} else {
 		printSnapshot = func(sn *restic.Snapshot) {
 			Verbosef("snapshot %s of %v filtered by %v at %s):\n", sn.ID().Str(), sn.Paths, dirs, sn.Time)
 		}
-		printNode = func(path string, node *restic.Node) {
-			Printf("%s\n", formatNode(path, node, lsOptions.ListLong))
+		printNode = func(path string, node *restic.Node) error {
+			Printf("%s\n", formatNode(path, node, opts.ListLong))
+			return nil
 		}
 	}
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args[:1]) {
+	for sn := range snapshots {
 		printSnapshot(sn)
 
-		err := walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
-			if err != nil {
-				return false, err
-			}
-			if node == nil {
-				return false, nil
-			}
-
-			if withinDir(nodepath) {
-				// if we're within a dir, print the node
-				printNode(nodepath, node)
-
-				// if recursive listing is requested, signal the walker that it
-				// should continue walking recursively
-				if opts.Recursive {
-					return false, nil
-				}
-			}
-
-			// if there's an upcoming match deeper in the tree (but we're not
-			// there yet), signal the walker to descend into any subdirs
-			if approachingMatchingTree(nodepath) {
-				return false, nil
-			}
-
-			// otherwise, signal the walker to not walk recursively into any
-			// subdirs
-			if node.Type == "dir" {
-				return false, walker.SkipNode
-			}
-			return false, nil
-		})
-
+		err := lsWalk(ctx, repo, "/", *sn.Tree, match, printNode)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// lsTestLoader is an in-memory tree loader which records the loaded trees.
+type lsTestLoader struct {
+	trees  map[restic.ID]*restic.Tree
+	loaded restic.IDSet
+}
+
+func (l *lsTestLoader) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	tree, ok := l.trees[id]
+	if !ok {
+		return nil, errors.Errorf("tree %v not found", id.Str())
+	}
+	l.loaded.Insert(id)
+	return tree, nil
+}
+
+func (l *lsTestLoader) add(nodes ...*restic.Node) restic.ID {
+	id := restic.NewRandomID()
+	l.trees[id] = &restic.Tree{Nodes: nodes}
+	return id
+}
+
+func lsTestMatch(patterns []string) lsMatchFunc {
+	return func(nodepath string, node *restic.Node) (bool, bool, error) {
+		if len(patterns) == 0 {
+			return true, node.Type == "dir", nil
+		}
+		list, childMayMatch, err := filter.List(patterns, nodepath)
+		return list, node.Type == "dir" && childMayMatch, err
+	}
+}
+
+// lsTreePaths returns the paths of all nodes in the nested tree, each dir is
+// followed by its children.
+func lsTreePaths(nodes []*lsTreeNode) (paths []string) {
+	for _, node := range nodes {
+		paths = append(paths, node.Path)
+		paths = append(paths, lsTreePaths(node.Children)...)
+	}
+	return paths
+}
+
+func TestLsTree(t *testing.T) {
+	loader := &lsTestLoader{trees: make(map[restic.ID]*restic.Tree)}
+	a := loader.add(
+		&restic.Node{Name: "y.txt", Type: "file"},
+		&restic.Node{Name: "x.go", Type: "file"},
+	)
+	b := loader.add(&restic.Node{Name: "z.txt", Type: "file"})
+	root := loader.add(
+		&restic.Node{Name: "c.txt", Type: "file"},
+		&restic.Node{Name: "b", Type: "dir", Subtree: &b},
+		&restic.Node{Name: "a", Type: "dir", Subtree: &a},
+	)
+
+	var tests = []struct {
+		patterns []string
+		paths    []string
+		loaded   restic.IDSet
+	}{
+		{
+			nil,
+			[]string{"/a", "/a/x.go", "/a/y.txt", "/b", "/b/z.txt", "/c.txt"},
+			restic.NewIDSet(root, a, b),
+		},
+		{
+			[]string{"*.go"},
+			[]string{"/a", "/a/x.go"},
+			restic.NewIDSet(root, a, b),
+		},
+		{
+			// subtree b cannot contain matching nodes and is not loaded
+			[]string{"/a/*.go"},
+			[]string{"/a", "/a/x.go"},
+			restic.NewIDSet(root, a),
+		},
+		{
+			// like for excludes, a pattern matching a dir matches its content
+			[]string{"/b", "/c.txt"},
+			[]string{"/b", "/b/z.txt", "/c.txt"},
+			restic.NewIDSet(root, b),
+		},
+		{
+			[]string{"*.md"},
+			nil,
+			restic.NewIDSet(root, a, b),
+		},
+	}
+
+	for _, test := range tests {
+		loader.loaded = restic.NewIDSet()
+		nodes, err := lsBuildTree(context.TODO(), loader, "/", root, lsTestMatch(test.patterns))
+		rtest.OK(t, err)
+		rtest.Equals(t, test.paths, lsTreePaths(nodes))
+		rtest.Assert(t, loader.loaded.Equals(test.loaded),
+			"patterns %v: wrong trees loaded: %v", test.patterns, loader.loaded)
+
+		// the nested tree contains the dirs leading to the listed nodes
+		var listed []string
+		err = lsWalk(context.TODO(), loader, "/", root, lsTestMatch(test.patterns), func(path string, node *restic.Node) error {
+			listed = append(listed, path)
+			return nil
+		})
+		rtest.OK(t, err)
+		for _, p := range listed {
+			found := false
+			for _, q := range test.paths {
+				found = found || p == q
+			}
+			rtest.Assert(t, found, "patterns %v: listed path %v is missing in the tree", test.patterns, p)
+		}
+	}
+}
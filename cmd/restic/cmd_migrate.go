@@ -89,6 +89,12 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 }
 
 func runMigrate(opts MigrateOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		if err := checkAppendOnly(gopts, "migrate"); err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
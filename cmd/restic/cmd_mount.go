@@ -44,6 +44,16 @@ You need to specify a sample format for exactly the following timestamp:
 
 For details please see the documentation for time.Format() at:
   https://godoc.org/time#Time.Format
+
+The template can also describe a directory hierarchy for the "snapshots"
+directory, using the placeholders {id}, {host}, {tags} and {time}. The format
+for the timestamp can be set with {time:format}, e.g. to group the snapshots by
+host and tags:
+
+    --snapshot-template "{host}/{tags}/{time:2006-01-02_15-04-05}"
+
+When several snapshots map to the same directory, a suffix is added to the
+names of the newer ones.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -170,7 +180,7 @@ func runMount(opts MountOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("snapshot template string cannot be empty")
 	}
 
-	if strings.ContainsAny(opts.SnapshotTemplate, `\/`) {
+	if !fuse.IsPathTemplate(opts.SnapshotTemplate) && strings.ContainsAny(opts.SnapshotTemplate, `\/`) {
 		return errors.Fatal("snapshot template string contains a slash (/) or backslash (\\) character")
 	}
 
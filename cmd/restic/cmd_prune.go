@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -19,15 +20,33 @@ var cmdPrune = &cobra.Command{
 	Long: `
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
+
+With "--fast", only pack files which do not contain any referenced data are
+removed. Packs which are partially used are kept as they are instead of being
+rewritten, so less space is freed, but prune is much faster.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	DryRun      bool
+	KeepPackAge time.Duration
+	Fast        bool
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+
+	f := cmdPrune.Flags()
+	f.BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	f.BoolVar(&pruneOptions.Fast, "fast", false, "only remove packs which do not contain any used blobs, do not rewrite partially used packs")
+	f.DurationVar(&pruneOptions.KeepPackAge, "keep-pack-age", 0, "never remove or rewrite packs which were modified less than `duration` (e.g. 48h) ago (default: disabled)")
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -70,19 +89,33 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 	return p
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	if !opts.DryRun {
+		if err := checkAppendOnly(gopts, "prune"); err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(repo)
+	// a dry run does not modify the repository, so a non-exclusive lock is
+	// sufficient
+	lockFn := lockRepoExclusive
+	if opts.DryRun {
+		lockFn = lockRepo
+	}
+
+	lock, err := lockFn(repo)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
 	}
 
-	return pruneRepository(gopts, repo)
+	_, err = pruneRepository(gopts, opts, repo)
+	return err
 }
 
 func mixedBlobs(list []restic.Blob) bool {
@@ -104,12 +137,60 @@ func mixedBlobs(list []restic.Blob) bool {
 	return false
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+// PrunePlan describes the changes prune makes to the repository.
+type PrunePlan struct {
+	// RewritePacks contains packs which contain both used and unused blobs,
+	// the used blobs are repacked and the packs are deleted afterwards.
+	RewritePacks restic.IDSet
+	// RemovePacks contains packs which do not contain any used blobs and
+	// invalid pack files, they are deleted.
+	RemovePacks restic.IDSet
+	// KeepPacks contains packs which are not modified.
+	KeepPacks restic.IDSet
+	// RemoveBytes is the number of bytes freed by removing unused and
+	// duplicate blobs.
+	RemoveBytes uint64
+
+	usedBlobs restic.BlobSet
+}
+
+// pruneRepository computes which packs need to be rewritten or removed and
+// executes the plan. When opts.DryRun is set, the repository is not
+// modified and only the plan is returned.
+func pruneRepository(gopts GlobalOptions, opts PruneOptions, repo restic.Repository) (*PrunePlan, error) {
+	plan, err := planPrune(gopts, opts, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
+		len(plan.RemovePacks), len(plan.RewritePacks), formatBytes(plan.RemoveBytes))
+
+	if opts.DryRun {
+		if gopts.verbosity >= 2 {
+			for _, id := range plan.RewritePacks.List() {
+				Printf("would rewrite pack %v\n", id.Str())
+			}
+			for _, id := range plan.RemovePacks.List() {
+				Printf("would remove pack %v\n", id.Str())
+			}
+		}
+
+		Verbosef("dry run, repository not modified\n")
+		return plan, nil
+	}
+
+	return plan, executePrune(gopts, repo, plan)
+}
+
+// planPrune finds the packs which need to be rewritten or removed. The
+// repository is not modified.
+func planPrune(gopts GlobalOptions, opts PruneOptions, repo restic.Repository) (*PrunePlan, error) {
 	ctx := gopts.ctx
 
 	err := repo.LoadIndex(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var stats struct {
@@ -119,21 +200,62 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		bytes     int64
 	}
 
+	// recentPacks contains the packs which are younger than opts.KeepPackAge,
+	// they are neither removed nor rewritten
+	recentPacks := restic.NewIDSet()
+	unknownAge := 0
+	minModTime := time.Now().Add(-opts.KeepPackAge)
+
+	// pendingPacks contains the packs which a previous prune was unable to
+	// remove, they are not indexed again but removed right away
+	pending := repo.Index().(*repository.MasterIndex).PendingRemoval()
+	pendingPacks := restic.NewIDSet()
+
 	Verbosef("counting files in repo\n")
-	err = repo.List(ctx, restic.DataFile, func(restic.ID, int64) error {
+	err = repo.Backend().List(ctx, restic.DataFile, func(fi restic.FileInfo) error {
+		id, err := restic.ParseID(fi.Name)
+		if err != nil {
+			debug.Log("unable to parse %v as an ID", fi.Name)
+			stats.packs++
+			return nil
+		}
+
+		if pending.Has(id) {
+			pendingPacks.Insert(id)
+			return nil
+		}
+
 		stats.packs++
+
+		if opts.KeepPackAge <= 0 {
+			return nil
+		}
+
+		if fi.ModTime.IsZero() {
+			unknownAge++
+			recentPacks.Insert(id)
+		} else if fi.ModTime.After(minModTime) {
+			recentPacks.Insert(id)
+		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if unknownAge > 0 {
+		Warnf("the backend does not report the modification time of %d packs, keeping them\n", unknownAge)
+	}
+	if opts.KeepPackAge > 0 {
+		Verbosef("keeping %d packs modified within the last %v\n", len(recentPacks), opts.KeepPackAge)
 	}
 
 	Verbosef("building new index for repo\n")
 
 	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
+	idx, invalidFiles, err := index.New(ctx, repo, pendingPacks, bar)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, id := range invalidFiles {
@@ -173,37 +295,20 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	// find referenced blobs
 	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	stats.snapshots = len(snapshots)
 
 	Verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
 
-	usedBlobs := restic.NewBlobSet()
-	seenBlobs := restic.NewBlobSet()
-
-	bar = newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
-	bar.Start()
-	for _, sn := range snapshots {
-		debug.Log("process snapshot %v", sn.ID())
-
-		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
-		if err != nil {
-			if repo.Backend().IsNotExist(err) {
-				return errors.Fatal("unable to load a tree from the repo: " + err.Error())
-			}
-
-			return err
-		}
-
-		debug.Log("processed snapshot %v", sn.ID())
-		bar.Report(restic.Stat{Blobs: 1})
+	usedBlobs, err := findUsedBlobs(gopts, repo, snapshots)
+	if err != nil {
+		return nil, err
 	}
-	bar.Done()
 
 	if len(usedBlobs) > stats.blobs {
-		return errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
+		return nil, errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
 			"Please report this error (along with the output of the 'prune' run) at\n" +
 			"https://github.com/restic/restic/issues/new")
 	}
@@ -214,6 +319,10 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	// find packs that need a rewrite
 	rewritePacks := restic.NewIDSet()
 	for _, pack := range idx.Packs {
+		if recentPacks.Has(pack.ID) {
+			continue
+		}
+
 		if mixedBlobs(pack.Entries) {
 			rewritePacks.Insert(pack.ID)
 			continue
@@ -233,18 +342,33 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	}
 
 	removeBytes := duplicateBytes
+	// removedPackBytes is the size of the unused blobs in the packs which
+	// are removed completely
+	var removedPackBytes uint64
 
 	// find packs that are unneeded
 	removePacks := restic.NewIDSet()
 
-	Verbosef("will remove %d invalid files\n", len(invalidFiles))
 	for _, id := range invalidFiles {
+		if recentPacks.Has(id) {
+			continue
+		}
 		removePacks.Insert(id)
 	}
+	Verbosef("will remove %d invalid files\n", len(removePacks))
+
+	if len(pendingPacks) > 0 {
+		Verbosef("will retry removing %d packs which a previous prune was unable to remove\n", len(pendingPacks))
+		removePacks.Merge(pendingPacks)
+	}
 
 	for packID, p := range idx.Packs {
+		if recentPacks.Has(packID) {
+			continue
+		}
 
 		hasActiveBlob := false
+		var unusedBytes uint64
 		for _, blob := range p.Entries {
 			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
 			if usedBlobs.Has(h) {
@@ -252,30 +376,87 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 				continue
 			}
 
-			removeBytes += uint64(blob.Length)
+			unusedBytes += uint64(blob.Length)
 		}
+		removeBytes += unusedBytes
 
 		if hasActiveBlob {
 			continue
 		}
 
 		removePacks.Insert(packID)
+		removedPackBytes += unusedBytes
 
 		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+			return nil, errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
 		}
 
 		rewritePacks.Delete(packID)
 	}
 
-	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
-		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	if opts.Fast {
+		Verbosef("keeping %d partially used packs\n", len(rewritePacks))
+		rewritePacks = restic.NewIDSet()
+		removeBytes = removedPackBytes
+	}
+
+	keepPacks := restic.NewIDSet()
+	for packID := range idx.Packs {
+		if !removePacks.Has(packID) && !rewritePacks.Has(packID) {
+			keepPacks.Insert(packID)
+		}
+	}
+
+	return &PrunePlan{
+		RewritePacks: rewritePacks,
+		RemovePacks:  removePacks,
+		KeepPacks:    keepPacks,
+		RemoveBytes:  removeBytes,
+		usedBlobs:    usedBlobs,
+	}, nil
+}
+
+// findUsedBlobs returns the blobs referenced by the snapshots.
+func findUsedBlobs(gopts GlobalOptions, repo restic.Repository, snapshots []*restic.Snapshot) (restic.BlobSet, error) {
+	usedBlobs := restic.NewBlobSet()
+	seenBlobs := restic.NewBlobSet()
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
+	bar.Start()
+	for _, sn := range snapshots {
+		debug.Log("process snapshot %v", sn.ID())
+
+		err := restic.FindUsedBlobs(gopts.ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
+		if err != nil {
+			if repo.Backend().IsNotExist(err) {
+				return nil, errors.Fatal("unable to load a tree from the repo: " + err.Error())
+			}
+
+			return nil, err
+		}
+
+		debug.Log("processed snapshot %v", sn.ID())
+		bar.Report(restic.Stat{Blobs: 1})
+	}
+	bar.Done()
+
+	return usedBlobs, nil
+}
+
+// executePrune repacks and removes the packs as described in plan and
+// rebuilds the index.
+func executePrune(gopts GlobalOptions, repo restic.Repository, plan *PrunePlan) error {
+	ctx := gopts.ctx
+	removePacks := restic.NewIDSet()
+	removePacks.Merge(plan.RemovePacks)
 
 	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
+	var err error
+	var bar *restic.Progress
+	if len(plan.RewritePacks) != 0 {
+		bar = newProgressMax(!gopts.Quiet, uint64(len(plan.RewritePacks)), "packs rewritten")
 		bar.Start()
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+		obsoletePacks, err = repository.Repack(ctx, repo, plan.RewritePacks, plan.usedBlobs, bar)
 		if err != nil {
 			return err
 		}
@@ -288,20 +469,59 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		return err
 	}
 
-	if len(removePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
-		bar.Start()
-		for packID := range removePacks {
-			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
-			err = repo.Backend().Remove(ctx, h)
-			if err != nil {
-				Warnf("unable to remove file %v from the repository\n", packID.Str())
-			}
-			bar.Report(restic.Stat{Blobs: 1})
-		}
-		bar.Done()
-	}
+	deletePacks(gopts, repo, removePacks)
 
 	Verbosef("done\n")
 	return nil
 }
+
+// deletePacks removes the packs from the backend, they must not be referenced
+// by the index any more. Packs which are protected by a retention policy are
+// listed as pending removal in a new index, so that check tolerates them and
+// the next prune removes them.
+func deletePacks(gopts GlobalOptions, repo restic.Repository, packs restic.IDSet) {
+	if len(packs) == 0 {
+		return
+	}
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs deleted")
+	bar.Start()
+	var retained restic.IDs
+	for packID := range packs {
+		h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+		err := repo.Backend().Remove(gopts.ctx, h)
+		if restic.IsRetentionError(err) {
+			debug.Log("pack %v is retained: %v", packID, err)
+			retained = append(retained, packID)
+		} else if err != nil {
+			Warnf("unable to remove file %v from the repository\n", packID.Str())
+		}
+		bar.Report(restic.Stat{Blobs: 1})
+	}
+	bar.Done()
+
+	if len(retained) == 0 {
+		return
+	}
+
+	Verbosef("skipped removing %d packs which are protected by a retention policy\n", len(retained))
+
+	id, err := savePendingRemoval(gopts.ctx, repo, retained)
+	if err != nil {
+		Warnf("unable to save the list of packs pending removal: %v\n", err)
+		return
+	}
+
+	Verbosef("saved the packs as pending removal in index %v, they will be removed by a later prune\n", id.Str())
+}
+
+// savePendingRemoval saves a new index which only lists the packs as pending
+// removal.
+func savePendingRemoval(ctx context.Context, repo restic.Repository, packs restic.IDs) (restic.ID, error) {
+	idx := repository.NewIndex()
+	if err := idx.AddPendingRemoval(packs...); err != nil {
+		return restic.ID{}, err
+	}
+
+	return repository.SaveIndex(ctx, repo, idx)
+}
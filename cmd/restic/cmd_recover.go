@@ -78,6 +78,11 @@ func runRecover(gopts GlobalOptions) error {
 			continue
 		}
 
+		// chunks of a split tree are not roots
+		for _, chunk := range tree.Chunks {
+			trees[chunk] = true
+		}
+
 		for _, node := range tree.Nodes {
 			if node.Type != "dir" || node.Subtree == nil {
 				continue
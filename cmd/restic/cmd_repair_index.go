@@ -10,12 +10,14 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var cmdRebuildIndex = &cobra.Command{
-	Use:   "rebuild-index [flags]",
-	Short: "Build a new index file",
+var cmdRepairIndex = &cobra.Command{
+	Use:   "index [flags]",
+	Short: "Build a new index",
 	Long: `
-The "rebuild-index" command creates a new index based on the pack files in the
-repository.
+The "repair index" command creates a new index based on the pack files in the
+repository. It reads the header of every pack file and replaces all existing
+index files, so blobs which are not contained in any pack file are dropped from
+the index. Pack files with a damaged header are reported and skipped.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -23,11 +25,25 @@ repository.
 	},
 }
 
+var cmdRebuildIndex = &cobra.Command{
+	Use:               "rebuild-index [flags]",
+	Short:             cmdRepairIndex.Short,
+	Long:              cmdRepairIndex.Long,
+	Deprecated:        `use "repair index" instead`,
+	DisableAutoGenTag: true,
+	RunE:              cmdRepairIndex.RunE,
+}
+
 func init() {
+	cmdRepair.AddCommand(cmdRepairIndex)
 	cmdRoot.AddCommand(cmdRebuildIndex)
 }
 
 func runRebuildIndex(gopts GlobalOptions) error {
+	if err := checkAppendOnly(gopts, "repair index"); err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -57,11 +73,15 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 	}
 
 	bar := newProgressMax(!globalOptions.Quiet, packs-uint64(len(ignorePacks)), "packs")
-	idx, _, err := index.New(ctx, repo, ignorePacks, bar)
+	idx, invalidFiles, err := index.New(ctx, repo, ignorePacks, bar)
 	if err != nil {
 		return err
 	}
 
+	for _, id := range invalidFiles {
+		Warnf("skipping pack file %v, the header is damaged\n", id.Str())
+	}
+
 	Verbosef("finding old index files\n")
 
 	var supersedes restic.IDs
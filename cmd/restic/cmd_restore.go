@@ -6,7 +6,9 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -20,6 +22,9 @@ a directory.
 
 The special snapshot "latest" can be used to restore the latest snapshot in the
 repository.
+
+With "--stdout", the content of a single file is written to stdout instead, the
+file must be selected with "--include" and no other file may match.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -33,11 +38,21 @@ type RestoreOptions struct {
 	InsensitiveExclude []string
 	Include            []string
 	InsensitiveInclude []string
+	ExcludeRegex       []string
+	IExcludeRegex      []string
+	IncludeRegex       []string
+	IIncludeRegex      []string
 	Target             string
 	Host               string
 	Paths              []string
 	Tags               restic.TagLists
 	Verify             bool
+	MapPaths           []string
+	Overwrite          string
+	ModifiedSince      string
+	RestoreACLs        bool
+	Journal            bool
+	Stdout             bool
 }
 
 var restoreOptions RestoreOptions
@@ -50,18 +65,68 @@ func init() {
 	flags.StringArrayVar(&restoreOptions.InsensitiveExclude, "iexclude", nil, "same as `--exclude` but ignores the casing of filenames")
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as `--include` but ignores the casing of filenames")
+	flags.StringArrayVar(&restoreOptions.ExcludeRegex, "exclude-regex", nil, "exclude paths matching the regular `expression` (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.IExcludeRegex, "iexclude-regex", nil, "same as `--exclude-regex` but ignores the casing of filenames")
+	flags.StringArrayVar(&restoreOptions.IncludeRegex, "include-regex", nil, "include paths matching the regular `expression`, exclude everything else (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.IIncludeRegex, "iinclude-regex", nil, "same as `--include-regex` but ignores the casing of filenames")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
 
 	flags.StringVarP(&restoreOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
-	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify the content of each restored file after it has been written")
+	flags.StringArrayVar(&restoreOptions.MapPaths, "map-path", nil, "restore the path `src:dst` in the snapshot to dst within the target directory (can be specified multiple times)")
+	flags.StringVar(&restoreOptions.Overwrite, "overwrite", "always", "overwrite existing files `always`, if-newer, if-changed or never")
+	flags.BoolVar(&restoreOptions.RestoreACLs, "restore-acls", true, "restore the POSIX ACLs stored in the snapshot (Linux only)")
+	flags.BoolVar(&restoreOptions.Journal, "journal", true, "record restored files in a journal in the target directory, so that an interrupted restore skips them when it is run again")
+	flags.BoolVar(&restoreOptions.Stdout, "stdout", false, "write the content of the single file selected with --include to stdout")
+	flags.StringVar(&restoreOptions.ModifiedSince, "modified-since", "", "only restore files modified after `time` (e.g. \"2020-01-02 15:04\")")
+}
+
+// parsePathMappings parses the path mappings in the form "src:dst".
+func parsePathMappings(mappings []string) ([]restorer.PathMapping, error) {
+	var result []restorer.PathMapping
+	sources := make(map[string]struct{})
+	for _, s := range mappings {
+		data := strings.SplitN(s, ":", 2)
+		if len(data) != 2 || data[0] == "" || data[1] == "" {
+			return nil, errors.Fatalf("invalid path mapping %q, format is src:dst", s)
+		}
+
+		m := restorer.NewPathMapping(data[0], data[1])
+		if _, ok := sources[m.Source]; ok {
+			return nil, errors.Fatalf("path %v is mapped more than once", data[0])
+		}
+		sources[m.Source] = struct{}{}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// compileRestoreRegexps compiles the regular expressions for the restore
+// filters, insensitive contains the expressions which ignore the case.
+func compileRestoreRegexps(patterns, insensitive []string) ([]*regexp.Regexp, error) {
+	res, err := filter.CompileRegexps(patterns, false)
+	if err != nil {
+		return nil, errors.Fatal(err.Error())
+	}
+
+	ires, err := filter.CompileRegexps(insensitive, true)
+	if err != nil {
+		return nil, errors.Fatal(err.Error())
+	}
+
+	return append(res, ires...), nil
 }
 
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	ctx := gopts.ctx
-	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
-	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
+	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0 ||
+		len(opts.ExcludeRegex) > 0 || len(opts.IExcludeRegex) > 0
+	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0 ||
+		len(opts.IncludeRegex) > 0 || len(opts.IIncludeRegex) > 0
 
 	for i, str := range opts.InsensitiveExclude {
 		opts.InsensitiveExclude[i] = strings.ToLower(str)
@@ -78,7 +143,16 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatalf("more than one snapshot ID specified: %v", args)
 	}
 
-	if opts.Target == "" {
+	if opts.Stdout {
+		switch {
+		case opts.Target != "":
+			return errors.Fatal("--stdout and --target cannot be used together")
+		case !hasIncludes:
+			return errors.Fatal("--stdout needs a single file selected with --include")
+		case opts.Verify:
+			return errors.Fatal("--stdout and --verify cannot be used together")
+		}
+	} else if opts.Target == "" {
 		return errors.Fatal("please specify a directory to restore to (--target)")
 	}
 
@@ -86,6 +160,38 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	excludeRegexps, err := compileRestoreRegexps(opts.ExcludeRegex, opts.IExcludeRegex)
+	if err != nil {
+		return err
+	}
+
+	includeRegexps, err := compileRestoreRegexps(opts.IncludeRegex, opts.IIncludeRegex)
+	if err != nil {
+		return err
+	}
+
+	pathMappings, err := parsePathMappings(opts.MapPaths)
+	if err != nil {
+		return err
+	}
+
+	overwrite := restorer.OverwriteAlways
+	if opts.Overwrite != "" {
+		var err error
+		overwrite, err = restorer.ParseOverwriteBehavior(opts.Overwrite)
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	var modifiedSince time.Time
+	if opts.ModifiedSince != "" {
+		modifiedSince, err = parseTime(opts.ModifiedSince)
+		if err != nil {
+			return err
+		}
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -128,6 +234,14 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	totalErrors := 0
+	res.PathMappings = pathMappings
+	res.Verify = opts.Verify
+	res.Overwrite = overwrite
+	res.ModifiedSince = modifiedSince
+	res.RestoreACLs = opts.RestoreACLs
+	res.Warn = func(msg string) {
+		Warnf("%s\n", msg)
+	}
 	res.Error = func(location string, err error) error {
 		Warnf("ignoring error for %s: %s\n", location, err)
 		totalErrors++
@@ -145,11 +259,13 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for iexclude pattern: %v", err)
 		}
 
+		matchedRegexp := filter.MatchRegexps(excludeRegexps, item)
+
 		// An exclude filter is basically a 'wildcard but foo',
 		// so even if a childMayMatch, other children of a dir may not,
 		// therefore childMayMatch does not matter, but we should not go down
 		// unless the dir is selected for restore
-		selectedForRestore = !matched && !matchedInsensitive
+		selectedForRestore = !matched && !matchedInsensitive && !matchedRegexp
 		childMayBeSelected = selectedForRestore && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
@@ -166,8 +282,13 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for iexclude pattern: %v", err)
 		}
 
-		selectedForRestore = matched || matchedInsensitive
-		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
+		// it is not possible to tell whether a regular expression may match
+		// children of a dir, so all dirs are traversed
+		matchedRegexp := filter.MatchRegexps(includeRegexps, item)
+		childMayMatchRegexp := len(includeRegexps) > 0
+
+		selectedForRestore = matched || matchedInsensitive || matchedRegexp
+		childMayBeSelected = (childMayMatch || childMayMatchInsensitive || childMayMatchRegexp) && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
 	}
@@ -178,17 +299,42 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		res.SelectFilter = selectIncludeFilter
 	}
 
+	if opts.Stdout {
+		err = res.RestoreToWriter(ctx, gopts.stdout)
+		if totalErrors > 0 {
+			Warnf("There were %d errors\n", totalErrors)
+		}
+		return err
+	}
+
+	if opts.Journal {
+		journal, err := restorer.OpenJournal(opts.Target, id)
+		if err != nil {
+			return errors.Fatalf("unable to open restore journal: %v", err)
+		}
+		if journal.Len() > 0 {
+			Verbosef("resuming restore, %d files were already restored\n", journal.Len())
+		}
+		res.Journal = journal
+	}
+
 	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
 
 	err = res.RestoreTo(ctx, opts.Target)
-	if err == nil && opts.Verify {
-		Verbosef("verifying files in %s\n", opts.Target)
-		var count int
-		count, err = res.VerifyFiles(ctx, opts.Target)
-		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
-	}
 	if totalErrors > 0 {
 		Printf("There were %d errors\n", totalErrors)
 	}
+
+	if res.Journal != nil {
+		// keep the journal so that the restore can be resumed
+		if err != nil || totalErrors > 0 {
+			if cerr := res.Journal.Close(); cerr != nil {
+				Warnf("unable to close restore journal: %v\n", cerr)
+			}
+		} else if rerr := res.Journal.Remove(); rerr != nil {
+			Warnf("unable to remove restore journal: %v\n", rerr)
+		}
+	}
+
 	return err
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restorer"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParsePathMappings(t *testing.T) {
+	mappings, err := parsePathMappings([]string{"/home/alice:/home/bob", "/etc/hosts:/hosts"})
+	rtest.OK(t, err)
+	rtest.Equals(t, []restorer.PathMapping{
+		restorer.NewPathMapping("/home/alice", "/home/bob"),
+		restorer.NewPathMapping("/etc/hosts", "/hosts"),
+	}, mappings)
+
+	for _, invalid := range [][]string{
+		{"/home/alice"},
+		{":/home/bob"},
+		{"/home/alice:"},
+		{"/home/alice:/a", "/home/alice/:/b"},
+	} {
+		_, err := parsePathMappings(invalid)
+		rtest.Assert(t, err != nil, "expected error for %v, got none", invalid)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdRewrite = &cobra.Command{
+	Use:   "rewrite [flags] snapshot-ID [...]",
+	Short: "Rewrite the metadata of existing snapshots",
+	Long: `
+The "rewrite" command writes new snapshots which reference the same data as
+the given snapshots, but with modified metadata.
+
+With --exclude, the given paths and everything below them are removed from
+the new snapshots, all other files and the metadata of the snapshots are kept
+unchanged. Data which is only referenced by the removed paths is deleted by
+the next run of "prune" once the original snapshots have been removed.
+
+The original snapshots are kept unless --forget is specified. The new
+snapshots record the ID of the original snapshot.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rewriteOptions.SetDescription = cmd.Flags().Changed("description")
+		return runRewrite(rewriteOptions, globalOptions, args)
+	},
+}
+
+// RewriteOptions collects all options for the rewrite command.
+type RewriteOptions struct {
+	Description    string
+	SetDescription bool
+	Forget         bool
+	Excludes       []string
+}
+
+var rewriteOptions RewriteOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdRewrite)
+
+	f := cmdRewrite.Flags()
+	f.StringVar(&rewriteOptions.Description, "description", "", "set the `description` of the snapshots, an empty string removes the description")
+	f.BoolVar(&rewriteOptions.Forget, "forget", false, "remove the original snapshots after rewriting them")
+	f.StringArrayVarP(&rewriteOptions.Excludes, "exclude", "e", nil, "remove the `path` and everything below it from the snapshots (can be specified multiple times)")
+}
+
+// cleanExcludePaths returns the paths in the form used within snapshots.
+func cleanExcludePaths(excludes []string) ([]string, error) {
+	var paths []string
+	for _, p := range excludes {
+		p = path.Clean("/" + filepath.ToSlash(p))
+		if p == "/" {
+			return nil, errors.Fatal("cannot remove the root directory of a snapshot")
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// rewriteTree returns the ID of a copy of the tree id with the nodes at the
+// given paths removed. nodepath is the path of the tree within the snapshot.
+// Subtrees which do not contain any of the paths are not loaded, if nothing
+// is removed the ID of the original tree is returned.
+func rewriteTree(ctx context.Context, repo restic.Repository, nodepath string, id restic.ID, excludes []string) (restic.ID, error) {
+	prefix := nodepath
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	below := false
+	for _, p := range excludes {
+		if strings.HasPrefix(p, prefix) {
+			below = true
+			break
+		}
+	}
+	if !below {
+		return id, nil
+	}
+
+	tree, err := repo.LoadTree(ctx, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	newTree := restic.NewTree()
+	changed := false
+nodes:
+	for _, node := range tree.Nodes {
+		p := path.Join(nodepath, node.Name)
+		for _, exclude := range excludes {
+			if p == exclude {
+				Verbosef("removing %v\n", p)
+				changed = true
+				continue nodes
+			}
+		}
+
+		if node.Type == "dir" && node.Subtree != nil {
+			subtree, err := rewriteTree(ctx, repo, p, *node.Subtree, excludes)
+			if err != nil {
+				return restic.ID{}, err
+			}
+
+			if !subtree.Equal(*node.Subtree) {
+				copied := *node
+				copied.Subtree = &subtree
+				node = &copied
+				changed = true
+			}
+		}
+
+		newTree.Nodes = append(newTree.Nodes, node)
+	}
+
+	if !changed {
+		return id, nil
+	}
+
+	return repo.SaveTree(ctx, newTree)
+}
+
+// rewriteSnapshot saves a copy of sn with the metadata changed according to
+// opts and returns the ID of the new snapshot. The copy references the same
+// tree as sn, unless paths are removed. If the snapshot would not change, no
+// copy is saved and the null ID is returned.
+func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RewriteOptions) (restic.ID, error) {
+	newSn := *sn
+	if opts.SetDescription {
+		newSn.Description = opts.Description
+	}
+
+	if len(opts.Excludes) > 0 {
+		treeID, err := rewriteTree(ctx, repo, "/", *sn.Tree, opts.Excludes)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		if !treeID.Equal(*sn.Tree) {
+			if err = repo.Flush(ctx); err != nil {
+				return restic.ID{}, err
+			}
+			if err = repo.SaveIndex(ctx); err != nil {
+				return restic.ID{}, err
+			}
+			newSn.Tree = &treeID
+		} else if !opts.SetDescription {
+			debug.Log("nothing removed from snapshot %v", sn.ID())
+			return restic.ID{}, nil
+		}
+	}
+
+	// Retain the original snapshot id over all rewrites.
+	if newSn.Original == nil {
+		newSn.Original = sn.ID()
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, &newSn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	debug.Log("new snapshot for %v saved as %v", sn.ID(), id)
+
+	if err = repo.Flush(ctx); err != nil {
+		return restic.ID{}, err
+	}
+
+	if opts.Forget {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return restic.ID{}, err
+		}
+		debug.Log("old snapshot %v removed", sn.ID())
+	}
+
+	return id, nil
+}
+
+func runRewrite(opts RewriteOptions, gopts GlobalOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("no snapshot ID given")
+	}
+	if !opts.SetDescription && len(opts.Excludes) == 0 {
+		return errors.Fatal("nothing to do!")
+	}
+
+	excludes, err := cleanExcludePaths(opts.Excludes)
+	if err != nil {
+		return err
+	}
+	opts.Excludes = excludes
+
+	if opts.Forget {
+		if err := checkAppendOnly(gopts, "rewrite --forget"); err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		Verbosef("create exclusive lock for repository\n")
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if len(opts.Excludes) > 0 {
+		if err = repo.LoadIndex(ctx); err != nil {
+			return err
+		}
+	}
+
+	for sn := range FindFilteredSnapshots(ctx, repo, "", nil, nil, nil, args) {
+		id, err := rewriteSnapshot(ctx, repo, sn, opts)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot %v: %v", sn.ID().Str(), err)
+		}
+		if id.IsNull() {
+			Verbosef("snapshot %v unchanged\n", sn.ID().Str())
+			continue
+		}
+		Verbosef("snapshot %v rewritten as %v\n", sn.ID().Str(), id.Str())
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
@@ -18,6 +19,11 @@ var cmdSnapshots = &cobra.Command{
 	Short: "List all snapshots",
 	Long: `
 The "snapshots" command lists all snapshots stored in the repository.
+
+With --since, only the snapshots added to the repository at or after the
+given time are listed. Instead of a time, the ID of a snapshot can be
+given, then the snapshots added after it are listed. If the backend reports
+the modification time of files, older snapshots are not downloaded.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -30,9 +36,11 @@ type SnapshotOptions struct {
 	Host    string
 	Tags    restic.TagLists
 	Paths   []string
+	Filter  string
 	Compact bool
 	Last    bool
 	GroupBy string
+	Since   string
 }
 
 var snapshotOptions SnapshotOptions
@@ -44,12 +52,23 @@ func init() {
 	f.StringVarP(&snapshotOptions.Host, "host", "H", "", "only consider snapshots for this `host`")
 	f.Var(&snapshotOptions.Tags, "tag", "only consider snapshots which include this `taglist` (can be specified multiple times)")
 	f.StringArrayVar(&snapshotOptions.Paths, "path", nil, "only consider snapshots for this `path` (can be specified multiple times)")
+	f.StringVar(&snapshotOptions.Filter, "filter", "", "only consider snapshots matching the filter `expression`, e.g. \"tag:daily AND NOT host:web1\"")
 	f.BoolVarP(&snapshotOptions.Compact, "compact", "c", false, "use compact format")
 	f.BoolVar(&snapshotOptions.Last, "last", false, "only show the last snapshot for each host and path")
 	f.StringVarP(&snapshotOptions.GroupBy, "group-by", "g", "", "string for grouping snapshots by host,paths,tags")
+	f.StringVar(&snapshotOptions.Since, "since", "", "only list snapshots added at or after `time`, or after the snapshot with this ID")
 }
 
 func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) error {
+	snapshotFilter, err := parseSnapshotFilter(opts.Filter)
+	if err != nil {
+		return err
+	}
+
+	if opts.Since != "" && len(args) > 0 {
+		return errors.Fatal("--since cannot be used together with snapshot IDs")
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -67,8 +86,15 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 	defer cancel()
 
 	var snapshots restic.Snapshots
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
-		snapshots = append(snapshots, sn)
+	if opts.Since != "" {
+		snapshots, err = findSnapshotsSince(ctx, repo, opts, snapshotFilter)
+		if err != nil {
+			return err
+		}
+	} else {
+		for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, snapshotFilter, args) {
+			snapshots = append(snapshots, sn)
+		}
 	}
 	snapshotGroups, grouped, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
 	if err != nil {
@@ -105,6 +131,50 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 	return nil
 }
 
+// findSnapshotsSince returns the snapshots added after the cursor given with
+// --since, which is either a time or a snapshot ID, and which match the other
+// filter options.
+func findSnapshotsSince(ctx context.Context, repo restic.Repository, opts SnapshotOptions, filter restic.SnapshotFilter) (restic.Snapshots, error) {
+	var cursor *restic.ID
+	since, err := parseTime(opts.Since)
+	if err != nil {
+		id, ferr := restic.FindSnapshot(repo, opts.Since)
+		if ferr != nil {
+			return nil, errors.Fatalf("invalid --since %q: neither a time nor a snapshot ID", opts.Since)
+		}
+
+		since, err = restic.SnapshotAddedTime(ctx, repo, id)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &id
+	}
+
+	list, err := restic.LoadSnapshotsSince(ctx, repo, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots restic.Snapshots
+	for _, sn := range list {
+		if cursor != nil && sn.ID().Equal(*cursor) {
+			continue
+		}
+
+		if (opts.Host != "" && opts.Host != sn.Hostname) || !sn.HasTagList(opts.Tags) || !sn.HasPaths(opts.Paths) {
+			continue
+		}
+
+		if filter != nil && !filter.Match(sn) {
+			continue
+		}
+
+		snapshots = append(snapshots, sn)
+	}
+
+	return snapshots, nil
+}
+
 // filterLastSnapshotsKey is used by FilterLastSnapshots.
 type filterLastSnapshotsKey struct {
 	Hostname    string
@@ -160,7 +230,15 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 
 	// Determine the max widths for host and tag.
 	maxHost, maxTag := 10, 6
+	hasDescription := false
+	hasCheckpoint := false
 	for _, sn := range list {
+		if sn.Description != "" {
+			hasDescription = true
+		}
+		if sn.Checkpoint {
+			hasCheckpoint = true
+		}
 		if len(sn.Hostname) > maxHost {
 			maxHost = len(sn.Hostname)
 		}
@@ -178,6 +256,9 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		tab.AddColumn("Time", "{{ .Timestamp }}")
 		tab.AddColumn("Host", "{{ .Hostname }}")
 		tab.AddColumn("Tags  ", `{{ join .Tags "\n" }}`)
+		if hasCheckpoint {
+			tab.AddColumn("Checkpoint", "{{ .Checkpoint }}")
+		}
 	} else {
 		tab.AddColumn("ID", "{{ .ID }}")
 		tab.AddColumn("Time", "{{ .Timestamp }}")
@@ -187,25 +268,40 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			tab.AddColumn("Reasons", `{{ join .Reasons "\n" }}`)
 		}
 		tab.AddColumn("Paths", `{{ join .Paths "\n" }}`)
+		// only show descriptions if there are any, to keep the output compact
+		if hasDescription {
+			tab.AddColumn("Description", "{{ .Description }}")
+		}
+		// partial snapshots of interrupted backups are marked
+		if hasCheckpoint {
+			tab.AddColumn("Checkpoint", "{{ .Checkpoint }}")
+		}
 	}
 
 	type snapshot struct {
-		ID        string
-		Timestamp string
-		Hostname  string
-		Tags      []string
-		Reasons   []string
-		Paths     []string
+		ID          string
+		Timestamp   string
+		Hostname    string
+		Tags        []string
+		Reasons     []string
+		Paths       []string
+		Description string
+		Checkpoint  string
 	}
 
 	var multiline bool
 	for _, sn := range list {
 		data := snapshot{
-			ID:        sn.ID().Str(),
-			Timestamp: sn.Time.Local().Format(TimeFormat),
-			Hostname:  sn.Hostname,
-			Tags:      sn.Tags,
-			Paths:     sn.Paths,
+			ID:          sn.ID().Str(),
+			Timestamp:   sn.Time.Local().Format(TimeFormat),
+			Hostname:    sn.Hostname,
+			Tags:        sn.Tags,
+			Paths:       sn.Paths,
+			Description: sn.Description,
+		}
+
+		if sn.Checkpoint {
+			data.Checkpoint = "yes"
 		}
 
 		if len(reasons) > 0 {
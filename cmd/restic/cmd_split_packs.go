@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdSplitPacks = &cobra.Command{
+	Use:   "split-packs [flags]",
+	Short: "Split packs which are larger than the configured pack size",
+	Long: `
+The "split-packs" command rewrites the blobs of all packs which are larger than
+--max-size into new packs of the pack size configured for the repository.
+Afterwards, the index is rebuilt and the large packs are removed. Packs which
+are not larger than --max-size are left untouched.
+
+An interrupted run can be resumed by running the command again, blobs which
+have already been saved to a new pack are not saved again.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSplitPacks(splitPacksOptions, globalOptions)
+	},
+}
+
+// SplitPacksOptions collects all options for the split-packs command.
+type SplitPacksOptions struct {
+	MaxSize string
+}
+
+var splitPacksOptions SplitPacksOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdSplitPacks)
+
+	f := cmdSplitPacks.Flags()
+	f.StringVar(&splitPacksOptions.MaxSize, "max-size", "", "split packs larger than `size`, e.g. 32M (default: twice the pack size of the repository)")
+}
+
+func runSplitPacks(opts SplitPacksOptions, gopts GlobalOptions) error {
+	if err := checkAppendOnly(gopts, "split-packs"); err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	packSize := int64(repo.Config().TargetPackSize())
+	maxSize := 2 * packSize
+	if opts.MaxSize != "" {
+		maxSize, err = parseSizeStr(opts.MaxSize)
+		if err != nil {
+			return errors.Fatalf("invalid --max-size: %v", err)
+		}
+
+		if maxSize < packSize {
+			return errors.Fatalf("--max-size must not be smaller than the pack size of the repository (%d bytes)", packSize)
+		}
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx := gopts.ctx
+
+	Verbosef("loading index files\n")
+	err = repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	packs, err := repository.FindOversizedPacks(ctx, repo, maxSize)
+	if err != nil {
+		return err
+	}
+
+	if len(packs) == 0 {
+		Verbosef("no packs larger than %v found\n", formatBytes(uint64(maxSize)))
+		return nil
+	}
+
+	Verbosef("splitting %d packs larger than %v\n", len(packs), formatBytes(uint64(maxSize)))
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs split")
+	bar.Start()
+	obsoletePacks, err := repository.SplitPacks(ctx, repo, packs, bar)
+	if err != nil {
+		return err
+	}
+	bar.Done()
+
+	if err = rebuildIndex(ctx, repo, obsoletePacks); err != nil {
+		return err
+	}
+
+	deletePacks(gopts, repo, obsoletePacks)
+
+	Verbosef("done\n")
+	return nil
+}
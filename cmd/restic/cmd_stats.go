@@ -5,11 +5,12 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 
+	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/table"
 	"github.com/restic/restic/internal/walker"
 	"github.com/spf13/cobra"
 )
@@ -36,6 +37,11 @@ The modes are:
 * raw-data: Counts the size of blobs in the repository, regardless of
   how many files reference them.
 * blobs-per-file: A combination of files-by-contents and raw-data.
+* dedup: Compares the total size of all files to the size of the unique
+  data stored in the repository and lists the most referenced blobs.
+* blobs-per-pack: Lists for each pack in the index how many of its blobs
+  are still referenced by the snapshots and how much space is wasted by
+  unreferenced blobs, followed by a histogram of the wasted space.
 
 Refer to the online manual for more details about each mode.
 `,
@@ -48,7 +54,8 @@ Refer to the online manual for more details about each mode.
 func init() {
 	cmdRoot.AddCommand(cmdStats)
 	f := cmdStats.Flags()
-	f.StringVar(&countMode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file, or raw-data")
+	f.StringVar(&countMode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file, raw-data, dedup, or blobs-per-pack")
+	f.IntVar(&statsTopBlobs, "top", 10, "number of most referenced blobs to list in dedup mode")
 	f.StringVarP(&snapshotByHost, "host", "H", "", "filter latest snapshot by this hostname")
 }
 
@@ -90,40 +97,24 @@ func runStats(gopts GlobalOptions, args []string) error {
 		blobsSeen:   restic.NewBlobSet(),
 	}
 
-	if snapshotIDString != "" {
-		// scan just a single snapshot
+	snapshots, err := loadStatsSnapshots(ctx, repo)
+	if err != nil {
+		return err
+	}
 
-		var sID restic.ID
-		if snapshotIDString == "latest" {
-			sID, err = restic.FindLatestSnapshot(ctx, repo, []string{}, []restic.TagList{}, snapshotByHost)
-			if err != nil {
-				return errors.Fatalf("latest snapshot for criteria not found: %v", err)
-			}
-		} else {
-			sID, err = restic.FindSnapshot(repo, snapshotIDString)
-			if err != nil {
-				return errors.Fatalf("error loading snapshot: %v", err)
-			}
-		}
+	if countMode == countModeDedup {
+		return statsDedup(ctx, gopts, repo, snapshots)
+	}
 
-		snapshot, err := restic.LoadSnapshot(ctx, repo, sID)
-		if err != nil {
-			return errors.Fatalf("error loading snapshot from repo: %v", err)
-		}
+	if countMode == countModeBlobsPerPack {
+		return statsBlobsPerPack(ctx, gopts, repo, snapshots)
+	}
 
+	for _, snapshot := range snapshots {
 		err = statsWalkSnapshot(ctx, snapshot, repo, stats)
-	} else {
-		// iterate every snapshot in the repo
-		err = repo.List(ctx, restic.SnapshotFile, func(snapshotID restic.ID, size int64) error {
-			snapshot, err := restic.LoadSnapshot(ctx, repo, snapshotID)
-			if err != nil {
-				return fmt.Errorf("Error loading snapshot %s: %v", snapshotID.Str(), err)
-			}
-			return statsWalkSnapshot(ctx, snapshot, repo, stats)
-		})
-	}
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
 	}
 
 	if countMode == countModeRawData {
@@ -139,11 +130,12 @@ func runStats(gopts GlobalOptions, args []string) error {
 	}
 
 	if gopts.JSON {
-		err = json.NewEncoder(os.Stdout).Encode(stats)
-		if err != nil {
-			return fmt.Errorf("encoding output: %v", err)
-		}
-		return nil
+		return printStatsJSON(gopts, statsJSON{
+			SnapshotsCount: len(snapshots),
+			TotalSize:      stats.TotalSize,
+			TotalFileCount: stats.TotalFileCount,
+			TotalBlobCount: stats.TotalBlobCount,
+		})
 	}
 
 	// inform the user what was scanned and how it was scanned
@@ -166,6 +158,197 @@ func runStats(gopts GlobalOptions, args []string) error {
 	return nil
 }
 
+// statsSchemaVersion is the version of the JSON output of the stats command.
+// It must be increased when the output changes in an incompatible way.
+const statsSchemaVersion = 1
+
+// statsJSON is the JSON output of the stats command, it is the same for all
+// counting modes.
+type statsJSON struct {
+	MessageType    string `json:"message_type"` // "stats"
+	SchemaVersion  int    `json:"schema_version"`
+	Mode           string `json:"mode"`
+	SnapshotsCount int    `json:"snapshots_count"`
+	TotalSize      uint64 `json:"total_size"`
+	TotalFileCount uint64 `json:"total_file_count"`
+	TotalBlobCount uint64 `json:"total_blob_count"`
+
+	// Dedup is only set in dedup mode, TotalSize is the logical size and
+	// TotalBlobCount the number of unique data blobs in this mode.
+	Dedup *restic.DedupStats `json:"dedup,omitempty"`
+
+	// PackStats is only set in blobs-per-pack mode, TotalSize is the size of
+	// all packs and TotalBlobCount the number of blobs in this mode.
+	PackStats *restic.PackFragmentation `json:"pack_stats,omitempty"`
+}
+
+// printStatsJSON fills in the common fields of s and prints it to stdout.
+func printStatsJSON(gopts GlobalOptions, s statsJSON) error {
+	s.MessageType = "stats"
+	s.SchemaVersion = statsSchemaVersion
+	s.Mode = countMode
+
+	err := json.NewEncoder(gopts.stdout).Encode(s)
+	if err != nil {
+		return fmt.Errorf("encoding output: %v", err)
+	}
+	return nil
+}
+
+// loadStatsSnapshots returns the snapshot given by the user or, if none was
+// specified, all snapshots in the repo except for the checkpoints of
+// interrupted backups.
+func loadStatsSnapshots(ctx context.Context, repo restic.Repository) ([]*restic.Snapshot, error) {
+	if snapshotIDString != "" {
+		// scan just a single snapshot
+
+		var sID restic.ID
+		var err error
+		if snapshotIDString == "latest" {
+			sID, err = restic.FindLatestSnapshot(ctx, repo, []string{}, []restic.TagList{}, snapshotByHost)
+			if err != nil {
+				return nil, errors.Fatalf("latest snapshot for criteria not found: %v", err)
+			}
+		} else {
+			sID, err = restic.FindSnapshot(repo, snapshotIDString)
+			if err != nil {
+				return nil, errors.Fatalf("error loading snapshot: %v", err)
+			}
+		}
+
+		snapshot, err := restic.LoadSnapshot(ctx, repo, sID)
+		if err != nil {
+			return nil, errors.Fatalf("error loading snapshot from repo: %v", err)
+		}
+
+		return []*restic.Snapshot{snapshot}, nil
+	}
+
+	// iterate every snapshot in the repo
+	var snapshots []*restic.Snapshot
+	err := repo.List(ctx, restic.SnapshotFile, func(snapshotID restic.ID, size int64) error {
+		snapshot, err := restic.LoadSnapshot(ctx, repo, snapshotID)
+		if err != nil {
+			return fmt.Errorf("Error loading snapshot %s: %v", snapshotID.Str(), err)
+		}
+		if snapshot.Checkpoint {
+			debug.Log("skipping checkpoint snapshot %v", snapshotID.Str())
+			return nil
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+// statsDedup computes and prints the deduplication statistics for snapshots.
+func statsDedup(ctx context.Context, gopts GlobalOptions, repo restic.Repository, snapshots []*restic.Snapshot) error {
+	stats, err := restic.ComputeDedupStats(ctx, repo, snapshots, statsTopBlobs)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return printStatsJSON(gopts, statsJSON{
+			SnapshotsCount: stats.SnapshotsCount,
+			TotalSize:      stats.LogicalSize,
+			TotalBlobCount: uint64(stats.UniqueBlobCount),
+			Dedup:          stats,
+		})
+	}
+
+	Printf("Stats for %d snapshots in %s mode:\n", stats.SnapshotsCount, countMode)
+	Printf("      Logical Size:   %-5s\n", formatBytes(stats.LogicalSize))
+	Printf("       Stored Size:   %-5s\n", formatBytes(stats.StoredSize))
+	Printf(" Unique Blob Count:   %d\n", stats.UniqueBlobCount)
+	Printf("       Dedup Ratio:   %.2f\n", stats.DedupRatio)
+
+	if len(stats.TopBlobs) > 0 {
+		Printf("\nMost referenced blobs:\n")
+		for _, blob := range stats.TopBlobs {
+			Printf("  %v  %8d references  %s\n", blob.ID.Str(), blob.References, formatBytes(uint64(blob.Size)))
+		}
+	}
+
+	return nil
+}
+
+// statsBlobsPerPack computes and prints the statistics for all packs, a blob
+// is in use if it is referenced by one of the snapshots.
+func statsBlobsPerPack(ctx context.Context, gopts GlobalOptions, repo restic.Repository, snapshots []*restic.Snapshot) error {
+	if gopts.JSON {
+		// don't mix the progress bar with the JSON output
+		gopts.Quiet = true
+	}
+
+	usedBlobs, err := findUsedBlobs(gopts, repo, snapshots)
+	if err != nil {
+		return err
+	}
+
+	stats := restic.ComputePackStats(ctx, repo, usedBlobs)
+
+	var totalSize, totalBlobs uint64
+	for _, pack := range stats.Packs {
+		totalSize += pack.Size
+		totalBlobs += uint64(pack.Blobs)
+	}
+
+	if gopts.JSON {
+		return printStatsJSON(gopts, statsJSON{
+			SnapshotsCount: len(snapshots),
+			TotalSize:      totalSize,
+			TotalBlobCount: totalBlobs,
+			PackStats:      stats,
+		})
+	}
+
+	type packInfo struct {
+		ID        string
+		Blobs     int
+		UsedBlobs int
+		Size      string
+		UsedSize  string
+		Wasted    string
+	}
+
+	tab := table.New()
+	tab.AddColumn("Pack", "{{ .ID }}")
+	tab.AddColumn("Blobs", "{{ .Blobs }}")
+	tab.AddColumn("Used Blobs", "{{ .UsedBlobs }}")
+	tab.AddColumn("Size", "{{ .Size }}")
+	tab.AddColumn("Used Size", "{{ .UsedSize }}")
+	tab.AddColumn("Wasted", "{{ .Wasted }}")
+
+	for _, pack := range stats.Packs {
+		tab.AddRow(packInfo{
+			ID:        pack.ID.Str(),
+			Blobs:     pack.Blobs,
+			UsedBlobs: pack.UsedBlobs,
+			Size:      formatBytes(pack.Size),
+			UsedSize:  formatBytes(pack.UsedSize),
+			Wasted:    fmt.Sprintf("%.1f%%", pack.Wasted()),
+		})
+	}
+
+	if err = tab.Write(gopts.stdout); err != nil {
+		return err
+	}
+
+	Printf("\nStats for %d snapshots in %s mode:\n", len(snapshots), countMode)
+	Printf("        Pack Count:   %d\n", len(stats.Packs))
+	Printf("        Blob Count:   %d\n", totalBlobs)
+	Printf("        Total Size:   %-5s\n", formatBytes(totalSize))
+
+	Printf("\nWasted space:\n")
+	step := 100 / restic.PackStatsHistogramBuckets
+	for i, count := range stats.Histogram {
+		Printf("  %3d%% - %3d%%:   %d packs\n", i*step, (i+1)*step, count)
+	}
+
+	return nil
+}
+
 func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo restic.Repository, stats *statsContainer) error {
 	if snapshot.Tree == nil {
 		return fmt.Errorf("snapshot %s has nil tree", snapshot.ID().Str())
@@ -264,6 +447,8 @@ func verifyStatsInput(gopts GlobalOptions, args []string) error {
 	case countModeUniqueFilesByContents:
 	case countModeBlobsPerFile:
 	case countModeRawData:
+	case countModeDedup:
+	case countModeBlobsPerPack:
 	default:
 		return fmt.Errorf("unknown counting mode: %s (use the -h flag to get a list of supported modes)", countMode)
 	}
@@ -285,9 +470,9 @@ func verifyStatsInput(gopts GlobalOptions, args []string) error {
 // to collect information about it, as well as state needed
 // for a successful and efficient walk.
 type statsContainer struct {
-	TotalSize      uint64 `json:"total_size"`
-	TotalFileCount uint64 `json:"total_file_count"`
-	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
+	TotalSize      uint64
+	TotalFileCount uint64
+	TotalBlobCount uint64
 
 	// uniqueFiles marks visited files according to their
 	// contents (hashed sequence of content blob IDs)
@@ -315,6 +500,10 @@ var (
 	// snapshotByHost is the host to filter latest
 	// snapshot by, if given by user
 	snapshotByHost string
+
+	// statsTopBlobs is the number of most referenced
+	// blobs to list in dedup mode
+	statsTopBlobs int
 )
 
 const (
@@ -322,4 +511,6 @@ const (
 	countModeUniqueFilesByContents = "files-by-contents"
 	countModeBlobsPerFile          = "blobs-per-file"
 	countModeRawData               = "raw-data"
+	countModeDedup                 = "dedup"
+	countModeBlobsPerPack          = "blobs-per-pack"
 )
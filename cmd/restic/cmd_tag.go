@@ -33,6 +33,7 @@ type TagOptions struct {
 	Host       string
 	Paths      []string
 	Tags       restic.TagLists
+	Filter     string
 	SetTags    []string
 	AddTags    []string
 	RemoveTags []string
@@ -51,6 +52,7 @@ func init() {
 	tagFlags.StringVarP(&tagOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
 	tagFlags.Var(&tagOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+	tagFlags.StringVar(&tagOptions.Filter, "filter", "", "only consider snapshots matching the filter `expression`, when no snapshot-ID is given")
 }
 
 func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
@@ -106,6 +108,14 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	if len(opts.SetTags) != 0 && (len(opts.AddTags) != 0 || len(opts.RemoveTags) != 0) {
 		return errors.Fatal("--set and --add/--remove cannot be given at the same time")
 	}
+	if err := checkAppendOnly(gopts, "tag"); err != nil {
+		return err
+	}
+
+	snapshotFilter, err := parseSnapshotFilter(opts.Filter)
+	if err != nil {
+		return err
+	}
 
 	repo, err := OpenRepository(gopts)
 	if err != nil {
@@ -124,7 +134,7 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	changeCnt := 0
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, snapshotFilter, args) {
 		changed, err := changeTags(ctx, repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
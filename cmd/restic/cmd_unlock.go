@@ -31,21 +31,30 @@ func init() {
 }
 
 func runUnlock(opts UnlockOptions, gopts GlobalOptions) error {
+	if err := checkAppendOnly(gopts, "unlock"); err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
-	fn := restic.RemoveStaleLocks
 	if opts.RemoveAll {
-		fn = restic.RemoveAllLocks
+		err = restic.RemoveAllLocks(gopts.ctx, repo)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("successfully removed locks\n")
+		return nil
 	}
 
-	err = fn(gopts.ctx, repo)
+	removed, err := restic.RemoveStaleLocks(gopts.ctx, repo, lockOptions(gopts))
 	if err != nil {
 		return err
 	}
 
-	Verbosef("successfully removed locks\n")
+	Verbosef("removed %d stale locks\n", len(removed))
 	return nil
 }
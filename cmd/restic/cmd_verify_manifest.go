@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdVerifyManifest = &cobra.Command{
+	Use:   "verify-manifest [flags] snapshotID",
+	Short: "Export the content hashes of the files in a snapshot",
+	Long: `
+The "verify-manifest" command prints the SHA-256 hashes of all files in a
+snapshot, which allows verifying restored files with external tools. The
+hashes are only available for files saved with "backup --store-content-hash".
+
+The output uses the format of sha256sum. The paths are relative to the root of
+the snapshot, so that files restored with "restore --target dir" can be
+checked by running "sha256sum -c" in dir. With --json, one JSON object per
+file with the absolute path within the snapshot is printed instead.
+
+The special snapshot "latest" can be used to use the latest snapshot in the
+repository.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVerifyManifest(verifyManifestOptions, globalOptions, args)
+	},
+}
+
+// VerifyManifestOptions collects all options for the verify-manifest command.
+type VerifyManifestOptions struct {
+	Host  string
+	Paths []string
+	Tags  restic.TagLists
+}
+
+var verifyManifestOptions VerifyManifestOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdVerifyManifest)
+
+	flags := cmdVerifyManifest.Flags()
+	flags.StringVarP(&verifyManifestOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
+	flags.Var(&verifyManifestOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
+	flags.StringArrayVar(&verifyManifestOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+}
+
+// manifestEntry is printed for each file with --json.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+func runVerifyManifest(opts VerifyManifestOptions, gopts GlobalOptions, args []string) error {
+	ctx := gopts.ctx
+
+	if len(args) != 1 {
+		return errors.Fatal("no snapshot ID specified")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	var id restic.ID
+	if args[0] == "latest" {
+		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Host)
+		if err != nil {
+			return errors.Fatalf("latest snapshot for criteria not found: %v Paths:%v Host:%v", err, opts.Paths, opts.Host)
+		}
+	} else {
+		id, err = restic.FindSnapshot(repo, args[0])
+		if err != nil {
+			return errors.Fatalf("invalid id %q: %v", args[0], err)
+		}
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return errors.Fatalf("loading snapshot %q failed: %v", args[0], err)
+	}
+
+	enc := json.NewEncoder(gopts.stdout)
+	missing := 0
+
+	err = walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+
+		if node == nil || node.Type != "file" {
+			return false, nil
+		}
+
+		if node.ContentHash == "" {
+			missing++
+			return false, nil
+		}
+
+		if gopts.JSON {
+			return false, enc.Encode(manifestEntry{Path: nodepath, SHA256: node.ContentHash})
+		}
+
+		_, err = fmt.Fprintf(gopts.stdout, "%s  %s\n", node.ContentHash, strings.TrimPrefix(nodepath, "/"))
+		return false, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if missing > 0 {
+		Warnf("%d files in snapshot %v have no content hash\n", missing, id.Str())
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,6 +16,7 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/textfile"
 )
 
 type rejectionCache struct {
@@ -100,6 +103,30 @@ func rejectByInsensitivePattern(patterns []string) RejectByNameFunc {
 	}
 }
 
+// rejectByRegexp returns a RejectByNameFunc which rejects files whose path
+// matches one of the regular expressions in patterns, or in insensitive
+// ignoring the case. The expressions are compiled once.
+func rejectByRegexp(patterns, insensitive []string) (RejectByNameFunc, error) {
+	res, err := filter.CompileRegexps(patterns, false)
+	if err != nil {
+		return nil, errors.Fatalf("--exclude-regex: %v", err)
+	}
+
+	ires, err := filter.CompileRegexps(insensitive, true)
+	if err != nil {
+		return nil, errors.Fatalf("--iexclude-regex: %v", err)
+	}
+
+	res = append(res, ires...)
+	return func(item string) bool {
+		if filter.MatchRegexps(res, item) {
+			debug.Log("path %q excluded by a regular expression", item)
+			return true
+		}
+		return false
+	}, nil
+}
+
 // rejectIfPresent returns a RejectByNameFunc which itself returns whether a path
 // should be excluded. The RejectByNameFunc considers a file to be excluded when
 // it resides in a directory with an exclusion file, that is specified by
@@ -197,6 +224,158 @@ func isDirExcludedByFile(dir, tagFilename, header string) bool {
 	return true
 }
 
+// ignoreRule is a pattern read from an ignore file. Negated rules include
+// files which were excluded by a previous rule.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+}
+
+// parseIgnoreFile returns the rules in an ignore file. Empty lines and lines
+// starting with '#' are skipped, a leading '!' negates the pattern.
+func parseIgnoreFile(data []byte) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{pattern: line}
+		if strings.HasPrefix(line, "!") {
+			rule = ignoreRule{pattern: line[1:], negate: true}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignoreFiles reads the rules of the ignore files in the directories below
+// the backup targets. It is safe for concurrent use.
+type ignoreFiles struct {
+	names   []string
+	targets []string
+
+	mtx   sync.Mutex
+	rules map[string][]ignoreRule
+}
+
+// dirRules returns the rules of all ignore files in dir, in the order the
+// names of the ignore files were given. The rules are cached.
+func (f *ignoreFiles) dirRules(dir string) []ignoreRule {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if rules, ok := f.rules[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	for _, name := range f.names {
+		filename := filepath.Join(dir, name)
+		data, err := textfile.Read(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			Warnf("could not read ignore file: %v\n", err)
+			continue
+		}
+
+		debug.Log("read ignore file %v", filename)
+		rules = append(rules, parseIgnoreFile(data)...)
+	}
+
+	f.rules[dir] = rules
+	return rules
+}
+
+// insideTargets returns true if dir is one of the targets or below one.
+func (f *ignoreFiles) insideTargets(dir string) bool {
+	for _, target := range f.targets {
+		if fs.HasPathPrefix(target, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectByIgnoreFiles returns a RejectByNameFunc which rejects files that are
+// excluded by the patterns in ignore files with one of the given names. An
+// ignore file applies to all files below its directory, the patterns are
+// relative to that directory. Only ignore files in the targets and their
+// subdirectories are considered. The rules of nested ignore files are
+// evaluated after those of their parent directories, the last matching rule
+// decides whether a file is excluded, so a negated pattern can include files
+// excluded by a parent directory.
+func rejectByIgnoreFiles(names, targets []string) (RejectByNameFunc, error) {
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return nil, errors.Fatalf("invalid name for ignore file %q", name)
+		}
+	}
+
+	f := &ignoreFiles{
+		names: names,
+		rules: make(map[string][]ignoreRule),
+	}
+	for _, target := range targets {
+		target, err := filepath.Abs(target)
+		if err != nil {
+			return nil, err
+		}
+		f.targets = append(f.targets, target)
+	}
+
+	return func(item string) bool {
+		item, err := filepath.Abs(item)
+		if err != nil {
+			Warnf("could not evaluate ignore files for %v: %v\n", item, err)
+			return false
+		}
+
+		// collect the directories with ignore files from the top down
+		var dirs []string
+		for dir := filepath.Dir(item); f.insideTargets(dir); dir = filepath.Dir(dir) {
+			dirs = append(dirs, dir)
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+
+		excluded := false
+		for i := len(dirs) - 1; i >= 0; i-- {
+			rules := f.dirRules(dirs[i])
+			if len(rules) == 0 {
+				continue
+			}
+
+			rel, err := filepath.Rel(dirs[i], item)
+			if err != nil {
+				continue
+			}
+			rel = string(filepath.Separator) + rel
+
+			for _, rule := range rules {
+				matched, err := filter.Match(rule.pattern, rel)
+				if err != nil {
+					Warnf("error for pattern %q in ignore file in %v: %v\n", rule.pattern, dirs[i], err)
+					continue
+				}
+				if matched {
+					excluded = !rule.negate
+				}
+			}
+		}
+
+		if excluded {
+			debug.Log("path %q excluded by an ignore file", item)
+		}
+		return excluded
+	}, nil
+}
+
 // gatherDevices returns the set of unique device ids of the files and/or
 // directory paths listed in "items".
 func gatherDevices(items []string) (deviceMap map[string]uint64, err error) {
@@ -224,13 +403,61 @@ func gatherDevices(items []string) (deviceMap map[string]uint64, err error) {
 }
 
 // rejectByDevice returns a RejectFunc that rejects files which are on a
-// different file systems than the files/dirs in samples.
-func rejectByDevice(samples []string) (RejectFunc, error) {
+// different file systems than the files/dirs in samples. The paths in
+// crossMounts are exceptions, files below them are allowed if they are on the
+// same file system as the path itself.
+func rejectByDevice(samples, crossMounts []string) (RejectFunc, error) {
 	allowed, err := gatherDevices(samples)
 	if err != nil {
 		return nil, err
 	}
-	debug.Log("allowed devices: %v\n", allowed)
+
+	var exceptions map[string]uint64
+	if len(crossMounts) > 0 {
+		exceptions, err = gatherDevices(crossMounts)
+		if err != nil {
+			return nil, errors.Fatalf("invalid --cross-mount: %v", err)
+		}
+	}
+
+	return rejectByDeviceID(allowed, exceptions, func(item string, fi os.FileInfo) (uint64, error) {
+		return fs.DeviceID(fi)
+	}), nil
+}
+
+// rejectBySize returns a RejectFunc that rejects regular files which are
+// smaller than minSize or larger than maxSize bytes. The size is taken from
+// the file info the archiver gets before reading the file, so a file which
+// grows while it is saved is not excluded afterwards.
+func rejectBySize(minSize, maxSize int64) RejectFunc {
+	return func(item string, fi os.FileInfo) bool {
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+
+		size := fi.Size()
+		if size < minSize || size > maxSize {
+			debug.Log("file %q with size %d excluded, allowed range is %d to %d bytes", item, size, minSize, maxSize)
+			return true
+		}
+
+		return false
+	}
+}
+
+// rejectByDeviceID returns a RejectFunc that rejects files for which the
+// device ID returned by deviceID differs from the one of the closest parent
+// dir in allowed or exceptions. The parent dirs of the paths in exceptions are
+// never rejected, so that the exceptions are reached.
+func rejectByDeviceID(allowed, exceptions map[string]uint64, deviceID func(item string, fi os.FileInfo) (uint64, error)) RejectFunc {
+	devices := make(map[string]uint64, len(allowed)+len(exceptions))
+	for dir, id := range allowed {
+		devices[dir] = id
+	}
+	for dir, id := range exceptions {
+		devices[dir] = id
+	}
+	debug.Log("allowed devices: %v\n", devices)
 
 	return func(item string, fi os.FileInfo) bool {
 		if fi == nil {
@@ -239,7 +466,14 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 
 		item = filepath.Clean(item)
 
-		id, err := fs.DeviceID(fi)
+		for dir := range exceptions {
+			if fs.HasPathPrefix(item, dir) {
+				debug.Log("path %q leads to cross mount %v", item, dir)
+				return false
+			}
+		}
+
+		id, err := deviceID(item, fi)
 		if err != nil {
 			// This should never happen because gatherDevices() would have
 			// errored out earlier. If it still does that's a reason to panic.
@@ -249,7 +483,7 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 		for dir := item; ; dir = filepath.Dir(dir) {
 			debug.Log("item %v, test dir %v", item, dir)
 
-			allowedID, ok := allowed[dir]
+			allowedID, ok := devices[dir]
 			if !ok {
 				if dir == filepath.Dir(dir) {
 					break
@@ -265,8 +499,8 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 			return false
 		}
 
-		panic(fmt.Sprintf("item %v, device id %v not found, allowedDevs: %v", item, id, allowed))
-	}, nil
+		panic(fmt.Sprintf("item %v, device id %v not found, allowedDevs: %v", item, id, devices))
+	}
 }
 
 // rejectResticCache returns a RejectByNameFunc that rejects the restic cache
@@ -292,3 +526,47 @@ func rejectResticCache(repo *repository.Repository) (RejectByNameFunc, error) {
 		return false
 	}, nil
 }
+
+// RejectByContentFunc is a function that takes a filename, os.FileInfo and the
+// first bytes of a regular file that would be included in the backup. The
+// function returns true if it should be excluded (rejected) from the backup.
+type RejectByContentFunc func(path string, fi os.FileInfo, head []byte) bool
+
+// rejectByContentType returns a RejectByContentFunc which rejects files whose
+// content type matches one of the MIME types. The content type is detected
+// from the magic bytes at the start of the file as described in
+// https://mimesniff.spec.whatwg.org/, so it does not depend on the name of the
+// file. A type of the form "image/*" matches all subtypes.
+func rejectByContentType(types []string) (RejectByContentFunc, error) {
+	var patterns []string
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		parts := strings.Split(t, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Fatalf("invalid content type %q, expected type/subtype", t)
+		}
+		patterns = append(patterns, t)
+	}
+
+	return func(item string, fi os.FileInfo, head []byte) bool {
+		contentType := detectContentType(head)
+		for _, pattern := range patterns {
+			if pattern == contentType ||
+				(strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, pattern[:len(pattern)-1])) {
+				debug.Log("file %q with content type %v excluded", item, contentType)
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// detectContentType returns the MIME type of data without any parameters.
+func detectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if pos := strings.IndexByte(contentType, ';'); pos >= 0 {
+		contentType = contentType[:pos]
+	}
+	return contentType
+}
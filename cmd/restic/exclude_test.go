@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/test"
 )
 
@@ -189,3 +190,271 @@ func TestMultipleIsExcludedByFile(t *testing.T) {
 		}
 	}
 }
+
+func TestRejectByContentType(t *testing.T) {
+	var (
+		jpeg = "\xff\xd8\xff\xe0\x00\x10JFIF\x00"
+		png  = "\x89PNG\x0d\x0a\x1a\x0a\x00\x00\x00\x0dIHDR"
+		gzip = "\x1f\x8b\x08\x00\x00\x00\x00\x00"
+	)
+
+	var tests = []struct {
+		types    []string
+		filename string
+		content  string
+		reject   bool
+	}{
+		{[]string{"image/jpeg"}, "photo.txt", jpeg, true},
+		{[]string{"image/jpeg"}, "photo.jpg", "not a jpeg at all", false},
+		{[]string{"image/jpeg"}, "image.jpg", png, false},
+		{[]string{"image/*"}, "image.dat", png, true},
+		{[]string{"image/*"}, "photo", jpeg, true},
+		{[]string{"IMAGE/JPEG"}, "photo", jpeg, true},
+		{[]string{"image/png", "application/x-gzip"}, "archive.txt", gzip, true},
+		{[]string{"text/plain"}, "empty.bin", "", true},
+		{[]string{"text/plain"}, "photo.txt", jpeg, false},
+	}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			reject, err := rejectByContentType(tc.types)
+			test.OK(t, err)
+
+			res := reject(tc.filename, nil, []byte(tc.content))
+			if res != tc.reject {
+				t.Fatalf("wrong result for %v with types %v: want %v, got %v",
+					tc.filename, tc.types, tc.reject, res)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", "image", "image/", "/jpeg", "image/jpeg/x"} {
+		_, err := rejectByContentType([]string{invalid})
+		test.Assert(t, err != nil, "no error for invalid content type %q", invalid)
+	}
+}
+
+func TestRejectBySize(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	sizes := map[string]int{"empty": 0, "small": 10, "medium": 1000, "large": 100000}
+	for name, size := range sizes {
+		test.OK(t, ioutil.WriteFile(filepath.Join(tempDir, name), make([]byte, size), 0644))
+	}
+	test.OK(t, os.Mkdir(filepath.Join(tempDir, "dir"), 0755))
+
+	var tests = []struct {
+		smaller, larger string
+		rejected        []string
+	}{
+		{"", "1000", []string{"large"}},
+		{"", "999", []string{"medium", "large"}},
+		{"1", "", []string{"empty"}},
+		{"1k", "", []string{"empty", "small", "medium"}},
+		{"1", "50k", []string{"empty", "large"}},
+		{"10", "10", []string{"empty", "medium", "large"}},
+	}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			opts := BackupOptions{ExcludeSmallerThan: tc.smaller, ExcludeLargerThan: tc.larger}
+			funcs, err := collectRejectFuncs(opts, nil, nil)
+			test.OK(t, err)
+			test.Equals(t, 1, len(funcs))
+
+			rejected := make(map[string]bool)
+			for _, name := range tc.rejected {
+				rejected[name] = true
+			}
+
+			for _, name := range []string{"empty", "small", "medium", "large", "dir"} {
+				item := filepath.Join(tempDir, name)
+				fi, err := os.Lstat(item)
+				test.OK(t, err)
+
+				if res := funcs[0](item, fi); res != rejected[name] {
+					t.Errorf("wrong result for %v with range [%q, %q]: want %v, got %v",
+						name, tc.smaller, tc.larger, rejected[name], res)
+				}
+			}
+		})
+	}
+
+	for _, opts := range []BackupOptions{
+		{ExcludeLargerThan: "foo"},
+		{ExcludeSmallerThan: "-1"},
+		{ExcludeSmallerThan: "2k", ExcludeLargerThan: "1k"},
+	} {
+		_, err := collectRejectFuncs(opts, nil, nil)
+		test.Assert(t, err != nil, "no error for invalid options %+v", opts)
+	}
+}
+
+func TestRejectByIgnoreFiles(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	target := filepath.Join(tempDir, "target")
+	ignoreFiles := map[string]string{
+		// the ignore file above the target is not used
+		".resticignore":                "*\n",
+		"target/.resticignore":         "# comment\n*.log\n/build\ncache/\n",
+		"target/sub/.resticignore":     "!keep.log\n*.tmp\n",
+		"target/sub/deeper/.otherfile": "*.bak\n",
+	}
+	for name, content := range ignoreFiles {
+		filename := filepath.Join(tempDir, filepath.FromSlash(name))
+		test.OK(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		test.OK(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	}
+
+	reject, err := rejectByIgnoreFiles([]string{".resticignore", ".otherfile"}, []string{target})
+	test.OK(t, err)
+
+	var tests = []struct {
+		path     string
+		rejected bool
+	}{
+		{"target", false},
+		{"target/.resticignore", false},
+		{"target/foo.txt", false},
+		{"target/foo.log", true},
+		{"target/build", true},
+		{"target/build/main.o", true},
+		{"target/cache/data", true},
+		{"target/x.tmp", false},
+		{"target/sub/build", false},
+		{"target/sub/other.log", true},
+		{"target/sub/keep.log", false},
+		{"target/sub/x.tmp", true},
+		{"target/sub/deeper/keep.log", false},
+		{"target/sub/deeper/x.tmp", true},
+		{"target/sub/deeper/cache/data", true},
+		{"target/sub/deeper/x.bak", true},
+		{"target/sub/x.bak", false},
+	}
+
+	for _, tc := range tests {
+		item := filepath.Join(tempDir, filepath.FromSlash(tc.path))
+		if res := reject(item); res != tc.rejected {
+			t.Errorf("wrong result for %v: want %v, got %v", tc.path, tc.rejected, res)
+		}
+	}
+
+	for _, name := range []string{"", "sub/.resticignore"} {
+		_, err := rejectByIgnoreFiles([]string{name}, []string{target})
+		test.Assert(t, err != nil, "no error for invalid name %q", name)
+	}
+}
+
+func TestRejectByRegexp(t *testing.T) {
+	opts := BackupOptions{
+		Excludes:           []string{"*.tmp"},
+		ExcludeRegexps:     []string{`/cache/[0-9]+$`, `^/var/`},
+		InsensitiveRegexps: []string{`\.bak$`},
+	}
+
+	funcs, err := collectRejectByNameFuncs(opts, &repository.Repository{}, nil)
+	test.OK(t, err)
+	test.Equals(t, 2, len(funcs))
+
+	var tests = []struct {
+		path     string
+		rejected bool
+	}{
+		{"/home/user/file.txt", false},
+		{"/home/user/file.tmp", true},
+		{"/home/user/cache/123", true},
+		{"/home/user/cache/123/file", false},
+		{"/home/user/cache/abc", false},
+		{"/var/log/syslog", true},
+		{"/home/var/file", false},
+		{"/home/user/file.bak", true},
+		{"/home/user/file.BAK", true},
+		{"/home/user/file.bak.txt", false},
+	}
+
+	for _, tc := range tests {
+		rejected := false
+		for _, reject := range funcs {
+			if reject(filepath.FromSlash(tc.path)) {
+				rejected = true
+			}
+		}
+
+		if rejected != tc.rejected {
+			t.Errorf("wrong result for %v: want %v, got %v", tc.path, tc.rejected, rejected)
+		}
+	}
+
+	for _, opts := range []BackupOptions{
+		{ExcludeRegexps: []string{"foo("}},
+		{InsensitiveRegexps: []string{"[a-"}},
+	} {
+		_, err := collectRejectByNameFuncs(opts, &repository.Repository{}, nil)
+		test.Assert(t, err != nil, "no error for invalid options %+v", opts)
+	}
+}
+
+func TestRejectByDeviceID(t *testing.T) {
+	// the fake mount table maps mount points to device IDs
+	mounts := map[string]uint64{
+		"/":               1,
+		"/var":            2,
+		"/var/lib/docker": 3,
+		"/home":           4,
+		"/srv":            5,
+		"/srv/data":       6,
+	}
+
+	deviceID := func(item string, fi os.FileInfo) (uint64, error) {
+		for dir := item; ; dir = filepath.Dir(dir) {
+			if id, ok := mounts[filepath.ToSlash(dir)]; ok {
+				return id, nil
+			}
+		}
+	}
+
+	allowed := map[string]uint64{filepath.FromSlash("/"): 1}
+	exceptions := map[string]uint64{
+		filepath.FromSlash("/var"):      2,
+		filepath.FromSlash("/srv/data"): 6,
+	}
+	reject := rejectByDeviceID(allowed, exceptions, deviceID)
+
+	fi, err := os.Lstat(".")
+	test.OK(t, err)
+
+	var tests = []struct {
+		item   string
+		reject bool
+	}{
+		{"/etc/passwd", false},
+		{"/var", false},
+		{"/var/log/syslog", false},
+		// other mounts below an exception are not crossed
+		{"/var/lib/docker", true},
+		{"/var/lib/docker/image", true},
+		{"/home", true},
+		{"/home/user/file", true},
+		// the path to an exception is not rejected
+		{"/srv", false},
+		{"/srv/other", true},
+		{"/srv/data", false},
+		{"/srv/data/file", false},
+	}
+
+	for _, tc := range tests {
+		res := reject(filepath.FromSlash(tc.item), fi)
+		if res != tc.reject {
+			t.Errorf("wrong result for %v: want %v, got %v", tc.item, tc.reject, res)
+		}
+	}
+
+	// without exceptions, only the root file system is allowed
+	reject = rejectByDeviceID(allowed, nil, deviceID)
+	for _, item := range []string{"/var", "/srv/data/file"} {
+		test.Assert(t, reject(filepath.FromSlash(item), fi), "%v was not rejected", item)
+	}
+}
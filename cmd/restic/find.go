@@ -3,12 +3,28 @@ package main
 import (
 	"context"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
+// parseSnapshotFilter parses the filter expression given with --filter. If s
+// is empty, nil is returned.
+func parseSnapshotFilter(s string) (restic.SnapshotFilter, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	filter, err := restic.ParseSnapshotFilter(s)
+	if err != nil {
+		return nil, errors.Fatal(err.Error())
+	}
+	return filter, nil
+}
+
 // FindFilteredSnapshots yields Snapshots, either given explicitly by `snapshotIDs` or filtered from the list of all snapshots.
-func FindFilteredSnapshots(ctx context.Context, repo *repository.Repository, host string, tags []restic.TagList, paths []string, snapshotIDs []string) <-chan *restic.Snapshot {
+// If filter is not nil, only snapshots matching it are selected from the list of all snapshots.
+func FindFilteredSnapshots(ctx context.Context, repo *repository.Repository, host string, tags []restic.TagList, paths []string, filter restic.SnapshotFilter, snapshotIDs []string) <-chan *restic.Snapshot {
 	out := make(chan *restic.Snapshot)
 	go func() {
 		defer close(out)
@@ -39,7 +55,7 @@ func FindFilteredSnapshots(ctx context.Context, repo *repository.Repository, hos
 			}
 
 			// Give the user some indication their filters are not used.
-			if !usedFilter && (host != "" || len(tags) != 0 || len(paths) != 0) {
+			if !usedFilter && (host != "" || len(tags) != 0 || len(paths) != 0 || filter != nil) {
 				Warnf("Ignoring filters as there are explicit snapshot ids given\n")
 			}
 
@@ -65,6 +81,10 @@ func FindFilteredSnapshots(ctx context.Context, repo *repository.Repository, hos
 		}
 
 		for _, sn := range snapshots {
+			if filter != nil && !filter.Match(sn) {
+				continue
+			}
+
 			select {
 			case <-ctx.Done():
 				return
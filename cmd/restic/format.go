@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -25,6 +28,44 @@ func formatBytes(c uint64) string {
 	}
 }
 
+// parseSizeStr parses a size like "512K" or "4M". The suffixes K, M, G and T
+// denote powers of 1024, a number without suffix is a size in bytes.
+func parseSizeStr(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, errors.New("empty size")
+	}
+
+	s := sizeStr
+	unit := int64(1)
+	switch s[len(s)-1] {
+	case 'b', 'B':
+		s = s[:len(s)-1]
+	case 'k', 'K':
+		unit = 1 << 10
+		s = s[:len(s)-1]
+	case 'm', 'M':
+		unit = 1 << 20
+		s = s[:len(s)-1]
+	case 'g', 'G':
+		unit = 1 << 30
+		s = s[:len(s)-1]
+	case 't', 'T':
+		unit = 1 << 40
+		s = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", sizeStr)
+	}
+
+	if value < 0 || value > math.MaxInt64/unit {
+		return 0, errors.Errorf("size %q out of range", sizeStr)
+	}
+
+	return value * unit, nil
+}
+
 func formatSeconds(sec uint64) string {
 	hours := sec / 3600
 	sec -= hours * 3600
@@ -13,20 +13,9 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/backend"
-	"github.com/restic/restic/internal/backend/azure"
-	"github.com/restic/restic/internal/backend/b2"
-	"github.com/restic/restic/internal/backend/gs"
-	"github.com/restic/restic/internal/backend/local"
-	"github.com/restic/restic/internal/backend/location"
-	"github.com/restic/restic/internal/backend/rclone"
-	"github.com/restic/restic/internal/backend/rest"
-	"github.com/restic/restic/internal/backend/s3"
-	"github.com/restic/restic/internal/backend/sftp"
-	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/factory"
 	"github.com/restic/restic/internal/cache"
-	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
-	"github.com/restic/restic/internal/limiter"
 	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -53,16 +42,32 @@ type GlobalOptions struct {
 	Quiet           bool
 	Verbose         int
 	NoLock          bool
+	LockScope       string
+	LockClockSkew   time.Duration
+	StaleFutureLock bool
+	LockRetries     int
+	LockRetryDelay  time.Duration
+	AppendOnly      bool
 	JSON            bool
 	CacheDir        string
 	NoCache         bool
+	CacheListingTTL time.Duration
+	CacheMaxSize    string
 	CACerts         []string
 	TLSClientCert   string
 	CleanupCache    bool
 
+	HTTPHeaders        []string
+	HTTPHeaderOverride bool
+
 	LimitUploadKb   int
 	LimitDownloadKb int
 
+	LimitDataUploadKb       int
+	LimitDataDownloadKb     int
+	LimitMetadataUploadKb   int
+	LimitMetadataDownloadKb int
+
 	ctx      context.Context
 	password string
 	stdout   io.Writer
@@ -101,14 +106,28 @@ func init() {
 	f.BoolVarP(&globalOptions.Quiet, "quiet", "q", false, "do not output comprehensive progress report")
 	f.CountVarP(&globalOptions.Verbose, "verbose", "v", "be verbose (specify --verbose multiple times or level `n`)")
 	f.BoolVar(&globalOptions.NoLock, "no-lock", false, "do not lock the repo, this allows some operations on read-only repos")
+	f.StringVar(&globalOptions.LockScope, "lock-scope", os.Getenv("RESTIC_LOCK_SCOPE"), "record the `scope` in the locks created by restic, exclusive locks still conflict with all scopes (default: $RESTIC_LOCK_SCOPE)")
+	f.DurationVar(&globalOptions.LockClockSkew, "lock-clock-skew", restic.DefaultLockMaxClockSkew, "report locks dated more than `duration` in the future")
+	f.BoolVar(&globalOptions.StaleFutureLock, "stale-future-locks", false, "consider locks dated beyond --lock-clock-skew in the future as stale")
+	f.IntVar(&globalOptions.LockRetries, "lock-retries", 0, "retry creating a lock file up to `n` times on network and server errors (default: no retries)")
+	f.DurationVar(&globalOptions.LockRetryDelay, "lock-retry-delay", time.Second, "wait `duration` before the first retry of creating a lock file, the delay grows for each retry")
+	f.BoolVar(&globalOptions.AppendOnly, "append-only", false, "never remove or overwrite files in the repository, e.g. for append-only storage")
 	f.BoolVarP(&globalOptions.JSON, "json", "", false, "set output mode to JSON for commands that support it")
 	f.StringVar(&globalOptions.CacheDir, "cache-dir", "", "set the cache directory. (default: use system default cache directory)")
 	f.BoolVar(&globalOptions.NoCache, "no-cache", false, "do not use a local cache")
+	f.DurationVar(&globalOptions.CacheListingTTL, "cache-listing-ttl", 0, "cache the list of files in the repository for `duration` (e.g. 10m) (default: disabled)")
+	f.StringVar(&globalOptions.CacheMaxSize, "cache-max-size", "", "limit the cache to `size` (e.g. 500M or 2G), the least recently used files are removed (default: unlimited)")
 	f.StringSliceVar(&globalOptions.CACerts, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a file containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.StringArrayVar(&globalOptions.HTTPHeaders, "http-header", nil, "add the HTTP `header` (Name=value) to all requests of HTTP based backends (can be specified multiple times)")
+	f.BoolVar(&globalOptions.HTTPHeaderOverride, "http-header-override", false, "replace headers set by restic, e.g. authorization headers, with the values from --http-header")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
+	f.IntVar(&globalOptions.LimitDataUploadKb, "limit-data-upload", 0, "limits uploads of data files to a maximum rate in KiB/s. (default: unlimited)")
+	f.IntVar(&globalOptions.LimitDataDownloadKb, "limit-data-download", 0, "limits downloads of data files to a maximum rate in KiB/s. (default: unlimited)")
+	f.IntVar(&globalOptions.LimitMetadataUploadKb, "limit-metadata-upload", 0, "limits uploads of all files except data files to a maximum rate in KiB/s. (default: unlimited)")
+	f.IntVar(&globalOptions.LimitMetadataDownloadKb, "limit-metadata-download", 0, "limits downloads of all files except data files to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
 
 	restoreTerminal()
@@ -240,8 +259,10 @@ func Exitf(exitcode int, format string, args ...interface{}) {
 	Exit(exitcode)
 }
 
-// resolvePassword determines the password to be used for opening the repository.
-func resolvePassword(opts GlobalOptions) (string, error) {
+// resolvePassword determines the password to be used for opening the
+// repository. If neither a password file nor a command is given, the password
+// is read from the environment variable envStr.
+func resolvePassword(opts GlobalOptions, envStr string) (string, error) {
 	if opts.PasswordFile != "" && opts.PasswordCommand != "" {
 		return "", errors.Fatalf("Password file and command are mutually exclusive options")
 	}
@@ -266,7 +287,7 @@ func resolvePassword(opts GlobalOptions) (string, error) {
 		return strings.TrimSpace(string(s)), errors.Wrap(err, "Readfile")
 	}
 
-	if pwd := os.Getenv("RESTIC_PASSWORD"); pwd != "" {
+	if pwd := os.Getenv(envStr); pwd != "" {
 		return pwd, nil
 	}
 
@@ -349,44 +370,53 @@ func ReadPasswordTwice(gopts GlobalOptions, prompt1, prompt2 string) (string, er
 
 const maxKeys = 20
 
+// checkAppendOnly returns an error if the repository was opened with
+// --append-only, cmd is the name of a command which needs to remove files.
+func checkAppendOnly(gopts GlobalOptions, cmd string) error {
+	if gopts.AppendOnly {
+		return errors.Fatalf("%s needs to remove files from the repository, which is not possible with --append-only", cmd)
+	}
+	return nil
+}
+
 // OpenRepository reads the password and opens the repository.
 func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	if opts.Repo == "" {
 		return nil, errors.Fatal("Please specify repository location (-r)")
 	}
 
-	be, err := open(opts.Repo, opts, opts.extended)
-	if err != nil {
-		return nil, err
-	}
-
-	be = backend.NewRetryBackend(be, 10, func(msg string, err error, d time.Duration) {
-		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
-	})
-
-	s := repository.New(be)
-
-	passwordTriesLeft := 1
-	if stdinIsTerminal() && opts.password == "" {
-		passwordTriesLeft = 3
-	}
-
-	for ; passwordTriesLeft > 0; passwordTriesLeft-- {
-		opts.password, err = ReadPassword(opts, "enter password for repository: ")
-		if err != nil && passwordTriesLeft > 1 {
-			opts.password = ""
-			fmt.Printf("%s. Try again\n", err)
-		}
+	var cacheMaxSize int64
+	if opts.CacheMaxSize != "" {
+		size, err := parseSizeStr(opts.CacheMaxSize)
 		if err != nil {
-			continue
+			return nil, errors.Fatalf("invalid --cache-max-size: %v", err)
 		}
+		cacheMaxSize = size
+	}
 
-		err = s.SearchKey(opts.ctx, opts.password, maxKeys, opts.KeyHint)
-		if err != nil && passwordTriesLeft > 1 {
-			opts.password = ""
+	passwordTries := 1
+	if stdinIsTerminal() && opts.password == "" {
+		passwordTries = 3
+	}
+
+	s, err := repository.Open(opts.ctx, repository.OpenOptions{
+		Repo:           opts.Repo,
+		BackendOptions: backendOptions(opts, opts.extended),
+		AppendOnly:     opts.AppendOnly,
+		Password: func() (string, error) {
+			return ReadPassword(opts, "enter password for repository: ")
+		},
+		PasswordTries: passwordTries,
+		PasswordError: func(err error) {
 			fmt.Printf("%s. Try again\n", err)
-		}
-	}
+		},
+		KeyHint:         opts.KeyHint,
+		CacheDir:        opts.CacheDir,
+		NoCache:         opts.NoCache,
+		CacheMaxSize:    cacheMaxSize,
+		CacheListingTTL: opts.CacheListingTTL,
+		Warnf:           Warnf,
+	})
 	if err != nil {
 		if errors.IsFatal(err) {
 			return nil, err
@@ -399,18 +429,11 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		if len(id) > 8 {
 			id = id[:8]
 		}
-		if !opts.JSON {
-			Verbosef("repository %v opened successfully, password is correct\n", id)
-		}
+		Verbosef("repository %v opened successfully, password is correct\n", id)
 	}
 
-	if opts.NoCache {
-		return s, nil
-	}
-
-	c, err := cache.New(s.Config().ID, opts.CacheDir)
-	if err != nil {
-		Warnf("unable to open cache: %v\n", err)
+	c, ok := s.Cache.(*cache.Cache)
+	if !ok {
 		return s, nil
 	}
 
@@ -418,9 +441,6 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		Verbosef("created new cache in %v\n", c.Base)
 	}
 
-	// start using the cache
-	s.UseCache(c)
-
 	oldCacheDirs, err := cache.Old(c.Base)
 	if err != nil {
 		Warnf("unable to find old cache directories: %v", err)
@@ -452,256 +472,33 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	return s, nil
 }
 
-func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
-	// only apply options for a particular backend here
-	opts = opts.Extract(loc.Scheme)
-
-	switch loc.Scheme {
-	case "local":
-		cfg := loc.Config.(local.Config)
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening local repository at %#v", cfg)
-		return cfg, nil
-
-	case "sftp":
-		cfg := loc.Config.(sftp.Config)
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening sftp repository at %#v", cfg)
-		return cfg, nil
-
-	case "s3":
-		cfg := loc.Config.(s3.Config)
-		if cfg.KeyID == "" {
-			cfg.KeyID = os.Getenv("AWS_ACCESS_KEY_ID")
-		}
-
-		if cfg.Secret == "" {
-			cfg.Secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
-		}
-
-		if cfg.Region == "" {
-			cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
-		}
-
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening s3 repository at %#v", cfg)
-		return cfg, nil
-
-	case "gs":
-		cfg := loc.Config.(gs.Config)
-		if cfg.ProjectID == "" {
-			cfg.ProjectID = os.Getenv("GOOGLE_PROJECT_ID")
-		}
-
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening gs repository at %#v", cfg)
-		return cfg, nil
-
-	case "azure":
-		cfg := loc.Config.(azure.Config)
-		if cfg.AccountName == "" {
-			cfg.AccountName = os.Getenv("AZURE_ACCOUNT_NAME")
-		}
-
-		if cfg.AccountKey == "" {
-			cfg.AccountKey = os.Getenv("AZURE_ACCOUNT_KEY")
-		}
-
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening gs repository at %#v", cfg)
-		return cfg, nil
-
-	case "swift":
-		cfg := loc.Config.(swift.Config)
-
-		if err := swift.ApplyEnvironment("", &cfg); err != nil {
-			return nil, err
-		}
-
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening swift repository at %#v", cfg)
-		return cfg, nil
-
-	case "b2":
-		cfg := loc.Config.(b2.Config)
-
-		if cfg.AccountID == "" {
-			cfg.AccountID = os.Getenv("B2_ACCOUNT_ID")
-		}
-
-		if cfg.AccountID == "" {
-			return nil, errors.Fatalf("unable to open B2 backend: Account ID ($B2_ACCOUNT_ID) is empty")
-		}
-
-		if cfg.Key == "" {
-			cfg.Key = os.Getenv("B2_ACCOUNT_KEY")
-		}
-
-		if cfg.Key == "" {
-			return nil, errors.Fatalf("unable to open B2 backend: Key ($B2_ACCOUNT_KEY) is empty")
-		}
-
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening b2 repository at %#v", cfg)
-		return cfg, nil
-	case "rest":
-		cfg := loc.Config.(rest.Config)
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening rest repository at %#v", cfg)
-		return cfg, nil
-	case "rclone":
-		cfg := loc.Config.(rclone.Config)
-		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
-			return nil, err
-		}
-
-		debug.Log("opening rest repository at %#v", cfg)
-		return cfg, nil
+// backendOptions returns the options for opening and creating backends.
+func backendOptions(gopts GlobalOptions, opts options.Options) factory.Options {
+	return factory.Options{
+		Extended: opts,
+		Transport: backend.TransportOptions{
+			RootCertFilenames:        gopts.CACerts,
+			TLSClientCertKeyFilename: gopts.TLSClientCert,
+			Headers:                  gopts.HTTPHeaders,
+			OverrideHeaders:          gopts.HTTPHeaderOverride,
+		},
+
+		LimitUploadKb:   gopts.LimitUploadKb,
+		LimitDownloadKb: gopts.LimitDownloadKb,
+
+		LimitDataUploadKb:       gopts.LimitDataUploadKb,
+		LimitDataDownloadKb:     gopts.LimitDataDownloadKb,
+		LimitMetadataUploadKb:   gopts.LimitMetadataUploadKb,
+		LimitMetadataDownloadKb: gopts.LimitMetadataDownloadKb,
 	}
-
-	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 }
 
 // Open the backend specified by a location config.
 func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
-	debug.Log("parsing location %v", s)
-	loc, err := location.Parse(s)
-	if err != nil {
-		return nil, errors.Fatalf("parsing repository location failed: %v", err)
-	}
-
-	var be restic.Backend
-
-	cfg, err := parseConfig(loc, opts)
-	if err != nil {
-		return nil, err
-	}
-
-	tropts := backend.TransportOptions{
-		RootCertFilenames:        globalOptions.CACerts,
-		TLSClientCertKeyFilename: globalOptions.TLSClientCert,
-	}
-	rt, err := backend.Transport(tropts)
-	if err != nil {
-		return nil, err
-	}
-
-	// wrap the transport so that the throughput via HTTP is limited
-	lim := limiter.NewStaticLimiter(gopts.LimitUploadKb, gopts.LimitDownloadKb)
-	rt = lim.Transport(rt)
-
-	switch loc.Scheme {
-	case "local":
-		be, err = local.Open(cfg.(local.Config))
-		// wrap the backend in a LimitBackend so that the throughput is limited
-		be = limiter.LimitBackend(be, lim)
-	case "sftp":
-		be, err = sftp.Open(cfg.(sftp.Config))
-		// wrap the backend in a LimitBackend so that the throughput is limited
-		be = limiter.LimitBackend(be, lim)
-	case "s3":
-		be, err = s3.Open(cfg.(s3.Config), rt)
-	case "gs":
-		be, err = gs.Open(cfg.(gs.Config), rt)
-	case "azure":
-		be, err = azure.Open(cfg.(azure.Config), rt)
-	case "swift":
-		be, err = swift.Open(cfg.(swift.Config), rt)
-	case "b2":
-		be, err = b2.Open(globalOptions.ctx, cfg.(b2.Config), rt)
-	case "rest":
-		be, err = rest.Open(cfg.(rest.Config), rt)
-	case "rclone":
-		be, err = rclone.Open(cfg.(rclone.Config), lim)
-
-	default:
-		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
-	}
-
-	if err != nil {
-		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
-	}
-
-	// check if config is there
-	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
-	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
-	}
-
-	if fi.Size == 0 {
-		return nil, errors.New("config file has zero size, invalid repository?")
-	}
-
-	return be, nil
+	return factory.Open(globalOptions.ctx, s, backendOptions(gopts, opts))
 }
 
 // Create the backend specified by URI.
 func create(s string, opts options.Options) (restic.Backend, error) {
-	debug.Log("parsing location %v", s)
-	loc, err := location.Parse(s)
-	if err != nil {
-		return nil, err
-	}
-
-	cfg, err := parseConfig(loc, opts)
-	if err != nil {
-		return nil, err
-	}
-
-	tropts := backend.TransportOptions{
-		RootCertFilenames:        globalOptions.CACerts,
-		TLSClientCertKeyFilename: globalOptions.TLSClientCert,
-	}
-	rt, err := backend.Transport(tropts)
-	if err != nil {
-		return nil, err
-	}
-
-	switch loc.Scheme {
-	case "local":
-		return local.Create(cfg.(local.Config))
-	case "sftp":
-		return sftp.Create(cfg.(sftp.Config))
-	case "s3":
-		return s3.Create(cfg.(s3.Config), rt)
-	case "gs":
-		return gs.Create(cfg.(gs.Config), rt)
-	case "azure":
-		return azure.Create(cfg.(azure.Config), rt)
-	case "swift":
-		return swift.Open(cfg.(swift.Config), rt)
-	case "b2":
-		return b2.Create(globalOptions.ctx, cfg.(b2.Config), rt)
-	case "rest":
-		return rest.Create(cfg.(rest.Config), rt)
-	case "rclone":
-		return rclone.Open(cfg.(rclone.Config), nil)
-	}
-
-	debug.Log("invalid repository scheme: %v", s)
-	return nil, errors.Fatalf("invalid scheme %q", loc.Scheme)
+	return factory.Create(globalOptions.ctx, s, backendOptions(globalOptions, opts))
 }
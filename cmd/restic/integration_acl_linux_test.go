@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testACL returns an access ACL in the format of the extended attribute,
+// which grants read access to the user with the ID 1000 in addition to the
+// permissions of the mode 0644.
+func testACL() []byte {
+	const undefinedID = 0xffffffff
+	entries := []struct {
+		tag, perm uint16
+		id        uint32
+	}{
+		{0x01, 6, undefinedID}, // user
+		{0x02, 4, 1000},        // named user
+		{0x04, 4, undefinedID}, // group
+		{0x10, 4, undefinedID}, // mask
+		{0x20, 4, undefinedID}, // other
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(2))
+	for _, e := range entries {
+		_ = binary.Write(buf, binary.LittleEndian, e)
+	}
+	return buf.Bytes()
+}
+
+func TestRestoreACLs(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	acl := testACL()
+	p := filepath.Join(env.testdata, "file")
+	rtest.OK(t, ioutil.WriteFile(p, []byte("foo"), 0644))
+	rtest.OK(t, restic.Setxattr(p, restic.ACLAccessAttribute, acl))
+	if value, err := restic.Getxattr(p, restic.ACLAccessAttribute); err != nil || value == nil {
+		t.Skipf("file system does not support ACLs: %v", err)
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	for _, restoreACLs := range []bool{true, false} {
+		restoredir := filepath.Join(env.base, "restore", map[bool]string{true: "acls", false: "noacls"}[restoreACLs])
+		opts := RestoreOptions{Target: restoredir, RestoreACLs: restoreACLs}
+		rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotIDs[0].String()}))
+
+		restored := filepath.Join(restoredir, filepath.Base(env.testdata), "file")
+		if restoreACLs {
+			value, err := restic.Getxattr(restored, restic.ACLAccessAttribute)
+			rtest.OK(t, err)
+			rtest.Equals(t, acl, value)
+		} else {
+			names, err := restic.Listxattr(restored)
+			rtest.OK(t, err)
+			for _, name := range names {
+				rtest.Assert(t, !restic.IsACLAttribute(name), "ACL %v restored with --restore-acls=false", name)
+			}
+		}
+	}
+}
@@ -5,24 +5,32 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	mrand "math/rand"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restorer"
 	rtest "github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/ui/termstatus"
 	"golang.org/x/sync/errgroup"
@@ -50,7 +58,7 @@ func testRunInit(t testing.TB, opts GlobalOptions) {
 	restic.TestDisableCheckPolynomial(t)
 	restic.TestSetLockTimeout(t, 0)
 
-	rtest.OK(t, runInit(opts, nil))
+	rtest.OK(t, runInit(InitOptions{}, opts, nil))
 	t.Logf("repository initialized at %v", opts.Repo)
 }
 
@@ -249,7 +257,7 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 }
 
 func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+	rtest.OK(t, runPrune(PruneOptions{}, gopts))
 }
 
 func TestBackup(t *testing.T) {
@@ -317,6 +325,41 @@ func TestBackup(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestBackupScan(t *testing.T) {
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	for _, noScan := range []bool{false, true} {
+		t.Run(fmt.Sprintf("no-scan=%v", noScan), func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+
+			testRunInit(t, env.gopts)
+			rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+			// without --no-scan, the scan runs concurrently to the backup
+			opts := BackupOptions{NoScan: noScan}
+			testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+			snapshotIDs := testRunList(t, "snapshots", env.gopts)
+			rtest.Assert(t, len(snapshotIDs) == 1,
+				"expected one snapshot, got %v", snapshotIDs)
+
+			testRunCheck(t, env.gopts)
+
+			restoredir := filepath.Join(env.base, "restore")
+			testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+			rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+				"directories are not equal")
+		})
+	}
+}
+
 func TestBackupNonExistingFile(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -436,6 +479,53 @@ func TestBackupExclude(t *testing.T) {
 		"expected file %q not in first snapshot, but it's included", "passwords.txt")
 }
 
+func TestBackupFilesFromRaw(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("newlines in filenames are not supported on Windows")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	filenames := []string{
+		"file with spaces",
+		" leading and trailing space ",
+		"file with\nnewline",
+		"#no comment",
+	}
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for _, filename := range append(filenames, "not included") {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, filename), []byte(filename), 0644))
+	}
+
+	var list bytes.Buffer
+	for _, filename := range filenames {
+		list.WriteString(filepath.Join("testdata", filename))
+		list.WriteByte(0)
+	}
+	listfile := filepath.Join(env.base, "files-from-raw")
+	rtest.OK(t, ioutil.WriteFile(listfile, list.Bytes(), 0644))
+
+	opts := BackupOptions{FilesFromRaw: []string{listfile}}
+	testRunBackup(t, filepath.Dir(env.testdata), nil, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filepath.Join(restoredir, "testdata", filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, filename, string(data))
+	}
+
+	_, err := os.Lstat(filepath.Join(restoredir, "testdata", "not included"))
+	rtest.Assert(t, os.IsNotExist(err), "file not in the list was backed up, err: %v", err)
+}
+
 const (
 	incrementalFirstWrite  = 10 * 1042 * 1024
 	incrementalSecondWrite = 1 * 1042 * 1024
@@ -502,587 +592,2259 @@ func TestIncrementalBackup(t *testing.T) {
 	t.Logf("repository grown by %d bytes", stat3.size-stat2.size)
 }
 
-func TestBackupTags(t *testing.T) {
+func TestInitChunkerAverageSize(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "backup-data.tar.gz")
-	testRunInit(t, env.gopts)
-	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
 
-	opts := BackupOptions{}
+	err := runInit(InitOptions{ChunkerAverageSize: "100K"}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected error for invalid average chunk size, got none")
 
-	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ := testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 0,
-		"expected no tags, got %v", newest.Tags)
-	parent := newest
+	rtest.OK(t, runInit(InitOptions{ChunkerAverageSize: "64K"}, env.gopts, nil))
 
-	opts.Tags = []string{"NL"}
-	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "testfile"), 4<<20))
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
 	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "NL",
-		"expected one NL tag, got %v", newest.Tags)
-	// Tagged backup should have untagged backup as parent.
-	rtest.Assert(t, parent.ID.Equal(*newest.Parent),
-		"expected parent to be %v, got %v", parent.ID, newest.Parent)
-}
 
-func testRunTag(t testing.TB, opts TagOptions, gopts GlobalOptions) {
-	rtest.OK(t, runTag(opts, gopts, []string{}))
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(64<<10), repo.Config().ChunkerAverageSize)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+
+	// with the default chunk sizes, the file would be split into few chunks
+	// of at least 512KiB
+	blobs := 0
+	for pb := range repo.Index().Each(env.gopts.ctx) {
+		if pb.Type != restic.DataBlob {
+			continue
+		}
+		blobs++
+
+		size := pb.Length - crypto.Extension
+		rtest.Assert(t, size <= 512<<10, "data blob %v is too large: %d", pb.ID.Str(), size)
+	}
+	rtest.Assert(t, blobs >= 16, "expected at least 16 data blobs, got %d", blobs)
 }
 
-func TestTag(t *testing.T) {
+func TestVerifyManifest(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "backup-data.tar.gz")
 	testRunInit(t, env.gopts)
-	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 
-	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ := testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 0,
-		"expected no tags, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original == nil,
-		"expected original ID to be nil, got %v", newest.Original)
-	originalID := *newest.ID
+	datadir := filepath.Join(env.base, "testdata")
+	files := []string{
+		filepath.Join(datadir, "file1"),
+		filepath.Join(datadir, "subdir", "file2"),
+	}
+	rtest.OK(t, os.MkdirAll(filepath.Join(datadir, "subdir"), 0755))
+	for _, filename := range files {
+		rtest.OK(t, appendRandomData(filename, 3<<20))
+	}
 
-	testRunTag(t, TagOptions{SetTags: []string{"NL"}}, env.gopts)
+	testRunBackup(t, "", []string{datadir}, BackupOptions{StoreContentHash: true}, env.gopts)
 	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "NL",
-		"set failed, expected one NL tag, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
-	rtest.Assert(t, *newest.Original == originalID,
-		"expected original ID to be set to the first snapshot id")
 
-	testRunTag(t, TagOptions{AddTags: []string{"CH"}}, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 2 && newest.Tags[0] == "NL" && newest.Tags[1] == "CH",
-		"add failed, expected CH,NL tags, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
-	rtest.Assert(t, *newest.Original == originalID,
-		"expected original ID to be set to the first snapshot id")
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	rtest.OK(t, runVerifyManifest(VerifyManifestOptions{}, gopts, []string{"latest"}))
 
-	testRunTag(t, TagOptions{RemoveTags: []string{"NL"}}, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "CH",
-		"remove failed, expected one CH tag, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
-	rtest.Assert(t, *newest.Original == originalID,
-		"expected original ID to be set to the first snapshot id")
+	manifest := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fields := strings.SplitN(line, "  ", 2)
+		rtest.Assert(t, len(fields) == 2, "invalid manifest line %q", line)
+		manifest[filepath.FromSlash("/"+fields[1])] = fields[0]
+	}
+	rtest.Equals(t, len(files), len(manifest))
 
-	testRunTag(t, TagOptions{AddTags: []string{"US", "RU"}}, env.gopts)
-	testRunTag(t, TagOptions{RemoveTags: []string{"CH", "US", "RU"}}, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 0,
-		"expected no tags, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
-	rtest.Assert(t, *newest.Original == originalID,
-		"expected original ID to be set to the first snapshot id")
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		rtest.OK(t, err)
+		sum := sha256.Sum256(data)
+		rtest.Equals(t, hex.EncodeToString(sum[:]), manifest[filename])
+	}
 
-	// Check special case of removing all tags.
-	testRunTag(t, TagOptions{SetTags: []string{""}}, env.gopts)
-	testRunCheck(t, env.gopts)
-	newest, _ = testRunSnapshots(t, env.gopts)
-	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
-	rtest.Assert(t, len(newest.Tags) == 0,
-		"expected no tags, got %v", newest.Tags)
-	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
-	rtest.Assert(t, *newest.Original == originalID,
-		"expected original ID to be set to the first snapshot id")
+	// the JSON output contains the absolute paths in the snapshot
+	buf.Reset()
+	gopts.JSON = true
+	rtest.OK(t, runVerifyManifest(VerifyManifestOptions{}, gopts, []string{"latest"}))
+
+	dec := json.NewDecoder(buf)
+	entries := 0
+	for dec.More() {
+		var entry manifestEntry
+		rtest.OK(t, dec.Decode(&entry))
+		rtest.Equals(t, manifest[filepath.FromSlash(entry.Path)], entry.SHA256)
+		entries++
+	}
+	rtest.Equals(t, len(files), entries)
 }
 
-func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
-	buf := bytes.NewBuffer(nil)
+func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions) {
+	copyOpts := CopyOptions{
+		Repo:     dstGopts.Repo,
+		password: dstGopts.password,
+	}
 
-	globalOptions.stdout = buf
-	defer func() {
-		globalOptions.stdout = os.Stdout
-	}()
+	rtest.OK(t, runCopy(copyOpts, srcGopts, nil))
+}
 
-	rtest.OK(t, runKey(gopts, []string{"list"}))
+func TestCopy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
 
-	scanner := bufio.NewScanner(buf)
-	exp := regexp.MustCompile(`^ ([a-f0-9]+) `)
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
 
-	IDs := []string{}
-	for scanner.Scan() {
-		if id := exp.FindStringSubmatch(scanner.Text()); id != nil {
-			IDs = append(IDs, id[1])
-		}
+	testRunInit(t, env2.gopts)
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+
+	srcSnapshots := loadSnapshots(t, env.gopts)
+	dstSnapshots := loadSnapshots(t, env2.gopts)
+	rtest.Equals(t, len(srcSnapshots), len(dstSnapshots))
+
+	// the trees are copied without modification
+	for _, dst := range dstSnapshots {
+		rtest.Assert(t, dst.Original != nil, "copied snapshot %v has no original", dst.ID().Str())
+		src, ok := srcSnapshots[*dst.Original]
+		rtest.Assert(t, ok, "original %v of copied snapshot not found", dst.Original.Str())
+		rtest.Equals(t, *src.Tree, *dst.Tree)
 	}
 
-	return IDs
+	stat := dirStats(filepath.Join(env2.repo, "data"))
+	blobs := countBlobs(t, env2.gopts)
+
+	// copying the same snapshots again transfers nothing
+	testRunCopy(t, env.gopts, env2.gopts)
+	rtest.Equals(t, len(srcSnapshots), len(loadSnapshots(t, env2.gopts)))
+	rtest.Equals(t, stat, dirStats(filepath.Join(env2.repo, "data")))
+	rtest.Equals(t, blobs, countBlobs(t, env2.gopts))
+
+	// for a new snapshot of the same data, only the blobs which are new in
+	// the source repository are transferred, i.e. the trees for the changed
+	// parent directories
+	srcBlobs := countBlobs(t, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0")}, opts, env.gopts)
+	newBlobs := countBlobs(t, env.gopts) - srcBlobs
+
+	testRunCopy(t, env.gopts, env2.gopts)
+	rtest.Equals(t, len(srcSnapshots)+1, len(loadSnapshots(t, env2.gopts)))
+	rtest.Equals(t, blobs+newBlobs, countBlobs(t, env2.gopts))
+	testRunCheck(t, env2.gopts)
 }
 
-func testRunKeyAddNewKey(t testing.TB, newPassword string, gopts GlobalOptions) {
-	testKeyNewPassword = newPassword
-	defer func() {
-		testKeyNewPassword = ""
-	}()
+// listBlobs returns the blobs in the index of the repository.
+func listBlobs(t testing.TB, gopts GlobalOptions) restic.BlobSet {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(gopts.ctx))
 
-	rtest.OK(t, runKey(gopts, []string{"add"}))
+	blobs := restic.NewBlobSet()
+	for pb := range repo.Index().Each(gopts.ctx) {
+		blobs.Insert(restic.BlobHandle{ID: pb.ID, Type: pb.Type})
+	}
+	return blobs
 }
 
-func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
-	testKeyNewPassword = newPassword
-	defer func() {
-		testKeyNewPassword = ""
-	}()
+func TestCopyConcurrency(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
 
-	rtest.OK(t, runKey(gopts, []string{"passwd"}))
-}
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0")}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+
+	// copy runs the copy command with the given number of workers to a new
+	// repository and returns the trees of the copied snapshots by their
+	// original ID, together with the blobs in the repository
+	copy := func(readers, writers int) (map[restic.ID]restic.ID, restic.BlobSet) {
+		env2, cleanup2 := withTestEnvironment(t)
+		defer cleanup2()
+
+		testRunInit(t, env2.gopts)
+		copyOpts := CopyOptions{
+			Repo:             env2.gopts.Repo,
+			password:         env2.gopts.password,
+			ReadConcurrency:  readers,
+			WriteConcurrency: writers,
+		}
+		rtest.OK(t, runCopy(copyOpts, env.gopts, nil))
+		testRunCheck(t, env2.gopts)
 
-func testRunKeyRemove(t testing.TB, gopts GlobalOptions, IDs []string) {
-	t.Logf("remove %d keys: %q\n", len(IDs), IDs)
-	for _, id := range IDs {
-		rtest.OK(t, runKey(gopts, []string{"remove", id}))
+		trees := make(map[restic.ID]restic.ID)
+		for _, sn := range loadSnapshots(t, env2.gopts) {
+			trees[*sn.Original] = *sn.Tree
+		}
+		return trees, listBlobs(t, env2.gopts)
 	}
+
+	serialTrees, serialBlobs := copy(1, 1)
+	concurrentTrees, concurrentBlobs := copy(8, 4)
+
+	rtest.Equals(t, len(loadSnapshots(t, env.gopts)), len(serialTrees))
+	rtest.Equals(t, serialTrees, concurrentTrees)
+	rtest.Equals(t, listBlobs(t, env.gopts), serialBlobs)
+	rtest.Equals(t, serialBlobs, concurrentBlobs)
 }
 
-func TestKeyAddRemove(t *testing.T) {
-	passwordList := []string{
-		"OnnyiasyatvodsEvVodyawit",
-		"raicneirvOjEfEigonOmLasOd",
+func BenchmarkCopy(b *testing.B) {
+	env, cleanup := withTestEnvironment(b)
+	defer cleanup()
+
+	testRunInit(b, env.gopts)
+	for i := 0; i < 20; i++ {
+		rtest.OK(b, appendRandomData(filepath.Join(env.testdata, fmt.Sprintf("file%d", i)), 2<<20))
+	}
+	testRunBackup(b, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				env2, cleanup2 := withTestEnvironment(b)
+				testRunInit(b, env2.gopts)
+				copyOpts := CopyOptions{
+					Repo:             env2.gopts.Repo,
+					password:         env2.gopts.password,
+					ReadConcurrency:  workers,
+					WriteConcurrency: workers,
+				}
+				b.StartTimer()
+
+				rtest.OK(b, runCopy(copyOpts, env.gopts, nil))
+
+				b.StopTimer()
+				cleanup2()
+				b.StartTimer()
+			}
+		})
 	}
+}
 
+func TestCopyLatestPerHost(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
 
 	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
 
-	testRunKeyPasswd(t, "geheim2", env.gopts)
-	env.gopts.password = "geheim2"
-	t.Logf("changed password to %q", env.gopts.password)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{Host: "a"}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, BackupOptions{Host: "a"}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "0")}, BackupOptions{Host: "b"}, env.gopts)
 
-	for _, newPassword := range passwordList {
-		testRunKeyAddNewKey(t, newPassword, env.gopts)
-		t.Logf("added new password %q", newPassword)
-		env.gopts.password = newPassword
-		testRunKeyRemove(t, env.gopts, testRunKeyListOtherIDs(t, env.gopts))
+	srcSnapshots := loadSnapshots(t, env.gopts)
+	want := make(map[string]restic.ID)
+	for id, sn := range srcSnapshots {
+		if latest, ok := want[sn.Hostname]; !ok || sn.Time.After(srcSnapshots[latest].Time) {
+			want[sn.Hostname] = id
+		}
 	}
 
-	env.gopts.password = passwordList[len(passwordList)-1]
-	t.Logf("testing access with last password %q\n", env.gopts.password)
-	rtest.OK(t, runKey(env.gopts, []string{"list"}))
-	testRunCheck(t, env.gopts)
-}
-
-func testFileSize(filename string, size int64) error {
-	fi, err := os.Stat(filename)
-	if err != nil {
-		return err
+	testRunInit(t, env2.gopts)
+	copyOpts := CopyOptions{
+		Repo:     env2.gopts.Repo,
+		password: env2.gopts.password,
+		Latest:   true,
+		GroupBy:  "host",
 	}
+	rtest.OK(t, runCopy(copyOpts, env.gopts, nil))
+	testRunCheck(t, env2.gopts)
 
-	if fi.Size() != size {
-		return errors.Fatalf("wrong file size for %v: expected %v, got %v", filename, size, fi.Size())
+	got := make(map[string]restic.ID)
+	for _, sn := range loadSnapshots(t, env2.gopts) {
+		got[sn.Hostname] = *sn.Original
 	}
+	rtest.Equals(t, want, got)
 
-	return nil
-}
+	// the data only referenced by the older snapshot of host a is not copied
+	rtest.Assert(t, countBlobs(t, env2.gopts) < countBlobs(t, env.gopts),
+		"all blobs were copied to the destination repository")
 
-func TestRestoreFilter(t *testing.T) {
-	testfiles := []struct {
-		name string
-		size uint
-	}{
-		{"testfile1.c", 100},
-		{"testfile2.exe", 101},
-		{"subdir1/subdir2/testfile3.docx", 102},
-		{"subdir1/subdir2/testfile4.c", 102},
-	}
+	// copying the remaining snapshot later completes the repository
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+	rtest.Equals(t, 3, len(loadSnapshots(t, env2.gopts)))
+	rtest.Equals(t, countBlobs(t, env.gopts), countBlobs(t, env2.gopts))
+}
 
+func TestAuthenticateBlobIDs(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	testRunInit(t, env.gopts)
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(InitOptions{AuthenticateBlobIDs: true}, env.gopts, nil))
 
-	for _, testFile := range testfiles {
-		p := filepath.Join(env.testdata, testFile.name)
-		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
-		rtest.OK(t, appendRandomData(p, testFile.size))
-	}
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{}, env.gopts)
+	testRunBackup(t, env.testdata, []string{"0/0/9"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
 
-	opts := BackupOptions{}
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
 
-	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	// restoring and repacking decrypt the blobs
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, []string{filepath.Join(env.testdata, "0")}, "")
+	rtest.Assert(t, directoriesEqualContents(filepath.Join(env.testdata, "0"), filepath.Join(restoredir, "0")),
+		"directories are not equal")
+
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	testRunPrune(t, env.gopts)
 	testRunCheck(t, env.gopts)
+}
 
-	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+func TestInitKDF(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
 
-	// no restore filter should restore all files
-	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore0"), snapshotID)
-	for _, testFile := range testfiles {
-		rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", testFile.name), int64(testFile.size)))
+	restic.TestDisableCheckPolynomial(t)
+	initOpts := InitOptions{KDF: KDFOptions{KDF: crypto.KDFArgon2id, Argon2Time: 1, Argon2Memory: 1, Argon2Threads: 1}}
+	rtest.OK(t, runInit(initOpts, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	key, err := repository.LoadKey(env.gopts.ctx, repo, repo.KeyName())
+	rtest.OK(t, err)
+	rtest.Equals(t, crypto.KDFArgon2id, key.KDF)
+
+	initOpts.KDF.KDF = "bcrypt"
+	err = runInit(initOpts, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected an error for an unknown KDF")
+}
+
+func TestBackupRepositoryDefaults(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	initOpts := InitOptions{DefaultHost: "fleet", DefaultTags: []string{"env:prod"}}
+	rtest.OK(t, runInit(initOpts, env.gopts, nil))
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{}, env.gopts)
+	sn, _ := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, "fleet", sn.Hostname)
+	rtest.Equals(t, []string{"env:prod"}, sn.Tags)
+
+	// tags given on the command line are added to the default tags, an
+	// explicit host replaces the default host
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{Host: "other", Tags: []string{"daily", "env:prod"}}, env.gopts)
+	sn, _ = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, "other", sn.Hostname)
+	rtest.Equals(t, []string{"daily", "env:prod"}, sn.Tags)
+
+	// the defaults can be changed later on
+	rtest.OK(t, runConfig(ConfigOptions{DefaultTags: []string{"env:staging"}, ClearDefaultHost: true}, env.gopts))
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{Host: "another"}, env.gopts)
+	sn, _ = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, "another", sn.Hostname)
+	rtest.Equals(t, []string{"env:staging"}, sn.Tags)
+
+	err := runConfig(ConfigOptions{DefaultHost: "x", ClearDefaultHost: true}, env.gopts)
+	rtest.Assert(t, err != nil, "expected an error for conflicting options")
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupSplitTrees(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	// split trees must be enabled for the repository first
+	err := runBackup(BackupOptions{SplitTrees: true}, env.gopts, nil, []string{env.testdata})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "--enable-split-trees"),
+		"expected an error for a repository without split trees, got %v", err)
+
+	rtest.OK(t, runConfig(ConfigOptions{EnableSplitTrees: true}, env.gopts))
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.Config().SplitTrees, "split trees are not enabled in the config")
+	rtest.Equals(t, uint(2), repo.Config().Version)
+
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{SplitTrees: true}, env.gopts)
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupMetadataOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{MetadataOnly: true}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	sn, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, sn.MetadataOnly, "snapshot is not marked as metadata-only")
+	rtest.Assert(t, len(testRunLs(t, env.gopts, sn.ID.String())) > 1, "metadata-only snapshot contains no files")
+
+	opts := RestoreOptions{Target: filepath.Join(env.base, "restore")}
+	err := runRestore(opts, env.gopts, []string{sn.ID.String()})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "contains only metadata"),
+		"expected an error restoring a metadata-only snapshot, got %v", err)
+}
+
+func TestCheckIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, runCheckIndex(env.gopts, nil))
+
+	packs := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packs) > 0, "no packs found")
+	name := packs[0].String()
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", name[:2], name)))
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	gopts.JSON = true
+	err := runCheckIndex(gopts, nil)
+	rtest.Assert(t, err != nil, "check-index did not report the missing pack")
+
+	var result struct {
+		MissingPacks []struct {
+			ID restic.ID `json:"id"`
+		} `json:"missing_packs"`
+		UnindexedPacks []interface{} `json:"unindexed_packs"`
 	}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &result))
+	rtest.Equals(t, 1, len(result.MissingPacks))
+	rtest.Equals(t, packs[0], result.MissingPacks[0].ID)
+	rtest.Equals(t, 0, len(result.UnindexedPacks))
+}
 
-	for i, pat := range []string{"*.c", "*.exe", "*", "*file3*"} {
-		base := filepath.Join(env.base, fmt.Sprintf("restore%d", i+1))
-		testRunRestoreExcludes(t, env.gopts, base, snapshotID, []string{pat})
-		for _, testFile := range testfiles {
-			err := testFileSize(filepath.Join(base, "testdata", testFile.name), int64(testFile.size))
-			if ok, _ := filter.Match(pat, filepath.Base(testFile.name)); !ok {
-				rtest.OK(t, err)
-			} else {
-				rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
-					"expected %v to not exist in restore step %v, but it exists, err %v", testFile.name, i+1, err)
+func testRunCat(t testing.TB, opts CatOptions, gopts GlobalOptions, args ...string) []byte {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runCat(opts, gopts, args))
+	return buf.Bytes()
+}
+
+func TestCat(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, env.testdata, []string{"0"}, BackupOptions{}, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, snapshotIDs[0])
+	rtest.OK(t, err)
+
+	want, err := json.MarshalIndent(sn, "", "  ")
+	rtest.OK(t, err)
+	got := testRunCat(t, CatOptions{}, env.gopts, "snapshot", snapshotIDs[0].String())
+	rtest.Equals(t, string(want)+"\n", string(got))
+
+	tree, err := repo.LoadTree(env.gopts.ctx, *sn.Tree)
+	rtest.OK(t, err)
+
+	want, err = json.MarshalIndent(tree, "", "  ")
+	rtest.OK(t, err)
+	got = testRunCat(t, CatOptions{}, env.gopts, "tree", sn.Tree.String())
+	rtest.Equals(t, string(want)+"\n", string(got))
+
+	// find a data blob below the root tree
+	var blobID restic.ID
+	for blobID.IsNull() {
+		node := tree.Nodes[0]
+		if node.Type == "file" && len(node.Content) > 0 {
+			blobID = node.Content[0]
+			break
+		}
+		rtest.Assert(t, node.Type == "dir", "unexpected node %v", node.Name)
+		tree, err = repo.LoadTree(env.gopts.ctx, *node.Subtree)
+		rtest.OK(t, err)
+	}
+
+	blob := testRunCat(t, CatOptions{}, env.gopts, "blob", blobID.String())
+	rtest.Equals(t, blobID, restic.Hash(blob))
+
+	packs, found := repo.Index().Lookup(blobID, restic.DataBlob)
+	rtest.Assert(t, found, "blob %v not found in index", blobID.Str())
+	got = testRunCat(t, CatOptions{Pack: packs[0].PackID.String()}, env.gopts, "blob", blobID.String())
+	rtest.Equals(t, blob, got)
+}
+
+// loadSnapshots returns all snapshots in the repository by ID.
+func loadSnapshots(t testing.TB, gopts GlobalOptions) map[restic.ID]*restic.Snapshot {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+
+	snapshots, err := restic.LoadAllSnapshots(gopts.ctx, repo)
+	rtest.OK(t, err)
+
+	result := make(map[restic.ID]*restic.Snapshot)
+	for _, sn := range snapshots {
+		result[*sn.ID()] = sn
+	}
+	return result
+}
+
+// countBlobs returns the number of blobs in the index of the repository.
+func countBlobs(t testing.TB, gopts GlobalOptions) int {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(gopts.ctx))
+
+	return int(repo.Index().Count(restic.DataBlob) + repo.Index().Count(restic.TreeBlob))
+}
+
+func TestBackupTags(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 0,
+		"expected no tags, got %v", newest.Tags)
+	parent := newest
+
+	opts.Tags = []string{"NL"}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "NL",
+		"expected one NL tag, got %v", newest.Tags)
+	// Tagged backup should have untagged backup as parent.
+	rtest.Assert(t, parent.ID.Equal(*newest.Parent),
+		"expected parent to be %v, got %v", parent.ID, newest.Parent)
+}
+
+// testRunBackupSummary runs backup with --json and returns the summary.
+func testRunBackupSummary(t testing.TB, dir string, target []string, opts BackupOptions, gopts GlobalOptions) (summary map[string]interface{}) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	testRunBackup(t, dir, target, opts, gopts)
+
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var msg map[string]interface{}
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &msg))
+		if msg["message_type"] == "summary" {
+			rtest.Assert(t, summary == nil, "more than one summary printed")
+			summary = msg
+		}
+	}
+	rtest.OK(t, sc.Err())
+	rtest.Assert(t, summary != nil, "no summary printed")
+
+	return summary
+}
+
+func TestBackupJSONSummary(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for i, data := range []string{"foo", "bar", "a longer content"} {
+		filename := filepath.Join(env.testdata, fmt.Sprintf("file%d", i))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte(data), 0644))
+	}
+
+	summary := testRunBackupSummary(t, env.testdata, []string{"."}, BackupOptions{NoScan: true}, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, newest.ID.Str(), summary["snapshot_id"])
+
+	rtest.Equals(t, 3.0, summary["files_new"])
+	rtest.Equals(t, 0.0, summary["files_changed"])
+	rtest.Equals(t, 0.0, summary["files_unmodified"])
+	rtest.Equals(t, 3.0, summary["total_files_processed"])
+	rtest.Equals(t, 22.0, summary["total_bytes_processed"])
+	rtest.Equals(t, 3.0, summary["data_blobs"])
+	rtest.Assert(t, summary["tree_blobs"].(float64) > 0, "no tree blobs added")
+
+	// all new blobs are encrypted, which adds crypto.Extension bytes each
+	blobs := summary["data_blobs"].(float64) + summary["tree_blobs"].(float64)
+	rtest.Equals(t, summary["data_added"].(float64)+blobs*crypto.Extension, summary["data_added_in_repo"])
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file0"), []byte("changed"), 0644))
+
+	summary = testRunBackupSummary(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	rtest.Equals(t, 0.0, summary["files_new"])
+	rtest.Equals(t, 1.0, summary["files_changed"])
+	rtest.Equals(t, 2.0, summary["files_unmodified"])
+	rtest.Equals(t, 26.0, summary["total_bytes_processed"])
+	rtest.Equals(t, 1.0, summary["data_blobs"])
+}
+
+func TestBackupEventSocket(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for i, data := range []string{"foo", "bar"} {
+		filename := filepath.Join(env.testdata, fmt.Sprintf("file%d", i))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte(data), 0644))
+	}
+
+	// the path of a Unix domain socket must be short
+	socketDir, err := ioutil.TempDir("", "restic-events-")
+	rtest.OK(t, err)
+	defer func() {
+		_ = os.RemoveAll(socketDir)
+	}()
+	socket := filepath.Join(socketDir, "events.sock")
+
+	type result struct {
+		events []map[string]interface{}
+		err    error
+	}
+	resultCh := make(chan result, 1)
+
+	go func() {
+		var res result
+		defer func() { resultCh <- res }()
+
+		var conn net.Conn
+		for i := 0; i < 1000; i++ {
+			conn, res.err = net.Dial("unix", socket)
+			if res.err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if res.err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var ev map[string]interface{}
+			if res.err = json.Unmarshal(sc.Bytes(), &ev); res.err != nil {
+				return
 			}
+			res.events = append(res.events, ev)
 		}
+		res.err = sc.Err()
+	}()
+
+	opts := BackupOptions{EventSocket: socket, EventSocketWait: true}
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+
+	res := <-resultCh
+	rtest.OK(t, res.err)
+	rtest.Assert(t, len(res.events) > 0, "no events received")
+
+	summary := res.events[len(res.events)-1]
+	rtest.Equals(t, "summary", summary["message_type"])
+	rtest.Equals(t, 2.0, summary["files_new"])
+
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, newest.ID.Str(), summary["snapshot_id"])
+}
+
+func TestBackupParentOverride(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datafile := filepath.Join(env.testdata, "file")
+	rtest.OK(t, ioutil.WriteFile(datafile, []byte("content 1"), 0644))
+	fi, err := os.Stat(datafile)
+	rtest.OK(t, err)
+
+	// the status change time is only ignored together with the inode
+	opts := BackupOptions{Host: "old-host", IgnoreInode: true}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	parent, _ := testRunSnapshots(t, env.gopts)
+
+	// modify the file without changing its size and modification time, so
+	// that it is only read again if it is not found in the parent snapshot
+	rtest.OK(t, ioutil.WriteFile(datafile, []byte("content 2"), 0644))
+	rtest.OK(t, os.Chtimes(datafile, fi.ModTime(), fi.ModTime()))
+
+	restoredContent := func(id restic.ID) string {
+		restoredir := filepath.Join(env.base, "restore-"+id.Str())
+		testRunRestore(t, env.gopts, restoredir, id)
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, datafile))
+		rtest.OK(t, err)
+		return string(buf)
 	}
+
+	opts = BackupOptions{Host: "new-host", ParentHost: "old-host", IgnoreInode: true}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent != nil && parent.ID.Equal(*newest.Parent),
+		"expected parent to be %v, got %v", parent.ID, newest.Parent)
+	rtest.Equals(t, "content 1", restoredContent(*newest.ID))
+
+	opts = BackupOptions{Host: "other-host", IgnoreInode: true}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent == nil, "expected no parent, got %v", newest.Parent)
+	rtest.Equals(t, "content 2", restoredContent(*newest.ID))
+
+	opts = BackupOptions{Host: "old-host", ParentPaths: []string{env.testdata}}
+	testRunBackup(t, "", []string{datafile}, opts, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Parent != nil && parent.ID.Equal(*newest.Parent),
+		"expected parent to be %v, got %v", parent.ID, newest.Parent)
+
+	opts = BackupOptions{Parent: parent.ID.String(), ParentHost: "old-host"}
+	err = runBackup(opts, env.gopts, nil, []string{env.testdata})
+	rtest.Assert(t, err != nil, "expected error for --parent with --parent-host")
+}
+
+func TestForgetKeepTag(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{Tags: []string{"permanent"}}, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	permanent := *newest.ID
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	}
+	newest, _ = testRunSnapshots(t, env.gopts)
+	latest := *newest.ID
+
+	keepTags := restic.TagLists{restic.TagList{"permanent"}}
+
+	// the permanent snapshot does not count for --keep-last
+	rtest.OK(t, runForget(ForgetOptions{Last: 1, KeepTags: keepTags}, env.gopts, nil))
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapmap) == 2, "expected 2 snapshots, got %v", len(snapmap))
+	_, ok := snapmap[permanent]
+	rtest.Assert(t, ok, "permanent snapshot %v was removed", permanent.Str())
+	_, ok = snapmap[latest]
+	rtest.Assert(t, ok, "latest snapshot %v was removed", latest.Str())
+
+	// snapshots specified explicitly are not removed either
+	rtest.OK(t, runForget(ForgetOptions{KeepTags: keepTags}, env.gopts, []string{permanent.String(), latest.String()}))
+	_, snapmap = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapmap) == 1, "expected 1 snapshot, got %v", len(snapmap))
+	_, ok = snapmap[permanent]
+	rtest.Assert(t, ok, "permanent snapshot %v was removed", permanent.Str())
+}
+
+// testMarkCheckpoint replaces the snapshot id with a copy which is marked as
+// the checkpoint of an interrupted backup and returns the ID of the copy.
+func testMarkCheckpoint(t testing.TB, gopts GlobalOptions, id restic.ID) restic.ID {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+
+	sn, err := restic.LoadSnapshot(gopts.ctx, repo, id)
+	rtest.OK(t, err)
+	sn.Checkpoint = true
+
+	checkpointID, err := repo.SaveJSONUnpacked(gopts.ctx, restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Backend().Remove(gopts.ctx, restic.Handle{Type: restic.SnapshotFile, Name: id.String()}))
+
+	return checkpointID
+}
+
+func TestCheckpointSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	p := filepath.Join(env.testdata, "testfile.c")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+	rtest.OK(t, appendRandomData(p, 100))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	complete, _ := testRunSnapshots(t, env.gopts)
+	completeID := *complete.ID
+
+	// the second backup is interrupted and leaves a checkpoint behind
+	rtest.OK(t, os.Remove(p))
+	rtest.OK(t, appendRandomData(p, 101))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	checkpointID := testMarkCheckpoint(t, env.gopts, *newest.ID)
+
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, snapmap[checkpointID].Checkpoint, "checkpoint %v not marked in snapshots output", checkpointID.Str())
+
+	// latest refers to the last complete snapshot
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore"), nil, "")
+	rtest.OK(t, testFileSize(filepath.Join(env.base, "restore", "testdata", "testfile.c"), int64(100)))
+
+	// the checkpoint does not replace the last complete snapshot
+	rtest.OK(t, runForget(ForgetOptions{Last: 1}, env.gopts, nil))
+	_, snapmap = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapmap))
+	_, ok := snapmap[completeID]
+	rtest.Assert(t, ok, "complete snapshot %v was removed", completeID.Str())
+}
+
+func testRunTag(t testing.TB, opts TagOptions, gopts GlobalOptions) {
+	rtest.OK(t, runTag(opts, gopts, []string{}))
+}
+
+func TestTag(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 0,
+		"expected no tags, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original == nil,
+		"expected original ID to be nil, got %v", newest.Original)
+	originalID := *newest.ID
+
+	testRunTag(t, TagOptions{SetTags: []string{"NL"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "NL",
+		"set failed, expected one NL tag, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
+	rtest.Assert(t, *newest.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+
+	testRunTag(t, TagOptions{AddTags: []string{"CH"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 2 && newest.Tags[0] == "NL" && newest.Tags[1] == "CH",
+		"add failed, expected CH,NL tags, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
+	rtest.Assert(t, *newest.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+
+	testRunTag(t, TagOptions{RemoveTags: []string{"NL"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 1 && newest.Tags[0] == "CH",
+		"remove failed, expected one CH tag, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
+	rtest.Assert(t, *newest.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+
+	testRunTag(t, TagOptions{AddTags: []string{"US", "RU"}}, env.gopts)
+	testRunTag(t, TagOptions{RemoveTags: []string{"CH", "US", "RU"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 0,
+		"expected no tags, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
+	rtest.Assert(t, *newest.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+
+	// Check special case of removing all tags.
+	testRunTag(t, TagOptions{SetTags: []string{""}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, len(newest.Tags) == 0,
+		"expected no tags, got %v", newest.Tags)
+	rtest.Assert(t, newest.Original != nil, "expected original snapshot id, got nil")
+	rtest.Assert(t, *newest.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+}
+
+func testRunRewrite(t testing.TB, opts RewriteOptions, gopts GlobalOptions, args []string) {
+	rtest.OK(t, runRewrite(opts, gopts, args))
+}
+
+func TestRewriteDescription(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{Description: "first backup"}, env.gopts)
+	testRunCheck(t, env.gopts)
+	original, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, original != nil, "expected a new backup, got nil")
+	rtest.Equals(t, "first backup", original.Description)
+
+	// the rewritten snapshot has the same timestamp, so find it by its ID
+	newSnapshot := func(snapmap map[restic.ID]Snapshot, old ...*Snapshot) Snapshot {
+		for _, sn := range old {
+			delete(snapmap, *sn.ID)
+		}
+		rtest.Assert(t, len(snapmap) == 1, "expected one new snapshot, got %v", len(snapmap))
+		for _, sn := range snapmap {
+			return sn
+		}
+		return Snapshot{}
+	}
+
+	// the original snapshot is kept by default
+	testRunRewrite(t, RewriteOptions{Description: "changed", SetDescription: true}, env.gopts, []string{original.ID.String()})
+	testRunCheck(t, env.gopts)
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 2, len(snapmap))
+	rewritten := newSnapshot(snapmap, original)
+	rtest.Equals(t, "changed", rewritten.Description)
+	rtest.Equals(t, *original.Tree, *rewritten.Tree)
+	rtest.Assert(t, rewritten.Original != nil && *rewritten.Original == *original.ID,
+		"expected original ID to be set to the first snapshot id")
+
+	// remove the description and the rewritten snapshot
+	testRunRewrite(t, RewriteOptions{SetDescription: true, Forget: true}, env.gopts, []string{rewritten.ID.String()})
+	testRunCheck(t, env.gopts)
+	_, snapmap = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 2, len(snapmap))
+	_, ok := snapmap[*rewritten.ID]
+	rtest.Assert(t, !ok, "rewritten snapshot %v was not removed", rewritten.ID.Str())
+	sn := newSnapshot(snapmap, original)
+	rtest.Equals(t, "", sn.Description)
+	rtest.Equals(t, *original.Tree, *sn.Tree)
+	rtest.Assert(t, sn.Original != nil && *sn.Original == *original.ID,
+		"expected original ID to be retained")
+}
+
+func TestRewriteExclude(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{Description: "secret"}, env.gopts)
+	original, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, original != nil, "expected a new backup, got nil")
+
+	var excluded string
+	for _, line := range testRunLs(t, env.gopts, original.ID.String()) {
+		if strings.HasSuffix(line, "/0/tests") {
+			excluded = line
+		}
+	}
+	rtest.Assert(t, excluded != "", "directory to exclude not found in snapshot")
+
+	// paths which are not in the snapshot leave it unchanged
+	testRunRewrite(t, RewriteOptions{Excludes: []string{"/nonexistent"}}, env.gopts, []string{original.ID.String()})
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapmap))
+
+	// the data of the removed files is deleted by prune
+	testRunRewrite(t, RewriteOptions{Excludes: []string{excluded}, Forget: true}, env.gopts, []string{original.ID.String()})
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+	rewritten, snapmap := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapmap))
+	rtest.Assert(t, *rewritten.ID != *original.ID, "original snapshot was not removed")
+	rtest.Assert(t, *rewritten.Tree != *original.Tree, "tree was not rewritten")
+	rtest.Assert(t, rewritten.Original != nil && *rewritten.Original == *original.ID,
+		"expected original ID to be set to the first snapshot id")
+	rtest.Assert(t, rewritten.Time.Equal(original.Time), "time changed from %v to %v", original.Time, rewritten.Time)
+	rtest.Equals(t, original.Description, rewritten.Description)
+	rtest.Equals(t, original.Paths, rewritten.Paths)
+
+	for _, line := range testRunLs(t, env.gopts, rewritten.ID.String()) {
+		rtest.Assert(t, line != excluded && !strings.HasPrefix(line, excluded+"/"),
+			"excluded path %v found in rewritten snapshot", line)
+	}
+
+	// all other files are restored unchanged
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, *rewritten.ID)
+	rtest.Assert(t, directoriesEqualContents(filepath.Join(env.testdata, "0", "0"), filepath.Join(restoredir, "testdata", "0", "0")),
+		"directories are not equal")
+	_, err := os.Lstat(filepath.Join(restoredir, "testdata", "0", "tests"))
+	rtest.Assert(t, os.IsNotExist(err), "excluded directory was restored: %v", err)
+}
+
+func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
+	buf := bytes.NewBuffer(nil)
+
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runKey(gopts, []string{"list"}))
+
+	scanner := bufio.NewScanner(buf)
+	exp := regexp.MustCompile(`^ ([a-f0-9]+) `)
+
+	IDs := []string{}
+	for scanner.Scan() {
+		if id := exp.FindStringSubmatch(scanner.Text()); id != nil {
+			IDs = append(IDs, id[1])
+		}
+	}
+
+	return IDs
+}
+
+func testRunKeyAddNewKey(t testing.TB, newPassword string, gopts GlobalOptions) {
+	testKeyNewPassword = newPassword
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	rtest.OK(t, runKey(gopts, []string{"add"}))
+}
+
+func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
+	testKeyNewPassword = newPassword
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	rtest.OK(t, runKey(gopts, []string{"passwd"}))
+}
+
+func testRunKeyRemove(t testing.TB, gopts GlobalOptions, IDs []string) {
+	t.Logf("remove %d keys: %q\n", len(IDs), IDs)
+	for _, id := range IDs {
+		rtest.OK(t, runKey(gopts, []string{"remove", id}))
+	}
+}
+
+func TestKeyAddRemove(t *testing.T) {
+	passwordList := []string{
+		"OnnyiasyatvodsEvVodyawit",
+		"raicneirvOjEfEigonOmLasOd",
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	testRunKeyPasswd(t, "geheim2", env.gopts)
+	env.gopts.password = "geheim2"
+	t.Logf("changed password to %q", env.gopts.password)
+
+	for _, newPassword := range passwordList {
+		testRunKeyAddNewKey(t, newPassword, env.gopts)
+		t.Logf("added new password %q", newPassword)
+		env.gopts.password = newPassword
+		testRunKeyRemove(t, env.gopts, testRunKeyListOtherIDs(t, env.gopts))
+	}
+
+	env.gopts.password = passwordList[len(passwordList)-1]
+	t.Logf("testing access with last password %q\n", env.gopts.password)
+	rtest.OK(t, runKey(env.gopts, []string{"list"}))
+	testRunCheck(t, env.gopts)
+}
+
+func testFileSize(filename string, size int64) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() != size {
+		return errors.Fatalf("wrong file size for %v: expected %v, got %v", filename, size, fi.Size())
+	}
+
+	return nil
+}
+
+func TestRestoreFilter(t *testing.T) {
+	testfiles := []struct {
+		name string
+		size uint
+	}{
+		{"testfile1.c", 100},
+		{"testfile2.exe", 101},
+		{"subdir1/subdir2/testfile3.docx", 102},
+		{"subdir1/subdir2/testfile4.c", 102},
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, testFile := range testfiles {
+		p := filepath.Join(env.testdata, testFile.name)
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, testFile.size))
+	}
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	// no restore filter should restore all files
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore0"), snapshotID)
+	for _, testFile := range testfiles {
+		rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", testFile.name), int64(testFile.size)))
+	}
+
+	for i, pat := range []string{"*.c", "*.exe", "*", "*file3*"} {
+		base := filepath.Join(env.base, fmt.Sprintf("restore%d", i+1))
+		testRunRestoreExcludes(t, env.gopts, base, snapshotID, []string{pat})
+		for _, testFile := range testfiles {
+			err := testFileSize(filepath.Join(base, "testdata", testFile.name), int64(testFile.size))
+			if ok, _ := filter.Match(pat, filepath.Base(testFile.name)); !ok {
+				rtest.OK(t, err)
+			} else {
+				rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
+					"expected %v to not exist in restore step %v, but it exists, err %v", testFile.name, i+1, err)
+			}
+		}
+	}
+}
+
+func TestRestoreRegexFilter(t *testing.T) {
+	testfiles := []string{
+		"testfile1.c",
+		"testfile2.EXE",
+		"subdir1/subdir2/testfile3.docx",
+		"subdir1/subdir2/testfile4.c",
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, name := range testfiles {
+		p := filepath.Join(env.testdata, name)
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, 100))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	var tests = []struct {
+		opts     RestoreOptions
+		restored []string
+	}{
+		{
+			RestoreOptions{ExcludeRegex: []string{`\.c$`}},
+			[]string{"testfile2.EXE", "subdir1/subdir2/testfile3.docx"},
+		},
+		{
+			RestoreOptions{ExcludeRegex: []string{`\.exe$`}},
+			testfiles,
+		},
+		{
+			RestoreOptions{IExcludeRegex: []string{`\.exe$`}, Exclude: []string{"*.docx"}},
+			[]string{"testfile1.c", "subdir1/subdir2/testfile4.c"},
+		},
+		{
+			RestoreOptions{IncludeRegex: []string{`/subdir2/.*\.c$`}},
+			[]string{"subdir1/subdir2/testfile4.c"},
+		},
+		{
+			RestoreOptions{IIncludeRegex: []string{`^/testdata/TESTFILE[12]`}},
+			[]string{"testfile1.c", "testfile2.EXE"},
+		},
+	}
+
+	for i, test := range tests {
+		base := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		opts := test.opts
+		opts.Target = base
+		rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotID.String()}))
+
+		restored := make(map[string]bool)
+		for _, name := range test.restored {
+			restored[name] = true
+		}
+
+		for _, name := range testfiles {
+			_, err := os.Lstat(filepath.Join(base, "testdata", filepath.FromSlash(name)))
+			if restored[name] {
+				rtest.OK(t, err)
+			} else {
+				rtest.Assert(t, os.IsNotExist(err), "test %d: file %v was restored, err %v", i, name, err)
+			}
+		}
+	}
+
+	err := runRestore(RestoreOptions{Target: env.base, ExcludeRegex: []string{"foo("}}, env.gopts, []string{snapshotID.String()})
+	rtest.Assert(t, err != nil && errors.IsFatal(errors.Cause(err)), "expected fatal error for invalid expression, got %v", err)
+}
+
+func TestRestore(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for i := 0; i < 10; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("foo/bar/testfile%v", i))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, uint(mrand.Intn(2<<21))))
+	}
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// Restore latest without any filters
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, filepath.Base(env.testdata))),
+		"directories are not equal")
+}
+
+func TestRestoreJournal(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for i := 0; i < 3; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("testfile%v", i))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, 1000))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	// simulate an interrupted restore which restored testfile0 completely
+	restoredir := filepath.Join(env.base, "restore")
+	rtest.OK(t, os.MkdirAll(filepath.Join(restoredir, "testdata"), 0700))
+	marker := bytes.Repeat([]byte("x"), 1000)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(restoredir, "testdata", "testfile0"), marker, 0600))
+
+	journal, err := restorer.OpenJournal(restoredir, snapshotID)
+	rtest.OK(t, err)
+	rtest.OK(t, journal.Add(filepath.Join(string(filepath.Separator), "testdata", "testfile0")))
+	rtest.OK(t, journal.Close())
+
+	opts := RestoreOptions{Target: restoredir, Journal: true}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotID.String()}))
+
+	// testfile0 was skipped, the other files were restored
+	buf, err := ioutil.ReadFile(filepath.Join(restoredir, "testdata", "testfile0"))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(buf, marker), "file recorded in the journal was restored again")
+	for i := 1; i < 3; i++ {
+		name := fmt.Sprintf("testfile%v", i)
+		want, err := ioutil.ReadFile(filepath.Join(env.testdata, name))
+		rtest.OK(t, err)
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, "testdata", name))
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf, want), "file %v was not restored", name)
+	}
+
+	// the journal is removed after a successful restore
+	_, err = os.Lstat(journal.Path())
+	rtest.Assert(t, os.IsNotExist(err), "journal was not removed: %v", err)
+}
+
+func TestRestoreStdout(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for i := 0; i < 2; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("foo/testfile%v", i))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, uint(2<<20+i)))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	opts := RestoreOptions{Include: []string{"/testdata/foo/testfile1"}, Stdout: true}
+	rtest.OK(t, runRestore(opts, gopts, []string{snapshotID.String()}))
+
+	want, err := ioutil.ReadFile(filepath.Join(env.testdata, "foo", "testfile1"))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(buf.Bytes(), want), "content written to stdout differs, got %d bytes, want %d bytes", buf.Len(), len(want))
+
+	// more than one file matches the pattern
+	buf.Reset()
+	opts.Include = []string{"/testdata/foo/testfile*"}
+	err = runRestore(opts, gopts, []string{snapshotID.String()})
+	rtest.Assert(t, err != nil, "expected an error when more than one file is selected")
+	rtest.Equals(t, 0, buf.Len())
+
+	opts = RestoreOptions{Include: []string{"/testdata/foo/testfile1"}, Stdout: true, Target: env.base}
+	err = runRestore(opts, gopts, []string{snapshotID.String()})
+	rtest.Assert(t, err != nil, "expected an error for --stdout with --target")
+}
+
+func TestRestoreLatest(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	p := filepath.Join(env.testdata, "testfile.c")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+	rtest.OK(t, appendRandomData(p, 100))
+
+	opts := BackupOptions{}
+
+	// chdir manually here so we can get the current directory. This is not the
+	// same as the temp dir returned by ioutil.TempDir() on darwin.
+	back := fs.TestChdir(t, filepath.Dir(env.testdata))
+	defer back()
+
+	curdir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRunBackup(t, "", []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	os.Remove(p)
+	rtest.OK(t, appendRandomData(p, 101))
+	testRunBackup(t, "", []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// Restore latest without any filters
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore0"), nil, "")
+	rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", "testfile.c"), int64(101)))
+
+	// Setup test files in different directories backed up in different snapshots
+	p1 := filepath.Join(curdir, filepath.FromSlash("p1/testfile.c"))
+
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p1), 0755))
+	rtest.OK(t, appendRandomData(p1, 102))
+	testRunBackup(t, "", []string{"p1"}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	p2 := filepath.Join(curdir, filepath.FromSlash("p2/testfile.c"))
+
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p2), 0755))
+	rtest.OK(t, appendRandomData(p2, 103))
+	testRunBackup(t, "", []string{"p2"}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	p1rAbs := filepath.Join(env.base, "restore1", "p1/testfile.c")
+	p2rAbs := filepath.Join(env.base, "restore2", "p2/testfile.c")
+
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore1"), []string{filepath.Dir(p1)}, "")
+	rtest.OK(t, testFileSize(p1rAbs, int64(102)))
+	if _, err := os.Stat(p2rAbs); os.IsNotExist(errors.Cause(err)) {
+		rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
+			"expected %v to not exist in restore, but it exists, err %v", p2rAbs, err)
+	}
+
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore2"), []string{filepath.Dir(p2)}, "")
+	rtest.OK(t, testFileSize(p2rAbs, int64(103)))
+	if _, err := os.Stat(p1rAbs); os.IsNotExist(errors.Cause(err)) {
+		rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
+			"expected %v to not exist in restore, but it exists, err %v", p1rAbs, err)
+	}
+}
+
+func TestRestoreWithPermissionFailure(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "repo-restore-permissions-test.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
+
+	snapshots := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshots) > 0,
+		"no snapshots found in repo (%v)", datafile)
+
+	globalOptions.stderr = ioutil.Discard
+	defer func() {
+		globalOptions.stderr = os.Stderr
+	}()
+
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshots[0])
+
+	// make sure that all files have been restored, regardless of any
+	// permission errors
+	files := testRunLs(t, env.gopts, snapshots[0].String())
+	for _, filename := range files {
+		fi, err := os.Lstat(filepath.Join(env.base, "restore", filename))
+		rtest.OK(t, err)
+
+		rtest.Assert(t, !isFile(fi) || fi.Size() > 0,
+			"file %v restored, but filesize is 0", filename)
+	}
+}
+
+func setZeroModTime(filename string) error {
+	var utimes = []syscall.Timespec{
+		syscall.NsecToTimespec(0),
+		syscall.NsecToTimespec(0),
+	}
+
+	return syscall.UtimesNano(filename, utimes)
+}
+
+func TestRestoreNoMetadataOnIgnoredIntermediateDirs(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	p := filepath.Join(env.testdata, "subdir1", "subdir2", "subdir3", "file.ext")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+	rtest.OK(t, appendRandomData(p, 200))
+	rtest.OK(t, setZeroModTime(filepath.Join(env.testdata, "subdir1", "subdir2")))
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	// restore with filter "*.ext", this should restore "file.ext", but
+	// since the directories are ignored and only created because of
+	// "file.ext", no meta data should be restored for them.
+	testRunRestoreIncludes(t, env.gopts, filepath.Join(env.base, "restore0"), snapshotID, []string{"*.ext"})
+
+	f1 := filepath.Join(env.base, "restore0", "testdata", "subdir1", "subdir2")
+	fi, err := os.Stat(f1)
+	rtest.OK(t, err)
+
+	// restore with filter "*", this should restore meta data on everything.
+	testRunRestoreIncludes(t, env.gopts, filepath.Join(env.base, "restore1"), snapshotID, []string{"*"})
+
+	f2 := filepath.Join(env.base, "restore1", "testdata", "subdir1", "subdir2")
+	fi, err = os.Stat(f2)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, fi.ModTime() == time.Unix(0, 0),
+		"meta data of intermediate directory hasn't been restore")
+}
+
+func TestFind(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	results := testRunFind(t, false, env.gopts, "unexistingfile")
+	rtest.Assert(t, len(results) == 0, "unexisting file found in repo (%v)", datafile)
+
+	results = testRunFind(t, false, env.gopts, "testfile")
+	lines := strings.Split(string(results), "\n")
+	rtest.Assert(t, len(lines) == 2, "expected one file found in repo (%v)", datafile)
+
+	results = testRunFind(t, false, env.gopts, "testfile*")
+	lines = strings.Split(string(results), "\n")
+	rtest.Assert(t, len(lines) == 4, "expected three files found in repo (%v)", datafile)
+}
+
+func TestFindContent(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	// the file consists of several blobs, the other file contains only the
+	// first blobs and must not be found
+	data := rtest.Random(23, 5*1024*1024)
+	rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, "dir"), 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "dir", "known"), data, 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "prefix"), data[:len(data)/2], 0644))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	copied := filepath.Join(env.base, "copy")
+	rtest.OK(t, ioutil.WriteFile(copied, data, 0644))
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	rtest.OK(t, runFind(FindOptions{Content: true}, env.gopts, []string{copied}))
+
+	var matches []struct {
+		ObjectType string `json:"object_type"`
+		ID         string `json:"id"`
+		Path       string `json:"path"`
+		SnapshotID string `json:"snapshot"`
+	}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &matches))
+	rtest.Assert(t, len(matches) == 2, "expected one match in each snapshot, got %v", matches)
+
+	found := restic.NewIDSet()
+	for _, m := range matches {
+		rtest.Equals(t, "file", m.ObjectType)
+		rtest.Equals(t, copied, m.ID)
+		rtest.Assert(t, strings.HasSuffix(m.Path, "/dir/known"), "unexpected path %v", m.Path)
+		id, err := restic.ParseID(m.SnapshotID)
+		rtest.OK(t, err)
+		found.Insert(id)
+	}
+	rtest.Equals(t, restic.NewIDSet(snapshotIDs...), found)
+}
+
+type testMatch struct {
+	Path        string    `json:"path,omitempty"`
+	Permissions string    `json:"permissions,omitempty"`
+	Size        uint64    `json:"size,omitempty"`
+	Date        time.Time `json:"date,omitempty"`
+	UID         uint32    `json:"uid,omitempty"`
+	GID         uint32    `json:"gid,omitempty"`
+}
+
+type testMatches struct {
+	Hits       int         `json:"hits,omitempty"`
+	SnapshotID string      `json:"snapshot,omitempty"`
+	Matches    []testMatch `json:"matches,omitempty"`
 }
 
-func TestRestore(t *testing.T) {
+func TestFindJSON(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
 	testRunInit(t, env.gopts)
-
-	for i := 0; i < 10; i++ {
-		p := filepath.Join(env.testdata, fmt.Sprintf("foo/bar/testfile%v", i))
-		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
-		rtest.OK(t, appendRandomData(p, uint(mrand.Intn(2<<21))))
-	}
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 
 	opts := BackupOptions{}
 
-	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
 	testRunCheck(t, env.gopts)
 
-	// Restore latest without any filters
-	restoredir := filepath.Join(env.base, "restore")
-	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	results := testRunFind(t, true, env.gopts, "unexistingfile")
+	matches := []testMatches{}
+	rtest.OK(t, json.Unmarshal(results, &matches))
+	rtest.Assert(t, len(matches) == 0, "expected no match in repo (%v)", datafile)
 
-	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, filepath.Base(env.testdata))),
-		"directories are not equal")
+	results = testRunFind(t, true, env.gopts, "testfile")
+	rtest.OK(t, json.Unmarshal(results, &matches))
+	rtest.Assert(t, len(matches) == 1, "expected a single snapshot in repo (%v)", datafile)
+	rtest.Assert(t, len(matches[0].Matches) == 1, "expected a single file to match (%v)", datafile)
+	rtest.Assert(t, matches[0].Hits == 1, "expected hits to show 1 match (%v)", datafile)
+
+	results = testRunFind(t, true, env.gopts, "testfile*")
+	rtest.OK(t, json.Unmarshal(results, &matches))
+	rtest.Assert(t, len(matches) == 1, "expected a single snapshot in repo (%v)", datafile)
+	rtest.Assert(t, len(matches[0].Matches) == 3, "expected 3 files to match (%v)", datafile)
+	rtest.Assert(t, matches[0].Hits == 3, "expected hits to show 3 matches (%v)", datafile)
 }
 
-func TestRestoreLatest(t *testing.T) {
+func TestRebuildIndex(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	testRunInit(t, env.gopts)
+	datafile := filepath.Join("..", "..", "internal", "checker", "testdata", "duplicate-packs-in-index-test-repo.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
 
-	p := filepath.Join(env.testdata, "testfile.c")
-	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
-	rtest.OK(t, appendRandomData(p, 100))
+	out, err := testRunCheckOutput(env.gopts)
+	if !strings.Contains(out, "contained in several indexes") {
+		t.Fatalf("did not find checker hint for packs in several indexes")
+	}
 
-	opts := BackupOptions{}
+	if err != nil {
+		t.Fatalf("expected no error from checker for test repository, got %v", err)
+	}
 
-	// chdir manually here so we can get the current directory. This is not the
-	// same as the temp dir returned by ioutil.TempDir() on darwin.
-	back := fs.TestChdir(t, filepath.Dir(env.testdata))
-	defer back()
+	if !strings.Contains(out, "restic repair index") {
+		t.Fatalf("did not find hint for repair index command")
+	}
+
+	testRunRebuildIndex(t, env.gopts)
+
+	out, err = testRunCheckOutput(env.gopts)
+	if len(out) != 0 {
+		t.Fatalf("expected no output from the checker, got: %v", out)
+	}
 
-	curdir, err := os.Getwd()
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("expected no error from checker after rebuild-index, got: %v", err)
 	}
+}
 
-	testRunBackup(t, "", []string{filepath.Base(env.testdata)}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+func TestRebuildIndexAlwaysFull(t *testing.T) {
+	repository.IndexFull = func(*repository.Index) bool { return true }
+	TestRebuildIndex(t)
+}
 
-	os.Remove(p)
-	rtest.OK(t, appendRandomData(p, 101))
-	testRunBackup(t, "", []string{filepath.Base(env.testdata)}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+func testRunLocksList(t testing.TB, gopts GlobalOptions) (locks []map[string]interface{}) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runLocksList(gopts, nil))
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &locks))
+	return locks
+}
 
-	// Restore latest without any filters
-	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore0"), nil, "")
-	rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", "testfile.c"), int64(101)))
+func TestLocks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
 
-	// Setup test files in different directories backed up in different snapshots
-	p1 := filepath.Join(curdir, filepath.FromSlash("p1/testfile.c"))
+	testRunInit(t, env.gopts)
 
-	rtest.OK(t, os.MkdirAll(filepath.Dir(p1), 0755))
-	rtest.OK(t, appendRandomData(p1, 102))
-	testRunBackup(t, "", []string{"p1"}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
 
-	p2 := filepath.Join(curdir, filepath.FromSlash("p2/testfile.c"))
+	// the lock is removed by "locks remove --force" below
+	_, err = restic.NewExclusiveLock(env.gopts.ctx, repo, restic.LockOptions{})
+	rtest.OK(t, err)
 
-	rtest.OK(t, os.MkdirAll(filepath.Dir(p2), 0755))
-	rtest.OK(t, appendRandomData(p2, 103))
-	testRunBackup(t, "", []string{"p2"}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	staleID, err := repo.SaveJSONUnpacked(env.gopts.ctx, restic.LockFile, &restic.Lock{
+		Time:     time.Now().Add(-time.Hour),
+		Hostname: "other-host",
+		PID:      23,
+	})
+	rtest.OK(t, err)
 
-	p1rAbs := filepath.Join(env.base, "restore1", "p1/testfile.c")
-	p2rAbs := filepath.Join(env.base, "restore2", "p2/testfile.c")
+	locks := testRunLocksList(t, env.gopts)
+	rtest.Equals(t, 2, len(locks))
+	// locks are sorted by time
+	rtest.Equals(t, staleID.String(), locks[0]["id"])
+	rtest.Equals(t, "other-host", locks[0]["hostname"])
+	rtest.Equals(t, true, locks[0]["stale"])
+	rtest.Equals(t, false, locks[0]["exclusive"])
+	rtest.Equals(t, false, locks[1]["stale"])
+	rtest.Equals(t, true, locks[1]["exclusive"])
+	activeID := locks[1]["id"].(string)
 
-	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore1"), []string{filepath.Dir(p1)}, "")
-	rtest.OK(t, testFileSize(p1rAbs, int64(102)))
-	if _, err := os.Stat(p2rAbs); os.IsNotExist(errors.Cause(err)) {
-		rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
-			"expected %v to not exist in restore, but it exists, err %v", p2rAbs, err)
-	}
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	rtest.OK(t, runLocksList(gopts, nil))
+	rtest.Assert(t, strings.Contains(buf.String(), "other-host"), "lock of other-host missing in output: %q", buf.String())
 
-	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore2"), []string{filepath.Dir(p2)}, "")
-	rtest.OK(t, testFileSize(p2rAbs, int64(103)))
-	if _, err := os.Stat(p1rAbs); os.IsNotExist(errors.Cause(err)) {
-		rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
-			"expected %v to not exist in restore, but it exists, err %v", p1rAbs, err)
+	err = runLocksRemove(LocksRemoveOptions{}, env.gopts, []string{activeID[:8]})
+	rtest.Assert(t, err != nil, "lock which is not stale was removed without --force")
+	rtest.Equals(t, 2, len(testRunLocksList(t, env.gopts)))
+
+	rtest.OK(t, runLocksRemove(LocksRemoveOptions{}, env.gopts, []string{staleID.Str()}))
+	locks = testRunLocksList(t, env.gopts)
+	rtest.Equals(t, 1, len(locks))
+	rtest.Equals(t, activeID, locks[0]["id"])
+
+	rtest.OK(t, runLocksRemove(LocksRemoveOptions{Force: true}, env.gopts, []string{activeID}))
+	rtest.Equals(t, 0, len(testRunLocksList(t, env.gopts)))
+
+	err = runLocksRemove(LocksRemoveOptions{Stale: true}, env.gopts, []string{activeID})
+	rtest.Assert(t, err != nil, "IDs were accepted together with --stale")
+}
+
+func TestCheckRestoreNoLock(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "small-repo.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
+
+	err := filepath.Walk(env.repo, func(p string, fi os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		return os.Chmod(p, fi.Mode() & ^(os.FileMode(0222)))
+	})
+	rtest.OK(t, err)
+
+	env.gopts.NoLock = true
+
+	testRunCheck(t, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	if len(snapshotIDs) == 0 {
+		t.Fatalf("found no snapshots")
 	}
+
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshotIDs[0])
 }
 
-func TestRestoreWithPermissionFailure(t *testing.T) {
+func TestCheckSkipVerified(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "repo-restore-permissions-test.tar.gz")
+	datafile := filepath.Join("testdata", "small-repo.tar.gz")
 	rtest.SetupTarTestFixture(t, env.base, datafile)
 
-	snapshots := testRunList(t, "snapshots", env.gopts)
-	rtest.Assert(t, len(snapshots) > 0,
-		"no snapshots found in repo (%v)", datafile)
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
 
-	globalOptions.stderr = ioutil.Discard
+	opts := CheckOptions{
+		ReadData:                   true,
+		ReadDataSkipVerifiedWithin: restic.Duration{Days: 1},
+	}
+	rtest.OK(t, runCheck(opts, env.gopts, nil))
+
+	packs, err := filepath.Glob(filepath.Join(env.repo, "data", "*", "*"))
+	rtest.OK(t, err)
+	rtest.Assert(t, len(packs) > 0, "no packs found in repo")
+
+	// corrupt the header of a pack, the next run must skip it as it was
+	// verified recently, the blobs within the pack are still readable
+	buf, err := ioutil.ReadFile(packs[0])
+	rtest.OK(t, err)
+	buf[len(buf)-5] ^= 0xff
+	rtest.OK(t, os.Chmod(packs[0], 0644))
+	rtest.OK(t, ioutil.WriteFile(packs[0], buf, 0644))
+
+	rtest.OK(t, runCheck(opts, env.gopts, nil))
+
+	// without the flag all packs are read again
+	opts.ReadDataSkipVerifiedWithin = restic.Duration{}
+	rtest.Assert(t, runCheck(opts, env.gopts, nil) != nil,
+		"check did not detect the corrupted pack")
+}
+
+var updateGoldenFiles = flag.Bool("update", false, "update golden files in testdata/")
+
+func testRunStatsJSON(t testing.TB, gopts GlobalOptions, mode string) []byte {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	oldMode, oldSnapshot := countMode, snapshotIDString
+	countMode, snapshotIDString = mode, ""
 	defer func() {
-		globalOptions.stderr = os.Stderr
+		countMode, snapshotIDString = oldMode, oldSnapshot
 	}()
 
-	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshots[0])
+	rtest.OK(t, runStats(gopts, nil))
+	return buf.Bytes()
+}
 
-	// make sure that all files have been restored, regardless of any
-	// permission errors
-	files := testRunLs(t, env.gopts, snapshots[0].String())
-	for _, filename := range files {
-		fi, err := os.Lstat(filepath.Join(env.base, "restore", filename))
-		rtest.OK(t, err)
+func TestStatsJSON(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
 
-		rtest.Assert(t, !isFile(fi) || fi.Size() > 0,
-			"file %v restored, but filesize is 0", filename)
+	datafile := filepath.Join("testdata", "small-repo.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
+
+	for _, mode := range []string{countModeRestoreSize, countModeUniqueFilesByContents, countModeBlobsPerFile, countModeRawData, countModeDedup, countModeBlobsPerPack} {
+		t.Run(mode, func(t *testing.T) {
+			output := testRunStatsJSON(t, env.gopts, mode)
+
+			var stats statsJSON
+			rtest.OK(t, json.Unmarshal(output, &stats))
+			rtest.Equals(t, "stats", stats.MessageType)
+			rtest.Equals(t, statsSchemaVersion, stats.SchemaVersion)
+			rtest.Equals(t, mode, stats.Mode)
+
+			goldenFilename := filepath.Join("testdata", "stats-"+mode+".json")
+			if *updateGoldenFiles {
+				rtest.OK(t, ioutil.WriteFile(goldenFilename, output, 0644))
+			}
+
+			want, err := ioutil.ReadFile(goldenFilename)
+			rtest.OK(t, err)
+			rtest.Equals(t, string(want), string(output))
+		})
 	}
 }
 
-func setZeroModTime(filename string) error {
-	var utimes = []syscall.Timespec{
-		syscall.NsecToTimespec(0),
-		syscall.NsecToTimespec(0),
+// diffTestNode describes an item in a synthetic snapshot for TestDiffJSON.
+type diffTestNode struct {
+	node     restic.Node
+	content  string
+	children []diffTestNode
+}
+
+func saveDiffTestTree(t testing.TB, repo restic.Repository, nodes []diffTestNode) restic.ID {
+	tree := restic.NewTree()
+	for _, n := range nodes {
+		node := n.node
+		switch node.Type {
+		case "file":
+			if n.content != "" {
+				id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, []byte(n.content), restic.ID{})
+				rtest.OK(t, err)
+				node.Content = restic.IDs{id}
+			}
+			node.Size = uint64(len(n.content))
+		case "dir":
+			id := saveDiffTestTree(t, repo, n.children)
+			node.Subtree = &id
+		}
+		rtest.OK(t, tree.Insert(&node))
 	}
 
-	return syscall.UtimesNano(filename, utimes)
+	id, err := repo.SaveTree(context.TODO(), tree)
+	rtest.OK(t, err)
+	return id
 }
 
-func TestRestoreNoMetadataOnIgnoredIntermediateDirs(t *testing.T) {
+func saveDiffTestSnapshot(t testing.TB, repo *repository.Repository, nodes []diffTestNode) string {
+	treeID := saveDiffTestTree(t, repo, nodes)
+	rtest.OK(t, repo.Flush(context.TODO()))
+	rtest.OK(t, repo.SaveIndex(context.TODO()))
+
+	sn, err := restic.NewSnapshot([]string{"/"}, nil, "host", time.Unix(1500000000, 0))
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+
+	id, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	return id.String()
+}
+
+func TestDiffJSON(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
 	testRunInit(t, env.gopts)
 
-	p := filepath.Join(env.testdata, "subdir1", "subdir2", "subdir3", "file.ext")
-	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
-	rtest.OK(t, appendRandomData(p, 200))
-	rtest.OK(t, setZeroModTime(filepath.Join(env.testdata, "subdir1", "subdir2")))
-
-	opts := BackupOptions{}
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
 
-	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	mtime := time.Unix(1500000000, 0).UTC()
+	file := func(name, content string) diffTestNode {
+		return diffTestNode{
+			node:    restic.Node{Name: name, Type: "file", Mode: 0644, ModTime: mtime, UID: 1000, GID: 100},
+			content: content,
+		}
+	}
+	dir := func(name string, children ...diffTestNode) diffTestNode {
+		return diffTestNode{
+			node:     restic.Node{Name: name, Type: "dir", Mode: os.ModeDir | 0755, ModTime: mtime, UID: 1000, GID: 100},
+			children: children,
+		}
+	}
+	symlink := func(name, target string) diffTestNode {
+		return diffTestNode{
+			node: restic.Node{Name: name, Type: "symlink", Mode: os.ModeSymlink | 0777, ModTime: mtime, UID: 1000, GID: 100, LinkTarget: target},
+		}
+	}
 
-	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+	chmod := file("chmod", "chmod")
+	chmod.node.Mode = 0600
+	chown := file("chown", "chown")
+	chown.node.UID, chown.node.GID = 0, 0
+	touch := file("touch", "touch")
+	touch.node.ModTime = mtime.Add(time.Hour)
+	modifiedTouched := file("modified-touched", "new content, longer")
+	modifiedTouched.node.ModTime = mtime.Add(time.Hour)
+
+	sn1 := saveDiffTestSnapshot(t, repo, []diffTestNode{
+		file("unchanged", "unchanged"),
+		file("removed", "removed"),
+		dir("removed-dir", file("foo", "foo"), symlink("link", "foo")),
+		file("modified", "old content"),
+		file("modified-touched", "old content"),
+		file("chmod", "chmod"),
+		file("chown", "chown"),
+		file("touch", "touch"),
+		file("type", "type"),
+		dir("subdir", file("modified", "old content"), file("unchanged", "unchanged")),
+	})
 
-	// restore with filter "*.ext", this should restore "file.ext", but
-	// since the directories are ignored and only created because of
-	// "file.ext", no meta data should be restored for them.
-	testRunRestoreIncludes(t, env.gopts, filepath.Join(env.base, "restore0"), snapshotID, []string{"*.ext"})
+	sn2 := saveDiffTestSnapshot(t, repo, []diffTestNode{
+		file("unchanged", "unchanged"),
+		file("added", "added"),
+		dir("added-dir", file("bar", "bar")),
+		file("modified", "new content"),
+		modifiedTouched,
+		chmod,
+		chown,
+		touch,
+		symlink("type", "unchanged"),
+		dir("subdir", file("modified", "new content, longer"), file("unchanged", "unchanged")),
+	})
 
-	f1 := filepath.Join(env.base, "restore0", "testdata", "subdir1", "subdir2")
-	fi, err := os.Stat(f1)
-	rtest.OK(t, err)
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runDiff(DiffOptions{ShowMetadata: true}, gopts, []string{sn1, sn2}))
+	output := buf.Bytes()
 
-	// restore with filter "*", this should restore meta data on everything.
-	testRunRestoreIncludes(t, env.gopts, filepath.Join(env.base, "restore1"), snapshotID, []string{"*"})
+	goldenFilename := filepath.Join("testdata", "diff.json")
+	if *updateGoldenFiles {
+		rtest.OK(t, ioutil.WriteFile(goldenFilename, output, 0644))
+	}
 
-	f2 := filepath.Join(env.base, "restore1", "testdata", "subdir1", "subdir2")
-	fi, err = os.Stat(f2)
+	want, err := ioutil.ReadFile(goldenFilename)
 	rtest.OK(t, err)
-
-	rtest.Assert(t, fi.ModTime() == time.Unix(0, 0),
-		"meta data of intermediate directory hasn't been restore")
+	rtest.Equals(t, string(want), string(output))
 }
 
-func TestFind(t *testing.T) {
+func TestPrune(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
 	testRunInit(t, env.gopts)
-	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 	opts := BackupOptions{}
 
-	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(firstSnapshot) == 1,
+		"expected one snapshot, got %v", firstSnapshot)
 
-	results := testRunFind(t, false, env.gopts, "unexistingfile")
-	rtest.Assert(t, len(results) == 0, "unexisting file found in repo (%v)", datafile)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
 
-	results = testRunFind(t, false, env.gopts, "testfile")
-	lines := strings.Split(string(results), "\n")
-	rtest.Assert(t, len(lines) == 2, "expected one file found in repo (%v)", datafile)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 3,
+		"expected 3 snapshot, got %v", snapshotIDs)
 
-	results = testRunFind(t, false, env.gopts, "testfile*")
-	lines = strings.Split(string(results), "\n")
-	rtest.Assert(t, len(lines) == 4, "expected three files found in repo (%v)", datafile)
+	testRunForgetJSON(t, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
 }
 
-type testMatch struct {
-	Path        string    `json:"path,omitempty"`
-	Permissions string    `json:"permissions,omitempty"`
-	Size        uint64    `json:"size,omitempty"`
-	Date        time.Time `json:"date,omitempty"`
-	UID         uint32    `json:"uid,omitempty"`
-	GID         uint32    `json:"gid,omitempty"`
+// modificationRecorder records all calls which modify the backend.
+type modificationRecorder struct {
+	restic.Backend
+	modified []restic.Handle
 }
 
-type testMatches struct {
-	Hits       int         `json:"hits,omitempty"`
-	SnapshotID string      `json:"snapshot,omitempty"`
-	Matches    []testMatch `json:"matches,omitempty"`
+func (be *modificationRecorder) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	be.modified = append(be.modified, h)
+	return be.Backend.Save(ctx, h, rd)
 }
 
-func TestFindJSON(t *testing.T) {
+func (be *modificationRecorder) Remove(ctx context.Context, h restic.Handle) error {
+	be.modified = append(be.modified, h)
+	return be.Backend.Remove(ctx, h)
+}
+
+func TestPruneDryRun(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
 	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
 	testRunInit(t, env.gopts)
-	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
 	opts := BackupOptions{}
 
-	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
 
-	results := testRunFind(t, true, env.gopts, "unexistingfile")
-	matches := []testMatches{}
-	rtest.OK(t, json.Unmarshal(results, &matches))
-	rtest.Assert(t, len(matches) == 0, "expected no match in repo (%v)", datafile)
+	r, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
 
-	results = testRunFind(t, true, env.gopts, "testfile")
-	rtest.OK(t, json.Unmarshal(results, &matches))
-	rtest.Assert(t, len(matches) == 1, "expected a single snapshot in repo (%v)", datafile)
-	rtest.Assert(t, len(matches[0].Matches) == 1, "expected a single file to match (%v)", datafile)
-	rtest.Assert(t, matches[0].Hits == 1, "expected hits to show 1 match (%v)", datafile)
+	be := &modificationRecorder{Backend: r.Backend()}
+	repo := repository.New(be)
+	rtest.OK(t, repo.SearchKey(env.gopts.ctx, env.gopts.password, 0, ""))
 
-	results = testRunFind(t, true, env.gopts, "testfile*")
-	rtest.OK(t, json.Unmarshal(results, &matches))
-	rtest.Assert(t, len(matches) == 1, "expected a single snapshot in repo (%v)", datafile)
-	rtest.Assert(t, len(matches[0].Matches) == 3, "expected 3 files to match (%v)", datafile)
-	rtest.Assert(t, matches[0].Hits == 3, "expected hits to show 3 matches (%v)", datafile)
+	plan, err := pruneRepository(env.gopts, PruneOptions{DryRun: true}, repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, len(plan.RemovePacks)+len(plan.RewritePacks) > 0,
+		"prune plan does not contain any changes after removing a snapshot")
+	rtest.Assert(t, len(be.modified) == 0,
+		"dry run modified the repository: %v", be.modified)
+
+	// the plan must match what the real prune does
+	packs := testRunList(t, "packs", env.gopts)
+	testRunPrune(t, env.gopts)
+	remaining := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	for _, id := range packs {
+		if plan.RemovePacks.Has(id) || plan.RewritePacks.Has(id) {
+			rtest.Assert(t, !remaining.Has(id), "pack %v was planned to be removed but still exists", id.Str())
+		} else {
+			rtest.Assert(t, remaining.Has(id), "pack %v was planned to be kept but was removed", id.Str())
+		}
+	}
+	testRunCheck(t, env.gopts)
 }
 
-func TestRebuildIndex(t *testing.T) {
+func TestPruneKeepPackAge(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("..", "..", "internal", "checker", "testdata", "duplicate-packs-in-index-test-repo.tar.gz")
-	rtest.SetupTarTestFixture(t, env.base, datafile)
+	testRunInit(t, env.gopts)
 
-	out, err := testRunCheckOutput(env.gopts)
-	if !strings.Contains(out, "contained in several indexes") {
-		t.Fatalf("did not find checker hint for packs in several indexes")
+	// back up two directories with distinct content, so that each snapshot
+	// has its own packs
+	for _, dir := range []string{"old", "recent"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, dir), 0755))
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, dir, "file"), 1024*1024))
 	}
 
-	if err != nil {
-		t.Fatalf("expected no error from checker for test repository, got %v", err)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "old")}, BackupOptions{}, env.gopts)
+	oldPacks := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "recent")}, BackupOptions{}, env.gopts)
+	recentPacks := restic.NewIDSet(testRunList(t, "packs", env.gopts)...).Sub(oldPacks)
+	rtest.Assert(t, len(oldPacks) > 0 && len(recentPacks) > 0,
+		"expected packs for both snapshots, got %d and %d", len(oldPacks), len(recentPacks))
+
+	// the packs of the first snapshot were created two days ago
+	past := time.Now().Add(-48 * time.Hour)
+	for id := range oldPacks {
+		name := id.String()
+		rtest.OK(t, os.Chtimes(filepath.Join(env.repo, "data", name[:2], name), past, past))
 	}
 
-	if !strings.Contains(out, "restic rebuild-index") {
-		t.Fatalf("did not find hint for rebuild-index command")
+	// remove all snapshots, so that none of the packs is referenced any more
+	var snapshots []string
+	for _, id := range testRunList(t, "snapshots", env.gopts) {
+		snapshots = append(snapshots, id.String())
 	}
+	testRunForget(t, env.gopts, snapshots...)
+	rtest.OK(t, runPrune(PruneOptions{KeepPackAge: 24 * time.Hour}, env.gopts))
 
-	testRunRebuildIndex(t, env.gopts)
+	remaining := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	rtest.Equals(t, recentPacks, remaining)
 
-	out, err = testRunCheckOutput(env.gopts)
-	if len(out) != 0 {
-		t.Fatalf("expected no output from the checker, got: %v", out)
-	}
+	// the retained packs still contain unused blobs
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+}
 
-	if err != nil {
-		t.Fatalf("expected no error from checker after rebuild-index, got: %v", err)
+func TestPruneFast(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, BackupOptions{}, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+
+	// a full prune would remove some packs and rewrite others
+	full, err := pruneRepository(env.gopts, PruneOptions{DryRun: true}, repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, len(full.RemovePacks) > 0 && len(full.RewritePacks) > 0,
+		"expected packs to remove and rewrite, got %d and %d", len(full.RemovePacks), len(full.RewritePacks))
+
+	packs := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	plan, err := pruneRepository(env.gopts, PruneOptions{Fast: true}, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, full.RemovePacks, plan.RemovePacks)
+	rtest.Equals(t, 0, len(plan.RewritePacks))
+
+	// only the unused packs are removed, the partially used packs are kept
+	remaining := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	rtest.Equals(t, packs.Sub(full.RemovePacks), remaining)
+	for id := range full.RewritePacks {
+		rtest.Assert(t, remaining.Has(id), "partially used pack %v was removed", id.Str())
 	}
-}
 
-func TestRebuildIndexAlwaysFull(t *testing.T) {
-	repository.IndexFull = func(*repository.Index) bool { return true }
-	TestRebuildIndex(t)
+	// the kept packs still contain unused blobs
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
 }
 
-func TestCheckRestoreNoLock(t *testing.T) {
+func TestCacheWarm(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "small-repo.tar.gz")
-	rtest.SetupTarTestFixture(t, env.base, datafile)
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0")}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
 
-	err := filepath.Walk(env.repo, func(p string, fi os.FileInfo, e error) error {
-		if e != nil {
-			return e
-		}
-		return os.Chmod(p, fi.Mode() & ^(os.FileMode(0222)))
-	})
+	// find all packs which contain trees
+	repo, err := OpenRepository(env.gopts)
 	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+	treePacks := restic.NewIDSet()
+	for blob := range repo.Index().Each(env.gopts.ctx) {
+		if blob.Type == restic.TreeBlob {
+			treePacks.Insert(blob.PackID)
+		}
+	}
+	cacheDir := filepath.Join(env.cache, repo.Config().ID)
+	rtest.Assert(t, len(treePacks) > 0, "no tree packs found")
 
-	env.gopts.NoLock = true
+	// start with an empty cache
+	rtest.OK(t, os.RemoveAll(cacheDir))
 
-	testRunCheck(t, env.gopts)
+	rtest.OK(t, runCache(CacheOptions{}, env.gopts, []string{"warm"}))
 
-	snapshotIDs := testRunList(t, "snapshots", env.gopts)
-	if len(snapshotIDs) == 0 {
-		t.Fatalf("found no snapshots")
+	listCached := func(dir string) restic.IDSet {
+		ids := restic.NewIDSet()
+		err := filepath.Walk(filepath.Join(cacheDir, dir), func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			id, err := restic.ParseID(fi.Name())
+			rtest.OK(t, err)
+			ids.Insert(id)
+			return nil
+		})
+		rtest.OK(t, err)
+		return ids
 	}
 
-	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshotIDs[0])
+	rtest.Equals(t, restic.NewIDSet(testRunList(t, "index", env.gopts)...), listCached("index"))
+	rtest.Equals(t, treePacks, listCached("data"))
+
+	// warming the cache again does not change anything
+	rtest.OK(t, runCache(CacheOptions{}, env.gopts, []string{"warm", "latest"}))
+	rtest.Equals(t, treePacks, listCached("data"))
 }
 
-func TestPrune(t *testing.T) {
+// retainingBackend refuses to remove data files as if they were protected by
+// a retention policy.
+type retainingBackend struct {
+	restic.Backend
+}
+
+func (be retainingBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type == restic.DataFile {
+		return &restic.RetentionError{Handle: h, Err: errors.New("object is locked")}
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+func TestPruneRetention(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
-	datafile := filepath.Join("testdata", "backup-data.tar.gz")
-	fd, err := os.Open(datafile)
-	if os.IsNotExist(errors.Cause(err)) {
-		t.Skipf("unable to find data file %q, skipping", datafile)
-		return
-	}
+	testRunInit(t, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file"), 1024*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	packs := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+
+	snapshots := testRunList(t, "snapshots", env.gopts)
+	testRunForget(t, env.gopts, snapshots[0].String())
+
+	be, err := open(env.gopts.Repo, env.gopts, env.gopts.extended)
 	rtest.OK(t, err)
-	rtest.OK(t, fd.Close())
+	repo := repository.New(retainingBackend{be})
+	rtest.OK(t, repo.SearchKey(env.gopts.ctx, rtest.TestPassword, 1, ""))
 
-	testRunInit(t, env.gopts)
+	// the packs cannot be removed yet, this is not an error
+	plan, err := pruneRepository(env.gopts, PruneOptions{}, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, packs, plan.RemovePacks)
+	rtest.Equals(t, packs, restic.NewIDSet(testRunList(t, "packs", env.gopts)...))
 
-	rtest.SetupTarTestFixture(t, env.testdata, datafile)
-	opts := BackupOptions{}
+	// the retained packs are listed as pending removal, check does not
+	// report them as orphaned
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(env.gopts.ctx, rtest.TestPassword, 1, ""))
+	checker.TestCheckRepo(t, repo)
+	rtest.Equals(t, packs, repo.Index().(*repository.MasterIndex).PendingRemoval())
 
-	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
-	firstSnapshot := testRunList(t, "snapshots", env.gopts)
-	rtest.Assert(t, len(firstSnapshot) == 1,
-		"expected one snapshot, got %v", firstSnapshot)
+	// check-index reports them separately and does not fail
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runCheckIndex(gopts, nil))
 
-	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
-	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
+	var result struct {
+		UnindexedPacks      []interface{} `json:"unindexed_packs"`
+		PendingRemovalPacks []struct {
+			ID restic.ID `json:"id"`
+		} `json:"pending_removal_packs"`
+	}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &result))
+	rtest.Equals(t, 0, len(result.UnindexedPacks))
+	pending := restic.NewIDSet()
+	for _, pack := range result.PendingRemovalPacks {
+		pending.Insert(pack.ID)
+	}
+	rtest.Equals(t, packs, pending)
 
-	snapshotIDs := testRunList(t, "snapshots", env.gopts)
-	rtest.Assert(t, len(snapshotIDs) == 3,
-		"expected 3 snapshot, got %v", snapshotIDs)
+	// a prune during the retention period keeps them pending
+	repo = repository.New(retainingBackend{be})
+	rtest.OK(t, repo.SearchKey(env.gopts.ctx, rtest.TestPassword, 1, ""))
+	plan, err = pruneRepository(env.gopts, PruneOptions{}, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, packs, plan.RemovePacks)
+	rtest.Equals(t, 0, len(plan.KeepPacks)+len(plan.RewritePacks))
 
-	testRunForgetJSON(t, env.gopts)
-	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(env.gopts.ctx, rtest.TestPassword, 1, ""))
+	checker.TestCheckRepo(t, repo)
+
+	// once the retention period is over, the packs are removed
 	testRunPrune(t, env.gopts)
+	rtest.Equals(t, 0, len(testRunList(t, "packs", env.gopts)))
 	testRunCheck(t, env.gopts)
 }
 
@@ -1218,3 +2980,169 @@ func TestQuietBackup(t *testing.T) {
 
 	testRunCheck(t, env.gopts)
 }
+
+func TestAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	env.gopts.AppendOnly = true
+	globalOptions.AppendOnly = true
+
+	opts := BackupOptions{}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2,
+		"expected two snapshots, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	// the lock files of all commands are left behind
+	rtest.Assert(t, len(testRunList(t, "locks", env.gopts)) > 0,
+		"expected lock files to be left in the repository")
+
+	err = runForget(ForgetOptions{Last: 1}, env.gopts, nil)
+	rtest.Assert(t, errors.IsFatal(errors.Cause(err)),
+		"forget did not fail in append-only mode: %v", err)
+	rtest.OK(t, runForget(ForgetOptions{Last: 1, DryRun: true}, env.gopts, nil))
+
+	err = runPrune(PruneOptions{}, env.gopts)
+	rtest.Assert(t, errors.IsFatal(errors.Cause(err)),
+		"prune did not fail in append-only mode: %v", err)
+
+	err = runUnlock(UnlockOptions{}, env.gopts)
+	rtest.Assert(t, errors.IsFatal(errors.Cause(err)),
+		"unlock did not fail in append-only mode: %v", err)
+
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2,
+		"expected two snapshots, got %v", snapshotIDs)
+}
+
+func TestLsJSONTree(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, name := range []string{"a/x.go", "a/y.txt", "b/z.txt", "c.go"} {
+		filename := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte(name), 0644))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+
+	opts := LsOptions{Recursive: true, Patterns: []string{"*.go"}}
+	rtest.OK(t, runLs(opts, gopts, []string{snapshotIDs[0].String()}))
+
+	var sn lsSnapshotTree
+	dec := json.NewDecoder(buf)
+	rtest.OK(t, dec.Decode(&sn))
+	rtest.Assert(t, !dec.More(), "expected a single JSON document")
+	rtest.Equals(t, "snapshot", sn.StructType)
+	rtest.Equals(t, snapshotIDs[0].Str(), sn.ShortID)
+
+	rtest.Equals(t, 1, len(sn.Nodes))
+	dir := sn.Nodes[0]
+	rtest.Equals(t, "/testdata", dir.Path)
+	rtest.Equals(t, "dir", dir.Type)
+
+	var paths []string
+	for _, node := range dir.Children {
+		paths = append(paths, node.Path)
+	}
+	rtest.Equals(t, []string{"/testdata/a", "/testdata/c.go"}, paths)
+
+	a := dir.Children[0]
+	rtest.Equals(t, 1, len(a.Children))
+	rtest.Equals(t, "/testdata/a/x.go", a.Children[0].Path)
+	rtest.Equals(t, "file", a.Children[0].Type)
+	rtest.Equals(t, uint64(len("a/x.go")), a.Children[0].Size)
+	rtest.Equals(t, 0, len(a.Children[0].Children))
+
+	// without --recursive, the matching nodes are printed line by line
+	buf.Reset()
+	opts.Recursive = false
+	rtest.OK(t, runLs(opts, gopts, []string{snapshotIDs[0].String()}))
+
+	dec = json.NewDecoder(buf)
+	paths = nil
+	for dec.More() {
+		var node lsNode
+		rtest.OK(t, dec.Decode(&node))
+		if node.StructType == "node" {
+			paths = append(paths, node.Path)
+		}
+	}
+	rtest.Equals(t, []string{"/testdata/a/x.go", "/testdata/c.go"}, paths)
+}
+
+func testRunSnapshotsSince(t testing.TB, gopts GlobalOptions, since string) restic.IDs {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	rtest.OK(t, runSnapshots(SnapshotOptions{Since: since}, gopts, nil))
+
+	var snapshots []Snapshot
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &snapshots))
+
+	var ids restic.IDs
+	for _, sn := range snapshots {
+		ids = append(ids, *sn.ID)
+	}
+	return ids
+}
+
+func TestSnapshotsSince(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file"), []byte("foo"), 0644))
+
+	start := time.Now().Add(-time.Second)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	first, _ := testRunSnapshots(t, env.gopts)
+
+	// make sure the second snapshot file has a later modification time
+	time.Sleep(10 * time.Millisecond)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 2, len(snapshots))
+
+	ids := testRunSnapshotsSince(t, env.gopts, start.Format("2006-01-02 15:04:05"))
+	rtest.Equals(t, 2, len(ids))
+
+	ids = testRunSnapshotsSince(t, env.gopts, first.ID.Str())
+	rtest.Equals(t, restic.IDs{*newest.ID}, ids)
+
+	ids = testRunSnapshotsSince(t, env.gopts, newest.ID.String())
+	rtest.Equals(t, 0, len(ids))
+
+	ids = testRunSnapshotsSince(t, env.gopts, time.Now().Add(time.Hour).Format("2006-01-02 15:04:05"))
+	rtest.Equals(t, 0, len(ids))
+
+	err := runSnapshots(SnapshotOptions{Since: "invalid"}, env.gopts, nil)
+	rtest.Assert(t, errors.IsFatal(errors.Cause(err)), "expected a fatal error for an invalid cursor, got %v", err)
+}
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"os"
 	"sync"
-	"time"
 
+	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
@@ -14,9 +14,7 @@ import (
 )
 
 var globalLocks struct {
-	locks         []*restic.Lock
-	cancelRefresh chan struct{}
-	refreshWG     sync.WaitGroup
+	locks []*restic.Lock
 	sync.Mutex
 }
 
@@ -32,58 +30,59 @@ func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock,
 	lockFn := restic.NewLock
 	if exclusive {
 		lockFn = restic.NewExclusiveLock
+
+		// operations which need an exclusive lock modify the repository, they
+		// must see all files
+		disableListingCache(repo)
+	} else if c, ok := repo.Cache.(*cache.Cache); ok {
+		// files may have been added or removed while no lock was held, the
+		// listings cached before are not used any more
+		c.InvalidateListings()
 	}
 
-	lock, err := lockFn(context.TODO(), repo)
+	opts := lockOptions(globalOptions)
+	opts.RefreshInterval = refreshInterval
+	opts.Scope = globalOptions.LockScope
+	opts.AppendOnly = globalOptions.AppendOnly
+
+	lock, err := lockFn(context.TODO(), repo, opts)
 	if err != nil {
 		return nil, errors.Fatalf("unable to create lock in backend: %v", err)
 	}
 	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
 
-	globalLocks.Lock()
-	if globalLocks.cancelRefresh == nil {
-		debug.Log("start goroutine for lock refresh")
-		globalLocks.cancelRefresh = make(chan struct{})
-		globalLocks.refreshWG = sync.WaitGroup{}
-		globalLocks.refreshWG.Add(1)
-		go refreshLocks(&globalLocks.refreshWG, globalLocks.cancelRefresh)
-	}
+	lock.StartRefresh(func(err error) {
+		fmt.Fprintf(os.Stderr, "unable to refresh lock: %v\n", err)
+	})
 
+	globalLocks.Lock()
 	globalLocks.locks = append(globalLocks.locks, lock)
 	globalLocks.Unlock()
 
 	return lock, err
 }
 
-var refreshInterval = 5 * time.Minute
-
-func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}) {
-	debug.Log("start")
-	defer func() {
-		wg.Done()
-		globalLocks.Lock()
-		globalLocks.cancelRefresh = nil
-		globalLocks.Unlock()
-	}()
-
-	ticker := time.NewTicker(refreshInterval)
-
-	for {
-		select {
-		case <-done:
-			debug.Log("terminate")
-			return
-		case <-ticker.C:
-			debug.Log("refreshing locks")
-			globalLocks.Lock()
-			for _, lock := range globalLocks.locks {
-				err := lock.Refresh(context.TODO())
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "unable to refresh lock: %v\n", err)
-				}
-			}
-			globalLocks.Unlock()
-		}
+var refreshInterval = restic.DefaultLockRefreshInterval
+
+// disableListingCache configures the cache of repo to always list the files
+// in the backend. Commands which check or repair the repository use it, as a
+// cached listing may lack files which were added in the meantime.
+func disableListingCache(repo *repository.Repository) {
+	if c, ok := repo.Cache.(*cache.Cache); ok {
+		c.SetListingTTL(0)
+	}
+}
+
+// lockOptions returns the options for handling future-dated locks and for
+// retrying the creation of lock files which are set with the global flags.
+func lockOptions(gopts GlobalOptions) restic.LockOptions {
+	return restic.LockOptions{
+		ClockSkew:        gopts.LockClockSkew,
+		StaleFutureLocks: gopts.StaleFutureLock,
+		Retry: restic.LockRetryPolicy{
+			MaxAttempts: gopts.LockRetries + 1,
+			BaseDelay:   gopts.LockRetryDelay,
+		},
 	}
 }
 
@@ -7,6 +7,7 @@ import (
 	"path"
 	"runtime"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
@@ -31,18 +32,22 @@ type ErrorFunc func(file string, fi os.FileInfo, err error) error
 
 // ItemStats collects some statistics about a particular file or directory.
 type ItemStats struct {
-	DataBlobs int    // number of new data blobs added for this item
-	DataSize  uint64 // sum of the sizes of all new data blobs
-	TreeBlobs int    // number of new tree blobs added for this item
-	TreeSize  uint64 // sum of the sizes of all new tree blobs
+	DataBlobs      int    // number of new data blobs added for this item
+	DataSize       uint64 // sum of the sizes of all new data blobs
+	DataSizeInRepo uint64 // sum of the sizes of all new data blobs as stored in the repo
+	TreeBlobs      int    // number of new tree blobs added for this item
+	TreeSize       uint64 // sum of the sizes of all new tree blobs
+	TreeSizeInRepo uint64 // sum of the sizes of all new tree blobs as stored in the repo
 }
 
 // Add adds other to the current ItemStats.
 func (s *ItemStats) Add(other ItemStats) {
 	s.DataBlobs += other.DataBlobs
 	s.DataSize += other.DataSize
+	s.DataSizeInRepo += other.DataSizeInRepo
 	s.TreeBlobs += other.TreeBlobs
 	s.TreeSize += other.TreeSize
+	s.TreeSizeInRepo += other.TreeSizeInRepo
 }
 
 // Archiver saves a directory structure to the repo.
@@ -53,6 +58,11 @@ type Archiver struct {
 	FS           fs.FS
 	Options      Options
 
+	// SelectByContent is called for all regular files after Select, if it is
+	// set. This requires reading the start of each file, even if the file is
+	// unchanged compared to the parent snapshot.
+	SelectByContent SelectByContentFunc
+
 	blobSaver *BlobSaver
 	fileSaver *FileSaver
 	treeSaver *TreeSaver
@@ -80,6 +90,47 @@ type Archiver struct {
 	// default.
 	WithAtime   bool
 	IgnoreInode bool
+
+	// WindowsMetadata configures if the file attributes and alternate data
+	// streams of files and directories are saved on Windows.
+	WindowsMetadata bool
+
+	// StoreContentHash configures if the SHA-256 hash of the complete content
+	// of each file is recorded in its node. Unchanged files without a hash
+	// from the parent snapshot are read again.
+	StoreContentHash bool
+
+	// MetadataOnly configures the archiver to save only the nodes of regular
+	// files, without reading their content. The nodes have an empty list of
+	// blobs and the snapshot is marked as metadata-only.
+	MetadataOnly bool
+
+	// SkipBindMounts configures the archiver to traverse each directory only
+	// once per snapshot. Directories with the same device and inode as a
+	// directory which has already been traversed, e.g. bind mounts, are
+	// skipped. If BindMountMarker is set, they are saved as a symlink to the
+	// path of the first directory in the snapshot instead.
+	SkipBindMounts  bool
+	BindMountMarker bool
+
+	// FollowSymlinks contains the absolute paths of symlinks whose targets
+	// are saved in their place. All other symlinks are saved as symlinks.
+	FollowSymlinks []string
+
+	// Checkpoints configures the archiver to record the files and dirs which
+	// have been completed, so that SaveCheckpoint can save a partial snapshot
+	// while the backup is running.
+	Checkpoints bool
+
+	checkpointMutex sync.Mutex
+	checkpoint      *checkpointState
+	checkpointTree  *checkpointTree
+
+	seenDirsMutex sync.Mutex
+	seenDirs      map[dirID]string
+
+	followedMutex sync.Mutex
+	followed      map[string]string
 }
 
 // Options is used to configure the archiver.
@@ -97,6 +148,13 @@ type Options struct {
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// TreeSplitThreshold enables splitting trees into chunks. Trees which
+	// are larger than this number of bytes when encoded are saved as several
+	// chunks, so that a change to a large directory only requires saving the
+	// changed chunk. If it's set to zero, trees are never split. Splitting
+	// trees must be enabled in the repository config.
+	TreeSplitThreshold uint
 }
 
 // ApplyDefaults returns a copy of o with the default options set for all unset
@@ -158,18 +216,68 @@ func (arch *Archiver) error(item string, fi os.FileInfo, err error) error {
 }
 
 // saveTree stores a tree in the repo. It checks the index and the known blobs
-// before saving anything.
+// before saving anything. Large trees are split into chunks if enabled.
 func (arch *Archiver) saveTree(ctx context.Context, t *restic.Tree) (restic.ID, ItemStats, error) {
+	buf, err := encodeTree(t)
+	if err != nil {
+		return restic.ID{}, ItemStats{}, err
+	}
+
+	if arch.Options.TreeSplitThreshold > 0 && uint(len(buf)) > arch.Options.TreeSplitThreshold {
+		// only split when this results in more than one chunk, otherwise
+		// the tree is saved unchanged
+		if chunks := t.Split(); len(chunks) > 1 {
+			return arch.saveTreeChunks(ctx, chunks)
+		}
+	}
+
+	id, s := arch.saveTreeBlob(ctx, buf)
+	return id, s, nil
+}
+
+// saveTreeChunks saves each chunk as a tree blob, and then a tree which
+// references the chunks.
+func (arch *Archiver) saveTreeChunks(ctx context.Context, chunks []*restic.Tree) (restic.ID, ItemStats, error) {
+	debug.Log("saving tree as %d chunks", len(chunks))
+
 	var s ItemStats
+	top := &restic.Tree{Nodes: []*restic.Node{}}
+	for _, chunk := range chunks {
+		buf, err := encodeTree(chunk)
+		if err != nil {
+			return restic.ID{}, s, err
+		}
+
+		id, chunkStats := arch.saveTreeBlob(ctx, buf)
+		s.Add(chunkStats)
+		top.Chunks = append(top.Chunks, id)
+	}
+
+	buf, err := encodeTree(top)
+	if err != nil {
+		return restic.ID{}, s, err
+	}
+
+	id, topStats := arch.saveTreeBlob(ctx, buf)
+	s.Add(topStats)
+	return id, s, nil
+}
+
+// encodeTree returns the JSON encoding of t.
+func encodeTree(t *restic.Tree) ([]byte, error) {
 	buf, err := json.Marshal(t)
 	if err != nil {
-		return restic.ID{}, s, errors.Wrap(err, "MarshalJSON")
+		return nil, errors.Wrap(err, "MarshalJSON")
 	}
 
 	// append a newline so that the data is always consistent (json.Encoder
 	// adds a newline after each object)
-	buf = append(buf, '\n')
+	return append(buf, '\n'), nil
+}
 
+// saveTreeBlob saves the encoded tree buf as a tree blob.
+func (arch *Archiver) saveTreeBlob(ctx context.Context, buf []byte) (restic.ID, ItemStats) {
+	var s ItemStats
 	b := &Buffer{Data: buf}
 	res := arch.blobSaver.Save(ctx, restic.TreeBlob, b)
 
@@ -177,8 +285,9 @@ func (arch *Archiver) saveTree(ctx context.Context, t *restic.Tree) (restic.ID,
 	if !res.Known() {
 		s.TreeBlobs++
 		s.TreeSize += uint64(len(buf))
+		s.TreeSizeInRepo += uint64(restic.CiphertextLength(len(buf)))
 	}
-	return res.ID(), s, nil
+	return res.ID(), s
 }
 
 // nodeFromFileInfo returns the restic node from a os.FileInfo.
@@ -187,6 +296,12 @@ func (arch *Archiver) nodeFromFileInfo(filename string, fi os.FileInfo) (*restic
 	if !arch.WithAtime {
 		node.AccessTime = node.ModTime
 	}
+	if err == nil && arch.WindowsMetadata {
+		err = node.FillWindowsMetadata(filename)
+		if err != nil {
+			return node, errors.Wrap(err, "FillWindowsMetadata")
+		}
+	}
 	return node, errors.Wrap(err, "NodeFromFileInfo")
 }
 
@@ -216,7 +331,7 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, fi os.FileInfo
 		return FutureTree{}, err
 	}
 
-	names, err := readdirnames(arch.FS, dir)
+	names, err := readdirnames(arch.FS, dir, arch.isFollowedDir(dir))
 	if err != nil {
 		return FutureTree{}, err
 	}
@@ -346,14 +461,38 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		return FutureNode{}, true, nil
 	}
 
+	// save the target of the symlink in its place, if requested
+	targetFI, followed, err := arch.followSymlink(abstarget, fi)
+	if err != nil {
+		debug.Log("following symlink %v failed: %v", target, err)
+		err = arch.error(abstarget, fi, err)
+		if err != nil {
+			return FutureNode{}, false, err
+		}
+	}
+	fi = targetFI
+
 	switch {
 	case fs.IsRegularFile(fi):
 		debug.Log("  %v regular file", target)
 		start := time.Now()
 
+		// the file only needs to be opened to select it by its content
+		if arch.MetadataOnly && arch.SelectByContent == nil {
+			fn.node, err = arch.metadataOnlyNode(snPath, target, fi, previous, start)
+			if err != nil {
+				return FutureNode{}, false, err
+			}
+			return fn, false, nil
+		}
+
 		// reopen file and do an fstat() on the open file to check it is still
 		// a file (and has not been exchanged for e.g. a symlink)
-		file, err := arch.FS.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+		flags := fs.O_RDONLY | fs.O_NOFOLLOW
+		if followed {
+			flags = fs.O_RDONLY
+		}
+		file, err := arch.FS.OpenFile(target, flags, 0)
 		if err != nil {
 			debug.Log("Openfile() for %v returned error: %v", target, err)
 			err = arch.error(abstarget, fi, err)
@@ -384,8 +523,40 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			return FutureNode{}, true, nil
 		}
 
+		if arch.SelectByContent != nil {
+			f, head, err := readHead(file)
+			if err != nil {
+				debug.Log("reading the start of %v returned error: %v", target, err)
+				_ = file.Close()
+				err = arch.error(abstarget, fi, err)
+				if err != nil {
+					return FutureNode{}, false, errors.Wrap(err, "Read")
+				}
+				return FutureNode{}, true, nil
+			}
+
+			if !arch.SelectByContent(abstarget, fi, head) {
+				debug.Log("%v is excluded by content", target)
+				_ = file.Close()
+				return FutureNode{}, true, nil
+			}
+
+			// the data which has already been read is returned again from f
+			file = f
+		}
+
+		if arch.MetadataOnly {
+			_ = file.Close()
+			fn.node, err = arch.metadataOnlyNode(snPath, target, fi, previous, start)
+			if err != nil {
+				return FutureNode{}, false, err
+			}
+			return fn, false, nil
+		}
+
 		// use previous list of blobs if the file hasn't changed
-		if previous != nil && !fileChanged(fi, previous, arch.IgnoreInode) {
+		if previous != nil && !fileChanged(fi, previous, arch.IgnoreInode) &&
+			(!arch.StoreContentHash || previous.ContentHash != "") {
 			debug.Log("%v hasn't changed, using old list of blobs", target)
 			arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
 			arch.CompleteBlob(snPath, previous.Size)
@@ -396,6 +567,10 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 			// copy list of blobs
 			fn.node.Content = previous.Content
+			if arch.StoreContentHash {
+				fn.node.ContentHash = previous.ContentHash
+			}
+			arch.recordCheckpoint(snPath, fn.node)
 
 			_ = file.Close()
 			return fn, false, nil
@@ -406,12 +581,28 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.file = arch.fileSaver.Save(ctx, snPath, file, fi, func() {
 			arch.StartFile(snPath)
 		}, func(node *restic.Node, stats ItemStats) {
+			arch.recordCheckpoint(snPath, node)
 			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
 		})
 
 	case fi.IsDir():
 		debug.Log("  %v dir", target)
 
+		if arch.SkipBindMounts {
+			if first, seen := arch.seenDir(snPath, fi); seen {
+				debug.Log("%v has already been saved as %v", target, first)
+				if !arch.BindMountMarker {
+					return FutureNode{}, true, nil
+				}
+
+				fn.node, err = arch.bindMountNode(target, fi, first)
+				if err != nil {
+					return FutureNode{}, false, err
+				}
+				return fn, false, nil
+			}
+		}
+
 		snItem := snPath + "/"
 		start := time.Now()
 		oldSubtree := arch.loadSubtree(ctx, previous)
@@ -590,6 +781,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 			return nil, err
 		}
 
+		arch.recordCheckpoint(snItem, node)
 		arch.CompleteItem(snItem, oldNode, node, nodeStats, time.Since(start))
 	}
 
@@ -662,8 +854,15 @@ func readdir(filesystem fs.FS, dir string) ([]os.FileInfo, error) {
 	return entries, nil
 }
 
-func readdirnames(filesystem fs.FS, dir string) ([]string, error) {
-	f, err := filesystem.OpenFile(dir, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+// readdirnames returns the names of the entries in dir. If dir is a symlink,
+// it is only followed if followSymlink is true.
+func readdirnames(filesystem fs.FS, dir string, followSymlink bool) ([]string, error) {
+	flags := fs.O_RDONLY | fs.O_NOFOLLOW
+	if followSymlink {
+		flags = fs.O_RDONLY
+	}
+
+	f, err := filesystem.OpenFile(dir, flags, 0)
 	if err != nil {
 		return nil, errors.Wrap(err, "Open")
 	}
@@ -698,7 +897,7 @@ func resolveRelativeTargets(fs fs.FS, targets []string) ([]string, error) {
 		}
 
 		debug.Log("replacing %q with readdir(%q)", target, target)
-		entries, err := readdirnames(fs, target)
+		entries, err := readdirnames(fs, target, false)
 		if err != nil {
 			return nil, err
 		}
@@ -716,29 +915,73 @@ func resolveRelativeTargets(fs fs.FS, targets []string) ([]string, error) {
 type SnapshotOptions struct {
 	Tags           []string
 	Hostname       string
+	Description    string
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot restic.ID
+
+	// Deterministic saves the snapshot so that its ID only depends on its
+	// content. Backing up the same data with the same options and time again
+	// then yields the same snapshot ID.
+	Deterministic bool
 }
 
-// loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
-func (arch *Archiver) loadParentTree(ctx context.Context, snapshotID restic.ID) *restic.Tree {
+// metadataOnlyNode returns the node for the regular file target without
+// reading its content, the list of blobs is empty.
+func (arch *Archiver) metadataOnlyNode(snPath, target string, fi os.FileInfo, previous *restic.Node, start time.Time) (*restic.Node, error) {
+	node, err := arch.nodeFromFileInfo(target, fi)
+	if err != nil {
+		return nil, err
+	}
+	node.Content = restic.IDs{}
+
+	arch.recordCheckpoint(snPath, node)
+	arch.CompleteItem(snPath, previous, node, ItemStats{}, time.Since(start))
+	arch.CompleteBlob(snPath, node.Size)
+	return node, nil
+}
+
+// loadParent loads the tree of the parent snapshot id. If the parent is a
+// checkpoint of an interrupted backup, its tree is used, but the first
+// snapshot which is not a checkpoint is returned as the parent for the new
+// snapshot, together with the IDs of the checkpoints. If id is null, the tree
+// is nil.
+func (arch *Archiver) loadParent(ctx context.Context, snapshotID restic.ID) (restic.ID, *restic.Tree, restic.IDs) {
 	if snapshotID.IsNull() {
-		return nil
+		return snapshotID, nil, nil
 	}
 
 	debug.Log("load parent snapshot %v", snapshotID)
 	sn, err := restic.LoadSnapshot(ctx, arch.Repo, snapshotID)
 	if err != nil {
 		debug.Log("unable to load snapshot %v: %v", snapshotID, err)
-		return nil
+		return snapshotID, nil, nil
+	}
+
+	tree := arch.loadParentTree(ctx, snapshotID, sn)
+	if !sn.Checkpoint {
+		return snapshotID, tree, nil
 	}
 
+	parentID, checkpoints := arch.resolveCheckpoints(ctx, snapshotID)
+	debug.Log("parent %v is a checkpoint, using %v as parent", snapshotID, parentID)
+	return parentID, tree, checkpoints
+}
+
+// loadParentTree loads the tree of the snapshot sn with the given id.
+func (arch *Archiver) loadParentTree(ctx context.Context, snapshotID restic.ID, sn *restic.Snapshot) *restic.Tree {
+
 	if sn.Tree == nil {
 		debug.Log("snapshot %v has empty tree %v", snapshotID)
 		return nil
 	}
 
+	// the files in a metadata-only snapshot have no content to reuse
+	if sn.MetadataOnly && !arch.MetadataOnly {
+		debug.Log("snapshot %v is metadata-only, not using it as parent", snapshotID)
+		return nil
+	}
+
 	debug.Log("load parent tree %v", *sn.Tree)
 	tree, err := arch.Repo.LoadTree(ctx, *sn.Tree)
 	if err != nil {
@@ -755,16 +998,30 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver = NewFileSaver(ctx, t,
 		arch.FS,
 		arch.blobSaver.Save,
-		arch.Repo.Config().ChunkerPolynomial,
+		arch.Repo.Config(),
 		arch.Options.FileReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
+	arch.fileSaver.StoreContentHash = arch.StoreContentHash
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
 
 	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
+	arch.treeSaver.CompleteTree = arch.recordCheckpoint
+}
+
+// recordCheckpoint records the completed node for the item snPath, so that
+// it is contained in the next checkpoint.
+func (arch *Archiver) recordCheckpoint(snPath string, node *restic.Node) {
+	arch.checkpointTree.add(snPath, node)
 }
 
-// Snapshot saves several targets and returns a snapshot.
+// Snapshot saves several targets and returns a snapshot. The default tags in
+// the repository config are added to the snapshot, the default host is used
+// if opts.Hostname is empty.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
+	if arch.Options.TreeSplitThreshold > 0 && !arch.Repo.Config().SplitTrees {
+		return nil, restic.ID{}, errors.New("splitting trees is not enabled for the repository")
+	}
+
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
 		return nil, restic.ID{}, err
@@ -775,16 +1032,41 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
+	parentID, parent, checkpoints := arch.loadParent(ctx, opts.ParentSnapshot)
+	opts.ParentSnapshot = parentID
+
+	arch.checkpointTree = nil
+	if arch.Checkpoints {
+		arch.checkpointTree = newCheckpointTree()
+		arch.checkpointMutex.Lock()
+		arch.checkpoint = &checkpointState{
+			targets:     targets,
+			opts:        opts,
+			tree:        arch.checkpointTree,
+			checkpoints: checkpoints,
+		}
+		arch.checkpointMutex.Unlock()
+	}
+
 	var t tomb.Tomb
 	wctx := t.Context(ctx)
 
 	arch.runWorkers(wctx, &t)
 
+	// directories are only skipped if they are saved in the same snapshot
+	arch.seenDirsMutex.Lock()
+	arch.seenDirs = nil
+	arch.seenDirsMutex.Unlock()
+
+	arch.followedMutex.Lock()
+	arch.followed = nil
+	arch.followedMutex.Unlock()
+
 	start := time.Now()
 
 	debug.Log("starting snapshot")
 	rootTreeID, stats, err := func() (restic.ID, ItemStats, error) {
-		tree, err := arch.SaveTree(wctx, "/", atree, arch.loadParentTree(wctx, opts.ParentSnapshot))
+		tree, err := arch.SaveTree(wctx, "/", atree, parent)
 		if err != nil {
 			return restic.ID{}, ItemStats{}, err
 		}
@@ -797,6 +1079,14 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	}()
 	debug.Log("saved tree, error: %v", err)
 
+	// no more checkpoints are saved once all items have been processed
+	arch.checkpointMutex.Lock()
+	if arch.checkpoint != nil {
+		checkpoints = arch.checkpoint.checkpoints
+		arch.checkpoint = nil
+	}
+	arch.checkpointMutex.Unlock()
+
 	t.Kill(nil)
 	werr := t.Wait()
 	debug.Log("err is %v, werr is %v", err, werr)
@@ -804,6 +1094,11 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		err = werr
 	}
 
+	// never save a snapshot of an interrupted backup
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
 	if err != nil {
 		debug.Log("error while saving tree: %v", err)
 		return nil, restic.ID{}, err
@@ -821,18 +1116,50 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
-	sn.Excludes = opts.Excludes
-	if !opts.ParentSnapshot.IsNull() {
-		id := opts.ParentSnapshot
-		sn.Parent = &id
+	sn, err := arch.newSnapshot(targets, opts, rootTreeID)
+	if err != nil {
+		return nil, restic.ID{}, err
 	}
-	sn.Tree = &rootTreeID
 
-	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	var id restic.ID
+	if opts.Deterministic {
+		id, err = arch.Repo.SaveJSONUnpackedDeterministic(ctx, restic.SnapshotFile, sn)
+	} else {
+		id, err = arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	}
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
+	// the checkpoints are superseded by the complete snapshot
+	arch.removeCheckpoints(ctx, checkpoints)
+
 	return sn, id, nil
 }
+
+// newSnapshot returns a new snapshot for the tree with the given targets and
+// options. The default tags in the repository config are added, the default
+// host is used if opts.Hostname is empty.
+func (arch *Archiver) newSnapshot(targets []string, opts SnapshotOptions, tree restic.ID) (*restic.Snapshot, error) {
+	cfg := arch.Repo.Config()
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = cfg.DefaultHost
+	}
+
+	sn, err := restic.NewSnapshot(targets, opts.Tags, hostname, opts.Time)
+	if err != nil {
+		return nil, err
+	}
+	sn.AddTags(cfg.DefaultTags)
+	sn.Excludes = opts.Excludes
+	sn.Description = opts.Description
+	if !opts.ParentSnapshot.IsNull() {
+		id := opts.ParentSnapshot
+		sn.Parent = &id
+	}
+	sn.Tree = &tree
+	sn.MetadataOnly = arch.MetadataOnly
+
+	return sn, nil
+}
@@ -3,6 +3,9 @@ package archiver
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -16,11 +19,13 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	restictest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/walker"
 	tomb "gopkg.in/tomb.v2"
 )
 
@@ -1537,6 +1542,69 @@ func TestArchiverSnapshotSelect(t *testing.T) {
 	}
 }
 
+func TestArchiverSnapshotSelectByContent(t *testing.T) {
+	jpegMagic := "\xff\xd8\xff\xe0\x00\x10JFIF\x00"
+	largeJPEG := jpegMagic + string(restictest.Random(23, 2*ContentSniffLen))
+	largeText := string(restictest.Random(42, 3*ContentSniffLen))
+
+	src := TestDir{
+		"photo.txt":  TestFile{Content: largeJPEG},
+		"small.dat":  TestFile{Content: jpegMagic},
+		"notes.jpg":  TestFile{Content: "plain text with a misleading extension"},
+		"large.jpg":  TestFile{Content: largeText},
+		"empty.jpeg": TestFile{Content: ""},
+	}
+	want := TestDir{
+		"notes.jpg":  TestFile{Content: "plain text with a misleading extension"},
+		"large.jpg":  TestFile{Content: largeText},
+		"empty.jpeg": TestFile{Content: ""},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+
+	arch := New(repo, testFS, Options{})
+	arch.SelectByContent = func(item string, fi os.FileInfo, head []byte) bool {
+		if len(head) > ContentSniffLen {
+			t.Errorf("%v: got %d bytes, want at most %d", item, len(head), ContentSniffLen)
+		}
+		return !strings.HasPrefix(string(head), jpegMagic)
+	}
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	_, snapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TestEnsureSnapshot(t, repo, snapshotID, want)
+
+	// the start of a file must not be read twice
+	TestWalkFiles(t, ".", want, func(filename string, item interface{}) error {
+		file, ok := item.(TestFile)
+		if !ok {
+			return nil
+		}
+
+		if n := testFS.bytesRead[filename]; n != len(file.Content) {
+			t.Errorf("file %v: read %v bytes, wanted %v bytes", filename, n, len(file.Content))
+		}
+		return nil
+	})
+
+	checker.TestCheckRepo(t, repo)
+}
+
 // MockFS keeps track which files are read.
 type MockFS struct {
 	fs.FS
@@ -1674,6 +1742,275 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+// contentHashes returns the content hashes of all files in the snapshot.
+func contentHashes(t testing.TB, repo restic.Repository, id restic.ID) map[string]string {
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := make(map[string]string)
+	err = walker.Walk(context.TODO(), repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node != nil && node.Type == "file" {
+			hashes[strings.TrimPrefix(nodepath, "/")] = node.ContentHash
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hashes
+}
+
+func TestArchiverStoreContentHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"targetfile": TestFile{Content: string(restictest.Random(888, 2*1024*1024+5000))},
+		"empty":      TestFile{Content: ""},
+		"subdir": TestDir{
+			"other": TestFile{Content: "foobar"},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, testFS, Options{})
+	_, firstSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, hash := range contentHashes(t, repo, firstSnapshotID) {
+		if hash != "" {
+			t.Errorf("file %v: unexpected content hash %v", filename, hash)
+		}
+	}
+
+	// checkHashes verifies the content hashes, and that each file has been
+	// read the given number of times
+	checkHashes := func(id restic.ID, reads int) {
+		hashes := contentHashes(t, repo, id)
+		TestWalkFiles(t, ".", src, func(filename string, item interface{}) error {
+			file, ok := item.(TestFile)
+			if !ok {
+				return nil
+			}
+
+			sum := sha256.Sum256([]byte(file.Content))
+			want := hex.EncodeToString(sum[:])
+			if hashes[filepath.ToSlash(filename)] != want {
+				t.Errorf("file %v: wrong content hash, want %v, got %v", filename, want, hashes[filepath.ToSlash(filename)])
+			}
+
+			if n := testFS.bytesRead[filename]; n != reads*len(file.Content) {
+				t.Errorf("file %v: read %v bytes, wanted %v bytes", filename, n, reads*len(file.Content))
+			}
+			return nil
+		})
+	}
+
+	// unchanged files without a hash in the parent snapshot are read again
+	arch = New(repo, testFS, Options{})
+	arch.StoreContentHash = true
+	_, secondSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: firstSnapshotID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHashes(secondSnapshotID, 2)
+
+	// afterwards, the hashes are taken from the parent snapshot
+	_, thirdSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: secondSnapshotID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHashes(thirdSnapshotID, 2)
+
+	checker.TestCheckRepo(t, repo)
+}
+
+// countBlobs returns the number of data and tree blobs in the index of repo.
+func countBlobs(ctx context.Context, repo restic.Repository) (data, trees int) {
+	for pb := range repo.Index().Each(ctx) {
+		switch pb.Type {
+		case restic.DataBlob:
+			data++
+		case restic.TreeBlob:
+			trees++
+		}
+	}
+	return data, trees
+}
+
+func TestArchiverMetadataOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"targetfile": TestFile{Content: string(restictest.Random(888, 2*1024*1024+5000))},
+		"empty":      TestFile{Content: ""},
+		"subdir": TestDir{
+			"other": TestFile{Content: "foobar"},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, testFS, Options{})
+	arch.MetadataOnly = true
+	sn, firstSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sn.MetadataOnly {
+		t.Errorf("snapshot is not marked as metadata-only")
+	}
+
+	data, trees := countBlobs(ctx, repo)
+	if data != 0 {
+		t.Errorf("metadata-only snapshot saved %d data blobs", data)
+	}
+	if trees == 0 {
+		t.Errorf("metadata-only snapshot saved no trees")
+	}
+
+	err = walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node == nil || node.Type != "file" {
+			return false, nil
+		}
+
+		if node.Content == nil || len(node.Content) != 0 {
+			t.Errorf("file %v: expected empty list of blobs, got %v", nodepath, node.Content)
+		}
+
+		fi, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(nodepath)))
+		if err != nil {
+			return false, err
+		}
+		if node.Size != uint64(fi.Size()) {
+			t.Errorf("file %v: wrong size, want %d, got %d", nodepath, fi.Size(), node.Size)
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, n := range testFS.bytesRead {
+		if n != 0 {
+			t.Errorf("file %v: read %d bytes for a metadata-only snapshot", filename, n)
+		}
+	}
+
+	// a metadata-only snapshot is not used as parent of a complete snapshot
+	arch = New(repo, testFS, Options{})
+	_, _, err = arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: firstSnapshotID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TestWalkFiles(t, ".", src, func(filename string, item interface{}) error {
+		file, ok := item.(TestFile)
+		if !ok {
+			return nil
+		}
+		if n := testFS.bytesRead[filename]; n != len(file.Content) {
+			t.Errorf("file %v: read %v bytes, wanted %v bytes", filename, n, len(file.Content))
+		}
+		return nil
+	})
+
+	if data, _ := countBlobs(ctx, repo); data == 0 {
+		t.Errorf("complete snapshot saved no data blobs")
+	}
+
+	checker.TestCheckRepo(t, repo)
+}
+
+func TestArchiverDeterministicSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"file": TestFile{Content: "foobar"},
+		"subdir": TestDir{
+			"other": TestFile{Content: string(restictest.Random(23, 100000))},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	snapshot := func(opts SnapshotOptions) restic.ID {
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+		_, id, err := arch.Snapshot(ctx, []string{"."}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	pinned := time.Date(2020, 5, 4, 3, 2, 1, 0, time.UTC)
+	opts := SnapshotOptions{Time: pinned, Hostname: "host", Deterministic: true}
+
+	first := snapshot(opts)
+	second := snapshot(opts)
+	if !first.Equal(second) {
+		t.Errorf("backups of identical data have different snapshot IDs %v and %v", first.Str(), second.Str())
+	}
+
+	opts.Time = pinned.Add(time.Second)
+	if id := snapshot(opts); id.Equal(first) {
+		t.Errorf("snapshot with a different time has the same ID %v", id.Str())
+	}
+
+	opts.Time = pinned
+	opts.Deterministic = false
+	if id := snapshot(opts); id.Equal(first) {
+		t.Errorf("non-deterministic snapshot has the same ID %v", id.Str())
+	}
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 {
+		t.Errorf("wrong number of snapshots in the repo, want 3, got %d", len(snapshots))
+	}
+
+	checker.TestCheckRepo(t, repo)
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, fi os.FileInfo, err error) error {
@@ -2098,3 +2435,91 @@ func TestMetadataChanged(t *testing.T) {
 
 	checker.TestCheckRepo(t, repo)
 }
+
+func TestArchiverSplitTrees(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	huge := TestDir{}
+	for i := 0; i < 3000; i++ {
+		huge[fmt.Sprintf("file%04d", i)] = TestFile{Content: "foo"}
+	}
+	src := TestDir{"huge": huge}
+
+	tempdir, removeTempdir := restictest.TempDir(t)
+	defer removeTempdir()
+	TestCreateFiles(t, tempdir, src)
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	// splitting trees must be enabled in the repository config
+	plainRepo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	arch := New(plainRepo, fs.Track{FS: fs.Local{}}, Options{TreeSplitThreshold: 4096})
+	_, _, err := arch.Snapshot(ctx, []string{"huge"}, SnapshotOptions{Time: time.Now()})
+	if err == nil {
+		t.Fatal("expected an error for a repository without split trees")
+	}
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	repo := repository.New(mem.New())
+	restictest.OK(t, repo.Init(ctx, restictest.TestPassword, repository.InitOptions{SplitTrees: true}))
+
+	snapshot := func(parent restic.ID) (*restic.Snapshot, restic.ID) {
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{TreeSplitThreshold: 4096})
+		sn, id, err := arch.Snapshot(ctx, []string{"huge"}, SnapshotOptions{Time: time.Now(), ParentSnapshot: parent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sn, id
+	}
+
+	usedTrees := func(sn *restic.Snapshot) restic.BlobSet {
+		blobs := restic.NewBlobSet()
+		err := restic.FindUsedBlobs(ctx, repo, *sn.Tree, blobs, restic.NewBlobSet())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trees := restic.NewBlobSet()
+		for h := range blobs {
+			if h.Type == restic.TreeBlob {
+				trees.Insert(h)
+			}
+		}
+		return trees
+	}
+
+	sn1, id1 := snapshot(restic.ID{})
+
+	root, err := repo.LoadTree(ctx, *sn1.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.LoadTree(ctx, *root.Find("huge").Subtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Chunks) < 2 {
+		t.Fatalf("large tree was not split, chunks: %v", tree.Chunks)
+	}
+	if len(tree.Nodes) != len(huge) {
+		t.Fatalf("wrong number of nodes in joined tree, want %d, got %d", len(huge), len(tree.Nodes))
+	}
+
+	// adding a single entry only saves the changed chunk, the list of chunks
+	// and the root tree
+	restictest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "huge", "file1500a"), []byte("bar"), 0644))
+	sn2, id2 := snapshot(id1)
+
+	trees1, trees2 := usedTrees(sn1), usedTrees(sn2)
+	added := trees2.Sub(trees1)
+	if len(added) > 3 {
+		t.Errorf("single-entry change added %d of %d tree blobs", len(added), len(trees2))
+	}
+
+	huge["file1500a"] = TestFile{Content: "bar"}
+	TestEnsureSnapshot(t, repo, id2, src)
+	checker.TestCheckRepo(t, repo)
+}
@@ -0,0 +1,55 @@
+package archiver
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// dirID identifies a directory by the device and inode.
+type dirID struct {
+	device, inode uint64
+}
+
+// seenDir records that the directory with the file info fi is saved at snPath
+// in the snapshot. If a directory with the same device and inode has been
+// recorded before, its path in the snapshot is returned and seen is true.
+func (arch *Archiver) seenDir(snPath string, fi os.FileInfo) (first string, seen bool) {
+	extFI := fs.ExtendedStat(fi)
+	if extFI.Inode == 0 {
+		// no inode available, e.g. on Windows
+		return "", false
+	}
+
+	id := dirID{device: extFI.DeviceID, inode: extFI.Inode}
+
+	arch.seenDirsMutex.Lock()
+	defer arch.seenDirsMutex.Unlock()
+
+	if arch.seenDirs == nil {
+		arch.seenDirs = make(map[dirID]string)
+	}
+
+	if first, ok := arch.seenDirs[id]; ok {
+		return first, true
+	}
+
+	arch.seenDirs[id] = snPath
+	return "", false
+}
+
+// bindMountNode returns a node for the directory target which is saved as a
+// symlink to the path first within the snapshot.
+func (arch *Archiver) bindMountNode(target string, fi os.FileInfo, first string) (*restic.Node, error) {
+	node, err := arch.nodeFromFileInfo(target, fi)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Type = "symlink"
+	node.Mode = os.ModeSymlink | 0777
+	node.LinkTarget = first
+	node.Subtree = nil
+	return node, nil
+}
@@ -0,0 +1,114 @@
+// +build !windows
+
+package archiver
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+// bindMountFS simulates bind mounts. The file info for a mount point is the
+// one of the source directory, so both have the same device and inode. The
+// contents of the mount point are not redirected, the test needs to create
+// the same files in both directories.
+type bindMountFS struct {
+	fs.FS
+	mounts map[string]string
+}
+
+// renamedFileInfo returns the name of the mount point for the source
+// directory.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string {
+	return fi.name
+}
+
+func (f bindMountFS) Stat(name string) (os.FileInfo, error) {
+	src, ok := f.mounts[name]
+	if !ok {
+		return f.FS.Stat(name)
+	}
+
+	fi, err := f.FS.Stat(src)
+	if err != nil {
+		return fi, err
+	}
+	return renamedFileInfo{fi, f.FS.Base(name)}, nil
+}
+
+func (f bindMountFS) Lstat(name string) (os.FileInfo, error) {
+	src, ok := f.mounts[name]
+	if !ok {
+		return f.FS.Lstat(name)
+	}
+
+	fi, err := f.FS.Lstat(src)
+	if err != nil {
+		return fi, err
+	}
+	return renamedFileInfo{fi, f.FS.Base(name)}, nil
+}
+
+func TestArchiverSkipBindMounts(t *testing.T) {
+	data := TestDir{
+		"file1": TestFile{Content: "foo"},
+		"sub": TestDir{
+			"file2": TestFile{Content: "bar"},
+		},
+	}
+
+	var tests = []struct {
+		skip, marker bool
+		want         TestDir
+		saved        int
+	}{
+		{false, false, TestDir{"data": data, "mnt": data}, 2},
+		{true, false, TestDir{"data": data}, 1},
+		{true, true, TestDir{"data": data, "mnt": TestSymlink{Target: "/data"}}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{"data": data, "mnt": data})
+			defer cleanup()
+
+			back := fs.TestChdir(t, tempdir)
+			defer back()
+
+			testFS := bindMountFS{FS: fs.Local{}, mounts: map[string]string{"mnt": "data"}}
+
+			var m sync.Mutex
+			var saved int
+			arch := New(repo, fs.Track{FS: testFS}, Options{})
+			arch.SkipBindMounts = test.skip
+			arch.BindMountMarker = test.marker
+			arch.CompleteItem = func(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+				if strings.HasSuffix(item, "/file2") {
+					m.Lock()
+					saved++
+					m.Unlock()
+				}
+			}
+
+			_, id, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+			restictest.OK(t, err)
+
+			TestEnsureSnapshot(t, repo, id, test.want)
+			if saved != test.saved {
+				t.Errorf("file2 was saved %d times, want %d", saved, test.saved)
+			}
+		})
+	}
+}
@@ -20,7 +20,7 @@ type BlobSaver struct {
 	repo Saver
 
 	m          sync.Mutex
-	knownBlobs restic.BlobSet
+	knownBlobs map[restic.BlobHandle]*knownBlob
 
 	ch   chan<- saveBlobJob
 	done <-chan struct{}
@@ -32,7 +32,7 @@ func NewBlobSaver(ctx context.Context, t *tomb.Tomb, repo Saver, workers uint) *
 	ch := make(chan saveBlobJob)
 	s := &BlobSaver{
 		repo:       repo,
-		knownBlobs: restic.NewBlobSet(),
+		knownBlobs: make(map[restic.BlobHandle]*knownBlob),
 		ch:         ch,
 		done:       t.Dying(),
 	}
@@ -106,6 +106,13 @@ type saveBlobJob struct {
 	ch  chan<- saveBlobResponse
 }
 
+// knownBlob is a blob which has been passed to Save before. done is closed
+// once the blob has been saved to the repo, err is set if that failed.
+type knownBlob struct {
+	done chan struct{}
+	err  error
+}
+
 type saveBlobResponse struct {
 	id    restic.ID
 	known bool
@@ -116,23 +123,33 @@ func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte)
 	h := restic.BlobHandle{ID: id, Type: t}
 
 	// check if another goroutine has already saved this blob
-	known := false
 	s.m.Lock()
-	if s.knownBlobs.Has(h) {
-		known = true
-	} else {
-		s.knownBlobs.Insert(h)
-		known = false
+	kb, known := s.knownBlobs[h]
+	if !known {
+		kb = &knownBlob{done: make(chan struct{})}
+		s.knownBlobs[h] = kb
 	}
 	s.m.Unlock()
 
-	// blob is already known, nothing to do
+	// blob is already known, wait until it has been passed to the repo, so
+	// that an item is only completed once all its blobs are stored
 	if known {
+		select {
+		case <-kb.done:
+		case <-ctx.Done():
+			return saveBlobResponse{}, ctx.Err()
+		}
+
+		if kb.err != nil {
+			return saveBlobResponse{}, kb.err
+		}
+
 		return saveBlobResponse{
 			id:    id,
 			known: true,
 		}, nil
 	}
+	defer close(kb.done)
 
 	// check if the repo knows this blob
 	if s.repo.Index().Has(id, t) {
@@ -145,6 +162,7 @@ func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte)
 	// otherwise we're responsible for saving it
 	_, err := s.repo.SaveBlob(ctx, t, buf, id)
 	if err != nil {
+		kb.err = err
 		return saveBlobResponse{}, err
 	}
 
@@ -0,0 +1,288 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// checkpointTree records the nodes of all files and directories which have
+// been saved completely during a backup, so that they can be saved as a
+// partial snapshot while the backup is still running.
+type checkpointTree struct {
+	m    sync.Mutex
+	root checkpointDir
+}
+
+// checkpointDir is a directory in a checkpointTree. Once the directory has
+// been saved completely, node is set and the entries are discarded, as they
+// are contained in the subtree of the node.
+type checkpointDir struct {
+	node  *restic.Node
+	dirs  map[string]*checkpointDir
+	nodes map[string]*restic.Node
+}
+
+func newCheckpointTree() *checkpointTree {
+	return &checkpointTree{}
+}
+
+// add records the completed node for the item snPath. Directories are only
+// recorded once their subtree has been saved.
+func (t *checkpointTree) add(snPath string, node *restic.Node) {
+	if t == nil || node == nil || (node.Type == "dir" && node.Subtree == nil) {
+		return
+	}
+
+	names := strings.Split(strings.Trim(snPath, "/"), "/")
+	if len(names) == 0 || names[0] == "" {
+		return
+	}
+
+	// the archiver changes the name of some nodes after they are completed
+	n := *node
+	n.Name = names[len(names)-1]
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	d := &t.root
+	for _, name := range names[:len(names)-1] {
+		// the item is already contained in a completed directory
+		if d.node != nil {
+			return
+		}
+		d = d.dir(name)
+	}
+
+	if d.node != nil {
+		return
+	}
+
+	if n.Type == "dir" {
+		sub := d.dir(n.Name)
+		sub.node = &n
+		sub.dirs = nil
+		sub.nodes = nil
+		return
+	}
+
+	if d.nodes == nil {
+		d.nodes = make(map[string]*restic.Node)
+	}
+	d.nodes[n.Name] = &n
+}
+
+// dir returns the subdirectory name, it is created if necessary.
+func (d *checkpointDir) dir(name string) *checkpointDir {
+	if d.dirs == nil {
+		d.dirs = make(map[string]*checkpointDir)
+	}
+
+	sub, ok := d.dirs[name]
+	if !ok {
+		sub = &checkpointDir{}
+		d.dirs[name] = sub
+	}
+	return sub
+}
+
+// save saves the trees for all recorded items with saveTree and returns the
+// ID of the root tree. If no item has been completed yet, the ID is null.
+func (t *checkpointTree) save(ctx context.Context, saveTree func(context.Context, *restic.Tree) (restic.ID, ItemStats, error)) (restic.ID, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	tree, err := t.root.tree(ctx, saveTree, time.Now())
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if len(tree.Nodes) == 0 {
+		return restic.ID{}, nil
+	}
+
+	return saveCheckpointTree(ctx, saveTree, tree)
+}
+
+// tree returns the tree with the completed entries of the directory d. The
+// subtrees for directories which have not been completed yet are saved with
+// saveTree, their nodes only carry the time of the checkpoint.
+func (d *checkpointDir) tree(ctx context.Context, saveTree func(context.Context, *restic.Tree) (restic.ID, ItemStats, error), now time.Time) (*restic.Tree, error) {
+	tree := restic.NewTree()
+	for _, node := range d.nodes {
+		if err := tree.Insert(node); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(d.dirs))
+	for name := range d.dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sub := d.dirs[name]
+		if sub.node != nil {
+			if err := tree.Insert(sub.node); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		subtree, err := sub.tree(ctx, saveTree, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(subtree.Nodes) == 0 {
+			continue
+		}
+
+		id, err := saveCheckpointTree(ctx, saveTree, subtree)
+		if err != nil {
+			return nil, err
+		}
+
+		node := &restic.Node{
+			Name:       name,
+			Type:       "dir",
+			Mode:       os.ModeDir | 0755,
+			ModTime:    now,
+			AccessTime: now,
+			ChangeTime: now,
+			Subtree:    &id,
+		}
+		if err := tree.Insert(node); err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+func saveCheckpointTree(ctx context.Context, saveTree func(context.Context, *restic.Tree) (restic.ID, ItemStats, error), tree *restic.Tree) (restic.ID, error) {
+	id, _, err := saveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	// the blob saver returns a null ID once the backup has been stopped
+	if id.IsNull() {
+		return restic.ID{}, errors.New("unable to save checkpoint tree, backup has been stopped")
+	}
+
+	return id, nil
+}
+
+// checkpointState is the state of a running backup for saving checkpoints.
+type checkpointState struct {
+	targets []string
+	opts    SnapshotOptions
+	tree    *checkpointTree
+
+	// checkpoints contains the IDs of the checkpoint snapshots which are
+	// replaced by the next checkpoint or the complete snapshot, these are the
+	// last checkpoint of this backup and those of the interrupted backups it
+	// is based on
+	checkpoints restic.IDs
+}
+
+// SaveCheckpoint saves a partial snapshot of the running backup, if
+// Checkpoints is set. The snapshot contains all files and directories which
+// have been completed so far. It is marked as a checkpoint and replaces the
+// previous checkpoints of the backup. Before the snapshot is saved, the
+// pending packs are flushed and saveIndex is called, it must save all
+// indexes which have not been saved yet. If no snapshot is saved, the
+// returned ID is null.
+func (arch *Archiver) SaveCheckpoint(ctx context.Context, saveIndex func(context.Context) error) (restic.ID, error) {
+	arch.checkpointMutex.Lock()
+	defer arch.checkpointMutex.Unlock()
+
+	var tree restic.ID
+	state := arch.checkpoint
+	if state != nil {
+		var err error
+		tree, err = state.tree.save(ctx, arch.saveTree)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	// save the packs which are not full yet, so the blobs in them are
+	// contained in the index
+	err := arch.Repo.Flush(ctx)
+	if err != nil {
+		debug.Log("flush returned an error: %v", err)
+		return restic.ID{}, err
+	}
+
+	err = saveIndex(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if tree.IsNull() {
+		return restic.ID{}, nil
+	}
+
+	sn, err := arch.newSnapshot(state.targets, state.opts, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	sn.Checkpoint = true
+
+	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	debug.Log("saved checkpoint snapshot %v", id)
+
+	arch.removeCheckpoints(ctx, state.checkpoints)
+	state.checkpoints = restic.IDs{id}
+
+	return id, nil
+}
+
+// resolveCheckpoints follows the parents of the checkpoint snapshot id of
+// an interrupted backup. It returns the ID of the first snapshot which is not
+// a checkpoint, or a null ID if there is none, and the IDs of the checkpoints.
+func (arch *Archiver) resolveCheckpoints(ctx context.Context, id restic.ID) (restic.ID, restic.IDs) {
+	var checkpoints restic.IDs
+	for !id.IsNull() {
+		sn, err := restic.LoadSnapshot(ctx, arch.Repo, id)
+		if err != nil || !sn.Checkpoint {
+			return id, checkpoints
+		}
+
+		checkpoints = append(checkpoints, id)
+		if sn.Parent == nil {
+			return restic.ID{}, checkpoints
+		}
+		id = *sn.Parent
+	}
+
+	return id, checkpoints
+}
+
+// removeCheckpoints removes the checkpoint snapshots ids. Checkpoints are
+// only a shortcut for a later backup, so errors are not returned.
+func (arch *Archiver) removeCheckpoints(ctx context.Context, ids restic.IDs) {
+	for _, id := range ids {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+		err := arch.Repo.Backend().Remove(ctx, h)
+		if err != nil {
+			debug.Log("unable to remove checkpoint snapshot %v: %v", id, err)
+			continue
+		}
+		debug.Log("removed checkpoint snapshot %v", id)
+	}
+}
@@ -0,0 +1,58 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func TestCheckpointTree(t *testing.T) {
+	trees := make(map[restic.ID]*restic.Tree)
+	saveTree := func(ctx context.Context, tree *restic.Tree) (restic.ID, ItemStats, error) {
+		buf, err := encodeTree(tree)
+		if err != nil {
+			return restic.ID{}, ItemStats{}, err
+		}
+		id := restic.Hash(buf)
+		trees[id] = tree
+		return id, ItemStats{}, nil
+	}
+
+	subtree := restic.NewRandomID()
+	ct := newCheckpointTree()
+	ct.add("/home/user/file", &restic.Node{Name: "file", Type: "file"})
+	ct.add("/home/user/dir/a", &restic.Node{Name: "a", Type: "file"})
+	ct.add("/home/user/dir", &restic.Node{Name: "dir", Type: "dir", Subtree: &subtree})
+	ct.add("/home/user/dir/b", &restic.Node{Name: "b", Type: "file"})
+	ct.add("/home/user/other", &restic.Node{Name: "other", Type: "dir"})
+
+	id, err := ct.save(context.TODO(), saveTree)
+	restictest.OK(t, err)
+
+	var names []string
+	tree := trees[id]
+	for _, name := range []string{"home", "user"} {
+		restictest.Equals(t, 1, len(tree.Nodes))
+		restictest.Equals(t, name, tree.Nodes[0].Name)
+		tree = trees[*tree.Nodes[0].Subtree]
+	}
+	for _, node := range tree.Nodes {
+		names = append(names, node.Name)
+	}
+
+	// the incomplete dir "other" is not contained in the checkpoint
+	restictest.Equals(t, []string{"dir", "file"}, names)
+	restictest.Equals(t, subtree, *tree.Nodes[0].Subtree)
+}
+
+func TestCheckpointTreeEmpty(t *testing.T) {
+	ct := newCheckpointTree()
+	id, err := ct.save(context.TODO(), func(context.Context, *restic.Tree) (restic.ID, ItemStats, error) {
+		t.Fatal("unexpected tree saved")
+		return restic.ID{}, ItemStats{}, nil
+	})
+	restictest.OK(t, err)
+	restictest.Assert(t, id.IsNull(), "expected null ID for empty checkpoint, got %v", id)
+}
@@ -0,0 +1,48 @@
+package archiver
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/fs"
+)
+
+// ContentSniffLen is the maximum number of bytes from the start of a file
+// which are passed to a SelectByContentFunc.
+const ContentSniffLen = 4096
+
+// SelectByContentFunc returns true for all regular files that should be
+// included, based on the first (up to ContentSniffLen) bytes of the file
+// passed in head.
+type SelectByContentFunc func(item string, fi os.FileInfo, head []byte) bool
+
+// headFile is a file of which the first bytes have already been read. Reading
+// from it returns these bytes first, followed by the rest of the file, so the
+// data does not need to be read twice.
+type headFile struct {
+	fs.File
+	rd io.Reader
+}
+
+func (f *headFile) Read(p []byte) (int, error) {
+	return f.rd.Read(p)
+}
+
+// readHead reads up to ContentSniffLen bytes from the current position of
+// file. It returns the data together with a file which returns the same data
+// as the original file when read from.
+func readHead(file fs.File) (fs.File, []byte, error) {
+	head := make([]byte, ContentSniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	head = head[:n]
+
+	f := &headFile{
+		File: file,
+		rd:   io.MultiReader(bytes.NewReader(head), file),
+	}
+	return f, head, nil
+}
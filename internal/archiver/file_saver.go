@@ -2,6 +2,9 @@ package archiver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
 	"io"
 	"os"
 
@@ -57,30 +60,36 @@ type FileSaver struct {
 	saveFilePool *BufferPool
 	saveBlob     SaveBlobFn
 
-	pol chunker.Pol
+	cfg restic.Config
 
 	ch   chan<- saveFileJob
 	done <-chan struct{}
 
 	CompleteBlob func(filename string, bytes uint64)
 
+	// StoreContentHash configures if the SHA-256 hash of the whole file is
+	// saved in the node.
+	StoreContentHash bool
+
 	NodeFromFileInfo func(filename string, fi os.FileInfo) (*restic.Node, error)
 }
 
 // NewFileSaver returns a new file saver. A worker pool with fileWorkers is
-// started, it is stopped when ctx is cancelled.
-func NewFileSaver(ctx context.Context, t *tomb.Tomb, fs fs.FS, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
+// started, it is stopped when ctx is cancelled. Files are split into chunks as
+// configured in cfg.
+func NewFileSaver(ctx context.Context, t *tomb.Tomb, fs fs.FS, save SaveBlobFn, cfg restic.Config, fileWorkers, blobWorkers uint) *FileSaver {
 	ch := make(chan saveFileJob)
 
 	debug.Log("new file saver with %v file workers and %v blob workers", fileWorkers, blobWorkers)
 
 	poolSize := fileWorkers + blobWorkers
+	_, maxChunkSize := cfg.ChunkerBoundaries()
 
 	s := &FileSaver{
 		fs:           fs,
 		saveBlob:     save,
-		saveFilePool: NewBufferPool(ctx, int(poolSize), chunker.MaxSize),
-		pol:          pol,
+		saveFilePool: NewBufferPool(ctx, int(poolSize), int(maxChunkSize)),
+		cfg:          cfg,
 		ch:           ch,
 		done:         t.Dying(),
 
@@ -165,7 +174,13 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	}
 
 	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	var rd io.Reader = f
+	var hasher hash.Hash
+	if s.StoreContentHash {
+		hasher = sha256.New()
+		rd = io.TeeReader(f, hasher)
+	}
+	s.cfg.ResetChunker(chnker, rd)
 
 	var results []FutureBlob
 
@@ -216,12 +231,16 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		if !res.Known() {
 			stats.DataBlobs++
 			stats.DataSize += uint64(res.Length())
+			stats.DataSizeInRepo += uint64(restic.CiphertextLength(res.Length()))
 		}
 
 		node.Content = append(node.Content, res.ID())
 	}
 
 	node.Size = size
+	if hasher != nil {
+		node.ContentHash = hex.EncodeToString(hasher.Sum(nil))
+	}
 
 	return saveFileResponse{
 		node:  node,
@@ -231,7 +250,7 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
-	chnker := chunker.New(nil, s.pol)
+	chnker := s.cfg.NewChunker(nil)
 
 	for {
 		var job saveFileJob
@@ -45,7 +45,7 @@ func startFileSaver(ctx context.Context, t testing.TB, fs fs.FS) (*FileSaver, *t
 		t.Fatal(err)
 	}
 
-	s := NewFileSaver(ctx, &tmb, fs, saveBlob, pol, workers, workers)
+	s := NewFileSaver(ctx, &tmb, fs, saveBlob, restic.Config{ChunkerPolynomial: pol}, workers, workers)
 	s.NodeFromFileInfo = restic.NodeFromFileInfo
 
 	return s, &tmb
@@ -0,0 +1,87 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// followSymlink returns the file info of the target of the symlink at
+// abstarget, if it is listed in FollowSymlinks. The target is then saved in
+// place of the symlink. For other items, fi is returned unchanged and followed
+// is false.
+//
+// Each symlink target is only followed once per snapshot, so that symlinks
+// which lead back to a directory which is already being saved cannot cause a
+// loop. Symlinks which cannot be followed are reported as an error, the
+// caller saves them as symlinks.
+func (arch *Archiver) followSymlink(abstarget string, fi os.FileInfo) (os.FileInfo, bool, error) {
+	if fi == nil || fi.Mode()&os.ModeSymlink == 0 || !arch.isFollowedSymlink(abstarget) {
+		return fi, false, nil
+	}
+
+	// EvalSymlinks detects loops of symlinks, e.g. a symlink to itself
+	real, err := filepath.EvalSymlinks(abstarget)
+	if err != nil {
+		return fi, false, errors.Errorf("unable to follow symlink: %v", err)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(abstarget))
+	if err != nil {
+		return fi, false, errors.Errorf("unable to follow symlink: %v", err)
+	}
+
+	if fs.HasPathPrefix(real, parent) {
+		return fi, false, errors.Errorf("symlink points to %v, which contains the symlink itself, not following it", real)
+	}
+
+	arch.followedMutex.Lock()
+	first, seen := arch.followed[real]
+	if !seen {
+		if arch.followed == nil {
+			arch.followed = make(map[string]string)
+		}
+		arch.followed[real] = abstarget
+	}
+	arch.followedMutex.Unlock()
+
+	if seen {
+		return fi, false, errors.Errorf("target %v has already been saved via symlink %v, not following it", real, first)
+	}
+
+	targetFI, err := arch.FS.Stat(abstarget)
+	if err != nil {
+		return fi, false, errors.Wrap(err, "Stat")
+	}
+
+	debug.Log("following symlink %v to %v", abstarget, real)
+	return targetFI, true, nil
+}
+
+// isFollowedSymlink returns true if the symlink at abstarget is listed in
+// FollowSymlinks.
+func (arch *Archiver) isFollowedSymlink(abstarget string) bool {
+	for _, p := range arch.FollowSymlinks {
+		if p == abstarget {
+			return true
+		}
+	}
+	return false
+}
+
+// isFollowedDir returns true if dir is a followed symlink to a directory, so
+// that it must be opened without O_NOFOLLOW.
+func (arch *Archiver) isFollowedDir(dir string) bool {
+	if len(arch.FollowSymlinks) == 0 {
+		return false
+	}
+
+	abs, err := arch.FS.Abs(dir)
+	if err != nil {
+		return false
+	}
+	return arch.isFollowedSymlink(abs)
+}
@@ -0,0 +1,88 @@
+// +build !windows
+
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func TestArchiverFollowSymlinks(t *testing.T) {
+	data := TestDir{
+		"file1": TestFile{Content: "foo"},
+		"sub": TestDir{
+			"file2": TestFile{Content: "bar"},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{
+		"data": data,
+		"backup": TestDir{
+			"dirlink":  TestSymlink{Target: "../data"},
+			"dirlink2": TestSymlink{Target: "../data"},
+			"filelink": TestSymlink{Target: "../data/file1"},
+			"other":    TestSymlink{Target: "../data"},
+			"loop":     TestSymlink{Target: "loop"},
+			"parent":   TestSymlink{Target: "."},
+		},
+	})
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	var m sync.Mutex
+	errs := make(map[string]error)
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.Error = func(item string, fi os.FileInfo, err error) error {
+		m.Lock()
+		errs[filepath.Base(item)] = err
+		m.Unlock()
+		return nil
+	}
+	for _, name := range []string{"dirlink", "dirlink2", "filelink", "loop", "parent"} {
+		arch.FollowSymlinks = append(arch.FollowSymlinks, filepath.Join(tempdir, "backup", name))
+	}
+
+	_, id, err := arch.Snapshot(context.TODO(), []string{"backup"}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"backup": TestDir{
+			"dirlink": data,
+			// the target of dirlink2 was already saved via dirlink
+			"dirlink2": TestSymlink{Target: "../data"},
+			"filelink": TestFile{Content: "foo"},
+			"other":    TestSymlink{Target: "../data"},
+			"loop":     TestSymlink{Target: "loop"},
+			"parent":   TestSymlink{Target: "."},
+		},
+	})
+
+	for name, msg := range map[string]string{
+		"dirlink2": "has already been saved",
+		"loop":     "too many links",
+		"parent":   "contains the symlink itself",
+	} {
+		err, ok := errs[name]
+		if !ok {
+			t.Errorf("no error reported for %v", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("wrong error for %v, want %q, got %v", name, msg, err)
+		}
+	}
+	if len(errs) != 3 {
+		t.Errorf("unexpected errors reported: %v", errs)
+	}
+}
@@ -9,10 +9,31 @@ import (
 	"github.com/restic/restic/internal/restic"
 )
 
+// Checkpointer saves a partial snapshot of a running backup. Before the
+// snapshot is saved, saveIndex must be called to save the indexes.
+type Checkpointer interface {
+	SaveCheckpoint(ctx context.Context, saveIndex func(context.Context) error) (restic.ID, error)
+}
+
 // IndexUploader polls the repo for full indexes and uploads them.
 type IndexUploader struct {
 	restic.Repository
 
+	// CheckpointInterval configures how often a checkpoint is written. A
+	// checkpoint saves all pending packs and all indexes which have not been
+	// saved yet, so that the data uploaded so far is reused when an
+	// interrupted backup is restarted. If it is zero, no checkpoints are
+	// written.
+	CheckpointInterval time.Duration
+
+	// Checkpointer saves a checkpoint snapshot for each checkpoint, if it is
+	// set. An interrupted backup can then be restarted with the checkpoint
+	// snapshot as parent, so the files saved so far are not read again.
+	Checkpointer Checkpointer
+
+	// CompleteCheckpoint is called when a checkpoint snapshot has been saved.
+	CompleteCheckpoint func(id restic.ID)
+
 	// Start is called when an index is to be uploaded.
 	Start func()
 
@@ -26,6 +47,14 @@ func (u IndexUploader) Upload(ctx, shutdown context.Context, interval time.Durat
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// a nil channel blocks forever, so no checkpoints are written by default
+	var checkpoint <-chan time.Time
+	if u.CheckpointInterval > 0 {
+		checkpointTicker := time.NewTicker(u.CheckpointInterval)
+		defer checkpointTicker.Stop()
+		checkpoint = checkpointTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -34,20 +63,65 @@ func (u IndexUploader) Upload(ctx, shutdown context.Context, interval time.Durat
 			return nil
 		case <-ticker.C:
 			full := u.Repository.Index().(*repository.MasterIndex).FullIndexes()
-			for _, idx := range full {
-				if u.Start != nil {
-					u.Start()
-				}
-
-				id, err := repository.SaveIndex(ctx, u.Repository, idx)
-				if err != nil {
-					debug.Log("save indexes returned an error: %v", err)
-					return err
-				}
-				if u.Complete != nil {
-					u.Complete(id)
-				}
+			err := u.save(ctx, full)
+			if err != nil {
+				return err
 			}
+		case <-checkpoint:
+			err := u.checkpoint(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkpoint saves the pending packs and all indexes which have not been saved
+// yet. If a Checkpointer is set, it also saves a checkpoint snapshot.
+func (u IndexUploader) checkpoint(ctx context.Context) error {
+	debug.Log("writing checkpoint")
+
+	if u.Checkpointer != nil {
+		id, err := u.Checkpointer.SaveCheckpoint(ctx, func(ctx context.Context) error {
+			return u.save(ctx, u.Repository.Index().(*repository.MasterIndex).NotFinalIndexes())
+		})
+		if err != nil {
+			return err
 		}
+
+		if !id.IsNull() && u.CompleteCheckpoint != nil {
+			u.CompleteCheckpoint(id)
+		}
+		return nil
+	}
+
+	// save the packs which are not full yet, so the blobs in them are
+	// contained in the index
+	err := u.Repository.Flush(ctx)
+	if err != nil {
+		debug.Log("flush returned an error: %v", err)
+		return err
 	}
+
+	return u.save(ctx, u.Repository.Index().(*repository.MasterIndex).NotFinalIndexes())
+}
+
+// save uploads the indexes to the repo.
+func (u IndexUploader) save(ctx context.Context, indexes []*repository.Index) error {
+	for _, idx := range indexes {
+		if u.Start != nil {
+			u.Start()
+		}
+
+		id, err := repository.SaveIndex(ctx, u.Repository, idx)
+		if err != nil {
+			debug.Log("save indexes returned an error: %v", err)
+			return err
+		}
+		if u.Complete != nil {
+			u.Complete(id)
+		}
+	}
+
+	return nil
 }
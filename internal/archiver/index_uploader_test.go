@@ -0,0 +1,142 @@
+package archiver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+// reopenRepository returns a new repository for the backend of repo, with the
+// index loaded from the backend.
+func reopenRepository(t testing.TB, repo restic.Repository) restic.Repository {
+	r := repository.New(repo.Backend())
+	restictest.OK(t, r.SearchKey(context.TODO(), restictest.TestPassword, 10, ""))
+	restictest.OK(t, r.LoadIndex(context.TODO()))
+	return r
+}
+
+func TestIndexUploaderCheckpoint(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, restictest.Random(23, 1000), restic.ID{})
+	restictest.OK(t, err)
+
+	shutdown, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	uploader := IndexUploader{
+		Repository:         repo,
+		CheckpointInterval: 10 * time.Millisecond,
+		Complete: func(restic.ID) {
+			cancel()
+		},
+	}
+
+	restictest.OK(t, uploader.Upload(context.TODO(), shutdown, time.Hour))
+
+	// the blob was not in a finished pack yet, it must have been saved
+	// together with the index by the checkpoint
+	repo2 := reopenRepository(t, repo)
+	restictest.Assert(t, repo2.Index().Has(id, restic.DataBlob), "blob %v not found in saved index", id.Str())
+}
+
+func TestArchiverResumeAfterCheckpoint(t *testing.T) {
+	src := TestDir{
+		"file1": TestFile{Content: string(restictest.Random(1, 3*1024*1024))},
+		"file2": TestFile{Content: string(restictest.Random(2, 3*1024*1024))},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.Checkpoints = true
+	uploader := IndexUploader{Repository: repo, Checkpointer: arch}
+
+	// interrupt the first backup after file1 has been saved and a checkpoint
+	// snapshot has been written
+	var once sync.Once
+	var checkpointID restic.ID
+	arch.CompleteItem = func(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+		if !strings.HasSuffix(item, "file1") {
+			return
+		}
+
+		once.Do(func() {
+			id, err := uploader.Checkpointer.SaveCheckpoint(ctx, func(ctx context.Context) error {
+				return uploader.save(ctx, repo.Index().(*repository.MasterIndex).NotFinalIndexes())
+			})
+			if err != nil {
+				t.Errorf("checkpoint failed: %v", err)
+			}
+			checkpointID = id
+			cancel()
+		})
+	}
+
+	_, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.Assert(t, errors.Cause(err) == context.Canceled, "unexpected error for interrupted backup: %v", err)
+	restictest.Assert(t, !checkpointID.IsNull(), "no checkpoint snapshot saved")
+
+	// restart the backup with a new repository, which only knows about the
+	// index files stored in the backend
+	repo2 := reopenRepository(t, repo)
+
+	checkpoint, err := restic.LoadSnapshot(context.TODO(), repo2, checkpointID)
+	restictest.OK(t, err)
+	restictest.Assert(t, checkpoint.Checkpoint, "snapshot %v is not marked as checkpoint", checkpointID.Str())
+
+	var m sync.Mutex
+	var started []string
+	arch2 := New(repo2, fs.Track{FS: fs.Local{}}, Options{})
+	arch2.StartFile = func(filename string) {
+		m.Lock()
+		started = append(started, filename)
+		m.Unlock()
+	}
+
+	sn, snapshotID, err := arch2.Snapshot(context.Background(), []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: checkpointID})
+	restictest.OK(t, err)
+
+	for _, item := range started {
+		if strings.HasSuffix(item, "file1") {
+			t.Errorf("file1 was read again after the checkpoint")
+		}
+	}
+
+	restictest.Assert(t, sn.Parent == nil, "checkpoint used as parent of the snapshot: %v", sn.Parent)
+
+	// the checkpoint is removed once the backup is complete
+	_, err = restic.LoadSnapshot(context.TODO(), repo2, checkpointID)
+	restictest.Assert(t, err != nil, "checkpoint snapshot %v was not removed", checkpointID.Str())
+
+	TestEnsureSnapshot(t, repo2, snapshotID, src)
+
+	// the trees of the removed checkpoint remain unused until the next prune,
+	// so only the structure of the repository is checked
+	chkr := checker.New(repo2)
+	hints, errs := chkr.LoadIndex(context.TODO())
+	restictest.Assert(t, len(errs) == 0 && len(hints) == 0, "errors loading index: %v %v", errs, hints)
+
+	errChan := make(chan error)
+	go chkr.Structure(context.TODO(), errChan)
+	for err := range errChan {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,61 @@
+package archiver
+
+import (
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// ProgressReporter receives the progress of the archiver. Programs which use
+// the archiver as a library can implement it to get structured progress
+// information instead of setting the callback functions of the Archiver
+// individually.
+//
+// All methods may be called concurrently from several goroutines.
+type ProgressReporter interface {
+	// StartFile is called when a file is being processed by a worker.
+	StartFile(filename string)
+
+	// CompleteBlob is called for all saved blobs for files. Unlike the
+	// other methods, it receives the path of the file on disk instead of the
+	// path in the snapshot.
+	CompleteBlob(filename string, bytes uint64)
+
+	// CompleteItem is called for all files and dirs once they have been
+	// processed successfully, see Archiver.CompleteItem. It is called with
+	// the item "/" once the root tree has been saved.
+	CompleteItem(item string, previous, current *restic.Node, s ItemStats, d time.Duration)
+
+	// Error is called for all errors that occur during backup. When nil is
+	// returned, the archiver continues, otherwise it aborts and passes the
+	// error up the call stack.
+	Error(item string, fi os.FileInfo, err error) error
+}
+
+// SetProgressReporter configures arch to report its progress to p.
+func (arch *Archiver) SetProgressReporter(p ProgressReporter) {
+	arch.StartFile = p.StartFile
+	arch.CompleteBlob = p.CompleteBlob
+	arch.CompleteItem = p.CompleteItem
+	arch.Error = p.Error
+}
+
+// ScanProgressReporter receives the progress of the scanner, which collects
+// the number and size of the files to be saved.
+type ScanProgressReporter interface {
+	// ReportTotal is called for each new item found, with the cumulated
+	// stats so far. It is called with an empty item and the final stats once
+	// the scan is complete.
+	ReportTotal(item string, s ScanStats)
+
+	// ScannerError is called for all errors that occur during the scan, see
+	// ErrorFunc.
+	ScannerError(item string, fi os.FileInfo, err error) error
+}
+
+// SetProgressReporter configures s to report its progress to p.
+func (s *Scanner) SetProgressReporter(p ScanProgressReporter) {
+	s.Result = p.ReportTotal
+	s.Error = p.ScannerError
+}
@@ -0,0 +1,123 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+// recordingReporter records the events it receives.
+type recordingReporter struct {
+	m      sync.Mutex
+	events []string
+	total  ScanStats
+}
+
+func (r *recordingReporter) record(event string) {
+	r.m.Lock()
+	r.events = append(r.events, event)
+	r.m.Unlock()
+}
+
+func (r *recordingReporter) StartFile(filename string) {
+	r.record("start " + filename)
+}
+
+func (r *recordingReporter) CompleteBlob(filename string, bytes uint64) {
+	r.record("blob " + filename)
+}
+
+func (r *recordingReporter) CompleteItem(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+	r.record("complete " + item)
+}
+
+func (r *recordingReporter) Error(item string, fi os.FileInfo, err error) error {
+	r.record("error " + item)
+	return err
+}
+
+func (r *recordingReporter) ReportTotal(item string, s ScanStats) {
+	if item == "" {
+		r.m.Lock()
+		r.total = s
+		r.m.Unlock()
+	}
+}
+
+func (r *recordingReporter) ScannerError(item string, fi os.FileInfo, err error) error {
+	r.record("scanner error " + item)
+	return err
+}
+
+// eventsFor returns the events for item in the order they were received. The
+// path in the snapshot and the path of the file on disk is accepted for item.
+func (r *recordingReporter) eventsFor(item string) []string {
+	var events []string
+	for _, ev := range r.events {
+		for _, prefix := range []string{"start ", "blob ", "complete ", "error "} {
+			if !strings.HasPrefix(ev, prefix) {
+				continue
+			}
+
+			if strings.Trim(strings.TrimPrefix(ev, prefix), "/") == item {
+				events = append(events, ev)
+			}
+		}
+	}
+	return events
+}
+
+func TestProgressReporter(t *testing.T) {
+	src := TestDir{
+		"file1": TestFile{Content: "foo"},
+		"dir": TestDir{
+			"file2": TestFile{Content: "bar"},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	rep := &recordingReporter{}
+
+	sc := NewScanner(fs.Track{FS: fs.Local{}})
+	sc.SetProgressReporter(rep)
+	restictest.OK(t, sc.Scan(context.TODO(), []string{"."}))
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.SetProgressReporter(rep)
+
+	_, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	want := map[string][]string{
+		"file1":     {"start /file1", "blob file1", "complete /file1"},
+		"dir/file2": {"start /dir/file2", "blob dir/file2", "complete /dir/file2"},
+		"dir":       {"complete /dir/"},
+	}
+	for item, events := range want {
+		if !cmp.Equal(events, rep.eventsFor(item)) {
+			t.Errorf("wrong events for %v: %v", item, cmp.Diff(events, rep.eventsFor(item)))
+		}
+	}
+
+	if len(rep.events) == 0 || rep.events[len(rep.events)-1] != "complete /" {
+		t.Errorf("the last event is not the completion of the snapshot: %v", rep.events)
+	}
+
+	wantTotal := ScanStats{Files: 2, Dirs: 2, Bytes: 6}
+	if rep.total != wantTotal {
+		t.Errorf("wrong scan result, want %+v, got %+v", wantTotal, rep.total)
+	}
+}
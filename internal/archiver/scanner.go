@@ -86,7 +86,7 @@ func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string) (Sca
 		stats.Files++
 		stats.Bytes += uint64(fi.Size())
 	case fi.Mode().IsDir():
-		names, err := readdirnames(s.FS, target)
+		names, err := readdirnames(s.FS, target, false)
 		if err != nil {
 			return stats, s.Error(target, fi, err)
 		}
@@ -42,6 +42,10 @@ type TreeSaver struct {
 	saveTree func(context.Context, *restic.Tree) (restic.ID, ItemStats, error)
 	errFn    ErrorFunc
 
+	// CompleteTree is called for each dir once its subtree has been saved,
+	// if it is set.
+	CompleteTree func(snPath string, node *restic.Node)
+
 	ch   chan<- saveTreeJob
 	done <-chan struct{}
 }
@@ -162,6 +166,10 @@ func (s *TreeSaver) worker(ctx context.Context, jobs <-chan saveTreeJob) error {
 			return err
 		}
 
+		if s.CompleteTree != nil {
+			s.CompleteTree(job.snPath, node)
+		}
+
 		job.ch <- saveTreeResponse{
 			node:  node,
 			stats: stats,
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrAppendOnly is returned by AppendOnlyBackend for all operations which
+// would remove or overwrite files.
+var ErrAppendOnly = errors.New("the repository was opened in append-only mode, files cannot be removed")
+
+// IsAppendOnlyError returns true if err was returned because the backend is
+// in append-only mode.
+func IsAppendOnlyError(err error) bool {
+	return errors.Cause(err) == ErrAppendOnly
+}
+
+// AppendOnlyBackend wraps a backend and refuses to remove files. SaveAtomic
+// is not passed through, as it replaces the file at the final location, so
+// all files are created with Save, which fails if the file already exists.
+type AppendOnlyBackend struct {
+	restic.Backend
+}
+
+// statically ensure that AppendOnlyBackend implements restic.Backend.
+var _ restic.Backend = &AppendOnlyBackend{}
+
+// NewAppendOnlyBackend wraps be with a backend that never removes files.
+func NewAppendOnlyBackend(be restic.Backend) *AppendOnlyBackend {
+	return &AppendOnlyBackend{Backend: be}
+}
+
+// Remove returns ErrAppendOnly without calling the wrapped backend.
+func (be *AppendOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("refusing to remove %v in append-only mode", h)
+	return ErrAppendOnly
+}
+
+// Delete returns ErrAppendOnly without calling the wrapped backend.
+func (be *AppendOnlyBackend) Delete(ctx context.Context) error {
+	debug.Log("refusing to delete the repository in append-only mode")
+	return ErrAppendOnly
+}
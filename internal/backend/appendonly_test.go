@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/mock"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestAppendOnlyBackend(t *testing.T) {
+	removes, saves := 0, 0
+
+	be := mock.NewBackend()
+	be.RemoveFn = func(ctx context.Context, h restic.Handle) error {
+		removes++
+		return nil
+	}
+	be.SaveFn = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+		saves++
+		return nil
+	}
+
+	retryBackend := NewRetryBackend(NewAppendOnlyBackend(be), 5, nil)
+
+	h := restic.Handle{Type: restic.LockFile, Name: "foo"}
+	err := retryBackend.Remove(context.TODO(), h)
+	test.Assert(t, IsAppendOnlyError(err), "expected append-only error, got %v", err)
+
+	err = retryBackend.Delete(context.TODO())
+	test.Assert(t, IsAppendOnlyError(err), "expected append-only error, got %v", err)
+
+	// SaveAtomic must fall back to Save, which does not replace existing files
+	_, ok := restic.Backend(NewAppendOnlyBackend(be)).(restic.AtomicSaver)
+	test.Assert(t, !ok, "append-only backend implements SaveAtomic")
+	test.OK(t, retryBackend.SaveAtomic(context.TODO(), h, restic.NewByteReader([]byte("foo"))))
+
+	test.Equals(t, 0, removes)
+	test.Equals(t, 1, saves)
+}
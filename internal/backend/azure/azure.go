@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/restic/restic/internal/backend"
@@ -20,6 +21,9 @@ import (
 // Backend stores data on an azure endpoint.
 type Backend struct {
 	accountName  string
+	accountKey   []byte
+	client       *http.Client
+	accessTiers  map[restic.FileType]string
 	container    *storage.Container
 	sem          *backend.Semaphore
 	prefix       string
@@ -40,6 +44,16 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		return nil, errors.Wrap(err, "NewBasicClient")
 	}
 
+	accountKey, err := base64.StdEncoding.DecodeString(cfg.AccountKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeString")
+	}
+
+	accessTiers, err := parseAccessTiers(cfg.AccessTier)
+	if err != nil {
+		return nil, err
+	}
+
 	client.HTTPClient = &http.Client{Transport: rt}
 
 	service := client.GetBlobService()
@@ -52,6 +66,9 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	be := &Backend{
 		container:   service.GetContainerReference(cfg.Container),
 		accountName: cfg.AccountName,
+		accountKey:  accountKey,
+		client:      client.HTTPClient,
+		accessTiers: accessTiers,
 		sem:         sem,
 		prefix:      cfg.Prefix,
 		Layout: &backend.DefaultLayout{
@@ -146,7 +163,17 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	be.sem.ReleaseToken()
 	debug.Log("%v, err %#v", objName, err)
 
-	return errors.Wrap(err, "CreateBlockBlobFromReader")
+	if err != nil {
+		return errors.Wrap(err, "CreateBlockBlobFromReader")
+	}
+
+	if tier, ok := be.accessTiers[h.Type]; ok {
+		be.sem.GetToken()
+		err = be.setAccessTier(ctx, objName, tier)
+		be.sem.ReleaseToken()
+	}
+
+	return err
 }
 
 func (be *Backend) saveLarge(ctx context.Context, objName string, rd restic.RewindReader) error {
@@ -278,8 +305,9 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo,
 	}
 
 	fi := restic.FileInfo{
-		Size: int64(blob.Properties.ContentLength),
-		Name: h.Name,
+		Size:    int64(blob.Properties.ContentLength),
+		Name:    h.Name,
+		ModTime: time.Time(blob.Properties.LastModified),
 	}
 	return fi, nil
 }
@@ -345,8 +373,9 @@ func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.F
 			}
 
 			fi := restic.FileInfo{
-				Name: path.Base(m),
-				Size: item.Properties.ContentLength,
+				Name:    path.Base(m),
+				Size:    item.Properties.ContentLength,
+				ModTime: time.Time(item.Properties.LastModified),
 			}
 
 			if ctx.Err() != nil {
@@ -16,7 +16,8 @@ type Config struct {
 	Container   string
 	Prefix      string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 20)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 20)"`
+	AccessTier  string `option:"access-tier" help:"set the access tier per file type, e.g. data=Cool,index=Hot (default: account setting)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
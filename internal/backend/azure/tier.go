@@ -0,0 +1,134 @@
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// tierAPIVersion is the first version of the blob service API which supports
+// setting the access tier of a blob.
+const tierAPIVersion = "2017-04-17"
+
+// accessTiers contains the access tiers which can be set for a file, mapped
+// from the lower-case name to the name used by the API. Files in the archive
+// tier cannot be read without rehydrating them first, so it is not supported.
+var accessTiers = map[string]string{
+	"hot":  "Hot",
+	"cool": "Cool",
+}
+
+// parseAccessTiers parses a list of access tiers per file type in the form
+// "data=Cool,index=Hot".
+func parseAccessTiers(s string) (map[restic.FileType]string, error) {
+	tiers := make(map[restic.FileType]string)
+	if s == "" {
+		return tiers, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		data := strings.SplitN(item, "=", 2)
+		if len(data) != 2 {
+			return nil, errors.Errorf("invalid access tier %q, format is type=tier", item)
+		}
+
+		tpe := restic.FileType(strings.TrimSpace(data[0]))
+		switch tpe {
+		case restic.DataFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile, restic.ConfigFile:
+		default:
+			return nil, errors.Errorf("invalid file type %q for access tier", data[0])
+		}
+
+		tier, ok := accessTiers[strings.ToLower(strings.TrimSpace(data[1]))]
+		if !ok {
+			return nil, errors.Errorf("invalid access tier %q for file type %v", data[1], tpe)
+		}
+
+		tiers[tpe] = tier
+	}
+
+	return tiers, nil
+}
+
+// setAccessTier sets the access tier of the blob objName. The storage SDK does
+// not support this operation, so the request is built and signed here.
+func (be *Backend) setAccessTier(ctx context.Context, objName, tier string) error {
+	debug.Log("SetBlobTier(%v, %v)", objName, tier)
+
+	u, err := url.Parse(be.container.GetBlobReference(objName).GetURL())
+	if err != nil {
+		return errors.Wrap(err, "Parse")
+	}
+	u.RawQuery = url.Values{"comp": {"tier"}}.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "NewRequest")
+	}
+
+	req.Header.Set("x-ms-access-tier", tier)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", tierAPIVersion)
+	req.Header.Set("Authorization", "SharedKey "+be.accountName+":"+be.signRequest(req))
+
+	resp, err := be.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "SetBlobTier")
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if err = resp.Body.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("SetBlobTier for %v returned unexpected status %v", objName, resp.Status)
+	}
+
+	return nil
+}
+
+// signRequest returns the shared key signature for a request without body.
+// See https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (be *Backend) signRequest(req *http.Request) string {
+	var headers []string
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+req.Header.Get(name))
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/" + be.accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var params []string
+	for name, values := range query {
+		sort.Strings(values)
+		params = append(params, strings.ToLower(name)+":"+strings.Join(values, ","))
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		resource += "\n" + param
+	}
+
+	// the standard headers (Content-Length, Content-Type, Date, ...) are not
+	// set and therefore empty
+	s := req.Method + strings.Repeat("\n", 12) + strings.Join(headers, "\n") + "\n" + resource
+
+	mac := hmac.New(sha256.New, be.accountKey)
+	_, _ = mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
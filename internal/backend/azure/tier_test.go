@@ -0,0 +1,109 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// tierRoundTripper accepts all uploads and records the access tier set for
+// each blob.
+type tierRoundTripper struct {
+	m     sync.Mutex
+	tiers map[string]string
+	auth  []string
+}
+
+func (rt *tierRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(ioutil.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	status := http.StatusCreated
+	if req.Method == http.MethodPut && req.URL.Query().Get("comp") == "tier" {
+		rt.m.Lock()
+		rt.tiers[req.URL.Path] = req.Header.Get("x-ms-access-tier")
+		rt.auth = append(rt.auth, req.Header.Get("Authorization"))
+		rt.m.Unlock()
+		status = http.StatusOK
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
+		Request:    req,
+	}, nil
+}
+
+func TestAccessTier(t *testing.T) {
+	rt := &tierRoundTripper{tiers: make(map[string]string)}
+
+	cfg := NewConfig()
+	cfg.AccountName = "account"
+	cfg.AccountKey = base64.StdEncoding.EncodeToString([]byte("secret"))
+	cfg.Container = "container"
+	cfg.Prefix = "repo"
+	cfg.AccessTier = "data=Cool,lock=hot"
+
+	be, err := Open(cfg, rt)
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	for _, tpe := range []restic.FileType{restic.DataFile, restic.LockFile, restic.IndexFile} {
+		id := restic.Hash([]byte(tpe))
+		h := restic.Handle{Type: tpe, Name: id.String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	}
+
+	dataID := restic.Hash([]byte(restic.DataFile))
+	lockID := restic.Hash([]byte(restic.LockFile))
+	want := map[string]string{
+		"/container/repo/data/" + dataID.String()[:2] + "/" + dataID.String(): "Cool",
+		"/container/repo/locks/" + lockID.String():                            "Hot",
+	}
+	rtest.Equals(t, want, rt.tiers)
+
+	for _, auth := range rt.auth {
+		rtest.Assert(t, strings.HasPrefix(auth, "SharedKey account:"), "unexpected Authorization header %q", auth)
+	}
+}
+
+func TestParseAccessTiers(t *testing.T) {
+	var tests = []struct {
+		s     string
+		tiers map[restic.FileType]string
+		err   bool
+	}{
+		{"", map[restic.FileType]string{}, false},
+		{"data=Cool", map[restic.FileType]string{restic.DataFile: "Cool"}, false},
+		{"data=cool, index=HOT", map[restic.FileType]string{restic.DataFile: "Cool", restic.IndexFile: "Hot"}, false},
+		{"data", nil, true},
+		{"packs=Cool", nil, true},
+		{"data=Archive", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			tiers, err := parseAccessTiers(test.s)
+			if test.err {
+				rtest.Assert(t, err != nil, "expected error for %q, got none", test.s)
+				return
+			}
+
+			rtest.OK(t, err)
+			rtest.Equals(t, test.tiers, tiers)
+		})
+	}
+}
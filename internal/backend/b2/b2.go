@@ -225,7 +225,7 @@ func (be *b2Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileI
 		debug.Log("Attrs() err %v", err)
 		return restic.FileInfo{}, errors.Wrap(err, "Stat")
 	}
-	return restic.FileInfo{Size: info.Size, Name: h.Name}, nil
+	return restic.FileInfo{Size: info.Size, Name: h.Name, ModTime: info.UploadTimestamp}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -282,8 +282,9 @@ func (be *b2Backend) List(ctx context.Context, t restic.FileType, fn func(restic
 		}
 
 		fi := restic.FileInfo{
-			Name: path.Base(obj.Name()),
-			Size: attrs.Size,
+			Name:    path.Base(obj.Name()),
+			Size:    attrs.Size,
+			ModTime: attrs.UploadTimestamp,
 		}
 
 		if err := fn(fi); err != nil {
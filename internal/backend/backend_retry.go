@@ -32,8 +32,61 @@ func NewRetryBackend(be restic.Backend, maxTries int, report func(string, error,
 	}
 }
 
+// retriedError marks an error returned by a backend which has already retried
+// the failed request itself.
+type retriedError struct {
+	err error
+}
+
+func (e retriedError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the original error.
+func (e retriedError) Cause() error {
+	return e.err
+}
+
+// Retried marks err as returned by a backend which has already retried the
+// failed request itself. RetryBackend does not retry such errors again, so
+// that the number of attempts does not multiply.
+func Retried(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retriedError{err: err}
+}
+
+// IsRetried returns true if err has been marked with Retried, also when it
+// has been wrapped afterwards.
+func IsRetried(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if _, ok := err.(retriedError); ok {
+			return true
+		}
+
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+
+	return false
+}
+
 func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) error {
-	err := backoff.RetryNotify(f,
+	err := backoff.RetryNotify(func() error {
+		err := f()
+		if IsRetried(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	},
 		backoff.WithContext(backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(be.MaxTries)), ctx),
 		func(err error, d time.Duration) {
 			if be.Report != nil {
@@ -53,18 +106,48 @@ func (be *RetryBackend) Save(ctx context.Context, h restic.Handle, rd restic.Rew
 			return err
 		}
 
-		err = be.Backend.Save(ctx, h, rd)
-		if err == nil {
-			return nil
+		return be.save(ctx, h, rd)
+	})
+}
+
+// save stores the data in the backend and removes the file if that fails.
+func (be *RetryBackend) save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	err := be.Backend.Save(ctx, h, rd)
+	if err == nil {
+		return nil
+	}
+
+	debug.Log("Save(%v) failed with error, removing file: %v", h, err)
+	rerr := be.Backend.Remove(ctx, h)
+	if rerr != nil {
+		debug.Log("Remove(%v) returned error: %v", h, err)
+	}
+
+	// return original error
+	return err
+}
+
+// SaveAtomic stores the data in the backend under the given handle, atomically
+// if the underlying backend supports it.
+func (be *RetryBackend) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.retry(ctx, fmt.Sprintf("SaveAtomic(%v)", h), func() error {
+		err := rd.Rewind()
+		if err != nil {
+			return err
 		}
 
-		debug.Log("Save(%v) failed with error, removing file: %v", h, err)
-		rerr := be.Backend.Remove(ctx, h)
-		if rerr != nil {
-			debug.Log("Remove(%v) returned error: %v", h, err)
+		saver, ok := be.Backend.(restic.AtomicSaver)
+		if !ok {
+			return be.save(ctx, h, rd)
 		}
 
-		// return original error
+		// the backend removes its temporary file on errors, the final file
+		// must not be removed here: it was either not written by this call
+		// or has been written completely
+		err = saver.SaveAtomic(ctx, h, rd)
+		if err != nil {
+			debug.Log("SaveAtomic(%v) failed with error: %v", h, err)
+		}
 		return err
 	})
 }
@@ -95,7 +178,15 @@ func (be *RetryBackend) Stat(ctx context.Context, h restic.Handle) (fi restic.Fi
 // Remove removes a File with type t and name.
 func (be *RetryBackend) Remove(ctx context.Context, h restic.Handle) (err error) {
 	return be.retry(ctx, fmt.Sprintf("Remove(%v)", h), func() error {
-		return be.Backend.Remove(ctx, h)
+		err := be.Backend.Remove(ctx, h)
+		if restic.IsRetentionError(err) {
+			// retrying does not help until the retention period has expired
+			return backoff.Permanent(err)
+		}
+		if IsAppendOnlyError(err) {
+			return backoff.Permanent(err)
+		}
+		return err
 	})
 }
 
@@ -247,3 +247,86 @@ func TestBackendLoadRetry(t *testing.T) {
 	test.Equals(t, data, buf)
 	test.Equals(t, 2, attempt)
 }
+
+func TestBackendRemoveRetention(t *testing.T) {
+	attempt := 0
+
+	be := mock.NewBackend()
+	be.RemoveFn = func(ctx context.Context, h restic.Handle) error {
+		attempt++
+		return &restic.RetentionError{Handle: h, Err: errors.New("object is locked")}
+	}
+
+	retryBackend := RetryBackend{
+		Backend:  be,
+		MaxTries: 5,
+	}
+
+	err := retryBackend.Remove(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foo"})
+	test.Assert(t, restic.IsRetentionError(err), "expected retention error, got %v", err)
+	test.Equals(t, 1, attempt)
+}
+
+func TestBackendRetried(t *testing.T) {
+	attempt := 0
+
+	be := mock.NewBackend()
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		attempt++
+		return restic.FileInfo{}, Retried(errors.New("server error"))
+	}
+
+	retryBackend := RetryBackend{
+		Backend:  be,
+		MaxTries: 5,
+	}
+
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foo"})
+	test.Assert(t, IsRetried(err), "expected retried error, got %v", err)
+	test.Equals(t, 1, attempt)
+
+	test.Assert(t, IsRetried(errors.Wrap(Retried(io.ErrUnexpectedEOF), "Load")), "wrapped error is not detected")
+	test.Assert(t, !IsRetried(io.ErrUnexpectedEOF), "plain error is detected as retried")
+	test.Equals(t, io.ErrUnexpectedEOF, errors.Cause(Retried(io.ErrUnexpectedEOF)))
+}
+
+// atomicBackend implements restic.AtomicSaver with saveAtomic.
+type atomicBackend struct {
+	*mock.Backend
+	saveAtomic func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error
+}
+
+func (be atomicBackend) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.saveAtomic(ctx, h, rd)
+}
+
+func TestBackendSaveAtomicRetry(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	attempt := 0
+
+	be := atomicBackend{Backend: mock.NewBackend()}
+	be.saveAtomic = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+		attempt++
+		if attempt == 1 {
+			return errors.New("injected error")
+		}
+
+		_, err := io.Copy(buf, rd)
+		return err
+	}
+	be.RemoveFn = func(ctx context.Context, h restic.Handle) error {
+		t.Errorf("file %v removed, it may belong to a concurrent writer", h)
+		return nil
+	}
+
+	retryBackend := RetryBackend{
+		Backend:  be,
+		MaxTries: 5,
+	}
+
+	data := test.Random(23, 1024)
+	err := retryBackend.SaveAtomic(context.TODO(), restic.Handle{Type: restic.LockFile, Name: "foo"}, restic.NewByteReader(data))
+	test.OK(t, err)
+	test.Equals(t, data, buf.Bytes())
+	test.Equals(t, 2, attempt)
+}
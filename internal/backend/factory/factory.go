@@ -0,0 +1,376 @@
+// Package factory opens and creates the backends for repository locations.
+package factory
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/azure"
+	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/rclone"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/backend/s3"
+	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/limiter"
+	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Options configure how backends are opened and created.
+type Options struct {
+	// Extended contains the options for particular backends, e.g.
+	// "s3.connections=10".
+	Extended options.Options
+
+	// Transport is used for the HTTP based backends. The options which are
+	// specific to a backend are set from the backend's config.
+	Transport backend.TransportOptions
+
+	// limit the throughput of all files in KiB/s, zero means no limit
+	LimitUploadKb   int
+	LimitDownloadKb int
+
+	// limit the throughput of data and metadata files separately in KiB/s,
+	// in addition to the limits above
+	LimitDataUploadKb       int
+	LimitDataDownloadKb     int
+	LimitMetadataUploadKb   int
+	LimitMetadataDownloadKb int
+}
+
+// ParseConfig returns the config for the backend at loc. The options for this
+// backend are applied, missing values are taken from the environment.
+func ParseConfig(loc location.Location, opts options.Options) (interface{}, error) {
+	// only apply options for a particular backend here
+	opts = opts.Extract(loc.Scheme)
+
+	switch loc.Scheme {
+	case "local":
+		cfg := loc.Config.(local.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening local repository at %#v", cfg)
+		return cfg, nil
+
+	case "sftp":
+		cfg := loc.Config.(sftp.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening sftp repository at %#v", cfg)
+		return cfg, nil
+
+	case "s3":
+		cfg := loc.Config.(s3.Config)
+		if cfg.KeyID == "" {
+			cfg.KeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+
+		if cfg.Secret == "" {
+			cfg.Secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+
+		if cfg.Region == "" {
+			cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+
+		if !cfg.RequesterPays && os.Getenv("RESTIC_S3_REQUESTER_PAYS") != "" {
+			b, err := strconv.ParseBool(os.Getenv("RESTIC_S3_REQUESTER_PAYS"))
+			if err != nil {
+				return nil, errors.Fatalf("invalid value for RESTIC_S3_REQUESTER_PAYS: %v", err)
+			}
+			cfg.RequesterPays = b
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening s3 repository at %#v", cfg)
+		return cfg, nil
+
+	case "gs":
+		cfg := loc.Config.(gs.Config)
+		if cfg.ProjectID == "" {
+			cfg.ProjectID = os.Getenv("GOOGLE_PROJECT_ID")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening gs repository at %#v", cfg)
+		return cfg, nil
+
+	case "azure":
+		cfg := loc.Config.(azure.Config)
+		if cfg.AccountName == "" {
+			cfg.AccountName = os.Getenv("AZURE_ACCOUNT_NAME")
+		}
+
+		if cfg.AccountKey == "" {
+			cfg.AccountKey = os.Getenv("AZURE_ACCOUNT_KEY")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening gs repository at %#v", cfg)
+		return cfg, nil
+
+	case "swift":
+		cfg := loc.Config.(swift.Config)
+
+		if err := swift.ApplyEnvironment("", &cfg); err != nil {
+			return nil, err
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening swift repository at %#v", cfg)
+		return cfg, nil
+
+	case "b2":
+		cfg := loc.Config.(b2.Config)
+
+		if cfg.AccountID == "" {
+			cfg.AccountID = os.Getenv("B2_ACCOUNT_ID")
+		}
+
+		if cfg.AccountID == "" {
+			return nil, errors.Fatalf("unable to open B2 backend: Account ID ($B2_ACCOUNT_ID) is empty")
+		}
+
+		if cfg.Key == "" {
+			cfg.Key = os.Getenv("B2_ACCOUNT_KEY")
+		}
+
+		if cfg.Key == "" {
+			return nil, errors.Fatalf("unable to open B2 backend: Key ($B2_ACCOUNT_KEY) is empty")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening b2 repository at %#v", cfg)
+		return cfg, nil
+	case "rest":
+		cfg := loc.Config.(rest.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening rest repository at %#v", cfg)
+		return cfg, nil
+	case "rclone":
+		cfg := loc.Config.(rclone.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening rest repository at %#v", cfg)
+		return cfg, nil
+	case "mirror":
+		cfg := loc.Config.(mirror.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening mirror repository at %#v", cfg)
+		return cfg, nil
+	}
+
+	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
+}
+
+// transportOptions returns the options for the HTTP transport used by the
+// backend with the config cfg.
+func transportOptions(tropts backend.TransportOptions, cfg interface{}) backend.TransportOptions {
+	if cfg, ok := cfg.(rest.Config); ok {
+		tropts.HTTP2 = cfg.HTTP2
+		tropts.MaxIdleConns = int(cfg.MaxIdleConns)
+		tropts.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+
+	return tropts
+}
+
+// Open opens the backend at the location s. It returns an error if there is
+// no repository at the location.
+func Open(ctx context.Context, s string, opts Options) (restic.Backend, error) {
+	debug.Log("parsing location %v", s)
+	loc, err := location.Parse(s)
+	if err != nil {
+		return nil, errors.Fatalf("parsing repository location failed: %v", err)
+	}
+
+	var be restic.Backend
+
+	cfg, err := ParseConfig(loc, opts.Extended)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := backend.Transport(transportOptions(opts.Transport, cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	// wrap the transport so that the throughput via HTTP is limited
+	lim := limiter.NewStaticLimiter(opts.LimitUploadKb, opts.LimitDownloadKb)
+	rt = lim.Transport(rt)
+
+	switch loc.Scheme {
+	case "local":
+		be, err = local.Open(cfg.(local.Config))
+		// wrap the backend in a LimitBackend so that the throughput is limited
+		be = limiter.LimitBackend(be, lim, lim)
+	case "sftp":
+		be, err = sftp.Open(cfg.(sftp.Config))
+		// wrap the backend in a LimitBackend so that the throughput is limited
+		be = limiter.LimitBackend(be, lim, lim)
+	case "s3":
+		be, err = s3.Open(cfg.(s3.Config), rt)
+	case "gs":
+		be, err = gs.Open(cfg.(gs.Config), rt)
+	case "azure":
+		be, err = azure.Open(cfg.(azure.Config), rt)
+	case "swift":
+		be, err = swift.Open(cfg.(swift.Config), rt)
+	case "b2":
+		be, err = b2.Open(ctx, cfg.(b2.Config), rt)
+	case "rest":
+		be, err = rest.Open(cfg.(rest.Config), rt)
+	case "rclone":
+		be, err = rclone.Open(cfg.(rclone.Config), lim)
+	case "mirror":
+		be, err = openMirror(ctx, cfg.(mirror.Config), opts)
+		// wrap the backend in a LimitBackend so that the throughput is limited
+		// for all mirrors together
+		be = limiter.LimitBackend(be, lim, lim)
+
+	default:
+		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
+	}
+
+	if err != nil {
+		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
+	}
+
+	// limit data and metadata files separately, in addition to the limits for
+	// all files above
+	if opts.LimitDataUploadKb > 0 || opts.LimitDataDownloadKb > 0 ||
+		opts.LimitMetadataUploadKb > 0 || opts.LimitMetadataDownloadKb > 0 {
+		be = limiter.LimitBackend(be,
+			limiter.NewStaticLimiter(opts.LimitDataUploadKb, opts.LimitDataDownloadKb),
+			limiter.NewStaticLimiter(opts.LimitMetadataUploadKb, opts.LimitMetadataDownloadKb))
+	}
+
+	// check if config is there
+	fi, err := be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
+	}
+
+	if fi.Size == 0 {
+		return nil, errors.New("config file has zero size, invalid repository?")
+	}
+
+	return be, nil
+}
+
+// Create creates the backend at the location s. The throughput limits in
+// opts are not applied.
+func Create(ctx context.Context, s string, opts Options) (restic.Backend, error) {
+	debug.Log("parsing location %v", s)
+	loc, err := location.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := ParseConfig(loc, opts.Extended)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := backend.Transport(transportOptions(opts.Transport, cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	switch loc.Scheme {
+	case "local":
+		return local.Create(cfg.(local.Config))
+	case "sftp":
+		return sftp.Create(cfg.(sftp.Config))
+	case "s3":
+		return s3.Create(cfg.(s3.Config), rt)
+	case "gs":
+		return gs.Create(cfg.(gs.Config), rt)
+	case "azure":
+		return azure.Create(cfg.(azure.Config), rt)
+	case "swift":
+		return swift.Open(cfg.(swift.Config), rt)
+	case "b2":
+		return b2.Create(ctx, cfg.(b2.Config), rt)
+	case "rest":
+		return rest.Create(cfg.(rest.Config), rt)
+	case "rclone":
+		return rclone.Open(cfg.(rclone.Config), nil)
+	case "mirror":
+		return createMirror(ctx, cfg.(mirror.Config), opts)
+	}
+
+	debug.Log("invalid repository scheme: %v", s)
+	return nil, errors.Fatalf("invalid scheme %q", loc.Scheme)
+}
+
+// openMirror opens all backends of a mirror. The throughput limits are applied
+// to the mirror by the caller, so they are not set for the children.
+func openMirror(ctx context.Context, cfg mirror.Config, opts Options) (restic.Backend, error) {
+	childOpts := opts
+	childOpts.LimitUploadKb, childOpts.LimitDownloadKb = 0, 0
+	childOpts.LimitDataUploadKb, childOpts.LimitDataDownloadKb = 0, 0
+	childOpts.LimitMetadataUploadKb, childOpts.LimitMetadataDownloadKb = 0, 0
+
+	children := make([]restic.Backend, 0, len(cfg.Locations))
+	for _, loc := range cfg.Locations {
+		be, err := Open(ctx, loc, childOpts)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, be)
+	}
+
+	return mirror.New(children, cfg.Quorum)
+}
+
+// createMirror creates all backends of a mirror.
+func createMirror(ctx context.Context, cfg mirror.Config, opts Options) (restic.Backend, error) {
+	children := make([]restic.Backend, 0, len(cfg.Locations))
+	for _, loc := range cfg.Locations {
+		be, err := Create(ctx, loc, opts)
+		if err != nil {
+			return nil, errors.Fatalf("unable to create mirror at %v: %v", loc, err)
+		}
+		children = append(children, be)
+	}
+
+	return mirror.New(children, cfg.Quorum)
+}
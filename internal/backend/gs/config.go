@@ -16,7 +16,8 @@ type Config struct {
 	Bucket    string
 	Prefix    string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 20)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 20)"`
+	KMSKey      string `option:"kms-key" help:"set the Cloud KMS key used to encrypt new files (projects/P/locations/L/keyRings/R/cryptoKeys/K)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/restic/restic/internal/backend"
@@ -34,6 +36,7 @@ type Backend struct {
 	sem          *backend.Semaphore
 	bucketName   string
 	prefix       string
+	kmsKey       string
 	listMaxItems int
 	backend.Layout
 }
@@ -41,6 +44,9 @@ type Backend struct {
 // Ensure that *Backend implements restic.Backend.
 var _ restic.Backend = &Backend{}
 
+// kmsKeyPattern matches the resource name of a Cloud KMS key.
+var kmsKeyPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
 func getStorageService(rt http.RoundTripper) (*storage.Service, error) {
 	// create a new HTTP client
 	httpClient := &http.Client{
@@ -69,11 +75,20 @@ const defaultListMaxItems = 1000
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
+	if cfg.KMSKey != "" && !kmsKeyPattern.MatchString(cfg.KMSKey) {
+		return nil, errors.Errorf("invalid KMS key name %q, format is projects/P/locations/L/keyRings/R/cryptoKeys/K", cfg.KMSKey)
+	}
+
 	service, err := getStorageService(rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "getStorageService")
 	}
 
+	return newBackend(cfg, service)
+}
+
+// newBackend returns a backend for cfg which uses service to access the bucket.
+func newBackend(cfg Config, service *storage.Service) (*Backend, error) {
 	sem, err := backend.NewSemaphore(cfg.Connections)
 	if err != nil {
 		return nil, err
@@ -85,6 +100,7 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		sem:        sem,
 		bucketName: cfg.Bucket,
 		prefix:     cfg.Prefix,
+		kmsKey:     cfg.KMSKey,
 		Layout: &backend.DefaultLayout{
 			Path: cfg.Prefix,
 			Join: path.Join,
@@ -236,11 +252,17 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	// uploads are not providing significant benefit anyways.
 	cs := googleapi.ChunkSize(0)
 
-	info, err := be.service.Objects.Insert(be.bucketName,
+	req := be.service.Objects.Insert(be.bucketName,
 		&storage.Object{
 			Name: objName,
 			Size: uint64(rd.Length()),
-		}).Media(rd, cs).Do()
+		}).Media(rd, cs)
+
+	if be.kmsKey != "" {
+		req = req.KmsKeyName(be.kmsKey)
+	}
+
+	info, err := req.Do()
 
 	be.sem.ReleaseToken()
 
@@ -331,7 +353,17 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 		return restic.FileInfo{}, errors.Wrap(err, "service.Objects.Get")
 	}
 
-	return restic.FileInfo{Size: int64(obj.Size), Name: h.Name}, nil
+	return restic.FileInfo{Size: int64(obj.Size), Name: h.Name, ModTime: parseModTime(obj.Updated)}, nil
+}
+
+// parseModTime converts the modification time of an object to a time.Time,
+// the zero time is returned if it cannot be parsed.
+func parseModTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -406,8 +438,9 @@ func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.F
 			}
 
 			fi := restic.FileInfo{
-				Name: path.Base(m),
-				Size: int64(item.Size),
+				Name:    path.Base(m),
+				Size:    int64(item.Size),
+				ModTime: parseModTime(item.Updated),
 			}
 
 			err := fn(fi)
@@ -0,0 +1,80 @@
+package gs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// uploadRoundTripper accepts all uploads and records the KMS key name passed
+// for each of them.
+type uploadRoundTripper struct {
+	m       sync.Mutex
+	kmsKeys []string
+}
+
+func (rt *uploadRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(ioutil.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	rt.m.Lock()
+	rt.kmsKeys = append(rt.kmsKeys, req.URL.Query().Get("kmsKeyName"))
+	rt.m.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     http.StatusText(http.StatusOK),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`{"size": "6"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestSaveKMSKey(t *testing.T) {
+	const kmsKey = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+	for _, key := range []string{"", kmsKey} {
+		rt := &uploadRoundTripper{}
+		service, err := storage.New(&http.Client{Transport: rt})
+		rtest.OK(t, err)
+
+		cfg := NewConfig()
+		cfg.Bucket = "bucket"
+		cfg.KMSKey = key
+
+		be, err := newBackend(cfg, service)
+		rtest.OK(t, err)
+
+		for _, tpe := range []restic.FileType{restic.DataFile, restic.LockFile} {
+			h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(tpe)).String()}
+			rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte("foobar"))))
+		}
+
+		rtest.Equals(t, []string{key, key}, rt.kmsKeys)
+	}
+}
+
+func TestOpenInvalidKMSKey(t *testing.T) {
+	for _, key := range []string{
+		"foo",
+		"projects/p/locations/global/keyRings/r",
+		"projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+	} {
+		cfg := NewConfig()
+		cfg.Bucket = "bucket"
+		cfg.KMSKey = key
+
+		_, err := Open(cfg, &uploadRoundTripper{})
+		rtest.Assert(t, err != nil, "expected error for KMS key %q, got none", key)
+	}
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// reservedHeaders are managed by the HTTP client and cannot be set as static
+// headers.
+var reservedHeaders = []string{
+	"Host",
+	"Content-Length",
+	"Transfer-Encoding",
+	"Connection",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+// parseHeaders parses and validates the headers given as "Name=value".
+func parseHeaders(list []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, s := range list {
+		data := strings.SplitN(s, "=", 2)
+		if len(data) != 2 {
+			return nil, errors.Errorf("invalid HTTP header %q, must be in the form Name=value", s)
+		}
+
+		name, value := strings.TrimSpace(data[0]), strings.TrimSpace(data[1])
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, errors.Errorf("invalid HTTP header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, errors.Errorf("invalid value for HTTP header %q", name)
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		for _, reserved := range reservedHeaders {
+			if name == reserved {
+				return nil, errors.Errorf("HTTP header %q cannot be set", name)
+			}
+		}
+
+		headers.Add(name, value)
+	}
+
+	return headers, nil
+}
+
+// headerRoundTripper adds static headers to all requests. Headers which are
+// already set on a request are only replaced if override is true.
+type headerRoundTripper struct {
+	rt       http.RoundTripper
+	headers  http.Header
+	override bool
+}
+
+func (h headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	for name, values := range h.headers {
+		if _, ok := req.Header[name]; ok && !h.override {
+			debug.Log("not replacing header %v set for %v", name, req.URL)
+			continue
+		}
+
+		req.Header[name] = values
+	}
+
+	return h.rt.RoundTrip(req)
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/restic/restic/internal/test"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestParseHeaders(t *testing.T) {
+	var tests = []struct {
+		input []string
+		valid bool
+	}{
+		{[]string{"X-Proxy-Auth=secret"}, true},
+		{[]string{"x-proxy-auth = secret=foo"}, true},
+		{[]string{"X-Empty="}, true},
+		{[]string{"X-Proxy-Auth"}, false},
+		{[]string{"=secret"}, false},
+		{[]string{"X Proxy=secret"}, false},
+		{[]string{"X-Proxy=sec\nret"}, false},
+		{[]string{"Host=example.com"}, false},
+		{[]string{"content-length=23"}, false},
+	}
+
+	for _, tt := range tests {
+		_, err := parseHeaders(tt.input)
+		if tt.valid && err != nil {
+			t.Errorf("headers %q: unexpected error %v", tt.input, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("headers %q: expected error, got none", tt.input)
+		}
+	}
+
+	headers, err := parseHeaders([]string{"x-proxy-auth = secret=foo", "X-Multi=a", "X-Multi=b"})
+	test.OK(t, err)
+	test.Equals(t, http.Header{
+		"X-Proxy-Auth": []string{"secret=foo"},
+		"X-Multi":      []string{"a", "b"},
+	}, headers)
+}
+
+func TestHeaderRoundTripper(t *testing.T) {
+	for _, override := range []bool{false, true} {
+		headers, err := parseHeaders([]string{"X-Proxy-Auth=secret", "Authorization=custom", "X-Multi=a", "X-Multi=b"})
+		test.OK(t, err)
+
+		var seen http.Header
+		rt := headerRoundTripper{
+			rt: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				seen = req.Header
+				return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+			}),
+			headers:  headers,
+			override: override,
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/config", nil)
+		test.OK(t, err)
+		req.Header.Set("Authorization", "signature")
+
+		_, err = rt.RoundTrip(req)
+		test.OK(t, err)
+
+		test.Equals(t, "secret", seen.Get("X-Proxy-Auth"))
+		test.Equals(t, []string{"a", "b"}, seen["X-Multi"])
+		if override {
+			test.Equals(t, "custom", seen.Get("Authorization"))
+		} else {
+			test.Equals(t, "signature", seen.Get("Authorization"))
+		}
+
+		// the original request is not modified
+		test.Equals(t, http.Header{"Authorization": []string{"signature"}}, req.Header)
+	}
+}
+
+func TestTransportHeaders(t *testing.T) {
+	_, err := Transport(TransportOptions{Headers: []string{"invalid"}})
+	test.Assert(t, err != nil, "invalid header was accepted")
+
+	rt, err := Transport(TransportOptions{Headers: []string{"X-Proxy-Auth=secret"}})
+	test.OK(t, err)
+	_, ok := rt.(headerRoundTripper)
+	test.Assert(t, ok, "transport does not add headers, got %T", rt)
+}
@@ -22,6 +22,23 @@ type TransportOptions struct {
 
 	// contains the name of a file containing the TLS client certificate and private key in PEM format
 	TLSClientCertKeyFilename string
+
+	// try to use HTTP/2 for HTTPS connections, HTTP/1.1 is used if the server
+	// does not support it
+	HTTP2 bool
+
+	// maximum number of idle connections per host and the time after which
+	// idle connections are closed, zero values select the defaults
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+
+	// static headers which are added to all requests, in the form
+	// "Name=value"
+	Headers []string
+
+	// replace headers which are already set on a request, e.g. by the client
+	// library of a backend, with the values from Headers
+	OverrideHeaders bool
 }
 
 // readPEMCertKey reads a file and returns the PEM encoded certificate and key
@@ -76,6 +93,18 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		TLSClientConfig:       &tls.Config{},
+		// with a custom dialer and TLS config, HTTP/2 is only used when
+		// requested explicitly
+		ForceAttemptHTTP2: opts.HTTP2,
+	}
+
+	if opts.MaxIdleConns > 0 {
+		tr.MaxIdleConns = opts.MaxIdleConns
+		tr.MaxIdleConnsPerHost = opts.MaxIdleConns
+	}
+
+	if opts.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = opts.IdleConnTimeout
 	}
 
 	if opts.TLSClientCertKeyFilename != "" {
@@ -108,6 +137,16 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		tr.TLSClientConfig.RootCAs = pool
 	}
 
+	headers, err := parseHeaders(opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+
 	// wrap in the debug round tripper (if active)
-	return debug.RoundTripper(tr), nil
+	rt := debug.RoundTripper(tr)
+	if len(headers) > 0 {
+		rt = headerRoundTripper{rt: rt, headers: headers, override: opts.OverrideHeaders}
+	}
+
+	return rt, nil
 }
@@ -86,7 +86,67 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 	}
 
 	filename := b.Filename(h)
+	if err := b.writeFile(filename, rd); err != nil {
+		return err
+	}
+
+	return b.syncDir(filepath.Dir(filename))
+}
+
+// testHookBeforeRename is called by SaveAtomic after the data has been written
+// to the temporary file. If it returns an error, SaveAtomic returns
+// immediately, which allows tests to simulate a crash.
+var testHookBeforeRename func(tmpname string) error
+
+// SaveAtomic stores data in the backend at the handle. The data is written to
+// a temporary file first, which is renamed to the final name afterwards.
+func (b *Local) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("SaveAtomic %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
 
+	filename := b.Filename(h)
+	tmpname := filename + restic.TempFileInfix + restic.NewRandomID().String()[:16]
+
+	err := b.writeFile(tmpname, rd)
+	if err != nil {
+		_ = fs.Remove(tmpname)
+		return err
+	}
+
+	if testHookBeforeRename != nil {
+		if err = testHookBeforeRename(tmpname); err != nil {
+			return err
+		}
+	}
+
+	err = fs.Rename(tmpname, filename)
+	if err != nil {
+		_ = fs.Remove(tmpname)
+		return errors.Wrap(err, "Rename")
+	}
+
+	return b.syncDir(filepath.Dir(filename))
+}
+
+// syncDir syncs the directory dir to disk, so that a newly created or renamed
+// file in it is not lost on a crash.
+func (b *Local) syncDir(dir string) error {
+	if b.NoSyncDir {
+		return nil
+	}
+
+	err := fsyncDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "SyncDir")
+	}
+
+	return nil
+}
+
+// writeFile creates a new file with the data from rd and syncs it to disk.
+func (b *Local) writeFile(filename string, rd io.Reader) error {
 	// create new file
 	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
 
@@ -94,9 +154,15 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 		debug.Log("error %v: creating dir", err)
 
 		// error is caused by a missing directory, try to create it
-		mkdirErr := os.MkdirAll(filepath.Dir(filename), backend.Modes.Dir)
+		dir := filepath.Dir(filename)
+		mkdirErr := os.MkdirAll(dir, backend.Modes.Dir)
+		if mkdirErr == nil {
+			// make sure the new directory itself is not lost
+			mkdirErr = b.syncDir(filepath.Dir(dir))
+		}
+
 		if mkdirErr != nil {
-			debug.Log("error creating dir %v: %v", filepath.Dir(filename), mkdirErr)
+			debug.Log("error creating dir %v: %v", dir, mkdirErr)
 		} else {
 			// try again
 			f, err = fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
@@ -175,7 +241,7 @@ func (b *Local) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, err
 		return restic.FileInfo{}, errors.Wrap(err, "Stat")
 	}
 
-	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
+	return restic.FileInfo{Size: fi.Size(), Name: h.Name, ModTime: fi.ModTime()}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -237,8 +303,9 @@ func (b *Local) List(ctx context.Context, t restic.FileType, fn func(restic.File
 		debug.Log("send %v\n", filepath.Base(path))
 
 		rfi := restic.FileInfo{
-			Name: filepath.Base(path),
-			Size: fi.Size(),
+			Name:    filepath.Base(path),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
 		}
 
 		if ctx.Err() != nil {
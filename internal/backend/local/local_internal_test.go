@@ -0,0 +1,141 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSaveAtomic(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := Create(Config{Path: tempdir})
+	rtest.OK(t, err)
+
+	data := []byte("lock file content")
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.LockFile, Name: id.String()}
+
+	rtest.OK(t, be.SaveAtomic(context.TODO(), h, restic.NewByteReader(data)))
+
+	buf, err := ioutil.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	// the temporary file must be gone
+	entries, err := ioutil.ReadDir(filepath.Dir(be.Filename(h)))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+}
+
+func TestSaveAtomicCrash(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := Create(Config{Path: tempdir})
+	rtest.OK(t, err)
+
+	// simulate a crash after the data was written but before the rename
+	var tmpname string
+	testHookBeforeRename = func(name string) error {
+		tmpname = name
+		return errors.New("crash")
+	}
+	defer func() {
+		testHookBeforeRename = nil
+	}()
+
+	data := []byte("lock file content")
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.LockFile, Name: id.String()}
+
+	err = be.SaveAtomic(context.TODO(), h, restic.NewByteReader(data))
+	rtest.Assert(t, err != nil, "expected error from simulated crash")
+
+	rtest.Assert(t, tmpname != "", "hook was not called")
+	_, err = ioutil.ReadFile(tmpname)
+	rtest.OK(t, err)
+
+	found, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "lock file is visible under its final name after a crash")
+
+	// the left-over temporary file must not be listed as a valid lock
+	err = be.List(context.TODO(), restic.LockFile, func(fi restic.FileInfo) error {
+		if _, err := restic.ParseID(fi.Name); err == nil {
+			t.Errorf("temporary file %v is listed as a valid ID", fi.Name)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+}
+
+// recordSyncDir replaces fsyncDir with a function which records the synced
+// directories, and returns a function to restore it.
+func recordSyncDir(dirs *[]string) func() {
+	orig := fsyncDir
+	fsyncDir = func(dir string) error {
+		*dirs = append(*dirs, dir)
+		return nil
+	}
+
+	return func() {
+		fsyncDir = orig
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := Create(Config{Path: tempdir})
+	rtest.OK(t, err)
+
+	var synced []string
+	defer recordSyncDir(&synced)()
+
+	data := []byte("file content")
+	id := restic.Hash(data)
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	rtest.Equals(t, []string{filepath.Dir(be.Filename(h))}, synced)
+
+	synced = nil
+	h = restic.Handle{Type: restic.LockFile, Name: id.String()}
+	rtest.OK(t, be.SaveAtomic(context.TODO(), h, restic.NewByteReader(data)))
+	rtest.Equals(t, []string{filepath.Dir(be.Filename(h))}, synced)
+
+	// the data directory for the pack does not exist yet, its parent must be
+	// synced as well
+	synced = nil
+	h = restic.Handle{Type: restic.DataFile, Name: id.String()}
+	rtest.OK(t, fs.RemoveAll(filepath.Dir(be.Filename(h))))
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	rtest.Equals(t, []string{filepath.Dir(filepath.Dir(be.Filename(h))), filepath.Dir(be.Filename(h))}, synced)
+}
+
+func TestNoSyncDir(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := Create(Config{Path: tempdir, NoSyncDir: true})
+	rtest.OK(t, err)
+
+	var synced []string
+	defer recordSyncDir(&synced)()
+
+	data := []byte("file content")
+	id := restic.Hash(data)
+
+	rtest.OK(t, be.Save(context.TODO(), restic.Handle{Type: restic.DataFile, Name: id.String()}, restic.NewByteReader(data)))
+	rtest.OK(t, be.SaveAtomic(context.TODO(), restic.Handle{Type: restic.LockFile, Name: id.String()}, restic.NewByteReader(data)))
+	rtest.Equals(t, 0, len(synced))
+}
@@ -4,10 +4,32 @@ package local
 
 import (
 	"os"
+	"syscall"
 
 	"github.com/restic/restic/internal/fs"
 )
 
+// fsyncDir syncs the directory dir to disk. It is a variable so that tests can
+// replace it.
+var fsyncDir = func(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if pathErr, ok := err.(*os.PathError); ok && (pathErr.Err == syscall.EINVAL || pathErr.Err == syscall.ENOTSUP) {
+		// some filesystems do not support syncing directories
+		err = nil
+	}
+
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
 // set file to readonly
 func setNewFileMode(f string, mode os.FileMode) error {
 	return fs.Chmod(f, mode)
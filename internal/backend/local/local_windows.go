@@ -4,6 +4,12 @@ import (
 	"os"
 )
 
+// fsyncDir does nothing, directories cannot be synced on Windows. It is a
+// variable so that tests can replace it.
+var fsyncDir = func(dir string) error {
+	return nil
+}
+
 // We don't modify read-only on windows,
 // since it will make us unable to delete the file,
 // and this isn't common practice on this platform.
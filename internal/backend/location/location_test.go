@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/backend/b2"
 	"github.com/restic/restic/internal/backend/local"
@@ -276,8 +277,11 @@ var parseTests = []struct {
 		"rest:http://hostname.foo:1234/",
 		Location{Scheme: "rest",
 			Config: rest.Config{
-				URL:         parseURL("http://hostname.foo:1234/"),
-				Connections: 5,
+				URL:           parseURL("http://hostname.foo:1234/"),
+				Connections:   5,
+				Retries:       3,
+				RetryDelay:    500 * time.Millisecond,
+				RetryMaxDelay: 30 * time.Second,
 			},
 		},
 	},
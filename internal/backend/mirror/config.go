@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Separator separates the locations of the child backends.
+const Separator = "|"
+
+// Config holds the locations of the backends which are mirrored.
+type Config struct {
+	Locations []string
+	Quorum    uint `option:"quorum" help:"number of backends a file must be saved to for the upload to succeed (default: all)"`
+}
+
+func init() {
+	options.Register("mirror", Config{})
+}
+
+// ParseConfig parses a mirror backend config of the form
+// mirror:location1|location2|...
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "mirror:") {
+		return nil, errors.New(`invalid format, prefix "mirror" not found`)
+	}
+
+	var cfg Config
+	for _, loc := range strings.Split(s[7:], Separator) {
+		if loc == "" {
+			return nil, errors.New("mirror location must not be empty")
+		}
+
+		if strings.HasPrefix(loc, "mirror:") {
+			return nil, errors.New("mirror backends cannot be nested")
+		}
+
+		cfg.Locations = append(cfg.Locations, loc)
+	}
+
+	if len(cfg.Locations) < 2 {
+		return nil, errors.New("mirror needs at least two locations")
+	}
+
+	return cfg, nil
+}
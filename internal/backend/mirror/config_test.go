@@ -0,0 +1,50 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+var configTests = []struct {
+	s   string
+	cfg Config
+}{
+	{"mirror:/srv/repo|/mnt/backup/repo", Config{
+		Locations: []string{"/srv/repo", "/mnt/backup/repo"},
+	}},
+	{"mirror:s3:s3.amazonaws.com/bucket/repo|local:/srv/repo|sftp:user@host:/repo", Config{
+		Locations: []string{"s3:s3.amazonaws.com/bucket/repo", "local:/srv/repo", "sftp:user@host:/repo"},
+	}},
+}
+
+func TestParseConfig(t *testing.T) {
+	for i, test := range configTests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cfg, test.cfg) {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+			continue
+		}
+	}
+}
+
+var invalidConfigTests = []string{
+	"mirror:/srv/repo",
+	"mirror:/srv/repo|",
+	"mirror:/srv/repo|mirror:/a|/b",
+	"local:/srv/repo",
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range invalidConfigTests {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q, got none", s)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Package mirror implements a backend which stores all files in several other
+// backends at the same time.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend saves all files to each of its child backends. Files are read from
+// the first child which has them.
+type Backend struct {
+	children []restic.Backend
+	quorum   int
+}
+
+// make sure that *Backend implements restic.Backend and restic.AtomicSaver
+var _ restic.Backend = &Backend{}
+var _ restic.AtomicSaver = &Backend{}
+
+// New returns a backend which mirrors all files to the children. Saving a
+// file succeeds when it has been stored in at least quorum children, a quorum
+// of zero requires all children.
+func New(children []restic.Backend, quorum uint) (*Backend, error) {
+	if len(children) == 0 {
+		return nil, errors.New("no backends to mirror")
+	}
+
+	if quorum > uint(len(children)) {
+		return nil, errors.Errorf("quorum %d is larger than the number of backends (%d)", quorum, len(children))
+	}
+
+	if quorum == 0 {
+		quorum = uint(len(children))
+	}
+
+	return &Backend{children: children, quorum: int(quorum)}, nil
+}
+
+// Location returns the locations of all children.
+func (be *Backend) Location() string {
+	locs := make([]string, 0, len(be.children))
+	for _, child := range be.children {
+		locs = append(locs, child.Location())
+	}
+	return "mirror:" + strings.Join(locs, Separator)
+}
+
+// Test returns true if the file exists in any of the children.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	var firstErr error
+	answered := false
+	for _, child := range be.children {
+		ok, err := child.Test(ctx, h)
+		if err != nil {
+			debug.Log("Test(%v) on %v failed: %v", h, child.Location(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if ok {
+			return true, nil
+		}
+		answered = true
+	}
+
+	// only report an error if none of the children could be asked
+	if !answered {
+		return false, firstErr
+	}
+	return false, nil
+}
+
+// Remove removes the file from all children. Children which do not have the
+// file are ignored, as long as it was removed from at least one of them.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	errs := make([]error, len(be.children))
+	var wg sync.WaitGroup
+	for i, child := range be.children {
+		wg.Add(1)
+		go func(i int, child restic.Backend) {
+			defer wg.Done()
+			errs[i] = child.Remove(ctx, h)
+		}(i, child)
+	}
+	wg.Wait()
+
+	removed := 0
+	var notExistErr error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			removed++
+		case be.children[i].IsNotExist(err):
+			if notExistErr == nil {
+				notExistErr = err
+			}
+		default:
+			return errors.Wrapf(err, "Remove from %v", be.children[i].Location())
+		}
+	}
+
+	if removed == 0 {
+		return notExistErr
+	}
+	return nil
+}
+
+// Close closes all children.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, child := range be.children {
+		if err := child.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Save stores the data from rd in all children concurrently.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.save(ctx, h, rd, func(child restic.Backend, rd restic.RewindReader) error {
+		return child.Save(ctx, h, rd)
+	})
+}
+
+// SaveAtomic stores the data from rd in all children concurrently, using
+// atomic uploads for the children which support them.
+func (be *Backend) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.save(ctx, h, rd, func(child restic.Backend, rd restic.RewindReader) error {
+		return restic.SaveAtomic(ctx, child, h, rd)
+	})
+}
+
+func (be *Backend) save(ctx context.Context, h restic.Handle, rd restic.RewindReader, saveFn func(restic.Backend, restic.RewindReader) error) error {
+	if err := rd.Rewind(); err != nil {
+		return err
+	}
+
+	// the children read the data concurrently, so each one needs its own reader
+	var buf bytes.Buffer
+	buf.Grow(int(rd.Length()))
+	if _, err := buf.ReadFrom(rd); err != nil {
+		return errors.Wrap(err, "ReadFrom")
+	}
+
+	errs := make([]error, len(be.children))
+	var wg sync.WaitGroup
+	for i, child := range be.children {
+		wg.Add(1)
+		go func(i int, child restic.Backend) {
+			defer wg.Done()
+			errs[i] = saveFn(child, restic.NewByteReader(buf.Bytes()))
+		}(i, child)
+	}
+	wg.Wait()
+
+	saved := 0
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			debug.Log("Save(%v) to %v failed: %v", h, be.children[i].Location(), err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "Save to %v", be.children[i].Location())
+			}
+			continue
+		}
+		saved++
+	}
+
+	if saved < be.quorum {
+		return errors.Wrapf(firstErr, "saved to %d of %d backends, need %d", saved, len(be.children), be.quorum)
+	}
+
+	// the failed children may hold a partial copy of the file, which must not
+	// be read or listed later on
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		child := be.children[i]
+		if rerr := child.Remove(ctx, h); rerr != nil && !child.IsNotExist(rerr) {
+			debug.Log("unable to remove partial copy of %v from %v: %v", h, child.Location(), rerr)
+		}
+	}
+
+	return nil
+}
+
+// stat returns information about the file from all children which have it,
+// and the index of the child with the largest copy. A complete copy of the
+// file is never smaller than a partial one.
+func (be *Backend) stat(ctx context.Context, h restic.Handle) ([]*restic.FileInfo, int, error) {
+	infos := make([]*restic.FileInfo, len(be.children))
+	largest := -1
+	var firstErr error
+	for i, child := range be.children {
+		fi, err := child.Stat(ctx, h)
+		if err != nil {
+			debug.Log("Stat(%v) on %v failed: %v", h, child.Location(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		infos[i] = &fi
+		if largest < 0 || fi.Size > infos[largest].Size {
+			largest = i
+		}
+	}
+
+	if largest < 0 {
+		return nil, 0, firstErr
+	}
+	return infos, largest, nil
+}
+
+// Load runs fn with a reader for the file, trying the children in order until
+// one of them succeeds. Children with a copy which is smaller than the
+// largest copy are skipped, and a reader which ends before the requested
+// data has been read makes Load fall through to the next child.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	infos, largest, err := be.stat(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	size := infos[largest].Size
+	want := size - offset
+	if length > 0 && int64(length) < want {
+		want = int64(length)
+	}
+
+	var firstErr error
+	for i, child := range be.children {
+		if infos[i] == nil {
+			continue
+		}
+
+		if infos[i].Size < size {
+			debug.Log("Load(%v) skips %v, its copy has %d of %d bytes", h, child.Location(), infos[i].Size, size)
+			if firstErr == nil {
+				firstErr = errors.Errorf("file %v in %v is truncated to %d of %d bytes", h, child.Location(), infos[i].Size, size)
+			}
+			continue
+		}
+
+		err := child.Load(ctx, h, length, offset, func(rd io.Reader) error {
+			return fn(&sizeCheckReader{rd: rd, want: want})
+		})
+		if err == nil {
+			return nil
+		}
+
+		debug.Log("Load(%v) from %v failed: %v", h, child.Location(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+// sizeCheckReader returns an error instead of io.EOF if the underlying reader
+// ends before want bytes have been read.
+type sizeCheckReader struct {
+	rd   io.Reader
+	want int64
+	read int64
+}
+
+func (r *sizeCheckReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.read += int64(n)
+	if err == io.EOF && r.read < r.want {
+		return n, errors.Errorf("short read, got %d of %d bytes", r.read, r.want)
+	}
+	return n, err
+}
+
+// Stat returns information about the file from the child with the largest
+// copy of it, copies which were not written completely are smaller.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	infos, largest, err := be.stat(ctx, h)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+	return *infos[largest], nil
+}
+
+// List runs fn for each file of type t which is stored in any of the
+// children. Files which are stored in several children are only listed once.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	for _, child := range be.children {
+		err := child.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+			return fn(fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// any of the children.
+func (be *Backend) IsNotExist(err error) bool {
+	for _, child := range be.children {
+		if child.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes all data in all children.
+func (be *Backend) Delete(ctx context.Context) error {
+	var firstErr error
+	for _, child := range be.children {
+		if err := child.Delete(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
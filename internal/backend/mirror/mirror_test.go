@@ -0,0 +1,272 @@
+package mirror_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type mirrorConfig struct {
+	children []restic.Backend
+}
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return &mirrorConfig{}, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.children != nil {
+				be, err := mirror.New(c.children, 0)
+				if err != nil {
+					return nil, err
+				}
+
+				ok, err := be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.children = []restic.Backend{mem.New(), mem.New()}
+			return mirror.New(c.children, 0)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.children == nil {
+				c.children = []restic.Backend{mem.New(), mem.New()}
+			}
+			return mirror.New(c.children, 0)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(cfg interface{}) error {
+			// no cleanup needed
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendMirror(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+// failingBackend returns an error for all reads and writes once failing is set.
+type failingBackend struct {
+	restic.Backend
+	failing bool
+}
+
+var errFailing = errors.New("injected error")
+
+func (be *failingBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.failing {
+		return errFailing
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *failingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if be.failing {
+		return errFailing
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *failingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if be.failing {
+		return restic.FileInfo{}, errFailing
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) []byte {
+	var buf []byte
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	return buf
+}
+
+func TestMirrorSave(t *testing.T) {
+	children := []restic.Backend{mem.New(), mem.New(), mem.New()}
+	be, err := mirror.New(children, 0)
+	rtest.OK(t, err)
+
+	data := rtest.Random(23, 5*1024*1024)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	for i, child := range children {
+		rtest.Assert(t, bytes.Equal(data, load(t, child, h)), "child %d has wrong data", i)
+	}
+
+	// a file is removed from all children
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	for i, child := range children {
+		ok, err := child.Test(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.Assert(t, !ok, "file still exists in child %d", i)
+	}
+}
+
+func TestMirrorLoadFallback(t *testing.T) {
+	first := &failingBackend{Backend: mem.New()}
+	be, err := mirror.New([]restic.Backend{first, mem.New()}, 0)
+	rtest.OK(t, err)
+
+	data := rtest.Random(42, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	first.failing = true
+	rtest.Equals(t, data, load(t, be, h))
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+}
+
+func TestMirrorQuorum(t *testing.T) {
+	failing := &failingBackend{Backend: mem.New(), failing: true}
+	children := []restic.Backend{failing, mem.New(), mem.New()}
+
+	data := rtest.Random(5, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+
+	be, err := mirror.New(children, 0)
+	rtest.OK(t, err)
+	err = be.Save(context.TODO(), h, restic.NewByteReader(data))
+	rtest.Assert(t, errors.Cause(err) == errFailing, "expected injected error, got %v", err)
+	rtest.OK(t, children[1].Remove(context.TODO(), h))
+	rtest.OK(t, children[2].Remove(context.TODO(), h))
+
+	be, err = mirror.New(children, 2)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	// the file is listed once, even though it is missing in the first child
+	failing.failing = false
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.DataFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+	rtest.Equals(t, data, load(t, be, h))
+
+	// removing works although the file is only stored in some of the children
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+
+	_, err = mirror.New(children, 4)
+	rtest.Assert(t, err != nil, "expected error for quorum larger than the number of backends")
+}
+
+// partialBackend stores only the first half of a file and then returns an
+// error, like an upload which was interrupted.
+type partialBackend struct {
+	restic.Backend
+}
+
+func (be *partialBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+
+	err = be.Backend.Save(ctx, h, restic.NewByteReader(buf[:len(buf)/2]))
+	if err != nil {
+		return err
+	}
+	return errFailing
+}
+
+// shortReadBackend returns only the first half of the requested data.
+type shortReadBackend struct {
+	restic.Backend
+}
+
+func (be *shortReadBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		fi, err := be.Backend.Stat(ctx, h)
+		if err != nil {
+			return err
+		}
+		return fn(io.LimitReader(rd, fi.Size/2))
+	})
+}
+
+func TestMirrorRemovePartialCopies(t *testing.T) {
+	partial := &partialBackend{Backend: mem.New()}
+	be, err := mirror.New([]restic.Backend{partial, mem.New(), mem.New()}, 2)
+	rtest.OK(t, err)
+
+	data := rtest.Random(7, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	ok, err := partial.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "partial copy was not removed")
+}
+
+func TestMirrorLoadTruncated(t *testing.T) {
+	first := mem.New()
+	be, err := mirror.New([]restic.Backend{first, mem.New()}, 0)
+	rtest.OK(t, err)
+
+	data := rtest.Random(8, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	// replace the first copy with a truncated one
+	rtest.OK(t, first.Remove(context.TODO(), h))
+	rtest.OK(t, first.Save(context.TODO(), h, restic.NewByteReader(data[:100])))
+
+	rtest.Equals(t, data, load(t, be, h))
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+}
+
+func TestMirrorLoadShortRead(t *testing.T) {
+	be, err := mirror.New([]restic.Backend{&shortReadBackend{Backend: mem.New()}, mem.New()}, 0)
+	rtest.OK(t, err)
+
+	data := rtest.Random(9, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	rtest.Equals(t, data, load(t, be, h))
+
+	var buf []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 200, 700, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	}))
+	rtest.Equals(t, data[700:900], buf)
+}
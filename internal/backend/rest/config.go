@@ -3,6 +3,7 @@ package rest
 import (
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
@@ -12,6 +13,14 @@ import (
 type Config struct {
 	URL         *url.URL
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+
+	Retries       uint          `option:"retries" help:"number of times read requests are retried on server errors (default: 3)"`
+	RetryDelay    time.Duration `option:"retry-delay" help:"base delay before a request is retried, doubled for each retry (default: 500ms)"`
+	RetryMaxDelay time.Duration `option:"retry-max-delay" help:"maximum delay before a request is retried, 0 for no limit (default: 30s)"`
+
+	HTTP2           bool          `option:"http2" help:"use HTTP/2 for HTTPS connections if the server supports it (default: false)"`
+	MaxIdleConns    uint          `option:"max-idle-connections" help:"maximum number of idle connections which are kept open for reuse (default: 100)"`
+	IdleConnTimeout time.Duration `option:"idle-timeout" help:"close connections which have been idle for this duration (default: 90s)"`
 }
 
 func init() {
@@ -21,7 +30,10 @@ func init() {
 // NewConfig returns a new Config with the default values filled in.
 func NewConfig() Config {
 	return Config{
-		Connections: 5,
+		Connections:   5,
+		Retries:       3,
+		RetryDelay:    500 * time.Millisecond,
+		RetryMaxDelay: 30 * time.Second,
 	}
 }
 
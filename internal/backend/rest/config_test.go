@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func parseURL(s string) *url.URL {
@@ -22,15 +23,21 @@ var configTests = []struct {
 	{
 		s: "rest:http://localhost:1234",
 		cfg: Config{
-			URL:         parseURL("http://localhost:1234/"),
-			Connections: 5,
+			URL:           parseURL("http://localhost:1234/"),
+			Connections:   5,
+			Retries:       3,
+			RetryDelay:    500 * time.Millisecond,
+			RetryMaxDelay: 30 * time.Second,
 		},
 	},
 	{
 		s: "rest:http://localhost:1234/",
 		cfg: Config{
-			URL:         parseURL("http://localhost:1234/"),
-			Connections: 5,
+			URL:           parseURL("http://localhost:1234/"),
+			Connections:   5,
+			Retries:       3,
+			RetryDelay:    500 * time.Millisecond,
+			RetryMaxDelay: 30 * time.Second,
 		},
 	},
 }
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context/ctxhttp"
 
@@ -29,6 +32,15 @@ type Backend struct {
 	sem    *backend.Semaphore
 	client *http.Client
 	backend.Layout
+
+	retries       uint
+	retryDelay    time.Duration
+	retryMaxDelay time.Duration
+
+	// random returns a number in [0.0, 1.0) used for jitter, sleep waits for
+	// the given duration. Both can be replaced in tests.
+	random func() float64
+	sleep  func(ctx context.Context, d time.Duration) error
 }
 
 // the REST API protocol version is decided by HTTP request headers, these are the constants.
@@ -57,6 +69,12 @@ func Open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		client: client,
 		Layout: &backend.RESTLayout{URL: url, Join: path.Join},
 		sem:    sem,
+
+		retries:       cfg.Retries,
+		retryDelay:    cfg.RetryDelay,
+		retryMaxDelay: cfg.RetryMaxDelay,
+		random:        rand.Float64,
+		sleep:         sleep,
 	}
 
 	return be, nil
@@ -101,6 +119,104 @@ func Create(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	return be, nil
 }
 
+// retriable returns true if a request which returned resp and err can be
+// retried, which is the case for connection and server errors.
+func retriable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode >= 500
+}
+
+// retryDelayFor returns the time to wait before the given retry (starting at
+// zero). The delay grows exponentially up to retryMaxDelay (if it is zero, the
+// delay is not capped), and the actual value is chosen randomly below that
+// ("full jitter"), so that many clients do not retry at the same time.
+func (b *Backend) retryDelayFor(retry uint) time.Duration {
+	max := b.retryMaxDelay
+	d := b.retryDelay
+	for i := uint(0); i < retry && (max <= 0 || d < max) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+
+	if max > 0 && d > max {
+		d = max
+	}
+
+	return time.Duration(b.random() * float64(d))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do sends req to the server. Idempotent requests (GET and HEAD) are retried
+// on connection and server errors, other requests are sent exactly once. When
+// the retries are exhausted, the error is marked with backend.Retried, so that
+// the request is not retried again by the RetryBackend.
+func (b *Backend) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	retries := b.retries
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		retries = 0
+	}
+
+	for retry := uint(0); ; retry++ {
+		b.sem.GetToken()
+		resp, err := ctxhttp.Do(ctx, b.client, req)
+		b.sem.ReleaseToken()
+
+		if !retriable(ctx, resp, err) || retries == 0 {
+			return resp, err
+		}
+
+		if retry >= retries {
+			if resp != nil {
+				_ = drainAndClose(resp)
+				err = &restic.StatusError{
+					StatusCode: resp.StatusCode,
+					Err:        errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode),
+				}
+			}
+			return nil, backend.Retried(err)
+		}
+
+		if resp != nil {
+			debug.Log("%v %v returned %v, retrying", req.Method, req.URL, resp.Status)
+			_ = drainAndClose(resp)
+		} else {
+			debug.Log("%v %v returned error %v, retrying", req.Method, req.URL, err)
+		}
+
+		if err := b.sleep(ctx, b.retryDelayFor(retry)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// drainAndClose reads the remaining data of the response body and closes it,
+// so that the connection can be reused for further requests.
+func drainAndClose(resp *http.Response) error {
+	_, err := io.Copy(ioutil.Discard, resp.Body)
+	cerr := resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "Copy")
+	}
+	return cerr
+}
+
 // Location returns this backend's location (the server's URL).
 func (b *Backend) Location() string {
 	return b.url.String()
@@ -127,14 +243,11 @@ func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRea
 	// let's the server know what's coming.
 	req.ContentLength = rd.Length()
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
-	b.sem.ReleaseToken()
+	resp, err := b.do(ctx, req)
 
 	if resp != nil {
 		defer func() {
-			_, _ = io.Copy(ioutil.Discard, resp.Body)
-			e := resp.Body.Close()
+			e := drainAndClose(resp)
 
 			if err == nil {
 				err = errors.Wrap(e, "Close")
@@ -147,7 +260,10 @@ func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRea
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode)
+		return &restic.StatusError{
+			StatusCode: resp.StatusCode,
+			Err:        errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode),
+		}
 	}
 
 	return nil
@@ -203,25 +319,22 @@ func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, o
 	req.Header.Set("Accept", ContentTypeV2)
 	debug.Log("Load(%v) send range %v", h, byteRange)
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
-	b.sem.ReleaseToken()
+	resp, err := b.do(ctx, req)
 
 	if err != nil {
 		if resp != nil {
-			_, _ = io.Copy(ioutil.Discard, resp.Body)
-			_ = resp.Body.Close()
+			_ = drainAndClose(resp)
 		}
 		return nil, errors.Wrap(err, "client.Do")
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		_ = resp.Body.Close()
+		_ = drainAndClose(resp)
 		return nil, ErrIsNotExist{h}
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 206 {
-		_ = resp.Body.Close()
+		_ = drainAndClose(resp)
 		return nil, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
 	}
 
@@ -240,20 +353,16 @@ func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, e
 	}
 	req.Header.Set("Accept", ContentTypeV2)
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
-	b.sem.ReleaseToken()
+	resp, err := b.do(ctx, req)
 	if err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "client.Head")
 	}
 
-	_, _ = io.Copy(ioutil.Discard, resp.Body)
-	if err = resp.Body.Close(); err != nil {
+	if err = drainAndClose(resp); err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "Close")
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		_ = resp.Body.Close()
 		return restic.FileInfo{}, ErrIsNotExist{h}
 	}
 
@@ -295,29 +404,23 @@ func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	}
 	req.Header.Set("Accept", ContentTypeV2)
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
-	b.sem.ReleaseToken()
+	resp, err := b.do(ctx, req)
 
 	if err != nil {
 		return errors.Wrap(err, "client.Do")
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		_ = resp.Body.Close()
+		_ = drainAndClose(resp)
 		return ErrIsNotExist{h}
 	}
 
 	if resp.StatusCode != 200 {
+		_ = drainAndClose(resp)
 		return errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode)
 	}
 
-	_, err = io.Copy(ioutil.Discard, resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "Copy")
-	}
-
-	return errors.Wrap(resp.Body.Close(), "Close")
+	return errors.Wrap(drainAndClose(resp), "Close")
 }
 
 // List runs fn for each file in the backend which has the type t. When an
@@ -334,13 +437,14 @@ func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.Fi
 	}
 	req.Header.Set("Accept", ContentTypeV2)
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
-	b.sem.ReleaseToken()
+	resp, err := b.do(ctx, req)
 
 	if err != nil {
 		return errors.Wrap(err, "List")
 	}
+	defer func() {
+		_ = drainAndClose(resp)
+	}()
 
 	if resp.StatusCode != 200 {
 		return errors.Errorf("List failed, server response: %v (%v)", resp.Status, resp.StatusCode)
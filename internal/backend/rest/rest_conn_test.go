@@ -0,0 +1,224 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// memServer implements a minimal REST server which keeps all files in memory.
+type memServer struct {
+	m     sync.Mutex
+	files map[string][]byte
+	proto int
+}
+
+func (s *memServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.proto = req.ProtoMajor
+
+	// list the files in a directory
+	if strings.HasSuffix(req.URL.Path, "/") {
+		type entry struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		}
+		list := []entry{}
+		for name, data := range s.files {
+			if path.Dir(name)+"/" == req.URL.Path {
+				list = append(list, entry{Name: path.Base(name), Size: int64(len(data))})
+			}
+		}
+
+		res.Header().Set("Content-Type", ContentTypeV2)
+		_ = json.NewEncoder(res).Encode(list)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.files[req.URL.Path] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.files[req.URL.Path]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(res, req, "", time.Time{}, bytes.NewReader(data))
+	case http.MethodDelete:
+		if _, ok := s.files[req.URL.Path]; !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.files, req.URL.Path)
+	}
+}
+
+// countingRoundTripper counts the requests and how many of them reused an
+// existing connection.
+type countingRoundTripper struct {
+	rt http.RoundTripper
+
+	m        sync.Mutex
+	requests int
+	reused   int
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.m.Lock()
+			defer c.m.Unlock()
+
+			c.requests++
+			if info.Reused {
+				c.reused++
+			}
+		},
+	}
+
+	return c.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func openConnTestBackend(t testing.TB, srv *httptest.Server, rt http.RoundTripper) *Backend {
+	u, err := url.Parse(srv.URL + "/")
+	rtest.OK(t, err)
+
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, rt)
+	rtest.OK(t, err)
+	return be
+}
+
+func TestConnectionReuse(t *testing.T) {
+	var newConns int
+	var m sync.Mutex
+
+	srv := httptest.NewUnstartedServer(&memServer{files: make(map[string][]byte)})
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			m.Lock()
+			newConns++
+			m.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+	rt := &countingRoundTripper{rt: tr}
+	be := openConnTestBackend(t, srv, rt)
+	ctx := context.TODO()
+
+	for i := 0; i < 10; i++ {
+		data := rtest.Random(i, 100+i)
+		h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(ctx, h, restic.NewByteReader(data)))
+
+		fi, err := be.Stat(ctx, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(data)), fi.Size)
+
+		buf, err := backend.LoadAll(ctx, nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+
+		files := 0
+		rtest.OK(t, be.List(ctx, restic.DataFile, func(restic.FileInfo) error {
+			files++
+			return nil
+		}))
+		rtest.Equals(t, 1, files)
+
+		rtest.OK(t, be.Remove(ctx, h))
+
+		// requests for missing files do not prevent reusing the connection
+		_, err = be.Stat(ctx, h)
+		rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+		err = be.Load(ctx, h, 0, 0, func(rd io.Reader) error { return nil })
+		rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+		rtest.Assert(t, be.Remove(ctx, h) != nil, "removing a missing file did not fail")
+	}
+
+	rtest.Equals(t, 80, rt.requests)
+	rtest.Equals(t, rt.requests-1, rt.reused)
+	rtest.Equals(t, 1, newConns)
+}
+
+func TestHTTP2(t *testing.T) {
+	var tests = []struct {
+		serverHTTP2 bool
+		clientHTTP2 bool
+		proto       int
+	}{
+		{true, true, 2},
+		{true, false, 1},
+		// the client falls back to HTTP/1.1
+		{false, true, 1},
+	}
+
+	for _, test := range tests {
+		srvHandler := &memServer{files: make(map[string][]byte)}
+		srv := httptest.NewUnstartedServer(srvHandler)
+		srv.EnableHTTP2 = test.serverHTTP2
+		srv.StartTLS()
+
+		tempdir, cleanup := rtest.TempDir(t)
+		certfile := filepath.Join(tempdir, "cert.pem")
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+		rtest.OK(t, ioutil.WriteFile(certfile, cert, 0600))
+
+		tr, err := backend.Transport(backend.TransportOptions{
+			RootCertFilenames: []string{certfile},
+			HTTP2:             test.clientHTTP2,
+		})
+		rtest.OK(t, err)
+		be := openConnTestBackend(t, srv, tr)
+
+		data := rtest.Random(23, 1000)
+		h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+
+		srvHandler.m.Lock()
+		proto := srvHandler.proto
+		srvHandler.m.Unlock()
+		if proto != test.proto {
+			t.Errorf("server HTTP/2 %v, client HTTP/2 %v: wrong protocol version, want %d, got %d",
+				test.serverHTTP2, test.clientHTTP2, test.proto, proto)
+		}
+
+		srv.Close()
+		cleanup()
+	}
+}
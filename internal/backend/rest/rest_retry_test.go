@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+)
+
+// failingServer returns a test server which responds with the given status
+// code to the first n requests and with a valid response afterwards.
+func failingServer(t testing.TB, n int, code int) (*httptest.Server, *int) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		if requests <= n {
+			res.WriteHeader(code)
+			return
+		}
+
+		res.Header().Set("Content-Length", "23")
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	return srv, &requests
+}
+
+func newRetryBackend(t testing.TB, srv *httptest.Server, retries uint, random func() float64) (*Backend, *[]time.Duration) {
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewConfig()
+	cfg.URL = u
+	cfg.Retries = retries
+	cfg.RetryDelay = 100 * time.Millisecond
+	cfg.RetryMaxDelay = time.Second
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var delays []time.Duration
+	be.random = random
+	be.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	return be, &delays
+}
+
+var testHandle = restic.Handle{Type: restic.DataFile, Name: "foo"}
+
+func TestRetryBackoff(t *testing.T) {
+	srv, requests := failingServer(t, 5, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	be, delays := newRetryBackend(t, srv, 10, func() float64 { return 0.5 })
+
+	fi, err := be.Stat(context.TODO(), testHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size != 23 {
+		t.Fatalf("wrong size returned, want 23, got %v", fi.Size)
+	}
+
+	if *requests != 6 {
+		t.Fatalf("wrong number of requests, want 6, got %v", *requests)
+	}
+
+	// the delay doubles for each retry until the maximum delay is reached
+	want := []time.Duration{
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		500 * time.Millisecond,
+	}
+
+	if len(*delays) != len(want) {
+		t.Fatalf("wrong number of delays, want %v, got %v", want, *delays)
+	}
+
+	for i := range want {
+		if (*delays)[i] != want[i] {
+			t.Errorf("delay %d: want %v, got %v", i, want[i], (*delays)[i])
+		}
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	srv, _ := failingServer(t, 3, http.StatusInternalServerError)
+	defer srv.Close()
+
+	random := []float64{0.9, 0.1, 0.6}
+	be, delays := newRetryBackend(t, srv, 10, func() float64 {
+		r := random[0]
+		random = random[1:]
+		return r
+	})
+
+	_, err := be.Stat(context.TODO(), testHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Duration{
+		90 * time.Millisecond,
+		20 * time.Millisecond,
+		240 * time.Millisecond,
+	}
+
+	for i := range want {
+		if (*delays)[i] != want[i] {
+			t.Errorf("delay %d: want %v, got %v", i, want[i], (*delays)[i])
+		}
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	srv, requests := failingServer(t, 10, http.StatusBadGateway)
+	defer srv.Close()
+
+	be, _ := newRetryBackend(t, srv, 2, func() float64 { return 0 })
+
+	_, err := be.Stat(context.TODO(), testHandle)
+	if err == nil {
+		t.Fatal("expected error not returned")
+	}
+
+	if *requests != 3 {
+		t.Fatalf("wrong number of requests, want 3, got %v", *requests)
+	}
+}
+
+func TestRetryUncapped(t *testing.T) {
+	srv, _ := failingServer(t, 6, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	be, delays := newRetryBackend(t, srv, 10, func() float64 { return 0.5 })
+	be.retryMaxDelay = 0
+
+	_, err := be.Stat(context.TODO(), testHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a maximum delay, the delay keeps doubling
+	want := []time.Duration{
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1600 * time.Millisecond,
+	}
+
+	if len(*delays) != len(want) {
+		t.Fatalf("wrong number of delays, want %v, got %v", want, *delays)
+	}
+
+	for i := range want {
+		if (*delays)[i] != want[i] {
+			t.Errorf("delay %d: want %v, got %v", i, want[i], (*delays)[i])
+		}
+	}
+}
+
+func TestRetryNotRepeated(t *testing.T) {
+	srv, requests := failingServer(t, 100, http.StatusBadGateway)
+	defer srv.Close()
+
+	be, _ := newRetryBackend(t, srv, 2, func() float64 { return 0 })
+
+	// the RetryBackend must not retry requests which the REST backend has
+	// retried already
+	_, err := backend.NewRetryBackend(be, 5, nil).Stat(context.TODO(), testHandle)
+	if err == nil {
+		t.Fatal("expected error not returned")
+	}
+
+	if !restic.IsTransientError(err) {
+		t.Errorf("server error is not reported as transient: %v", err)
+	}
+
+	if *requests != 3 {
+		t.Fatalf("wrong number of requests, want 3, got %v", *requests)
+	}
+}
+
+func TestRetryNoClientErrors(t *testing.T) {
+	srv, requests := failingServer(t, 1, http.StatusForbidden)
+	defer srv.Close()
+
+	be, delays := newRetryBackend(t, srv, 10, func() float64 { return 0 })
+
+	_, err := be.Stat(context.TODO(), testHandle)
+	if err == nil {
+		t.Fatal("expected error not returned")
+	}
+
+	if *requests != 1 || len(*delays) != 0 {
+		t.Fatalf("request with client error was retried %d times", *requests-1)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	srv, requests := failingServer(t, 1, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	be, _ := newRetryBackend(t, srv, 10, func() float64 { return 0 })
+
+	err := be.Remove(context.TODO(), testHandle)
+	if err == nil {
+		t.Fatal("expected error not returned")
+	}
+
+	if *requests != 1 {
+		t.Fatalf("DELETE request was retried %d times", *requests-1)
+	}
+}
@@ -3,6 +3,7 @@ package s3
 import (
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/restic/restic/internal/errors"
@@ -20,11 +21,20 @@ type Config struct {
 	Layout        string `option:"layout" help:"use this backend layout (default: auto-detect)"`
 	StorageClass  string `option:"storage-class" help:"set S3 storage class (STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING or REDUCED_REDUNDANCY)"`
 
-	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
-	MaxRetries  uint   `option:"retries" help:"set the number of retries attempted"`
-	Region      string `option:"region" help:"set region"`
+	Connections   uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	MaxRetries    uint   `option:"retries" help:"set the number of retries attempted"`
+	Region        string `option:"region" help:"set region"`
+	RequesterPays bool   `option:"requester-pays" help:"send the requester pays header when reading from the bucket"`
+
+	ObjectLockDays uint `option:"object-lock-days" help:"protect new files with S3 Object Lock for the given number of days"`
+	ListMaxKeys    uint `option:"list-max-keys" help:"set the number of files returned per list request, between 1 and 1000 (default: 1000)"`
+	PartSize       uint `option:"part-size" help:"upload files larger than this many MiB in parts of this size, at least 5 (default: disabled)"`
 }
 
+// maxListMaxKeys is the largest number of keys S3 returns for a single list
+// request.
+const maxListMaxKeys = 1000
+
 // NewConfig returns a new Config with the default values filled in.
 func NewConfig() Config {
 	return Config{
@@ -39,8 +49,53 @@ func init() {
 // ParseConfig parses the string s and extracts the s3 config. The two
 // supported configuration formats are s3://host/bucketname/prefix and
 // s3:host/bucketname/prefix. The host can also be a valid s3 region
-// name. If no prefix is given the prefix "restic" will be used.
+// name. If no prefix is given the prefix "restic" will be used. Settings can
+// be passed as a query string, e.g. s3:host/bucketname?requester-pays=true.
 func ParseConfig(s string) (interface{}, error) {
+	var query string
+	if i := strings.Index(s, "?"); i >= 0 {
+		s, query = s[:i], s[i+1:]
+	}
+
+	cfg, err := parseLocation(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if query != "" {
+		if err := parseQuery(&cfg, query); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseQuery applies the settings in the query string of the repository
+// location to cfg.
+func parseQuery(cfg *Config, query string) error {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return errors.Wrap(err, "url.ParseQuery")
+	}
+
+	for key, value := range values {
+		switch key {
+		case "requester-pays":
+			b, err := strconv.ParseBool(value[len(value)-1])
+			if err != nil {
+				return errors.Errorf("s3: invalid value %q for requester-pays", value[len(value)-1])
+			}
+			cfg.RequesterPays = b
+		default:
+			return errors.Errorf("s3: unknown parameter %q", key)
+		}
+	}
+
+	return nil
+}
+
+func parseLocation(s string) (Config, error) {
 	switch {
 	case strings.HasPrefix(s, "s3:http"):
 		// assume that a URL has been specified, parse it and
@@ -48,11 +103,11 @@ func ParseConfig(s string) (interface{}, error) {
 		// bucket name and prefix
 		url, err := url.Parse(s[3:])
 		if err != nil {
-			return nil, errors.Wrap(err, "url.Parse")
+			return Config{}, errors.Wrap(err, "url.Parse")
 		}
 
 		if url.Path == "" {
-			return nil, errors.New("s3: bucket name not found")
+			return Config{}, errors.New("s3: bucket name not found")
 		}
 
 		path := strings.SplitN(url.Path[1:], "/", 2)
@@ -62,7 +117,7 @@ func ParseConfig(s string) (interface{}, error) {
 	case strings.HasPrefix(s, "s3:"):
 		s = s[3:]
 	default:
-		return nil, errors.New("s3: invalid format")
+		return Config{}, errors.New("s3: invalid format")
 	}
 	// use the first entry of the path as the endpoint and the
 	// remainder as bucket name and prefix
@@ -70,9 +125,9 @@ func ParseConfig(s string) (interface{}, error) {
 	return createConfig(path[0], path[1:], false)
 }
 
-func createConfig(endpoint string, p []string, useHTTP bool) (interface{}, error) {
+func createConfig(endpoint string, p []string, useHTTP bool) (Config, error) {
 	if len(p) < 1 {
-		return nil, errors.New("s3: invalid format, host/region or bucket name not found")
+		return Config{}, errors.New("s3: invalid format, host/region or bucket name not found")
 	}
 
 	var prefix string
@@ -94,6 +94,27 @@ var configTests = []struct {
 		UseHTTP:     true,
 		Connections: 5,
 	}},
+	{"s3:http://hostname:9999/bucket/prefix?requester-pays=true", Config{
+		Endpoint:      "hostname:9999",
+		Bucket:        "bucket",
+		Prefix:        "prefix",
+		UseHTTP:       true,
+		Connections:   5,
+		RequesterPays: true,
+	}},
+	{"s3:eu-central-1/foobar/prefix?requester-pays=1", Config{
+		Endpoint:      "eu-central-1",
+		Bucket:        "foobar",
+		Prefix:        "prefix",
+		Connections:   5,
+		RequesterPays: true,
+	}},
+	{"s3://eu-central-1/foobar?requester-pays=false", Config{
+		Endpoint:    "eu-central-1",
+		Bucket:      "foobar",
+		Prefix:      "",
+		Connections: 5,
+	}},
 }
 
 func TestParseConfig(t *testing.T) {
@@ -111,3 +132,17 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+var invalidConfigTests = []string{
+	"s3:eu-central-1/foobar?requester-pays=maybe",
+	"s3:eu-central-1/foobar?foo=bar",
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range invalidConfigTests {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q not returned", s)
+		}
+	}
+}
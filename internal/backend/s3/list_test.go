@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v6"
+)
+
+// mockPager returns the objects in keys in pages of at most maxKeys objects.
+// The continuation token is the index of the first object of the next page.
+type mockPager struct {
+	t        testing.TB
+	keys     []string
+	maxKeys  int
+	requests int
+}
+
+func (p *mockPager) ListObjectsV2(bucket, prefix, continuationToken string, fetchOwner bool, delimiter string, maxKeys int, startAfter string) (minio.ListBucketV2Result, error) {
+	p.requests++
+	if maxKeys != p.maxKeys {
+		p.t.Errorf("wrong page size, want %d, got %d", p.maxKeys, maxKeys)
+	}
+
+	start := 0
+	if continuationToken != "" {
+		var err error
+		start, err = strconv.Atoi(continuationToken)
+		if err != nil {
+			return minio.ListBucketV2Result{}, err
+		}
+	}
+
+	end := start + maxKeys
+	if end > len(p.keys) {
+		end = len(p.keys)
+	}
+
+	var res minio.ListBucketV2Result
+	for _, key := range p.keys[start:end] {
+		res.Contents = append(res.Contents, minio.ObjectInfo{Key: prefix + key})
+	}
+	if end < len(p.keys) {
+		res.IsTruncated = true
+		res.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	return res, nil
+}
+
+func TestListPages(t *testing.T) {
+	var keys []string
+	for i := 0; i < 25; i++ {
+		keys = append(keys, fmt.Sprintf("file-%02d", i))
+	}
+
+	for _, maxKeys := range []int{1, 2, 7, 25, 1000} {
+		t.Run(strconv.Itoa(maxKeys), func(t *testing.T) {
+			pager := &mockPager{t: t, keys: keys, maxKeys: maxKeys}
+
+			var listed []string
+			err := listPages(context.TODO(), pager, "bucket", "data/", true, maxKeys, func(obj minio.ObjectInfo) error {
+				listed = append(listed, obj.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(listed) != len(keys) {
+				t.Fatalf("wrong number of objects listed, want %d, got %d", len(keys), len(listed))
+			}
+			for i, key := range keys {
+				if listed[i] != "data/"+key {
+					t.Errorf("object %d: want %v, got %v", i, "data/"+key, listed[i])
+				}
+			}
+
+			wantRequests := (len(keys) + maxKeys - 1) / maxKeys
+			if pager.requests != wantRequests {
+				t.Errorf("wrong number of requests, want %d, got %d", wantRequests, pager.requests)
+			}
+		})
+	}
+}
+
+func TestListPagesStop(t *testing.T) {
+	pager := &mockPager{t: t, keys: []string{"a", "b", "c", "d"}, maxKeys: 1}
+
+	stop := fmt.Errorf("stop")
+	n := 0
+	err := listPages(context.TODO(), pager, "bucket", "", true, 1, func(obj minio.ObjectInfo) error {
+		n++
+		if n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected error %v, got %v", stop, err)
+	}
+	if pager.requests != 2 {
+		t.Errorf("listing was not stopped, %d requests sent", pager.requests)
+	}
+}
+
+func TestListMaxKeysInvalid(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Endpoint = "localhost"
+	cfg.Bucket = "bucket"
+	cfg.ListMaxKeys = maxListMaxKeys + 1
+
+	_, err := Open(cfg, &recordingTransport{})
+	if err == nil || !strings.Contains(err.Error(), "list-max-keys") {
+		t.Fatalf("expected an error for an invalid s3.list-max-keys value, got %v", err)
+	}
+}
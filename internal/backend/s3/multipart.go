@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+)
+
+// minPartSize is the smallest part size S3 accepts for all but the last part
+// of a multipart upload.
+const minPartSize = 5 * 1024 * 1024
+
+// A part of a multipart upload is sent up to partAttempts times, the delay
+// before the first retry is partRetryDelay.
+const (
+	partAttempts   = 5
+	partRetryDelay = time.Second
+)
+
+// multipartClient is the part of the S3 API needed for multipart uploads, it
+// is implemented by minio.Core.
+type multipartClient interface {
+	NewMultipartUpload(bucket, object string, opts minio.PutObjectOptions) (string, error)
+	PutObjectPartWithContext(ctx context.Context, bucket, object, uploadID string, partID int, data io.Reader, size int64, md5Base64, sha256Hex string, sse encrypt.ServerSide) (minio.ObjectPart, error)
+	CompleteMultipartUploadWithContext(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart) (string, error)
+	AbortMultipartUploadWithContext(ctx context.Context, bucket, object, uploadID string) error
+}
+
+// multipartUpload uploads files in parts. A part which fails to upload is
+// retried on its own, so that a failure does not restart the whole upload.
+type multipartUpload struct {
+	client   multipartClient
+	bucket   string
+	partSize int64
+
+	// attempts is the number of times a part is sent before the upload is
+	// given up, retryDelay is the delay before the first retry, it doubles
+	// for each further retry.
+	attempts   int
+	retryDelay time.Duration
+}
+
+// upload stores size bytes read from rd as object. If the upload fails, the
+// incomplete multipart upload is aborted so that the parts are removed.
+func (u multipartUpload) upload(ctx context.Context, object string, rd io.Reader, size int64, opts minio.PutObjectOptions) error {
+	uploadID, err := u.client.NewMultipartUpload(u.bucket, object, opts)
+	if err != nil {
+		return errors.Wrap(err, "NewMultipartUpload")
+	}
+	debug.Log("started multipart upload %v for %v", uploadID, object)
+
+	err = u.uploadParts(ctx, object, uploadID, rd, size)
+	if err != nil {
+		// the context may already be cancelled, abort the upload anyway
+		abortErr := u.client.AbortMultipartUploadWithContext(context.Background(), u.bucket, object, uploadID)
+		if abortErr != nil {
+			debug.Log("aborting multipart upload %v failed: %v", uploadID, abortErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (u multipartUpload) uploadParts(ctx context.Context, object, uploadID string, rd io.Reader, size int64) error {
+	var parts []minio.CompletePart
+	buf := make([]byte, u.partSize)
+
+	for partID, offset := 1, int64(0); offset < size; partID++ {
+		n := u.partSize
+		if size-offset < n {
+			n = size - offset
+		}
+
+		_, err := io.ReadFull(rd, buf[:n])
+		if err != nil {
+			return errors.Wrap(err, "ReadFull")
+		}
+
+		part, err := u.uploadPart(ctx, object, uploadID, partID, buf[:n])
+		if err != nil {
+			return err
+		}
+
+		parts = append(parts, minio.CompletePart{PartNumber: partID, ETag: part.ETag})
+		offset += n
+	}
+
+	_, err := u.client.CompleteMultipartUploadWithContext(ctx, u.bucket, object, uploadID, parts)
+	return errors.Wrap(err, "CompleteMultipartUpload")
+}
+
+// uploadPart sends a single part, it is retried on failure.
+func (u multipartUpload) uploadPart(ctx context.Context, object, uploadID string, partID int, data []byte) (minio.ObjectPart, error) {
+	sum := md5.Sum(data)
+	md5Base64 := base64.StdEncoding.EncodeToString(sum[:])
+
+	delay := u.retryDelay
+	for attempt := 1; ; attempt++ {
+		part, err := u.client.PutObjectPartWithContext(ctx, u.bucket, object, uploadID, partID,
+			bytes.NewReader(data), int64(len(data)), md5Base64, "", nil)
+		if err == nil {
+			return part, nil
+		}
+
+		if attempt >= u.attempts || ctx.Err() != nil {
+			return minio.ObjectPart{}, errors.Wrapf(err, "PutObjectPart %d", partID)
+		}
+
+		debug.Log("uploading part %d of %v failed, retrying in %v: %v", partID, object, delay, err)
+		select {
+		case <-ctx.Done():
+			return minio.ObjectPart{}, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,173 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+	"github.com/restic/restic/internal/restic"
+)
+
+// mockMultipartClient stores the parts of a single multipart upload. Sending
+// a part fails as often as configured in failures.
+type mockMultipartClient struct {
+	failures map[int]int
+
+	sent      []int
+	parts     map[int][]byte
+	completed []byte
+	aborted   bool
+}
+
+func (c *mockMultipartClient) NewMultipartUpload(bucket, object string, opts minio.PutObjectOptions) (string, error) {
+	c.parts = make(map[int][]byte)
+	return "upload-id", nil
+}
+
+func (c *mockMultipartClient) PutObjectPartWithContext(ctx context.Context, bucket, object, uploadID string, partID int, data io.Reader, size int64, md5Base64, sha256Hex string, sse encrypt.ServerSide) (minio.ObjectPart, error) {
+	c.sent = append(c.sent, partID)
+
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return minio.ObjectPart{}, err
+	}
+	if int64(len(buf)) != size {
+		return minio.ObjectPart{}, fmt.Errorf("part %d: size %d does not match data length %d", partID, size, len(buf))
+	}
+
+	if c.failures[partID] > 0 {
+		c.failures[partID]--
+		return minio.ObjectPart{}, errors.New("connection reset")
+	}
+
+	c.parts[partID] = buf
+	return minio.ObjectPart{PartNumber: partID, ETag: fmt.Sprintf("etag-%d", partID)}, nil
+}
+
+func (c *mockMultipartClient) CompleteMultipartUploadWithContext(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart) (string, error) {
+	var buf []byte
+	for i, part := range parts {
+		if part.PartNumber != i+1 || part.ETag != fmt.Sprintf("etag-%d", i+1) {
+			return "", fmt.Errorf("unexpected part %v at index %d", part, i)
+		}
+		buf = append(buf, c.parts[part.PartNumber]...)
+	}
+	c.completed = buf
+	return "etag", nil
+}
+
+func (c *mockMultipartClient) AbortMultipartUploadWithContext(ctx context.Context, bucket, object, uploadID string) error {
+	c.aborted = true
+	return nil
+}
+
+func testMultipartUpload(client multipartClient) multipartUpload {
+	return multipartUpload{
+		client:   client,
+		bucket:   "bucket",
+		partSize: 10,
+		attempts: 3,
+	}
+}
+
+func TestMultipartUploadRetryPart(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 3) + "abc")
+	client := &mockMultipartClient{failures: map[int]int{2: 1}}
+
+	err := testMultipartUpload(client).upload(context.TODO(), "data/foo", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{1, 2, 2, 3, 4}
+	if fmt.Sprint(client.sent) != fmt.Sprint(want) {
+		t.Errorf("wrong parts sent, want %v, got %v", want, client.sent)
+	}
+
+	if !bytes.Equal(client.completed, data) {
+		t.Errorf("uploaded data differs, want %q, got %q", data, client.completed)
+	}
+
+	if client.aborted {
+		t.Errorf("successful upload was aborted")
+	}
+}
+
+func TestMultipartUploadAbort(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 3))
+	client := &mockMultipartClient{failures: map[int]int{2: 5}}
+
+	err := testMultipartUpload(client).upload(context.TODO(), "data/foo", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	if err == nil {
+		t.Fatal("upload with a failing part did not return an error")
+	}
+
+	want := []int{1, 2, 2, 2}
+	if fmt.Sprint(client.sent) != fmt.Sprint(want) {
+		t.Errorf("wrong parts sent, want %v, got %v", want, client.sent)
+	}
+
+	if client.completed != nil {
+		t.Errorf("failed upload was completed")
+	}
+
+	if !client.aborted {
+		t.Errorf("failed upload was not aborted")
+	}
+}
+
+func TestMultipartSmallFile(t *testing.T) {
+	rt := &recordingTransport{}
+	cfg := NewConfig()
+	cfg.Endpoint = "s3.amazonaws.com"
+	cfg.Bucket = "bucket"
+	cfg.Region = "us-east-1"
+	cfg.KeyID = "key"
+	cfg.Secret = "secret"
+	cfg.Layout = "default"
+	cfg.PartSize = 5
+
+	be, err := open(cfg, rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var queries []string
+	for _, req := range rt.requests {
+		if req.Method == http.MethodPut || req.Method == http.MethodPost {
+			queries = append(queries, req.Method+" "+req.URL.RawQuery)
+		}
+	}
+	sort.Strings(queries)
+
+	if len(queries) != 1 || queries[0] != http.MethodPut+" " {
+		t.Errorf("expected a single PUT request without multipart upload, got %v", queries)
+	}
+}
+
+func TestPartSizeInvalid(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Endpoint = "localhost"
+	cfg.Bucket = "bucket"
+	cfg.PartSize = 4
+
+	_, err := Open(cfg, &recordingTransport{})
+	if err == nil || !strings.Contains(err.Error(), "part-size") {
+		t.Fatalf("expected an error for an invalid s3.part-size value, got %v", err)
+	}
+}
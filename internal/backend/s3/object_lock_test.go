@@ -0,0 +1,158 @@
+package s3
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// objectLockTransport simulates a bucket with object lock. The bucket's
+// object lock configuration is returned for the corresponding requests, all
+// objects are locked and cannot be deleted.
+type objectLockTransport struct {
+	config string
+
+	m        sync.Mutex
+	requests []*http.Request
+}
+
+func (t *objectLockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.m.Lock()
+	t.requests = append(t.requests, req)
+	t.m.Unlock()
+
+	status := http.StatusOK
+	body := ""
+	switch {
+	case req.Method == http.MethodGet && req.URL.Query()["object-lock"] != nil:
+		body = t.config
+	case req.Method == http.MethodDelete:
+		status = http.StatusForbidden
+		body = `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied because object protected by object lock.</Message></Error>`
+	}
+
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Length": []string{"0"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *objectLockTransport) puts() []*http.Request {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var puts []*http.Request
+	for _, req := range t.requests {
+		if req.Method == http.MethodPut {
+			puts = append(puts, req)
+		}
+	}
+	return puts
+}
+
+const complianceConfig = `<?xml version="1.0" encoding="UTF-8"?>
+<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+<Rule><DefaultRetention><Mode>COMPLIANCE</Mode><Days>1</Days></DefaultRetention></Rule>
+</ObjectLockConfiguration>`
+
+func openObjectLockTest(t testing.TB, days uint, rt http.RoundTripper) *Backend {
+	cfg := NewConfig()
+	cfg.Endpoint = "s3.amazonaws.com"
+	cfg.Bucket = "bucket"
+	cfg.Prefix = "prefix"
+	cfg.Region = "us-east-1"
+	cfg.KeyID = "key"
+	cfg.Secret = "secret"
+	cfg.Layout = "default"
+	cfg.ObjectLockDays = days
+
+	be, err := open(cfg, rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return be
+}
+
+func TestObjectLockRetentionHeaders(t *testing.T) {
+	var tests = []struct {
+		config string
+		days   uint
+		mode   string
+	}{
+		{complianceConfig, 7, "COMPLIANCE"},
+		// the configuration cannot be read
+		{"", 7, "GOVERNANCE"},
+		{complianceConfig, 0, ""},
+	}
+
+	for _, test := range tests {
+		rt := &objectLockTransport{config: test.config}
+		be := openObjectLockTest(t, test.days, rt)
+
+		start := time.Now()
+		for _, tpe := range []restic.FileType{restic.DataFile, restic.LockFile} {
+			h := restic.Handle{Type: tpe, Name: "0123456789abcdef"}
+			if err := be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		puts := rt.puts()
+		if len(puts) != 2 {
+			t.Fatalf("expected 2 uploads, got %d", len(puts))
+		}
+
+		pack, lock := puts[0], puts[1]
+		if mode := pack.Header.Get("x-amz-object-lock-mode"); mode != test.mode {
+			t.Errorf("wrong retention mode, want %q, got %q", test.mode, mode)
+		}
+
+		until := pack.Header.Get("x-amz-object-lock-retain-until-date")
+		if test.days == 0 {
+			if until != "" {
+				t.Errorf("retention date %v set although object lock is not configured", until)
+			}
+		} else {
+			date, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				t.Fatalf("invalid retention date %q: %v", until, err)
+			}
+
+			want := start.Add(time.Duration(test.days) * 24 * time.Hour)
+			if date.Before(want.Add(-time.Minute)) || date.After(want.Add(time.Minute)) {
+				t.Errorf("wrong retention date, want %v, got %v", want, date)
+			}
+		}
+
+		if lock.Header.Get("x-amz-object-lock-mode") != "" || lock.Header.Get("x-amz-object-lock-retain-until-date") != "" {
+			t.Errorf("retention headers set for lock file")
+		}
+	}
+}
+
+func TestObjectLockRemove(t *testing.T) {
+	h := restic.Handle{Type: restic.DataFile, Name: "0123456789abcdef"}
+
+	// object lock is detected for the bucket
+	be := openObjectLockTest(t, 0, &objectLockTransport{config: complianceConfig})
+	err := be.Remove(context.TODO(), h)
+	if !restic.IsRetentionError(err) {
+		t.Errorf("expected retention error, got %v", err)
+	}
+
+	// without object lock, access denied is a regular error
+	be = openObjectLockTest(t, 0, &objectLockTransport{})
+	err = be.Remove(context.TODO(), h)
+	if err == nil || restic.IsRetentionError(err) {
+		t.Errorf("expected access denied error, got %v", err)
+	}
+}
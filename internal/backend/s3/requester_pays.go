@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/minio/minio-go/v6/pkg/s3signer"
+	"github.com/restic/restic/internal/debug"
+)
+
+const requesterPaysHeader = "X-Amz-Request-Payer"
+
+// requesterPaysTransport adds the header which is required to read from
+// requester pays buckets to all GET and HEAD requests (this includes listing
+// the bucket). S3 rejects requests with x-amz-* headers which are not covered
+// by the signature, so requests which were signed with AWS signature version
+// 4 are signed again after adding the header.
+type requesterPaysTransport struct {
+	rt    http.RoundTripper
+	creds *credentials.Credentials
+}
+
+func newRequesterPaysTransport(rt http.RoundTripper, creds *credentials.Credentials) http.RoundTripper {
+	return requesterPaysTransport{rt: rt, creds: creds}
+}
+
+// RoundTrip adds the requester pays header to req and sends it.
+func (t requesterPaysTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.rt.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the original request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(requesterPaysHeader, "requester")
+
+	if region := signatureRegion(r); region != "" {
+		v, err := t.creds.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		debug.Log("signing %v %v again for region %v", r.Method, r.URL, region)
+		r = s3signer.SignV4(*r, v.AccessKeyID, v.SecretAccessKey, v.SessionToken, region)
+	}
+
+	return t.rt.RoundTrip(r)
+}
+
+// signatureRegion returns the region from the credential scope of a request
+// signed with AWS signature version 4, or the empty string for all other
+// requests.
+func signatureRegion(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return ""
+	}
+
+	pos := strings.Index(auth, "Credential=")
+	if pos < 0 {
+		return ""
+	}
+
+	// the credential scope is keyid/date/region/service/aws4_request
+	scope := auth[pos+len("Credential="):]
+	if end := strings.IndexByte(scope, ','); end >= 0 {
+		scope = scope[:end]
+	}
+
+	parts := strings.Split(scope, "/")
+	if len(parts) != 5 {
+		return ""
+	}
+
+	return parts[2]
+}
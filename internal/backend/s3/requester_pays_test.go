@@ -0,0 +1,129 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// recordingTransport records all requests and returns an empty successful
+// response.
+type recordingTransport struct {
+	m        sync.Mutex
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.m.Lock()
+	t.requests = append(t.requests, req)
+	t.m.Unlock()
+
+	body := ""
+	if req.Method == http.MethodGet && req.URL.Query().Get("list-type") == "2" {
+		body = `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult></ListBucketResult>`
+	}
+
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Length": []string{"0"}, "Last-Modified": []string{"Mon, 02 Jan 2006 15:04:05 GMT"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func TestRequesterPays(t *testing.T) {
+	for _, requesterPays := range []bool{false, true} {
+		rt := &recordingTransport{}
+		cfg := NewConfig()
+		cfg.Endpoint = "s3.amazonaws.com"
+		cfg.Bucket = "bucket"
+		cfg.Prefix = "prefix"
+		cfg.Region = "us-east-1"
+		cfg.KeyID = "key"
+		cfg.Secret = "secret"
+		cfg.Layout = "default"
+		cfg.RequesterPays = requesterPays
+
+		be, err := open(cfg, rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := restic.Handle{Type: restic.SnapshotFile, Name: "foo"}
+		_, _ = be.Stat(context.TODO(), h)
+		_ = be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+			_, err := io.Copy(ioutil.Discard, rd)
+			return err
+		})
+		_ = be.List(context.TODO(), restic.SnapshotFile, func(restic.FileInfo) error { return nil })
+		_ = be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo")))
+
+		methods := make(map[string]bool)
+		for _, req := range rt.requests {
+			methods[req.Method] = true
+			header := req.Header.Get(requesterPaysHeader)
+
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				if header != "" {
+					t.Errorf("requester pays header set for %v request", req.Method)
+				}
+				continue
+			}
+
+			if !requesterPays {
+				if header != "" {
+					t.Errorf("requester pays header set for %v %v although not configured", req.Method, req.URL)
+				}
+				continue
+			}
+
+			if header != "requester" {
+				t.Errorf("requester pays header not set for %v %v", req.Method, req.URL)
+			}
+
+			auth := req.Header.Get("Authorization")
+			if !strings.Contains(auth, "x-amz-request-payer") {
+				t.Errorf("requester pays header is not signed for %v %v: %v", req.Method, req.URL, auth)
+			}
+		}
+
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPut} {
+			if !methods[method] {
+				t.Errorf("no %v request sent", method)
+			}
+		}
+	}
+}
+
+func TestSignatureRegion(t *testing.T) {
+	var tests = []struct {
+		auth   string
+		region string
+	}{
+		{"AWS4-HMAC-SHA256 Credential=key/20200101/eu-west-1/s3/aws4_request, SignedHeaders=host, Signature=abc", "eu-west-1"},
+		{"AWS key:signature", ""},
+		{"", ""},
+		{"AWS4-HMAC-SHA256 Credential=invalid, SignedHeaders=host", ""},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com", bytes.NewReader(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", test.auth)
+
+		region := signatureRegion(req)
+		if region != test.region {
+			t.Errorf("wrong region for %q, want %q, got %q", test.auth, test.region, region)
+		}
+	}
+}
@@ -26,6 +26,11 @@ type Backend struct {
 	sem    *backend.Semaphore
 	cfg    Config
 	backend.Layout
+
+	// objectLock is set when S3 Object Lock is enabled for the bucket,
+	// lockMode is the retention mode used for new objects.
+	objectLock bool
+	lockMode   minio.RetentionMode
 }
 
 // make sure that *Backend implements backend.Backend
@@ -40,6 +45,14 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
+	if cfg.ListMaxKeys > maxListMaxKeys {
+		return nil, errors.Fatalf("s3.list-max-keys must be between 1 and %d, got %d", maxListMaxKeys, cfg.ListMaxKeys)
+	}
+
+	if cfg.PartSize > 0 && int64(cfg.PartSize)*1024*1024 < minPartSize {
+		return nil, errors.Fatalf("s3.part-size must be at least %d MiB, got %d", minPartSize/1024/1024, cfg.PartSize)
+	}
+
 	// Chains all credential types, in the following order:
 	// 	- Static credentials provided by user
 	//	- AWS env vars (i.e. AWS_ACCESS_KEY_ID)
@@ -82,6 +95,9 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		cfg:    cfg,
 	}
 
+	if cfg.RequesterPays {
+		rt = newRequesterPaysTransport(rt, creds)
+	}
 	client.SetCustomTransport(rt)
 
 	l, err := backend.ParseLayout(be, cfg.Layout, defaultLayout, cfg.Prefix)
@@ -91,9 +107,45 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 
 	be.Layout = l
 
+	if err := be.detectObjectLock(); err != nil {
+		return nil, err
+	}
+
 	return be, nil
 }
 
+// detectObjectLock checks whether S3 Object Lock is enabled for the bucket and
+// determines the retention mode for new objects. It is the default mode
+// configured for the bucket, or governance mode if there is none.
+func (be *Backend) detectObjectLock() error {
+	be.lockMode = minio.Governance
+
+	mode, _, _, err := be.client.GetBucketObjectLockConfig(be.cfg.Bucket)
+	if err != nil {
+		debug.Log("GetBucketObjectLockConfig(%v) returned error %v", be.cfg.Bucket, err)
+
+		if e, ok := errors.Cause(err).(minio.ErrorResponse); ok && e.Code == "ObjectLockConfigurationNotFoundError" {
+			if be.cfg.ObjectLockDays > 0 {
+				return errors.Errorf("object lock is not enabled for bucket %v", be.cfg.Bucket)
+			}
+			return nil
+		}
+
+		// the configuration cannot be read, e.g. because the bucket does not
+		// exist yet or due to missing permissions, so rely on the config
+		be.objectLock = be.cfg.ObjectLockDays > 0
+		return nil
+	}
+
+	be.objectLock = true
+	if mode != nil && mode.IsValid() {
+		be.lockMode = *mode
+	}
+	debug.Log("object lock is enabled for bucket %v, mode %v", be.cfg.Bucket, be.lockMode)
+
+	return nil
+}
+
 // Open opens the S3 backend at bucket and region. The bucket is created if it
 // does not exist yet.
 func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
@@ -119,7 +171,13 @@ func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
 		return nil, errors.Wrap(err, "client.BucketExists")
 	}
 
-	if !found {
+	if !found && cfg.ObjectLockDays > 0 {
+		// object lock can only be enabled when the bucket is created
+		err = be.client.MakeBucketWithObjectLock(cfg.Bucket, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "client.MakeBucketWithObjectLock")
+		}
+	} else if !found {
 		// create new bucket with default ACL in default region
 		err = be.client.MakeBucket(cfg.Bucket, "")
 		if err != nil {
@@ -141,6 +199,25 @@ func (be *Backend) IsAccessDenied(err error) bool {
 	return false
 }
 
+// isRetained returns true if the error returned when removing an object was
+// caused by S3 Object Lock.
+func (be *Backend) isRetained(err error) bool {
+	if !be.objectLock {
+		return false
+	}
+
+	e, ok := errors.Cause(err).(minio.ErrorResponse)
+	if !ok {
+		return false
+	}
+
+	switch e.Code {
+	case "AccessDenied", "ObjectLocked", "InvalidRequest":
+		return true
+	}
+	return false
+}
+
 // IsNotExist returns true if the error is caused by a not existing file.
 func (be *Backend) IsNotExist(err error) bool {
 	debug.Log("IsNotExist(%T, %#v)", err, err)
@@ -247,12 +324,43 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	opts := minio.PutObjectOptions{StorageClass: be.cfg.StorageClass}
 	opts.ContentType = "application/octet-stream"
 
+	// lock files must be removable at any time
+	if be.cfg.ObjectLockDays > 0 && h.Type != restic.LockFile {
+		until := time.Now().Add(time.Duration(be.cfg.ObjectLockDays) * 24 * time.Hour).UTC()
+		opts.Mode = &be.lockMode
+		opts.RetainUntilDate = &until
+	}
+
+	partSize := int64(be.cfg.PartSize) * 1024 * 1024
+	if partSize > 0 && rd.Length() > partSize {
+		debug.Log("multipart upload of %v (%v bytes) in parts of %v bytes", objName, rd.Length(), partSize)
+		u := multipartUpload{
+			client:     minio.Core{Client: be.client},
+			bucket:     be.cfg.Bucket,
+			partSize:   partSize,
+			attempts:   partAttempts,
+			retryDelay: partRetryDelay,
+		}
+		err := u.upload(ctx, objName, rd, rd.Length(), opts)
+		return errors.Wrap(err, "multipart upload")
+	}
+
 	debug.Log("PutObject(%v, %v, %v)", be.cfg.Bucket, objName, rd.Length())
 	n, err := be.client.PutObjectWithContext(ctx, be.cfg.Bucket, objName, ioutil.NopCloser(rd), int64(rd.Length()), opts)
 
 	debug.Log("%v -> %v bytes, err %#v: %v", objName, n, err, err)
 
-	return errors.Wrap(err, "client.PutObject")
+	return errors.Wrap(statusError(err), "client.PutObject")
+}
+
+// statusError returns a restic.StatusError for an error response of the
+// server, so that transient errors can be told apart from permanent ones.
+// Other errors are returned unchanged.
+func statusError(err error) error {
+	if e, ok := err.(minio.ErrorResponse); ok && e.StatusCode != 0 {
+		return &restic.StatusError{StatusCode: e.StatusCode, Err: err}
+	}
+	return err
 }
 
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
@@ -354,7 +462,7 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 		return restic.FileInfo{}, errors.Wrap(err, "Stat")
 	}
 
-	return restic.FileInfo{Size: fi.Size, Name: h.Name}, nil
+	return restic.FileInfo{Size: fi.Size, Name: h.Name, ModTime: fi.LastModified}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -388,6 +496,10 @@ func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 		err = nil
 	}
 
+	if err != nil && be.isRetained(err) {
+		return &restic.RetentionError{Handle: h, Err: err}
+	}
+
 	return errors.Wrap(err, "client.RemoveObject")
 }
 
@@ -406,6 +518,13 @@ func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.F
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if be.cfg.ListMaxKeys > 0 {
+		pager := minio.Core{Client: be.client}
+		return listPages(ctx, pager, be.cfg.Bucket, prefix, recursive, int(be.cfg.ListMaxKeys), func(obj minio.ObjectInfo) error {
+			return be.listObject(ctx, prefix, obj, fn)
+		})
+	}
+
 	// NB: unfortunately we can't protect this with be.sem.GetToken() here.
 	// Doing so would enable a deadlock situation (gh-1399), as ListObjects()
 	// starts its own goroutine and returns results via a channel.
@@ -416,31 +535,83 @@ func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.F
 			return obj.Err
 		}
 
-		m := strings.TrimPrefix(obj.Key, prefix)
-		if m == "" {
-			continue
+		err := be.listObject(ctx, prefix, obj, fn)
+		if err != nil {
+			return err
 		}
+	}
 
-		fi := restic.FileInfo{
-			Name: path.Base(m),
-			Size: obj.Size,
-		}
+	return ctx.Err()
+}
+
+// listObject passes the listed object obj below prefix to fn.
+func (be *Backend) listObject(ctx context.Context, prefix string, obj minio.ObjectInfo, fn func(restic.FileInfo) error) error {
+	m := strings.TrimPrefix(obj.Key, prefix)
+	if m == "" {
+		return nil
+	}
+
+	fi := restic.FileInfo{
+		Name:    path.Base(m),
+		Size:    obj.Size,
+		ModTime: obj.LastModified,
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
+	err := fn(fi)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// objectPager returns a single page of a bucket listing, it is implemented by
+// minio.Core.
+type objectPager interface {
+	ListObjectsV2(bucket, prefix, continuationToken string, fetchOwner bool, delimiter string, maxKeys int, startAfter string) (minio.ListBucketV2Result, error)
+}
+
+// listPages lists the objects below prefix with requests returning at most
+// maxKeys objects each, and runs fn for each object. Unless recursive is set,
+// only the objects directly below prefix are listed.
+func listPages(ctx context.Context, pager objectPager, bucket, prefix string, recursive bool, maxKeys int, fn func(minio.ObjectInfo) error) error {
+	delimiter := "/"
+	if recursive {
+		delimiter = ""
+	}
+
+	token := ""
+	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
-		err := fn(fi)
+		res, err := pager.ListObjectsV2(bucket, prefix, token, false, delimiter, maxKeys, "")
 		if err != nil {
-			return err
+			return errors.Wrap(err, "ListObjectsV2")
 		}
+		debug.Log("listed %d objects below %v", len(res.Contents), prefix)
 
-		if ctx.Err() != nil {
-			return ctx.Err()
+		for _, obj := range res.Contents {
+			err = fn(obj)
+			if err != nil {
+				return err
+			}
 		}
-	}
 
-	return ctx.Err()
+		if !res.IsTruncated {
+			return nil
+		}
+
+		if res.NextContinuationToken == "" {
+			return errors.Errorf("listing of %v is truncated but has no continuation token", prefix)
+		}
+		token = res.NextContinuationToken
+	}
 }
 
 // Remove keys for a specified backend type.
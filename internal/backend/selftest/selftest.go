@@ -0,0 +1,145 @@
+// Package selftest checks that a backend is usable by storing, reading back,
+// listing and removing a probe file.
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ProbeSize is the size of the probe file in bytes.
+const ProbeSize = 4096
+
+// Step is the result of a single operation of the self-test.
+type Step struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// errFound is used to stop listing the files once the probe has been found.
+var errFound = errors.New("probe found")
+
+// ProbeHandle returns a new handle for a probe file. The probe is stored next
+// to the lock files with a random name that is not a valid ID, so it is
+// ignored by all operations on a repository at the same location, e.g. it is
+// never mistaken for a pack by check or prune. Backends do not protect lock
+// files with a retention policy, and the name marks the probe as a temporary
+// file, so a probe which could not be removed is cleaned up together with
+// stale locks.
+func ProbeHandle() restic.Handle {
+	return restic.Handle{
+		Type: restic.LockFile,
+		Name: restic.NewRandomID().String() + restic.TempFileInfix + "selftest",
+	}
+}
+
+// Run stores a probe file with random content in be, checks that it can be
+// stat'ed, read back and listed and removes it again. The probe does not
+// collide with any file of a repository at the same location, see
+// ProbeHandle. Run stops at the first failing operation, but the probe is
+// removed in any case once it may have been stored.
+//
+// The results of all operations which have been run are returned, together
+// with the first error.
+func Run(ctx context.Context, be restic.Backend) ([]Step, error) {
+	data := make([]byte, ProbeSize)
+	_, err := io.ReadFull(rand.Reader, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFull")
+	}
+
+	h := ProbeHandle()
+	debug.Log("using probe %v", h)
+
+	var steps []Step
+	run := func(name string, fn func() error) error {
+		start := time.Now()
+		err := fn()
+		steps = append(steps, Step{Name: name, Duration: time.Since(start), Err: err})
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		return nil
+	}
+
+	saveErr := run("save", func() error {
+		return be.Save(ctx, h, restic.NewByteReader(data))
+	})
+
+	err = saveErr
+	if err == nil {
+		err = check(ctx, be, h, data, run)
+	}
+
+	// always clean up, the probe may have been stored partially even when
+	// saving it failed
+	removeErr := run("remove", func() error {
+		err := be.Remove(ctx, h)
+		if err != nil && saveErr != nil && be.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = removeErr
+	}
+
+	return steps, err
+}
+
+// check reads the probe h back in several ways and verifies the results.
+func check(ctx context.Context, be restic.Backend, h restic.Handle, data []byte, run func(string, func() error) error) error {
+	err := run("stat", func() error {
+		fi, err := be.Stat(ctx, h)
+		if err != nil {
+			return err
+		}
+		if fi.Size != int64(len(data)) {
+			return errors.Errorf("wrong size, want %d, got %d", len(data), fi.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = run("load", func() error {
+		return be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+			buf := bytes.NewBuffer(nil)
+			if _, err := io.Copy(buf, rd); err != nil {
+				return err
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				return errors.New("content does not match the stored data")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return run("list", func() error {
+		err := be.List(ctx, h.Type, func(fi restic.FileInfo) error {
+			if fi.Name == h.Name {
+				return errFound
+			}
+			return nil
+		})
+		if errors.Cause(err) == errFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return errors.New("probe is missing in the list of files")
+	})
+}
@@ -0,0 +1,169 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func stepNames(steps []Step) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func countFiles(t testing.TB, be restic.Backend) int {
+	files := 0
+	rtest.OK(t, be.List(context.TODO(), restic.LockFile, func(restic.FileInfo) error {
+		files++
+		return nil
+	}))
+	return files
+}
+
+func TestRun(t *testing.T) {
+	be := mem.New()
+
+	steps, err := Run(context.TODO(), be)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"save", "stat", "load", "list", "remove"}, stepNames(steps))
+	for _, step := range steps {
+		rtest.OK(t, step.Err)
+	}
+
+	rtest.Equals(t, 0, countFiles(t, be))
+}
+
+func TestProbeHandle(t *testing.T) {
+	h := ProbeHandle()
+	rtest.OK(t, h.Valid())
+	rtest.Equals(t, restic.FileType(restic.LockFile), h.Type)
+
+	// the probe must never be mistaken for a file of the repository
+	_, err := restic.ParseID(h.Name)
+	rtest.Assert(t, err != nil, "probe name %v is a valid ID", h.Name)
+	rtest.Assert(t, restic.IsTempFile(h.Name), "probe name %v is not a temporary file name", h.Name)
+
+	rtest.Assert(t, h.Name != ProbeHandle().Name, "probe names are not random")
+}
+
+// failingBackend fails the operation fail, all other operations are passed
+// on to the embedded backend.
+type failingBackend struct {
+	restic.Backend
+	fail string
+	// store is set when the probe should be stored although saving it fails
+	store bool
+}
+
+var errTest = errors.New("injected failure")
+
+func (be *failingBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.fail == "save" {
+		if be.store {
+			_ = be.Backend.Save(ctx, h, rd)
+		}
+		return errTest
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *failingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if be.fail == "stat" {
+		return restic.FileInfo{}, errTest
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func (be *failingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if be.fail == "load" {
+		return errTest
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *failingBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if be.fail == "list" {
+		return errTest
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func (be *failingBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if be.fail == "remove" {
+		return errTest
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+func TestRunFailure(t *testing.T) {
+	var tests = []struct {
+		fail  string
+		store bool
+		steps []string
+		// files is the number of files left behind
+		files int
+	}{
+		{"save", false, []string{"save", "remove"}, 0},
+		{"save", true, []string{"save", "remove"}, 0},
+		{"stat", false, []string{"save", "stat", "remove"}, 0},
+		{"load", false, []string{"save", "stat", "load", "remove"}, 0},
+		{"list", false, []string{"save", "stat", "load", "list", "remove"}, 0},
+		{"remove", false, []string{"save", "stat", "load", "list", "remove"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fail, func(t *testing.T) {
+			inner := mem.New()
+			be := &failingBackend{Backend: inner, fail: test.fail, store: test.store}
+
+			steps, err := Run(context.TODO(), be)
+			rtest.Assert(t, errors.Cause(err) == errTest, "expected injected failure, got %v", err)
+			rtest.Equals(t, test.steps, stepNames(steps))
+
+			for _, step := range steps {
+				if step.Name == test.fail {
+					rtest.Assert(t, step.Err == errTest, "step %v: expected injected failure, got %v", step.Name, step.Err)
+				} else {
+					rtest.OK(t, step.Err)
+				}
+			}
+
+			rtest.Equals(t, test.files, countFiles(t, inner))
+		})
+	}
+}
+
+func TestRunCorruptData(t *testing.T) {
+	be := &corruptingBackend{Backend: mem.New()}
+
+	steps, err := Run(context.TODO(), be)
+	rtest.Assert(t, err != nil, "corrupted probe was not detected")
+	rtest.Equals(t, []string{"save", "stat", "load", "remove"}, stepNames(steps))
+	rtest.Equals(t, 0, countFiles(t, be))
+}
+
+// corruptingBackend flips a bit in all data read from the backend.
+type corruptingBackend struct {
+	restic.Backend
+}
+
+func (be *corruptingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		buf[0] ^= 1
+		return fn(bytes.NewReader(buf))
+	})
+}
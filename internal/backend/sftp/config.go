@@ -14,6 +14,7 @@ type Config struct {
 	User, Host, Path string
 	Layout           string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
 	Command          string `option:"command" help:"specify command to create sftp connection"`
+	Connections      uint   `option:"connections" help:"set a limit for the number of concurrent sftp sessions (default: 1)"`
 }
 
 func init() {
@@ -0,0 +1,280 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+)
+
+// conn is an sftp session with the server, usually running in an ssh
+// process.
+type conn struct {
+	*sftp.Client
+
+	cmd    *exec.Cmd
+	result <-chan error
+
+	// done is closed when the session has ended, with the error in doneErr
+	done    chan struct{}
+	doneErr error
+
+	// users is the number of operations which currently use the connection,
+	// broken is set when an operation found the connection to be lost. Both
+	// are protected by the mutex of the pool.
+	users  int
+	broken bool
+}
+
+// newConn returns a conn for client. If cmd is not nil, it is the process
+// running the session, and result receives the error once it has exited.
+func newConn(client *sftp.Client, cmd *exec.Cmd, result <-chan error) *conn {
+	c := &conn{
+		Client: client,
+		cmd:    cmd,
+		result: result,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		c.doneErr = client.Wait()
+		close(c.done)
+	}()
+
+	return c
+}
+
+// err returns an error if the session has ended. Otherwise, nil is returned
+// immediately.
+func (c *conn) err() error {
+	select {
+	case err := <-c.result:
+		debug.Log("client has exited with err %v", err)
+		return err
+	case <-c.done:
+		return errors.Errorf("sftp session has ended: %v", c.doneErr)
+	default:
+	}
+
+	return nil
+}
+
+// lostTimeout is the time to wait for the session to end after an error
+// which was not reported by the server.
+var lostTimeout = 200 * time.Millisecond
+
+// lost returns true if err was caused by losing the connection.
+func (c *conn) lost(err error) bool {
+	if c.err() != nil {
+		return true
+	}
+
+	cause := errors.Cause(err)
+	switch cause {
+	case io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe, sftp.ErrSshFxConnectionLost:
+		return true
+	}
+
+	// the server has answered the request
+	if _, ok := cause.(*sftp.StatusError); ok || os.IsNotExist(cause) || os.IsPermission(cause) {
+		return false
+	}
+
+	// errors while sending a request do not tell whether the connection was
+	// lost, but in that case the session ends shortly afterwards
+	select {
+	case <-c.done:
+		return true
+	case <-time.After(lostTimeout):
+	}
+	return c.err() != nil
+}
+
+var closeTimeout = 2 * time.Second
+
+// Close closes the sftp session and terminates the underlying command.
+func (c *conn) Close() error {
+	err := c.Client.Close()
+	debug.Log("Close returned error %v", err)
+
+	if c.cmd == nil {
+		return nil
+	}
+
+	// wait for closeTimeout before killing the process
+	select {
+	case err := <-c.result:
+		return err
+	case <-time.After(closeTimeout):
+	}
+
+	if err := c.cmd.Process.Kill(); err != nil {
+		return err
+	}
+
+	// get the error, but ignore it
+	<-c.result
+	return nil
+}
+
+// connPool distributes the operations of the backend across several sftp
+// sessions. The sessions are started when they are needed, and restarted
+// when they have died.
+type connPool struct {
+	connect func() (*conn, error)
+
+	m     sync.Mutex
+	cond  *sync.Cond
+	conns []*conn
+	// connecting marks the slots for which a session is being started
+	connecting []bool
+	closed     bool
+}
+
+func newConnPool(size uint, connect func() (*conn, error)) *connPool {
+	if size == 0 {
+		size = 1
+	}
+
+	p := &connPool{
+		connect:    connect,
+		conns:      make([]*conn, size),
+		connecting: make([]bool, size),
+	}
+	p.cond = sync.NewCond(&p.m)
+	return p
+}
+
+// errPoolClosed is returned by get after the pool has been closed.
+var errPoolClosed = errors.New("connection pool is closed")
+
+// get returns the connection to use for the next operation. An idle
+// connection is preferred, otherwise a new one is started if the pool is not
+// full yet. When all connections are busy, the least used one is shared.
+func (p *connPool) get() (*conn, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for {
+		if p.closed {
+			return nil, errPoolClosed
+		}
+
+		var best *conn
+		free := -1
+		for i, c := range p.conns {
+			if c != nil && (c.broken || c.err() != nil) {
+				debug.Log("replacing lost connection %d", i)
+				go func(c *conn) {
+					_ = c.Close()
+				}(c)
+				p.conns[i] = nil
+				c = nil
+			}
+
+			if c == nil {
+				if free < 0 && !p.connecting[i] {
+					free = i
+				}
+				continue
+			}
+
+			if best == nil || c.users < best.users {
+				best = c
+			}
+		}
+
+		if free >= 0 && (best == nil || best.users > 0) {
+			c, err := p.connectSlot(free)
+			if err == errPoolClosed {
+				return nil, err
+			}
+			if err == nil {
+				best = c
+			} else if best == nil {
+				return nil, err
+			} else if best.broken || best.err() != nil {
+				// the connection was lost while the lock was released
+				continue
+			} else {
+				debug.Log("unable to start new connection, reusing existing one: %v", err)
+			}
+		}
+
+		if best == nil {
+			// all sessions are being started by other operations
+			p.cond.Wait()
+			continue
+		}
+
+		best.users++
+		return best, nil
+	}
+}
+
+// connectSlot starts a new session for the free slot i. The slot is reserved
+// and the lock is released while the session is started, so that other
+// operations are not blocked. p.m must be held by the caller.
+func (p *connPool) connectSlot(i int) (*conn, error) {
+	p.connecting[i] = true
+	p.m.Unlock()
+	c, err := p.connect()
+	p.m.Lock()
+	p.connecting[i] = false
+	p.cond.Broadcast()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.closed {
+		go func() {
+			_ = c.Close()
+		}()
+		return nil, errPoolClosed
+	}
+
+	p.conns[i] = c
+	return c, nil
+}
+
+// put returns the connection c after an operation has finished. If lost is
+// set, c is not used for further operations.
+func (p *connPool) put(c *conn, lost bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	c.users--
+	if lost {
+		c.broken = true
+	}
+}
+
+// Close closes all connections. Sessions which are being started are closed
+// once they are up.
+func (p *connPool) Close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+
+	var firstErr error
+	for i, c := range p.conns {
+		if c == nil {
+			continue
+		}
+
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		p.conns[i] = nil
+	}
+	return firstErr
+}
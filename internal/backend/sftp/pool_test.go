@@ -0,0 +1,239 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeConn is one end of an sftp session served in-process.
+type pipeConn struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (c pipeConn) Close() error {
+	_ = c.PipeReader.Close()
+	return c.PipeWriter.Close()
+}
+
+// testServer starts sftp sessions served by an in-process server.
+type testServer struct {
+	t testing.TB
+
+	m        sync.Mutex
+	sessions []pipeConn
+}
+
+func (s *testServer) connect() (*conn, error) {
+	clientRd, serverWr := io.Pipe()
+	serverRd, clientWr := io.Pipe()
+	server := pipeConn{serverRd, serverWr}
+
+	srv, err := sftp.NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = srv.Serve()
+		// end the session for the client when it has closed its side
+		_ = server.Close()
+	}()
+
+	client, err := sftp.NewClientPipe(clientRd, clientWr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	s.sessions = append(s.sessions, server)
+	s.m.Unlock()
+
+	return newConn(client, nil, nil), nil
+}
+
+// started returns the number of sessions started so far.
+func (s *testServer) started() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.sessions)
+}
+
+// drop terminates all sessions started so far.
+func (s *testServer) drop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, session := range s.sessions {
+		_ = session.Close()
+	}
+}
+
+func openTestBackend(t testing.TB, connections uint) (*SFTP, *testServer, func()) {
+	dir, cleanup := rtest.TempDir(t)
+
+	srv := &testServer{t: t}
+	be, err := open(Config{Path: dir, Connections: connections}, srv.connect)
+	rtest.OK(t, err)
+
+	return be, srv, func() {
+		rtest.OK(t, be.Close())
+		cleanup()
+	}
+}
+
+func saveTestFile(t testing.TB, be *SFTP, data []byte) restic.Handle {
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	return h
+}
+
+func TestConnPoolConcurrent(t *testing.T) {
+	be, srv, cleanup := openTestBackend(t, 3)
+	defer cleanup()
+
+	data := rtest.Random(23, 1000)
+	h := saveTestFile(t, be, data)
+	rtest.Equals(t, 1, srv.started())
+
+	// run loads which all wait until every one of them has started, so each
+	// needs its own connection
+	var started, wg sync.WaitGroup
+	release := make(chan struct{})
+	conns := make(chan *conn, 3)
+	for i := 0; i < 3; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+				conns <- rd.(*connReader).c
+				started.Done()
+				<-release
+
+				buf, err := ioutil.ReadAll(rd)
+				if err != nil {
+					return err
+				}
+				rtest.Equals(t, data, buf)
+				return nil
+			})
+			rtest.OK(t, err)
+		}()
+	}
+
+	started.Wait()
+	close(release)
+	wg.Wait()
+	close(conns)
+
+	used := make(map[*conn]struct{})
+	for c := range conns {
+		used[c] = struct{}{}
+	}
+	rtest.Equals(t, 3, len(used))
+	rtest.Equals(t, 3, srv.started())
+
+	// idle connections are reused, the pool does not grow beyond its size
+	for i := 0; i < 5; i++ {
+		_, err := be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+	}
+	rtest.Equals(t, 3, srv.started())
+}
+
+func TestConnPoolReconnect(t *testing.T) {
+	be, srv, cleanup := openTestBackend(t, 1)
+	defer cleanup()
+
+	data := rtest.Random(42, 1000)
+	h := saveTestFile(t, be, data)
+
+	srv.drop()
+
+	// the lost connection is replaced transparently
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+	rtest.Equals(t, 2, srv.started())
+
+	srv.drop()
+	h2 := saveTestFile(t, be, rtest.Random(5, 2000))
+	rtest.Equals(t, 3, srv.started())
+
+	srv.drop()
+	var buf []byte
+	err = be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	ok, err := be.Test(context.TODO(), h2)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "file %v saved after reconnect is missing", h2)
+}
+
+func TestConnPoolConnectUnlocked(t *testing.T) {
+	srv := &testServer{t: t}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	connects := 0
+	p := newConnPool(2, func() (*conn, error) {
+		connects++
+		if connects == 2 {
+			close(started)
+			<-release
+		}
+		return srv.connect()
+	})
+
+	c1, err := p.get()
+	rtest.OK(t, err)
+
+	// c1 is busy, so a second session is started
+	result := make(chan error, 1)
+	go func() {
+		c, err := p.get()
+		if err == nil && c == c1 {
+			err = errors.New("busy connection was shared instead of starting a new one")
+		}
+		result <- err
+	}()
+	<-started
+
+	// while the session is being started, the pool can be used
+	done := make(chan struct{})
+	go func() {
+		p.put(c1, false)
+		c, err := p.get()
+		if err != nil || c != c1 {
+			t.Errorf("idle connection was not reused, got %p, %v", c, err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool is blocked while a session is started")
+	}
+
+	close(release)
+	rtest.OK(t, <-result)
+	rtest.Equals(t, 2, srv.started())
+	rtest.OK(t, p.Close())
+
+	_, err = p.get()
+	rtest.Assert(t, err == errPoolClosed, "get on closed pool returned %v", err)
+}
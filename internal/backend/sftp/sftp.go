@@ -9,7 +9,6 @@ import (
 	"os/exec"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
@@ -22,11 +21,8 @@ import (
 
 // SFTP is a backend in a directory accessed via SFTP.
 type SFTP struct {
-	c *sftp.Client
-	p string
-
-	cmd    *exec.Cmd
-	result <-chan error
+	pool *connPool
+	p    string
 
 	backend.Layout
 	Config
@@ -36,7 +32,7 @@ var _ restic.Backend = &SFTP{}
 
 const defaultLayout = "default"
 
-func startClient(program string, args ...string) (*SFTP, error) {
+func startClient(program string, args ...string) (*conn, error) {
 	debug.Log("start client %v %v", program, args)
 	// Connect to a remote host and request the sftp subsystem via the 'ssh'
 	// command.  This assumes that passwordless login is correctly configured.
@@ -91,25 +87,12 @@ func startClient(program string, args ...string) (*SFTP, error) {
 		return nil, errors.Wrap(err, "bg")
 	}
 
-	return &SFTP{c: client, cmd: cmd, result: ch}, nil
-}
-
-// clientError returns an error if the client has exited. Otherwise, nil is
-// returned immediately.
-func (r *SFTP) clientError() error {
-	select {
-	case err := <-r.result:
-		debug.Log("client has exited with err %v", err)
-		return err
-	default:
-	}
-
-	return nil
+	return newConn(client, cmd, ch), nil
 }
 
 // Open opens an sftp backend as described by the config by running
-// "ssh" with the appropriate arguments (or cfg.Command, if set). The function
-// preExec is run just before, postExec just after starting a program.
+// "ssh" with the appropriate arguments (or cfg.Command, if set). Up to
+// cfg.Connections ssh processes are started to run operations concurrently.
 func Open(cfg Config) (*SFTP, error) {
 	debug.Log("open backend with config %#v", cfg)
 
@@ -118,27 +101,69 @@ func Open(cfg Config) (*SFTP, error) {
 		return nil, err
 	}
 
-	sftp, err := startClient(cmd, args...)
+	return open(cfg, func() (*conn, error) {
+		c, err := startClient(cmd, args...)
+		if err != nil {
+			debug.Log("unable to start program: %v", err)
+		}
+		return c, err
+	})
+}
+
+// open opens the backend, new sftp sessions are started by calling connect.
+func open(cfg Config, connect func() (*conn, error)) (*SFTP, error) {
+	r := &SFTP{
+		pool:   newConnPool(cfg.Connections, connect),
+		p:      cfg.Path,
+		Config: cfg,
+	}
+
+	// start the first session right away, so that errors are reported early
+	c, err := r.pool.get()
 	if err != nil {
-		debug.Log("unable to start program: %v", err)
 		return nil, err
 	}
+	r.pool.put(c, false)
 
-	sftp.Layout, err = backend.ParseLayout(sftp, cfg.Layout, defaultLayout, cfg.Path)
+	r.Layout, err = backend.ParseLayout(r, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
+		_ = r.Close()
 		return nil, err
 	}
 
-	debug.Log("layout: %v\n", sftp.Layout)
+	debug.Log("layout: %v\n", r.Layout)
+	return r, nil
+}
+
+// withConn runs fn with a connection from the pool. When fn fails because the
+// connection was lost, it is run once more with a new connection.
+func (r *SFTP) withConn(fn func(c *conn) error) error {
+	c, err := r.pool.get()
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+	lost := err != nil && c.lost(err)
+	r.pool.put(c, lost)
+	if !lost {
+		return err
+	}
 
-	sftp.Config = cfg
-	sftp.p = cfg.Path
-	return sftp, nil
+	debug.Log("connection lost (%v), retrying with a new connection", err)
+	c, err = r.pool.get()
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+	r.pool.put(c, err != nil && c.lost(err))
+	return err
 }
 
-func (r *SFTP) mkdirAllDataSubdirs() error {
+func (r *SFTP) mkdirAllDataSubdirs(c *conn) error {
 	for _, d := range r.Paths() {
-		err := r.mkdirAll(d, backend.Modes.Dir)
+		err := r.mkdirAll(c, d, backend.Modes.Dir)
 		debug.Log("mkdirAll %v -> %v", d, err)
 		if err != nil {
 			return err
@@ -154,8 +179,11 @@ func (r *SFTP) Join(p ...string) string {
 }
 
 // ReadDir returns the entries for a directory.
-func (r *SFTP) ReadDir(dir string) ([]os.FileInfo, error) {
-	fi, err := r.c.ReadDir(dir)
+func (r *SFTP) ReadDir(dir string) (fi []os.FileInfo, err error) {
+	err = r.withConn(func(c *conn) (err error) {
+		fi, err = c.ReadDir(dir)
+		return err
+	})
 
 	// sftp client does not specify dir name on error, so add it here
 	err = errors.Wrapf(err, "(%v)", dir)
@@ -209,30 +237,23 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 // with the appropriate arguments (or cfg.Command, if set). The function
 // preExec is run just before, postExec just after starting a program.
 func Create(cfg Config) (*SFTP, error) {
-	cmd, args, err := buildSSHCommand(cfg)
+	sftp, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	sftp, err := startClient(cmd, args...)
-	if err != nil {
-		debug.Log("unable to start program: %v", err)
-		return nil, err
-	}
+	err = sftp.withConn(func(c *conn) error {
+		// test if config file already exists
+		_, err := c.Lstat(Join(cfg.Path, backend.Paths.Config))
+		if err == nil {
+			return errors.New("config file already exists")
+		}
 
-	sftp.Layout, err = backend.ParseLayout(sftp, cfg.Layout, defaultLayout, cfg.Path)
+		// create paths for data and refs
+		return sftp.mkdirAllDataSubdirs(c)
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	// test if config file already exists
-	_, err = sftp.c.Lstat(Join(cfg.Path, backend.Paths.Config))
-	if err == nil {
-		return nil, errors.New("config file already exists")
-	}
-
-	// create paths for data and refs
-	if err = sftp.mkdirAllDataSubdirs(); err != nil {
+		_ = sftp.Close()
 		return nil, err
 	}
 
@@ -250,9 +271,9 @@ func (r *SFTP) Location() string {
 	return r.p
 }
 
-func (r *SFTP) mkdirAll(dir string, mode os.FileMode) error {
+func (r *SFTP) mkdirAll(c *conn, dir string, mode os.FileMode) error {
 	// check if directory already exists
-	fi, err := r.c.Lstat(dir)
+	fi, err := c.Lstat(dir)
 	if err == nil {
 		if fi.IsDir() {
 			return nil
@@ -262,13 +283,13 @@ func (r *SFTP) mkdirAll(dir string, mode os.FileMode) error {
 	}
 
 	// create parent directories
-	errMkdirAll := r.mkdirAll(path.Dir(dir), backend.Modes.Dir)
+	errMkdirAll := r.mkdirAll(c, path.Dir(dir), backend.Modes.Dir)
 
 	// create directory
-	errMkdir := r.c.Mkdir(dir)
+	errMkdir := c.Mkdir(dir)
 
 	// test if directory was created successfully
-	fi, err = r.c.Lstat(dir)
+	fi, err = c.Lstat(dir)
 	if err != nil {
 		// return previous errors
 		return errors.Errorf("mkdirAll(%s): unable to create directories: %v, %v", dir, errMkdirAll, errMkdir)
@@ -279,7 +300,7 @@ func (r *SFTP) mkdirAll(dir string, mode os.FileMode) error {
 	}
 
 	// set mode
-	return r.c.Chmod(dir, mode)
+	return c.Chmod(dir, mode)
 }
 
 // Join joins the given paths and cleans them afterwards. This always uses
@@ -291,27 +312,75 @@ func Join(parts ...string) string {
 // Save stores data in the backend at the handle.
 func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
 	debug.Log("Save %v", h)
-	if err := r.clientError(); err != nil {
+	if err := h.Valid(); err != nil {
 		return err
 	}
 
+	filename := r.Filename(h)
+	retry := false
+	return r.withConn(func(c *conn) error {
+		if retry {
+			// remove the file left over by the attempt with the lost connection
+			_ = c.Remove(filename)
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+		}
+		retry = true
+
+		return r.writeFile(c, filename, r.Dirname(h), rd)
+	})
+}
+
+// SaveAtomic stores data in the backend at the handle. The data is written to
+// a temporary file first, which is renamed to the final name afterwards.
+func (r *SFTP) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("SaveAtomic %v", h)
 	if err := h.Valid(); err != nil {
 		return err
 	}
 
 	filename := r.Filename(h)
+	retry := false
+	return r.withConn(func(c *conn) error {
+		if retry {
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+		}
+		retry = true
+
+		tmpname := filename + restic.TempFileInfix + restic.NewRandomID().String()[:16]
 
+		err := r.writeFile(c, tmpname, r.Dirname(h), rd)
+		if err != nil {
+			_ = c.Remove(tmpname)
+			return err
+		}
+
+		err = c.Rename(tmpname, filename)
+		if err != nil {
+			_ = c.Remove(tmpname)
+			return errors.Wrap(err, "Rename")
+		}
+
+		return nil
+	})
+}
+
+// writeFile creates a new file in dir with the data from rd.
+func (r *SFTP) writeFile(c *conn, filename, dir string, rd io.Reader) error {
 	// create new file
-	f, err := r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	f, err := c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 
 	if r.IsNotExist(err) {
 		// error is caused by a missing directory, try to create it
-		mkdirErr := r.mkdirAll(r.Dirname(h), backend.Modes.Dir)
+		mkdirErr := r.mkdirAll(c, dir, backend.Modes.Dir)
 		if mkdirErr != nil {
-			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
+			debug.Log("error creating dir %v: %v", dir, mkdirErr)
 		} else {
 			// try again
-			f, err = r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+			f, err = c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 		}
 	}
 
@@ -331,7 +400,7 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		return errors.Wrap(err, "Close")
 	}
 
-	return errors.Wrap(r.c.Chmod(filename, backend.Modes.File), "Chmod")
+	return errors.Wrap(c.Chmod(filename, backend.Modes.File), "Chmod")
 }
 
 // Load runs fn with a reader that yields the contents of the file at h at the
@@ -350,8 +419,24 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 		return nil, errors.New("offset is negative")
 	}
 
-	f, err := r.c.Open(r.Filename(h))
+	// the connection is used until the reader is closed
+	c, err := r.pool.get()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.Open(r.Filename(h))
+	if err != nil && c.lost(err) {
+		debug.Log("connection lost (%v), retrying with a new connection", err)
+		r.pool.put(c, true)
+		c, err = r.pool.get()
+		if err != nil {
+			return nil, err
+		}
+		f, err = c.Open(r.Filename(h))
+	}
 	if err != nil {
+		r.pool.put(c, c.lost(err))
 		return nil, err
 	}
 
@@ -359,44 +444,67 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 		_, err = f.Seek(offset, 0)
 		if err != nil {
 			_ = f.Close()
+			r.pool.put(c, c.lost(err))
 			return nil, err
 		}
 	}
 
+	var rd io.ReadCloser = f
 	if length > 0 {
-		return backend.LimitReadCloser(f, int64(length)), nil
+		rd = backend.LimitReadCloser(f, int64(length))
+	}
+
+	return &connReader{ReadCloser: rd, pool: r.pool, c: c}, nil
+}
+
+// connReader returns the connection to the pool when it is closed.
+type connReader struct {
+	io.ReadCloser
+	pool *connPool
+	c    *conn
+	lost bool
+}
+
+func (rd *connReader) Read(p []byte) (int, error) {
+	n, err := rd.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && rd.c.lost(err) {
+		rd.lost = true
 	}
+	return n, err
+}
 
-	return f, nil
+func (rd *connReader) Close() error {
+	err := rd.ReadCloser.Close()
+	rd.pool.put(rd.c, rd.lost)
+	return err
 }
 
 // Stat returns information about a blob.
 func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
 	debug.Log("Stat(%v)", h)
-	if err := r.clientError(); err != nil {
-		return restic.FileInfo{}, err
-	}
-
 	if err := h.Valid(); err != nil {
 		return restic.FileInfo{}, err
 	}
 
-	fi, err := r.c.Lstat(r.Filename(h))
+	var fi os.FileInfo
+	err := r.withConn(func(c *conn) (err error) {
+		fi, err = c.Lstat(r.Filename(h))
+		return err
+	})
 	if err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "Lstat")
 	}
 
-	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
+	return restic.FileInfo{Size: fi.Size(), Name: h.Name, ModTime: fi.ModTime()}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
 func (r *SFTP) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	debug.Log("Test(%v)", h)
-	if err := r.clientError(); err != nil {
-		return false, err
-	}
-
-	_, err := r.c.Lstat(r.Filename(h))
+	err := r.withConn(func(c *conn) error {
+		_, err := c.Lstat(r.Filename(h))
+		return err
+	})
 	if os.IsNotExist(errors.Cause(err)) {
 		return false, nil
 	}
@@ -411,11 +519,9 @@ func (r *SFTP) Test(ctx context.Context, h restic.Handle) (bool, error) {
 // Remove removes the content stored at name.
 func (r *SFTP) Remove(ctx context.Context, h restic.Handle) error {
 	debug.Log("Remove(%v)", h)
-	if err := r.clientError(); err != nil {
-		return err
-	}
-
-	return r.c.Remove(r.Filename(h))
+	return r.withConn(func(c *conn) error {
+		return c.Remove(r.Filename(h))
+	})
 }
 
 // List runs fn for each file in the backend which has the type t. When an
@@ -423,8 +529,17 @@ func (r *SFTP) Remove(ctx context.Context, h restic.Handle) error {
 func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
 	debug.Log("List %v", t)
 
+	// fn must not be called twice for a file, so List is not retried
+	c, err := r.pool.get()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		r.pool.put(c, c.err() != nil)
+	}()
+
 	basedir, subdirs := r.Basedir(t)
-	walker := r.c.Walk(basedir)
+	walker := c.Walk(basedir)
 	for walker.Step() {
 		if walker.Err() != nil {
 			if r.IsNotExist(walker.Err()) {
@@ -451,8 +566,9 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 		debug.Log("send %v\n", path.Base(walker.Path()))
 
 		rfi := restic.FileInfo{
-			Name: path.Base(walker.Path()),
-			Size: fi.Size(),
+			Name:    path.Base(walker.Path()),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
 		}
 
 		if ctx.Err() != nil {
@@ -472,49 +588,31 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 	return ctx.Err()
 }
 
-var closeTimeout = 2 * time.Second
-
-// Close closes the sftp connection and terminates the underlying command.
+// Close closes the sftp connections and terminates the underlying commands.
 func (r *SFTP) Close() error {
 	debug.Log("Close")
 	if r == nil {
 		return nil
 	}
 
-	err := r.c.Close()
-	debug.Log("Close returned error %v", err)
-
-	// wait for closeTimeout before killing the process
-	select {
-	case err := <-r.result:
-		return err
-	case <-time.After(closeTimeout):
-	}
-
-	if err := r.cmd.Process.Kill(); err != nil {
-		return err
-	}
-
-	// get the error, but ignore it
-	<-r.result
-	return nil
+	return r.pool.Close()
 }
 
-func (r *SFTP) deleteRecursive(name string) error {
-	entries, err := r.ReadDir(name)
+func (r *SFTP) deleteRecursive(c *conn, name string) error {
+	entries, err := c.ReadDir(name)
 	if err != nil {
-		return errors.Wrap(err, "ReadDir")
+		return errors.Wrapf(err, "ReadDir(%v)", name)
 	}
 
 	for _, fi := range entries {
 		itemName := r.Join(name, fi.Name())
 		if fi.IsDir() {
-			err := r.deleteRecursive(itemName)
+			err := r.deleteRecursive(c, itemName)
 			if err != nil {
 				return errors.Wrap(err, "ReadDir")
 			}
 
-			err = r.c.RemoveDirectory(itemName)
+			err = c.RemoveDirectory(itemName)
 			if err != nil {
 				return errors.Wrap(err, "RemoveDirectory")
 			}
@@ -522,7 +620,7 @@ func (r *SFTP) deleteRecursive(name string) error {
 			continue
 		}
 
-		err := r.c.Remove(itemName)
+		err := c.Remove(itemName)
 		if err != nil {
 			return errors.Wrap(err, "ReadDir")
 		}
@@ -533,5 +631,7 @@ func (r *SFTP) deleteRecursive(name string) error {
 
 // Delete removes all data in the backend.
 func (r *SFTP) Delete(context.Context) error {
-	return r.deleteRecursive(r.p)
+	return r.withConn(func(c *conn) error {
+		return r.deleteRecursive(c, r.p)
+	})
 }
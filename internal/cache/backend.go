@@ -36,6 +36,7 @@ func newBackend(be restic.Backend, c *Cache) *Backend {
 func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	debug.Log("cache Remove(%v)", h)
 	err := b.Backend.Remove(ctx, h)
+	b.Cache.invalidateListing(h.Type)
 	if err != nil {
 		return err
 	}
@@ -50,6 +51,8 @@ var autoCacheTypes = map[restic.FileType]struct{}{
 
 // Save stores a new file in the backend and the cache.
 func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	defer b.Cache.invalidateListing(h.Type)
+
 	if _, ok := autoCacheTypes[h.Type]; !ok {
 		return b.Backend.Save(ctx, h, rd)
 	}
@@ -84,6 +87,60 @@ func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRea
 	return nil
 }
 
+// SaveAtomic stores a new file in the backend, atomically if the underlying
+// backend supports it. Files which are stored in the cache are saved using
+// Save.
+func (b *Backend) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if _, ok := autoCacheTypes[h.Type]; ok {
+		return b.Save(ctx, h, rd)
+	}
+
+	defer b.Cache.invalidateListing(h.Type)
+	return restic.SaveAtomic(ctx, b.Backend, h, rd)
+}
+
+// List runs fn for each file of type t in the backend. If the listing cache
+// is enabled for t, a cached listing is used if it has not expired yet.
+// Otherwise the listing is requested from the backend and stored in the cache.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if !b.Cache.cacheListing(t) {
+		return b.Backend.List(ctx, t, fn)
+	}
+
+	if files, ok := b.Cache.loadListing(t); ok {
+		debug.Log("List(%v): using cached listing with %d files", t, len(files))
+		for _, fi := range files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	}
+
+	gen := b.Cache.listingGeneration(t)
+
+	var files []restic.FileInfo
+	err := b.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+		files = append(files, fi)
+		return fn(fi)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.Cache.saveListing(t, gen, files)
+	if err != nil {
+		debug.Log("unable to save listing for %v: %v", t, err)
+	}
+
+	return nil
+}
+
 var autoCacheFiles = map[restic.FileType]bool{
 	restic.IndexFile:    true,
 	restic.SnapshotFile: true,
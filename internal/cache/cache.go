@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,6 +22,17 @@ type Cache struct {
 	Base             string
 	Created          bool
 	PerformReadahead func(restic.Handle) bool
+
+	listingMutex sync.Mutex
+	listingTTL   time.Duration
+	listingGen   map[restic.FileType]uint64
+
+	// lruEntries tracks the files in the cache if the size is limited to
+	// maxSize, lruSize is the total size of the files.
+	lruMutex   sync.Mutex
+	maxSize    int64
+	lruSize    int64
+	lruEntries map[restic.Handle]*lruEntry
 }
 
 const dirMode = 0700
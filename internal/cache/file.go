@@ -81,7 +81,7 @@ func (c *Cache) Load(h restic.Handle, length int, offset int64) (io.ReadCloser,
 		rd.Reader = io.LimitReader(f, int64(length))
 	}
 
-	return rd, nil
+	return &unpinReader{ReadCloser: rd, unpin: c.pin(h)}, nil
 }
 
 // SaveWriter returns a writer for the cache object h. It must be closed after writing is finished.
@@ -97,12 +97,14 @@ func (c *Cache) SaveWriter(h restic.Handle) (io.WriteCloser, error) {
 		return nil, errors.Wrap(err, "MkdirAll")
 	}
 
+	unpin := c.pin(h)
 	f, err := fs.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
 	if err != nil {
+		unpin(-1)
 		return nil, errors.Wrap(err, "Create")
 	}
 
-	return f, err
+	return &unpinWriter{File: f, unpin: unpin}, nil
 }
 
 // Save saves a file in the cache.
@@ -145,6 +147,7 @@ func (c *Cache) Remove(h restic.Handle) error {
 		return nil
 	}
 
+	c.forget(h)
 	return fs.Remove(c.filename(h))
 }
 
@@ -166,7 +169,9 @@ func (c *Cache) Clear(t restic.FileType, valid restic.IDSet) error {
 			continue
 		}
 
-		if err = fs.Remove(c.filename(restic.Handle{Type: t, Name: id.String()})); err != nil {
+		h := restic.Handle{Type: t, Name: id.String()}
+		c.forget(h)
+		if err = fs.Remove(c.filename(h)); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// listingDir is the directory within the cache which contains the listings.
+const listingDir = "listing"
+
+// listingTypes contains the file types for which the listing can be cached.
+// Lock files are used to coordinate concurrent access to the repository, so
+// they are always listed in the backend.
+var listingTypes = map[restic.FileType]struct{}{
+	restic.DataFile:     {},
+	restic.IndexFile:    {},
+	restic.SnapshotFile: {},
+	restic.KeyFile:      {},
+}
+
+// listing is the list of files of one type, as stored in the cache.
+type listing struct {
+	Time  time.Time      `json:"time"`
+	Files []listingEntry `json:"files"`
+}
+
+type listingEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// SetListingTTL enables caching the list of files in the repository for the
+// duration ttl. A ttl of zero or less disables the listing cache.
+func (c *Cache) SetListingTTL(ttl time.Duration) {
+	c.listingMutex.Lock()
+	defer c.listingMutex.Unlock()
+
+	if ttl < 0 {
+		ttl = 0
+	}
+	c.listingTTL = ttl
+}
+
+func (c *Cache) listingFilename(t restic.FileType) string {
+	return filepath.Join(c.Path, listingDir, string(t))
+}
+
+// cacheListing returns true if the listing of files of type t is cached.
+func (c *Cache) cacheListing(t restic.FileType) bool {
+	c.listingMutex.Lock()
+	defer c.listingMutex.Unlock()
+
+	_, ok := listingTypes[t]
+	return ok && c.listingTTL > 0
+}
+
+// listingGeneration returns the number of times the listing of type t has been
+// invalidated by this process.
+func (c *Cache) listingGeneration(t restic.FileType) uint64 {
+	c.listingMutex.Lock()
+	defer c.listingMutex.Unlock()
+
+	return c.listingGen[t]
+}
+
+// loadListing returns the cached listing of files of type t. If there is no
+// listing or it is older than the TTL, ok is false.
+func (c *Cache) loadListing(t restic.FileType) (files []restic.FileInfo, ok bool) {
+	c.listingMutex.Lock()
+	ttl := c.listingTTL
+	c.listingMutex.Unlock()
+
+	buf, err := ioutil.ReadFile(c.listingFilename(t))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debug.Log("unable to read listing for %v: %v", t, err)
+		}
+		return nil, false
+	}
+
+	var l listing
+	if err = json.Unmarshal(buf, &l); err != nil {
+		debug.Log("unable to decode listing for %v: %v", t, err)
+		return nil, false
+	}
+
+	if time.Since(l.Time) >= ttl {
+		debug.Log("listing for %v from %v has expired", t, l.Time)
+		return nil, false
+	}
+
+	files = make([]restic.FileInfo, 0, len(l.Files))
+	for _, entry := range l.Files {
+		files = append(files, restic.FileInfo{Name: entry.Name, Size: entry.Size, ModTime: entry.ModTime})
+	}
+
+	return files, true
+}
+
+// saveListing stores the listing of files of type t in the cache, unless it
+// has been invalidated since generation gen.
+func (c *Cache) saveListing(t restic.FileType, gen uint64, files []restic.FileInfo) error {
+	l := listing{
+		Time:  time.Now(),
+		Files: make([]listingEntry, 0, len(files)),
+	}
+	for _, fi := range files {
+		l.Files = append(l.Files, listingEntry{Name: fi.Name, Size: fi.Size, ModTime: fi.ModTime})
+	}
+
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	c.listingMutex.Lock()
+	defer c.listingMutex.Unlock()
+
+	if c.listingGen[t] != gen {
+		debug.Log("listing for %v was invalidated, not saving it", t)
+		return nil
+	}
+
+	dir := filepath.Join(c.Path, listingDir)
+	if err = fs.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that other processes never read a
+	// partial listing
+	f, err := ioutil.TempFile(dir, "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+
+	if _, err = f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Write")
+	}
+
+	if err = f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Close")
+	}
+
+	if err = fs.Rename(f.Name(), c.listingFilename(t)); err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Rename")
+	}
+
+	return nil
+}
+
+// InvalidateListings removes the cached listings of all file types, so that
+// the files are listed in the backend again.
+func (c *Cache) InvalidateListings() {
+	for t := range listingTypes {
+		c.invalidateListing(t)
+	}
+}
+
+// invalidateListing removes the cached listing of files of type t, it must be
+// called after a file of this type has been added or removed.
+func (c *Cache) invalidateListing(t restic.FileType) {
+	if _, ok := listingTypes[t]; !ok {
+		return
+	}
+
+	c.listingMutex.Lock()
+	defer c.listingMutex.Unlock()
+
+	if c.listingGen == nil {
+		c.listingGen = make(map[restic.FileType]uint64)
+	}
+	c.listingGen[t]++
+
+	err := fs.Remove(c.listingFilename(t))
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		debug.Log("unable to remove listing for %v: %v", t, err)
+	}
+}
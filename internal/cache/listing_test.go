@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func listNames(t testing.TB, be restic.Backend, tpe restic.FileType) []string {
+	names := []string{}
+	err := be.List(context.TODO(), tpe, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func saveFile(t testing.TB, be restic.Backend, tpe restic.FileType, data string) string {
+	h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(data)).String()}
+	save(t, be, h, []byte(data))
+	return h.Name
+}
+
+func sorted(names ...string) []string {
+	sort.Strings(names)
+	return names
+}
+
+func TestListingCacheInvalidate(t *testing.T) {
+	be := mem.New()
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+	c.SetListingTTL(time.Hour)
+	wbe := c.Wrap(be)
+
+	name1 := saveFile(t, wbe, restic.SnapshotFile, "foo")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.SnapshotFile))
+
+	// a file added by somebody else is not visible until the listing expires
+	name2 := saveFile(t, be, restic.SnapshotFile, "bar")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.SnapshotFile))
+
+	// saving a file invalidates the listing
+	name3 := saveFile(t, wbe, restic.SnapshotFile, "baz")
+	test.Equals(t, sorted(name1, name2, name3), listNames(t, wbe, restic.SnapshotFile))
+
+	// and so does removing a file
+	remove(t, wbe, restic.Handle{Type: restic.SnapshotFile, Name: name1})
+	test.Equals(t, sorted(name2, name3), listNames(t, wbe, restic.SnapshotFile))
+
+	// saving a file of another type does not invalidate the listing
+	saveFile(t, be, restic.SnapshotFile, "other")
+	saveFile(t, wbe, restic.DataFile, "data")
+	test.Equals(t, sorted(name2, name3), listNames(t, wbe, restic.SnapshotFile))
+
+	// the listing is stored in the cache and used by other processes
+	c2, err := New(filepath.Base(c.Path), c.Base)
+	test.OK(t, err)
+	c2.SetListingTTL(time.Hour)
+	test.Equals(t, sorted(name2, name3), listNames(t, c2.Wrap(be), restic.SnapshotFile))
+}
+
+func TestListingCacheInvalidateAll(t *testing.T) {
+	be := mem.New()
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+	c.SetListingTTL(time.Hour)
+	wbe := c.Wrap(be)
+
+	name1 := saveFile(t, wbe, restic.DataFile, "foo")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.DataFile))
+
+	// a pack added by another process is listed once the listings have been
+	// invalidated, e.g. when a lock is taken
+	name2 := saveFile(t, be, restic.DataFile, "bar")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.DataFile))
+
+	c.InvalidateListings()
+	test.Equals(t, sorted(name1, name2), listNames(t, wbe, restic.DataFile))
+}
+
+func TestListingCacheTTL(t *testing.T) {
+	be := mem.New()
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+	c.SetListingTTL(50 * time.Millisecond)
+	wbe := c.Wrap(be)
+
+	name1 := saveFile(t, wbe, restic.IndexFile, "foo")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.IndexFile))
+
+	name2 := saveFile(t, be, restic.IndexFile, "bar")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.IndexFile))
+
+	time.Sleep(100 * time.Millisecond)
+	test.Equals(t, sorted(name1, name2), listNames(t, wbe, restic.IndexFile))
+}
+
+func TestListingCacheLocks(t *testing.T) {
+	be := mem.New()
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+	c.SetListingTTL(time.Hour)
+	wbe := c.Wrap(be)
+
+	name1 := saveFile(t, wbe, restic.LockFile, "foo")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.LockFile))
+
+	// locks are never cached, so a removed lock must not be listed anymore,
+	// and new locks of other processes are visible immediately
+	remove(t, wbe, restic.Handle{Type: restic.LockFile, Name: name1})
+	name2 := saveFile(t, be, restic.LockFile, "bar")
+	test.Equals(t, []string{name2}, listNames(t, wbe, restic.LockFile))
+}
+
+func TestListingCacheDisabled(t *testing.T) {
+	be := mem.New()
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+	wbe := c.Wrap(be)
+
+	name1 := saveFile(t, wbe, restic.SnapshotFile, "foo")
+	test.Equals(t, []string{name1}, listNames(t, wbe, restic.SnapshotFile))
+
+	name2 := saveFile(t, be, restic.SnapshotFile, "bar")
+	test.Equals(t, sorted(name1, name2), listNames(t, wbe, restic.SnapshotFile))
+}
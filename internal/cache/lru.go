@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// lruEntry tracks a file in the cache for the size limit. The modification
+// time of the file is set to the last access time, so that it survives
+// restarts. Files with pinned > 0 are currently being read or written and are
+// never evicted.
+type lruEntry struct {
+	size   int64
+	atime  time.Time
+	pinned int
+}
+
+// SetMaxSize limits the total size of the files in the cache to max bytes.
+// When the limit is exceeded, the least recently used files are removed. A
+// max of zero or less disables the limit.
+func (c *Cache) SetMaxSize(max int64) error {
+	c.lruMutex.Lock()
+	defer c.lruMutex.Unlock()
+
+	if max <= 0 {
+		c.maxSize = 0
+		c.lruEntries = nil
+		c.lruSize = 0
+		return nil
+	}
+
+	if c.lruEntries == nil {
+		if err := c.scanEntries(); err != nil {
+			return err
+		}
+	}
+
+	c.maxSize = max
+	c.evict()
+	return nil
+}
+
+// scanEntries collects the size and last access time of all files in the
+// cache. It must be called with lruMutex held.
+func (c *Cache) scanEntries() error {
+	entries := make(map[restic.Handle]*lruEntry)
+	var size int64
+
+	for t, subdir := range cacheLayoutPaths {
+		err := filepath.Walk(filepath.Join(c.Path, subdir), func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return errors.Wrap(err, "Walk")
+			}
+
+			if !isFile(fi) {
+				return nil
+			}
+
+			id, err := restic.ParseID(filepath.Base(name))
+			if err != nil {
+				return nil
+			}
+
+			h := restic.Handle{Type: t, Name: id.String()}
+			entries[h] = &lruEntry{size: fi.Size(), atime: fi.ModTime()}
+			size += fi.Size()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	debug.Log("cache contains %d files with %d bytes", len(entries), size)
+	c.lruEntries = entries
+	c.lruSize = size
+	return nil
+}
+
+// evict removes the least recently used files which are not pinned until the
+// size of the cache is below the limit. It must be called with lruMutex
+// held.
+func (c *Cache) evict() {
+	if c.lruEntries == nil || c.lruSize <= c.maxSize {
+		return
+	}
+
+	var candidates []restic.Handle
+	for h, e := range c.lruEntries {
+		if e.pinned == 0 {
+			candidates = append(candidates, h)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return c.lruEntries[candidates[i]].atime.Before(c.lruEntries[candidates[j]].atime)
+	})
+
+	for _, h := range candidates {
+		if c.lruSize <= c.maxSize {
+			break
+		}
+
+		debug.Log("evicting %v from the cache", h)
+		err := fs.Remove(c.filename(h))
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			debug.Log("unable to remove %v: %v", h, err)
+			continue
+		}
+
+		c.lruSize -= c.lruEntries[h].size
+		delete(c.lruEntries, h)
+	}
+}
+
+// pin marks h as used and records the access. It returns a function which
+// must be called when h is not used any more, with the new size of the file
+// or a negative value if the size has not changed.
+func (c *Cache) pin(h restic.Handle) (unpin func(size int64)) {
+	c.lruMutex.Lock()
+	defer c.lruMutex.Unlock()
+
+	if c.lruEntries == nil {
+		return func(int64) {}
+	}
+
+	now := time.Now()
+	e, ok := c.lruEntries[h]
+	if !ok {
+		e = &lruEntry{}
+		c.lruEntries[h] = e
+	}
+	e.atime = now
+	e.pinned++
+
+	// remember the access time across restarts, ignore errors
+	_ = fs.Chtimes(c.filename(h), now, now)
+
+	return func(size int64) {
+		c.lruMutex.Lock()
+		defer c.lruMutex.Unlock()
+
+		// the file may have been removed in the meantime
+		if c.lruEntries == nil || c.lruEntries[h] != e {
+			return
+		}
+
+		e.pinned--
+		if size >= 0 {
+			c.lruSize += size - e.size
+			e.size = size
+		}
+		c.evict()
+	}
+}
+
+// forget stops tracking h after it has been removed from the cache.
+func (c *Cache) forget(h restic.Handle) {
+	c.lruMutex.Lock()
+	defer c.lruMutex.Unlock()
+
+	if e, ok := c.lruEntries[h]; ok {
+		c.lruSize -= e.size
+		delete(c.lruEntries, h)
+	}
+}
+
+// unpinReader calls unpin when the reader is closed.
+type unpinReader struct {
+	io.ReadCloser
+	unpin func(int64)
+}
+
+func (rd *unpinReader) Close() error {
+	err := rd.ReadCloser.Close()
+	rd.unpin(-1)
+	return err
+}
+
+// unpinWriter calls unpin with the size of the file when the file is closed.
+type unpinWriter struct {
+	*os.File
+	unpin func(int64)
+}
+
+func (wr *unpinWriter) Close() error {
+	size := int64(-1)
+	if fi, err := wr.File.Stat(); err == nil {
+		size = fi.Size()
+	}
+
+	err := wr.File.Close()
+	wr.unpin(size)
+	return err
+}
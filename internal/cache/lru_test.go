@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+const lruTestFileSize = 1000
+
+func saveTestFile(t testing.TB, c *Cache, seed int) restic.Handle {
+	buf := test.Random(seed, lruTestFileSize)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(buf).String()}
+	test.OK(t, c.Save(h, bytes.NewReader(buf)))
+	return h
+}
+
+func checkCached(t testing.TB, c *Cache, want map[restic.Handle]bool) {
+	t.Helper()
+	for h, cached := range want {
+		if c.Has(h) != cached {
+			t.Errorf("file %v: want cached %v, got %v", h, cached, c.Has(h))
+		}
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+
+	test.OK(t, c.SetMaxSize(3*lruTestFileSize))
+
+	h0 := saveTestFile(t, c, 0)
+	h1 := saveTestFile(t, c, 1)
+	h2 := saveTestFile(t, c, 2)
+	checkCached(t, c, map[restic.Handle]bool{h0: true, h1: true, h2: true})
+
+	// accessing the first file makes the second one the least recently used
+	load(t, c, h0)
+
+	h3 := saveTestFile(t, c, 3)
+	checkCached(t, c, map[restic.Handle]bool{h0: true, h1: false, h2: true, h3: true})
+
+	h4 := saveTestFile(t, c, 4)
+	checkCached(t, c, map[restic.Handle]bool{h0: true, h2: false, h3: true, h4: true})
+}
+
+func TestLRUPinned(t *testing.T) {
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+
+	test.OK(t, c.SetMaxSize(lruTestFileSize))
+
+	h0 := saveTestFile(t, c, 0)
+	rd, err := c.Load(h0, 0, 0)
+	test.OK(t, err)
+
+	// the file which is being read is not evicted although it is the least
+	// recently used one
+	h1 := saveTestFile(t, c, 1)
+	h2 := saveTestFile(t, c, 2)
+	checkCached(t, c, map[restic.Handle]bool{h0: true, h1: false, h2: false})
+
+	test.OK(t, rd.Close())
+	checkCached(t, c, map[restic.Handle]bool{h0: true})
+
+	h3 := saveTestFile(t, c, 3)
+	checkCached(t, c, map[restic.Handle]bool{h0: false, h3: true})
+}
+
+func TestLRUExistingFiles(t *testing.T) {
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+
+	// the modification time is the last access time of files cached before
+	var handles []restic.Handle
+	for i := 0; i < 4; i++ {
+		h := saveTestFile(t, c, i)
+		ts := time.Now().Add(time.Duration(i-10) * time.Hour)
+		test.OK(t, fs.Chtimes(c.filename(h), ts, ts))
+		handles = append(handles, h)
+	}
+
+	test.OK(t, c.SetMaxSize(2*lruTestFileSize))
+	checkCached(t, c, map[restic.Handle]bool{
+		handles[0]: false,
+		handles[1]: false,
+		handles[2]: true,
+		handles[3]: true,
+	})
+
+	// removing the limit keeps all files
+	test.OK(t, c.SetMaxSize(0))
+	h4 := saveTestFile(t, c, 4)
+	checkCached(t, c, map[restic.Handle]bool{handles[2]: true, handles[3]: true, h4: true})
+}
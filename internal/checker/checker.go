@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"sync"
 
 	"github.com/restic/restic/internal/debug"
@@ -32,6 +33,11 @@ type Checker struct {
 	masterIndex *repository.MasterIndex
 
 	repo restic.Repository
+
+	// ReadWorkers is the number of packs which are downloaded and verified
+	// concurrently by ReadData and ReadPacks. If it is zero,
+	// DefaultReadWorkers() is used.
+	ReadWorkers int
 }
 
 // New returns a new checker which runs on repo.
@@ -51,6 +57,19 @@ func New(repo restic.Repository) *Checker {
 
 const defaultParallelism = 5
 
+// maxDefaultReadWorkers caps the default number of packs read concurrently.
+const maxDefaultReadWorkers = 16
+
+// DefaultReadWorkers returns the number of packs read concurrently unless
+// configured otherwise, which is GOMAXPROCS but at most 16.
+func DefaultReadWorkers() int {
+	n := runtime.GOMAXPROCS(0)
+	if n > maxDefaultReadWorkers {
+		n = maxDefaultReadWorkers
+	}
+	return n
+}
+
 // ErrDuplicatePacks is returned when a pack is found in more than one index.
 type ErrDuplicatePacks struct {
 	PackID  restic.ID
@@ -241,8 +260,15 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 		errChan <- err
 	}
 
-	// orphaned: present in the repo but not in c.packs
+	// orphaned: present in the repo but not in c.packs. Packs which prune
+	// was unable to remove are listed as pending removal and not reported.
+	pending := c.masterIndex.PendingRemoval()
 	for orphanID := range repoPacks.Sub(c.packs) {
+		if pending.Has(orphanID) {
+			debug.Log("pack %v is pending removal", orphanID)
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
 			return
@@ -250,12 +276,27 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 		}
 	}
 
-	// missing: present in c.packs but not in the repo
-	for missingID := range c.packs.Sub(repoPacks) {
+	// missing: present in c.packs but not in the repo. Listings of some
+	// backends may not include files which were saved very recently, so
+	// each pack is checked again with Stat before reporting it. Listing the
+	// files again could miss them the same way.
+	be := c.repo.Backend()
+	for id := range c.packs.Sub(repoPacks) {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		_, err := be.Stat(ctx, h)
+		if err == nil {
+			debug.Log("pack %v not listed, but exists", h.Name)
+			continue
+		}
+
+		if be.IsNotExist(err) {
+			err = errors.New("does not exist")
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case errChan <- PackError{ID: missingID, Err: errors.New("does not exist")}:
+		case errChan <- PackError{ID: id, Err: err}:
 		}
 	}
 }
@@ -608,7 +649,9 @@ func (c *Checker) Structure(ctx context.Context, errChan chan<- error) {
 func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 	debug.Log("checking tree %v", id)
 
-	var blobs []restic.ID
+	// the chunks of a split tree are referenced like data blobs, they are
+	// already loaded with the tree
+	blobs := append(restic.IDs{}, tree.Chunks...)
 
 	for _, node := range tree.Nodes {
 		switch node.Type {
@@ -746,7 +789,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		}
 
 		nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
-		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, r.Config().BlobAdditionalData(blob.ID))
 		if err != nil {
 			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
 			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
@@ -762,7 +805,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	}
 
 	if len(errs) > 0 {
-		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+		return errors.Errorf("contains %v errors: %v", len(errs), errs)
 	}
 
 	return nil
@@ -773,18 +816,26 @@ func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan
 	c.ReadPacks(ctx, c.packs, p, errChan)
 }
 
-// ReadPacks loads data from specified packs and checks the integrity.
+// ReadPacks loads data from specified packs and checks the integrity. Errors
+// for individual packs are sent to errChan as PackError.
 func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.Progress, errChan chan<- error) {
 	defer close(errChan)
 
 	p.Start()
 	defer p.Done()
 
+	workers := c.ReadWorkers
+	if workers <= 0 {
+		workers = DefaultReadWorkers()
+	}
+	debug.Log("reading %d packs with %d workers", len(packs), workers)
+
 	g, ctx := errgroup.WithContext(ctx)
 	ch := make(chan restic.ID)
 
-	// run workers
-	for i := 0; i < defaultParallelism; i++ {
+	// run workers, each one verifies a pack independently and sends errors
+	// to errChan
+	for i := 0; i < workers; i++ {
 		g.Go(func() error {
 			for {
 				var id restic.ID
@@ -808,7 +859,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 				select {
 				case <-ctx.Done():
 					return nil
-				case errChan <- err:
+				case errChan <- PackError{ID: id, Err: err}:
 				}
 			}
 		})
@@ -1,16 +1,21 @@
 package checker_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -105,6 +110,53 @@ func TestMissingPack(t *testing.T) {
 	}
 }
 
+// unlistedBackend omits the file hidden from listings, like a backend
+// whose listing does not include files saved very recently.
+type unlistedBackend struct {
+	restic.Backend
+	hidden restic.Handle
+}
+
+func (b unlistedBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return b.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+		if t == b.hidden.Type && fi.Name == b.hidden.Name {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+func TestUnlistedPack(t *testing.T) {
+	repodir, cleanup := test.Env(t, checkerTestData)
+	defer cleanup()
+
+	be, err := local.Open(local.Config{Path: repodir})
+	test.OK(t, err)
+
+	// the pack exists, but is not listed
+	packHandle := restic.Handle{
+		Type: restic.DataFile,
+		Name: "657f7fb64f6a854fff6fe9279998ee09034901eded4e6db9bcee0e59745bbce6",
+	}
+	repo := repository.New(unlistedBackend{Backend: be, hidden: packHandle})
+	test.OK(t, repo.SearchKey(context.TODO(), test.TestPassword, 10, ""))
+
+	chkr := checker.New(repo)
+	hints, errs := chkr.LoadIndex(context.TODO())
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+
+	if len(hints) > 0 {
+		t.Errorf("expected no hints, got %v: %v", len(hints), hints)
+	}
+
+	errs = checkPacks(chkr)
+	if len(errs) > 0 {
+		t.Errorf("expected no errors, got %v: %v", len(errs), errs)
+	}
+}
+
 func TestUnreferencedPack(t *testing.T) {
 	repodir, cleanup := test.Env(t, checkerTestData)
 	defer cleanup()
@@ -363,6 +415,118 @@ func TestCheckerModifiedData(t *testing.T) {
 	}
 }
 
+// corruptingBackend modifies the data of all packs whose ID starts with an
+// even byte.
+type corruptingBackend struct {
+	restic.Backend
+}
+
+func (b corruptingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) error {
+	id, err := restic.ParseID(h.Name)
+	if h.Type != restic.DataFile || err != nil || id[0]%2 != 0 {
+		return b.Backend.Load(ctx, h, length, offset, consumer)
+	}
+
+	return b.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		buf[len(buf)/2] ^= 1
+		return consumer(bytes.NewReader(buf))
+	})
+}
+
+func sortedErrors(errs []error) []string {
+	list := make([]string, 0, len(errs))
+	for _, err := range errs {
+		list = append(list, err.Error())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestCheckerReadWorkers(t *testing.T) {
+	repodir, cleanup := test.Env(t, checkerTestData)
+	defer cleanup()
+
+	repo := repository.TestOpenLocal(t, repodir)
+	checkRepo := repository.New(corruptingBackend{Backend: repo.Backend()})
+	test.OK(t, checkRepo.SearchKey(context.TODO(), test.TestPassword, 5, ""))
+
+	var results [][]string
+	for _, workers := range []int{1, 2, 8, 0} {
+		chkr := checker.New(checkRepo)
+		chkr.ReadWorkers = workers
+
+		hints, errs := chkr.LoadIndex(context.TODO())
+		if len(errs) > 0 {
+			t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+		}
+
+		if len(hints) > 0 {
+			t.Errorf("expected no hints, got %v: %v", len(hints), hints)
+		}
+
+		corrupted := 0
+		for id := range chkr.GetPacks() {
+			if id[0]%2 == 0 {
+				corrupted++
+			}
+		}
+
+		errs = checkData(chkr)
+		if len(errs) != corrupted {
+			t.Fatalf("workers %d: expected %d errors, got %d: %v", workers, corrupted, len(errs), errs)
+		}
+
+		results = append(results, sortedErrors(errs))
+	}
+
+	for i := 1; i < len(results); i++ {
+		if !reflect.DeepEqual(results[0], results[i]) {
+			t.Errorf("different errors reported for run %d:\n  %v\n  %v", i, results[0], results[i])
+		}
+	}
+}
+
+// slowBackend delays all Load calls to simulate the latency of a remote
+// backend.
+type slowBackend struct {
+	restic.Backend
+	delay time.Duration
+}
+
+func (b slowBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) error {
+	time.Sleep(b.delay)
+	return b.Backend.Load(ctx, h, length, offset, consumer)
+}
+
+func BenchmarkCheckerReadData(b *testing.B) {
+	repodir, cleanup := test.Env(b, checkerTestData)
+	defer cleanup()
+
+	repo := repository.TestOpenLocal(b, repodir)
+	checkRepo := repository.New(slowBackend{Backend: repo.Backend(), delay: 5 * time.Millisecond})
+	test.OK(b, checkRepo.SearchKey(context.TODO(), test.TestPassword, 5, ""))
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			chkr := checker.New(checkRepo)
+			chkr.ReadWorkers = workers
+
+			_, errs := chkr.LoadIndex(context.TODO())
+			test.OKs(b, errs)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				test.OKs(b, checkData(chkr))
+			}
+		})
+	}
+}
+
 func BenchmarkChecker(t *testing.B) {
 	repodir, cleanup := test.Env(t, checkerTestData)
 	defer cleanup()
@@ -0,0 +1,130 @@
+package checker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// VerifiedPacksFilename is the name of the file in the repository's cache
+// directory that records which packs have been verified successfully.
+const VerifiedPacksFilename = "check-verified.json"
+
+// VerifiedPacks records when the data of packs has last been read and
+// verified successfully. It is stored locally and allows skipping packs
+// which have been verified recently.
+type VerifiedPacks struct {
+	RepositoryID string
+	Packs        map[restic.ID]time.Time
+}
+
+type verifiedPacksFile struct {
+	RepositoryID string               `json:"repository_id"`
+	Packs        map[string]time.Time `json:"packs"`
+}
+
+// NewVerifiedPacks returns an empty state for the repository with the given
+// ID.
+func NewVerifiedPacks(repoID string) *VerifiedPacks {
+	return &VerifiedPacks{
+		RepositoryID: repoID,
+		Packs:        make(map[restic.ID]time.Time),
+	}
+}
+
+// LoadVerifiedPacks reads the state for the repository repoID from filename.
+// If the file does not exist or belongs to a different repository, an empty
+// state is returned.
+func LoadVerifiedPacks(filename string, repoID string) (*VerifiedPacks, error) {
+	v := NewVerifiedPacks(repoID)
+
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var f verifiedPacksFile
+	err = json.Unmarshal(buf, &f)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	if f.RepositoryID != repoID {
+		debug.Log("verified packs in %v belong to repo %v, ignoring", filename, f.RepositoryID)
+		return v, nil
+	}
+
+	for s, t := range f.Packs {
+		id, err := restic.ParseID(s)
+		if err != nil {
+			debug.Log("ignoring invalid pack ID %q: %v", s, err)
+			continue
+		}
+		v.Packs[id] = t
+	}
+
+	return v, nil
+}
+
+// Save writes the state to filename, replacing the file atomically.
+func (v *VerifiedPacks) Save(filename string) error {
+	f := verifiedPacksFile{
+		RepositoryID: v.RepositoryID,
+		Packs:        make(map[string]time.Time, len(v.Packs)),
+	}
+	for id, t := range v.Packs {
+		f.Packs[id.String()] = t
+	}
+
+	buf, err := json.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	err = fs.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	tmpname := filename + ".tmp"
+	err = ioutil.WriteFile(tmpname, buf, 0600)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	return errors.Wrap(fs.Rename(tmpname, filename), "Rename")
+}
+
+// Mark records that the pack id has been verified successfully at time t.
+func (v *VerifiedPacks) Mark(id restic.ID, t time.Time) {
+	v.Packs[id] = t
+}
+
+// VerifiedSince returns true if the pack id has been verified successfully
+// at or after t.
+func (v *VerifiedPacks) VerifiedSince(id restic.ID, t time.Time) bool {
+	verified, ok := v.Packs[id]
+	return ok && !verified.Before(t)
+}
+
+// Prune removes all packs which are not contained in existing and returns the
+// number of removed entries.
+func (v *VerifiedPacks) Prune(existing restic.IDSet) (removed int) {
+	for id := range v.Packs {
+		if !existing.Has(id) {
+			delete(v.Packs, id)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,72 @@
+package checker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestVerifiedPacks(t *testing.T) {
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "repo", checker.VerifiedPacksFilename)
+
+	v, err := checker.LoadVerifiedPacks(filename, "repo")
+	test.OK(t, err)
+	test.Equals(t, 0, len(v.Packs))
+
+	now := time.Now()
+	id1 := restic.NewRandomID()
+	id2 := restic.NewRandomID()
+	v.Mark(id1, now)
+	v.Mark(id2, now.Add(-48*time.Hour))
+
+	test.OK(t, v.Save(filename))
+
+	v, err = checker.LoadVerifiedPacks(filename, "repo")
+	test.OK(t, err)
+	test.Equals(t, 2, len(v.Packs))
+
+	cutoff := now.Add(-24 * time.Hour)
+	test.Assert(t, v.VerifiedSince(id1, cutoff), "pack %v should have been verified recently", id1.Str())
+	test.Assert(t, !v.VerifiedSince(id2, cutoff), "pack %v should not have been verified recently", id2.Str())
+	test.Assert(t, !v.VerifiedSince(restic.NewRandomID(), cutoff), "unknown pack reported as verified")
+
+	// the state for a different repository must not be used
+	other, err := checker.LoadVerifiedPacks(filename, "other-repo")
+	test.OK(t, err)
+	test.Equals(t, 0, len(other.Packs))
+	test.Equals(t, "other-repo", other.RepositoryID)
+}
+
+func TestVerifiedPacksPrune(t *testing.T) {
+	v := checker.NewVerifiedPacks("repo")
+
+	now := time.Now()
+	id1 := restic.NewRandomID()
+	id2 := restic.NewRandomID()
+	v.Mark(id1, now)
+	v.Mark(id2, now)
+
+	removed := v.Prune(restic.NewIDSet(id1))
+	test.Equals(t, 1, removed)
+	test.Assert(t, v.VerifiedSince(id1, now), "existing pack was removed")
+	test.Assert(t, !v.VerifiedSince(id2, now), "missing pack was not removed")
+}
+
+func TestVerifiedPacksInvalid(t *testing.T) {
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, checker.VerifiedPacksFilename)
+	test.OK(t, ioutil.WriteFile(filename, []byte("{invalid"), 0600))
+
+	_, err := checker.LoadVerifiedPacks(filename, "repo")
+	test.Assert(t, err != nil, "no error returned for invalid file")
+}
@@ -3,7 +3,10 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 
@@ -158,6 +161,34 @@ func NewRandomNonce() []byte {
 	return iv
 }
 
+// NewDeterministicNonce returns a nonce which is derived from the plaintext
+// and the key, so that encrypting the same plaintext twice yields the same
+// ciphertext. Different plaintexts never share a nonce unless the hash
+// collides. This reveals whether two ciphertexts contain the same plaintext,
+// so it must only be used when this is intended.
+func (k *Key) NewDeterministicNonce(plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonceKey())
+	_, _ = mac.Write(plaintext)
+	iv := mac.Sum(nil)[:ivSize]
+
+	if !validNonce(iv) {
+		// an all-zero nonce is rejected by Seal and Open
+		iv[0] = 1
+	}
+	return iv
+}
+
+// nonceKey returns the key for deriving deterministic nonces. It is derived
+// from the keys with a fixed label, so that the encryption key itself is
+// never used for anything but encryption.
+func (k *Key) nonceKey() []byte {
+	mac := hmac.New(sha256.New, k.EncryptionKey[:])
+	_, _ = mac.Write(k.MACKey.K[:])
+	_, _ = mac.Write(k.MACKey.R[:])
+	_, _ = mac.Write([]byte("restic deterministic nonce key"))
+	return mac.Sum(nil)
+}
+
 type jsonMACKey struct {
 	K []byte `json:"k"`
 	R []byte `json:"r"`
@@ -276,6 +307,24 @@ func sliceForAppend(in []byte, n int) (head, tail []byte) {
 	return
 }
 
+// macInput returns the message authenticated by the MAC for ciphertext and
+// additionalData. Without additional data, this is only the ciphertext. Else
+// the additional data is prepended and its length appended, so that the
+// boundary between the additional data and the ciphertext is unambiguous.
+func macInput(ciphertext, additionalData []byte) []byte {
+	if len(additionalData) == 0 {
+		return ciphertext
+	}
+
+	msg := make([]byte, 0, len(additionalData)+len(ciphertext)+8)
+	msg = append(msg, additionalData...)
+	msg = append(msg, ciphertext...)
+
+	var l [8]byte
+	binary.LittleEndian.PutUint64(l[:], uint64(len(additionalData)))
+	return append(msg, l[:]...)
+}
+
 // Seal encrypts and authenticates plaintext, authenticates the
 // additional data and appends the result to dst, returning the updated
 // slice. The nonce must be NonceSize() bytes long and unique for all
@@ -288,10 +337,6 @@ func (k *Key) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 		panic("key is invalid")
 	}
 
-	if len(additionalData) > 0 {
-		panic("additional data is not supported")
-	}
-
 	if len(nonce) != ivSize {
 		panic("incorrect nonce length")
 	}
@@ -309,7 +354,7 @@ func (k *Key) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 	e := cipher.NewCTR(c, nonce)
 	e.XORKeyStream(out, plaintext)
 
-	mac := poly1305MAC(out[:len(plaintext)], nonce, &k.MACKey)
+	mac := poly1305MAC(macInput(out[:len(plaintext)], additionalData), nonce, &k.MACKey)
 	copy(out[len(plaintext):], mac)
 
 	return ret
@@ -349,7 +394,7 @@ func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error
 	ct, mac := ciphertext[:l], ciphertext[l:]
 
 	// verify mac
-	if !poly1305Verify(ct, nonce, &k.MACKey, mac) {
+	if !poly1305Verify(macInput(ct, additionalData), nonce, &k.MACKey, mac) {
 		return nil, ErrUnauthenticated
 	}
 
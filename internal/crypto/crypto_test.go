@@ -2,7 +2,9 @@ package crypto_test
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"io"
 	"testing"
 
@@ -43,6 +45,61 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDeterministicNonce(t *testing.T) {
+	k := crypto.NewRandomKey()
+	data := rtest.Random(23, 1000)
+
+	nonce := k.NewDeterministicNonce(data)
+	rtest.Equals(t, k.NonceSize(), len(nonce))
+	rtest.Equals(t, nonce, k.NewDeterministicNonce(data))
+
+	other := append([]byte{}, data...)
+	other[0]++
+	rtest.Assert(t, !bytes.Equal(nonce, k.NewDeterministicNonce(other)),
+		"same nonce for different plaintexts")
+
+	rtest.Assert(t, !bytes.Equal(nonce, crypto.NewRandomKey().NewDeterministicNonce(data)),
+		"same nonce for different keys")
+
+	// the nonce must not be keyed with the encryption key itself
+	mac := hmac.New(sha256.New, k.EncryptionKey[:])
+	_, _ = mac.Write(data)
+	rtest.Assert(t, !bytes.Equal(nonce, mac.Sum(nil)[:len(nonce)]),
+		"nonce is derived with the encryption key")
+
+	ciphertext := k.Seal(nil, nonce, data, nil)
+	rtest.Equals(t, ciphertext, k.Seal(nil, k.NewDeterministicNonce(data), data, nil))
+
+	plaintext, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plaintext)
+}
+
+func TestAdditionalData(t *testing.T) {
+	k := crypto.NewRandomKey()
+	data := rtest.Random(23, 1000)
+	ad := rtest.Random(42, 32)
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := k.Seal(nil, nonce, data, ad)
+
+	plaintext, err := k.Open(nil, nonce, ciphertext, ad)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plaintext)
+
+	other := append([]byte{}, ad...)
+	other[0]++
+	for _, wrong := range [][]byte{nil, other, ad[:16], append(append([]byte{}, ad...), 0)} {
+		_, err = k.Open(nil, nonce, ciphertext, wrong)
+		rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+	}
+
+	// data sealed without additional data cannot be opened with it
+	ciphertext = k.Seal(nil, nonce, data, nil)
+	_, err = k.Open(nil, nonce, ciphertext, ad)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+}
+
 func TestSmallBuffer(t *testing.T) {
 	k := crypto.NewRandomKey()
 
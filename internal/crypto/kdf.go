@@ -7,11 +7,18 @@ import (
 	"github.com/restic/restic/internal/errors"
 
 	sscrypt "github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
 const saltLength = 64
 
+// Names of the supported key derivation functions, as stored in key files.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
 // Params are the default parameters used for the key derivation function KDF().
 type Params struct {
 	N int
@@ -68,8 +75,6 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 		return nil, errors.Wrap(err, "Check")
 	}
 
-	derKeys := &Key{}
-
 	keybytes := macKeySize + aesKeySize
 	scryptKeys, err := scrypt.Key([]byte(password), salt, p.N, p.R, p.P, keybytes)
 	if err != nil {
@@ -80,13 +85,71 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 		return nil, errors.Errorf("invalid numbers of bytes expanded from scrypt(): %d", len(scryptKeys))
 	}
 
-	// first 32 byte of scrypt output is the encryption key
-	copy(derKeys.EncryptionKey[:], scryptKeys[:aesKeySize])
+	return keyFromKDFOutput(scryptKeys), nil
+}
+
+// Argon2Params are the parameters used for the key derivation function
+// Argon2KDF().
+type Argon2Params struct {
+	Time    uint32 // number of passes over the memory
+	Memory  uint32 // memory in KiB
+	Threads uint8  // degree of parallelism
+}
+
+// DefaultArgon2Params are the default parameters for Argon2KDF(), they follow
+// the recommendation for memory-constrained environments in RFC 9106.
+var DefaultArgon2Params = Argon2Params{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Check returns an error if the parameters cannot be used for Argon2id.
+func (p Argon2Params) Check() error {
+	if p.Time < 1 {
+		return errors.Errorf("invalid Argon2id time parameter %d, must be at least 1", p.Time)
+	}
+
+	if p.Threads < 1 {
+		return errors.Errorf("invalid Argon2id parallelism %d, must be at least 1", p.Threads)
+	}
+
+	if p.Memory < 8*uint32(p.Threads) {
+		return errors.Errorf("invalid Argon2id memory %d KiB, must be at least 8 KiB per thread", p.Memory)
+	}
+
+	return nil
+}
+
+// Argon2KDF derives encryption and message authentication keys from the
+// password using Argon2id with the supplied parameters and the salt.
+func Argon2KDF(p Argon2Params, salt []byte, password string) (*Key, error) {
+	if len(salt) != saltLength {
+		return nil, errors.Errorf("argon2id() called with invalid salt bytes (len %d)", len(salt))
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
+	keybytes := macKeySize + aesKeySize
+	argonKeys := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(keybytes))
+
+	return keyFromKDFOutput(argonKeys), nil
+}
+
+// keyFromKDFOutput splits the output of a key derivation function into
+// encryption and message authentication keys.
+func keyFromKDFOutput(buf []byte) *Key {
+	derKeys := &Key{}
+
+	// first 32 byte of the output is the encryption key
+	copy(derKeys.EncryptionKey[:], buf[:aesKeySize])
 
-	// next 32 byte of scrypt output is the mac key, in the form k||r
-	macKeyFromSlice(&derKeys.MACKey, scryptKeys[aesKeySize:])
+	// next 32 byte of the output is the mac key, in the form k||r
+	macKeyFromSlice(&derKeys.MACKey, buf[aesKeySize:])
 
-	return derKeys, nil
+	return derKeys
 }
 
 // NewSalt returns new random salt bytes to use with KDF(). If NewSalt returns
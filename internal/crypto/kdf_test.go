@@ -12,3 +12,82 @@ func TestCalibrate(t *testing.T) {
 	}
 	t.Logf("testing calibrate, params after: %v", params)
 }
+
+func TestArgon2KDF(t *testing.T) {
+	params := Argon2Params{Time: 1, Memory: 64, Threads: 2}
+
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1, err := Argon2KDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !k1.Valid() {
+		t.Fatal("derived key is not valid")
+	}
+
+	k2, err := Argon2KDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *k1 != *k2 {
+		t.Fatal("same password and salt derived different keys")
+	}
+
+	for _, p := range []Argon2Params{
+		{Time: 2, Memory: 64, Threads: 2},
+		{Time: 1, Memory: 128, Threads: 2},
+		{Time: 1, Memory: 64, Threads: 1},
+	} {
+		k, err := Argon2KDF(p, salt, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *k == *k1 {
+			t.Errorf("parameters %v derived the same key as %v", p, params)
+		}
+	}
+
+	salt2, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k3, err := Argon2KDF(params, salt2, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *k3 == *k1 {
+		t.Fatal("different salts derived the same key")
+	}
+}
+
+func TestArgon2KDFInvalidParams(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []Argon2Params{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+		{Time: 1, Memory: 15, Threads: 2},
+	} {
+		_, err := Argon2KDF(p, salt, "password")
+		if err == nil {
+			t.Errorf("expected error for parameters %v, got none", p)
+		}
+	}
+
+	_, err = Argon2KDF(Argon2Params{Time: 1, Memory: 64, Threads: 1}, salt[:10], "password")
+	if err == nil {
+		t.Error("expected error for short salt, got none")
+	}
+}
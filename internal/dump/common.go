@@ -0,0 +1,82 @@
+// Package dump writes the contents of a tree stored in a repository to an
+// io.Writer, either as the data of a single file or as an archive.
+package dump
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// dumpFunc is called by writeDump for each node, path is the slash-separated
+// path of the node within the archive.
+type dumpFunc func(ctx context.Context, node *restic.Node, path string) error
+
+// writeDump calls dump for all nodes in tree and, recursively, for all nodes
+// below them. The paths of the nodes are relative to rootPath.
+func writeDump(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dump dumpFunc) error {
+	for _, node := range tree.Nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		nodePath := path.Join(rootPath, node.Name)
+		err := dump(ctx, node, nodePath)
+		if err != nil {
+			return err
+		}
+
+		if node.Type != "dir" {
+			continue
+		}
+
+		if node.Subtree == nil {
+			return errors.Errorf("dir %q has no subtree", nodePath)
+		}
+
+		subtree, err := repo.LoadTree(ctx, *node.Subtree)
+		if err != nil {
+			return errors.Wrapf(err, "cannot load subtree for %q", nodePath)
+		}
+
+		err = writeDump(ctx, repo, subtree, nodePath, dump)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetNodeData writes the content of the file node to output.
+func GetNodeData(ctx context.Context, output io.Writer, repo restic.Repository, node *restic.Node) error {
+	var buf []byte
+	for _, id := range node.Content {
+
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return errors.Errorf("id %v not found in repository", id)
+		}
+
+		buf = buf[:cap(buf)]
+		if len(buf) < restic.CiphertextLength(int(size)) {
+			buf = restic.NewBlobBuffer(int(size))
+		}
+
+		n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+		if err != nil {
+			return err
+		}
+		buf = buf[:n]
+
+		_, err = output.Write(buf)
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package dump
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ImageMetadata is the metadata of an entry in a file system image.
+type ImageMetadata struct {
+	Mode    os.FileMode
+	UID     uint32
+	GID     uint32
+	ModTime time.Time
+}
+
+// ImageSink builds a file system image in a specific format, e.g. squashfs.
+// WriteImage adds the entries in depth-first order, a directory is always
+// added before the entries it contains. Paths are slash-separated and
+// relative to the root of the image.
+type ImageSink interface {
+	// AddDir adds a directory.
+	AddDir(path string, meta ImageMetadata) error
+
+	// AddFile adds a regular file with size bytes of content. The sink must
+	// read content up to EOF before returning.
+	AddFile(path string, meta ImageMetadata, size uint64, content io.Reader) error
+
+	// AddSymlink adds a symlink pointing to target.
+	AddSymlink(path string, meta ImageMetadata, target string) error
+
+	// AddHardlink adds a hardlink to the file at target, which was added
+	// before.
+	AddHardlink(path string, target string) error
+
+	// Close finishes the image.
+	Close() error
+}
+
+// WriteImage adds the nodes in tree and everything below them to sink. The
+// paths of the entries are the paths of the nodes below rootPath. Files which
+// share the same inode and device are added as hardlinks to the first file.
+// Nodes of other types, e.g. devices, are not added. The sink is not closed.
+func WriteImage(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, sink ImageSink) error {
+	hardlinks := make(map[hardlinkKey]string)
+
+	return writeDump(ctx, repo, tree, rootPath, func(ctx context.Context, node *restic.Node, path string) error {
+		return imageNode(ctx, sink, repo, node, strings.TrimPrefix(path, "/"), hardlinks)
+	})
+}
+
+func imageNode(ctx context.Context, sink ImageSink, repo restic.Repository, node *restic.Node, path string, hardlinks map[hardlinkKey]string) error {
+	meta := ImageMetadata{
+		Mode:    node.Mode,
+		UID:     node.UID,
+		GID:     node.GID,
+		ModTime: node.ModTime,
+	}
+
+	switch node.Type {
+	case "dir":
+		return errors.Wrapf(sink.AddDir(path, meta), "add dir %v", path)
+	case "symlink":
+		return errors.Wrapf(sink.AddSymlink(path, meta, node.LinkTarget), "add symlink %v", path)
+	case "file":
+		if node.Links > 1 {
+			key := hardlinkKey{inode: node.Inode, device: node.DeviceID}
+			if target, ok := hardlinks[key]; ok {
+				return errors.Wrapf(sink.AddHardlink(path, target), "add hardlink %v", path)
+			}
+			hardlinks[key] = path
+		}
+
+		return imageFile(ctx, sink, repo, node, path, meta)
+	default:
+		// other types like devices or sockets are not included
+		return nil
+	}
+}
+
+// imageFile adds the file node to sink, the content is loaded from the
+// repository while the sink reads it.
+func imageFile(ctx context.Context, sink ImageSink, repo restic.Repository, node *restic.Node, path string, meta ImageMetadata) error {
+	rd, wr := io.Pipe()
+
+	loadErr := make(chan error, 1)
+	go func() {
+		err := GetNodeData(ctx, wr, repo, node)
+		_ = wr.CloseWithError(err)
+		loadErr <- err
+	}()
+
+	err := sink.AddFile(path, meta, node.Size, rd)
+	// unblock the goroutine if the sink did not read the whole content
+	_ = rd.Close()
+	lerr := <-loadErr
+
+	if err != nil {
+		return errors.Wrapf(err, "add file %v", path)
+	}
+	if errors.Cause(lerr) == io.ErrClosedPipe {
+		return errors.Errorf("add file %v: content was not read completely", path)
+	}
+	return lerr
+}
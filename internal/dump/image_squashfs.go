@@ -0,0 +1,123 @@
+package dump
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// SquashfsCommand is the program which builds squashfs images. It reads a tar
+// archive from stdin and writes the image to the file passed as argument. It
+// is part of squashfs-tools since version 4.6.
+var SquashfsCommand = "sqfstar"
+
+// squashfsImageSink builds a squashfs image by piping a tar archive into
+// SquashfsCommand.
+type squashfsImageSink struct {
+	*tarImageSink
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// NewSquashfsImageSink starts SquashfsCommand to build a squashfs image in
+// filename. The output of the command is written to stderr.
+func NewSquashfsImageSink(filename string) (ImageSink, error) {
+	cmd := exec.Command(SquashfsCommand, filename)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdinPipe")
+	}
+
+	debug.Log("running %v", cmd.Args)
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to run %v", SquashfsCommand)
+	}
+
+	return &squashfsImageSink{
+		tarImageSink: newTarImageSink(stdin),
+		cmd:          cmd,
+		stdin:        stdin,
+	}, nil
+}
+
+// Close finishes the tar archive and waits for the command to build the image.
+func (s *squashfsImageSink) Close() error {
+	err := s.tarImageSink.Close()
+	if cerr := s.stdin.Close(); err == nil {
+		err = errors.Wrap(cerr, "Close")
+	}
+
+	werr := s.cmd.Wait()
+	if werr != nil {
+		return errors.Wrapf(werr, "%v failed", SquashfsCommand)
+	}
+	return err
+}
+
+// tarImageSink writes the entries of an image as a tar archive, e.g. for
+// image builders which read tar archives.
+type tarImageSink struct {
+	tw *tar.Writer
+}
+
+func newTarImageSink(wr io.Writer) *tarImageSink {
+	return &tarImageSink{tw: tar.NewWriter(wr)}
+}
+
+func (s *tarImageSink) header(path string, meta ImageMetadata) *tar.Header {
+	return &tar.Header{
+		Name:    path,
+		Mode:    tarMode(meta.Mode),
+		Uid:     int(meta.UID),
+		Gid:     int(meta.GID),
+		ModTime: meta.ModTime,
+	}
+}
+
+func (s *tarImageSink) AddDir(path string, meta ImageMetadata) error {
+	header := s.header(path+"/", meta)
+	header.Typeflag = tar.TypeDir
+	return errors.Wrap(s.tw.WriteHeader(header), "TarHeader")
+}
+
+func (s *tarImageSink) AddFile(path string, meta ImageMetadata, size uint64, content io.Reader) error {
+	header := s.header(path, meta)
+	header.Typeflag = tar.TypeReg
+	header.Size = int64(size)
+
+	err := s.tw.WriteHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	_, err = io.Copy(s.tw, content)
+	return errors.Wrap(err, "Copy")
+}
+
+func (s *tarImageSink) AddSymlink(path string, meta ImageMetadata, target string) error {
+	header := s.header(path, meta)
+	header.Typeflag = tar.TypeSymlink
+	header.Linkname = target
+	return errors.Wrap(s.tw.WriteHeader(header), "TarHeader")
+}
+
+func (s *tarImageSink) AddHardlink(path string, target string) error {
+	header := &tar.Header{
+		Name:     path,
+		Typeflag: tar.TypeLink,
+		Linkname: target,
+	}
+	return errors.Wrap(s.tw.WriteHeader(header), "TarHeader")
+}
+
+func (s *tarImageSink) Close() error {
+	return errors.Wrap(s.tw.Close(), "Close")
+}
@@ -0,0 +1,190 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeImageSink records the entries added to an image.
+type fakeImageSink struct {
+	entries  []string
+	modes    map[string]os.FileMode
+	contents map[string]string
+
+	// readBytes limits the number of bytes read from the content of files if
+	// it is not negative.
+	readBytes int64
+	// failFor makes the sink return an error for an entry.
+	failFor string
+}
+
+func newFakeImageSink() *fakeImageSink {
+	return &fakeImageSink{
+		modes:     make(map[string]os.FileMode),
+		contents:  make(map[string]string),
+		readBytes: -1,
+	}
+}
+
+func (s *fakeImageSink) add(entry, path string, meta ImageMetadata) error {
+	if path == s.failFor {
+		return errors.New("sink failed")
+	}
+	s.entries = append(s.entries, entry)
+	s.modes[path] = meta.Mode
+	return nil
+}
+
+func (s *fakeImageSink) AddDir(path string, meta ImageMetadata) error {
+	return s.add("dir "+path, path, meta)
+}
+
+func (s *fakeImageSink) AddFile(path string, meta ImageMetadata, size uint64, content io.Reader) error {
+	if s.readBytes >= 0 {
+		content = io.LimitReader(content, s.readBytes)
+	}
+
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	s.contents[path] = string(buf)
+
+	return s.add(fmt.Sprintf("file %v %d", path, size), path, meta)
+}
+
+func (s *fakeImageSink) AddSymlink(path string, meta ImageMetadata, target string) error {
+	return s.add(fmt.Sprintf("symlink %v -> %v", path, target), path, meta)
+}
+
+func (s *fakeImageSink) AddHardlink(path string, target string) error {
+	return s.add(fmt.Sprintf("hardlink %v -> %v", path, target), path, ImageMetadata{})
+}
+
+func (s *fakeImageSink) Close() error {
+	return nil
+}
+
+func TestWriteImage(t *testing.T) {
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: "foobar"},
+		"dir": archiver.TestDir{
+			"file": archiver.TestFile{Content: strings.Repeat("data", 100000)},
+			"subdir": archiver.TestDir{
+				"file":    archiver.TestFile{Content: "subdir file"},
+				"symlink": archiver.TestSymlink{Target: "../file"},
+			},
+			"emptydir": archiver.TestDir{},
+		},
+		"empty": archiver.TestFile{Content: ""},
+	})
+	defer cleanup()
+
+	rtest.OK(t, os.Chmod(filepath.Join(tempdir, "file"), 0751))
+
+	tree := snapshotTree(t, repo, tempdir)
+
+	sink := newFakeImageSink()
+	rtest.OK(t, WriteImage(context.TODO(), repo, tree, "/", sink))
+
+	rtest.Equals(t, []string{
+		"dir dir",
+		"dir dir/emptydir",
+		"file dir/file 400000",
+		"dir dir/subdir",
+		"file dir/subdir/file 11",
+		"symlink dir/subdir/symlink -> ../file",
+		"file empty 0",
+		"file file 6",
+	}, sink.entries)
+
+	rtest.Equals(t, "foobar", sink.contents["file"])
+	rtest.Equals(t, strings.Repeat("data", 100000), sink.contents["dir/file"])
+	rtest.Equals(t, "", sink.contents["empty"])
+
+	for path, mode := range sink.modes {
+		fi, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(path)))
+		rtest.OK(t, err)
+		rtest.Equals(t, fi.Mode(), mode)
+	}
+}
+
+func TestWriteImageHardlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hardlinks are not supported on Windows")
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: "hardlinked content"},
+		"dir":  archiver.TestDir{},
+	})
+	defer cleanup()
+
+	rtest.OK(t, os.Link(filepath.Join(tempdir, "file"), filepath.Join(tempdir, "dir", "link")))
+
+	tree := snapshotTree(t, repo, tempdir)
+
+	sink := newFakeImageSink()
+	rtest.OK(t, WriteImage(context.TODO(), repo, tree, "/", sink))
+
+	rtest.Equals(t, []string{
+		"dir dir",
+		"file dir/link 18",
+		"hardlink file -> dir/link",
+	}, sink.entries)
+}
+
+func TestWriteImageErrors(t *testing.T) {
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: strings.Repeat("data", 100000)},
+		"dir": archiver.TestDir{
+			"file": archiver.TestFile{Content: "foobar"},
+		},
+	})
+	defer cleanup()
+
+	tree := snapshotTree(t, repo, tempdir)
+
+	sink := newFakeImageSink()
+	sink.failFor = "dir/file"
+	err := WriteImage(context.TODO(), repo, tree, "/", sink)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "sink failed"), "unexpected error %v", err)
+	rtest.Equals(t, []string{"dir dir"}, sink.entries)
+
+	sink = newFakeImageSink()
+	sink.readBytes = 10
+	err = WriteImage(context.TODO(), repo, tree, "/", sink)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "not read completely"), "unexpected error %v", err)
+}
+
+func TestTarImageSink(t *testing.T) {
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: "foobar"},
+		"dir": archiver.TestDir{
+			"file":    archiver.TestFile{Content: "file in dir"},
+			"symlink": archiver.TestSymlink{Target: "../file"},
+		},
+	})
+	defer cleanup()
+
+	tree := snapshotTree(t, repo, tempdir)
+
+	buf := bytes.NewBuffer(nil)
+	sink := newTarImageSink(buf)
+	rtest.OK(t, WriteImage(context.TODO(), repo, tree, "/", sink))
+	rtest.OK(t, sink.Close())
+
+	checkTar(t, tempdir, "/", buf)
+}
@@ -0,0 +1,136 @@
+package dump
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// mode bits as defined by tar, see archive/tar
+const (
+	cISUID = 04000
+	cISGID = 02000
+	cISVTX = 01000
+)
+
+// hardlinkKey identifies a file by its inode and device.
+type hardlinkKey struct {
+	inode, device uint64
+}
+
+// WriteTar writes the nodes in tree and everything below them as a tar
+// archive to dst. The names of the entries are the paths of the nodes below
+// rootPath, without a leading slash. Files which share the same inode and
+// device are written as hardlinks to the first file.
+func WriteTar(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	tw := tar.NewWriter(dst)
+	hardlinks := make(map[hardlinkKey]string)
+
+	err := writeDump(ctx, repo, tree, rootPath, func(ctx context.Context, node *restic.Node, path string) error {
+		return tarNode(ctx, tw, repo, node, path, hardlinks)
+	})
+	if err != nil {
+		_ = tw.Close()
+		return err
+	}
+
+	return errors.Wrap(tw.Close(), "Close")
+}
+
+// tarMode converts the mode of a node to the mode stored in a tar header.
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= cISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= cISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= cISVTX
+	}
+	return m
+}
+
+func tarNode(ctx context.Context, tw *tar.Writer, repo restic.Repository, node *restic.Node, path string, hardlinks map[hardlinkKey]string) error {
+	header := &tar.Header{
+		Name:       strings.TrimPrefix(path, "/"),
+		Mode:       tarMode(node.Mode),
+		Uid:        int(node.UID),
+		Gid:        int(node.GID),
+		Uname:      node.User,
+		Gname:      node.Group,
+		ModTime:    node.ModTime,
+		AccessTime: node.AccessTime,
+		ChangeTime: node.ChangeTime,
+		PAXRecords: parseXattrs(node.ExtendedAttributes),
+	}
+
+	switch node.Type {
+	case "file":
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(node.Size)
+
+		if node.Links > 1 {
+			key := hardlinkKey{inode: node.Inode, device: node.DeviceID}
+			if target, ok := hardlinks[key]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = target
+				header.Size = 0
+			} else {
+				hardlinks[key] = header.Name
+			}
+		}
+	case "symlink":
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = node.LinkTarget
+	case "dir":
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	default:
+		// other types like devices or sockets are not included
+		return nil
+	}
+
+	err := tw.WriteHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	return GetNodeData(ctx, tw, repo, node)
+}
+
+func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
+	tmpMap := make(map[string]string)
+
+	for _, attr := range xattrs {
+		attrString := string(attr.Value)
+
+		if strings.HasPrefix(attr.Name, "system.posix_acl_") {
+			na := acl{}
+			na.decode(attr.Value)
+
+			if na.String() != "" {
+				if strings.Contains(attr.Name, "system.posix_acl_access") {
+					tmpMap["SCHILY.acl.access"] = na.String()
+				} else if strings.Contains(attr.Name, "system.posix_acl_default") {
+					tmpMap["SCHILY.acl.default"] = na.String()
+				}
+			}
+
+		} else {
+			tmpMap["SCHILY.xattr."+attr.Name] = attrString
+		}
+	}
+
+	return tmpMap
+}
@@ -0,0 +1,214 @@
+package dump
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// prepareTempdirRepoSrc creates the files in src in a temporary directory
+// and returns it together with a new repository.
+func prepareTempdirRepoSrc(t testing.TB, src archiver.TestDir) (tempdir string, repo restic.Repository, cleanup func()) {
+	tempdir, removeTempdir := rtest.TempDir(t)
+	repo, removeRepository := repository.TestRepository(t)
+
+	archiver.TestCreateFiles(t, tempdir, src)
+
+	cleanup = func() {
+		removeRepository()
+		removeTempdir()
+	}
+
+	return tempdir, repo, cleanup
+}
+
+// snapshotTree saves tempdir in a snapshot and returns the snapshot's tree.
+func snapshotTree(t testing.TB, repo restic.Repository, tempdir string) *restic.Tree {
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	sn := archiver.TestSnapshot(t, repo, ".", nil)
+
+	tree, err := repo.LoadTree(context.TODO(), *sn.Tree)
+	rtest.OK(t, err)
+	return tree
+}
+
+func TestWriteTar(t *testing.T) {
+	var tests = []struct {
+		name   string
+		args   archiver.TestDir
+		target string
+	}{
+		{
+			name: "single file",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+			},
+			target: "/",
+		},
+		{
+			name: "multiple files",
+			args: archiver.TestDir{
+				"file":  archiver.TestFile{Content: "foobar"},
+				"file2": archiver.TestFile{Content: "foobar2"},
+				"empty": archiver.TestFile{Content: ""},
+			},
+			target: "/",
+		},
+		{
+			name: "nested directories and symlinks",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+				"dir": archiver.TestDir{
+					"file": archiver.TestFile{Content: strings.Repeat("data", 1000)},
+					"subdir": archiver.TestDir{
+						"file":    archiver.TestFile{Content: "subdir file"},
+						"symlink": archiver.TestSymlink{Target: "../file"},
+					},
+					"emptydir": archiver.TestDir{},
+				},
+			},
+			target: "/",
+		},
+		{
+			name: "target prefix",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+				"dir": archiver.TestDir{
+					"file": archiver.TestFile{Content: "file in dir"},
+				},
+			},
+			target: "/some/prefix",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempdir, repo, cleanup := prepareTempdirRepoSrc(t, test.args)
+			defer cleanup()
+
+			tree := snapshotTree(t, repo, tempdir)
+
+			buf := bytes.NewBuffer(nil)
+			rtest.OK(t, WriteTar(context.TODO(), repo, tree, test.target, buf))
+
+			checkTar(t, tempdir, test.target, buf)
+		})
+	}
+}
+
+func TestWriteTarHardlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hardlinks are not supported on Windows")
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: "hardlinked content"},
+		"dir":  archiver.TestDir{},
+	})
+	defer cleanup()
+
+	rtest.OK(t, os.Link(filepath.Join(tempdir, "file"), filepath.Join(tempdir, "dir", "link")))
+
+	tree := snapshotTree(t, repo, tempdir)
+
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, WriteTar(context.TODO(), repo, tree, "/", buf))
+
+	links := make(map[string]string)
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+
+		if hdr.Typeflag == tar.TypeLink {
+			links[hdr.Name] = hdr.Linkname
+		}
+	}
+
+	rtest.Equals(t, map[string]string{"file": "dir/link"}, links)
+}
+
+// checkTar compares the entries in the tar archive with the files in srcdir.
+func checkTar(t *testing.T, srcdir string, target string, rd io.Reader) {
+	prefix := strings.TrimPrefix(target, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	seen := make(map[string]struct{})
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+
+		rtest.Assert(t, strings.HasPrefix(hdr.Name, prefix), "entry %v does not start with %v", hdr.Name, prefix)
+		name := filepath.Join(srcdir, filepath.FromSlash(strings.TrimPrefix(hdr.Name, prefix)))
+		seen[name] = struct{}{}
+
+		fi, err := os.Lstat(name)
+		rtest.OK(t, err)
+
+		rtest.Equals(t, int64(fi.Mode().Perm()), hdr.Mode&0777)
+		// tar stores the modification time with a resolution of one second
+		if d := fi.ModTime().Sub(hdr.ModTime); !fi.IsDir() && (d > time.Second || d < -time.Second) {
+			t.Errorf("%v: wrong modification time, want %v, got %v", hdr.Name, fi.ModTime(), hdr.ModTime)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			rtest.Assert(t, fi.IsDir(), "%v is not a directory", name)
+			rtest.Assert(t, strings.HasSuffix(hdr.Name, "/"), "directory %v does not end with a slash", hdr.Name)
+		case tar.TypeSymlink:
+			target, err := fs.Readlink(name)
+			rtest.OK(t, err)
+			rtest.Equals(t, target, hdr.Linkname)
+		case tar.TypeReg:
+			rtest.Equals(t, fi.Size(), hdr.Size)
+
+			want, err := ioutil.ReadFile(name)
+			rtest.OK(t, err)
+			got, err := ioutil.ReadAll(tr)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(want, got), "%v: wrong content", hdr.Name)
+		default:
+			t.Errorf("%v: unexpected type %v", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	// all files in srcdir must be contained in the archive
+	err := filepath.Walk(srcdir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == srcdir {
+			return nil
+		}
+		if _, ok := seen[name]; !ok {
+			t.Errorf("%v not found in tar archive", name)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+}
@@ -0,0 +1,71 @@
+package dump
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// WriteZip writes the nodes in tree and everything below them as a zip
+// archive to dst. The names of the entries are the paths of the nodes below
+// rootPath, without a leading slash. The archive is written sequentially
+// using data descriptors, so dst does not need to be seekable.
+//
+// The modification time and the mode of each node are stored in the
+// archive, symlinks are stored as entries containing the link target.
+func WriteZip(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	zw := zip.NewWriter(dst)
+
+	err := writeDump(ctx, repo, tree, rootPath, func(ctx context.Context, node *restic.Node, path string) error {
+		return zipNode(ctx, zw, repo, node, path)
+	})
+	if err != nil {
+		_ = zw.Close()
+		return err
+	}
+
+	return errors.Wrap(zw.Close(), "Close")
+}
+
+func zipNode(ctx context.Context, zw *zip.Writer, repo restic.Repository, node *restic.Node, path string) error {
+	header := &zip.FileHeader{
+		Name:     strings.TrimPrefix(path, "/"),
+		Method:   zip.Deflate,
+		Modified: node.ModTime,
+	}
+
+	switch node.Type {
+	case "file":
+		header.SetMode(node.Mode)
+	case "symlink":
+		header.SetMode(os.ModeSymlink | node.Mode.Perm())
+		header.Method = zip.Store
+	case "dir":
+		header.SetMode(os.ModeDir | node.Mode.Perm())
+		header.Method = zip.Store
+		header.Name += "/"
+	default:
+		// other types like devices or sockets are not included
+		return nil
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "ZipHeader")
+	}
+
+	switch node.Type {
+	case "file":
+		return GetNodeData(ctx, w, repo, node)
+	case "symlink":
+		_, err = w.Write([]byte(node.LinkTarget))
+		return errors.Wrap(err, "Write")
+	}
+
+	return nil
+}
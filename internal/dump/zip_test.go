@@ -0,0 +1,140 @@
+package dump
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestWriteZip(t *testing.T) {
+	var tests = []struct {
+		name   string
+		args   archiver.TestDir
+		target string
+	}{
+		{
+			name: "single file",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+			},
+			target: "/",
+		},
+		{
+			name: "empty files and dirs",
+			args: archiver.TestDir{
+				"empty":    archiver.TestFile{Content: ""},
+				"emptydir": archiver.TestDir{},
+			},
+			target: "/",
+		},
+		{
+			name: "nested directories and symlinks",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+				"dir": archiver.TestDir{
+					"file": archiver.TestFile{Content: strings.Repeat("data", 1000)},
+					"subdir": archiver.TestDir{
+						"file":    archiver.TestFile{Content: "subdir file"},
+						"symlink": archiver.TestSymlink{Target: "../file"},
+					},
+				},
+			},
+			target: "/",
+		},
+		{
+			name: "target prefix",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: "foobar"},
+			},
+			target: "/some/prefix",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempdir, repo, cleanup := prepareTempdirRepoSrc(t, test.args)
+			defer cleanup()
+
+			tree := snapshotTree(t, repo, tempdir)
+
+			// bytes.Buffer is not seekable, like stdout
+			buf := bytes.NewBuffer(nil)
+			rtest.OK(t, WriteZip(context.TODO(), repo, tree, test.target, buf))
+
+			checkZip(t, tempdir, test.target, buf.Bytes())
+		})
+	}
+}
+
+// checkZip compares the entries in the zip archive with the files in srcdir.
+func checkZip(t *testing.T, srcdir string, target string, data []byte) {
+	prefix := strings.TrimPrefix(target, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	rtest.OK(t, err)
+
+	seen := make(map[string]struct{})
+	for _, f := range zr.File {
+		rtest.Assert(t, strings.HasPrefix(f.Name, prefix), "entry %v does not start with %v", f.Name, prefix)
+		name := filepath.Join(srcdir, filepath.FromSlash(strings.TrimPrefix(f.Name, prefix)))
+		seen[name] = struct{}{}
+
+		fi, err := os.Lstat(name)
+		rtest.OK(t, err)
+
+		rtest.Equals(t, fi.Mode().Perm(), f.Mode().Perm())
+		if d := fi.ModTime().Sub(f.Modified); !fi.IsDir() && (d > time.Second || d < -time.Second) {
+			t.Errorf("%v: wrong modification time, want %v, got %v", f.Name, fi.ModTime(), f.Modified)
+		}
+
+		rd, err := f.Open()
+		rtest.OK(t, err)
+		content, err := ioutil.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+
+		switch {
+		case f.Mode()&os.ModeDir != 0:
+			rtest.Assert(t, fi.IsDir(), "%v is not a directory", name)
+			rtest.Assert(t, strings.HasSuffix(f.Name, "/"), "directory %v does not end with a slash", f.Name)
+		case f.Mode()&os.ModeSymlink != 0:
+			target, err := fs.Readlink(name)
+			rtest.OK(t, err)
+			rtest.Equals(t, target, string(content))
+		case f.Mode().IsRegular():
+			want, err := ioutil.ReadFile(name)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(want, content), "%v: wrong content", f.Name)
+		default:
+			t.Errorf("%v: unexpected mode %v", f.Name, f.Mode())
+		}
+	}
+
+	// all files in srcdir must be contained in the archive
+	err = filepath.Walk(srcdir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == srcdir {
+			return nil
+		}
+		if _, ok := seen[name]; !ok {
+			t.Errorf("%v not found in zip archive", name)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+}
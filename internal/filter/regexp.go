@@ -0,0 +1,41 @@
+package filter
+
+import (
+	"path/filepath"
+	"regexp"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// CompileRegexps compiles the regular expressions in patterns. If insensitive
+// is true, the expressions ignore the case of the paths. The expressions are
+// not anchored, use '^' and '$' to match the beginning or end of a path.
+func CompileRegexps(patterns []string, insensitive bool) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := pattern
+		if insensitive {
+			expr = "(?i)" + expr
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regular expression %q", pattern)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// MatchRegexps returns true if str matches one of the regular expressions.
+// The file path separators in str are converted to '/' before matching, so
+// the same expressions can be used on all platforms.
+func MatchRegexps(res []*regexp.Regexp, str string) bool {
+	str = filepath.ToSlash(str)
+	for _, re := range res {
+		if re.MatchString(str) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/filter"
+)
+
+var regexpTests = []struct {
+	patterns    []string
+	insensitive bool
+	path        string
+	match       bool
+}{
+	{[]string{`\.go$`}, false, "/foo/bar/test.go", true},
+	{[]string{`\.go$`}, false, "/foo/bar/test.go.orig", false},
+	{[]string{`\.GO$`}, false, "/foo/bar/test.go", false},
+	{[]string{`\.GO$`}, true, "/foo/bar/test.go", true},
+	{[]string{`bar`}, false, "/foo/bar/test.go", true},
+	{[]string{`^bar`}, false, "/foo/bar/test.go", false},
+	{[]string{`^/foo/`}, false, "/foo/bar/test.go", true},
+	{[]string{`^/foo$`}, false, "/foo/bar/test.go", false},
+	{[]string{`/[0-9]{4}-[0-9]{2}/`}, false, "/backup/2020-01/data", true},
+	{[]string{`/[0-9]{4}-[0-9]{2}/`}, false, "/backup/2020-1/data", false},
+	{[]string{`\.c$`, `\.h$`}, false, "/src/main.h", true},
+	{[]string{`\.c$`, `\.h$`}, false, "/src/main.go", false},
+	{nil, false, "/src/main.go", false},
+}
+
+func TestMatchRegexps(t *testing.T) {
+	for _, test := range regexpTests {
+		res, err := filter.CompileRegexps(test.patterns, test.insensitive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m := filter.MatchRegexps(res, test.path); m != test.match {
+			t.Errorf("MatchRegexps(%q, %v, %q): want %v, got %v",
+				test.patterns, test.insensitive, test.path, test.match, m)
+		}
+	}
+}
+
+func TestCompileRegexpsInvalid(t *testing.T) {
+	_, err := filter.CompileRegexps([]string{`\.go$`, `foo(`}, false)
+	if err == nil {
+		t.Fatal("no error for invalid regular expression")
+	}
+}
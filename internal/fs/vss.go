@@ -0,0 +1,172 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// VSSSnapshot is a shadow copy of a volume created by the Volume Shadow Copy
+// Service on Windows.
+type VSSSnapshot struct {
+	// ID identifies the shadow copy.
+	ID string
+
+	// Volume is the volume the shadow copy was created for, e.g. `C:\`.
+	Volume string
+
+	// DeviceObject is the path of the shadow copy, e.g.
+	// `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1`.
+	DeviceObject string
+}
+
+// VSSProvider creates and deletes shadow copies of volumes.
+type VSSProvider interface {
+	CreateSnapshot(volume string) (VSSSnapshot, error)
+	DeleteSnapshot(snapshot VSSSnapshot) error
+}
+
+// LocalVSS is the local file system, but files are read from shadow copies
+// of their volumes. A shadow copy is created for each volume when the first
+// file on it is accessed. Only the paths of opened files are mapped to the
+// shadow copies, so the names of files are not changed. If no shadow copy can
+// be created for a volume, the files are read from the volume itself.
+type LocalVSS struct {
+	FS
+
+	provider VSSProvider
+	msgError func(volume string, err error)
+
+	m         sync.Mutex
+	snapshots map[string]VSSSnapshot
+	failed    map[string]struct{}
+}
+
+// statically ensure that LocalVSS implements FS.
+var _ FS = &LocalVSS{}
+
+// NewLocalVSS returns a file system which reads files from shadow copies
+// created by provider. msgError is called when no shadow copy can be created
+// for a volume.
+func NewLocalVSS(provider VSSProvider, msgError func(volume string, err error)) *LocalVSS {
+	return &LocalVSS{
+		FS:        Local{},
+		provider:  provider,
+		msgError:  msgError,
+		snapshots: make(map[string]VSSSnapshot),
+		failed:    make(map[string]struct{}),
+	}
+}
+
+// DeleteSnapshots deletes all shadow copies which were created. It can be
+// called several times, shadow copies are only deleted once.
+func (fs *LocalVSS) DeleteSnapshots() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	var firstErr error
+	for volume, snapshot := range fs.snapshots {
+		debug.Log("deleting shadow copy %v of %v", snapshot.ID, volume)
+		if err := fs.provider.DeleteSnapshot(snapshot); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "unable to delete shadow copy of %v", volume)
+		}
+		delete(fs.snapshots, volume)
+	}
+
+	return firstErr
+}
+
+// Open opens a file for reading.
+func (fs *LocalVSS) Open(name string) (File, error) {
+	return fs.FS.Open(fs.snapshotPath(name))
+}
+
+// OpenFile opens a file, files can only be read from shadow copies.
+func (fs *LocalVSS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.FS.OpenFile(fs.snapshotPath(name), flag, perm)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (fs *LocalVSS) Stat(name string) (os.FileInfo, error) {
+	return fs.FS.Stat(fs.snapshotPath(name))
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+func (fs *LocalVSS) Lstat(name string) (os.FileInfo, error) {
+	return fs.FS.Lstat(fs.snapshotPath(name))
+}
+
+// snapshotPath returns the path of name within the shadow copy of its volume.
+// If there is no shadow copy for the volume, name is returned unchanged.
+func (fs *LocalVSS) snapshotPath(name string) string {
+	volume, rest, ok := splitVolume(fixpath(name))
+	if !ok {
+		return name
+	}
+
+	snapshot, ok := fs.snapshot(volume)
+	if !ok {
+		return name
+	}
+
+	return joinSnapshotPath(snapshot.DeviceObject, rest)
+}
+
+// snapshot returns the shadow copy for volume, it is created if it does not
+// exist yet.
+func (fs *LocalVSS) snapshot(volume string) (VSSSnapshot, bool) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if snapshot, ok := fs.snapshots[volume]; ok {
+		return snapshot, true
+	}
+	if _, ok := fs.failed[volume]; ok {
+		return VSSSnapshot{}, false
+	}
+
+	debug.Log("creating shadow copy of %v", volume)
+	snapshot, err := fs.provider.CreateSnapshot(volume)
+	if err != nil {
+		debug.Log("creating shadow copy of %v failed: %v", volume, err)
+		fs.failed[volume] = struct{}{}
+		if fs.msgError != nil {
+			fs.msgError(volume, err)
+		}
+		return VSSSnapshot{}, false
+	}
+
+	debug.Log("created shadow copy %v of %v at %v", snapshot.ID, volume, snapshot.DeviceObject)
+	fs.snapshots[volume] = snapshot
+	return snapshot, true
+}
+
+// splitVolume splits an absolute Windows path with a drive letter into the
+// volume (e.g. `C:\`) and the rest of the path. A leading `\\?\` is removed.
+// For other paths, e.g. on network shares, ok is false.
+func splitVolume(path string) (volume, rest string, ok bool) {
+	path = strings.TrimPrefix(path, `\\?\`)
+	if len(path) < 3 || path[1] != ':' || (path[2] != '\\' && path[2] != '/') {
+		return "", "", false
+	}
+
+	letter := path[0]
+	if !('a' <= letter && letter <= 'z') && !('A' <= letter && letter <= 'Z') {
+		return "", "", false
+	}
+
+	return strings.ToUpper(path[:2]) + `\`, strings.TrimLeft(path[2:], `\/`), true
+}
+
+// joinSnapshotPath returns the path of rest within the shadow copy at
+// deviceObject.
+func joinSnapshotPath(deviceObject, rest string) string {
+	deviceObject = strings.TrimRight(deviceObject, `\`)
+	if rest == "" {
+		return deviceObject + `\`
+	}
+	return deviceObject + `\` + strings.Replace(rest, "/", `\`, -1)
+}
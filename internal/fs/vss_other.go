@@ -0,0 +1,25 @@
+// +build !windows
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// unsupportedVSSProvider is used on platforms without the Volume Shadow Copy
+// Service.
+type unsupportedVSSProvider struct{}
+
+// NewVSSProvider returns a VSSProvider which fails to create shadow copies,
+// they are only supported on Windows.
+func NewVSSProvider() VSSProvider {
+	return unsupportedVSSProvider{}
+}
+
+// CreateSnapshot returns an error.
+func (p unsupportedVSSProvider) CreateSnapshot(volume string) (VSSSnapshot, error) {
+	return VSSSnapshot{}, errors.New("shadow copies are only supported on Windows")
+}
+
+// DeleteSnapshot returns an error.
+func (p unsupportedVSSProvider) DeleteSnapshot(snapshot VSSSnapshot) error {
+	return errors.New("shadow copies are only supported on Windows")
+}
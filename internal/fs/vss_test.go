@@ -0,0 +1,118 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// mockVSSProvider records the shadow copies it creates and deletes.
+type mockVSSProvider struct {
+	created map[string]int
+	deleted []VSSSnapshot
+	fail    map[string]bool
+}
+
+func newMockVSSProvider() *mockVSSProvider {
+	return &mockVSSProvider{
+		created: make(map[string]int),
+		fail:    make(map[string]bool),
+	}
+}
+
+func (p *mockVSSProvider) CreateSnapshot(volume string) (VSSSnapshot, error) {
+	if p.fail[volume] {
+		return VSSSnapshot{}, errors.New("access denied")
+	}
+
+	p.created[volume]++
+	n := len(p.created)
+	return VSSSnapshot{
+		ID:           fmt.Sprintf("{%08d-0000-0000-0000-000000000000}", n),
+		Volume:       volume,
+		DeviceObject: fmt.Sprintf(`\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy%d`, n),
+	}, nil
+}
+
+func (p *mockVSSProvider) DeleteSnapshot(snapshot VSSSnapshot) error {
+	p.deleted = append(p.deleted, snapshot)
+	return nil
+}
+
+func TestSplitVolume(t *testing.T) {
+	var tests = []struct {
+		path, volume, rest string
+		ok                 bool
+	}{
+		{`C:\Users\foo\file.txt`, `C:\`, `Users\foo\file.txt`, true},
+		{`c:\Users`, `C:\`, `Users`, true},
+		{`\\?\D:\data\db.mdf`, `D:\`, `data\db.mdf`, true},
+		{`C:\`, `C:\`, ``, true},
+		{`C:/Users/foo`, `C:\`, `Users/foo`, true},
+		{`C:relative`, ``, ``, false},
+		{`\\server\share\file`, ``, ``, false},
+		{`\\?\UNC\server\share\file`, ``, ``, false},
+		{`/home/user`, ``, ``, false},
+		{`relative\path`, ``, ``, false},
+		{`1:\foo`, ``, ``, false},
+	}
+
+	for _, test := range tests {
+		volume, rest, ok := splitVolume(test.path)
+		if volume != test.volume || rest != test.rest || ok != test.ok {
+			t.Errorf("splitVolume(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				test.path, volume, rest, ok, test.volume, test.rest, test.ok)
+		}
+	}
+}
+
+func TestLocalVSSSnapshotPath(t *testing.T) {
+	provider := newMockVSSProvider()
+	provider.fail[`E:\`] = true
+
+	var failed []string
+	fs := NewLocalVSS(provider, func(volume string, err error) {
+		failed = append(failed, volume)
+	})
+
+	var tests = []struct {
+		path, mapped string
+	}{
+		{`C:\Users\foo\file.txt`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Users\foo\file.txt`},
+		{`c:\Windows`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Windows`},
+		{`\\?\D:\data\db.mdf`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy2\data\db.mdf`},
+		{`D:\`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy2\`},
+		{`C:/Users/foo`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Users\foo`},
+		// no shadow copy could be created, the path is not changed
+		{`E:\file`, `E:\file`},
+		{`E:\other`, `E:\other`},
+		// network shares are not mapped
+		{`\\server\share\file`, `\\server\share\file`},
+	}
+
+	for _, test := range tests {
+		if mapped := fs.snapshotPath(test.path); mapped != test.mapped {
+			t.Errorf("snapshotPath(%q) = %q, want %q", test.path, mapped, test.mapped)
+		}
+	}
+
+	// a single shadow copy is created for each volume
+	if len(provider.created) != 2 || provider.created[`C:\`] != 1 || provider.created[`D:\`] != 1 {
+		t.Errorf("wrong shadow copies created: %v", provider.created)
+	}
+
+	// the error is reported once
+	if len(failed) != 1 || failed[0] != `E:\` {
+		t.Errorf("wrong errors reported for volumes: %v", failed)
+	}
+
+	// the shadow copies are deleted only once
+	for i := 0; i < 2; i++ {
+		if err := fs.DeleteSnapshots(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(provider.deleted) != 2 {
+		t.Errorf("wrong number of shadow copies deleted: %v", provider.deleted)
+	}
+}
@@ -0,0 +1,82 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// shadowCopyID matches the IDs of shadow copies, which are GUIDs.
+var shadowCopyID = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}$`)
+
+// wmiVSSProvider manages shadow copies via the Win32_ShadowCopy WMI class,
+// which is accessed through PowerShell. Creating shadow copies requires
+// administrator privileges.
+type wmiVSSProvider struct{}
+
+// NewVSSProvider returns a VSSProvider which uses the Volume Shadow Copy
+// Service of Windows.
+func NewVSSProvider() VSSProvider {
+	return wmiVSSProvider{}
+}
+
+// runPowerShell runs the script and returns the lines written to stdout.
+func runPowerShell(script string) ([]string, error) {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Errorf("powershell: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// CreateSnapshot creates a shadow copy of volume, e.g. `C:\`.
+func (p wmiVSSProvider) CreateSnapshot(volume string) (VSSSnapshot, error) {
+	if _, rest, ok := splitVolume(volume); !ok || rest != "" {
+		return VSSSnapshot{}, errors.Errorf("invalid volume %q", volume)
+	}
+
+	script := fmt.Sprintf(`$ErrorActionPreference = "Stop"
+$r = (Get-WmiObject -List Win32_ShadowCopy).Create('%s', 'ClientAccessible')
+if ($r.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create returned $($r.ReturnValue)" }
+$s = Get-WmiObject Win32_ShadowCopy | Where-Object { $_.ID -eq $r.ShadowID }
+Write-Output $s.ID
+Write-Output $s.DeviceObject`, volume)
+
+	lines, err := runPowerShell(script)
+	if err != nil {
+		return VSSSnapshot{}, err
+	}
+	if len(lines) != 2 || !shadowCopyID.MatchString(lines[0]) {
+		return VSSSnapshot{}, errors.Errorf("unexpected output creating shadow copy: %q", lines)
+	}
+
+	return VSSSnapshot{ID: lines[0], Volume: volume, DeviceObject: lines[1]}, nil
+}
+
+// DeleteSnapshot deletes the shadow copy.
+func (p wmiVSSProvider) DeleteSnapshot(snapshot VSSSnapshot) error {
+	if !shadowCopyID.MatchString(snapshot.ID) {
+		return errors.Errorf("invalid shadow copy ID %q", snapshot.ID)
+	}
+
+	script := fmt.Sprintf(`$ErrorActionPreference = "Stop"
+Get-WmiObject Win32_ShadowCopy | Where-Object { $_.ID -eq '%s' } | ForEach-Object { $_.Delete() }`, snapshot.ID)
+
+	_, err := runPowerShell(script)
+	return err
+}
@@ -22,6 +22,8 @@ const blockSize = 512
 // Statically ensure that *file implements the given interface
 var _ = fs.HandleReader(&file{})
 var _ = fs.HandleReleaser(&file{})
+var _ = fs.NodeListxattrer(&file{})
+var _ = fs.NodeGetxattrer(&file{})
 
 type file struct {
 	root  *Root
@@ -166,19 +168,9 @@ func (f *file) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
 }
 
 func (f *file) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
-	debug.Log("Listxattr(%v, %v)", f.node.Name, req.Size)
-	for _, attr := range f.node.ExtendedAttributes {
-		resp.Append(attr.Name)
-	}
-	return nil
+	return nodeListxattr(f.node, req, resp)
 }
 
 func (f *file) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
-	debug.Log("Getxattr(%v, %v, %v)", f.node.Name, req.Name, req.Size)
-	attrval := f.node.GetExtendedAttribute(req.Name)
-	if attrval != nil {
-		resp.Xattr = attrval
-		return nil
-	}
-	return fuse.ErrNoXattr
+	return nodeGetxattr(f.node, req, resp)
 }
@@ -14,6 +14,8 @@ import (
 
 // Statically ensure that *link implements the given interface
 var _ = fs.NodeReadlinker(&link{})
+var _ = fs.NodeListxattrer(&link{})
+var _ = fs.NodeGetxattrer(&link{})
 
 type link struct {
 	root  *Root
@@ -45,3 +47,11 @@ func (l *link) Attr(ctx context.Context, a *fuse.Attr) error {
 
 	return nil
 }
+
+func (l *link) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return nodeListxattr(l.node, req, resp)
+}
+
+func (l *link) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return nodeGetxattr(l.node, req, resp)
+}
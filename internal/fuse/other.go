@@ -7,10 +7,15 @@ package fuse
 
 import (
 	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/net/context"
 )
 
+// Statically ensure that *other implements the given interface
+var _ = fs.NodeListxattrer(&other{})
+var _ = fs.NodeGetxattrer(&other{})
+
 type other struct {
 	root  *Root
 	node  *restic.Node
@@ -41,3 +46,11 @@ func (l *other) Attr(ctx context.Context, a *fuse.Attr) error {
 
 	return nil
 }
+
+func (l *other) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return nodeListxattr(l.node, req, resp)
+}
+
+func (l *other) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return nodeGetxattr(l.node, req, resp)
+}
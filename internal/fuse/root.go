@@ -36,6 +36,13 @@ type Root struct {
 	snCount   int
 	lastCheck time.Time
 
+	// template is set if the snapshots are arranged in a directory
+	// hierarchy, timeTemplate is used to name snapshots by their timestamp
+	template        *snapshotTemplate
+	templateTree    *templateEntry
+	templateSnCount int
+	timeTemplate    string
+
 	*MetaDir
 }
 
@@ -54,10 +61,24 @@ func NewRoot(ctx context.Context, repo restic.Repository, cfg Config) (*Root, er
 		inode:         rootInode,
 		cfg:           cfg,
 		blobSizeCache: NewBlobSizeCache(ctx, repo.Index()),
+		timeTemplate:  cfg.SnapshotTemplate,
+	}
+
+	var snapshotsDir fs.Node
+	if IsPathTemplate(cfg.SnapshotTemplate) {
+		tmpl, err := parseSnapshotTemplate(cfg.SnapshotTemplate)
+		if err != nil {
+			return nil, err
+		}
+		root.template = &tmpl
+		root.timeTemplate = tmpl.timeLayout()
+		snapshotsDir = NewTemplateDir(root, fs.GenerateDynamicInode(root.inode, "snapshots"), nil)
+	} else {
+		snapshotsDir = NewSnapshotsDir(root, fs.GenerateDynamicInode(root.inode, "snapshots"), "", "")
 	}
 
 	entries := map[string]fs.Node{
-		"snapshots": NewSnapshotsDir(root, fs.GenerateDynamicInode(root.inode, "snapshots"), "", ""),
+		"snapshots": snapshotsDir,
 		"tags":      NewTagsDir(root, fs.GenerateDynamicInode(root.inode, "tags")),
 		"hosts":     NewHostsDir(root, fs.GenerateDynamicInode(root.inode, "hosts")),
 		"ids":       NewSnapshotsIDSDir(root, fs.GenerateDynamicInode(root.inode, "ids")),
@@ -122,7 +122,7 @@ func NewSnapshotsDir(root *Root, inode uint64, tag string, host string) *Snapsho
 		latest:   "",
 		tag:      tag,
 		host:     host,
-		template: root.cfg.SnapshotTemplate,
+		template: root.timeTemplate,
 	}
 
 	return d
@@ -260,7 +260,7 @@ func updateSnapshotNames(d *SnapshotsDir, template string) {
 			if d.tag == "" || isElem(d.tag, sn.Tags) {
 				if d.host == "" || d.host == sn.Hostname {
 					name := sn.Time.Format(template)
-					if d.latest == "" || !sn.Time.Before(latestTime) {
+					if !sn.Checkpoint && (d.latest == "" || !sn.Time.Before(latestTime)) {
 						latestTime = sn.Time
 						d.latest = name
 					}
@@ -287,7 +287,7 @@ func (d *SnapshotsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	updateSnapshots(ctx, d.root)
 
 	// update snapshot names
-	updateSnapshotNames(d, d.root.cfg.SnapshotTemplate)
+	updateSnapshotNames(d, d.template)
 
 	items := []fuse.Dirent{
 		{
@@ -461,7 +461,7 @@ func (d *SnapshotsDir) Lookup(ctx context.Context, name string) (fs.Node, error)
 		updateSnapshots(ctx, d.root)
 
 		// update snapshot names
-		updateSnapshotNames(d, d.root.cfg.SnapshotTemplate)
+		updateSnapshotNames(d, d.template)
 
 		sn, ok := d.names[name]
 		if ok {
@@ -0,0 +1,212 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// IsPathTemplate returns true if the snapshot template s describes a directory
+// hierarchy (e.g. "{host}/{tags}/{time}") instead of a format for timestamps.
+func IsPathTemplate(s string) bool {
+	return strings.Contains(s, "{")
+}
+
+// templatePart is either a literal string or a placeholder within a path
+// component of a snapshot template.
+type templatePart struct {
+	literal     string
+	placeholder string
+	layout      string
+}
+
+// snapshotTemplate arranges snapshots in a directory hierarchy, each path
+// component is built from the parts of one element of components.
+type snapshotTemplate struct {
+	components [][]templatePart
+}
+
+// parseSnapshotTemplate parses a path template such as "{host}/{tags}/{time}".
+// The placeholders {id}, {host}, {tags} and {time} are supported, the format
+// for the timestamp can be set with {time:layout}, e.g.
+// {time:2006-01-02_15-04-05}.
+func parseSnapshotTemplate(s string) (snapshotTemplate, error) {
+	var tmpl snapshotTemplate
+
+	for _, component := range strings.Split(s, "/") {
+		if component == "" {
+			return snapshotTemplate{}, errors.Errorf("snapshot template %q contains an empty path component", s)
+		}
+
+		var parts []templatePart
+		for component != "" {
+			start := strings.Index(component, "{")
+			if start < 0 {
+				parts = append(parts, templatePart{literal: component})
+				break
+			}
+
+			if start > 0 {
+				parts = append(parts, templatePart{literal: component[:start]})
+			}
+
+			end := strings.Index(component[start:], "}")
+			if end < 0 {
+				return snapshotTemplate{}, errors.Errorf("snapshot template %q contains an unterminated placeholder", s)
+			}
+			end += start
+
+			part, err := parsePlaceholder(component[start+1 : end])
+			if err != nil {
+				return snapshotTemplate{}, err
+			}
+			parts = append(parts, part)
+			component = component[end+1:]
+		}
+
+		tmpl.components = append(tmpl.components, parts)
+	}
+
+	return tmpl, nil
+}
+
+func parsePlaceholder(s string) (templatePart, error) {
+	name, layout := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, layout = s[:i], s[i+1:]
+	}
+
+	switch name {
+	case "id", "host", "tags":
+		if layout != "" {
+			return templatePart{}, errors.Errorf("placeholder {%s} does not accept a format", name)
+		}
+	case "time":
+		if layout == "" {
+			layout = time.RFC3339
+		}
+	default:
+		return templatePart{}, errors.Errorf("unknown placeholder {%s} in snapshot template", s)
+	}
+
+	return templatePart{placeholder: name, layout: layout}, nil
+}
+
+// timeLayout returns the format of the first {time} placeholder in the
+// template, or RFC3339 if there is none.
+func (t snapshotTemplate) timeLayout() string {
+	for _, parts := range t.components {
+		for _, part := range parts {
+			if part.placeholder == "time" {
+				return part.layout
+			}
+		}
+	}
+	return time.RFC3339
+}
+
+// expand returns the path components for the snapshot sn. Slashes within the
+// values are replaced by underscores, and empty components are represented by
+// a single underscore.
+func (t snapshotTemplate) expand(sn *restic.Snapshot) []string {
+	path := make([]string, 0, len(t.components))
+	for _, parts := range t.components {
+		var name string
+		for _, part := range parts {
+			switch part.placeholder {
+			case "id":
+				name += sn.ID().Str()
+			case "host":
+				name += sn.Hostname
+			case "tags":
+				name += strings.Join(sn.Tags, ",")
+			case "time":
+				name += sn.Time.Format(part.layout)
+			default:
+				name += part.literal
+			}
+		}
+
+		name = strings.Replace(name, "/", "_", -1)
+		if name == "" || name == "." || name == ".." {
+			name = "_"
+		}
+		path = append(path, name)
+	}
+
+	return path
+}
+
+// templateEntry is a directory in the hierarchy built from a snapshot
+// template, it either contains further directories or is a snapshot.
+type templateEntry struct {
+	snapshot *restic.Snapshot
+	entries  map[string]*templateEntry
+}
+
+// buildTemplateTree arranges snapshots according to tmpl. When several
+// snapshots map to the same path, a suffix is added to the names of all but the
+// oldest one.
+func buildTemplateTree(snapshots restic.Snapshots, tmpl snapshotTemplate) *templateEntry {
+	sorted := make(restic.Snapshots, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Time.Equal(sorted[j].Time) {
+			return sorted[i].Time.Before(sorted[j].Time)
+		}
+		return sorted[i].ID().Str() < sorted[j].ID().Str()
+	})
+
+	root := &templateEntry{entries: make(map[string]*templateEntry)}
+	for _, sn := range sorted {
+		path := tmpl.expand(sn)
+
+		dir := root
+		for _, name := range path[:len(path)-1] {
+			entry, ok := dir.entries[name]
+			if !ok {
+				entry = &templateEntry{entries: make(map[string]*templateEntry)}
+				dir.entries[name] = entry
+			}
+			dir = entry
+		}
+
+		base := path[len(path)-1]
+		name := base
+		for i := 1; ; i++ {
+			if _, ok := dir.entries[name]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		dir.entries[name] = &templateEntry{snapshot: sn}
+	}
+
+	return root
+}
+
+// lookup returns the entry for path below e.
+func (e *templateEntry) lookup(path []string) (*templateEntry, bool) {
+	for _, name := range path {
+		if e.snapshot != nil {
+			return nil, false
+		}
+
+		entry, ok := e.entries[name]
+		if !ok {
+			return nil, false
+		}
+		e = entry
+	}
+
+	return e, true
+}
@@ -0,0 +1,134 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// TemplateDir is a fuse directory within the hierarchy built from the
+// snapshot template.
+type TemplateDir struct {
+	inode uint64
+	root  *Root
+	path  []string
+}
+
+// ensure that *TemplateDir implements these interfaces
+var _ = fs.HandleReadDirAller(&TemplateDir{})
+var _ = fs.NodeStringLookuper(&TemplateDir{})
+
+// NewTemplateDir returns a new directory for path within the hierarchy built
+// from the snapshot template.
+func NewTemplateDir(root *Root, inode uint64, path []string) *TemplateDir {
+	debug.Log("create template dir %v, inode %d", path, inode)
+	return &TemplateDir{
+		root:  root,
+		inode: inode,
+		path:  path,
+	}
+}
+
+// update the directory hierarchy from the current repository-state.
+func updateTemplateTree(root *Root) {
+	if root.templateTree == nil || root.templateSnCount != root.snCount {
+		root.templateSnCount = root.snCount
+		root.templateTree = buildTemplateTree(root.snapshots, *root.template)
+	}
+}
+
+// entry returns the entry for the directory, it is nil if the directory does
+// not exist anymore.
+func (d *TemplateDir) entry(ctx context.Context) *templateEntry {
+	// update snapshots
+	updateSnapshots(ctx, d.root)
+
+	// update directory hierarchy
+	updateTemplateTree(d.root)
+
+	entry, ok := d.root.templateTree.lookup(d.path)
+	if !ok || entry.snapshot != nil {
+		return nil
+	}
+	return entry
+}
+
+// Attr returns the attributes for the TemplateDir.
+func (d *TemplateDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Inode = d.inode
+	attr.Mode = os.ModeDir | 0555
+
+	if !d.root.cfg.OwnerIsRoot {
+		attr.Uid = uint32(os.Getuid())
+		attr.Gid = uint32(os.Getgid())
+	}
+	debug.Log("attr: %v", attr)
+	return nil
+}
+
+// ReadDirAll returns all entries of the TemplateDir.
+func (d *TemplateDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	debug.Log("ReadDirAll()")
+
+	entry := d.entry(ctx)
+	if entry == nil {
+		return nil, fuse.ENOENT
+	}
+
+	items := []fuse.Dirent{
+		{
+			Inode: d.inode,
+			Name:  ".",
+			Type:  fuse.DT_Dir,
+		},
+		{
+			Inode: d.root.inode,
+			Name:  "..",
+			Type:  fuse.DT_Dir,
+		},
+	}
+
+	for name := range entry.entries {
+		items = append(items, fuse.Dirent{
+			Inode: fs.GenerateDynamicInode(d.inode, name),
+			Name:  name,
+			Type:  fuse.DT_Dir,
+		})
+	}
+
+	return items, nil
+}
+
+// Lookup returns a specific entry from the TemplateDir.
+func (d *TemplateDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	debug.Log("Lookup(%s)", name)
+
+	entry := d.entry(ctx)
+	if entry == nil {
+		return nil, fuse.ENOENT
+	}
+
+	child, ok := entry.entries[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	inode := fs.GenerateDynamicInode(d.inode, name)
+	if child.snapshot != nil {
+		return newDirFromSnapshot(ctx, d.root, inode, child.snapshot)
+	}
+
+	path := make([]string, len(d.path), len(d.path)+1)
+	copy(path, d.path)
+	return NewTemplateDir(d.root, inode, append(path, name)), nil
+}
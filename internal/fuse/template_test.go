@@ -0,0 +1,178 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// newTemplateTestSnapshot saves a new snapshot to repo and returns it, so
+// that the snapshot has an ID.
+func newTemplateTestSnapshot(t testing.TB, repo restic.Repository, host string, tags []string, ts string) *restic.Snapshot {
+	tm, err := time.Parse(time.RFC3339, ts)
+	rtest.OK(t, err)
+
+	sn, err := restic.NewSnapshot([]string{"/home"}, tags, host, tm)
+	rtest.OK(t, err)
+
+	id, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	sn, err = restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	return sn
+}
+
+// templatePaths returns the paths of all snapshots in the tree below e.
+func templatePaths(e *templateEntry, prefix string, paths map[string]*restic.Snapshot) {
+	for name, entry := range e.entries {
+		if entry.snapshot != nil {
+			paths[prefix+name] = entry.snapshot
+			continue
+		}
+		templatePaths(entry, prefix+name+"/", paths)
+	}
+}
+
+func TestSnapshotTemplateTree(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	sn1 := newTemplateTestSnapshot(t, repo, "foo", []string{"work"}, "2021-01-20T10:17:01Z")
+	sn2 := newTemplateTestSnapshot(t, repo, "foo", []string{"work"}, "2021-01-21T10:17:05Z")
+	sn3 := newTemplateTestSnapshot(t, repo, "foo", []string{"home", "work"}, "2021-01-21T10:17:05Z")
+	sn4 := newTemplateTestSnapshot(t, repo, "bar", nil, "2021-01-21T10:17:05Z")
+	sn5 := newTemplateTestSnapshot(t, repo, "bar", nil, "2021-01-21T10:17:05Z")
+	sn6 := newTemplateTestSnapshot(t, repo, "bar", []string{"a/b"}, "2021-01-22T08:00:00Z")
+	snapshots := restic.Snapshots{sn6, sn5, sn4, sn3, sn2, sn1}
+
+	// snapshots with the same timestamp are ordered by their IDs
+	if sn5.ID().Str() < sn4.ID().Str() {
+		sn4, sn5 = sn5, sn4
+	}
+	foo21, foo21x := sn2, sn3
+	if sn3.ID().Str() < sn2.ID().Str() {
+		foo21, foo21x = sn3, sn2
+	}
+
+	var tests = []struct {
+		template string
+		paths    map[string]*restic.Snapshot
+	}{
+		{
+			"{host}/{tags}/{time}",
+			map[string]*restic.Snapshot{
+				"foo/work/2021-01-20T10:17:01Z":      sn1,
+				"foo/work/2021-01-21T10:17:05Z":      sn2,
+				"foo/home,work/2021-01-21T10:17:05Z": sn3,
+				"bar/_/2021-01-21T10:17:05Z":         sn4,
+				"bar/_/2021-01-21T10:17:05Z-1":       sn5,
+				"bar/a_b/2021-01-22T08:00:00Z":       sn6,
+			},
+		},
+		{
+			"{time:2006}/{time:01}/{host}-{time:02}",
+			map[string]*restic.Snapshot{
+				"2021/01/foo-20":   sn1,
+				"2021/01/foo-21":   foo21,
+				"2021/01/foo-21-1": foo21x,
+				"2021/01/bar-21":   sn4,
+				"2021/01/bar-21-1": sn5,
+				"2021/01/bar-22":   sn6,
+			},
+		},
+		{
+			"hosts/{host}/{id}",
+			map[string]*restic.Snapshot{
+				"hosts/foo/" + sn1.ID().Str(): sn1,
+				"hosts/foo/" + sn2.ID().Str(): sn2,
+				"hosts/foo/" + sn3.ID().Str(): sn3,
+				"hosts/bar/" + sn4.ID().Str(): sn4,
+				"hosts/bar/" + sn5.ID().Str(): sn5,
+				"hosts/bar/" + sn6.ID().Str(): sn6,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			tmpl, err := parseSnapshotTemplate(test.template)
+			rtest.OK(t, err)
+
+			paths := make(map[string]*restic.Snapshot)
+			templatePaths(buildTemplateTree(snapshots, tmpl), "", paths)
+			rtest.Equals(t, test.paths, paths)
+		})
+	}
+}
+
+func TestParseSnapshotTemplateInvalid(t *testing.T) {
+	for _, template := range []string{
+		"{host}//{time}",
+		"{host}/",
+		"{host",
+		"{foo}",
+		"{host:2006}",
+	} {
+		_, err := parseSnapshotTemplate(template)
+		rtest.Assert(t, err != nil, "expected error for template %q, got none", template)
+	}
+}
+
+func TestTemplateDir(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	root := &Root{
+		inode:     rootInode,
+		snapshots: restic.Snapshots{
+			newTemplateTestSnapshot(t, repo, "foo", []string{"work"}, "2021-01-20T10:17:01Z"),
+			newTemplateTestSnapshot(t, repo, "foo", []string{"home"}, "2021-01-21T10:17:05Z"),
+			newTemplateTestSnapshot(t, repo, "bar", []string{"work"}, "2021-01-21T10:17:05Z"),
+		},
+		snCount:   3,
+		lastCheck: time.Now(),
+	}
+	tmpl, err := parseSnapshotTemplate("{host}/{tags}/{time:2006-01-02}")
+	rtest.OK(t, err)
+	root.template = &tmpl
+
+	readDir := func(d *TemplateDir) []string {
+		items, err := d.ReadDirAll(context.TODO())
+		rtest.OK(t, err)
+
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	dir := NewTemplateDir(root, 2, nil)
+	rtest.Equals(t, []string{".", "..", "bar", "foo"}, readDir(dir))
+
+	node, err := dir.Lookup(context.TODO(), "foo")
+	rtest.OK(t, err)
+	foo := node.(*TemplateDir)
+	rtest.Equals(t, []string{".", "..", "home", "work"}, readDir(foo))
+
+	node, err = foo.Lookup(context.TODO(), "work")
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{".", "..", "2021-01-20"}, readDir(node.(*TemplateDir)))
+
+	_, err = dir.Lookup(context.TODO(), "baz")
+	rtest.Assert(t, err != nil, "expected error for nonexistent directory")
+}
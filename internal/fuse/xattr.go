@@ -0,0 +1,48 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"bazil.org/fuse"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// nodeListxattr returns the names of the extended attributes stored for node.
+// When the kernel only asks for the required size (req.Size is zero), the
+// complete list must be returned nevertheless, the fuse library then only
+// passes on its length.
+func nodeListxattr(node *restic.Node, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	debug.Log("Listxattr(%v, %v)", node.Name, req.Size)
+	for _, attr := range node.ExtendedAttributes {
+		resp.Append(attr.Name)
+	}
+
+	if req.Size != 0 && uint64(len(resp.Xattr)) > uint64(req.Size) {
+		return fuse.ERANGE
+	}
+	return nil
+}
+
+// nodeGetxattr returns the value of an extended attribute stored for node,
+// size probes are handled like in nodeListxattr.
+func nodeGetxattr(node *restic.Node, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	debug.Log("Getxattr(%v, %v, %v)", node.Name, req.Name, req.Size)
+	for _, attr := range node.ExtendedAttributes {
+		if attr.Name != req.Name {
+			continue
+		}
+
+		if req.Size != 0 && uint64(len(attr.Value)) > uint64(req.Size) {
+			return fuse.ERANGE
+		}
+
+		// attributes with an empty value exist as well, so never return nil
+		resp.Xattr = append([]byte{}, attr.Value...)
+		return nil
+	}
+	return fuse.ErrNoXattr
+}
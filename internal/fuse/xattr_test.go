@@ -0,0 +1,146 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+var testXattrs = []restic.ExtendedAttribute{
+	{Name: "security.selinux", Value: []byte("system_u:object_r:user_home_t:s0\x00")},
+	{Name: "user.comment", Value: []byte("foo")},
+	{Name: "user.empty", Value: []byte{}},
+}
+
+// saveXattrSnapshot saves a snapshot containing a file, a directory and a
+// symlink with testXattrs, and returns the snapshot's ID.
+func saveXattrSnapshot(t testing.TB, repo restic.Repository) restic.ID {
+	ctx := context.TODO()
+	mtime := time.Unix(1500000000, 0)
+
+	subtreeID, err := repo.SaveTree(ctx, restic.NewTree())
+	rtest.OK(t, err)
+
+	tree := restic.NewTree()
+	for _, node := range []*restic.Node{
+		{Name: "dir", Type: "dir", Mode: os.ModeDir | 0755, ModTime: mtime, Subtree: &subtreeID, ExtendedAttributes: testXattrs},
+		{Name: "file", Type: "file", Mode: 0644, ModTime: mtime, ExtendedAttributes: testXattrs},
+		{Name: "link", Type: "symlink", Mode: os.ModeSymlink | 0777, ModTime: mtime, LinkTarget: "file", ExtendedAttributes: testXattrs},
+		{Name: "plain", Type: "file", Mode: 0644, ModTime: mtime},
+	} {
+		rtest.OK(t, tree.Insert(node))
+	}
+
+	treeID, err := repo.SaveTree(ctx, tree)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, repo.SaveIndex(ctx))
+
+	sn, err := restic.NewSnapshot([]string{"/"}, nil, "host", mtime)
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+type xattrNode interface {
+	fs.NodeListxattrer
+	fs.NodeGetxattrer
+}
+
+func lookupPath(t testing.TB, node fs.Node, path ...string) fs.Node {
+	for _, name := range path {
+		var err error
+		node, err = node.(fs.NodeStringLookuper).Lookup(context.TODO(), name)
+		rtest.OK(t, err)
+	}
+	return node
+}
+
+func TestXattrs(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id := saveXattrSnapshot(t, repo)
+
+	root, err := NewRoot(context.TODO(), repo, Config{SnapshotTemplate: time.RFC3339})
+	rtest.OK(t, err)
+
+	names := []string{}
+	for _, attr := range testXattrs {
+		names = append(names, attr.Name)
+	}
+	sort.Strings(names)
+
+	for _, name := range []string{"dir", "file", "link"} {
+		t.Run(name, func(t *testing.T) {
+			node := lookupPath(t, root, "ids", id.Str(), name).(xattrNode)
+
+			// size probe
+			resp := &fuse.ListxattrResponse{}
+			rtest.OK(t, node.Listxattr(context.TODO(), &fuse.ListxattrRequest{}, resp))
+			size := len(resp.Xattr)
+
+			resp = &fuse.ListxattrResponse{}
+			rtest.OK(t, node.Listxattr(context.TODO(), &fuse.ListxattrRequest{Size: uint32(size)}, resp))
+			rtest.Equals(t, size, len(resp.Xattr))
+
+			// the names are separated by null bytes
+			var listed []string
+			start := 0
+			for i, c := range resp.Xattr {
+				if c == 0 {
+					listed = append(listed, string(resp.Xattr[start:i]))
+					start = i + 1
+				}
+			}
+			sort.Strings(listed)
+			rtest.Equals(t, names, listed)
+
+			err := node.Listxattr(context.TODO(), &fuse.ListxattrRequest{Size: uint32(size - 1)}, &fuse.ListxattrResponse{})
+			rtest.Equals(t, fuse.ERANGE, err)
+
+			for _, attr := range testXattrs {
+				// size probe
+				resp := &fuse.GetxattrResponse{}
+				rtest.OK(t, node.Getxattr(context.TODO(), &fuse.GetxattrRequest{Name: attr.Name}, resp))
+				rtest.Equals(t, len(attr.Value), len(resp.Xattr))
+
+				resp = &fuse.GetxattrResponse{}
+				rtest.OK(t, node.Getxattr(context.TODO(), &fuse.GetxattrRequest{Name: attr.Name, Size: 1024}, resp))
+				rtest.Equals(t, attr.Value, resp.Xattr)
+
+				if len(attr.Value) > 1 {
+					err := node.Getxattr(context.TODO(), &fuse.GetxattrRequest{Name: attr.Name, Size: 1}, &fuse.GetxattrResponse{})
+					rtest.Equals(t, fuse.ERANGE, err)
+				}
+			}
+
+			err = node.Getxattr(context.TODO(), &fuse.GetxattrRequest{Name: "user.missing", Size: 1024}, &fuse.GetxattrResponse{})
+			rtest.Equals(t, fuse.ErrNoXattr, err)
+		})
+	}
+
+	node := lookupPath(t, root, "ids", id.Str(), "plain").(xattrNode)
+	resp := &fuse.ListxattrResponse{}
+	rtest.OK(t, node.Listxattr(context.TODO(), &fuse.ListxattrRequest{}, resp))
+	rtest.Equals(t, 0, len(resp.Xattr))
+}
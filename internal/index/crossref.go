@@ -0,0 +1,99 @@
+package index
+
+import (
+	"context"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MissingPack is a pack which is referenced by index files, but does not
+// exist in the repository.
+type MissingPack struct {
+	ID restic.ID
+
+	// Blobs is the number of index entries for blobs in the pack.
+	Blobs int
+
+	// Indexes contains the IDs of the index files referencing the pack.
+	Indexes restic.IDs
+}
+
+// UnindexedPack is a pack file which is not referenced by any index file.
+type UnindexedPack struct {
+	ID   restic.ID
+	Size int64
+
+	// PendingRemoval is set if an index file lists the pack as pending
+	// removal, e.g. because prune was unable to remove it due to a retention
+	// policy. Such a pack is expected not to be referenced.
+	PendingRemoval bool
+}
+
+// CrossReference compares the packs referenced by the index files with the
+// pack files in the repository. It returns the packs which are referenced but
+// missing, and the pack files which are not referenced by any index. The
+// repository is not modified.
+func CrossReference(ctx context.Context, repo ListLoader) (missing []MissingPack, unindexed []UnindexedPack, err error) {
+	indexed := make(map[restic.ID]*MissingPack)
+	pending := restic.NewIDSet()
+
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		idx, err := loadIndexJSON(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		for _, pack := range idx.Packs {
+			p, ok := indexed[pack.ID]
+			if !ok {
+				p = &MissingPack{ID: pack.ID}
+				indexed[pack.ID] = p
+			}
+			p.Blobs += len(pack.Blobs)
+			p.Indexes = append(p.Indexes, id)
+		}
+
+		for _, packID := range idx.PendingRemoval {
+			pending.Insert(packID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	packs := restic.NewIDSet()
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+		if _, ok := indexed[id]; !ok {
+			debug.Log("pack %v is not referenced by any index, pending removal %v", id, pending.Has(id))
+			unindexed = append(unindexed, UnindexedPack{ID: id, Size: size, PendingRemoval: pending.Has(id)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for id, p := range indexed {
+		if packs.Has(id) {
+			continue
+		}
+
+		debug.Log("pack %v referenced by %d indexes is missing", id, len(p.Indexes))
+		sort.Sort(p.Indexes)
+		missing = append(missing, *p)
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].ID.String() < missing[j].ID.String()
+	})
+	sort.Slice(unindexed, func(i, j int) bool {
+		return unindexed[i].ID.String() < unindexed[j].ID.String()
+	})
+
+	return missing, unindexed, nil
+}
@@ -46,8 +46,11 @@ type Lister interface {
 	ListPack(ctx context.Context, id restic.ID, size int64) ([]restic.Blob, int64, error)
 }
 
-// New creates a new index for repo from scratch. InvalidFiles contains all IDs
-// of files  that cannot be listed successfully.
+// New creates a new index for repo from scratch by reading the header of each
+// pack file. InvalidFiles contains all IDs of files which are not valid pack
+// files, e.g. because the header is truncated or references data beyond the
+// end of the file. Files that cannot be listed for other reasons are reported
+// on stderr and skipped.
 func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.Progress) (idx *Index, invalidFiles restic.IDs, err error) {
 	p.Start()
 	defer p.Done()
@@ -130,6 +133,12 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 			continue
 		}
 
+		if !entriesWithinPack(res.Entries, res.Size) {
+			debug.Log("pack %v references data beyond its end", res.PackID)
+			invalidFiles = append(invalidFiles, res.PackID)
+			continue
+		}
+
 		debug.Log("pack %v contains %d blobs", res.PackID, len(res.Entries))
 
 		err := idx.AddPack(res.PackID, res.Size, res.Entries)
@@ -151,6 +160,17 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 	return idx, invalidFiles, nil
 }
 
+// entriesWithinPack returns true if all blobs are contained in a pack file of
+// the given size.
+func entriesWithinPack(entries []restic.Blob, size int64) bool {
+	for _, entry := range entries {
+		if int64(entry.Offset)+int64(entry.Length) > size {
+			return false
+		}
+	}
+	return true
+}
+
 type packJSON struct {
 	ID    restic.ID  `json:"id"`
 	Blobs []blobJSON `json:"blobs"`
@@ -164,8 +184,9 @@ type blobJSON struct {
 }
 
 type indexJSON struct {
-	Supersedes restic.IDs `json:"supersedes,omitempty"`
-	Packs      []packJSON `json:"packs"`
+	Supersedes     restic.IDs `json:"supersedes,omitempty"`
+	Packs          []packJSON `json:"packs"`
+	PendingRemoval restic.IDs `json:"pending_removal,omitempty"`
 }
 
 // ListLoader allows listing files and their content, in addition to loading and unmarshaling JSON files.
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
@@ -155,6 +156,65 @@ func TestIndexNewPackErrors(t *testing.T) {
 	}
 }
 
+// truncatePack replaces the pack file id in the backend of repo by its first
+// size bytes.
+func truncatePack(t testing.TB, repo restic.Repository, id restic.ID, size int) {
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	buf, err := backend.LoadAll(context.TODO(), nil, repo.Backend(), h)
+	test.OK(t, err)
+
+	test.OK(t, repo.Backend().Remove(context.TODO(), h))
+	test.OK(t, repo.Backend().Save(context.TODO(), h, restic.NewByteReader(buf[:size])))
+}
+
+func TestIndexNewTruncatedPack(t *testing.T) {
+	var tests = []struct {
+		name string
+		size func(packSize int) int
+	}{
+		{"header-length-missing", func(packSize int) int { return packSize - 3 }},
+		{"header-truncated", func(packSize int) int { return packSize - 40 }},
+		{"tiny-file", func(packSize int) int { return 5 }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, cleanup := createFilledRepo(t, 3, 0)
+			defer cleanup()
+
+			packs := make(map[restic.ID]int64)
+			test.OK(t, repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+				packs[id] = size
+				return nil
+			}))
+
+			var damaged restic.ID
+			for id, size := range packs {
+				damaged = id
+				truncatePack(t, repo, id, tc.size(int(size)))
+				break
+			}
+
+			idx, invalid, err := New(context.TODO(), repo, restic.NewIDSet(), nil)
+			test.OK(t, err)
+
+			if _, ok := idx.Packs[damaged]; ok {
+				t.Errorf("damaged pack %v is contained in the index", damaged.Str())
+			}
+			test.Equals(t, restic.IDs{damaged}, invalid)
+
+			for id := range packs {
+				if id.Equal(damaged) {
+					continue
+				}
+				if _, ok := idx.Packs[id]; !ok {
+					t.Errorf("pack %v missing from index", id.Str())
+				}
+			}
+		})
+	}
+}
+
 func TestIndexLoad(t *testing.T) {
 	repo, cleanup := createFilledRepo(t, 3, 0)
 	defer cleanup()
@@ -302,6 +362,65 @@ func TestIndexDuplicateBlobs(t *testing.T) {
 	t.Logf("%d packs with duplicate blobs", len(packs))
 }
 
+func TestCrossReference(t *testing.T) {
+	repo, cleanup := createFilledRepo(t, 3, 0)
+	defer cleanup()
+
+	missing, unindexed, err := CrossReference(context.TODO(), repo)
+	test.OK(t, err)
+	test.Equals(t, 0, len(missing))
+	test.Equals(t, 0, len(unindexed))
+
+	// remove a pack file, its index entries are left dangling
+	idx := loadIndex(t, repo)
+	var removed Pack
+	for _, pack := range idx.Packs {
+		removed = pack
+		break
+	}
+	h := restic.Handle{Type: restic.DataFile, Name: removed.ID.String()}
+	test.OK(t, repo.Backend().Remove(context.TODO(), h))
+
+	// save a pack file without adding it to an index
+	_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, test.Random(23, 1000), restic.ID{})
+	test.OK(t, err)
+	test.OK(t, repo.Flush(context.TODO()))
+
+	var unindexedID restic.ID
+	err = repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		if _, ok := idx.Packs[id]; !ok {
+			unindexedID = id
+		}
+		return nil
+	})
+	test.OK(t, err)
+
+	missing, unindexed, err = CrossReference(context.TODO(), repo)
+	test.OK(t, err)
+
+	test.Equals(t, 1, len(missing))
+	test.Equals(t, removed.ID, missing[0].ID)
+	test.Equals(t, len(removed.Entries), missing[0].Blobs)
+	test.Equals(t, 1, len(missing[0].Indexes))
+
+	test.Equals(t, 1, len(unindexed))
+	test.Equals(t, unindexedID, unindexed[0].ID)
+	test.Assert(t, unindexed[0].Size > 0, "unindexed pack has no size")
+	test.Assert(t, !unindexed[0].PendingRemoval, "unindexed pack is marked as pending removal")
+
+	// an index listing the pack as pending removal marks it as such
+	pendingIdx := repository.NewIndex()
+	test.OK(t, pendingIdx.AddPendingRemoval(unindexedID))
+	_, err = repository.SaveIndex(context.TODO(), repo, pendingIdx)
+	test.OK(t, err)
+
+	_, unindexed, err = CrossReference(context.TODO(), repo)
+	test.OK(t, err)
+	test.Equals(t, 1, len(unindexed))
+	test.Equals(t, unindexedID, unindexed[0].ID)
+	test.Assert(t, unindexed[0].PendingRemoval, "pack is not marked as pending removal")
+}
+
 func loadIndex(t testing.TB, repo restic.Repository) *Index {
 	idx, err := Load(context.TODO(), repo, nil)
 	if err != nil {
@@ -8,28 +8,60 @@ import (
 )
 
 // LimitBackend wraps a Backend and applies rate limiting to Load() and Save()
-// calls on the backend.
-func LimitBackend(be restic.Backend, l Limiter) restic.Backend {
+// calls on the backend. Data files (which contain the bulk of the data) are
+// limited by data, all other files (index, snapshots, locks, keys and the
+// config) by metadata. If a limiter is nil, the respective files are not
+// limited.
+func LimitBackend(be restic.Backend, data, metadata Limiter) restic.Backend {
 	return rateLimitedBackend{
-		Backend: be,
-		limiter: l,
+		Backend:  be,
+		data:     data,
+		metadata: metadata,
 	}
 }
 
 type rateLimitedBackend struct {
 	restic.Backend
-	limiter Limiter
+	data     Limiter
+	metadata Limiter
+}
+
+// limiter returns the limiter for files of type t.
+func (r rateLimitedBackend) limiter(t restic.FileType) Limiter {
+	if t == restic.DataFile {
+		return r.data
+	}
+	return r.metadata
 }
 
 func (r rateLimitedBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	l := r.limiter(h.Type)
+	if l == nil {
+		return r.Backend.Save(ctx, h, rd)
+	}
+
 	limited := limitedRewindReader{
 		RewindReader: rd,
-		limited:      r.limiter.Upstream(rd),
+		limited:      l.Upstream(rd),
 	}
 
 	return r.Backend.Save(ctx, h, limited)
 }
 
+func (r rateLimitedBackend) SaveAtomic(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	l := r.limiter(h.Type)
+	if l == nil {
+		return restic.SaveAtomic(ctx, r.Backend, h, rd)
+	}
+
+	limited := limitedRewindReader{
+		RewindReader: rd,
+		limited:      l.Upstream(rd),
+	}
+
+	return restic.SaveAtomic(ctx, r.Backend, h, limited)
+}
+
 type limitedRewindReader struct {
 	restic.RewindReader
 
@@ -41,9 +73,14 @@ func (l limitedRewindReader) Read(b []byte) (int, error) {
 }
 
 func (r rateLimitedBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) error {
+	l := r.limiter(h.Type)
+	if l == nil {
+		return r.Backend.Load(ctx, h, length, offset, consumer)
+	}
+
 	return r.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
 		lrd := limitedReadCloser{
-			limited: r.limiter.Downstream(rd),
+			limited: l.Downstream(rd),
 		}
 		return consumer(lrd)
 	})
@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/restic/restic/internal/mock"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// countingLimiter does not limit anything, but counts the number of bytes
+// which passed through it.
+type countingLimiter struct {
+	up, down int
+}
+
+type countingReader struct {
+	rd io.Reader
+	n  *int
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	*r.n += n
+	return n, err
+}
+
+func (l *countingLimiter) Upstream(r io.Reader) io.Reader {
+	return countingReader{rd: r, n: &l.up}
+}
+
+func (l *countingLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	panic("not implemented")
+}
+
+func (l *countingLimiter) Downstream(r io.Reader) io.Reader {
+	return countingReader{rd: r, n: &l.down}
+}
+
+func (l *countingLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	panic("not implemented")
+}
+
+func TestLimitBackendDataMetadata(t *testing.T) {
+	data := []byte("foobar")
+
+	be := mock.NewBackend()
+	be.SaveFn = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+		_, err := io.Copy(ioutil.Discard, rd)
+		return err
+	}
+	be.OpenReaderFn = func(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	var tests = []struct {
+		tpe        restic.FileType
+		isDataFile bool
+	}{
+		{restic.DataFile, true},
+		{restic.IndexFile, false},
+		{restic.LockFile, false},
+		{restic.SnapshotFile, false},
+		{restic.KeyFile, false},
+		{restic.ConfigFile, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.tpe), func(t *testing.T) {
+			dataLimiter := &countingLimiter{}
+			metadataLimiter := &countingLimiter{}
+			limited := LimitBackend(be, dataLimiter, metadataLimiter)
+
+			h := restic.Handle{Type: test.tpe, Name: "foo"}
+			rtest.OK(t, limited.Save(context.TODO(), h, restic.NewByteReader(data)))
+			rtest.OK(t, limited.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+				_, err := io.Copy(ioutil.Discard, rd)
+				return err
+			}))
+
+			want, other := metadataLimiter, dataLimiter
+			if test.isDataFile {
+				want, other = dataLimiter, metadataLimiter
+			}
+
+			rtest.Equals(t, countingLimiter{up: len(data), down: len(data)}, *want)
+			rtest.Equals(t, countingLimiter{}, *other)
+		})
+	}
+}
+
+func TestLimitBackendNilLimiter(t *testing.T) {
+	data := []byte("foobar")
+
+	var saved []byte
+	be := mock.NewBackend()
+	be.SaveFn = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+		var err error
+		saved, err = ioutil.ReadAll(rd)
+		return err
+	}
+
+	dataLimiter := &countingLimiter{}
+	limited := LimitBackend(be, dataLimiter, nil)
+
+	h := restic.Handle{Type: restic.IndexFile, Name: "foo"}
+	rtest.OK(t, limited.Save(context.TODO(), h, restic.NewByteReader(data)))
+	rtest.Equals(t, data, saved)
+	rtest.Equals(t, countingLimiter{}, *dataLimiter)
+}
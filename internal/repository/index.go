@@ -23,6 +23,10 @@ type Index struct {
 	id         restic.ID // set to the ID of the index when it's finalized
 	supersedes restic.IDs
 	created    time.Time
+
+	// pendingRemoval lists packs which are no longer referenced by any index
+	// but could not be removed yet, e.g. because of a retention policy
+	pendingRemoval restic.IDs
 }
 
 type indexEntry struct {
@@ -109,6 +113,22 @@ func (idx *Index) Store(blob restic.PackedBlob) {
 	idx.store(blob)
 }
 
+// storeIfNotFinal stores the blob unless the index has been finalized. It
+// returns false if the blob was not stored.
+func (idx *Index) storeIfNotFinal(blob restic.PackedBlob) bool {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.final {
+		return false
+	}
+
+	debug.Log("%v", blob)
+
+	idx.store(blob)
+	return true
+}
+
 // Lookup queries the index for the blob ID and returns a restic.PackedBlob.
 func (idx *Index) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.PackedBlob, found bool) {
 	idx.m.Lock()
@@ -204,6 +224,26 @@ func (idx *Index) AddToSupersedes(ids ...restic.ID) error {
 	return nil
 }
 
+// PendingRemoval returns the list of packs which are waiting to be removed.
+func (idx *Index) PendingRemoval() restic.IDs {
+	return idx.pendingRemoval
+}
+
+// AddPendingRemoval adds the packs ids to the list of packs which are waiting
+// to be removed. If the index has already been finalized, an error is
+// returned.
+func (idx *Index) AddPendingRemoval(ids ...restic.ID) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.final {
+		return errors.New("index already finalized")
+	}
+
+	idx.pendingRemoval = append(idx.pendingRemoval, ids...)
+	return nil
+}
+
 // Each returns a channel that yields all blobs known to the index. When the
 // context is cancelled, the background goroutine terminates. This blocks any
 // modification of the index.
@@ -330,8 +370,9 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 }
 
 type jsonIndex struct {
-	Supersedes restic.IDs  `json:"supersedes,omitempty"`
-	Packs      []*packJSON `json:"packs"`
+	Supersedes     restic.IDs  `json:"supersedes,omitempty"`
+	Packs          []*packJSON `json:"packs"`
+	PendingRemoval restic.IDs  `json:"pending_removal,omitempty"`
 }
 
 // Encode writes the JSON serialization of the index to the writer w.
@@ -354,8 +395,9 @@ func (idx *Index) encode(w io.Writer) error {
 
 	enc := json.NewEncoder(w)
 	idxJSON := jsonIndex{
-		Supersedes: idx.supersedes,
-		Packs:      list,
+		Supersedes:     idx.supersedes,
+		Packs:          list,
+		PendingRemoval: idx.pendingRemoval,
 	}
 	return enc.Encode(idxJSON)
 }
@@ -416,8 +458,9 @@ func (idx *Index) Dump(w io.Writer) error {
 	}
 
 	outer := jsonIndex{
-		Supersedes: idx.Supersedes(),
-		Packs:      list,
+		Supersedes:     idx.Supersedes(),
+		Packs:          list,
+		PendingRemoval: idx.pendingRemoval,
 	}
 
 	buf, err := json.MarshalIndent(outer, "", "  ")
@@ -498,6 +541,7 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 		}
 	}
 	idx.supersedes = idxJSON.Supersedes
+	idx.pendingRemoval = idxJSON.PendingRemoval
 	idx.final = true
 
 	debug.Log("done")
@@ -327,6 +327,24 @@ func TestIndexUnserialize(t *testing.T) {
 	}
 }
 
+func TestIndexPendingRemoval(t *testing.T) {
+	pending := restic.IDs{restic.NewRandomID(), restic.NewRandomID()}
+
+	idx := repository.NewIndex()
+	rtest.OK(t, idx.AddPendingRemoval(pending...))
+
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Finalize(wr))
+
+	idx2, err := repository.DecodeIndex(wr.Bytes())
+	rtest.OK(t, err)
+	rtest.Equals(t, pending, idx2.PendingRemoval())
+
+	// a finalized index cannot be modified
+	rtest.Assert(t, idx2.AddPendingRemoval(restic.NewRandomID()) != nil,
+		"expected error for finalized index")
+}
+
 func BenchmarkDecodeIndex(b *testing.B) {
 	b.ResetTimer()
 
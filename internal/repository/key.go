@@ -34,6 +34,8 @@ type Key struct {
 	N    int    `json:"N"`
 	R    int    `json:"r"`
 	P    int    `json:"p"`
+	T    uint32 `json:"t,omitempty"`
+	M    uint32 `json:"m,omitempty"`
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
@@ -47,6 +49,17 @@ type Key struct {
 // calibrated on the first run of AddKey().
 var Params *crypto.Params
 
+// KeyOptions selects the key derivation function for new keys. The zero value
+// selects scrypt.
+type KeyOptions struct {
+	// KDF is the key derivation function, either crypto.KDFScrypt or
+	// crypto.KDFArgon2id. An empty string selects scrypt.
+	KDF string
+
+	// Argon2Params are the parameters used when KDF is crypto.KDFArgon2id.
+	Argon2Params crypto.Argon2Params
+}
+
 var (
 	// KDFTimeout specifies the maximum runtime for the KDF.
 	KDFTimeout = 500 * time.Millisecond
@@ -57,8 +70,8 @@ var (
 
 // createMasterKey creates a new master key in the given backend and encrypts
 // it with the password.
-func createMasterKey(s *Repository, password string) (*Key, error) {
-	return AddKey(context.TODO(), s, password, nil)
+func createMasterKey(s *Repository, password string, opts KeyOptions) (*Key, error) {
+	return AddKey(context.TODO(), s, password, nil, opts)
 }
 
 // OpenKey tries do decrypt the key specified by name with the given password.
@@ -69,20 +82,10 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 		return nil, err
 	}
 
-	// check KDF
-	if k.KDF != "scrypt" {
-		return nil, errors.New("only supported KDF is scrypt()")
-	}
-
 	// derive user key
-	params := crypto.Params{
-		N: k.N,
-		R: k.R,
-		P: k.P,
-	}
-	k.user, err = crypto.KDF(params, k.Salt, password)
+	k.user, err = k.deriveUserKey(password)
 	if err != nil {
-		return nil, errors.Wrap(err, "crypto.KDF")
+		return nil, err
 	}
 
 	// decrypt master keys
@@ -198,26 +201,46 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 	return k, nil
 }
 
-// AddKey adds a new key to an already existing repository.
-func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key) (*Key, error) {
-	// make sure we have valid KDF parameters
-	if Params == nil {
-		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
-		if err != nil {
-			return nil, errors.Wrap(err, "Calibrate")
-		}
-
-		Params = &p
-		debug.Log("calibrated KDF parameters are %v", p)
+// AddKey adds a new key to an already existing repository. The key is derived
+// from the password with the KDF selected in opts.
+func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key, opts KeyOptions) (*Key, error) {
+	kdf := opts.KDF
+	if kdf == "" {
+		kdf = crypto.KDFScrypt
 	}
 
 	// fill meta data about key
 	newkey := &Key{
 		Created: time.Now(),
-		KDF:     "scrypt",
-		N:       Params.N,
-		R:       Params.R,
-		P:       Params.P,
+		KDF:     kdf,
+	}
+
+	switch kdf {
+	case crypto.KDFScrypt:
+		// make sure we have valid KDF parameters
+		if Params == nil {
+			p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+			if err != nil {
+				return nil, errors.Wrap(err, "Calibrate")
+			}
+
+			Params = &p
+			debug.Log("calibrated KDF parameters are %v", p)
+		}
+
+		newkey.N = Params.N
+		newkey.R = Params.R
+		newkey.P = Params.P
+	case crypto.KDFArgon2id:
+		if err := opts.Argon2Params.Check(); err != nil {
+			return nil, err
+		}
+
+		newkey.T = opts.Argon2Params.Time
+		newkey.M = opts.Argon2Params.Memory
+		newkey.P = int(opts.Argon2Params.Threads)
+	default:
+		return nil, errors.Errorf("unsupported KDF %q", kdf)
 	}
 
 	hn, err := os.Hostname()
@@ -237,7 +260,7 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 	}
 
 	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
+	newkey.user, err = newkey.deriveUserKey(password)
 	if err != nil {
 		return nil, err
 	}
@@ -284,6 +307,43 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 	return newkey, nil
 }
 
+// deriveUserKey derives the user key from the password with the KDF and the
+// parameters stored in the key.
+func (k *Key) deriveUserKey(password string) (*crypto.Key, error) {
+	switch k.KDF {
+	case crypto.KDFScrypt:
+		params := crypto.Params{
+			N: k.N,
+			R: k.R,
+			P: k.P,
+		}
+
+		user, err := crypto.KDF(params, k.Salt, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "crypto.KDF")
+		}
+		return user, nil
+	case crypto.KDFArgon2id:
+		if k.P < 1 || k.P > 255 {
+			return nil, errors.Errorf("invalid Argon2id parallelism %d", k.P)
+		}
+
+		params := crypto.Argon2Params{
+			Time:    k.T,
+			Memory:  k.M,
+			Threads: uint8(k.P),
+		}
+
+		user, err := crypto.Argon2KDF(params, k.Salt, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "crypto.Argon2KDF")
+		}
+		return user, nil
+	default:
+		return nil, errors.Errorf("unsupported KDF %q", k.KDF)
+	}
+}
+
 func (k *Key) String() string {
 	if k == nil {
 		return "<Key nil>"
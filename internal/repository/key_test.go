@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestKeyKDF(t *testing.T) {
+	r, cleanup := TestRepository(t)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	argon2Params := crypto.Argon2Params{Time: 1, Memory: 64, Threads: 2}
+
+	for _, kdf := range []string{crypto.KDFScrypt, crypto.KDFArgon2id} {
+		t.Run(kdf, func(t *testing.T) {
+			password := "password for " + kdf
+
+			key, err := AddKey(context.TODO(), repo, password, repo.Key(), KeyOptions{KDF: kdf, Argon2Params: argon2Params})
+			rtest.OK(t, err)
+
+			// the KDF and its parameters are stored in the key file
+			buf, err := backend.LoadAll(context.TODO(), nil, repo.Backend(), restic.Handle{Type: restic.KeyFile, Name: key.Name()})
+			rtest.OK(t, err)
+
+			var stored map[string]interface{}
+			rtest.OK(t, json.Unmarshal(buf, &stored))
+			rtest.Equals(t, kdf, stored["kdf"])
+			if kdf == crypto.KDFArgon2id {
+				rtest.Equals(t, float64(1), stored["t"])
+				rtest.Equals(t, float64(64), stored["m"])
+				rtest.Equals(t, float64(2), stored["p"])
+			} else {
+				rtest.Equals(t, nil, stored["t"])
+				rtest.Equals(t, nil, stored["m"])
+			}
+
+			// the KDF is selected by the key file
+			opened, err := OpenKey(context.TODO(), repo, key.Name(), password)
+			rtest.OK(t, err)
+			rtest.Equals(t, repo.Key().EncryptionKey, opened.master.EncryptionKey)
+			rtest.Equals(t, repo.Key().MACKey.K, opened.master.MACKey.K)
+			rtest.Equals(t, repo.Key().MACKey.R, opened.master.MACKey.R)
+
+			_, err = OpenKey(context.TODO(), repo, key.Name(), "wrong password")
+			rtest.Assert(t, errors.Cause(err) == crypto.ErrUnauthenticated,
+				"expected ErrUnauthenticated for wrong password, got %v", err)
+
+			opened, err = SearchKey(context.TODO(), repo, password, 0, "")
+			rtest.OK(t, err)
+			rtest.Equals(t, key.Name(), opened.Name())
+		})
+	}
+}
+
+func TestKeyUnsupportedKDF(t *testing.T) {
+	r, cleanup := TestRepository(t)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	_, err := AddKey(context.TODO(), repo, "password", repo.Key(), KeyOptions{KDF: "bcrypt"})
+	rtest.Assert(t, err != nil, "expected error for unsupported KDF, got none")
+
+	_, err = AddKey(context.TODO(), repo, "password", repo.Key(), KeyOptions{KDF: crypto.KDFArgon2id})
+	rtest.Assert(t, err != nil, "expected error for invalid Argon2id parameters, got none")
+}
@@ -119,8 +119,8 @@ func (mi *MasterIndex) Store(pb restic.PackedBlob) {
 	defer mi.idxMutex.Unlock()
 
 	for _, idx := range mi.idx {
-		if !idx.Final() {
-			idx.Store(pb)
+		// the index may be finalized concurrently while it is being saved
+		if idx.storeIfNotFinal(pb) {
 			return
 		}
 	}
@@ -181,6 +181,21 @@ func (mi *MasterIndex) All() []*Index {
 	return mi.idx
 }
 
+// PendingRemoval returns the packs which are waiting to be removed according
+// to any of the indexes.
+func (mi *MasterIndex) PendingRemoval() restic.IDSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	pending := restic.NewIDSet()
+	for _, idx := range mi.idx {
+		for _, id := range idx.PendingRemoval() {
+			pending.Insert(id)
+		}
+	}
+	return pending
+}
+
 // Each returns a channel that yields all blobs known to the index. When the
 // context is cancelled, the background goroutine terminates. This blocks any
 // modification of the index.
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/factory"
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// OpenOptions configure how Open opens a repository.
+type OpenOptions struct {
+	// Repo is the location of the repository, e.g. "/srv/restic-repo" or
+	// "s3:s3.amazonaws.com/bucket".
+	Repo string
+
+	// Backend is used instead of opening the backend at Repo if it is set.
+	Backend restic.Backend
+
+	// BackendOptions configure how the backend at Repo is opened.
+	BackendOptions factory.Options
+
+	// AppendOnly wraps the backend so that no files can be removed.
+	AppendOnly bool
+
+	// Password is called to get the password for the repository.
+	Password func() (string, error)
+
+	// PasswordTries is the number of times Password is called if the
+	// password is wrong or cannot be read, zero means one try.
+	// PasswordError is called with the error of each failed try but the
+	// last.
+	PasswordTries int
+	PasswordError func(err error)
+
+	// KeyHint is the ID of the key which is tried first.
+	KeyHint string
+
+	// CacheDir is the directory for the local cache, the default directory
+	// is used if it is empty. The cache is not used if NoCache is set.
+	CacheDir string
+	NoCache  bool
+
+	// CacheMaxSize limits the size of the cache in bytes, zero means no
+	// limit.
+	CacheMaxSize int64
+
+	// CacheListingTTL is the time for which listings of the repository are
+	// kept in the cache, zero disables caching listings.
+	CacheListingTTL time.Duration
+
+	// Warnf is called for errors which do not prevent opening the
+	// repository, e.g. retried backend requests or an unusable cache. The
+	// errors are only logged if it is nil.
+	Warnf func(format string, args ...interface{})
+}
+
+// openMaxKeys is the number of keys which are tried by Open.
+const openMaxKeys = 20
+
+// Open opens the repository at opts.Repo and decrypts the key with the
+// password. The local cache is used unless disabled in opts. This is
+// intended for programs which use restic as a library, the repository must
+// be closed by calling Close.
+func Open(ctx context.Context, opts OpenOptions) (*Repository, error) {
+	if opts.Password == nil {
+		return nil, errors.New("no password source configured")
+	}
+
+	be := opts.Backend
+	if be == nil {
+		if opts.Repo == "" {
+			return nil, errors.New("no repository location configured")
+		}
+
+		var err error
+		be, err = factory.Open(ctx, opts.Repo, opts.BackendOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	warnf := opts.Warnf
+	if warnf == nil {
+		warnf = func(format string, args ...interface{}) {
+			debug.Log(format, args...)
+		}
+	}
+
+	if opts.AppendOnly {
+		be = backend.NewAppendOnlyBackend(be)
+	}
+
+	be = backend.NewRetryBackend(be, 10, func(msg string, err error, d time.Duration) {
+		warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
+	})
+
+	r := New(be)
+
+	tries := opts.PasswordTries
+	if tries < 1 {
+		tries = 1
+	}
+
+	var err error
+	for ; tries > 0; tries-- {
+		var password string
+		password, err = opts.Password()
+		if err == nil {
+			err = r.SearchKey(ctx, password, openMaxKeys, opts.KeyHint)
+		}
+		if err == nil {
+			break
+		}
+		if tries > 1 && opts.PasswordError != nil {
+			opts.PasswordError(err)
+		}
+	}
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	if opts.NoCache {
+		return r, nil
+	}
+
+	// the repository can be used without the cache, so errors are not fatal
+	c, err := cache.New(r.Config().ID, opts.CacheDir)
+	if err != nil {
+		warnf("unable to open cache: %v\n", err)
+		return r, nil
+	}
+
+	c.SetListingTTL(opts.CacheListingTTL)
+
+	if opts.CacheMaxSize > 0 {
+		if err := c.SetMaxSize(opts.CacheMaxSize); err != nil {
+			warnf("unable to limit the cache size: %v\n", err)
+		}
+	}
+
+	r.UseCache(c)
+	return r, nil
+}
@@ -0,0 +1,54 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+func ExampleOpen() {
+	ctx := context.Background()
+
+	// prepare a repository with a snapshot in memory
+	be := mem.New()
+	repo := repository.New(be)
+	if err := repo.Init(ctx, "secret", repository.InitOptions{}); err != nil {
+		panic(err)
+	}
+
+	sn, err := restic.NewSnapshot([]string{"/home/user"}, nil, "example", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		panic(err)
+	}
+	if _, err = repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn); err != nil {
+		panic(err)
+	}
+
+	// open the repository, usually only opts.Repo is set instead of the backend
+	repo, err = repository.Open(ctx, repository.OpenOptions{
+		Backend: be,
+		Password: func() (string, error) {
+			return "secret", nil
+		},
+		NoCache: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer repo.Close()
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, sn := range snapshots {
+		fmt.Printf("%v %v %v\n", sn.Time.Format("2006-01-02 15:04:05"), sn.Hostname, sn.Paths)
+	}
+
+	// Output: 2020-01-02 03:04:05 example [/home/user]
+}
@@ -0,0 +1,83 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOpen(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repodir := filepath.Join(dir, "repo")
+	be, err := local.Create(local.Config{Path: repodir})
+	rtest.OK(t, err)
+	repo, _ := repository.TestRepositoryWithBackend(t, be)
+	rtest.OK(t, be.Close())
+
+	password := func(pw string) func() (string, error) {
+		return func() (string, error) { return pw, nil }
+	}
+
+	opts := repository.OpenOptions{
+		Repo:     repodir,
+		Password: password(rtest.TestPassword),
+		CacheDir: filepath.Join(dir, "cache"),
+	}
+
+	r, err := repository.Open(context.TODO(), opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, repo.Config().ID, r.Config().ID)
+	rtest.Assert(t, r.Cache != nil, "cache not used")
+	rtest.OK(t, r.Close())
+
+	opts.Password = password("wrong")
+	_, err = repository.Open(context.TODO(), opts)
+	rtest.Assert(t, err != nil, "no error for wrong password")
+
+	opts.Password = nil
+	_, err = repository.Open(context.TODO(), opts)
+	rtest.Assert(t, err != nil, "no error for missing password")
+
+	opts.Password = password(rtest.TestPassword)
+	opts.Repo = filepath.Join(dir, "missing")
+	_, err = repository.Open(context.TODO(), opts)
+	rtest.Assert(t, err != nil, "no error for missing repository")
+}
+
+func TestOpenPasswordTries(t *testing.T) {
+	be := mem.New()
+	repository.TestRepositoryWithBackend(t, be)
+
+	passwords := []string{"wrong", rtest.TestPassword}
+	var failed []error
+	opts := repository.OpenOptions{
+		Backend: be,
+		Password: func() (string, error) {
+			pw := passwords[0]
+			passwords = passwords[1:]
+			return pw, nil
+		},
+		PasswordTries: 2,
+		PasswordError: func(err error) {
+			failed = append(failed, err)
+		},
+		NoCache:    true,
+		AppendOnly: true,
+	}
+
+	r, err := repository.Open(context.TODO(), opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(failed))
+
+	// files cannot be removed from an append-only repository
+	err = r.Backend().Remove(context.TODO(), restic.Handle{Type: restic.KeyFile, Name: r.KeyName()})
+	rtest.Assert(t, err != nil, "expected an error removing a file from an append-only repository")
+}
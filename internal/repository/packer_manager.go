@@ -36,8 +36,6 @@ type packerManager struct {
 	packers []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
-
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
 func newPackerManager(be Saver, key *crypto.Key) *packerManager {
@@ -90,7 +88,7 @@ func (r *packerManager) insertPacker(p *Packer) {
 // savePacker stores p in the backend.
 func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packer) error {
 	debug.Log("save packer for %v with %d blobs (%d bytes)\n", t, p.Packer.Count(), p.Packer.Size())
-	_, err := p.Packer.Finalize()
+	size, err := p.Packer.Finalize()
 	if err != nil {
 		return err
 	}
@@ -111,6 +109,13 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 
 	debug.Log("saved as %v", h)
 
+	if r.verifyUploads {
+		err = r.verifyPack(ctx, h, int64(size), p)
+		if err != nil {
+			return err
+		}
+	}
+
 	if t == restic.TreeBlob && r.Cache != nil {
 		debug.Log("saving tree pack file in cache")
 
@@ -152,6 +157,29 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 	return nil
 }
 
+// verifyPack reads the header of the uploaded pack file h with the given size
+// from the backend and checks that it lists the blobs saved in p.
+func (r *Repository) verifyPack(ctx context.Context, h restic.Handle, size int64, p *Packer) error {
+	debug.Log("verify %v", h)
+	blobs, err := pack.List(r.key, restic.ReaderAt(r.be, h), size)
+	if err != nil {
+		return errors.Wrapf(err, "verify uploaded pack %v", h.Name)
+	}
+
+	written := p.Packer.Blobs()
+	if len(blobs) != len(written) {
+		return errors.Errorf("verify uploaded pack %v: header lists %d blobs, %d were written", h.Name, len(blobs), len(written))
+	}
+
+	for i, blob := range blobs {
+		if blob != written[i] {
+			return errors.Errorf("verify uploaded pack %v: header lists %v, %v was written", h.Name, blob, written[i])
+		}
+	}
+
+	return nil
+}
+
 // countPacker returns the number of open (unfinished) packers.
 func (r *packerManager) countPacker() int {
 	r.pm.Lock()
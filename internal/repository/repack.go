@@ -71,7 +71,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 			}
 
 			nonce, ciphertext := buf[:repo.Key().NonceSize()], buf[repo.Key().NonceSize():]
-			plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+			plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, repo.Config().BlobAdditionalData(entry.ID))
 			if err != nil {
 				return nil, err
 			}
@@ -31,6 +31,10 @@ type Repository struct {
 
 	treePM *packerManager
 	dataPM *packerManager
+
+	treeCache *treeCache
+
+	verifyUploads bool
 }
 
 // New returns a new repository with backend be.
@@ -60,6 +64,24 @@ func (r *Repository) UseCache(c restic.Cache) {
 	r.be = c.Wrap(r.be)
 }
 
+// UseTreeCache keeps the most recently loaded trees in memory, so that trees
+// which are loaded again, e.g. by several walks over the same snapshot, are
+// not loaded and decoded from the backend again. maxSize is the maximum size
+// of the cached trees in bytes, as stored in the repository.
+func (r *Repository) UseTreeCache(maxSize int) {
+	debug.Log("using tree cache with %d bytes", maxSize)
+	r.treeCache = newTreeCache(maxSize)
+}
+
+// VerifyUploads makes the repository read back the header of each pack file
+// after it has been uploaded, and check that it lists the blobs which were
+// written to the pack. This detects backends which return corrupted data
+// early, at the cost of an additional request per pack file.
+func (r *Repository) VerifyUploads() {
+	debug.Log("verifying uploaded packs")
+	r.verifyUploads = true
+}
+
 // PrefixLength returns the number of bytes required so that all prefixes of
 // all IDs of type t are unique.
 func (r *Repository) PrefixLength(t restic.FileType) (int, error) {
@@ -174,7 +196,7 @@ func (r *Repository) loadBlob(ctx context.Context, id restic.ID, t restic.BlobTy
 
 		// decrypt
 		nonce, ciphertext := plaintextBuf[:r.key.NonceSize()], plaintextBuf[r.key.NonceSize():]
-		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, r.cfg.BlobAdditionalData(id))
 		if err != nil {
 			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
 			continue
@@ -234,7 +256,7 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	defer freeBuf(ciphertext)
 
 	// encrypt blob
-	ciphertext = r.key.Seal(ciphertext, nonce, data, nil)
+	ciphertext = r.key.Seal(ciphertext, nonce, data, r.cfg.BlobAdditionalData(*id))
 
 	// find suitable packer and add blob
 	var pm *packerManager
@@ -260,7 +282,7 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < r.cfg.TargetPackSize() {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return *id, nil
@@ -282,12 +304,37 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 	return r.SaveUnpacked(ctx, t, plaintext)
 }
 
+// SaveJSONUnpackedDeterministic works like SaveJSONUnpacked, but the nonce
+// is derived from the content, so saving the same item again yields the same
+// storage hash.
+func (r *Repository) SaveJSONUnpackedDeterministic(ctx context.Context, t restic.FileType, item interface{}) (restic.ID, error) {
+	debug.Log("save new deterministic blob %v", t)
+	plaintext, err := json.Marshal(item)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "json.Marshal")
+	}
+
+	return r.saveUnpacked(ctx, t, plaintext, true)
+}
+
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
+	return r.saveUnpacked(ctx, t, p, false)
+}
+
+// saveUnpacked encrypts and stores p. If deterministic is set, the nonce is
+// derived from p and the file is not saved again if it already exists.
+func (r *Repository) saveUnpacked(ctx context.Context, t restic.FileType, p []byte, deterministic bool) (id restic.ID, err error) {
+	var nonce []byte
+	if deterministic {
+		nonce = r.key.NewDeterministicNonce(p)
+	} else {
+		nonce = crypto.NewRandomNonce()
+	}
+
 	ciphertext := restic.NewBlobBuffer(len(p))
 	ciphertext = ciphertext[:0]
-	nonce := crypto.NewRandomNonce()
 	ciphertext = append(ciphertext, nonce...)
 
 	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
@@ -295,7 +342,24 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	id = restic.Hash(ciphertext)
 	h := restic.Handle{Type: t, Name: id.String()}
 
-	err = r.be.Save(ctx, h, restic.NewByteReader(ciphertext))
+	if deterministic {
+		exists, err := r.be.Test(ctx, h)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		if exists {
+			debug.Log("blob %v already exists", h)
+			return id, nil
+		}
+	}
+
+	if t == restic.LockFile {
+		// a truncated lock file left behind by an interrupted process cannot
+		// be decoded, so save lock files atomically if possible
+		err = restic.SaveAtomic(ctx, r.be, h, restic.NewByteReader(ciphertext))
+	} else {
+		err = r.be.Save(ctx, h, restic.NewByteReader(ciphertext))
+	}
 	if err != nil {
 		debug.Log("error saving blob %v: %v", h, err)
 		return restic.ID{}, err
@@ -576,20 +640,62 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
-	r.key = key.master
-	r.dataPM.key = key.master
-	r.treePM.key = key.master
+	r.setKey(key.master)
 	r.keyName = key.Name()
 	r.cfg, err = restic.LoadConfig(ctx, r)
 	if err != nil {
-		return errors.Fatalf("config cannot be loaded: %v", err)
+		// the config may already be encrypted with the new master key of an
+		// interrupted key rotation
+		master, cfg, rerr := openKeyRotation(ctx, r.be, key.master)
+		if rerr != nil {
+			debug.Log("no key rotation found: %v", rerr)
+			return errors.Fatalf("config cannot be loaded: %v", err)
+		}
+
+		debug.Log("using the new master key of an interrupted key rotation")
+		r.setKey(master)
+		r.cfg = cfg
 	}
 	return nil
 }
 
+// setKey sets the master key used to encrypt and decrypt data.
+func (r *Repository) setKey(key *crypto.Key) {
+	r.key = key
+	r.dataPM.key = key
+	r.treePM.key = key
+}
+
+// InitOptions configures a new repository.
+type InitOptions struct {
+	// ChunkerAverageSize is the average size of data chunks, zero selects
+	// the default.
+	ChunkerAverageSize uint
+
+	// PackSize is the target size of pack files, zero selects the default.
+	PackSize uint
+
+	// AuthenticateBlobIDs configures that the IDs of blobs are authenticated
+	// when they are decrypted. The repository is created with version 2.
+	AuthenticateBlobIDs bool
+
+	// SplitTrees allows the metadata of large directories to be split into
+	// several tree blobs. The repository is created with version 2.
+	SplitTrees bool
+
+	// DefaultHost and DefaultTags are stored in the config and applied to
+	// all new snapshots.
+	DefaultHost string
+	DefaultTags []string
+
+	// Key selects the key derivation function for the first key.
+	Key KeyOptions
+}
+
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string) error {
+// saves the repository config. The repository is created with the lowest
+// version which supports the options.
+func (r *Repository) Init(ctx context.Context, password string, opts InitOptions) error {
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -603,20 +709,65 @@ func (r *Repository) Init(ctx context.Context, password string) error {
 		return err
 	}
 
-	return r.init(ctx, password, cfg)
+	if opts.ChunkerAverageSize != 0 {
+		if err := restic.CheckChunkerAverageSize(opts.ChunkerAverageSize); err != nil {
+			return err
+		}
+		cfg.ChunkerAverageSize = opts.ChunkerAverageSize
+	}
+
+	if opts.PackSize != 0 {
+		if err := restic.CheckPackSize(opts.PackSize); err != nil {
+			return err
+		}
+		cfg.PackSize = opts.PackSize
+	}
+
+	cfg.AuthenticateBlobIDs = opts.AuthenticateBlobIDs
+	cfg.SplitTrees = opts.SplitTrees
+	cfg.DefaultHost = opts.DefaultHost
+	cfg.DefaultTags = opts.DefaultTags
+	cfg.Version = cfg.RequiredVersion()
+
+	return r.init(ctx, password, cfg, opts.Key)
+}
+
+// SaveConfig replaces the config of the repository with cfg. The config is
+// saved atomically if the backend supports it, backends which refuse to
+// overwrite files return an error and the old config is kept.
+func (r *Repository) SaveConfig(ctx context.Context, cfg restic.Config) error {
+	if cfg.Version < cfg.RequiredVersion() {
+		return errors.Errorf("repository version %d does not support the configured features", cfg.Version)
+	}
+
+	plaintext, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := restic.NewBlobBuffer(len(plaintext))
+	ciphertext = append(ciphertext[:0], nonce...)
+	ciphertext = r.key.Seal(ciphertext, nonce, plaintext, nil)
+
+	err = restic.SaveAtomic(ctx, r.be, restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader(ciphertext))
+	if err != nil {
+		return err
+	}
+
+	r.cfg = cfg
+	return nil
 }
 
 // init creates a new master key with the supplied password and uses it to save
 // the config into the repo.
-func (r *Repository) init(ctx context.Context, password string, cfg restic.Config) error {
-	key, err := createMasterKey(r, password)
+func (r *Repository) init(ctx context.Context, password string, cfg restic.Config, keyOpts KeyOptions) error {
+	key, err := createMasterKey(r, password, keyOpts)
 	if err != nil {
 		return err
 	}
 
-	r.key = key.master
-	r.dataPM.key = key.master
-	r.treePM.key = key.master
+	r.setKey(key.master)
 	r.keyName = key.Name()
 	r.cfg = cfg
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
@@ -705,7 +856,69 @@ func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte
 }
 
 // LoadTree loads a tree from the repository.
+//
+// If the tree was split into chunks, the nodes of all chunks are loaded and
+// joined, the IDs of the chunks are available in the Chunks field.
 func (r *Repository) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	if r.treeCache == nil {
+		return r.loadJoinedTree(ctx, id)
+	}
+
+	if t, ok := r.treeCache.Get(id); ok {
+		debug.Log("tree %v found in tree cache", id)
+		return t, nil
+	}
+
+	t, err := r.loadJoinedTree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for _, blobID := range append(restic.IDs{id}, t.Chunks...) {
+		blobSize, _ := r.idx.LookupSize(blobID, restic.TreeBlob)
+		size += int(blobSize)
+	}
+	r.treeCache.Add(id, t, size)
+
+	return t, nil
+}
+
+// loadJoinedTree loads the tree with the given id and joins its chunks.
+func (r *Repository) loadJoinedTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	t, err := r.loadTree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Chunks) == 0 {
+		return t, nil
+	}
+
+	if len(t.Nodes) > 0 {
+		return nil, errors.Errorf("tree %v contains both nodes and chunks", id.Str())
+	}
+
+	for _, chunkID := range t.Chunks {
+		chunk, err := r.loadTree(ctx, chunkID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chunk.Chunks) > 0 {
+			return nil, errors.Errorf("chunk %v of tree %v is split again", chunkID.Str(), id.Str())
+		}
+
+		t.Nodes = append(t.Nodes, chunk.Nodes...)
+	}
+
+	debug.Log("joined %d chunks of tree %v, %d nodes", len(t.Chunks), id, len(t.Nodes))
+	return t, nil
+}
+
+// loadTree loads and decodes the tree blob with the given id, without
+// joining chunks.
+func (r *Repository) loadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
 	debug.Log("load tree %v", id)
 
 	size, found := r.idx.LookupSize(id, restic.TreeBlob)
@@ -733,9 +946,10 @@ func (r *Repository) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree,
 
 // SaveTree stores a tree into the repository and returns the ID. The ID is
 // checked against the index. The tree is only stored when the index does not
-// contain the ID.
+// contain the ID. The tree is never split, the Chunks field of a loaded tree
+// is dropped as the nodes have already been joined.
 func (r *Repository) SaveTree(ctx context.Context, t *restic.Tree) (restic.ID, error) {
-	buf, err := json.Marshal(t)
+	buf, err := json.Marshal(&restic.Tree{Nodes: t.Nodes})
 	if err != nil {
 		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
 	}
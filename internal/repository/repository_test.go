@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
@@ -58,6 +62,175 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestPackSize(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	const blobSize = 512 << 10
+
+	for _, packSize := range []uint{0, 6 << 20, 9 << 20} {
+		t.Run(fmt.Sprintf("%d", packSize), func(t *testing.T) {
+			be := mem.New()
+			repo := repository.New(be)
+			rtest.OK(t, repo.Init(context.TODO(), rtest.TestPassword, repository.InitOptions{PackSize: packSize}))
+
+			want := packSize
+			if want == 0 {
+				want = restic.DefaultPackSize
+			}
+			rtest.Equals(t, want, repo.Config().TargetPackSize())
+
+			data := make([]byte, blobSize)
+			for i := 0; i < 64; i++ {
+				_, err := io.ReadFull(rnd, data)
+				rtest.OK(t, err)
+				_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+				rtest.OK(t, err)
+			}
+			rtest.OK(t, repo.Flush(context.TODO()))
+
+			// all packs but the one written by Flush() must have been saved
+			// right after they reached the configured size
+			small := 0
+			rtest.OK(t, be.List(context.TODO(), restic.DataFile, func(fi restic.FileInfo) error {
+				size := uint(fi.Size)
+				if size < want {
+					small++
+					return nil
+				}
+
+				if size > want+blobSize+64<<10 {
+					t.Errorf("pack %v is too large: %d bytes, target is %d", fi.Name, size, want)
+				}
+				return nil
+			}))
+
+			rtest.Assert(t, small <= 1, "found %d packs smaller than %d bytes", small, want)
+		})
+	}
+
+	for _, packSize := range []uint{1 << 20, 256 << 20} {
+		repo := repository.New(mem.New())
+		err := repo.Init(context.TODO(), rtest.TestPassword, repository.InitOptions{PackSize: packSize})
+		rtest.Assert(t, err != nil, "expected error for pack size %d, got none", packSize)
+	}
+}
+
+func TestAuthenticateBlobIDs(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	for _, authenticate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("%v", authenticate), func(t *testing.T) {
+			ctx := context.TODO()
+			repo := repository.New(mem.New())
+			rtest.OK(t, repo.Init(ctx, rtest.TestPassword, repository.InitOptions{AuthenticateBlobIDs: authenticate}))
+
+			cfg, err := restic.LoadConfig(ctx, repo)
+			rtest.OK(t, err)
+			rtest.Equals(t, authenticate, cfg.AuthenticateBlobIDs)
+			if authenticate {
+				rtest.Equals(t, uint(2), cfg.Version)
+			} else {
+				rtest.Equals(t, uint(restic.RepoVersion), cfg.Version)
+			}
+
+			id1, err := repo.SaveBlob(ctx, restic.DataBlob, []byte("foo"), restic.ID{})
+			rtest.OK(t, err)
+			id2, err := repo.SaveBlob(ctx, restic.DataBlob, []byte("bar"), restic.ID{})
+			rtest.OK(t, err)
+			rtest.OK(t, repo.Flush(ctx))
+
+			buf := restic.NewBlobBuffer(3)
+			n, err := repo.LoadBlob(ctx, restic.DataBlob, id1, buf)
+			rtest.OK(t, err)
+			rtest.Equals(t, []byte("foo"), buf[:n])
+
+			// serve the data of the second blob for the first one
+			blobs, found := repo.Index().Lookup(id2, restic.DataBlob)
+			rtest.Assert(t, found, "blob %v not found", id2.Str())
+			relocated := blobs[0]
+			relocated.ID = id1
+
+			idx := repository.NewIndex()
+			idx.Store(relocated)
+			mi := repository.NewMasterIndex()
+			mi.Insert(idx)
+			rtest.OK(t, repo.SetIndex(mi))
+
+			_, err = repo.LoadBlob(ctx, restic.DataBlob, id1, buf)
+			rtest.Assert(t, err != nil, "relocated blob was loaded")
+			if authenticate {
+				rtest.Assert(t, strings.Contains(err.Error(), "decrypting blob"), "relocated blob was decrypted: %v", err)
+			} else {
+				rtest.Assert(t, strings.Contains(err.Error(), "invalid hash"), "unexpected error %v", err)
+			}
+		})
+	}
+}
+
+// corruptingBackend flips a bit in the header of all pack files it loads.
+type corruptingBackend struct {
+	restic.Backend
+}
+
+func (b corruptingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) error {
+	if h.Type != restic.DataFile {
+		return b.Backend.Load(ctx, h, length, offset, consumer)
+	}
+
+	return b.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		// the last byte of the encrypted header is followed by its length
+		if len(buf) > 4 {
+			buf[len(buf)-5] ^= 1
+		}
+		return consumer(bytes.NewReader(buf))
+	})
+}
+
+func TestVerifyUploads(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	var tests = []struct {
+		be      func(restic.Backend) restic.Backend
+		corrupt bool
+	}{
+		{func(be restic.Backend) restic.Backend { return be }, false},
+		{func(be restic.Backend) restic.Backend { return corruptingBackend{Backend: be} }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("corrupt-%v", test.corrupt), func(t *testing.T) {
+			ctx := context.TODO()
+			repo := repository.New(test.be(mem.New()))
+			rtest.OK(t, repo.Init(ctx, rtest.TestPassword, repository.InitOptions{}))
+			repo.VerifyUploads()
+
+			data := make([]byte, 1000)
+			_, err := io.ReadFull(rnd, data)
+			rtest.OK(t, err)
+
+			id, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{})
+			rtest.OK(t, err)
+
+			err = repo.Flush(ctx)
+			if test.corrupt {
+				rtest.Assert(t, err != nil && strings.Contains(err.Error(), "verify uploaded pack"),
+					"expected verification error, got %v", err)
+				return
+			}
+			rtest.OK(t, err)
+
+			buf := make([]byte, restic.CiphertextLength(len(data)))
+			n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+			rtest.OK(t, err)
+			rtest.Equals(t, data, buf[:n])
+		})
+	}
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -0,0 +1,654 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// keyRotationName is the name of the file in the keys directory which holds
+// the state of an unfinished master key rotation. It is not a valid ID, so the
+// file is ignored when searching for keys.
+const keyRotationName = "rotation"
+
+var keyRotationHandle = restic.Handle{Type: restic.KeyFile, Name: keyRotationName}
+
+// keyRotationSavepoint is the number of packs after which the index for the
+// rewritten packs is saved, so that an interrupted rotation can be resumed.
+var keyRotationSavepoint = 100
+
+// keyRotationState is stored in the repository while the master key is
+// rotated, encrypted with the old master key.
+type keyRotationState struct {
+	Created time.Time     `json:"created"`
+	Master  *crypto.Key   `json:"master"`
+	Config  restic.Config `json:"config"`
+}
+
+// loadKeyRotationState returns the state of an unfinished key rotation, which
+// is decrypted with key. If no rotation is in progress, nil is returned.
+func loadKeyRotationState(ctx context.Context, be restic.Backend, key *crypto.Key) (*keyRotationState, error) {
+	buf, err := backend.LoadAll(ctx, nil, be, keyRotationHandle)
+	if be.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < key.NonceSize() {
+		return nil, errors.New("key rotation state is truncated")
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &keyRotationState{}
+	err = json.Unmarshal(plaintext, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	if !state.Master.Valid() {
+		return nil, errors.New("invalid master key in key rotation state")
+	}
+
+	return state, nil
+}
+
+// saveKeyRotationState saves state in the repository, encrypted with key.
+func saveKeyRotationState(ctx context.Context, be restic.Backend, key *crypto.Key, state *keyRotationState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := make([]byte, 0, len(buf)+key.Overhead()+key.NonceSize())
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = key.Seal(ciphertext, nonce, buf, nil)
+
+	return be.Save(ctx, keyRotationHandle, restic.NewByteReader(ciphertext))
+}
+
+// KeyRotation replaces the master key of a repository by a new random key. All
+// files encrypted with the old master key are rewritten using the new key, the
+// old files are removed at the end. When the rotation is interrupted, the
+// repository can only be used again after the rotation has been resumed by
+// calling NewKeyRotation again.
+type KeyRotation struct {
+	repo    *Repository
+	newRepo *Repository
+	state   *keyRotationState
+	resumed bool
+
+	// oldIdx contains all index files encrypted with the old key
+	oldIdx     *MasterIndex
+	oldIndexes restic.IDSet
+
+	// keysOnly is set when only the key files remain to be replaced
+	keysOnly bool
+}
+
+// NewKeyRotation starts a new master key rotation for repo or resumes an
+// unfinished one. It loads all index files, so repo.LoadIndex() must not have
+// been called before. Afterwards, RewritePacks() and Finish() must be called.
+func NewKeyRotation(ctx context.Context, repo *Repository) (*KeyRotation, error) {
+	kr := &KeyRotation{
+		repo:       repo,
+		oldIdx:     NewMasterIndex(),
+		oldIndexes: restic.NewIDSet(),
+	}
+
+	state, err := loadKeyRotationState(ctx, repo.be, repo.key)
+	switch {
+	case err == nil && state == nil:
+		debug.Log("starting new key rotation")
+		state = &keyRotationState{
+			Created: time.Now(),
+			Master:  crypto.NewRandomKey(),
+			Config:  repo.cfg,
+		}
+		err = saveKeyRotationState(ctx, repo.be, repo.key, state)
+		if err != nil {
+			return nil, err
+		}
+	case err == nil:
+		debug.Log("resuming key rotation started at %v", state.Created)
+		kr.resumed = true
+	case errors.Cause(err) == crypto.ErrUnauthenticated:
+		// the repository has been opened with the new master key, so all
+		// files except for the keys have already been rewritten
+		debug.Log("resuming key rotation, only the keys remain")
+		kr.resumed = true
+		kr.keysOnly = true
+		kr.newRepo = repo
+		return kr, nil
+	default:
+		return nil, err
+	}
+
+	kr.state = state
+	kr.newRepo = New(repo.be)
+	kr.newRepo.setKey(state.Master)
+	kr.newRepo.cfg = state.Config
+	kr.newRepo.Cache = repo.Cache
+
+	err = kr.loadIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+// Resumed returns true if an unfinished key rotation is continued.
+func (kr *KeyRotation) Resumed() bool {
+	return kr.resumed
+}
+
+// loadIndexes loads all index files, either with the new or the old key.
+func (kr *KeyRotation) loadIndexes(ctx context.Context) error {
+	return kr.repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		idx, err := LoadIndex(ctx, kr.newRepo, id)
+		if err == nil {
+			kr.newRepo.idx.Insert(idx)
+			return nil
+		}
+
+		if errors.Cause(err) != crypto.ErrUnauthenticated {
+			return errors.Wrap(err, fmt.Sprintf("unable to load index %v", id.Str()))
+		}
+
+		idx, err = LoadIndex(ctx, kr.repo, id)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to load index %v", id.Str()))
+		}
+
+		kr.oldIdx.Insert(idx)
+		kr.oldIndexes.Insert(id)
+		return nil
+	})
+}
+
+// Packs returns the packs which still need to be rewritten with the new key,
+// i.e. those which contain blobs not yet contained in a rewritten pack.
+func (kr *KeyRotation) Packs() restic.IDs {
+	if kr.keysOnly {
+		return nil
+	}
+
+	packs := restic.NewIDSet()
+	for _, idx := range kr.oldIdx.All() {
+		for id := range idx.Packs() {
+			packs.Insert(id)
+		}
+	}
+
+	var ids restic.IDs
+	for id := range packs {
+		for _, blob := range kr.oldIdx.ListPack(id) {
+			if !kr.newRepo.idx.Has(blob.ID, blob.Type) {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+
+	sort.Sort(ids)
+	return ids
+}
+
+// RewritePacks rewrites all blobs in packs using the new key. The index for
+// the new packs is saved regularly, so that the work is not lost when the
+// rotation is interrupted.
+func (kr *KeyRotation) RewritePacks(ctx context.Context, packs restic.IDs, p *restic.Progress) error {
+	p.Start()
+	defer p.Done()
+
+	for i, packID := range packs {
+		err := kr.rewritePack(ctx, packID)
+		if err != nil {
+			return err
+		}
+
+		if (i+1)%keyRotationSavepoint == 0 {
+			if err = kr.savepoint(ctx); err != nil {
+				return err
+			}
+		}
+
+		p.Report(restic.Stat{Blobs: 1})
+	}
+
+	return kr.savepoint(ctx)
+}
+
+// savepoint saves all new packs and their index.
+func (kr *KeyRotation) savepoint(ctx context.Context) error {
+	if err := kr.newRepo.Flush(ctx); err != nil {
+		return err
+	}
+
+	return kr.newRepo.SaveIndex(ctx)
+}
+
+func (kr *KeyRotation) rewritePack(ctx context.Context, packID restic.ID) error {
+	h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+
+	tempfile, hash, packLength, err := DownloadAndHash(ctx, kr.repo.be, h)
+	if err != nil {
+		return errors.Wrap(err, "RewritePacks")
+	}
+
+	defer func() {
+		_ = tempfile.Close()
+		_ = fs.RemoveIfExists(tempfile.Name())
+	}()
+
+	if !packID.Equal(hash) {
+		return errors.Errorf("hash does not match id: want %v, got %v", packID, hash)
+	}
+
+	blobs, err := pack.List(kr.repo.key, tempfile, packLength)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("rewriting pack %v, blobs: %v", packID, len(blobs))
+	var buf []byte
+	for _, entry := range blobs {
+		if kr.newRepo.idx.Has(entry.ID, entry.Type) {
+			continue
+		}
+
+		if uint(cap(buf)) < entry.Length {
+			buf = make([]byte, entry.Length)
+		}
+		buf = buf[:entry.Length]
+
+		n, err := tempfile.ReadAt(buf, int64(entry.Offset))
+		if err != nil {
+			return errors.Wrap(err, "ReadAt")
+		}
+
+		if n != len(buf) {
+			return errors.Errorf("read blob %v from %v: not enough bytes read, want %v, got %v",
+				entry.ID, packID, len(buf), n)
+		}
+
+		nonce, ciphertext := buf[:kr.repo.key.NonceSize()], buf[kr.repo.key.NonceSize():]
+		plaintext, err := kr.repo.key.Open(ciphertext[:0], nonce, ciphertext, kr.repo.cfg.BlobAdditionalData(entry.ID))
+		if err != nil {
+			return err
+		}
+
+		if !restic.Hash(plaintext).Equal(entry.ID) {
+			return errors.Errorf("blob %v in pack %v is damaged", entry.ID.Str(), packID.Str())
+		}
+
+		_, err = kr.newRepo.SaveBlob(ctx, entry.Type, plaintext, entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finish rewrites the snapshots and the config with the new key, removes all
+// files encrypted with the old key, and replaces all keys by a single new key
+// for password, which is derived with the KDF selected in keyOpts. All packs
+// must have been rewritten before.
+func (kr *KeyRotation) Finish(ctx context.Context, password string, keyOpts KeyOptions) (*Key, error) {
+	if !kr.keysOnly {
+		if len(kr.Packs()) > 0 {
+			return nil, errors.New("not all packs have been rewritten")
+		}
+
+		if err := kr.rewriteSnapshots(ctx); err != nil {
+			return nil, err
+		}
+
+		if err := kr.removeOldFiles(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := kr.rewriteConfig(ctx); err != nil {
+		return nil, err
+	}
+
+	if !kr.keysOnly {
+		// from now on, the repository is used with the new key
+		kr.repo.setKey(kr.newRepo.key)
+		kr.repo.idx = kr.newRepo.idx
+	}
+
+	return kr.replaceKeys(ctx, password, keyOpts)
+}
+
+// rewriteSnapshots encrypts all snapshots with the new key. Rewriting a
+// snapshot changes its ID, so the references to other snapshots in the fields
+// parent and original are replaced by the new IDs. The snapshots are rewritten
+// in dependency order for this, and the old files are removed in reverse
+// order, so that an interrupted rotation can still map all references when it
+// is resumed.
+func (kr *KeyRotation) rewriteSnapshots(ctx context.Context) error {
+	old := make(map[restic.ID]*rotatedSnapshot)
+	newSnapshots := make(map[restic.ID]restic.ID)
+	err := kr.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		buf, err := kr.newRepo.LoadAndDecrypt(ctx, nil, restic.SnapshotFile, id)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			old[id] = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		newSnapshots[restic.Hash(buf)] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range old {
+		buf, err := kr.repo.LoadAndDecrypt(ctx, nil, restic.SnapshotFile, id)
+		if err != nil {
+			return err
+		}
+
+		sn, err := newRotatedSnapshot(buf)
+		if err != nil {
+			return errors.Wrapf(err, "snapshot %v", id.Str())
+		}
+		old[id] = sn
+	}
+
+	order, err := rotationOrder(old)
+	if err != nil {
+		return err
+	}
+
+	newIDs := make(map[restic.ID]restic.ID)
+	for _, id := range order {
+		buf, err := old[id].remap(newIDs)
+		if err != nil {
+			return err
+		}
+
+		// the snapshot may have been rewritten before the rotation was
+		// interrupted, but the old file was not removed yet
+		newID, ok := newSnapshots[restic.Hash(buf)]
+		if !ok {
+			newID, err = kr.newRepo.SaveUnpacked(ctx, restic.SnapshotFile, buf)
+			if err != nil {
+				return err
+			}
+		}
+		debug.Log("snapshot %v rewritten as %v", id, newID)
+		newIDs[id] = newID
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		err = kr.repo.be.Remove(ctx, restic.Handle{Type: restic.SnapshotFile, Name: order[i].String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatedSnapshot is a snapshot which is rewritten with the new key. Only the
+// references to other snapshots are decoded, all other fields are kept as
+// they are.
+type rotatedSnapshot struct {
+	buf    []byte
+	fields map[string]json.RawMessage
+	refs   map[string]restic.ID
+}
+
+// snapshotRefFields are the fields of a snapshot which contain the ID of
+// another snapshot.
+var snapshotRefFields = []string{"parent", "original"}
+
+func newRotatedSnapshot(buf []byte) (*rotatedSnapshot, error) {
+	sn := &rotatedSnapshot{buf: buf, refs: make(map[string]restic.ID)}
+	err := json.Unmarshal(buf, &sn.fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	for _, field := range snapshotRefFields {
+		raw, ok := sn.fields[field]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+
+		var id restic.ID
+		err = json.Unmarshal(raw, &id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %v", field)
+		}
+		sn.refs[field] = id
+	}
+
+	return sn, nil
+}
+
+// remap returns the snapshot with the references replaced by the new IDs in
+// newIDs. If no reference is replaced, the snapshot is returned unchanged.
+func (sn *rotatedSnapshot) remap(newIDs map[restic.ID]restic.ID) ([]byte, error) {
+	changed := false
+	for field, id := range sn.refs {
+		newID, ok := newIDs[id]
+		if !ok {
+			continue
+		}
+
+		raw, err := json.Marshal(newID)
+		if err != nil {
+			return nil, errors.Wrap(err, "Marshal")
+		}
+		sn.fields[field] = raw
+		changed = true
+	}
+
+	if !changed {
+		return sn.buf, nil
+	}
+
+	buf, err := json.Marshal(sn.fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	return buf, nil
+}
+
+// rotationOrder returns the IDs of the snapshots so that each snapshot comes
+// after the snapshots it references.
+func rotationOrder(snapshots map[restic.ID]*rotatedSnapshot) (restic.IDs, error) {
+	ids := make(restic.IDs, 0, len(snapshots))
+	for id := range snapshots {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	order := make(restic.IDs, 0, len(snapshots))
+	done := restic.NewIDSet()
+	visiting := restic.NewIDSet()
+
+	var visit func(id restic.ID) error
+	visit = func(id restic.ID) error {
+		if done.Has(id) {
+			return nil
+		}
+		if visiting.Has(id) {
+			return errors.Errorf("snapshot %v references itself", id.Str())
+		}
+		visiting.Insert(id)
+
+		for _, field := range snapshotRefFields {
+			ref, ok := snapshots[id].refs[field]
+			if _, isOld := snapshots[ref]; ok && isOld {
+				if err := visit(ref); err != nil {
+					return err
+				}
+			}
+		}
+
+		visiting.Delete(id)
+		done.Insert(id)
+		order = append(order, id)
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := visit(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// removeOldFiles removes all index files encrypted with the old key and all
+// packs not referenced by the new index.
+func (kr *KeyRotation) removeOldFiles(ctx context.Context) error {
+	packs := restic.NewIDSet()
+	for _, idx := range kr.newRepo.idx.All() {
+		for id := range idx.Packs() {
+			packs.Insert(id)
+		}
+	}
+
+	var remove restic.IDs
+	err := kr.repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		if !packs.Has(id) {
+			remove = append(remove, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range remove {
+		err = kr.repo.be.Remove(ctx, restic.Handle{Type: restic.DataFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	for id := range kr.oldIndexes {
+		err = kr.repo.be.Remove(ctx, restic.Handle{Type: restic.IndexFile, Name: id.String()})
+		if err != nil && !kr.repo.be.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteConfig encrypts the config with the new key.
+func (kr *KeyRotation) rewriteConfig(ctx context.Context) error {
+	cfg, err := restic.LoadConfig(ctx, kr.newRepo)
+	if err == nil {
+		kr.newRepo.cfg = cfg
+		return nil
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	err = kr.repo.be.Remove(ctx, h)
+	if err != nil && !kr.repo.be.IsNotExist(err) {
+		return err
+	}
+
+	_, err = kr.newRepo.SaveJSONUnpacked(ctx, restic.ConfigFile, kr.newRepo.cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "saving the config failed, it is restored when the key rotation is resumed\n")
+		return err
+	}
+
+	return nil
+}
+
+// replaceKeys adds a key for password and removes all other keys and the key
+// rotation state.
+func (kr *KeyRotation) replaceKeys(ctx context.Context, password string, keyOpts KeyOptions) (*Key, error) {
+	key, err := AddKey(ctx, kr.repo, password, kr.repo.key, keyOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var remove []string
+	err = kr.repo.be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		if fi.Name != key.Name() && fi.Name != keyRotationName {
+			remove = append(remove, fi.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range remove {
+		err = kr.repo.be.Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: name})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = kr.repo.be.Remove(ctx, keyRotationHandle)
+	if err != nil && !kr.repo.be.IsNotExist(err) {
+		return nil, err
+	}
+
+	kr.repo.keyName = key.Name()
+	return key, nil
+}
+
+// openKeyRotation is called when the config cannot be decrypted with key. If
+// an interrupted key rotation has already rewritten the config, the new master
+// key is returned together with the config.
+func openKeyRotation(ctx context.Context, be restic.Backend, key *crypto.Key) (*crypto.Key, restic.Config, error) {
+	state, err := loadKeyRotationState(ctx, be, key)
+	if err != nil {
+		return nil, restic.Config{}, err
+	}
+	if state == nil {
+		return nil, restic.Config{}, errors.New("no key rotation in progress")
+	}
+
+	repo := New(be)
+	repo.setKey(state.Master)
+	cfg, err := restic.LoadConfig(ctx, repo)
+	if err == nil {
+		return state.Master, cfg, nil
+	}
+
+	// the config may have been removed just before it was saved again
+	has, terr := be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
+	if terr == nil && !has {
+		return state.Master, state.Config, nil
+	}
+
+	return nil, restic.Config{}, err
+}
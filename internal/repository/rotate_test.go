@@ -0,0 +1,331 @@
+package repository
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// rotationTestBackend fails to save data files after an index has been saved,
+// or to remove key or snapshot files, once enabled.
+type rotationTestBackend struct {
+	restic.Backend
+
+	m                   sync.Mutex
+	failSaves           bool
+	indexSaved          bool
+	failRemoves         bool
+	failSnapshotRemoves bool
+}
+
+func (be *rotationTestBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	be.m.Lock()
+	if be.failSaves && h.Type == restic.IndexFile {
+		be.indexSaved = true
+	}
+	fail := be.failSaves && be.indexSaved && h.Type == restic.DataFile
+	be.m.Unlock()
+
+	if fail {
+		return errors.New("injected error")
+	}
+
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *rotationTestBackend) Remove(ctx context.Context, h restic.Handle) error {
+	be.m.Lock()
+	fail := (be.failRemoves && h.Type == restic.KeyFile) ||
+		(be.failSnapshotRemoves && h.Type == restic.SnapshotFile)
+	be.m.Unlock()
+
+	if fail {
+		return errors.New("injected error")
+	}
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// saveRotationTestData saves random blobs in several packs, and a snapshot.
+func saveRotationTestData(t testing.TB, repo *Repository) map[restic.ID][]byte {
+	blobs := make(map[restic.ID][]byte)
+	rnd := rand.New(rand.NewSource(23))
+
+	for i := 0; i < 20; i++ {
+		buf := make([]byte, 1000+rnd.Intn(10000))
+		rnd.Read(buf)
+
+		id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{})
+		rtest.OK(t, err)
+		blobs[id] = buf
+
+		if i%2 == 1 {
+			rtest.OK(t, repo.Flush(context.TODO()))
+		}
+	}
+
+	treeID, err := repo.SaveTree(context.TODO(), restic.NewTree())
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+	rtest.OK(t, repo.SaveIndex(context.TODO()))
+
+	sn, err := restic.NewSnapshot([]string{"/foo"}, nil, "host", time.Now())
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+	_, err = repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	return blobs
+}
+
+func openRotationTestRepo(t testing.TB, be restic.Backend) *Repository {
+	repo := New(be)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 0, ""))
+	return repo
+}
+
+func rotateKey(t testing.TB, repo *Repository) {
+	kr, err := NewKeyRotation(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, kr.RewritePacks(context.TODO(), kr.Packs(), nil))
+	_, err = kr.Finish(context.TODO(), rtest.TestPassword, KeyOptions{})
+	rtest.OK(t, err)
+}
+
+// checkRotatedRepo verifies that all data can be read with the new key, and
+// that no file in the repository can be decrypted with the old key anymore.
+func checkRotatedRepo(t testing.TB, be restic.Backend, oldKey *crypto.Key, blobs map[restic.ID][]byte) {
+	ctx := context.TODO()
+	repo := openRotationTestRepo(t, be)
+	rtest.Assert(t, repo.Key().EncryptionKey != oldKey.EncryptionKey, "master key was not replaced")
+	rtest.OK(t, repo.LoadIndex(ctx))
+
+	for id, data := range blobs {
+		buf := make([]byte, len(data)+crypto.Extension)
+		n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf[:n])
+	}
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(snapshots))
+
+	// all packs are referenced by the index
+	packs := restic.NewIDSet()
+	rtest.OK(t, repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+
+		_, err := pack.List(oldKey, restic.ReaderAt(be, restic.Handle{Type: restic.DataFile, Name: id.String()}), size)
+		rtest.Assert(t, err != nil, "pack %v can be read with the old key", id.Str())
+		return nil
+	}))
+	indexed := restic.NewIDSet()
+	for pb := range repo.Index().Each(ctx) {
+		indexed.Insert(pb.PackID)
+	}
+	rtest.Equals(t, indexed, packs)
+
+	old := New(be)
+	old.setKey(oldKey)
+	for _, tpe := range []restic.FileType{restic.IndexFile, restic.SnapshotFile} {
+		rtest.OK(t, repo.List(ctx, tpe, func(id restic.ID, size int64) error {
+			_, err := old.LoadAndDecrypt(ctx, nil, tpe, id)
+			rtest.Assert(t, errors.Cause(err) == crypto.ErrUnauthenticated,
+				"%v %v can be decrypted with the old key", tpe, id.Str())
+			return nil
+		}))
+	}
+
+	_, err = restic.LoadConfig(ctx, old)
+	rtest.Assert(t, errors.Cause(err) == crypto.ErrUnauthenticated, "config can be decrypted with the old key")
+
+	var keys []string
+	rtest.OK(t, be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		keys = append(keys, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{repo.KeyName()}, keys)
+}
+
+func TestKeyRotation(t *testing.T) {
+	be := mem.New()
+	r, cleanup := TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	blobs := saveRotationTestData(t, repo)
+	oldKey := repo.Key()
+
+	// add a second key, which is removed by the rotation
+	_, err := AddKey(context.TODO(), repo, "other password", oldKey, KeyOptions{})
+	rtest.OK(t, err)
+
+	repo = openRotationTestRepo(t, be)
+	rotateKey(t, repo)
+
+	// the repository can still be used with the new key
+	_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, []byte("foo"), restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+	rtest.OK(t, repo.SaveIndex(context.TODO()))
+	blobs[restic.Hash([]byte("foo"))] = []byte("foo")
+
+	checkRotatedRepo(t, be, oldKey, blobs)
+
+	other := New(be)
+	err = other.SearchKey(context.TODO(), "other password", 0, "")
+	rtest.Assert(t, err != nil, "repository can still be opened with the removed key")
+}
+
+func TestKeyRotationResumePacks(t *testing.T) {
+	defer func(n int) {
+		keyRotationSavepoint = n
+	}(keyRotationSavepoint)
+	keyRotationSavepoint = 2
+
+	be := &rotationTestBackend{Backend: mem.New()}
+	r, cleanup := TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	blobs := saveRotationTestData(t, repo)
+	oldKey := repo.Key()
+
+	repo = openRotationTestRepo(t, be)
+	kr, err := NewKeyRotation(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !kr.Resumed(), "new key rotation is reported as resumed")
+	packs := kr.Packs()
+
+	// interrupt the rotation after the first savepoint
+	be.failSaves = true
+	err = kr.RewritePacks(context.TODO(), packs, nil)
+	rtest.Assert(t, err != nil, "expected error, got none")
+	be.failSaves = false
+
+	// the repository is still opened with the old key, and the rotation
+	// continues with the remaining packs
+	repo = openRotationTestRepo(t, be)
+	rtest.Equals(t, oldKey, repo.Key())
+
+	kr, err = NewKeyRotation(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, kr.Resumed(), "key rotation was not resumed")
+	remaining := kr.Packs()
+	rtest.Assert(t, len(remaining) > 0 && len(remaining) < len(packs),
+		"unexpected number of remaining packs %d of %d", len(remaining), len(packs))
+
+	rtest.OK(t, kr.RewritePacks(context.TODO(), remaining, nil))
+	_, err = kr.Finish(context.TODO(), rtest.TestPassword, KeyOptions{})
+	rtest.OK(t, err)
+
+	checkRotatedRepo(t, be, oldKey, blobs)
+}
+
+func TestKeyRotationResumeKeys(t *testing.T) {
+	be := &rotationTestBackend{Backend: mem.New()}
+	r, cleanup := TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	blobs := saveRotationTestData(t, repo)
+	oldKey := repo.Key()
+
+	// interrupt the rotation while the old keys are removed
+	be.failRemoves = true
+	repo = openRotationTestRepo(t, be)
+	kr, err := NewKeyRotation(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, kr.RewritePacks(context.TODO(), kr.Packs(), nil))
+	_, err = kr.Finish(context.TODO(), rtest.TestPassword, KeyOptions{})
+	rtest.Assert(t, err != nil, "expected error, got none")
+	be.failRemoves = false
+
+	// both the old and the new key file open the repository with the new key
+	keys := 0
+	rtest.OK(t, repo.List(context.TODO(), restic.KeyFile, func(id restic.ID, size int64) error {
+		keys++
+
+		k := New(be)
+		rtest.OK(t, k.SearchKey(context.TODO(), rtest.TestPassword, 0, id.String()))
+		rtest.Assert(t, k.Key().EncryptionKey != oldKey.EncryptionKey, "key %v opens the repository with the old master key", id.Str())
+		return nil
+	}))
+	rtest.Equals(t, 2, keys)
+
+	rotateKey(t, openRotationTestRepo(t, be))
+	checkRotatedRepo(t, be, oldKey, blobs)
+}
+
+func TestKeyRotationSnapshotReferences(t *testing.T) {
+	be := &rotationTestBackend{Backend: mem.New()}
+	r, cleanup := TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*Repository)
+
+	saveRotationTestData(t, repo)
+
+	// add a chain of snapshots which reference each other
+	snapshots, err := restic.LoadAllSnapshots(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(snapshots))
+	prev := *snapshots[0].ID()
+	for i := 0; i < 3; i++ {
+		sn, err := restic.NewSnapshot([]string{"/foo"}, nil, "host", time.Now())
+		rtest.OK(t, err)
+		sn.Tree = snapshots[0].Tree
+		sn.Parent = &prev
+		if i == 2 {
+			sn.Original = snapshots[0].ID()
+		}
+
+		prev, err = repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+		rtest.OK(t, err)
+	}
+
+	// interrupt the rotation while the old snapshots are removed
+	be.failSnapshotRemoves = true
+	repo = openRotationTestRepo(t, be)
+	kr, err := NewKeyRotation(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, kr.RewritePacks(context.TODO(), kr.Packs(), nil))
+	_, err = kr.Finish(context.TODO(), rtest.TestPassword, KeyOptions{})
+	rtest.Assert(t, err != nil, "expected error, got none")
+	be.failSnapshotRemoves = false
+
+	rotateKey(t, openRotationTestRepo(t, be))
+
+	repo = openRotationTestRepo(t, be)
+	snapshots, err = restic.LoadAllSnapshots(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 4, len(snapshots))
+
+	// all references point to the rewritten snapshots
+	ids := restic.NewIDSet()
+	for _, sn := range snapshots {
+		ids.Insert(*sn.ID())
+	}
+	parents, originals := 0, 0
+	for _, sn := range snapshots {
+		if sn.Parent != nil {
+			parents++
+			rtest.Assert(t, ids.Has(*sn.Parent), "snapshot %v references unknown parent %v", sn.ID().Str(), sn.Parent.Str())
+		}
+		if sn.Original != nil {
+			originals++
+			rtest.Assert(t, ids.Has(*sn.Original), "snapshot %v references unknown original %v", sn.ID().Str(), sn.Original.Str())
+		}
+	}
+	rtest.Equals(t, 3, parents)
+	rtest.Equals(t, 1, originals)
+}
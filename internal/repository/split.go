@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// FindOversizedPacks returns the packs in the backend which are larger than
+// maxSize bytes.
+func FindOversizedPacks(ctx context.Context, repo restic.Repository, maxSize int64) (restic.IDSet, error) {
+	packs := restic.NewIDSet()
+	err := repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		if size > maxSize {
+			debug.Log("pack %v is oversized (%d bytes)", id, size)
+			packs.Insert(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return packs, nil
+}
+
+// SplitPacks saves the blobs of packs again, the repository's packer then
+// distributes them on packs of the configured size. The index is saved after
+// each pack, the blobs which are already contained in another pack are not
+// saved again. An interrupted run can therefore be resumed by calling
+// SplitPacks again for the same packs.
+//
+// All packs are returned as obsolete, they must be removed from the index
+// and the backend afterwards.
+func SplitPacks(ctx context.Context, repo restic.Repository, packs restic.IDSet, p *restic.Progress) (obsoletePacks restic.IDSet, err error) {
+	debug.Log("splitting %d packs", len(packs))
+
+	// collect the blobs contained in packs
+	blobs := make(map[restic.ID]restic.BlobSet)
+	all := restic.NewBlobSet()
+	for pb := range repo.Index().Each(ctx) {
+		if !packs.Has(pb.PackID) {
+			continue
+		}
+
+		h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+		if blobs[pb.PackID] == nil {
+			blobs[pb.PackID] = restic.NewBlobSet()
+		}
+		blobs[pb.PackID].Insert(h)
+		all.Insert(h)
+	}
+
+	// find the blobs which are also contained in other packs, e.g. because
+	// they have been saved by an interrupted run
+	stored := restic.NewBlobSet()
+	for pb := range repo.Index().Each(ctx) {
+		h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+		if !packs.Has(pb.PackID) && all.Has(h) {
+			stored.Insert(h)
+		}
+	}
+
+	for packID := range packs {
+		keepBlobs := restic.NewBlobSet()
+		for h := range blobs[packID] {
+			if !stored.Has(h) {
+				keepBlobs.Insert(h)
+			}
+		}
+
+		if len(keepBlobs) > 0 {
+			debug.Log("splitting pack %v, saving %d of %d blobs", packID, len(keepBlobs), len(blobs[packID]))
+			stored.Merge(keepBlobs)
+
+			// Repack removes the saved blobs from the set
+			_, err = Repack(ctx, repo, restic.NewIDSet(packID), keepBlobs, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			// save the index for the new packs, so that their blobs are not
+			// saved again if the operation is interrupted
+			err = repo.SaveIndex(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if p != nil {
+			p.Report(restic.Stat{Blobs: 1})
+		}
+	}
+
+	return packs, nil
+}
@@ -0,0 +1,93 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// saveOversizedPack saves the data as blobs in a single pack, bypassing the
+// packer of the repository.
+func saveOversizedPack(t *testing.T, repo restic.Repository, data [][]byte) restic.ID {
+	buf := bytes.NewBuffer(nil)
+	p := pack.NewPacker(repo.Key(), buf)
+	for _, d := range data {
+		nonce := crypto.NewRandomNonce()
+		ciphertext := repo.Key().Seal(append([]byte{}, nonce...), nonce, d, nil)
+		_, err := p.Add(restic.DataBlob, restic.Hash(d), ciphertext)
+		rtest.OK(t, err)
+	}
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	id := restic.Hash(buf.Bytes())
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	rtest.OK(t, repo.Backend().Save(context.TODO(), h, restic.NewByteReader(buf.Bytes())))
+	return id
+}
+
+func TestSplitPacks(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	// a pack of the regular size, which is left untouched
+	small := random(t, 100)
+	_, err := repo.SaveBlob(context.TODO(), restic.DataBlob, small, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+	regularPacks := listPacks(t, repo)
+	rtest.Equals(t, 1, len(regularPacks))
+
+	// 40 blobs of 256KiB, the packer writes a pack as soon as 16 blobs
+	// (4MiB) are collected
+	var data [][]byte
+	for i := 0; i < 40; i++ {
+		data = append(data, random(t, 256<<10))
+	}
+	oversized := saveOversizedPack(t, repo, data)
+
+	rebuildIndex(t, repo)
+	reloadIndex(t, repo)
+
+	packs, err := repository.FindOversizedPacks(context.TODO(), repo, 8<<20)
+	rtest.OK(t, err)
+	rtest.Assert(t, packs.Equals(restic.NewIDSet(oversized)), "wrong oversized packs found: %v", packs)
+
+	obsolete, err := repository.SplitPacks(context.TODO(), repo, packs, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, obsolete.Equals(packs), "wrong obsolete packs returned: %v", obsolete)
+
+	// an interrupted run can be resumed, no blob is saved again
+	before := listPacks(t, repo)
+	_, err = repository.SplitPacks(context.TODO(), repo, packs, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, listPacks(t, repo).Equals(before), "resumed split saved new packs")
+
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.DataFile, Name: oversized.String()}))
+	rebuildIndex(t, repo)
+	reloadIndex(t, repo)
+
+	after := listPacks(t, repo)
+	for id := range regularPacks {
+		rtest.Assert(t, after.Has(id), "regular pack %v was removed", id.Str())
+	}
+	rtest.Equals(t, 1+3, len(after))
+
+	packs, err = repository.FindOversizedPacks(context.TODO(), repo, 8<<20)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(packs))
+
+	for _, d := range append(data, small) {
+		id := restic.Hash(d)
+		buf := restic.NewBlobBuffer(len(d))
+		n, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, buf)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf[:n], d), "blob %v has wrong content", id.Str())
+	}
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// treeCache keeps the most recently used decoded trees in memory. The size of
+// the cache is limited by the size of the tree blobs in the repository. It is
+// safe for concurrent use.
+type treeCache struct {
+	m       sync.Mutex
+	maxSize int
+	size    int
+	lru     *list.List
+	entries map[restic.ID]*list.Element
+}
+
+type treeCacheEntry struct {
+	id   restic.ID
+	tree *restic.Tree
+	size int
+}
+
+// newTreeCache returns a cache for trees with a total size of at most maxSize
+// bytes.
+func newTreeCache(maxSize int) *treeCache {
+	return &treeCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[restic.ID]*list.Element),
+	}
+}
+
+// Get returns a copy of the tree with the given ID, if it is in the cache.
+func (c *treeCache) Get(id restic.ID) (*restic.Tree, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+	return copyTree(e.Value.(*treeCacheEntry).tree), true
+}
+
+// Add stores a copy of the tree with the given ID in the cache, size is the
+// size of its tree blobs. The least recently used trees are removed until the
+// cache is small enough. Trees larger than the cache are not stored.
+func (c *treeCache) Add(id restic.ID, tree *restic.Tree, size int) {
+	if size > c.maxSize {
+		debug.Log("tree %v with %d bytes is too large for the cache", id.Str(), size)
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[id] = c.lru.PushFront(&treeCacheEntry{id: id, tree: copyTree(tree), size: size})
+	c.size += size
+
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		entry := e.Value.(*treeCacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, entry.id)
+		c.size -= entry.size
+	}
+}
+
+// copyTree returns a copy of tree, so that callers may modify the nodes of a
+// tree they got from the cache.
+func copyTree(tree *restic.Tree) *restic.Tree {
+	res := &restic.Tree{
+		Nodes:  make([]*restic.Node, len(tree.Nodes)),
+		Chunks: tree.Chunks,
+	}
+	for i, node := range tree.Nodes {
+		n := *node
+		res.Nodes[i] = &n
+	}
+	return res
+}
@@ -0,0 +1,132 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// loadCountingBackend counts the files loaded from the backend.
+type loadCountingBackend struct {
+	restic.Backend
+	loads int32
+}
+
+func (be *loadCountingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	atomic.AddInt32(&be.loads, 1)
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+// saveTestTrees saves a tree with subdirectories nested depth levels deep
+// and returns its ID.
+func saveTestTrees(t testing.TB, repo restic.Repository, name string, depth int) restic.ID {
+	tree := restic.NewTree()
+	for i := 0; i < 3; i++ {
+		node := &restic.Node{Name: fmt.Sprintf("%s-%d", name, i), Type: "file"}
+		if depth > 0 {
+			id := saveTestTrees(t, repo, node.Name, depth-1)
+			node.Type = "dir"
+			node.Subtree = &id
+		}
+		rtest.OK(t, tree.Insert(node))
+	}
+
+	id, err := repo.SaveTree(context.TODO(), tree)
+	rtest.OK(t, err)
+	return id
+}
+
+func TestTreeCache(t *testing.T) {
+	be := &loadCountingBackend{Backend: mem.New()}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	root := saveTestTrees(t, repo, "dir", 3)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	repo.(*repository.Repository).UseTreeCache(1 << 20)
+
+	walk := func() restic.BlobSet {
+		blobs := restic.NewBlobSet()
+		rtest.OK(t, restic.FindUsedBlobs(context.TODO(), repo, root, blobs, restic.NewBlobSet()))
+		return blobs
+	}
+
+	first := walk()
+	loads := atomic.LoadInt32(&be.loads)
+	rtest.Equals(t, 1+3+9+27, int(loads))
+
+	// the second walk hits the cache and does not load anything
+	second := walk()
+	rtest.Equals(t, loads, atomic.LoadInt32(&be.loads))
+	rtest.Assert(t, first.Equals(second), "walks returned different blobs")
+
+	// modifying a tree does not change the cached tree
+	tree, err := repo.LoadTree(context.TODO(), root)
+	rtest.OK(t, err)
+	tree.Nodes[0].Name = "modified"
+	tree, err = repo.LoadTree(context.TODO(), root)
+	rtest.OK(t, err)
+	rtest.Equals(t, "dir-0", tree.Nodes[0].Name)
+
+	// concurrent walks
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blobs := restic.NewBlobSet()
+			err := restic.FindUsedBlobs(context.TODO(), repo, root, blobs, restic.NewBlobSet())
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	rtest.Equals(t, loads, atomic.LoadInt32(&be.loads))
+}
+
+func TestTreeCacheSizeLimit(t *testing.T) {
+	be := &loadCountingBackend{Backend: mem.New()}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	var ids restic.IDs
+	for i := 0; i < 10; i++ {
+		ids = append(ids, saveTestTrees(t, repo, fmt.Sprintf("tree%d", i), 0))
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	size := 0
+	for _, id := range ids {
+		blobSize, found := repo.LookupBlobSize(id, restic.TreeBlob)
+		rtest.Assert(t, found, "tree %v not found", id.Str())
+		size += int(blobSize)
+	}
+
+	// the cache can hold about half of the trees
+	repo.(*repository.Repository).UseTreeCache(size / 2)
+
+	load := func(ids restic.IDs) int {
+		before := atomic.LoadInt32(&be.loads)
+		for _, id := range ids {
+			_, err := repo.LoadTree(context.TODO(), id)
+			rtest.OK(t, err)
+		}
+		return int(atomic.LoadInt32(&be.loads) - before)
+	}
+
+	rtest.Equals(t, 10, load(ids))
+
+	// the most recently used trees are still cached, the others were removed
+	rtest.Equals(t, 0, load(ids[8:]))
+	rtest.Equals(t, 2, load(ids[:2]))
+}
@@ -2,7 +2,14 @@ package restic
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 // Backend is used to store and access data.
@@ -54,8 +61,88 @@ type Backend interface {
 	Delete(ctx context.Context) error
 }
 
-// FileInfo is contains information about a file in the backend.
+// AtomicSaver is implemented by backends which can store a file atomically, so
+// that it is either stored completely or not at all, even if the process is
+// interrupted while writing.
+type AtomicSaver interface {
+	// SaveAtomic stores the data from rd under the given handle. The file
+	// only becomes visible under its final name when all data was written.
+	SaveAtomic(ctx context.Context, h Handle, rd RewindReader) error
+}
+
+// TempFileInfix is inserted between the final name of a file and a random
+// suffix to build the name of the temporary file used by SaveAtomic.
+const TempFileInfix = "-tmp-"
+
+// IsTempFile returns true if name is the name of a temporary file written by
+// SaveAtomic.
+func IsTempFile(name string) bool {
+	return strings.Contains(name, TempFileInfix)
+}
+
+// SaveAtomic stores the data from rd in be. If be implements AtomicSaver, the
+// file is saved atomically, otherwise Save is used.
+func SaveAtomic(ctx context.Context, be Backend, h Handle, rd RewindReader) error {
+	if saver, ok := be.(AtomicSaver); ok {
+		return saver.SaveAtomic(ctx, h, rd)
+	}
+
+	return be.Save(ctx, h, rd)
+}
+
+// RetentionError is returned by Backend.Remove when the file cannot be removed
+// yet because it is protected by a retention policy, e.g. S3 Object Lock.
+type RetentionError struct {
+	Handle Handle
+	Err    error
+}
+
+func (e *RetentionError) Error() string {
+	return fmt.Sprintf("%v is protected by a retention policy: %v", e.Handle, e.Err)
+}
+
+// IsRetentionError returns true if err was caused by a retention policy which
+// prevents removing a file.
+func IsRetentionError(err error) bool {
+	_, ok := errors.Cause(err).(*RetentionError)
+	return ok
+}
+
+// StatusError is returned by backends when a request failed with an HTTP
+// status code.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("server response unexpected: %v", e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+// IsTransientError returns true if err was caused by a condition which may go
+// away when the operation is retried: network errors, server errors (HTTP
+// status 5xx) and rate limiting (HTTP status 429). All other errors, e.g.
+// missing permissions or invalid requests, are permanent.
+func IsTransientError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return false
+	case *StatusError:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	case net.Error:
+		return true
+	}
+
+	return errors.Cause(err) == io.ErrUnexpectedEOF
+}
+
+// FileInfo is contains information about a file in the backend. ModTime is
+// the zero time if the backend does not report modification times.
 type FileInfo struct {
-	Size int64
-	Name string
+	Size    int64
+	Name    string
+	ModTime time.Time
 }
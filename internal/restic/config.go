@@ -2,6 +2,8 @@ package restic
 
 import (
 	"context"
+	"io"
+	"math/bits"
 	"testing"
 
 	"github.com/restic/restic/internal/errors"
@@ -16,12 +18,109 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// ChunkerAverageSize is the target average size of data chunks, zero
+	// selects DefaultChunkerAverageSize.
+	ChunkerAverageSize uint `json:"chunker_average_size,omitempty"`
+
+	// PackSize is the size at which pack files are written to the backend,
+	// zero selects DefaultPackSize.
+	PackSize uint `json:"pack_size,omitempty"`
+
+	// AuthenticateBlobIDs binds each encrypted blob to its ID, which is
+	// passed as additional data to the authenticated encryption. It requires
+	// repository version 2.
+	AuthenticateBlobIDs bool `json:"authenticate_blob_ids,omitempty"`
+
+	// SplitTrees allows saving the metadata of large directories as several
+	// tree blobs. Older versions of restic would show such directories as
+	// empty, so it requires repository version 2.
+	SplitTrees bool `json:"split_trees,omitempty"`
+
+	// DefaultHost is used as the hostname of new snapshots for which no
+	// hostname is given explicitly.
+	DefaultHost string `json:"default_host,omitempty"`
+
+	// DefaultTags are added to the tags of all new snapshots.
+	DefaultTags []string `json:"default_tags,omitempty"`
+}
+
+const (
+	// DefaultChunkerAverageSize is the average chunk size used when none is
+	// configured for the repository.
+	DefaultChunkerAverageSize = 1 << 20
+
+	// MinChunkerAverageSize is the smallest supported average chunk size.
+	MinChunkerAverageSize = 64 << 10
+
+	// MaxChunkerAverageSize is the largest supported average chunk size.
+	MaxChunkerAverageSize = 8 << 20
+)
+
+// CheckChunkerAverageSize returns an error if size cannot be used as the
+// average chunk size. It must be a power of two between
+// MinChunkerAverageSize and MaxChunkerAverageSize.
+func CheckChunkerAverageSize(size uint) error {
+	if size < MinChunkerAverageSize || size > MaxChunkerAverageSize {
+		return errors.Errorf("invalid average chunk size %d, must be between %d and %d",
+			size, MinChunkerAverageSize, MaxChunkerAverageSize)
+	}
+
+	if size&(size-1) != 0 {
+		return errors.Errorf("invalid average chunk size %d, must be a power of two", size)
+	}
+
+	return nil
+}
+
+const (
+	// DefaultPackSize is the pack size used when none is configured for the
+	// repository.
+	DefaultPackSize = 4 << 20
+
+	// MinPackSize is the smallest supported pack size.
+	MinPackSize = 4 << 20
+
+	// MaxPackSize is the largest supported pack size.
+	MaxPackSize = 128 << 20
+)
+
+// CheckPackSize returns an error if size cannot be used as the pack size. It
+// must be between MinPackSize and MaxPackSize.
+func CheckPackSize(size uint) error {
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Errorf("invalid pack size %d, must be between %d and %d",
+			size, MinPackSize, MaxPackSize)
+	}
+
+	return nil
 }
 
 // RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
+// is newly created with Init() and does not use any feature which requires a
+// later version.
 const RepoVersion = 1
 
+const (
+	// MinRepoVersion is the oldest repository version which is supported.
+	MinRepoVersion = 1
+
+	// MaxRepoVersion is the newest repository version which is supported.
+	// Version 2 is required for features which older versions of restic do
+	// not know about and would silently mishandle, they refuse to open such
+	// repositories.
+	MaxRepoVersion = 2
+)
+
+// RequiredVersion returns the minimal repository version for the features
+// enabled in cfg.
+func (cfg Config) RequiredVersion() uint {
+	if cfg.AuthenticateBlobIDs || cfg.SplitTrees {
+		return 2
+	}
+	return RepoVersion
+}
+
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
 	LoadJSONUnpacked(context.Context, FileType, ID, interface{}) error
@@ -77,8 +176,13 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %d", cfg.Version)
+	}
+
+	if cfg.Version < cfg.RequiredVersion() {
+		return Config{}, errors.Errorf("repository version %d does not support the configured features, version %d is required",
+			cfg.Version, cfg.RequiredVersion())
 	}
 
 	if checkPolynomial {
@@ -87,5 +191,68 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		}
 	}
 
+	if cfg.ChunkerAverageSize != 0 {
+		if err := CheckChunkerAverageSize(cfg.ChunkerAverageSize); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if cfg.PackSize != 0 {
+		if err := CheckPackSize(cfg.PackSize); err != nil {
+			return Config{}, err
+		}
+	}
+
 	return cfg, nil
 }
+
+// TargetPackSize returns the configured pack size. Packs are written to the
+// backend as soon as they reach this size.
+func (cfg Config) TargetPackSize() uint {
+	if cfg.PackSize == 0 {
+		return DefaultPackSize
+	}
+	return cfg.PackSize
+}
+
+// BlobAdditionalData returns the additional data for encrypting and
+// decrypting the blob id. If blob IDs are not authenticated, it is nil.
+func (cfg Config) BlobAdditionalData(id ID) []byte {
+	if !cfg.AuthenticateBlobIDs {
+		return nil
+	}
+	return id[:]
+}
+
+// chunkerAverageSize returns the configured average chunk size.
+func (cfg Config) chunkerAverageSize() uint {
+	if cfg.ChunkerAverageSize == 0 {
+		return DefaultChunkerAverageSize
+	}
+	return cfg.ChunkerAverageSize
+}
+
+// ChunkerBoundaries returns the minimal and maximal size of the data chunks.
+// They scale with the average chunk size, for the default average the
+// chunker's defaults chunker.MinSize and chunker.MaxSize are returned.
+func (cfg Config) ChunkerBoundaries() (min, max uint) {
+	avg := cfg.chunkerAverageSize()
+	return avg / 2, avg * 8
+}
+
+// NewChunker returns a chunker for rd which splits the data into chunks as
+// configured for the repository.
+func (cfg Config) NewChunker(rd io.Reader) *chunker.Chunker {
+	min, max := cfg.ChunkerBoundaries()
+	c := chunker.NewWithBoundaries(rd, cfg.ChunkerPolynomial, min, max)
+	c.SetAverageBits(bits.TrailingZeros(cfg.chunkerAverageSize()))
+	return c
+}
+
+// ResetChunker reinitializes c to read from rd with the parameters configured
+// for the repository.
+func (cfg Config) ResetChunker(c *chunker.Chunker, rd io.Reader) {
+	min, max := cfg.ChunkerBoundaries()
+	c.ResetWithBoundaries(rd, cfg.ChunkerPolynomial, min, max)
+	c.SetAverageBits(bits.TrailingZeros(cfg.chunkerAverageSize()))
+}
@@ -1,9 +1,14 @@
 package restic_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"math/rand"
 	"testing"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -50,6 +55,152 @@ func TestConfig(t *testing.T) {
 	cfg2, err := restic.LoadConfig(context.TODO(), loader(load))
 	rtest.OK(t, err)
 
-	rtest.Assert(t, cfg1 == cfg2,
-		"configs aren't equal: %v != %v", cfg1, cfg2)
+	rtest.Equals(t, cfg1, cfg2)
+}
+
+func TestCheckChunkerAverageSize(t *testing.T) {
+	for _, size := range []uint{64 << 10, 512 << 10, 1 << 20, 8 << 20} {
+		rtest.OK(t, restic.CheckChunkerAverageSize(size))
+	}
+
+	for _, size := range []uint{0, 32 << 10, 100 << 10, 3 << 20, 16 << 20} {
+		err := restic.CheckChunkerAverageSize(size)
+		rtest.Assert(t, err != nil, "expected error for size %d, got none", size)
+	}
+}
+
+func TestConfigInvalidChunkerAverageSize(t *testing.T) {
+	cfg, err := restic.CreateConfig()
+	rtest.OK(t, err)
+	cfg.ChunkerAverageSize = 100 << 10
+
+	load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+		*arg.(*restic.Config) = cfg
+		return nil
+	}
+
+	_, err = restic.LoadConfig(context.TODO(), loader(load))
+	rtest.Assert(t, err != nil, "expected error for invalid average chunk size, got none")
+}
+
+func TestCheckPackSize(t *testing.T) {
+	for _, size := range []uint{4 << 20, 5<<20 + 123, 16 << 20, 128 << 20} {
+		rtest.OK(t, restic.CheckPackSize(size))
+	}
+
+	for _, size := range []uint{0, 1 << 20, 4<<20 - 1, 128<<20 + 1, 1 << 30} {
+		err := restic.CheckPackSize(size)
+		rtest.Assert(t, err != nil, "expected error for size %d, got none", size)
+	}
+}
+
+func TestConfigInvalidPackSize(t *testing.T) {
+	cfg, err := restic.CreateConfig()
+	rtest.OK(t, err)
+	cfg.PackSize = 1 << 30
+
+	load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+		*arg.(*restic.Config) = cfg
+		return nil
+	}
+
+	_, err = restic.LoadConfig(context.TODO(), loader(load))
+	rtest.Assert(t, err != nil, "expected error for invalid pack size, got none")
+}
+
+func TestConfigVersion(t *testing.T) {
+	var tests = []struct {
+		version      uint
+		authenticate bool
+		splitTrees   bool
+		valid        bool
+	}{
+		{1, false, false, true},
+		{2, false, false, true},
+		{2, true, false, true},
+		{2, false, true, true},
+		// older versions of restic would open a version 1 repository and
+		// write blobs which are not authenticated
+		{1, true, false, false},
+		// or show split trees as empty directories
+		{1, false, true, false},
+		{0, false, false, false},
+		{3, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d-%v-%v", test.version, test.authenticate, test.splitTrees), func(t *testing.T) {
+			cfg, err := restic.CreateConfig()
+			rtest.OK(t, err)
+			cfg.Version = test.version
+			cfg.AuthenticateBlobIDs = test.authenticate
+			cfg.SplitTrees = test.splitTrees
+
+			load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+				*arg.(*restic.Config) = cfg
+				return nil
+			}
+
+			_, err = restic.LoadConfig(context.TODO(), loader(load))
+			if test.valid {
+				rtest.OK(t, err)
+			} else {
+				rtest.Assert(t, err != nil, "expected error for version %d, got none", test.version)
+			}
+		})
+	}
+}
+
+func TestConfigChunker(t *testing.T) {
+	buf := make([]byte, 32<<20)
+	_, err := rand.New(rand.NewSource(42)).Read(buf)
+	rtest.OK(t, err)
+
+	pol := chunker.Pol(0x3DA3358B4DC173)
+
+	for _, size := range []uint{0, 64 << 10, 256 << 10, 4 << 20} {
+		t.Run(fmt.Sprintf("%d", size), func(t *testing.T) {
+			cfg := restic.Config{ChunkerPolynomial: pol, ChunkerAverageSize: size}
+			min, max := cfg.ChunkerBoundaries()
+
+			avg := size
+			if size == 0 {
+				avg = restic.DefaultChunkerAverageSize
+				rtest.Equals(t, uint(chunker.MinSize), min)
+				rtest.Equals(t, uint(chunker.MaxSize), max)
+			}
+
+			var chunks []chunker.Chunk
+			c := cfg.NewChunker(nil)
+			// make sure the parameters are kept when the chunker is reused
+			for i := 0; i < 2; i++ {
+				chunks = nil
+				cfg.ResetChunker(c, bytes.NewReader(buf))
+				for {
+					chunk, err := c.Next(nil)
+					if err == io.EOF {
+						break
+					}
+					rtest.OK(t, err)
+					chunks = append(chunks, chunk)
+				}
+			}
+
+			for i, chunk := range chunks {
+				if i < len(chunks)-1 && chunk.Length < min {
+					t.Errorf("chunk %d is too small: %d < %d", i, chunk.Length, min)
+				}
+				if chunk.Length > max {
+					t.Errorf("chunk %d is too large: %d > %d", i, chunk.Length, max)
+				}
+			}
+
+			// the content-defined boundaries are found after avg bytes on
+			// average in addition to the minimal size, allow for some
+			// variance as there are only few chunks for large sizes
+			mean := uint(len(buf)) / uint(len(chunks))
+			rtest.Assert(t, mean >= min+avg/4 && mean <= min+4*avg,
+				"mean chunk size %d not close to expected %d", mean, min+avg)
+		})
+	}
 }
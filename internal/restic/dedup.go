@@ -0,0 +1,192 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// DedupStats describes how much storage space is saved by deduplicating the
+// data of a set of snapshots.
+type DedupStats struct {
+	// SnapshotsCount is the number of snapshots scanned.
+	SnapshotsCount int `json:"snapshots_count"`
+
+	// LogicalSize is the sum of the sizes of all files in all snapshots, a
+	// file contained in several snapshots is counted once per snapshot.
+	LogicalSize uint64 `json:"logical_size"`
+
+	// StoredSize is the size of the unique data blobs referenced by the
+	// snapshots as stored in the repository, including the encryption
+	// overhead.
+	StoredSize uint64 `json:"stored_size"`
+
+	// UniqueBlobCount is the number of unique data blobs referenced.
+	UniqueBlobCount int `json:"unique_blob_count"`
+
+	// DedupRatio is LogicalSize divided by StoredSize.
+	DedupRatio float64 `json:"dedup_ratio"`
+
+	// TopBlobs lists the most referenced data blobs, in descending order.
+	TopBlobs []BlobReferences `json:"top_blobs,omitempty"`
+}
+
+// BlobReferences is the number of references to a data blob.
+type BlobReferences struct {
+	ID         ID     `json:"id"`
+	Size       uint   `json:"size"`
+	References uint64 `json:"references"`
+}
+
+// dedupTree is a tree visited by a dedupWalker.
+type dedupTree struct {
+	// size is the sum of the sizes of all files within the tree.
+	size uint64
+	// subtrees lists the subtrees, once for each directory referencing it.
+	subtrees IDs
+	// blobs lists the data blobs of the files in the tree, once for each
+	// reference.
+	blobs IDs
+}
+
+// dedupWalker collects the sizes of the trees and the references to subtrees
+// and data blobs. Each tree is loaded only once.
+type dedupWalker struct {
+	repo  Repository
+	trees map[ID]*dedupTree
+	// order lists the trees so that each tree comes after all trees
+	// referencing it.
+	order IDs
+}
+
+// walk returns the sum of the sizes of all files within the tree id.
+func (w *dedupWalker) walk(ctx context.Context, id ID) (uint64, error) {
+	if t, ok := w.trees[id]; ok {
+		return t.size, nil
+	}
+
+	tree, err := w.repo.LoadTree(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	t := &dedupTree{}
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case "file":
+			t.size += node.Size
+			t.blobs = append(t.blobs, node.Content...)
+		case "dir":
+			if node.Subtree == nil {
+				return 0, errors.Errorf("dir %q in tree %v has no subtree", node.Name, id.Str())
+			}
+
+			subtreeSize, err := w.walk(ctx, *node.Subtree)
+			if err != nil {
+				return 0, err
+			}
+			t.size += subtreeSize
+			t.subtrees = append(t.subtrees, *node.Subtree)
+		}
+	}
+
+	w.trees[id] = t
+	// all subtrees have been added already, the order is reversed later
+	w.order = append(w.order, id)
+	return t.size, nil
+}
+
+// references returns the number of references to each data blob, counting
+// each tree once for each snapshot and directory referencing it.
+func (w *dedupWalker) references(snapshots []*Snapshot) map[ID]uint64 {
+	treeRefs := make(map[ID]uint64, len(w.trees))
+	for _, sn := range snapshots {
+		treeRefs[*sn.Tree]++
+	}
+
+	refs := make(map[ID]uint64)
+	for i := len(w.order) - 1; i >= 0; i-- {
+		id := w.order[i]
+		t := w.trees[id]
+		n := treeRefs[id]
+
+		for _, subtree := range t.subtrees {
+			treeRefs[subtree] += n
+		}
+		for _, blob := range t.blobs {
+			refs[blob] += n
+		}
+	}
+
+	return refs
+}
+
+// ComputeDedupStats walks the trees of all snapshots and computes how much
+// storage space is saved by deduplication. Trees shared between snapshots are
+// only loaded once, but the references to a data blob are counted for each
+// snapshot and each directory containing it. At most topN of the most
+// referenced blobs are included in the result.
+func ComputeDedupStats(ctx context.Context, repo Repository, snapshots []*Snapshot, topN int) (*DedupStats, error) {
+	w := &dedupWalker{
+		repo:  repo,
+		trees: make(map[ID]*dedupTree),
+	}
+
+	stats := &DedupStats{
+		SnapshotsCount: len(snapshots),
+	}
+
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return nil, errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+		}
+
+		size, err := w.walk(ctx, *sn.Tree)
+		if err != nil {
+			return nil, err
+		}
+		stats.LogicalSize += size
+	}
+
+	refs := w.references(snapshots)
+
+	top := make([]BlobReferences, 0, len(refs))
+	for id, n := range refs {
+		blobs, found := repo.Index().Lookup(id, DataBlob)
+		if !found {
+			return nil, errors.Errorf("data blob %v not found in index", id.Str())
+		}
+
+		stats.StoredSize += uint64(blobs[0].Length)
+		top = append(top, BlobReferences{
+			ID:         id,
+			Size:       uint(PlaintextLength(int(blobs[0].Length))),
+			References: n,
+		})
+	}
+	stats.UniqueBlobCount = len(refs)
+
+	if stats.StoredSize > 0 {
+		stats.DedupRatio = float64(stats.LogicalSize) / float64(stats.StoredSize)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].References != top[j].References {
+			return top[i].References > top[j].References
+		}
+		return bytes.Compare(top[i].ID[:], top[j].ID[:]) < 0
+	})
+	if topN < 0 {
+		topN = 0
+	}
+	if topN < len(top) {
+		top = top[:topN]
+	}
+	if len(top) > 0 {
+		stats.TopBlobs = top
+	}
+
+	return stats, nil
+}
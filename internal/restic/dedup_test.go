@@ -0,0 +1,133 @@
+package restic_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func saveDedupTree(t testing.TB, repo restic.Repository, nodes ...*restic.Node) restic.ID {
+	tree := restic.NewTree()
+	for _, node := range nodes {
+		rtest.OK(t, tree.Insert(node))
+	}
+
+	id, err := repo.SaveTree(context.TODO(), tree)
+	rtest.OK(t, err)
+	return id
+}
+
+func dedupFileNode(name string, blobs []restic.ID, size int) *restic.Node {
+	return &restic.Node{Name: name, Type: "file", Content: blobs, Size: uint64(size)}
+}
+
+func TestComputeDedupStats(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+
+	data := map[string]string{
+		"a": strings.Repeat("a", 100),
+		"b": strings.Repeat("b", 200),
+		"c": strings.Repeat("c", 300),
+	}
+	ids := make(map[string]restic.ID)
+	for name, d := range data {
+		id, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(d), restic.ID{})
+		rtest.OK(t, err)
+		ids[name] = id
+	}
+	a, b, c := ids["a"], ids["b"], ids["c"]
+
+	// the subtree is shared between both snapshots
+	subtree := saveDedupTree(t, repo,
+		dedupFileNode("file3", restic.IDs{a, c}, 400),
+	)
+
+	tree1 := saveDedupTree(t, repo,
+		dedupFileNode("file1", restic.IDs{a, b}, 300),
+		dedupFileNode("file2", restic.IDs{a}, 100),
+		&restic.Node{Name: "sub", Type: "dir", Subtree: &subtree},
+	)
+
+	tree2 := saveDedupTree(t, repo,
+		dedupFileNode("file", restic.IDs{b}, 200),
+		&restic.Node{Name: "sub", Type: "dir", Subtree: &subtree},
+	)
+
+	rtest.OK(t, repo.Flush(ctx))
+
+	var snapshots []*restic.Snapshot
+	for _, tree := range []restic.ID{tree1, tree2} {
+		sn, err := restic.NewSnapshot([]string{"/"}, nil, "host", time.Now())
+		rtest.OK(t, err)
+		tree := tree
+		sn.Tree = &tree
+		snapshots = append(snapshots, sn)
+	}
+
+	stats, err := restic.ComputeDedupStats(ctx, repo, snapshots, 2)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, 2, stats.SnapshotsCount)
+	rtest.Equals(t, uint64(300+100+400+200+400), stats.LogicalSize)
+	rtest.Equals(t, uint64(100+200+300+3*crypto.Extension), stats.StoredSize)
+	rtest.Equals(t, 3, stats.UniqueBlobCount)
+	rtest.Equals(t, float64(stats.LogicalSize)/float64(stats.StoredSize), stats.DedupRatio)
+
+	// the blobs in the shared subtree are counted once for each snapshot
+	rtest.Equals(t, []restic.BlobReferences{
+		{ID: a, Size: 100, References: 4},
+		{ID: b, Size: 200, References: 2},
+	}, stats.TopBlobs)
+}
+
+func TestComputeDedupStatsSameTree(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+
+	id, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(strings.Repeat("a", 100)), restic.ID{})
+	rtest.OK(t, err)
+
+	subtree := saveDedupTree(t, repo, dedupFileNode("file", restic.IDs{id}, 100))
+	tree := saveDedupTree(t, repo,
+		&restic.Node{Name: "dir1", Type: "dir", Subtree: &subtree},
+		&restic.Node{Name: "dir2", Type: "dir", Subtree: &subtree},
+	)
+	rtest.OK(t, repo.Flush(ctx))
+
+	// three unchanged snapshots of the same directory
+	var snapshots []*restic.Snapshot
+	for i := 0; i < 3; i++ {
+		sn, err := restic.NewSnapshot([]string{"/"}, nil, "host", time.Now())
+		rtest.OK(t, err)
+		sn.Tree = &tree
+		snapshots = append(snapshots, sn)
+	}
+
+	stats, err := restic.ComputeDedupStats(ctx, repo, snapshots, 1)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, uint64(3*2*100), stats.LogicalSize)
+	rtest.Equals(t, []restic.BlobReferences{
+		{ID: id, Size: 100, References: 3 * 2},
+	}, stats.TopBlobs)
+}
+
+func TestComputeDedupStatsEmpty(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	stats, err := restic.ComputeDedupStats(context.TODO(), repo, nil, 10)
+	rtest.OK(t, err)
+	rtest.Equals(t, &restic.DedupStats{}, stats)
+}
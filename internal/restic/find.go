@@ -13,6 +13,10 @@ func FindUsedBlobs(ctx context.Context, repo Repository, treeID ID, blobs BlobSe
 		return err
 	}
 
+	for _, id := range tree.Chunks {
+		blobs.Insert(BlobHandle{ID: id, Type: TreeBlob})
+	}
+
 	for _, node := range tree.Nodes {
 		switch node.Type {
 		case "file":
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cenkalti/backoff"
 	"github.com/restic/restic/internal/errors"
 
 	"github.com/restic/restic/internal/debug"
@@ -22,8 +23,13 @@ import (
 // different non-exclusive locks, but at most one exclusive lock, which can
 // only be acquired while no non-exclusive lock is held.
 //
+// A lock may be restricted to a scope, e.g. for several tenants sharing a
+// repository. Locks with different scopes do not conflict, a lock without a
+// scope conflicts with the locks of all scopes.
+//
 // A lock must be refreshed regularly to not be considered stale, this must be
-// triggered by regularly calling Refresh.
+// triggered by regularly calling Refresh, or by starting a goroutine which
+// does that with StartRefresh.
 type Lock struct {
 	Time      time.Time `json:"time"`
 	Exclusive bool      `json:"exclusive"`
@@ -33,8 +39,149 @@ type Lock struct {
 	UID       uint32    `json:"uid,omitempty"`
 	GID       uint32    `json:"gid,omitempty"`
 
-	repo   Repository
-	lockID *ID
+	// ProcessStartTicks is the start time of the process holding the lock,
+	// in clock ticks since boot as reported by the kernel. It is used to
+	// detect a reused PID and is zero if it could not be determined, or the
+	// lock was created by an older version of restic.
+	ProcessStartTicks uint64 `json:"process_start_ticks,omitempty"`
+
+	// StaleAge is the duration after which the lock is considered stale when
+	// it has not been refreshed. Locks created by older versions of restic do
+	// not carry this field, for those DefaultLockStaleAge is used.
+	StaleAge time.Duration `json:"stale_age,omitempty"`
+
+	// Scope records the scope of the client which created the lock. It does
+	// not affect conflicts: exclusive locks conflict with the locks of all
+	// scopes, so that e.g. prune never runs alongside a backup in another
+	// scope.
+	Scope string `json:"scope,omitempty"`
+
+	repo            Repository
+	lockID          *ID
+	refreshInterval time.Duration
+	appendOnly      bool
+
+	// clockSkew and staleFutureLocks configure how the lock is handled if it
+	// is dated in the future, see LockOptions
+	clockSkew        time.Duration
+	staleFutureLocks bool
+
+	// m protects lockID, unlocked and the refresh goroutine state, so that
+	// Refresh and Unlock do not run concurrently.
+	m           sync.Mutex
+	unlocked    bool
+	stopRefresh chan struct{}
+	refreshDone chan struct{}
+}
+
+// Default values used for LockOptions fields which are left at zero.
+const (
+	DefaultLockRefreshInterval = 5 * time.Minute
+	DefaultLockStaleAge        = 30 * time.Minute
+	DefaultLockMaxClockSkew    = 10 * time.Minute
+)
+
+// LockOptions configures how a lock is refreshed and when it is considered
+// stale. Zero values are replaced by the defaults.
+type LockOptions struct {
+	// RefreshInterval is the interval in which the lock should be refreshed
+	// by calling Refresh.
+	RefreshInterval time.Duration
+
+	// StaleAge is the age after which a lock which was not refreshed is
+	// considered stale. It is stored in the lock file so that other processes
+	// use the same threshold.
+	StaleAge time.Duration
+
+	// Retry configures retrying the creation of the lock file on transient
+	// backend errors. By default, no retries happen.
+	Retry LockRetryPolicy
+
+	// Scope is recorded in the lock. Exclusive locks still conflict with the
+	// locks of all scopes.
+	Scope string
+
+	// AppendOnly must be set when the repository does not allow removing
+	// files. Lock files are then never removed, an unlocked or refreshed lock
+	// is left behind and becomes stale after StaleAge.
+	AppendOnly bool
+
+	// ClockSkew is how far the timestamp of another lock may lie in the
+	// future, e.g. because the clock of the host which created it is off.
+	// Locks beyond ClockSkew are reported as future-dated.
+	ClockSkew time.Duration
+
+	// StaleFutureLocks configures that future-dated locks are considered
+	// stale. Such a lock would otherwise never become stale.
+	StaleFutureLocks bool
+}
+
+// LockRetryPolicy describes how often and how fast saving a new lock file is
+// retried when the backend returns a transient error, see IsTransientError.
+// The delay between attempts starts at BaseDelay and grows exponentially.
+type LockRetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+// retry runs fn until it succeeds, returns a non-retriable error or the
+// maximum number of attempts is reached.
+func (p LockRetryPolicy) retry(ctx context.Context, fn func() error) error {
+	if p.MaxAttempts <= 1 {
+		return fn()
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	if p.BaseDelay > 0 {
+		bo.InitialInterval = p.BaseDelay
+	}
+	// the number of attempts is limited below, don't stop after a timeout
+	bo.MaxElapsedTime = 0
+
+	policy := backoff.WithContext(backoff.WithMaxRetries(bo, uint64(p.MaxAttempts-1)), ctx)
+	return backoff.RetryNotify(func() error {
+		err := fn()
+		if err != nil && !isRetriableLockError(ctx, err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, policy, func(err error, d time.Duration) {
+		debug.Log("creating lock failed, retrying in %v: %v", d, err)
+	})
+}
+
+// isRetriableLockError returns true for transient backend errors, e.g.
+// network or server errors. Other errors won't go away by retrying, e.g. when
+// another process holds the lock or the operation was cancelled.
+func isRetriableLockError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || IsAlreadyLocked(err) {
+		return false
+	}
+
+	return IsTransientError(err)
+}
+
+func (opts LockOptions) withDefaults() (LockOptions, error) {
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = DefaultLockRefreshInterval
+	}
+	if opts.StaleAge == 0 {
+		opts.StaleAge = DefaultLockStaleAge
+	}
+	if opts.ClockSkew == 0 {
+		opts.ClockSkew = DefaultLockMaxClockSkew
+	}
+
+	if opts.RefreshInterval < 0 || opts.StaleAge < 0 || opts.ClockSkew < 0 {
+		return opts, errors.New("lock refresh interval, stale age and clock skew must not be negative")
+	}
+
+	if opts.RefreshInterval >= opts.StaleAge {
+		return opts, errors.Errorf("lock refresh interval %v must be shorter than the stale age %v",
+			opts.RefreshInterval, opts.StaleAge)
+	}
+
+	return opts, nil
 }
 
 // ErrAlreadyLocked is returned when NewLock or NewExclusiveLock are unable to
@@ -48,7 +195,12 @@ func (e ErrAlreadyLocked) Error() string {
 	if e.otherLock.Exclusive {
 		s = "exclusively "
 	}
-	return fmt.Sprintf("repository is already locked %sby %v", s, e.otherLock)
+	msg := fmt.Sprintf("repository is already locked %sby %v", s, e.otherLock)
+	if e.otherLock.FutureDated() {
+		msg += fmt.Sprintf("\nthe lock is dated %v in the future, the clock of host %q is probably wrong",
+			time.Until(e.otherLock.Time).Round(time.Second), e.otherLock.Hostname)
+	}
+	return msg
 }
 
 // IsAlreadyLocked returns true iff err is an instance of ErrAlreadyLocked.
@@ -63,15 +215,15 @@ func IsAlreadyLocked(err error) bool {
 // NewLock returns a new, non-exclusive lock for the repository. If an
 // exclusive lock is already held by another process, ErrAlreadyLocked is
 // returned.
-func NewLock(ctx context.Context, repo Repository) (*Lock, error) {
-	return newLock(ctx, repo, false)
+func NewLock(ctx context.Context, repo Repository, opts LockOptions) (*Lock, error) {
+	return newLock(ctx, repo, false, opts)
 }
 
 // NewExclusiveLock returns a new, exclusive lock for the repository. If
 // another lock (normal and exclusive) is already held by another process,
 // ErrAlreadyLocked is returned.
-func NewExclusiveLock(ctx context.Context, repo Repository) (*Lock, error) {
-	return newLock(ctx, repo, true)
+func NewExclusiveLock(ctx context.Context, repo Repository, opts LockOptions) (*Lock, error) {
+	return newLock(ctx, repo, true, opts)
 }
 
 var waitBeforeLockCheck = 200 * time.Millisecond
@@ -82,12 +234,24 @@ func TestSetLockTimeout(t testing.TB, d time.Duration) {
 	waitBeforeLockCheck = d
 }
 
-func newLock(ctx context.Context, repo Repository, excl bool) (*Lock, error) {
+func newLock(ctx context.Context, repo Repository, excl bool, opts LockOptions) (*Lock, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
 	lock := &Lock{
-		Time:      time.Now(),
-		PID:       os.Getpid(),
-		Exclusive: excl,
-		repo:      repo,
+		Time:            time.Now(),
+		PID:             os.Getpid(),
+		Exclusive:       excl,
+		StaleAge:        opts.StaleAge,
+		Scope:           opts.Scope,
+		repo:            repo,
+		refreshInterval: opts.RefreshInterval,
+		appendOnly:      opts.AppendOnly,
+
+		clockSkew:        opts.ClockSkew,
+		staleFutureLocks: opts.StaleFutureLocks,
 	}
 
 	hn, err := os.Hostname()
@@ -99,11 +263,22 @@ func newLock(ctx context.Context, repo Repository, excl bool) (*Lock, error) {
 		return nil, err
 	}
 
+	ticks, err := processStartTicks(lock.PID)
+	if err != nil {
+		debug.Log("unable to determine process start time: %v", err)
+	} else {
+		lock.ProcessStartTicks = ticks
+	}
+
 	if err = lock.checkForOtherLocks(ctx); err != nil {
 		return nil, err
 	}
 
-	lockID, err := lock.createLock(ctx)
+	var lockID ID
+	err = opts.Retry.retry(ctx, func() (err error) {
+		lockID, err = lock.createLock(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -131,24 +306,60 @@ func (l *Lock) fillUserInfo() error {
 	return err
 }
 
+// releasedLocks records the lock files which were unlocked or replaced by a
+// refresh in append-only mode, and which are therefore still present in the
+// repository.
+var releasedLocks = struct {
+	IDSet
+	sync.Mutex
+}{IDSet: NewIDSet()}
+
+// release marks the lock file id as released. It is not removed from the
+// repository, but ignored by other locks of this process.
+func release(id ID) {
+	releasedLocks.Lock()
+	releasedLocks.Insert(id)
+	releasedLocks.Unlock()
+}
+
+func isReleased(id ID) bool {
+	releasedLocks.Lock()
+	defer releasedLocks.Unlock()
+	return releasedLocks.Has(id)
+}
+
 // checkForOtherLocks looks for other locks that currently exist in the repository.
 //
 // If an exclusive lock is to be created, checkForOtherLocks returns an error
 // if there are any other locks, regardless if exclusive or not. If a
 // non-exclusive lock is to be created, an error is only returned when an
 // exclusive lock is found.
+//
+// Locks with a different scope are ignored. In append-only mode, stale locks
+// cannot be removed and are ignored instead, as are the locks released by this
+// process.
 func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 	return l.repo.List(ctx, LockFile, func(id ID, size int64) error {
 		if l.lockID != nil && id.Equal(*l.lockID) {
 			return nil
 		}
 
+		if l.appendOnly && isReleased(id) {
+			return nil
+		}
+
 		lock, err := LoadLock(ctx, l.repo, id)
 		if err != nil {
 			// ignore locks that cannot be loaded
 			debug.Log("ignore lock %v: %v", id, err)
 			return nil
 		}
+		lock.clockSkew, lock.staleFutureLocks = l.clockSkew, l.staleFutureLocks
+
+		if l.appendOnly && lock.Stale() {
+			debug.Log("ignore stale lock %v in append-only mode", id)
+			return nil
+		}
 
 		if l.Exclusive {
 			return ErrAlreadyLocked{otherLock: lock}
@@ -172,23 +383,85 @@ func (l *Lock) createLock(ctx context.Context) (ID, error) {
 	return id, nil
 }
 
-// Unlock removes the lock from the repository.
+// Unlock removes the lock from the repository. In append-only mode, the lock
+// file is left in the repository. A refresh goroutine started with
+// StartRefresh is stopped first, Unlock waits until a refresh which is in
+// progress has finished, so that no new lock file is created afterwards.
 func (l *Lock) Unlock() error {
-	if l == nil || l.lockID == nil {
+	if l == nil {
+		return nil
+	}
+
+	l.m.Lock()
+	l.unlocked = true
+	stop, done := l.stopRefresh, l.refreshDone
+	l.stopRefresh, l.refreshDone = nil, nil
+	l.m.Unlock()
+
+	if stop != nil {
+		debug.Log("stop refreshing lock %v", l.lockID)
+		close(stop)
+		<-done
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.lockID == nil {
+		return nil
+	}
+
+	if l.appendOnly {
+		debug.Log("append-only mode, not removing lock %v", l.lockID)
+		release(*l.lockID)
 		return nil
 	}
 
 	return l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
 }
 
-var staleTimeout = 30 * time.Minute
+// RefreshInterval returns the interval in which Refresh needs to be called to
+// keep the lock from becoming stale.
+func (l *Lock) RefreshInterval() time.Duration {
+	if l.refreshInterval == 0 {
+		return DefaultLockRefreshInterval
+	}
+	return l.refreshInterval
+}
+
+// staleAge returns the age after which the lock is considered stale.
+func (l *Lock) staleAge() time.Duration {
+	if l.StaleAge <= 0 {
+		return DefaultLockStaleAge
+	}
+	return l.StaleAge
+}
+
+// FutureDated returns true if the timestamp of the lock lies further in the
+// future than the clock skew allowance, which is 10 minutes by default.
+func (l *Lock) FutureDated() bool {
+	maxSkew := l.clockSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultLockMaxClockSkew
+	}
+	return time.Until(l.Time) > maxSkew
+}
 
 // Stale returns true if the lock is stale. A lock is stale if the timestamp is
-// older than 30 minutes or if it was created on the current machine and the
-// process isn't alive any more.
+// older than the stale age stored in the lock (30 minutes by default) or if it
+// was created on the current machine and the process isn't alive any more.
+// Future-dated locks are only stale if the lock was loaded with
+// LockOptions.StaleFutureLocks set.
 func (l *Lock) Stale() bool {
 	debug.Log("testing if lock %v for process %d is stale", l, l.PID)
-	if time.Since(l.Time) > staleTimeout {
+	if l.FutureDated() {
+		debug.Log("lock is dated in the future: %v\n", l.Time)
+		if l.staleFutureLocks {
+			return true
+		}
+	}
+
+	if time.Since(l.Time) > l.staleAge() {
 		debug.Log("lock is stale, timestamp is too old: %v\n", l.Time)
 		return true
 	}
@@ -217,9 +490,44 @@ func (l *Lock) Stale() bool {
 	return false
 }
 
+// processReused returns true if the start time recorded in the lock does not
+// match the start time of the process which currently has the lock's PID,
+// which means the PID was reused by another process. If any of the start
+// times is unknown, false is returned.
+func (l *Lock) processReused() bool {
+	if l.ProcessStartTicks == 0 {
+		return false
+	}
+
+	ticks, err := processStartTicks(l.PID)
+	if err != nil {
+		debug.Log("unable to determine start time of process %d: %v", l.PID, err)
+		return false
+	}
+
+	if ticks != l.ProcessStartTicks {
+		debug.Log("process %d was started at tick %d, lock was created by process started at tick %d",
+			l.PID, ticks, l.ProcessStartTicks)
+		return true
+	}
+
+	return false
+}
+
+// errLockUnlocked is returned by Refresh for a lock which has been unlocked.
+var errLockUnlocked = errors.New("lock has been unlocked")
+
 // Refresh refreshes the lock by creating a new file in the backend with a new
-// timestamp. Afterwards the old lock is removed.
+// timestamp. Afterwards the old lock is removed, unless the lock was created in
+// append-only mode. A lock which has been unlocked cannot be refreshed.
 func (l *Lock) Refresh(ctx context.Context) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.unlocked {
+		return errLockUnlocked
+	}
+
 	debug.Log("refreshing lock %v", l.lockID)
 	l.Time = time.Now()
 	id, err := l.createLock(ctx)
@@ -227,9 +535,13 @@ func (l *Lock) Refresh(ctx context.Context) error {
 		return err
 	}
 
-	err = l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
-	if err != nil {
-		return err
+	if l.appendOnly {
+		release(*l.lockID)
+	} else {
+		err = l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
+		if err != nil {
+			return err
+		}
 	}
 
 	debug.Log("new lock ID %v", id)
@@ -238,10 +550,59 @@ func (l *Lock) Refresh(ctx context.Context) error {
 	return nil
 }
 
-func (l Lock) String() string {
-	text := fmt.Sprintf("PID %d on %s by %s (UID %d, GID %d)\nlock was created at %s (%s ago)\nstorage ID %v",
+// StartRefresh starts a goroutine which refreshes the lock every
+// RefreshInterval until it is unlocked. Errors returned by Refresh are passed
+// to errorFn. Calling StartRefresh again while the goroutine is running, or
+// after the lock has been unlocked, does nothing.
+func (l *Lock) StartRefresh(errorFn func(error)) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.unlocked || l.stopRefresh != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	l.stopRefresh, l.refreshDone = stop, done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(l.RefreshInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			// Unlock may have been called while waiting for the ticker
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			err := l.Refresh(context.TODO())
+			if err != nil && err != errLockUnlocked {
+				errorFn(err)
+			}
+		}
+	}()
+}
+
+func (l *Lock) String() string {
+	age := fmt.Sprintf("%s ago", time.Since(l.Time))
+	if l.Time.After(time.Now()) {
+		age = fmt.Sprintf("%s in the future", time.Until(l.Time))
+	}
+
+	text := fmt.Sprintf("PID %d on %s by %s (UID %d, GID %d)\nlock was created at %s (%s)\nstorage ID %v",
 		l.PID, l.Hostname, l.Username, l.UID, l.GID,
-		l.Time.Format("2006-01-02 15:04:05"), time.Since(l.Time),
+		l.Time.Format("2006-01-02 15:04:05"), age,
 		l.lockID.Str())
 
 	return text
@@ -252,9 +613,9 @@ var ignoreSIGHUP sync.Once
 
 func init() {
 	ignoreSIGHUP.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
 		go func() {
-			c := make(chan os.Signal)
-			signal.Notify(c, syscall.SIGHUP)
 			for s := range c {
 				debug.Log("Signal received: %v\n", s)
 			}
@@ -273,24 +634,166 @@ func LoadLock(ctx context.Context, repo Repository, id ID) (*Lock, error) {
 	return lock, nil
 }
 
-// RemoveStaleLocks deletes all locks detected as stale from the repository.
-func RemoveStaleLocks(ctx context.Context, repo Repository) error {
-	return repo.List(ctx, LockFile, func(id ID, size int64) error {
-		lock, err := LoadLock(ctx, repo, id)
+// loadLock loads a lock like LoadLock, future-dated locks are handled as
+// configured in opts.
+func loadLock(ctx context.Context, repo Repository, id ID, opts LockOptions) (*Lock, error) {
+	lock, err := LoadLock(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.clockSkew = opts.ClockSkew
+	lock.staleFutureLocks = opts.StaleFutureLocks
+	return lock, nil
+}
+
+// LockInfo describes a lock found in the repository.
+type LockInfo struct {
+	ID        ID
+	Time      time.Time
+	PID       int
+	Hostname  string
+	Exclusive bool
+	Stale     bool
+	Scope     string
+
+	// FutureDated is set if the timestamp of the lock lies in the future by
+	// more than the allowed clock skew.
+	FutureDated bool
+
+	// Err is set if the lock file could not be loaded, all other fields
+	// except ID are unset in this case.
+	Err error
+}
+
+// ListLocks loads all locks in the repository and returns information about
+// them. The repository is not modified. Lock files which cannot be loaded or
+// decoded are included in the result with Err set. Only the clock skew
+// settings in opts are used.
+func ListLocks(ctx context.Context, repo Repository, opts LockOptions) ([]LockInfo, error) {
+	var locks []LockInfo
+	err := repo.List(ctx, LockFile, func(id ID, size int64) error {
+		lock, err := loadLock(ctx, repo, id, opts)
+		if err != nil {
+			debug.Log("unable to load lock %v: %v", id, err)
+			locks = append(locks, LockInfo{ID: id, Err: err})
+			return nil
+		}
+
+		locks = append(locks, LockInfo{
+			ID:        id,
+			Time:      lock.Time,
+			PID:       lock.PID,
+			Hostname:  lock.Hostname,
+			Exclusive: lock.Exclusive,
+			Stale:     lock.Stale(),
+			Scope:     lock.Scope,
+
+			FutureDated: lock.FutureDated(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return locks, nil
+}
+
+// RemoveStaleLocks deletes all locks detected as stale from the repository
+// and returns the IDs of the locks which were removed. The context is checked
+// before each removal, so a cancelled context stops the cleanup between two
+// locks; the IDs removed so far are returned together with the error. Only the
+// clock skew settings and the stale age in opts are used, temporary lock files
+// left behind by interrupted processes are removed once they are older than
+// the stale age.
+func RemoveStaleLocks(ctx context.Context, repo Repository, opts LockOptions) (IDs, error) {
+	err := removeStaleTempLocks(ctx, repo.Backend(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed IDs
+	err = repo.List(ctx, LockFile, func(id ID, size int64) error {
+		lock, err := loadLock(ctx, repo, id, opts)
 		if err != nil {
 			// ignore locks that cannot be loaded
 			debug.Log("ignore lock %v: %v", id, err)
 			return nil
 		}
 
-		if lock.Stale() {
-			return repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: id.String()})
+		if !lock.Stale() {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err = repo.Backend().Remove(ctx, Handle{Type: LockFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+
+		debug.Log("removed stale lock %v", id)
+		removed = append(removed, id)
+		return nil
+	})
+
+	return removed, err
+}
+
+// removeStaleTempLocks removes the temporary files written while saving lock
+// files atomically or by the backend self-test which have not been modified
+// within the stale age. Files for which the backend does not report a
+// modification time are kept.
+func removeStaleTempLocks(ctx context.Context, be Backend, opts LockOptions) error {
+	staleAge := opts.StaleAge
+	if staleAge <= 0 {
+		staleAge = DefaultLockStaleAge
+	}
+
+	return be.List(ctx, LockFile, func(fi FileInfo) error {
+		if !IsTempFile(fi.Name) || fi.ModTime.IsZero() || time.Since(fi.ModTime) <= staleAge {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
+		err := be.Remove(ctx, Handle{Type: LockFile, Name: fi.Name})
+		if err != nil {
+			return err
+		}
+
+		debug.Log("removed stale temporary lock file %v", fi.Name)
 		return nil
 	})
 }
 
+// ErrLockNotStale is returned by RemoveLock for a lock which is not stale.
+var ErrLockNotStale = errors.New("lock is not stale")
+
+// RemoveLock removes the lock with the given ID from the repository. Unless
+// force is set, only stale locks and lock files which cannot be loaded are
+// removed, for other locks an error wrapping ErrLockNotStale is returned. Only
+// the clock skew settings in opts are used.
+func RemoveLock(ctx context.Context, repo Repository, id ID, force bool, opts LockOptions) error {
+	if !force {
+		lock, err := loadLock(ctx, repo, id, opts)
+		if err != nil {
+			// locks that cannot be loaded are ignored when locking
+			debug.Log("remove lock %v which cannot be loaded: %v", id, err)
+		} else if !lock.Stale() {
+			return errors.Wrapf(ErrLockNotStale, "lock %v held by PID %d on %v", id.Str(), lock.PID, lock.Hostname)
+		}
+	}
+
+	debug.Log("remove lock %v", id)
+	return repo.Backend().Remove(ctx, Handle{Type: LockFile, Name: id.String()})
+}
+
 // RemoveAllLocks removes all locks forcefully.
 func RemoveAllLocks(ctx context.Context, repo Repository) error {
 	return repo.List(ctx, LockFile, func(id ID, size int64) error {
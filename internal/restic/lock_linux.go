@@ -0,0 +1,41 @@
+package restic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// processStartTicks returns the start time of the process with the given PID
+// as reported by the proc filesystem, in clock ticks since the system was
+// booted. The value is stable for the lifetime of the process and is compared
+// exactly, so it is not converted to a wall clock time.
+func processStartTicks(pid int) (uint64, error) {
+	buf, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadFile")
+	}
+
+	// the second field is the command name in parens, which may contain
+	// spaces, so start parsing after the last closing paren
+	pos := bytes.LastIndexByte(buf, ')')
+	if pos < 0 {
+		return 0, errors.Errorf("invalid stat file for process %d", pid)
+	}
+
+	// starttime is field 22, the fields after the command name start at 3
+	fields := strings.Fields(string(buf[pos+1:]))
+	if len(fields) < 20 {
+		return 0, errors.Errorf("invalid stat file for process %d", pid)
+	}
+
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "ParseUint")
+	}
+
+	return ticks, nil
+}
@@ -0,0 +1,56 @@
+package restic
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProcessStartTicks(t *testing.T) {
+	ticks, err := processStartTicks(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ticks == 0 {
+		t.Fatal("process start time is zero")
+	}
+
+	again, err := processStartTicks(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ticks != again {
+		t.Fatalf("process start time changed from %d to %d", ticks, again)
+	}
+}
+
+func TestLockStaleReusedPID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticks, err := processStartTicks(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := Lock{
+		Time:              time.Now(),
+		PID:               os.Getpid(),
+		Hostname:          hostname,
+		ProcessStartTicks: ticks,
+	}
+
+	if lock.Stale() {
+		t.Fatal("lock with matching process start time is stale")
+	}
+
+	// even a single tick of difference means a different process
+	lock.ProcessStartTicks = ticks + 1
+	if !lock.Stale() {
+		t.Fatal("lock with different process start time is not stale")
+	}
+}
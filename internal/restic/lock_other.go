@@ -0,0 +1,12 @@
+// +build !linux
+
+package restic
+
+import (
+	"github.com/restic/restic/internal/errors"
+)
+
+// processStartTicks is not supported on this platform.
+func processStartTicks(pid int) (uint64, error) {
+	return 0, errors.New("process start time is not supported on this platform")
+}
@@ -2,10 +2,17 @@ package restic_test
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -15,7 +22,7 @@ func TestLock(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	lock, err := restic.NewLock(context.TODO(), repo)
+	lock, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
 	rtest.OK(t, lock.Unlock())
@@ -25,7 +32,7 @@ func TestDoubleUnlock(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	lock, err := restic.NewLock(context.TODO(), repo)
+	lock, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
 	rtest.OK(t, lock.Unlock())
@@ -39,10 +46,10 @@ func TestMultipleLock(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	lock1, err := restic.NewLock(context.TODO(), repo)
+	lock1, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
-	lock2, err := restic.NewLock(context.TODO(), repo)
+	lock2, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
 	rtest.OK(t, lock1.Unlock())
@@ -53,7 +60,7 @@ func TestLockExclusive(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	elock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 	rtest.OK(t, elock.Unlock())
 }
@@ -62,10 +69,10 @@ func TestLockOnExclusiveLockedRepo(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	elock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
-	lock, err := restic.NewLock(context.TODO(), repo)
+	lock, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.Assert(t, err != nil,
 		"create normal lock with exclusively locked repo didn't return an error")
 	rtest.Assert(t, restic.IsAlreadyLocked(err),
@@ -79,10 +86,10 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	elock, err := restic.NewLock(context.TODO(), repo)
+	elock, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 
-	lock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	lock, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.Assert(t, err != nil,
 		"create normal lock with exclusively locked repo didn't return an error")
 	rtest.Assert(t, restic.IsAlreadyLocked(err),
@@ -92,6 +99,44 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	rtest.OK(t, elock.Unlock())
 }
 
+func TestLockScope(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	restic.TestSetLockTimeout(t, 0)
+
+	// non-exclusive locks of different scopes do not conflict
+	lockA, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{Scope: "a"})
+	rtest.OK(t, err)
+	lockB, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{Scope: "b"})
+	rtest.OK(t, err)
+
+	// exclusive locks conflict with the locks of all scopes
+	for _, scope := range []string{"a", "c", ""} {
+		_, err = restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{Scope: scope})
+		rtest.Assert(t, restic.IsAlreadyLocked(err),
+			"create exclusive lock in scope %q didn't return the correct error, got %v", scope, err)
+	}
+
+	rtest.OK(t, lockA.Unlock())
+	rtest.OK(t, lockB.Unlock())
+
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{Scope: "a"})
+	rtest.OK(t, err)
+
+	for _, scope := range []string{"a", "b", ""} {
+		_, err = restic.NewLock(context.TODO(), repo, restic.LockOptions{Scope: scope})
+		rtest.Assert(t, restic.IsAlreadyLocked(err),
+			"create lock in scope %q on exclusively locked repo didn't return the correct error, got %v", scope, err)
+	}
+
+	locks, err := restic.ListLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(locks))
+	rtest.Equals(t, "a", locks[0].Scope)
+	rtest.OK(t, elock.Unlock())
+}
+
 func createFakeLock(repo restic.Repository, t time.Time, pid int) (restic.ID, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -163,6 +208,161 @@ func TestLockStale(t *testing.T) {
 	}
 }
 
+// flakyBackend fails saving lock files with err for the configured number of
+// times, by default with a transient server error.
+type flakyBackend struct {
+	restic.Backend
+	failures int
+	saves    int
+	err      error
+}
+
+func (be *flakyBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.LockFile {
+		be.saves++
+		if be.saves <= be.failures {
+			if be.err != nil {
+				return be.err
+			}
+			return errors.Wrap(&restic.StatusError{StatusCode: 503}, "Save")
+		}
+	}
+
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestLockRetry(t *testing.T) {
+	be := &flakyBackend{Backend: mem.New(), failures: 2}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	_, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
+	rtest.Assert(t, err != nil, "lock was created without retries despite backend error")
+
+	be.saves = 0
+	opts := restic.LockOptions{
+		Retry: restic.LockRetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	}
+	lock, err := restic.NewLock(context.TODO(), repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, be.saves)
+	rtest.OK(t, lock.Unlock())
+
+	be.saves = 0
+	opts.Retry.MaxAttempts = 2
+	_, err = restic.NewLock(context.TODO(), repo, opts)
+	rtest.Assert(t, err != nil, "lock was created despite too many backend errors")
+	rtest.Equals(t, 2, be.saves)
+}
+
+func TestLockRetryPermanentError(t *testing.T) {
+	for _, err := range []error{
+		errors.New("permission denied"),
+		&restic.StatusError{StatusCode: 403},
+	} {
+		be := &flakyBackend{Backend: mem.New(), failures: 1, err: err}
+		repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+
+		opts := restic.LockOptions{
+			Retry: restic.LockRetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		}
+		_, lerr := restic.NewLock(context.TODO(), repo, opts)
+		rtest.Assert(t, lerr != nil, "lock was created despite permanent error %v", err)
+		rtest.Equals(t, 1, be.saves)
+
+		cleanup()
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("permission denied"), false},
+		{context.Canceled, false},
+		{&restic.StatusError{StatusCode: 404}, false},
+		{&restic.StatusError{StatusCode: 429}, true},
+		{errors.Wrap(&restic.StatusError{StatusCode: 503}, "Save"), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{errors.Wrap(io.ErrUnexpectedEOF, "Load"), true},
+	} {
+		rtest.Assert(t, restic.IsTransientError(test.err) == test.transient,
+			"IsTransientError(%v) != %v", test.err, test.transient)
+	}
+}
+
+func TestLockRetryAlreadyLocked(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+
+	opts := restic.LockOptions{
+		Retry: restic.LockRetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour},
+	}
+	_, err = restic.NewLock(context.TODO(), repo, opts)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create lock on exclusively locked repo didn't return the correct error, got %v", err)
+
+	rtest.OK(t, elock.Unlock())
+}
+
+func TestLockStaleAge(t *testing.T) {
+	hostname, err := os.Hostname()
+	rtest.OK(t, err)
+
+	lock := restic.Lock{
+		Time:     time.Now().Add(-10 * time.Minute),
+		PID:      os.Getpid(),
+		Hostname: "other-" + hostname,
+	}
+	rtest.Assert(t, !lock.Stale(), "lock without stale age should use the default")
+
+	lock.StaleAge = 5 * time.Minute
+	rtest.Assert(t, lock.Stale(), "lock older than its stale age is not stale")
+
+	lock.StaleAge = time.Hour
+	lock.Time = time.Now().Add(-45 * time.Minute)
+	rtest.Assert(t, !lock.Stale(), "lock younger than its stale age is stale")
+}
+
+func TestLockDecodeOldFormat(t *testing.T) {
+	data := []byte(`{"time":"2019-11-22T10:20:30Z","exclusive":false,"hostname":"foo","username":"bar","pid":23}`)
+
+	var lock restic.Lock
+	rtest.OK(t, json.Unmarshal(data, &lock))
+	rtest.Equals(t, 23, lock.PID)
+	rtest.Equals(t, uint64(0), lock.ProcessStartTicks)
+}
+
+func TestLockOptions(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	opts := restic.LockOptions{RefreshInterval: time.Minute, StaleAge: 10 * time.Minute}
+	lock, err := restic.NewLock(context.TODO(), repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, time.Minute, lock.RefreshInterval())
+
+	var lockID restic.ID
+	err = repo.List(context.TODO(), restic.LockFile, func(id restic.ID, size int64) error {
+		lockID = id
+		return nil
+	})
+	rtest.OK(t, err)
+
+	lock2, err := restic.LoadLock(context.TODO(), repo, lockID)
+	rtest.OK(t, err)
+	rtest.Equals(t, 10*time.Minute, lock2.StaleAge)
+	rtest.OK(t, lock.Unlock())
+
+	_, err = restic.NewLock(context.TODO(), repo, restic.LockOptions{RefreshInterval: time.Hour})
+	rtest.Assert(t, err != nil, "refresh interval longer than stale age was accepted")
+}
+
 func lockExists(repo restic.Repository, t testing.TB, id restic.ID) bool {
 	h := restic.Handle{Type: restic.LockFile, Name: id.String()}
 	exists, err := repo.Backend().Test(context.TODO(), h)
@@ -184,7 +384,10 @@ func TestLockWithStaleLock(t *testing.T) {
 	id3, err := createFakeLock(repo, time.Now().Add(-time.Minute), os.Getpid()+500000)
 	rtest.OK(t, err)
 
-	rtest.OK(t, restic.RemoveStaleLocks(context.TODO(), repo))
+	removed, err := restic.RemoveStaleLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Assert(t, restic.NewIDSet(removed...).Equals(restic.NewIDSet(id1, id3)),
+		"unexpected list of removed locks: %v", removed)
 
 	rtest.Assert(t, lockExists(repo, t, id1) == false,
 		"stale lock still exists after RemoveStaleLocks was called")
@@ -196,6 +399,138 @@ func TestLockWithStaleLock(t *testing.T) {
 	rtest.OK(t, removeLock(repo, id2))
 }
 
+func TestRemoveStaleLocksCancel(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id, err := createFakeLock(repo, time.Now().Add(-time.Hour), os.Getpid())
+	rtest.OK(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	removed, err := restic.RemoveStaleLocks(ctx, repo, restic.LockOptions{})
+	rtest.Assert(t, err != nil, "RemoveStaleLocks did not return an error for a cancelled context")
+	rtest.Equals(t, 0, len(removed))
+	rtest.Assert(t, lockExists(repo, t, id), "stale lock was removed despite cancelled context")
+}
+
+// lockModTimeBackend reports the modification times in modTimes when listing
+// files.
+type lockModTimeBackend struct {
+	restic.Backend
+	modTimes map[string]time.Time
+}
+
+func (be lockModTimeBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return be.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+		fi.ModTime = be.modTimes[fi.Name]
+		return fn(fi)
+	})
+}
+
+func TestRemoveStaleTempLocks(t *testing.T) {
+	be := lockModTimeBackend{Backend: mem.New(), modTimes: make(map[string]time.Time)}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	id, err := createFakeLock(repo, time.Now(), os.Getpid())
+	rtest.OK(t, err)
+
+	oldTemp := id.String() + restic.TempFileInfix + "0123456789abcdef"
+	newTemp := id.String() + restic.TempFileInfix + "fedcba9876543210"
+	unknownTemp := id.String() + restic.TempFileInfix + "00112233445566ff"
+	for _, name := range []string{oldTemp, newTemp, unknownTemp} {
+		h := restic.Handle{Type: restic.LockFile, Name: name}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte("partial"))))
+	}
+	be.modTimes[id.String()] = time.Now().Add(-time.Hour)
+	be.modTimes[oldTemp] = time.Now().Add(-time.Hour)
+	be.modTimes[newTemp] = time.Now()
+
+	removed, err := restic.RemoveStaleLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(removed))
+
+	for name, exists := range map[string]bool{
+		id.String(): true,
+		oldTemp:     false,
+		newTemp:     true,
+		unknownTemp: true,
+	} {
+		found, err := be.Test(context.TODO(), restic.Handle{Type: restic.LockFile, Name: name})
+		rtest.OK(t, err)
+		rtest.Assert(t, found == exists, "file %v: want exists %v, got %v", name, exists, found)
+	}
+}
+
+func TestListLocks(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id1, err := createFakeLock(repo, time.Now().Add(-time.Hour), os.Getpid())
+	rtest.OK(t, err)
+
+	id2, err := createFakeLock(repo, time.Now().Add(-time.Minute), os.Getpid())
+	rtest.OK(t, err)
+
+	// save a lock file which cannot be decoded
+	id3, err := repo.SaveUnpacked(context.TODO(), restic.LockFile, []byte("invalid"))
+	rtest.OK(t, err)
+
+	locks, err := restic.ListLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(locks))
+
+	for _, lock := range locks {
+		switch {
+		case lock.ID.Equal(id1):
+			rtest.OK(t, lock.Err)
+			rtest.Assert(t, lock.Stale, "old lock is not reported as stale")
+		case lock.ID.Equal(id2):
+			rtest.OK(t, lock.Err)
+			rtest.Assert(t, !lock.Stale, "current lock is reported as stale")
+			rtest.Equals(t, os.Getpid(), lock.PID)
+		case lock.ID.Equal(id3):
+			rtest.Assert(t, lock.Err != nil, "invalid lock was decoded without error")
+		default:
+			t.Errorf("unexpected lock %v", lock.ID)
+		}
+	}
+
+	for _, id := range []restic.ID{id1, id2, id3} {
+		rtest.Assert(t, lockExists(repo, t, id), "lock %v was removed by ListLocks", id)
+	}
+}
+
+func TestRemoveLock(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	staleID, err := createFakeLock(repo, time.Now().Add(-time.Hour), os.Getpid())
+	rtest.OK(t, err)
+
+	activeID, err := createFakeLock(repo, time.Now().Add(-time.Minute), os.Getpid())
+	rtest.OK(t, err)
+
+	invalidID, err := repo.SaveUnpacked(context.TODO(), restic.LockFile, []byte("invalid"))
+	rtest.OK(t, err)
+
+	err = restic.RemoveLock(context.TODO(), repo, activeID, false, restic.LockOptions{})
+	rtest.Assert(t, errors.Cause(err) == restic.ErrLockNotStale, "expected ErrLockNotStale, got %v", err)
+	rtest.Assert(t, lockExists(repo, t, activeID), "lock which is not stale was removed")
+
+	rtest.OK(t, restic.RemoveLock(context.TODO(), repo, staleID, false, restic.LockOptions{}))
+	rtest.Assert(t, !lockExists(repo, t, staleID), "stale lock was not removed")
+	rtest.Assert(t, lockExists(repo, t, activeID), "other lock was removed")
+
+	rtest.OK(t, restic.RemoveLock(context.TODO(), repo, invalidID, false, restic.LockOptions{}))
+	rtest.Assert(t, !lockExists(repo, t, invalidID), "invalid lock was not removed")
+
+	rtest.OK(t, restic.RemoveLock(context.TODO(), repo, activeID, true, restic.LockOptions{}))
+	rtest.Assert(t, !lockExists(repo, t, activeID), "lock was not removed with force")
+}
+
 func TestRemoveAllLocks(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -219,11 +554,54 @@ func TestRemoveAllLocks(t *testing.T) {
 		"lock still exists after RemoveAllLocks was called")
 }
 
+func TestLockFutureDated(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	hostname, err := os.Hostname()
+	rtest.OK(t, err)
+
+	id, err := createFakeLock(repo, time.Now().Add(24*time.Hour), os.Getpid())
+	rtest.OK(t, err)
+
+	lock, err := restic.LoadLock(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, lock.FutureDated(), "far-future lock is not detected")
+	rtest.Assert(t, !lock.Stale(), "far-future lock is stale by default")
+
+	_, err = restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "expected ErrAlreadyLocked, got %v", err)
+	rtest.Assert(t, strings.Contains(err.Error(), "in the future") && strings.Contains(err.Error(), hostname),
+		"error does not mention the future-dated lock and its host: %v", err)
+
+	locks, err := restic.ListLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(locks))
+	rtest.Assert(t, locks[0].FutureDated, "listed lock is not marked as future-dated")
+
+	locks, err = restic.ListLocks(context.TODO(), repo, restic.LockOptions{ClockSkew: 48 * time.Hour})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(locks))
+	rtest.Assert(t, !locks[0].FutureDated, "lock within the clock skew allowance is future-dated")
+
+	removed, err := restic.RemoveStaleLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(removed))
+
+	removed, err = restic.RemoveStaleLocks(context.TODO(), repo, restic.LockOptions{ClockSkew: time.Hour, StaleFutureLocks: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.IDs{id}, removed)
+
+	excl, err := restic.NewExclusiveLock(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.OK(t, excl.Unlock())
+}
+
 func TestLockRefresh(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
 
-	lock, err := restic.NewLock(context.TODO(), repo)
+	lock, err := restic.NewLock(context.TODO(), repo, restic.LockOptions{})
 	rtest.OK(t, err)
 	time0 := lock.Time
 
@@ -262,3 +640,82 @@ func TestLockRefresh(t *testing.T) {
 		"expected a later timestamp after lock refresh")
 	rtest.OK(t, lock.Unlock())
 }
+
+// slowSaveBackend delays saving files, so that a refresh takes a while.
+type slowSaveBackend struct {
+	restic.Backend
+}
+
+func (be slowSaveBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestLockRefreshUnlockRace(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithBackend(t, slowSaveBackend{Backend: mem.New()})
+	defer cleanup()
+
+	restic.TestSetLockTimeout(t, 0)
+	opts := restic.LockOptions{RefreshInterval: 100 * time.Microsecond}
+
+	for i := 0; i < 50; i++ {
+		lock, err := restic.NewLock(context.TODO(), repo, opts)
+		rtest.OK(t, err)
+
+		lock.StartRefresh(func(err error) {
+			t.Errorf("refresh failed: %v", err)
+		})
+		time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+		rtest.OK(t, lock.Unlock())
+
+		// a refresh must not be started after unlocking
+		lock.StartRefresh(func(err error) {
+			t.Errorf("refresh failed: %v", err)
+		})
+		rtest.Assert(t, lock.Refresh(context.TODO()) != nil, "unlocked lock was refreshed")
+
+		locks, err := restic.ListLocks(context.TODO(), repo, restic.LockOptions{})
+		rtest.OK(t, err)
+		rtest.Equals(t, 0, len(locks))
+	}
+}
+
+// removeCountingBackend counts the calls to Remove.
+type removeCountingBackend struct {
+	restic.Backend
+	removes int
+}
+
+func (be *removeCountingBackend) Remove(ctx context.Context, h restic.Handle) error {
+	be.removes++
+	return be.Backend.Remove(ctx, h)
+}
+
+func TestLockAppendOnly(t *testing.T) {
+	be := &removeCountingBackend{Backend: mem.New()}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	opts := restic.LockOptions{AppendOnly: true}
+	lock, err := restic.NewLock(context.TODO(), repo, opts)
+	rtest.OK(t, err)
+
+	_, err = restic.NewExclusiveLock(context.TODO(), repo, opts)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create exclusive lock on locked repo didn't return the correct error, got %v", err)
+
+	rtest.OK(t, lock.Refresh(context.TODO()))
+	rtest.OK(t, lock.Unlock())
+
+	// the released lock files are ignored
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo, opts)
+	rtest.OK(t, err)
+	rtest.OK(t, elock.Unlock())
+
+	rtest.Equals(t, 0, be.removes)
+
+	// all lock files are left behind
+	locks, err := restic.ListLocks(context.TODO(), repo, restic.LockOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(locks))
+}
@@ -31,8 +31,9 @@ func uidGidInt(u user.User) (uid, gid uint32, err error) {
 
 // checkProcess will check if the process retaining the lock
 // exists and responds to SIGHUP signal.
-// Returns true if the process exists and responds.
-func (l Lock) processExists() bool {
+// Returns true if the process exists, responds and was started at the time
+// recorded in the lock.
+func (l *Lock) processExists() bool {
 	proc, err := os.FindProcess(l.PID)
 	if err != nil {
 		debug.Log("error searching for process %d: %v\n", l.PID, err)
@@ -46,5 +47,10 @@ func (l Lock) processExists() bool {
 		debug.Log("signal error: %v, lock is probably stale\n", err)
 		return false
 	}
+
+	if l.processReused() {
+		debug.Log("PID %d was reused by another process, lock is probably stale\n", l.PID)
+		return false
+	}
 	return true
 }
@@ -14,12 +14,17 @@ func uidGidInt(u user.User) (uid, gid uint32, err error) {
 
 // checkProcess will check if the process retaining the lock exists.
 // Returns true if the process exists.
-func (l Lock) processExists() bool {
+func (l *Lock) processExists() bool {
 	proc, err := os.FindProcess(l.PID)
 	if err != nil {
 		debug.Log("error searching for process %d: %v\n", l.PID, err)
 		return false
 	}
 	proc.Release()
+
+	if l.processReused() {
+		debug.Log("PID %d was reused by another process, lock is probably stale\n", l.PID)
+		return false
+	}
 	return true
 }
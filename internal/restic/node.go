@@ -46,8 +46,14 @@ type Node struct {
 	ExtendedAttributes []ExtendedAttribute `json:"extended_attributes,omitempty"`
 	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
 	Content            IDs                 `json:"content"`
+	ContentHash        string              `json:"content_hash,omitempty"` // hex-encoded SHA-256 of the file's content
 	Subtree            *ID                 `json:"subtree,omitempty"`
 
+	// WindowsAttributes contains the file attributes on Windows, it is only
+	// set if Windows metadata is saved, see FillWindowsMetadata.
+	WindowsAttributes    uint32                `json:"windows_attributes,omitempty"`
+	AlternateDataStreams []AlternateDataStream `json:"alternate_data_streams,omitempty"`
+
 	Error string `json:"error,omitempty"`
 
 	Path string `json:"-"`
@@ -172,7 +178,8 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo Repository) er
 	return nil
 }
 
-// RestoreMetadata restores node metadata
+// RestoreMetadata restores node metadata. ACLs are not restored, see
+// RestoreACLs.
 func (node Node) RestoreMetadata(path string) error {
 	err := node.restoreMetadata(path)
 	if err != nil {
@@ -206,6 +213,13 @@ func (node Node) restoreMetadata(path string) error {
 		}
 	}
 
+	if err := node.restoreAlternateDataStreams(path); err != nil {
+		debug.Log("error restoring alternate data streams for %v: %v", path, err)
+		if firsterr == nil {
+			firsterr = err
+		}
+	}
+
 	if err := node.RestoreTimestamps(path); err != nil {
 		debug.Log("error restoring timestamps for dir %v: %v", path, err)
 		if firsterr != nil {
@@ -220,11 +234,24 @@ func (node Node) restoreMetadata(path string) error {
 		}
 	}
 
+	if err := node.restoreWindowsAttributes(path); err != nil {
+		debug.Log("error restoring file attributes for %v: %v", path, err)
+		if firsterr == nil {
+			firsterr = err
+		}
+	}
+
 	return firsterr
 }
 
+// restoreExtendedAttributes restores all extended attributes except for ACLs,
+// which are restored by RestoreACLs.
 func (node Node) restoreExtendedAttributes(path string) error {
 	for _, attr := range node.ExtendedAttributes {
+		if IsACLAttribute(attr.Name) {
+			continue
+		}
+
 		err := Setxattr(path, attr.Name, attr.Value)
 		if err != nil {
 			return err
@@ -427,9 +454,18 @@ func (node Node) Equals(other Node) bool {
 	if !node.sameContent(other) {
 		return false
 	}
+	if node.ContentHash != other.ContentHash {
+		return false
+	}
 	if !node.sameExtendedAttributes(other) {
 		return false
 	}
+	if node.WindowsAttributes != other.WindowsAttributes {
+		return false
+	}
+	if !node.sameAlternateDataStreams(other) {
+		return false
+	}
 	if node.Subtree != nil {
 		if other.Subtree == nil {
 			return false
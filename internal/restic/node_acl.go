@@ -0,0 +1,49 @@
+package restic
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Names of the extended attributes in which Linux stores POSIX ACLs. They are
+// saved like other extended attributes, but only restored with RestoreACLs.
+const (
+	ACLAccessAttribute  = "system.posix_acl_access"
+	ACLDefaultAttribute = "system.posix_acl_default"
+)
+
+// ErrACLNotSupported is returned by RestoreACLs if the file system or the
+// platform does not support POSIX ACLs.
+var ErrACLNotSupported = errors.New("ACLs are not supported")
+
+// IsACLAttribute returns true if the extended attribute name contains an ACL.
+func IsACLAttribute(name string) bool {
+	return strings.HasPrefix(name, "system.posix_acl_")
+}
+
+// HasACLs returns true if ACLs are stored for the node.
+func (node Node) HasACLs() bool {
+	for _, attr := range node.ExtendedAttributes {
+		if IsACLAttribute(attr.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreACLs applies the ACLs stored for the node to path. ACLs are restored
+// after the other metadata, as changing the mode of a file also changes its
+// access ACL.
+func (node Node) RestoreACLs(path string) error {
+	for _, attr := range node.ExtendedAttributes {
+		if !IsACLAttribute(attr.Name) {
+			continue
+		}
+
+		if err := setACL(path, attr.Name, attr.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package restic
+
+import (
+	"syscall"
+
+	"github.com/pkg/xattr"
+	"github.com/restic/restic/internal/errors"
+)
+
+// setACL stores the ACL in the extended attribute name of path.
+func setACL(path, name string, data []byte) error {
+	e := xattr.Set(path, name, data)
+	if err, ok := e.(*xattr.Error); ok && err.Err == syscall.ENOTSUP {
+		return ErrACLNotSupported
+	}
+	return errors.Wrap(e, "Setxattr")
+}
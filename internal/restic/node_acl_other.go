@@ -0,0 +1,8 @@
+// +build !linux
+
+package restic
+
+// setACL returns ErrACLNotSupported, POSIX ACLs are only restored on Linux.
+func setACL(path, name string, data []byte) error {
+	return ErrACLNotSupported
+}
@@ -1,7 +1,9 @@
 package restic_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -295,3 +297,34 @@ func TestFixTime(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeWindowsMetadataJSON(t *testing.T) {
+	node := restic.Node{Name: "foo", Type: "file"}
+
+	buf, err := json.Marshal(node)
+	rtest.OK(t, err)
+	for _, key := range []string{"windows_attributes", "alternate_data_streams"} {
+		if bytes.Contains(buf, []byte(key)) {
+			t.Errorf("node without Windows metadata contains key %q: %s", key, buf)
+		}
+	}
+
+	node.WindowsAttributes = restic.WindowsAttributeHidden | restic.WindowsAttributeArchive
+	node.AlternateDataStreams = []restic.AlternateDataStream{
+		{Name: "Zone.Identifier", Value: []byte("[ZoneTransfer]\r\nZoneId=3\r\n")},
+	}
+
+	buf, err = json.Marshal(node)
+	rtest.OK(t, err)
+
+	var res restic.Node
+	rtest.OK(t, json.Unmarshal(buf, &res))
+	if !node.Equals(res) {
+		t.Fatalf("wrong node after decoding, want:\n  %#v\ngot:\n  %#v", node, res)
+	}
+
+	res.AlternateDataStreams[0].Value = []byte("ZoneId=0")
+	if node.Equals(res) {
+		t.Fatal("nodes with different alternate data streams are equal")
+	}
+}
@@ -0,0 +1,49 @@
+package restic
+
+import "bytes"
+
+// AlternateDataStream is a named data stream of a file or directory on NTFS,
+// in addition to the main content.
+type AlternateDataStream struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+// File attributes on Windows which are saved with FillWindowsMetadata.
+// Other attributes, e.g. for compressed or encrypted files, cannot be set
+// directly and are not restored.
+const (
+	WindowsAttributeReadonly          = 0x1
+	WindowsAttributeHidden            = 0x2
+	WindowsAttributeSystem            = 0x4
+	WindowsAttributeArchive           = 0x20
+	WindowsAttributeNotContentIndexed = 0x2000
+
+	windowsAttributesMask = WindowsAttributeReadonly | WindowsAttributeHidden |
+		WindowsAttributeSystem | WindowsAttributeArchive | WindowsAttributeNotContentIndexed
+)
+
+// FillWindowsMetadata stores the file attributes and alternate data streams
+// of path in the node. On other platforms than Windows, nothing is done.
+func (node *Node) FillWindowsMetadata(path string) error {
+	if node.Type != "file" && node.Type != "dir" {
+		return nil
+	}
+	return node.fillWindowsMetadata(path)
+}
+
+func (node Node) sameAlternateDataStreams(other Node) bool {
+	if len(node.AlternateDataStreams) != len(other.AlternateDataStreams) {
+		return false
+	}
+
+	for i, stream := range node.AlternateDataStreams {
+		if stream.Name != other.AlternateDataStreams[i].Name {
+			return false
+		}
+		if !bytes.Equal(stream.Value, other.AlternateDataStreams[i].Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+// +build !windows
+
+package restic
+
+// fillWindowsMetadata does nothing, file attributes and alternate data streams
+// only exist on Windows.
+func (node *Node) fillWindowsMetadata(path string) error {
+	return nil
+}
+
+// restoreAlternateDataStreams does nothing, alternate data streams can only be
+// restored on Windows.
+func (node Node) restoreAlternateDataStreams(path string) error {
+	return nil
+}
+
+// restoreWindowsAttributes does nothing, file attributes can only be restored
+// on Windows.
+func (node Node) restoreWindowsAttributes(path string) error {
+	return nil
+}
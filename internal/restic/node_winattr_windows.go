@@ -0,0 +1,157 @@
+package restic
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is the WIN32_FIND_STREAM_DATA structure returned by
+// FindFirstStreamW and FindNextStreamW.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+func (node *Node) fillWindowsMetadata(path string) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return errors.Wrap(err, "UTF16PtrFromString")
+	}
+
+	attrs, err := windows.GetFileAttributes(p)
+	if err != nil {
+		return errors.Wrap(err, "GetFileAttributes")
+	}
+	node.WindowsAttributes = attrs
+
+	names, err := listAlternateDataStreams(p)
+	if err != nil {
+		return err
+	}
+
+	node.AlternateDataStreams = nil
+	for _, name := range names {
+		value, err := readAlternateDataStream(path, name)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read alternate data stream %v", name)
+		}
+
+		node.AlternateDataStreams = append(node.AlternateDataStreams, AlternateDataStream{
+			Name:  name,
+			Value: value,
+		})
+	}
+
+	return nil
+}
+
+// readAlternateDataStream returns the content of the alternate data stream
+// name of path.
+func readAlternateDataStream(path, name string) ([]byte, error) {
+	f, err := fs.Open(path + ":" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := ioutil.ReadAll(f)
+	closeErr := f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return value, closeErr
+}
+
+// listAlternateDataStreams returns the names of the alternate data streams of
+// path, without the main data stream.
+func listAlternateDataStreams(path *uint16) ([]string, error) {
+	var data win32FindStreamData
+	r, _, e := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(path)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	handle := windows.Handle(r)
+	if handle == windows.InvalidHandle {
+		if e == windows.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, errors.Wrap(e, "FindFirstStreamW")
+	}
+	defer func() {
+		_ = windows.FindClose(handle)
+	}()
+
+	var names []string
+	for {
+		// stream names have the form ":name:$DATA", the main data stream
+		// has an empty name
+		name := syscall.UTF16ToString(data.StreamName[:])
+		name = strings.TrimSuffix(strings.TrimPrefix(name, ":"), ":$DATA")
+		if name != "" {
+			names = append(names, name)
+		}
+
+		ok, _, e := procFindNextStreamW.Call(uintptr(handle), uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if e == windows.ERROR_HANDLE_EOF {
+				return names, nil
+			}
+			return nil, errors.Wrap(e, "FindNextStreamW")
+		}
+	}
+}
+
+// restoreAlternateDataStreams writes the alternate data streams of the node to
+// path. This changes the modification time, so it must be done before the
+// timestamps are restored.
+func (node Node) restoreAlternateDataStreams(path string) error {
+	for _, stream := range node.AlternateDataStreams {
+		debug.Log("restore alternate data stream %v of %v", stream.Name, path)
+		f, err := fs.OpenFile(path+":"+stream.Name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return errors.Wrap(err, "OpenFile")
+		}
+
+		_, err = f.Write(stream.Value)
+		closeErr := f.Close()
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+		if closeErr != nil {
+			return errors.Wrap(closeErr, "Close")
+		}
+	}
+	return nil
+}
+
+// restoreWindowsAttributes sets the file attributes of path. This must be done
+// last, as the readonly attribute is also changed by Chmod.
+func (node Node) restoreWindowsAttributes(path string) error {
+	if node.WindowsAttributes == 0 {
+		// no attributes were saved for the node
+		return nil
+	}
+
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return errors.Wrap(err, "UTF16PtrFromString")
+	}
+
+	attrs, err := windows.GetFileAttributes(p)
+	if err != nil {
+		return errors.Wrap(err, "GetFileAttributes")
+	}
+
+	attrs = attrs&^windowsAttributesMask | node.WindowsAttributes&windowsAttributesMask
+	return errors.Wrap(windows.SetFileAttributes(p, attrs), "SetFileAttributes")
+}
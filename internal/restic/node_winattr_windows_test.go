@@ -0,0 +1,60 @@
+package restic_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/sys/windows"
+)
+
+func getFileAttributes(t testing.TB, path string) uint32 {
+	p, err := windows.UTF16PtrFromString(path)
+	rtest.OK(t, err)
+	attrs, err := windows.GetFileAttributes(p)
+	rtest.OK(t, err)
+	return attrs
+}
+
+func TestNodeWindowsMetadataRoundTrip(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(path, []byte("content"), 0600))
+	rtest.OK(t, ioutil.WriteFile(path+":stream", []byte("alternate content"), 0600))
+
+	p, err := windows.UTF16PtrFromString(path)
+	rtest.OK(t, err)
+	rtest.OK(t, windows.SetFileAttributes(p, getFileAttributes(t, path)|restic.WindowsAttributeHidden))
+
+	fi, err := os.Lstat(path)
+	rtest.OK(t, err)
+	node, err := restic.NodeFromFileInfo(path, fi)
+	rtest.OK(t, err)
+	rtest.OK(t, node.FillWindowsMetadata(path))
+
+	if node.WindowsAttributes&restic.WindowsAttributeHidden == 0 {
+		t.Fatalf("hidden attribute not saved, attributes are %#x", node.WindowsAttributes)
+	}
+	if len(node.AlternateDataStreams) != 1 || node.AlternateDataStreams[0].Name != "stream" {
+		t.Fatalf("wrong alternate data streams saved: %v", node.AlternateDataStreams)
+	}
+
+	target := filepath.Join(tempdir, "restored")
+	node.Content = nil
+	rtest.OK(t, node.CreateAt(context.Background(), target, nil))
+	rtest.OK(t, node.RestoreMetadata(target))
+
+	if getFileAttributes(t, target)&restic.WindowsAttributeHidden == 0 {
+		t.Errorf("hidden attribute was not restored")
+	}
+
+	buf, err := ioutil.ReadFile(target + ":stream")
+	rtest.OK(t, err)
+	rtest.Equals(t, "alternate content", string(buf))
+}
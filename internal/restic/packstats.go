@@ -0,0 +1,88 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// PackStats describes how many of the blobs in a pack are still in use.
+type PackStats struct {
+	ID ID `json:"id"`
+
+	// Blobs is the number of blobs in the pack, UsedBlobs the number of
+	// blobs which are still referenced.
+	Blobs     int `json:"blobs"`
+	UsedBlobs int `json:"used_blobs"`
+
+	// Size is the size of all blobs in the pack as stored in the repository,
+	// UsedSize the size of the referenced blobs.
+	Size     uint64 `json:"size"`
+	UsedSize uint64 `json:"used_size"`
+}
+
+// Wasted returns the percentage of the pack's size taken up by blobs which
+// are not referenced anymore.
+func (s PackStats) Wasted() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return 100 * float64(s.Size-s.UsedSize) / float64(s.Size)
+}
+
+// PackStatsHistogramBuckets is the number of buckets of the histogram of
+// wasted space, each bucket spans the same range of percentages.
+const PackStatsHistogramBuckets = 10
+
+// PackFragmentation collects the statistics of all packs in the index.
+type PackFragmentation struct {
+	Packs []PackStats `json:"packs"`
+
+	// Histogram counts the packs by wasted space, bucket i contains the
+	// packs with i*10% up to (i+1)*10% wasted space. Packs without any
+	// referenced blobs are counted in the last bucket.
+	Histogram [PackStatsHistogramBuckets]int `json:"histogram"`
+}
+
+// ComputePackStats returns the statistics for all packs in the index of
+// repo. usedBlobs contains the blobs which are referenced, e.g. as computed
+// by FindUsedBlobs for all snapshots. The packs are sorted by wasted space in
+// descending order.
+func ComputePackStats(ctx context.Context, repo Repository, usedBlobs BlobSet) *PackFragmentation {
+	packs := make(map[ID]*PackStats)
+	for pb := range repo.Index().Each(ctx) {
+		s, ok := packs[pb.PackID]
+		if !ok {
+			s = &PackStats{ID: pb.PackID}
+			packs[pb.PackID] = s
+		}
+
+		s.Blobs++
+		s.Size += uint64(pb.Length)
+		if usedBlobs.Has(BlobHandle{ID: pb.ID, Type: pb.Type}) {
+			s.UsedBlobs++
+			s.UsedSize += uint64(pb.Length)
+		}
+	}
+
+	res := &PackFragmentation{Packs: make([]PackStats, 0, len(packs))}
+	for _, s := range packs {
+		res.Packs = append(res.Packs, *s)
+
+		bucket := int(s.Wasted() * PackStatsHistogramBuckets / 100)
+		if bucket >= PackStatsHistogramBuckets {
+			bucket = PackStatsHistogramBuckets - 1
+		}
+		res.Histogram[bucket]++
+	}
+
+	sort.Slice(res.Packs, func(i, j int) bool {
+		wi, wj := res.Packs[i].Wasted(), res.Packs[j].Wasted()
+		if wi != wj {
+			return wi > wj
+		}
+		return bytes.Compare(res.Packs[i].ID[:], res.Packs[j].ID[:]) < 0
+	})
+
+	return res
+}
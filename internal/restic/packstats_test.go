@@ -0,0 +1,81 @@
+package restic_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestComputePackStats(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+
+	saveBlob := func(data string) restic.ID {
+		id, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(data), restic.ID{})
+		rtest.OK(t, err)
+		return id
+	}
+
+	// the first pack contains three blobs, of which only one is referenced
+	a := saveBlob(strings.Repeat("a", 100))
+	b := saveBlob(strings.Repeat("b", 200))
+	c := saveBlob(strings.Repeat("c", 300))
+	rtest.OK(t, repo.Flush(ctx))
+
+	d := saveBlob(strings.Repeat("d", 400))
+	rtest.OK(t, repo.Flush(ctx))
+
+	tree := saveDedupTree(t, repo,
+		dedupFileNode("file1", restic.IDs{a}, 100),
+		dedupFileNode("file2", restic.IDs{d}, 400),
+	)
+	rtest.OK(t, repo.Flush(ctx))
+
+	usedBlobs := restic.NewBlobSet()
+	rtest.OK(t, restic.FindUsedBlobs(ctx, repo, tree, usedBlobs, restic.NewBlobSet()))
+
+	stats := restic.ComputePackStats(ctx, repo, usedBlobs)
+	rtest.Equals(t, 3, len(stats.Packs))
+
+	length := func(id restic.ID) uint64 {
+		blobs, found := repo.Index().Lookup(id, restic.DataBlob)
+		rtest.Assert(t, found, "blob %v not found", id.Str())
+		return uint64(blobs[0].Length)
+	}
+
+	// the packs are sorted by wasted space
+	partial := stats.Packs[0]
+	rtest.Equals(t, 3, partial.Blobs)
+	rtest.Equals(t, 1, partial.UsedBlobs)
+	rtest.Equals(t, length(a)+length(b)+length(c), partial.Size)
+	rtest.Equals(t, length(a), partial.UsedSize)
+	rtest.Equals(t, uint64(restic.CiphertextLength(100)), partial.UsedSize)
+
+	wasted := 100 * float64(length(b)+length(c)) / float64(partial.Size)
+	rtest.Equals(t, wasted, partial.Wasted())
+
+	for _, pack := range stats.Packs[1:] {
+		rtest.Equals(t, 1, pack.Blobs)
+		rtest.Equals(t, 1, pack.UsedBlobs)
+		rtest.Equals(t, pack.Size, pack.UsedSize)
+		rtest.Equals(t, float64(0), pack.Wasted())
+	}
+
+	var histogram [restic.PackStatsHistogramBuckets]int
+	histogram[0] = 2
+	histogram[int(wasted/10)] = 1
+	rtest.Equals(t, histogram, stats.Histogram)
+
+	// without any referenced blobs, all packs are in the last bucket
+	stats = restic.ComputePackStats(ctx, repo, restic.NewBlobSet())
+	for _, pack := range stats.Packs {
+		rtest.Equals(t, float64(100), pack.Wasted())
+	}
+	rtest.Equals(t, 3, stats.Histogram[restic.PackStatsHistogramBuckets-1])
+}
@@ -38,6 +38,9 @@ type Repository interface {
 
 	SaveUnpacked(context.Context, FileType, []byte) (ID, error)
 	SaveJSONUnpacked(context.Context, FileType, interface{}) (ID, error)
+	// SaveJSONUnpackedDeterministic works like SaveJSONUnpacked, but saving
+	// the same item again yields the same ID.
+	SaveJSONUnpackedDeterministic(context.Context, FileType, interface{}) (ID, error)
 
 	LoadJSONUnpacked(ctx context.Context, t FileType, id ID, dest interface{}) error
 	// LoadAndDecrypt loads and decrypts the file with the given type and ID,
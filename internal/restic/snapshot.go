@@ -12,17 +12,26 @@ import (
 
 // Snapshot is the state of a resource at one point in time.
 type Snapshot struct {
-	Time     time.Time `json:"time"`
-	Parent   *ID       `json:"parent,omitempty"`
-	Tree     *ID       `json:"tree"`
-	Paths    []string  `json:"paths"`
-	Hostname string    `json:"hostname,omitempty"`
-	Username string    `json:"username,omitempty"`
-	UID      uint32    `json:"uid,omitempty"`
-	GID      uint32    `json:"gid,omitempty"`
-	Excludes []string  `json:"excludes,omitempty"`
-	Tags     []string  `json:"tags,omitempty"`
-	Original *ID       `json:"original,omitempty"`
+	Time        time.Time `json:"time"`
+	Parent      *ID       `json:"parent,omitempty"`
+	Tree        *ID       `json:"tree"`
+	Paths       []string  `json:"paths"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	UID         uint32    `json:"uid,omitempty"`
+	GID         uint32    `json:"gid,omitempty"`
+	Excludes    []string  `json:"excludes,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Original    *ID       `json:"original,omitempty"`
+
+	// MetadataOnly is set for snapshots which contain the files without
+	// their content.
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+
+	// Checkpoint is set for partial snapshots of a backup which is still
+	// running or has been interrupted.
+	Checkpoint bool `json:"checkpoint,omitempty"`
 
 	id *ID // plaintext ID, used during restore
 }
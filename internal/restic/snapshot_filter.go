@@ -0,0 +1,268 @@
+package restic
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// SnapshotFilter is a boolean expression over the metadata of a snapshot. It
+// is parsed by ParseSnapshotFilter.
+type SnapshotFilter interface {
+	// Match returns true if the snapshot is selected by the filter.
+	Match(sn *Snapshot) bool
+
+	// String returns the expression with explicit parentheses.
+	String() string
+}
+
+type filterAnd struct {
+	left, right SnapshotFilter
+}
+
+func (f filterAnd) Match(sn *Snapshot) bool {
+	return f.left.Match(sn) && f.right.Match(sn)
+}
+
+func (f filterAnd) String() string {
+	return "(" + f.left.String() + " AND " + f.right.String() + ")"
+}
+
+type filterOr struct {
+	left, right SnapshotFilter
+}
+
+func (f filterOr) Match(sn *Snapshot) bool {
+	return f.left.Match(sn) || f.right.Match(sn)
+}
+
+func (f filterOr) String() string {
+	return "(" + f.left.String() + " OR " + f.right.String() + ")"
+}
+
+type filterNot struct {
+	expr SnapshotFilter
+}
+
+func (f filterNot) Match(sn *Snapshot) bool {
+	return !f.expr.Match(sn)
+}
+
+func (f filterNot) String() string {
+	return "NOT " + f.expr.String()
+}
+
+// filterTerm matches a single key against the snapshot's metadata.
+type filterTerm struct {
+	key, value string
+}
+
+func (f filterTerm) Match(sn *Snapshot) bool {
+	switch f.key {
+	case "tag":
+		return sn.hasTag(f.value)
+	case "host":
+		return sn.Hostname == f.value
+	case "path":
+		return sn.hasPath(f.value)
+	case "id":
+		return sn.id != nil && strings.HasPrefix(sn.id.String(), f.value)
+	}
+
+	return false
+}
+
+func (f filterTerm) String() string {
+	value := f.value
+	if strings.IndexFunc(value, func(r rune) bool { return unicode.IsSpace(r) || r == '(' || r == ')' }) >= 0 {
+		value = fmt.Sprintf("%q", value)
+	}
+	return f.key + ":" + value
+}
+
+var snapshotFilterKeys = map[string]struct{}{
+	"tag":  {},
+	"host": {},
+	"path": {},
+	"id":   {},
+}
+
+// filterParser is a recursive descent parser for snapshot filter expressions.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenizeFilter splits s into parentheses and words. Double quotes within a
+// word can be used to include whitespace and parentheses in a value.
+func tokenizeFilter(s string) ([]string, error) {
+	var tokens []string
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		switch {
+		case unicode.IsSpace(rs[i]):
+			i++
+		case rs[i] == '(' || rs[i] == ')':
+			tokens = append(tokens, string(rs[i]))
+			i++
+		default:
+			var word []rune
+			inQuotes := false
+			for ; i < len(rs); i++ {
+				r := rs[i]
+				if r == '"' {
+					inQuotes = !inQuotes
+					continue
+				}
+				if !inQuotes && (unicode.IsSpace(r) || r == '(' || r == ')') {
+					break
+				}
+				word = append(word, r)
+			}
+			if inQuotes {
+				return nil, errors.Errorf("unterminated quote in filter %q", s)
+			}
+			tokens = append(tokens, string(word))
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) isKeyword(kw string) bool {
+	return strings.ToUpper(p.peek()) == kw
+}
+
+// parseOr parses: and { "OR" and }
+func (p *filterParser) parseOr() (SnapshotFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: not { "AND" not }
+func (p *filterParser) parseAnd() (SnapshotFilter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseNot parses: "NOT" not | primary
+func (p *filterParser) parseNot() (SnapshotFilter, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary parses: "(" or ")" | key ":" value
+func (p *filterParser) parsePrimary() (SnapshotFilter, error) {
+	tok := p.peek()
+	switch {
+	case p.pos >= len(p.tokens):
+		return nil, errors.New("unexpected end of filter expression")
+	case tok == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case tok == ")":
+		return nil, errors.New("unexpected closing parenthesis")
+	case p.isKeyword("AND") || p.isKeyword("OR"):
+		return nil, errors.Errorf("unexpected operator %q", tok)
+	}
+
+	p.pos++
+	pos := strings.Index(tok, ":")
+	if pos < 0 {
+		return nil, errors.Errorf("invalid term %q, expected key:value", tok)
+	}
+
+	key, value := tok[:pos], tok[pos+1:]
+	if _, ok := snapshotFilterKeys[key]; !ok {
+		return nil, errors.Errorf("unknown key %q in term %q", key, tok)
+	}
+	if value == "" {
+		return nil, errors.Errorf("empty value in term %q", tok)
+	}
+
+	return filterTerm{key: key, value: value}, nil
+}
+
+// ParseSnapshotFilter parses a filter expression like
+//
+//     tag:daily AND (host:web1 OR host:web2) AND NOT tag:manual
+//
+// Terms are of the form key:value, supported keys are tag, host, path and id
+// (which matches a prefix of the snapshot ID). Terms can be combined with
+// the operators NOT, AND and OR (in decreasing order of precedence) and
+// grouped with parentheses. Values containing spaces or parentheses can be
+// put in double quotes.
+func ParseSnapshotFilter(s string) (SnapshotFilter, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty filter expression")
+	}
+
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid filter %q", s)
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, errors.Errorf("invalid filter %q: unexpected %q", s, p.peek())
+	}
+
+	return expr, nil
+}
@@ -0,0 +1,104 @@
+package restic
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseSnapshotFilter(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  string
+	}{
+		{"tag:daily", "tag:daily"},
+		{"tag:daily AND host:web1", "(tag:daily AND host:web1)"},
+		{"tag:a OR tag:b AND tag:c", "(tag:a OR (tag:b AND tag:c))"},
+		{"tag:a AND tag:b OR tag:c", "((tag:a AND tag:b) OR tag:c)"},
+		{"(tag:a OR tag:b) AND tag:c", "((tag:a OR tag:b) AND tag:c)"},
+		{"NOT tag:a AND tag:b", "(NOT tag:a AND tag:b)"},
+		{"NOT (tag:a AND tag:b)", "NOT (tag:a AND tag:b)"},
+		{"NOT NOT tag:a", "NOT NOT tag:a"},
+		{"tag:a OR tag:b OR tag:c", "((tag:a OR tag:b) OR tag:c)"},
+		{"tag:a and not tag:b or tag:c", "((tag:a AND NOT tag:b) OR tag:c)"},
+		{"tag:daily AND (host:web1 OR host:web2) AND NOT tag:manual", "((tag:daily AND (host:web1 OR host:web2)) AND NOT tag:manual)"},
+		{"((tag:a))", "tag:a"},
+		{"path:/home/user AND id:c0ff33", "(path:/home/user AND id:c0ff33)"},
+		{`path:"/home/my files"`, `path:"/home/my files"`},
+		{"tag:a:b", "tag:a:b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			f, err := ParseSnapshotFilter(test.input)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, f.String())
+		})
+	}
+}
+
+func TestParseSnapshotFilterInvalid(t *testing.T) {
+	var tests = []string{
+		"",
+		"   ",
+		"tag",
+		"tag:",
+		"foo:bar",
+		"tag:a AND",
+		"AND tag:a",
+		"tag:a OR OR tag:b",
+		"tag:a tag:b",
+		"(tag:a",
+		"tag:a)",
+		"()",
+		"NOT",
+		`tag:"unterminated`,
+	}
+
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			f, err := ParseSnapshotFilter(test)
+			rtest.Assert(t, err != nil, "expected error for %q, got filter %v", test, f)
+		})
+	}
+}
+
+func TestSnapshotFilterMatch(t *testing.T) {
+	id := TestParseID("c0ff33c0ffee0000000000000000000000000000000000000000000000000000")
+	sn := &Snapshot{
+		Hostname: "web1",
+		Tags:     []string{"daily", "prod"},
+		Paths:    []string{"/srv", "/home/my files"},
+		id:       &id,
+	}
+
+	var tests = []struct {
+		filter string
+		match  bool
+	}{
+		{"tag:daily", true},
+		{"tag:weekly", false},
+		{"host:web1", true},
+		{"host:web", false},
+		{"path:/srv", true},
+		{`path:"/home/my files"`, true},
+		{"path:/home", false},
+		{"id:c0ff33", true},
+		{"id:c0ffee", false},
+		{"tag:daily AND (host:web1 OR host:web2) AND NOT tag:manual", true},
+		{"tag:daily AND (host:web2 OR host:web3)", false},
+		{"tag:daily AND NOT tag:prod", false},
+		{"tag:weekly OR tag:prod", true},
+		{"NOT tag:weekly", true},
+		{"tag:weekly OR tag:daily AND host:web2", false},
+		{"(tag:weekly OR tag:daily) AND host:web1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			f, err := ParseSnapshotFilter(test.filter)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.match, f.Match(sn))
+		})
+	}
+}
@@ -14,7 +14,20 @@ import (
 var ErrNoSnapshotFound = errors.New("no snapshot found")
 
 // FindLatestSnapshot finds latest snapshot with optional target/directory, tags and hostname filters.
+// Checkpoint snapshots of interrupted backups are not considered, as they are
+// incomplete.
 func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostname string) (ID, error) {
+	return findLatestSnapshot(ctx, repo, targets, tagLists, hostname, false)
+}
+
+// FindLatestSnapshotOrCheckpoint works like FindLatestSnapshot, but also
+// considers checkpoint snapshots. It is used to find the parent of a backup,
+// so that an interrupted backup is resumed from its last checkpoint.
+func FindLatestSnapshotOrCheckpoint(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostname string) (ID, error) {
+	return findLatestSnapshot(ctx, repo, targets, tagLists, hostname, true)
+}
+
+func findLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostname string, checkpoints bool) (ID, error) {
 	var err error
 	absTargets := make([]string, 0, len(targets))
 	for _, target := range targets {
@@ -42,6 +55,10 @@ func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string,
 			return nil
 		}
 
+		if snapshot.Checkpoint && !checkpoints {
+			return nil
+		}
+
 		if !snapshot.HasTagList(tagLists) {
 			return nil
 		}
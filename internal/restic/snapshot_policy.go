@@ -8,8 +8,38 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 )
 
+// BucketSelection determines which snapshot is kept for an hour, day, week,
+// month or year with several snapshots.
+type BucketSelection int
+
+const (
+	// SelectLast keeps the newest snapshot of each bucket.
+	SelectLast BucketSelection = iota
+	// SelectFirst keeps the oldest snapshot of each bucket.
+	SelectFirst
+)
+
+// ParseBucketSelection parses "first" or "last".
+func ParseBucketSelection(s string) (BucketSelection, error) {
+	switch s {
+	case "last":
+		return SelectLast, nil
+	case "first":
+		return SelectFirst, nil
+	}
+	return SelectLast, errors.Errorf("invalid bucket selection %q, must be first or last", s)
+}
+
+func (s BucketSelection) String() string {
+	if s == SelectFirst {
+		return "first"
+	}
+	return "last"
+}
+
 // ExpirePolicy configures which snapshots should be automatically removed.
 type ExpirePolicy struct {
 	Last    int       // keep the last n snapshots
@@ -18,8 +48,13 @@ type ExpirePolicy struct {
 	Weekly  int       // keep the last n weekly snapshots
 	Monthly int       // keep the last n monthly snapshots
 	Yearly  int       // keep the last n yearly snapshots
+	Weekday int       // keep the last n daily snapshots for each day of the week
 	Within  Duration  // keep snapshots made within this duration
 	Tags    []TagList // keep all snapshots that include at least one of the tag lists.
+
+	// Select determines which snapshot is kept within an hour, day, week,
+	// month or year.
+	Select BucketSelection
 }
 
 func (e ExpirePolicy) String() (s string) {
@@ -42,9 +77,15 @@ func (e ExpirePolicy) String() (s string) {
 	if e.Yearly > 0 {
 		keeps = append(keeps, fmt.Sprintf("%d yearly", e.Yearly))
 	}
+	if e.Weekday > 0 {
+		keeps = append(keeps, fmt.Sprintf("%d per weekday", e.Weekday))
+	}
 
 	if len(keeps) > 0 {
 		s = fmt.Sprintf("keep the last %s snapshots", strings.Join(keeps, ", "))
+		if e.Select == SelectFirst {
+			s += " (the first snapshot of each period)"
+		}
 	}
 
 	if len(e.Tags) > 0 {
@@ -67,7 +108,7 @@ func (e ExpirePolicy) String() (s string) {
 // Sum returns the maximum number of snapshots to be kept according to this
 // policy.
 func (e ExpirePolicy) Sum() int {
-	return e.Last + e.Hourly + e.Daily + e.Weekly + e.Monthly + e.Yearly
+	return e.Last + e.Hourly + e.Daily + e.Weekly + e.Monthly + e.Yearly + 7*e.Weekday
 }
 
 // Empty returns true iff no policy has been configured (all values zero).
@@ -76,7 +117,8 @@ func (e ExpirePolicy) Empty() bool {
 		return false
 	}
 
-	empty := ExpirePolicy{Tags: e.Tags}
+	// the selection alone does not keep any snapshots
+	empty := ExpirePolicy{Tags: e.Tags, Select: e.Select}
 	return reflect.DeepEqual(e, empty)
 }
 
@@ -143,12 +185,59 @@ type KeepReason struct {
 		Weekly  int `json:"weekly,omitempty"`
 		Monthly int `json:"monthly,omitempty"`
 		Yearly  int `json:"yearly,omitempty"`
+		// Weekday is the sum of the counters for all days of the week
+		Weekday int `json:"weekday,omitempty"`
 	} `json:"counters"`
 }
 
+// selectSnapshots returns the indexes of the snapshots in list which are kept
+// for the n most recent buckets returned by bucker. If weekday is not
+// negative, only snapshots made on that day of the week are considered.
+// Snapshots in skip are not considered at all. list must be sorted from the
+// newest to the oldest snapshot.
+func selectSnapshots(list Snapshots, n int, bucker func(d time.Time, nr int) int, weekday time.Weekday, sel BucketSelection, skip map[int]struct{}) map[int]struct{} {
+	selected := make(map[int]struct{})
+	last, lastNr, count := -1, -1, 0
+
+	for nr, sn := range list {
+		if _, ok := skip[nr]; ok {
+			continue
+		}
+
+		if weekday >= 0 && sn.Time.Weekday() != weekday {
+			continue
+		}
+
+		val := bucker(sn.Time, nr)
+		if val != last {
+			if count == n {
+				break
+			}
+
+			count++
+			last = val
+			lastNr = nr
+			selected[nr] = struct{}{}
+			continue
+		}
+
+		// the list is sorted by time, so the snapshot is older than the one
+		// selected for the bucket so far
+		if sel == SelectFirst {
+			delete(selected, lastNr)
+			lastNr = nr
+			selected[nr] = struct{}{}
+		}
+	}
+
+	return selected
+}
+
 // ApplyPolicy returns the snapshots from list that are to be kept and removed
 // according to the policy p. list is sorted in the process. reasons contains
 // the reasons to keep each snapshot, it is in the same order as keep.
+// Checkpoint snapshots of interrupted backups are incomplete, they are never
+// kept by a non-empty policy and do not use up any of its counts.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
 	sort.Sort(list)
 
@@ -166,27 +255,64 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		return list, nil, nil
 	}
 
-	var buckets = [6]struct {
-		Count  int
-		bucker func(d time.Time, nr int) int
-		Last   int
-		reason string
-	}{
-		{p.Last, always, -1, "last snapshot"},
-		{p.Hourly, ymdh, -1, "hourly snapshot"},
-		{p.Daily, ymd, -1, "daily snapshot"},
-		{p.Weekly, yw, -1, "weekly snapshot"},
-		{p.Monthly, ym, -1, "monthly snapshot"},
-		{p.Yearly, y, -1, "yearly snapshot"},
+	type bucket struct {
+		Count    int
+		bucker   func(d time.Time, nr int) int
+		weekday  time.Weekday
+		reason   string
+		selected map[int]struct{}
+	}
+
+	var buckets = []bucket{
+		{p.Last, always, -1, "last snapshot", nil},
+		{p.Hourly, ymdh, -1, "hourly snapshot", nil},
+		{p.Daily, ymd, -1, "daily snapshot", nil},
+		{p.Weekly, yw, -1, "weekly snapshot", nil},
+		{p.Monthly, ym, -1, "monthly snapshot", nil},
+		{p.Yearly, y, -1, "yearly snapshot", nil},
+	}
+
+	// the buckets for each day of the week, starting with Monday
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		buckets = append(buckets, bucket{p.Weekday, ymd, day, fmt.Sprintf("%v snapshot", day), nil})
+	}
+
+	// Snapshots with one of the tags are always kept. They are evaluated
+	// first and do not use up the counts of the other rules. Checkpoints are
+	// skipped as well.
+	skip := make(map[int]struct{})
+	var complete Snapshots
+	for nr, cur := range list {
+		if cur.Checkpoint || (len(p.Tags) > 0 && cur.HasTagList(p.Tags)) {
+			skip[nr] = struct{}{}
+		}
+		if !cur.Checkpoint {
+			complete = append(complete, cur)
+		}
+	}
+
+	for i, b := range buckets {
+		if b.Count > 0 {
+			buckets[i].selected = selectSnapshots(list, b.Count, b.bucker, b.weekday, p.Select, skip)
+		}
 	}
 
-	latest := findLatestTimestamp(list)
+	var latest time.Time
+	if len(complete) > 0 {
+		latest = findLatestTimestamp(complete)
+	}
 
 	for nr, cur := range list {
+		if cur.Checkpoint {
+			debug.Log("remove checkpoint %v", cur.id.Str())
+			remove = append(remove, cur)
+			continue
+		}
+
 		var keepSnap bool
 		var keepSnapReasons []string
 
-		// Tags are handled specially as they are not counted.
 		for _, l := range p.Tags {
 			if cur.HasTags(l) {
 				keepSnap = true
@@ -205,15 +331,11 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 
 		// Now update the other buckets and see if they have some counts left.
 		for i, b := range buckets {
-			if b.Count > 0 {
-				val := b.bucker(cur.Time, nr)
-				if val != b.Last {
-					debug.Log("keep %v %v, bucker %v, val %v\n", cur.Time, cur.id.Str(), i, val)
-					keepSnap = true
-					buckets[i].Last = val
-					buckets[i].Count--
-					keepSnapReasons = append(keepSnapReasons, b.reason)
-				}
+			if _, ok := b.selected[nr]; ok && b.Count > 0 {
+				debug.Log("keep %v %v, bucket %v", cur.Time, cur.id.Str(), i)
+				keepSnap = true
+				buckets[i].Count--
+				keepSnapReasons = append(keepSnapReasons, b.reason)
 			}
 		}
 
@@ -229,6 +351,9 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 			kr.Counters.Weekly = buckets[3].Count
 			kr.Counters.Monthly = buckets[4].Count
 			kr.Counters.Yearly = buckets[5].Count
+			for _, b := range buckets[6:] {
+				kr.Counters.Weekday += b.Count
+			}
 			reasons = append(reasons, kr)
 		} else {
 			remove = append(remove, cur)
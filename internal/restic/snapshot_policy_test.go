@@ -40,6 +40,8 @@ func TestExpireSnapshotOps(t *testing.T) {
 		{true, 0, &restic.ExpirePolicy{}},
 		{true, 0, &restic.ExpirePolicy{Tags: []restic.TagList{}}},
 		{false, 22, &restic.ExpirePolicy{Daily: 7, Weekly: 2, Monthly: 3, Yearly: 10}},
+		{true, 0, &restic.ExpirePolicy{Select: restic.SelectFirst}},
+		{false, 15, &restic.ExpirePolicy{Daily: 1, Weekday: 2, Select: restic.SelectFirst}},
 	}
 	for i, d := range data {
 		isEmpty := d.p.Empty()
@@ -269,3 +271,193 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+// denseTimeline returns snapshots made every six hours from January 1st to
+// March 31st, 2020. When weekend is false, there are no snapshots on
+// Saturdays and Sundays except for the first weekend.
+func denseTimeline(weekend bool) restic.Snapshots {
+	var list restic.Snapshots
+	start := parseTimeUTC("2020-01-01 00:00:00")
+	end := parseTimeUTC("2020-04-01 00:00:00")
+	for ts := start; ts.Before(end); ts = ts.Add(6 * time.Hour) {
+		day := ts.Weekday()
+		if !weekend && (day == time.Saturday || day == time.Sunday) && ts.After(parseTimeUTC("2020-01-06 00:00:00")) {
+			continue
+		}
+		list = append(list, &restic.Snapshot{Time: ts})
+	}
+	return list
+}
+
+func TestApplyPolicyCalendar(t *testing.T) {
+	var tests = []struct {
+		weekend bool
+		p       restic.ExpirePolicy
+		keep    []string
+	}{
+		{true, restic.ExpirePolicy{Monthly: 3}, []string{
+			"2020-03-31 18:00:00", "2020-02-29 18:00:00", "2020-01-31 18:00:00",
+		}},
+		{true, restic.ExpirePolicy{Monthly: 3, Select: restic.SelectFirst}, []string{
+			"2020-03-01 00:00:00", "2020-02-01 00:00:00", "2020-01-01 00:00:00",
+		}},
+		{true, restic.ExpirePolicy{Weekly: 2, Select: restic.SelectFirst}, []string{
+			"2020-03-30 00:00:00", "2020-03-23 00:00:00",
+		}},
+		{true, restic.ExpirePolicy{Daily: 2, Hourly: 1, Select: restic.SelectFirst}, []string{
+			"2020-03-31 18:00:00", "2020-03-31 00:00:00", "2020-03-30 00:00:00",
+		}},
+		{true, restic.ExpirePolicy{Yearly: 1, Select: restic.SelectFirst}, []string{
+			"2020-01-01 00:00:00",
+		}},
+		{true, restic.ExpirePolicy{Weekday: 1}, []string{
+			"2020-03-31 18:00:00", "2020-03-30 18:00:00", "2020-03-29 18:00:00",
+			"2020-03-28 18:00:00", "2020-03-27 18:00:00", "2020-03-26 18:00:00",
+			"2020-03-25 18:00:00",
+		}},
+		// one snapshot is kept for each day of the week, even if the last
+		// weekend was long ago
+		{false, restic.ExpirePolicy{Weekday: 1}, []string{
+			"2020-03-31 18:00:00", "2020-03-30 18:00:00", "2020-03-27 18:00:00",
+			"2020-03-26 18:00:00", "2020-03-25 18:00:00", "2020-01-05 18:00:00",
+			"2020-01-04 18:00:00",
+		}},
+		{false, restic.ExpirePolicy{Weekday: 2, Select: restic.SelectFirst}, []string{
+			"2020-03-31 00:00:00", "2020-03-30 00:00:00", "2020-03-27 00:00:00",
+			"2020-03-26 00:00:00", "2020-03-25 00:00:00", "2020-03-24 00:00:00",
+			"2020-03-23 00:00:00", "2020-03-20 00:00:00", "2020-03-19 00:00:00",
+			"2020-03-18 00:00:00", "2020-01-05 00:00:00", "2020-01-04 00:00:00",
+		}},
+		{false, restic.ExpirePolicy{Weekday: 1, Daily: 3}, []string{
+			"2020-03-31 18:00:00", "2020-03-30 18:00:00", "2020-03-27 18:00:00",
+			"2020-03-26 18:00:00", "2020-03-25 18:00:00", "2020-01-05 18:00:00",
+			"2020-01-04 18:00:00",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.p.String(), func(t *testing.T) {
+			list := denseTimeline(test.weekend)
+			keep, remove, reasons := restic.ApplyPolicy(list, test.p)
+
+			if len(keep)+len(remove) != len(list) {
+				t.Errorf("len(keep)+len(remove) = %d != len(list) = %d", len(keep)+len(remove), len(list))
+			}
+
+			if len(keep) != len(reasons) {
+				t.Errorf("got %d keep reasons for %d snapshots to keep, these must be equal", len(reasons), len(keep))
+			}
+
+			var kept []string
+			for _, sn := range keep {
+				kept = append(kept, sn.Time.Format("2006-01-02 15:04:05"))
+			}
+
+			if !cmp.Equal(test.keep, kept) {
+				t.Error(cmp.Diff(test.keep, kept))
+			}
+
+			// the result does not depend on the order of the input
+			for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+				list[i], list[j] = list[j], list[i]
+			}
+			keep2, _, _ := restic.ApplyPolicy(list, test.p)
+			if !cmp.Equal(keep, keep2, cmpopts.IgnoreUnexported(restic.Snapshot{})) {
+				t.Errorf("result depends on the order of the snapshots")
+			}
+		})
+	}
+}
+
+func TestParseBucketSelection(t *testing.T) {
+	for _, s := range []string{"first", "last"} {
+		sel, err := restic.ParseBucketSelection(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sel.String() != s {
+			t.Errorf("wrong selection for %q: %v", s, sel)
+		}
+	}
+
+	if _, err := restic.ParseBucketSelection("middle"); err == nil {
+		t.Errorf("no error for invalid selection")
+	}
+}
+
+func TestApplyPolicyKeepTags(t *testing.T) {
+	// the last snapshot of every fourth day is tagged permanent, including
+	// the latest snapshot
+	list := denseTimeline(true)
+	var permanent []string
+	for _, sn := range list {
+		if sn.Time.YearDay()%4 == 3 && sn.Time.Hour() == 18 {
+			sn.Tags = []string{"permanent"}
+			permanent = append(permanent, sn.Time.Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	p := restic.ExpirePolicy{
+		Last:  1,
+		Daily: 2,
+		Tags:  []restic.TagList{{"permanent"}},
+	}
+	keep, remove, reasons := restic.ApplyPolicy(list, p)
+
+	if len(keep) != len(reasons) {
+		t.Errorf("got %d keep reasons for %d snapshots to keep, these must be equal", len(reasons), len(keep))
+	}
+
+	for _, sn := range remove {
+		if sn.HasTags([]string{"permanent"}) {
+			t.Errorf("permanent snapshot %v removed", sn.Time)
+		}
+	}
+
+	// the permanent snapshots do not count for the other rules
+	var kept []string
+	for _, sn := range keep {
+		if !sn.HasTags([]string{"permanent"}) {
+			kept = append(kept, sn.Time.Format("2006-01-02 15:04:05"))
+		}
+	}
+	want := []string{"2020-03-31 12:00:00", "2020-03-30 18:00:00"}
+	if !cmp.Equal(want, kept) {
+		t.Error(cmp.Diff(want, kept))
+	}
+
+	if len(keep) != len(permanent)+len(want) {
+		t.Errorf("wrong number of snapshots kept, want %d, got %d", len(permanent)+len(want), len(keep))
+	}
+}
+
+func TestApplyPolicyCheckpoints(t *testing.T) {
+	complete := &restic.Snapshot{Time: parseTimeUTC("2020-03-31 12:00:00"), Tags: []string{"permanent"}}
+	older := &restic.Snapshot{Time: parseTimeUTC("2020-03-30 12:00:00")}
+	checkpoint := &restic.Snapshot{Time: parseTimeUTC("2020-03-31 18:00:00"), Tags: []string{"permanent"}, Checkpoint: true}
+
+	for _, p := range []restic.ExpirePolicy{
+		{Last: 1},
+		{Daily: 1},
+		{Within: restic.Duration{Hours: 1}},
+		{Tags: []restic.TagList{{"permanent"}}},
+	} {
+		list := restic.Snapshots{older, checkpoint, complete}
+		keep, remove, _ := restic.ApplyPolicy(list, p)
+
+		// the checkpoint of the interrupted backup is not kept and does not
+		// replace the last complete snapshot
+		if len(keep) != 1 || keep[0] != complete {
+			t.Errorf("policy %v: wrong snapshots kept: %v", p, keep)
+		}
+		if len(remove) != 2 || remove[0] != checkpoint || remove[1] != older {
+			t.Errorf("policy %v: wrong snapshots removed: %v", p, remove)
+		}
+	}
+
+	// an empty policy keeps all snapshots
+	keep, remove, _ := restic.ApplyPolicy(restic.Snapshots{checkpoint}, restic.ExpirePolicy{})
+	if len(keep) != 1 || len(remove) != 0 {
+		t.Errorf("empty policy: wrong snapshots kept %v and removed %v", keep, remove)
+	}
+}
@@ -0,0 +1,72 @@
+package restic
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// LoadSnapshotsSince returns the snapshots which were added to the repository
+// at or after since.
+//
+// The names of snapshot files are hashes, so there is no listing order which
+// allows to stop early. Instead, when the backend reports the modification
+// time of a snapshot file, files modified before since are skipped without
+// loading them, so only new snapshots are downloaded. For files without a
+// modification time, the snapshot is loaded and its time is compared instead.
+func LoadSnapshotsSince(ctx context.Context, repo Repository, since time.Time) (Snapshots, error) {
+	var snapshots Snapshots
+	skipped := 0
+
+	err := repo.Backend().List(ctx, SnapshotFile, func(fi FileInfo) error {
+		id, err := ParseID(fi.Name)
+		if err != nil {
+			debug.Log("unable to parse %v as an ID", fi.Name)
+			return nil
+		}
+
+		if !fi.ModTime.IsZero() && fi.ModTime.Before(since) {
+			skipped++
+			return nil
+		}
+
+		sn, err := LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		if fi.ModTime.IsZero() && sn.Time.Before(since) {
+			return nil
+		}
+
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	debug.Log("found %d snapshots since %v, skipped %d older snapshot files", len(snapshots), since, skipped)
+	return snapshots, nil
+}
+
+// SnapshotAddedTime returns the time at which the snapshot id was added to
+// the repository, for use as cursor with LoadSnapshotsSince. If the backend
+// does not report modification times, the time of the snapshot is returned.
+func SnapshotAddedTime(ctx context.Context, repo Repository, id ID) (time.Time, error) {
+	fi, err := repo.Backend().Stat(ctx, Handle{Type: SnapshotFile, Name: id.String()})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !fi.ModTime.IsZero() {
+		return fi.ModTime, nil
+	}
+
+	sn, err := LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sn.Time, nil
+}
@@ -0,0 +1,126 @@
+package restic_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// modTimeBackend reports the configured modification times for snapshot
+// files and counts how often snapshot files are loaded.
+type modTimeBackend struct {
+	restic.Backend
+	modTimes map[string]time.Time
+	loads    int
+}
+
+func (be *modTimeBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return be.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+		if t == restic.SnapshotFile {
+			fi.ModTime = be.modTimes[fi.Name]
+		}
+		return fn(fi)
+	})
+}
+
+func (be *modTimeBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	fi, err := be.Backend.Stat(ctx, h)
+	if h.Type == restic.SnapshotFile {
+		fi.ModTime = be.modTimes[h.Name]
+	}
+	return fi, err
+}
+
+func (be *modTimeBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if h.Type == restic.SnapshotFile {
+		be.loads++
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func saveSnapshotAt(t testing.TB, repo restic.Repository, at time.Time) restic.ID {
+	sn, err := restic.NewSnapshot([]string{"/home/user"}, nil, "host", at)
+	rtest.OK(t, err)
+
+	id, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func snapshotIDs(list restic.Snapshots) restic.IDSet {
+	ids := restic.NewIDSet()
+	for _, sn := range list {
+		ids.Insert(*sn.ID())
+	}
+	return ids
+}
+
+func TestLoadSnapshotsSince(t *testing.T) {
+	be := &modTimeBackend{Backend: mem.New(), modTimes: make(map[string]time.Time)}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// the snapshot times differ from the times the files were added, e.g.
+	// because an old snapshot was copied from another repository
+	var ids restic.IDs
+	for i := 0; i < 4; i++ {
+		id := saveSnapshotAt(t, repo, base.Add(-time.Duration(i)*time.Hour))
+		be.modTimes[id.String()] = base.Add(time.Duration(i) * time.Minute)
+		ids = append(ids, id)
+	}
+
+	be.loads = 0
+	snapshots, err := restic.LoadSnapshotsSince(context.TODO(), repo, base.Add(2*time.Minute))
+	rtest.OK(t, err)
+	rtest.Assert(t, snapshotIDs(snapshots).Equals(restic.NewIDSet(ids[2], ids[3])),
+		"wrong snapshots returned: %v", snapshots)
+	// older snapshot files are not loaded
+	rtest.Equals(t, 2, be.loads)
+
+	// a snapshot ID can be used as cursor
+	since, err := restic.SnapshotAddedTime(context.TODO(), repo, ids[3])
+	rtest.OK(t, err)
+	snapshots, err = restic.LoadSnapshotsSince(context.TODO(), repo, since)
+	rtest.OK(t, err)
+	rtest.Assert(t, snapshotIDs(snapshots).Equals(restic.NewIDSet(ids[3])),
+		"wrong snapshots returned: %v", snapshots)
+
+	snapshots, err = restic.LoadSnapshotsSince(context.TODO(), repo, base.Add(time.Hour))
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(snapshots))
+}
+
+func TestLoadSnapshotsSinceNoModTime(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var ids restic.IDs
+	for i := 0; i < 4; i++ {
+		ids = append(ids, saveSnapshotAt(t, repo, base.Add(time.Duration(i)*time.Hour)))
+	}
+
+	// without modification times, the snapshot times are compared
+	snapshots, err := restic.LoadSnapshotsSince(context.TODO(), repo, base.Add(90*time.Minute))
+	rtest.OK(t, err)
+	rtest.Assert(t, snapshotIDs(snapshots).Equals(restic.NewIDSet(ids[2], ids[3])),
+		"wrong snapshots returned: %v", snapshots)
+
+	since, err := restic.SnapshotAddedTime(context.TODO(), repo, ids[1])
+	rtest.OK(t, err)
+	rtest.Equals(t, base.Add(time.Hour), since.UTC())
+
+	snapshots, err = restic.LoadSnapshotsSince(context.TODO(), repo, since)
+	rtest.OK(t, err)
+	rtest.Assert(t, snapshotIDs(snapshots).Equals(restic.NewIDSet(ids[1], ids[2], ids[3])),
+		"wrong snapshots returned: %v", snapshots)
+}
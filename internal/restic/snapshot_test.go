@@ -1,6 +1,8 @@
 package restic_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -14,3 +16,21 @@ func TestNewSnapshot(t *testing.T) {
 	_, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
 	rtest.OK(t, err)
 }
+
+func TestSnapshotDescriptionJSON(t *testing.T) {
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.OK(t, err)
+
+	// an empty description is omitted
+	buf, err := json.Marshal(sn)
+	rtest.OK(t, err)
+	rtest.Assert(t, !bytes.Contains(buf, []byte("description")), "empty description included in %s", buf)
+
+	sn.Description = "multi-line\ndescription"
+	buf, err = json.Marshal(sn)
+	rtest.OK(t, err)
+
+	var sn2 restic.Snapshot
+	rtest.OK(t, json.Unmarshal(buf, &sn2))
+	rtest.Equals(t, sn.Description, sn2.Description)
+}
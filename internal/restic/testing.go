@@ -33,13 +33,14 @@ type fakeFileSystem struct {
 // IDs is returned.
 func (fs *fakeFileSystem) saveFile(ctx context.Context, rd io.Reader) (blobs IDs) {
 	if fs.buf == nil {
-		fs.buf = make([]byte, chunker.MaxSize)
+		_, max := fs.repo.Config().ChunkerBoundaries()
+		fs.buf = make([]byte, max)
 	}
 
 	if fs.chunker == nil {
-		fs.chunker = chunker.New(rd, fs.repo.Config().ChunkerPolynomial)
+		fs.chunker = fs.repo.Config().NewChunker(rd)
 	} else {
-		fs.chunker.Reset(rd, fs.repo.Config().ChunkerPolynomial)
+		fs.repo.Config().ResetChunker(fs.chunker, rd)
 	}
 
 	blobs = IDs{}
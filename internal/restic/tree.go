@@ -12,6 +12,12 @@ import (
 // Tree is an ordered list of nodes.
 type Tree struct {
 	Nodes []*Node `json:"nodes"`
+
+	// Chunks contains the IDs of the tree blobs holding the nodes of a large
+	// tree which was split. When the tree is loaded, the nodes of all chunks
+	// are joined into Nodes, Chunks is kept so that the chunks can be found
+	// as referenced blobs.
+	Chunks IDs `json:"chunks,omitempty"`
 }
 
 // NewTree creates a new tree object.
@@ -0,0 +1,43 @@
+package restic
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// DefaultTreeSplitThreshold is the size of the encoded tree in bytes above
+// which a tree is split into chunks when tree splitting is enabled.
+const DefaultTreeSplitThreshold = 1 << 20
+
+// treeChunkBits determines the average number of nodes in a chunk of a split
+// tree, which is 2^treeChunkBits.
+const treeChunkBits = 8
+
+// isTreeChunkBoundary returns true if a chunk ends after the node with the
+// given name. The boundaries only depend on the names of the nodes, so adding
+// or removing a node only changes the chunk it belongs to.
+func isTreeChunkBoundary(name string) bool {
+	h := sha256.Sum256([]byte(name))
+	return binary.LittleEndian.Uint32(h[:4])&(1<<treeChunkBits-1) == 0
+}
+
+// Split splits the nodes of t into chunks at content-defined boundaries. The
+// chunks are returned in order, if no boundary is found the result only
+// contains a single chunk with all nodes.
+func (t *Tree) Split() []*Tree {
+	var chunks []*Tree
+	cur := NewTree()
+	for _, node := range t.Nodes {
+		cur.Nodes = append(cur.Nodes, node)
+		if isTreeChunkBoundary(node.Name) {
+			chunks = append(chunks, cur)
+			cur = NewTree()
+		}
+	}
+
+	if len(cur.Nodes) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, cur)
+	}
+
+	return chunks
+}
@@ -3,6 +3,7 @@ package restic_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -113,3 +114,74 @@ func TestLoadTree(t *testing.T) {
 		"trees are not equal: want %v, got %v",
 		tree, tree2)
 }
+
+func testTreeWithNodes(t testing.TB, n int, extra ...string) *restic.Tree {
+	tree := restic.NewTree()
+	for i := 0; i < n; i++ {
+		rtest.OK(t, tree.Insert(&restic.Node{Name: fmt.Sprintf("file%05d", i), Type: "file"}))
+	}
+	for _, name := range extra {
+		rtest.OK(t, tree.Insert(&restic.Node{Name: name, Type: "file"}))
+	}
+	return tree
+}
+
+func TestTreeSplit(t *testing.T) {
+	tree := testTreeWithNodes(t, 5000)
+	chunks := tree.Split()
+	rtest.Assert(t, len(chunks) > 1, "tree with %d nodes was not split", len(tree.Nodes))
+
+	joined := restic.NewTree()
+	for _, chunk := range chunks {
+		joined.Nodes = append(joined.Nodes, chunk.Nodes...)
+	}
+	rtest.Assert(t, tree.Equals(joined), "joined chunks differ from the tree")
+
+	// inserting a single node only changes a single chunk
+	changed := testTreeWithNodes(t, 5000, "file02500a").Split()
+	rtest.Equals(t, len(chunks), len(changed))
+
+	different := 0
+	for i := range chunks {
+		if !chunks[i].Equals(changed[i]) {
+			different++
+		}
+	}
+	rtest.Equals(t, 1, different)
+
+	// small trees without a boundary are not split
+	rtest.Equals(t, 1, len(testTreeWithNodes(t, 0).Split()))
+}
+
+func TestLoadSplitTree(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tree := testTreeWithNodes(t, 2000)
+	top := &restic.Tree{Nodes: []*restic.Node{}}
+	for _, chunk := range tree.Split() {
+		id, err := repo.SaveTree(context.TODO(), chunk)
+		rtest.OK(t, err)
+		top.Chunks = append(top.Chunks, id)
+	}
+
+	buf, err := json.Marshal(top)
+	rtest.OK(t, err)
+	id, err := repo.SaveBlob(context.TODO(), restic.TreeBlob, buf, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	tree2, err := repo.LoadTree(context.TODO(), id)
+	rtest.OK(t, err)
+	rtest.Assert(t, tree.Equals(tree2), "joined tree differs: want %v, got %v", tree, tree2)
+	rtest.Equals(t, top.Chunks, tree2.Chunks)
+
+	// saving the loaded tree again stores it unsplit
+	id2, err := repo.SaveTree(context.TODO(), tree2)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+	tree3, err := repo.LoadTree(context.TODO(), id2)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(tree3.Chunks))
+	rtest.Assert(t, tree.Equals(tree3), "saved tree differs: want %v, got %v", tree, tree3)
+}
@@ -3,6 +3,7 @@ package restorer
 import (
 	"context"
 	"io"
+	"os"
 	"path/filepath"
 
 	"github.com/restic/restic/internal/crypto"
@@ -38,6 +39,7 @@ const (
 type fileInfo struct {
 	location string      // file on local filesystem relative to restorer basedir
 	blobs    []restic.ID // remaining blobs of the file
+	content  restic.IDs  // all blobs of the file
 }
 
 // information about a data pack required to restore one or more files
@@ -66,6 +68,17 @@ type fileRestorer struct {
 
 	dst   string
 	files []*fileInfo
+
+	// verify enables reading each file again after it has been written
+	// completely and comparing it to the expected content
+	verify bool
+
+	// journal, if set, records the files which have been restored completely
+	journal *Journal
+
+	// cfg is the config of the repository, which determines how blobs are
+	// decrypted
+	cfg restic.Config
 }
 
 func newFileRestorer(dst string, packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error, key *crypto.Key, idx filePackTraverser) *fileRestorer {
@@ -80,7 +93,7 @@ func newFileRestorer(dst string, packLoader func(ctx context.Context, h restic.H
 }
 
 func (r *fileRestorer) addFile(location string, content restic.IDs) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content})
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, content: content})
 }
 
 func (r *fileRestorer) targetPath(location string) string {
@@ -180,6 +193,11 @@ func (r *fileRestorer) restoreFiles(ctx context.Context, onError func(path strin
 				if len(file.blobs) == 0 {
 					r.filesWriter.close(target)
 					delete(inprogress, file)
+					if r.journal != nil {
+						if err := r.journal.Add(file.location); err != nil {
+							onError(file.location, err)
+						}
+					}
 				}
 				success = append(success, file)
 			}
@@ -289,11 +307,68 @@ func (r *fileRestorer) processPack(ctx context.Context, request processingInfo,
 					break // could not restore the file
 				}
 			}
+
+			// verify the file in this worker if all remaining blobs were
+			// contained in this pack
+			if r.verify && request.files[file] == nil && len(packBlobs) == len(file.blobs) {
+				r.filesWriter.close(target)
+				request.files[file] = r.verifyFile(target, file.content)
+			}
 			return false
 		})
 	}
 }
 
+// verifyFile reads the file target and checks that its content consists of
+// the blobs in content.
+func (r *fileRestorer) verifyFile(target string, content restic.IDs) error {
+	debug.Log("verifying %v", target)
+
+	f, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf []byte
+	var offset int64
+	for _, id := range content {
+		blobs, found := r.idx.lookup(id, restic.DataBlob)
+		if !found {
+			return errors.Errorf("Unknown blob %s", id.String())
+		}
+
+		length := int(blobs[0].Length) - crypto.Extension
+		if cap(buf) < length {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+
+		_, err = io.ReadFull(f, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.Errorf("verifying failed: file is shorter than expected, got %d bytes", offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !restic.Hash(buf).Equal(id) {
+			return errors.Errorf("verifying failed: unexpected content starting at offset %d", offset)
+		}
+		offset += int64(length)
+	}
+
+	n, err := f.Read(make([]byte, 1))
+	if n > 0 {
+		return errors.Errorf("verifying failed: file is larger than expected %d bytes", offset)
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
 func (r *fileRestorer) loadBlob(rd io.ReaderAt, blob restic.Blob) ([]byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
@@ -310,7 +385,7 @@ func (r *fileRestorer) loadBlob(rd io.ReaderAt, blob restic.Blob) ([]byte, error
 
 	// decrypt
 	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, r.cfg.BlobAdditionalData(blob.ID))
 	if err != nil {
 		return nil, errors.Errorf("decrypting blob %v failed: %v", blob.ID, err)
 	}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/crypto"
@@ -135,7 +137,7 @@ func newTestRepo(content []TestFile) *TestRepo {
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		files = append(files, &fileInfo{location: file.name, blobs: content, content: content})
 	}
 
 	repo := &TestRepo{
@@ -211,3 +213,98 @@ func TestFileRestorerBasic(t *testing.T) {
 		},
 	})
 }
+
+func TestFileRestorerVerify(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := newTestRepo([]TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+			},
+		},
+		TestFile{
+			name: "file2",
+			blobs: []TestBlob{
+				TestBlob{"data2-1", "pack1"},
+				TestBlob{"data2-2", "pack2"},
+			},
+		},
+		TestFile{
+			name: "file3",
+			blobs: []TestBlob{
+				TestBlob{"data3-1", "pack3"},
+			},
+		},
+	})
+
+	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.idx)
+	r.files = repo.files
+	r.verify = true
+
+	// corrupt the second blob of file2 while it is written
+	corrupted := r.targetPath("file2")
+	r.filesWriter.write = func(wr *os.File, blob []byte) (int, error) {
+		if wr.Name() == corrupted && string(blob) == "data2-2" {
+			blob = []byte("dataXXX")
+		}
+		return wr.Write(blob)
+	}
+
+	errs := make(map[string]error)
+	rtest.OK(t, r.restoreFiles(context.TODO(), func(path string, err error) {
+		errs[path] = err
+	}))
+
+	rtest.Assert(t, len(errs) == 1, "expected a single error, got %v", errs)
+	rtest.Assert(t, errs["file2"] != nil && strings.Contains(errs["file2"].Error(), "verifying failed"),
+		"expected verification error for file2, got %v", errs["file2"])
+
+	// the other files were restored completely
+	for _, name := range []string{"file1", "file3"} {
+		data, err := ioutil.ReadFile(r.targetPath(name))
+		rtest.OK(t, err)
+		rtest.Equals(t, repo.filesPathToContent[name], string(data))
+	}
+}
+
+func TestFileRestorerVerifyFile(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := newTestRepo([]TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+			},
+		},
+	})
+
+	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.idx)
+	target := r.targetPath("file1")
+	content := repo.files[0].content
+
+	for _, test := range []struct {
+		data string
+		ok   bool
+	}{
+		{"data1-1data1-2", true},
+		{"data1-1data1-X", false},
+		{"data1-1data1-", false},
+		{"data1-1data1-2X", false},
+		{"", false},
+	} {
+		rtest.OK(t, ioutil.WriteFile(target, []byte(test.data), 0600))
+		err := r.verifyFile(target, content)
+		if test.ok {
+			rtest.OK(t, err)
+		} else {
+			rtest.Assert(t, err != nil, "expected error for content %q, got none", test.data)
+		}
+	}
+}
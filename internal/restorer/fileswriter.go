@@ -18,6 +18,9 @@ type filesWriter struct {
 	inprogress map[string]struct{} // (logically) opened file writers
 	cache      map[string]*os.File // cache of open files
 	cacheCap   int                 // max number of cached open files
+
+	// write writes blob to the file, tests can replace it
+	write func(wr *os.File, blob []byte) (int, error)
 }
 
 func newFilesWriter(cacheCap int) *filesWriter {
@@ -25,6 +28,7 @@ func newFilesWriter(cacheCap int) *filesWriter {
 		inprogress: make(map[string]struct{}),
 		cache:      make(map[string]*os.File),
 		cacheCap:   cacheCap,
+		write:      (*os.File).Write,
 	}
 }
 
@@ -79,7 +83,7 @@ func (w *filesWriter) writeToFile(path string, blob []byte) error {
 	if err != nil {
 		return err
 	}
-	n, err := wr.Write(blob)
+	n, err := w.write(wr, blob)
 	cacheOrCloseWriter(wr)
 	if err != nil {
 		return err
@@ -0,0 +1,140 @@
+package restorer
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Journal records the files which have been restored completely, so that an
+// interrupted restore can skip them when it is started again. The journal is
+// stored in the target directory, its name contains the ID of the snapshot.
+// Each line contains the quoted location of a file relative to the target
+// directory. It is safe for concurrent use.
+type Journal struct {
+	path string
+
+	m    sync.Mutex
+	f    *os.File
+	done map[string]struct{}
+}
+
+// JournalFilename returns the name of the journal for restoring the snapshot
+// id to a directory.
+func JournalFilename(id restic.ID) string {
+	return ".restic-restore-" + id.String() + ".journal"
+}
+
+// OpenJournal opens the journal for restoring the snapshot id to dir. If a
+// journal from an interrupted restore exists, the files recorded in it are
+// loaded. The directory is created if it does not exist.
+func OpenJournal(dir string, id restic.ID) (*Journal, error) {
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	j := &Journal{
+		path: filepath.Join(dir, JournalFilename(id)),
+		done: make(map[string]struct{}),
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	f, err := fs.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenFile")
+	}
+	j.f = f
+
+	debug.Log("opened journal %v with %d restored files", j.path, len(j.done))
+	return j, nil
+}
+
+// load reads the locations recorded in an existing journal. A line which
+// cannot be parsed, e.g. because it was only written partially, is ignored.
+func (j *Journal) load() error {
+	f, err := fs.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		location, err := strconv.Unquote(sc.Text())
+		if err != nil {
+			debug.Log("ignoring invalid line %q in journal %v", sc.Text(), j.path)
+			continue
+		}
+		j.done[location] = struct{}{}
+	}
+
+	return errors.Wrap(sc.Err(), "Scan")
+}
+
+// Path returns the path of the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Len returns the number of files recorded in the journal.
+func (j *Journal) Len() int {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	return len(j.done)
+}
+
+// Has returns true if the file at location has been restored completely.
+func (j *Journal) Has(location string) bool {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	_, ok := j.done[location]
+	return ok
+}
+
+// Add records that the file at location has been restored completely.
+func (j *Journal) Add(location string) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if _, ok := j.done[location]; ok {
+		return nil
+	}
+
+	_, err := j.f.WriteString(strconv.Quote(location) + "\n")
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	j.done[location] = struct{}{}
+	return nil
+}
+
+// Close closes the journal file, it is kept so that the restore can be
+// resumed.
+func (j *Journal) Close() error {
+	return errors.Wrap(j.f.Close(), "Close")
+}
+
+// Remove closes and removes the journal file, it must be called after the
+// restore completed successfully.
+func (j *Journal) Remove() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	return errors.Wrap(fs.Remove(j.path), "Remove")
+}
@@ -0,0 +1,126 @@
+package restorer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestJournal(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	id := restic.NewRandomID()
+	j, err := OpenJournal(tempdir, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, j.Len())
+
+	for _, location := range []string{"/dir/file", "/dir/file", "/name with\nnewline"} {
+		rtest.OK(t, j.Add(location))
+	}
+	rtest.OK(t, j.Close())
+
+	// simulate a partially written line
+	f, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0600)
+	rtest.OK(t, err)
+	_, err = f.WriteString(`"/incomplete`)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	j, err = OpenJournal(tempdir, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, j.Len())
+	rtest.Assert(t, j.Has("/dir/file"), "file not found in journal")
+	rtest.Assert(t, j.Has("/name with\nnewline"), "file with newline not found in journal")
+	rtest.Assert(t, !j.Has("/incomplete"), "partially written entry found in journal")
+
+	// the journal of another snapshot is independent
+	other, err := OpenJournal(tempdir, restic.NewRandomID())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, other.Len())
+	rtest.OK(t, other.Remove())
+
+	rtest.OK(t, j.Remove())
+	_, err = os.Lstat(j.Path())
+	rtest.Assert(t, os.IsNotExist(err), "journal was not removed: %v", err)
+}
+
+func TestRestorerResumeWithJournal(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"done.txt":    File{Data: "content: done"},
+			"partial.txt": File{Data: "content: partial"},
+			"missing.txt": File{Data: "content: missing"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"modified.txt": File{Data: "content: modified"},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// simulate an interrupted restore: done.txt and dir/modified.txt were
+	// restored completely, partial.txt was only written partially and
+	// missing.txt was not restored at all. To detect whether a file is
+	// restored again, done.txt contains different data of the same size.
+	rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, "dir"), 0700))
+	for filename, content := range map[string]string{
+		"done.txt":         "xxxxxxx: xxxx",
+		"partial.txt":      "content: ",
+		"dir/modified.txt": "content: modified, but changed since",
+	} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, filepath.FromSlash(filename)), []byte(content), 0600))
+	}
+
+	j, err := OpenJournal(tempdir, id)
+	rtest.OK(t, err)
+	rtest.OK(t, j.Add(string(filepath.Separator)+"done.txt"))
+	rtest.OK(t, j.Add(filepath.Join(string(filepath.Separator), "dir", "modified.txt")))
+	rtest.OK(t, j.Close())
+
+	j, err = OpenJournal(tempdir, id)
+	rtest.OK(t, err)
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Journal = j
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		// skipped, because it is recorded in the journal
+		"done.txt": "xxxxxxx: xxxx",
+		// restored, because they are not recorded in the journal
+		"partial.txt": "content: partial",
+		"missing.txt": "content: missing",
+		// restored, because the size does not match
+		"dir/modified.txt": "content: modified",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+
+	// all files are recorded now
+	rtest.Equals(t, 4, j.Len())
+	for _, location := range []string{"done.txt", "partial.txt", "missing.txt", "dir/modified.txt"} {
+		location = filepath.Join(string(filepath.Separator), filepath.FromSlash(location))
+		rtest.Assert(t, j.Has(location), "%v is not recorded in the journal", location)
+	}
+	rtest.OK(t, j.Close())
+}
@@ -0,0 +1,146 @@
+package restorer
+
+import (
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// OverwriteBehavior controls which existing files are overwritten during a
+// restore.
+type OverwriteBehavior int
+
+const (
+	// OverwriteAlways overwrites all existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfNewer skips existing files which have been modified after
+	// the file in the snapshot.
+	OverwriteIfNewer
+	// OverwriteIfChanged skips existing files with the same content as the
+	// file in the snapshot. Their metadata is restored.
+	OverwriteIfChanged
+	// OverwriteNever skips all existing files.
+	OverwriteNever
+)
+
+var overwriteBehaviorNames = map[OverwriteBehavior]string{
+	OverwriteAlways:    "always",
+	OverwriteIfNewer:   "if-newer",
+	OverwriteIfChanged: "if-changed",
+	OverwriteNever:     "never",
+}
+
+// ParseOverwriteBehavior parses "always", "if-newer", "if-changed" or "never".
+func ParseOverwriteBehavior(s string) (OverwriteBehavior, error) {
+	for b, name := range overwriteBehaviorNames {
+		if s == name {
+			return b, nil
+		}
+	}
+	return OverwriteAlways, errors.Errorf("invalid overwrite behavior %q, must be always, if-newer, if-changed or never", s)
+}
+
+func (b OverwriteBehavior) String() string {
+	return overwriteBehaviorNames[b]
+}
+
+// skipAction describes what is done with an item which is skipped.
+type skipAction int
+
+const (
+	// restoreItem restores the item.
+	restoreItem skipAction = iota
+	// skipItem leaves the item untouched.
+	skipItem
+	// skipContent only restores the metadata of the item.
+	skipContent
+)
+
+// checkOverwrite decides whether the node is restored to target according to
+// res.Overwrite.
+func (res *Restorer) checkOverwrite(node *restic.Node, target string) (skipAction, error) {
+	if res.Overwrite == OverwriteAlways {
+		return restoreItem, nil
+	}
+
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(errors.Cause(err)) {
+		return restoreItem, nil
+	}
+	if err != nil {
+		return restoreItem, err
+	}
+
+	switch res.Overwrite {
+	case OverwriteNever:
+		return skipItem, nil
+
+	case OverwriteIfNewer:
+		if fi.ModTime().After(node.ModTime) {
+			debug.Log("%v was modified after the file in the snapshot", target)
+			return skipItem, nil
+		}
+
+	case OverwriteIfChanged:
+		if node.Type != "file" || !fs.IsRegularFile(fi) || uint64(fi.Size()) != node.Size {
+			return restoreItem, nil
+		}
+
+		same, err := res.sameContent(node, target)
+		if err != nil {
+			return restoreItem, err
+		}
+		if same {
+			debug.Log("%v has the same content as the file in the snapshot", target)
+			return skipContent, nil
+		}
+	}
+
+	return restoreItem, nil
+}
+
+// sameContent returns true if the file target has the content of node. The
+// file is read blob by blob, reading is stopped at the first mismatch.
+func (res *Restorer) sameContent(node *restic.Node, target string) (bool, error) {
+	f, err := fs.Open(target)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var buf []byte
+	for _, id := range node.Content {
+		size, found := res.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return false, errors.Errorf("blob %v not found in index", id.Str())
+		}
+
+		if cap(buf) < int(size) {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+
+		_, err = io.ReadFull(f, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if !restic.Hash(buf).Equal(id) {
+			return false, nil
+		}
+	}
+
+	// the file must not contain more data
+	n, err := f.Read(make([]byte, 1))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return n == 0, nil
+}
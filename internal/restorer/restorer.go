@@ -2,8 +2,13 @@ package restorer
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
@@ -20,6 +25,85 @@ type Restorer struct {
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// Verify enables reading each restored file again after it has been
+	// written and comparing it to the content in the snapshot. Mismatches are
+	// reported via Error.
+	Verify bool
+
+	// PathMappings are applied to the paths within the snapshot to determine
+	// where they are restored.
+	PathMappings []PathMapping
+
+	// Overwrite controls which existing files are overwritten.
+	Overwrite OverwriteBehavior
+
+	// ModifiedSince, if set, skips all files which were last modified
+	// before this time. Directories are always restored.
+	ModifiedSince time.Time
+
+	// RestoreACLs enables restoring the ACLs stored in the snapshot. If the
+	// file system does not support ACLs, Warn is called once and the files
+	// are restored without ACLs.
+	RestoreACLs bool
+
+	// Warn is called for problems which do not prevent restoring the files.
+	Warn func(msg string)
+
+	// Journal, if set, records the files which have been restored
+	// completely. Files which are already recorded in it are not restored
+	// again, only their metadata.
+	Journal *Journal
+
+	aclWarned  bool
+	linkWarned bool
+}
+
+// link creates a hardlink, it is replaced in tests.
+var link = fs.Link
+
+// PathMapping restores the path Source within the snapshot and everything
+// below it to Target, relative to the restore directory.
+type PathMapping struct {
+	Source string
+	Target string
+}
+
+// NewPathMapping returns a PathMapping for the slash-separated paths src and
+// dst.
+func NewPathMapping(src, dst string) PathMapping {
+	return PathMapping{
+		Source: filepath.Join(string(filepath.Separator), filepath.FromSlash(src)),
+		Target: filepath.Join(string(filepath.Separator), filepath.FromSlash(dst)),
+	}
+}
+
+// mapLocation returns the location relative to the restore directory for the
+// location within the snapshot. The mapping with the longest matching source
+// is applied.
+func (res *Restorer) mapLocation(location string) string {
+	var best *PathMapping
+	for i, m := range res.PathMappings {
+		if !fs.HasPathPrefix(m.Source, location) {
+			continue
+		}
+
+		if best == nil || len(m.Source) > len(best.Source) {
+			best = &res.PathMappings[i]
+		}
+	}
+
+	if best == nil {
+		return location
+	}
+
+	rel, err := filepath.Rel(best.Source, location)
+	if err != nil {
+		// cannot happen, location is below the source
+		panic(err)
+	}
+
+	return filepath.Join(best.Target, rel)
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -42,6 +126,15 @@ func NewRestorer(repo restic.Repository, id restic.ID) (*Restorer, error) {
 	return r, nil
 }
 
+// checkContent returns an error if the snapshot does not contain the content
+// of its files.
+func (res *Restorer) checkContent() error {
+	if res.sn.MetadataOnly {
+		return errors.Errorf("snapshot %v contains only metadata, the content of its files cannot be restored", res.sn.ID().Str())
+	}
+	return nil
+}
+
 type treeVisitor struct {
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
@@ -49,8 +142,9 @@ type treeVisitor struct {
 }
 
 // traverseTree traverses a tree from the repo and calls treeVisitor.
-// target is the path in the file system, location within the snapshot.
-func (res *Restorer) traverseTree(ctx context.Context, target, location string, treeID restic.ID, visitor treeVisitor) error {
+// target is the path in the file system below the restore directory dst,
+// location within the snapshot.
+func (res *Restorer) traverseTree(ctx context.Context, dst, target, location string, treeID restic.ID, visitor treeVisitor) error {
 	debug.Log("%v %v %v", target, location, treeID)
 	tree, err := res.repo.LoadTree(ctx, treeID)
 	if err != nil {
@@ -85,6 +179,11 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 			continue
 		}
 
+		if mapped := res.mapLocation(nodeLocation); mapped != nodeLocation {
+			nodeTarget = filepath.Join(dst, mapped)
+			debug.Log("%v is mapped to %v", nodeLocation, nodeTarget)
+		}
+
 		// sockets cannot be restored
 		if node.Type == "socket" {
 			continue
@@ -93,6 +192,11 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		selectedForRestore, childMayBeSelected := res.SelectFilter(nodeLocation, nodeTarget, node)
 		debug.Log("SelectFilter returned %v %v", selectedForRestore, childMayBeSelected)
 
+		if selectedForRestore && node.Type == "file" && node.ModTime.Before(res.ModifiedSince) {
+			debug.Log("%v was modified before %v", nodeLocation, res.ModifiedSince)
+			selectedForRestore = false
+		}
+
 		sanitizeError := func(err error) error {
 			if err != nil {
 				err = res.Error(nodeLocation, err)
@@ -113,7 +217,7 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 			}
 
 			if childMayBeSelected {
-				err = sanitizeError(res.traverseTree(ctx, nodeTarget, nodeLocation, *node.Subtree, visitor))
+				err = sanitizeError(res.traverseTree(ctx, dst, nodeTarget, nodeLocation, *node.Subtree, visitor))
 				if err != nil {
 					return err
 				}
@@ -159,22 +263,67 @@ func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location s
 	err := node.RestoreMetadata(target)
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
+		return err
+	}
+
+	if res.RestoreACLs {
+		err = node.RestoreACLs(target)
+		if err == restic.ErrACLNotSupported {
+			debug.Log("ACLs of %v not restored: %v", location, err)
+			if !res.aclWarned && res.Warn != nil {
+				res.Warn(fmt.Sprintf("ACLs are not supported for %v, restoring files without ACLs", location))
+			}
+			res.aclWarned = true
+			return nil
+		}
 	}
 	return err
 }
 
+// restoreHardlinkAt creates path as a hardlink to target, which has already
+// been restored. If the hardlink cannot be created, e.g. because the file
+// system does not support hardlinks, target is copied instead.
 func (res *Restorer) restoreHardlinkAt(node *restic.Node, target, path, location string) error {
 	if err := fs.Remove(path); !os.IsNotExist(err) {
 		return errors.Wrap(err, "RemoveCreateHardlink")
 	}
-	err := fs.Link(target, path)
+	err := link(target, path)
 	if err != nil {
-		return errors.Wrap(err, "CreateHardlink")
+		debug.Log("unable to create hardlink %v to %v, copying the file: %v", path, target, err)
+		if !res.linkWarned && res.Warn != nil {
+			res.Warn(fmt.Sprintf("unable to create hardlink for %v, restoring copies of hardlinked files: %v", location, err))
+		}
+		res.linkWarned = true
+
+		err = copyFile(target, path)
+		if err != nil {
+			return errors.Wrap(err, "CreateHardlink")
+		}
 	}
 	// TODO investigate if hardlinks have separate metadata on any supported system
 	return res.restoreNodeMetadataTo(node, path, location)
 }
 
+// copyFile copies the content of the file src to dst.
+func copyFile(src, dst string) error {
+	rd, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	wr, err := fs.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(wr, rd)
+	if cerr := wr.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location string) error {
 	wr, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 	if err != nil {
@@ -191,7 +340,11 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
-	var err error
+	err := res.checkContent()
+	if err != nil {
+		return err
+	}
+
 	if !filepath.IsAbs(dst) {
 		dst, err = filepath.Abs(dst)
 		if err != nil {
@@ -206,10 +359,17 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 
 	idx := restic.NewHardlinkIndex()
 
+	// skipped contains the locations of the existing items which are not
+	// overwritten
+	skipped := make(map[string]skipAction)
+
 	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), filePackTraverser{lookup: res.repo.Index().Lookup})
+	filerestorer.verify = res.Verify
+	filerestorer.journal = res.Journal
+	filerestorer.cfg = res.repo.Config()
 
 	// first tree pass: create directories and collect all files to restore
-	err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	err = res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: func(node *restic.Node, target, location string) error {
 			// create dir with default permissions
 			// #leaveDir restores dir metadata after visiting all children
@@ -224,6 +384,24 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				return err
 			}
 
+			action := skipContent
+			if !res.restoredBefore(node, target, location) {
+				action, err = res.checkOverwrite(node, target)
+				if err != nil {
+					return err
+				}
+			}
+			if action != restoreItem {
+				skipped[location] = action
+
+				// the file already has the content from the snapshot, so
+				// the other hardlinks to it can link to the file
+				if action == skipContent && node.Type == "file" && node.Links > 1 && !idx.Has(node.Inode, node.DeviceID) {
+					idx.Add(node.Inode, node.DeviceID, location)
+				}
+				return nil
+			}
+
 			if node.Type != "file" {
 				return nil
 			}
@@ -239,7 +417,7 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
-			filerestorer.addFile(location, node.Content)
+			filerestorer.addFile(res.mapLocation(location), node.Content)
 
 			return nil
 		},
@@ -255,9 +433,16 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	}
 
 	// second tree pass: restore special files and filesystem metadata
-	return res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	return res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: noop,
 		visitNode: func(node *restic.Node, target, location string) error {
+			switch skipped[location] {
+			case skipItem:
+				return nil
+			case skipContent:
+				return res.restoreNodeMetadataTo(node, target, location)
+			}
+
 			if node.Type != "file" {
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
@@ -271,7 +456,7 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 			}
 
 			if idx.Has(node.Inode, node.DeviceID) && idx.GetFilename(node.Inode, node.DeviceID) != location {
-				return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.GetFilename(node.Inode, node.DeviceID)), target, location)
+				return res.restoreHardlinkAt(node, filerestorer.targetPath(res.mapLocation(idx.GetFilename(node.Inode, node.DeviceID))), target, location)
 			}
 
 			return res.restoreNodeMetadataTo(node, target, location)
@@ -280,17 +465,216 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	})
 }
 
+// restoredBefore returns true if the file node has been restored completely
+// to target by an earlier, interrupted restore, according to the journal. The
+// size of the file is checked in case it was modified since.
+func (res *Restorer) restoredBefore(node *restic.Node, target, location string) bool {
+	if res.Journal == nil || node.Type != "file" || node.Size == 0 {
+		return false
+	}
+
+	if !res.Journal.Has(res.mapLocation(location)) {
+		return false
+	}
+
+	fi, err := fs.Lstat(target)
+	if err != nil || !fi.Mode().IsRegular() || uint64(fi.Size()) != node.Size {
+		debug.Log("%v is recorded in the journal, but does not match the snapshot", location)
+		return false
+	}
+
+	debug.Log("%v was already restored", location)
+	return true
+}
+
 // Snapshot returns the snapshot this restorer is configured to use.
 func (res *Restorer) Snapshot() *restic.Snapshot {
 	return res.sn
 }
 
+// lookupNode returns the node for the slash-separated path p within the
+// snapshot.
+func (res *Restorer) lookupNode(ctx context.Context, p string) (*restic.Node, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil, errors.New("path refers to the snapshot root")
+	}
+
+	treeID := *res.sn.Tree
+	components := strings.Split(p, "/")
+	for i, name := range components {
+		tree, err := res.repo.LoadTree(ctx, treeID)
+		if err != nil {
+			return nil, err
+		}
+
+		node := tree.Find(name)
+		if node == nil {
+			return nil, errors.Errorf("path %q not found in snapshot", p)
+		}
+
+		if i == len(components)-1 {
+			return node, nil
+		}
+
+		if node.Type != "dir" || node.Subtree == nil {
+			return nil, errors.Errorf("%q is not a directory", path.Join(components[:i+1]...))
+		}
+		treeID = *node.Subtree
+	}
+
+	panic("unreachable")
+}
+
+// RestoreRange writes length bytes of the file at path p within the snapshot,
+// starting at offset, to w. Only the blobs covering the range are loaded from
+// the repository. If the range extends past the end of the file, the data up
+// to the end of the file is written. The number of bytes written is returned.
+func (res *Restorer) RestoreRange(ctx context.Context, p string, offset, length int64, w io.Writer) (int64, error) {
+	if offset < 0 || length < 0 {
+		return 0, errors.Errorf("invalid range offset %d length %d", offset, length)
+	}
+
+	if err := res.checkContent(); err != nil {
+		return 0, err
+	}
+
+	node, err := res.lookupNode(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+
+	if node.Type != "file" {
+		return 0, errors.Errorf("%q is not a file, but a %v", p, node.Type)
+	}
+
+	return res.writeRange(ctx, node, offset, length, w)
+}
+
+// writeRange writes length bytes of the content of the file node, starting at
+// offset, to w. The blobs are loaded one at a time.
+func (res *Restorer) writeRange(ctx context.Context, node *restic.Node, offset, length int64, w io.Writer) (int64, error) {
+	end := offset + length
+	if end > int64(node.Size) {
+		end = int64(node.Size)
+	}
+	if end <= offset {
+		return 0, nil
+	}
+
+	var written int64
+	var buf []byte
+	var blobStart int64
+	for _, id := range node.Content {
+		if blobStart >= end {
+			break
+		}
+
+		size, found := res.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return written, errors.Errorf("data blob %v not found in repository", id.Str())
+		}
+		blobEnd := blobStart + int64(size)
+
+		// skip blobs completely before the range without loading them
+		if blobEnd <= offset {
+			blobStart = blobEnd
+			continue
+		}
+
+		if cap(buf) < restic.CiphertextLength(int(size)) {
+			buf = restic.NewBlobBuffer(int(size))
+		}
+
+		n, err := res.repo.LoadBlob(ctx, restic.DataBlob, id, buf[:cap(buf)])
+		if err != nil {
+			return written, err
+		}
+		data := buf[:n]
+
+		// cut the blob to the part within the range
+		lo, hi := int64(0), int64(len(data))
+		if offset > blobStart {
+			lo = offset - blobStart
+		}
+		if blobStart+hi > end {
+			hi = end - blobStart
+		}
+
+		m, err := w.Write(data[lo:hi])
+		written += int64(m)
+		if err != nil {
+			return written, errors.Wrap(err, "Write")
+		}
+
+		blobStart = blobEnd
+	}
+
+	return written, nil
+}
+
+// RestoreToWriter writes the content of the single file selected by
+// SelectFilter to w, the path mappings are applied as for RestoreTo. An error
+// is returned if no file or more than one file is selected, or if a selected
+// node is not a regular file. The content is streamed blob by blob.
+func (res *Restorer) RestoreToWriter(ctx context.Context, w io.Writer) error {
+	if err := res.checkContent(); err != nil {
+		return err
+	}
+
+	var selected []*restic.Node
+	var locations []string
+	var invalid error
+	noop := func(node *restic.Node, target, location string) error { return nil }
+
+	// errors returned by the visitor are passed to res.Error, which may ignore
+	// them, so the first invalid node is recorded instead
+	err := res.traverseTree(ctx, string(filepath.Separator), string(filepath.Separator), string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		enterDir: func(node *restic.Node, target, location string) error {
+			if invalid == nil {
+				invalid = errors.Errorf("%v is a directory", location)
+			}
+			return nil
+		},
+		visitNode: func(node *restic.Node, target, location string) error {
+			if node.Type != "file" {
+				if invalid == nil {
+					invalid = errors.Errorf("%v is not a regular file, but a %v", location, node.Type)
+				}
+				return nil
+			}
+			selected = append(selected, node)
+			locations = append(locations, location)
+			return nil
+		},
+		leaveDir: noop,
+	})
+	if err != nil {
+		return err
+	}
+	if invalid != nil {
+		return invalid
+	}
+
+	switch {
+	case len(selected) == 0:
+		return errors.New("no file selected")
+	case len(selected) > 1:
+		return errors.Errorf("%d files selected, only one can be written: %v", len(selected), strings.Join(locations, ", "))
+	}
+
+	node := selected[0]
+	debug.Log("writing %v (%d bytes)", locations[0], node.Size)
+	_, err = res.writeRange(ctx, node, 0, int64(node.Size), w)
+	return err
+}
+
 // VerifyFiles reads all snapshot files and verifies their contents
 func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 	// TODO multithreaded?
 
 	count := 0
-	err := res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	err := res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: func(node *restic.Node, target, location string) error { return nil },
 		visitNode: func(node *restic.Node, target, location string) error {
 			if node.Type != "file" {
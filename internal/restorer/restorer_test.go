@@ -3,6 +3,7 @@ package restorer
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -27,11 +28,18 @@ type File struct {
 	Data  string
 	Links uint64
 	Inode uint64
+
+	// Blobs, if set, is used instead of Data to store the content in
+	// several blobs.
+	Blobs []string
+
+	ModTime time.Time
 }
 
 type Dir struct {
-	Nodes map[string]Node
-	Mode  os.FileMode
+	Nodes   map[string]Node
+	Mode    os.FileMode
+	ModTime time.Time
 }
 
 func saveFile(t testing.TB, repo restic.Repository, node File) restic.ID {
@@ -64,7 +72,14 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				lc = 1
 			}
 			fc := []restic.ID{}
-			if len(n.(File).Data) > 0 {
+			size := len(node.Data)
+			if len(node.Blobs) > 0 {
+				size = 0
+				for _, data := range node.Blobs {
+					fc = append(fc, saveFile(t, repo, File{Data: data}))
+					size += len(data)
+				}
+			} else if len(node.Data) > 0 {
 				fc = append(fc, saveFile(t, repo, node))
 			}
 			tree.Insert(&restic.Node{
@@ -74,9 +89,10 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				UID:     uint32(os.Getuid()),
 				GID:     uint32(os.Getgid()),
 				Content: fc,
-				Size:    uint64(len(n.(File).Data)),
+				Size:    uint64(size),
 				Inode:   fi,
 				Links:   lc,
+				ModTime: node.ModTime,
 			})
 		case Dir:
 			id := saveDir(t, repo, node.Nodes, inode)
@@ -93,6 +109,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				UID:     uint32(os.Getuid()),
 				GID:     uint32(os.Getgid()),
 				Subtree: &id,
+				ModTime: node.ModTime,
 			})
 		default:
 			t.Fatalf("unknown node type %T", node)
@@ -687,10 +704,536 @@ func TestRestorerTraverseTree(t *testing.T) {
 			// make sure we're creating a new subdir of the tempdir
 			target := filepath.Join(tempdir, "target")
 
-			err = res.traverseTree(ctx, target, string(filepath.Separator), *sn.Tree, test.Visitor(t))
+			err = res.traverseTree(ctx, target, target, string(filepath.Separator), *sn.Tree, test.Visitor(t))
 			if err != nil {
 				t.Fatal(err)
 			}
 		})
 	}
 }
+
+func TestRestoreRange(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	blobs := []string{"0123456789", "abcdefghij", strings.Repeat("\x00", 20), "klmnopqrst"}
+	content := strings.Join(blobs, "")
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"image": File{Blobs: blobs},
+				},
+			},
+			"empty": File{},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	var tests = []struct {
+		offset, length int64
+	}{
+		{0, int64(len(content))},
+		{0, 5},
+		{3, 4},
+		{10, 10},
+		{5, 10},
+		{5, 30},
+		{25, 10},
+		{35, 100},
+		{40, 10},
+		{100, 10},
+		{7, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d-%d", test.offset, test.length), func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			n, err := res.RestoreRange(context.TODO(), "/dir/image", test.offset, test.length, buf)
+			rtest.OK(t, err)
+
+			want := ""
+			if test.offset < int64(len(content)) {
+				end := test.offset + test.length
+				if end > int64(len(content)) {
+					end = int64(len(content))
+				}
+				want = content[test.offset:end]
+			}
+
+			rtest.Equals(t, int64(len(want)), n)
+			rtest.Equals(t, want, buf.String())
+		})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	n, err := res.RestoreRange(context.TODO(), "empty", 0, 10, buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(0), n)
+
+	for _, p := range []string{"/dir", "/missing", "/dir/image/foo"} {
+		_, err = res.RestoreRange(context.TODO(), p, 0, 10, buf)
+		rtest.Assert(t, err != nil, "expected error for path %v", p)
+	}
+
+	_, err = res.RestoreRange(context.TODO(), "/dir/image", -1, 10, buf)
+	rtest.Assert(t, err != nil, "expected error for negative offset")
+}
+
+// countingRepository counts the data blobs loaded.
+type countingRepository struct {
+	restic.Repository
+	loaded restic.IDSet
+}
+
+func (r *countingRepository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) (int, error) {
+	r.loaded.Insert(id)
+	return r.Repository.LoadBlob(ctx, t, id, buf)
+}
+
+func TestRestoreRangeLoadsOnlyNeededBlobs(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	blobs := []string{"first blob", "second blob", "third blob"}
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Blobs: blobs},
+		},
+	})
+
+	crepo := &countingRepository{Repository: repo, loaded: restic.NewIDSet()}
+	res, err := NewRestorer(crepo, id)
+	rtest.OK(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	_, err = res.RestoreRange(context.TODO(), "file", int64(len(blobs[0])+2), 3, buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, "con", buf.String())
+
+	rtest.Equals(t, restic.NewIDSet(restic.Hash([]byte(blobs[1]))), crepo.loaded)
+}
+
+func TestRestoreToWriter(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	blobs := []string{"0123456789", "abcdefghij", "klmnopqrst"}
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"image": File{Blobs: blobs},
+					"other": File{Data: "other file"},
+				},
+			},
+		},
+	})
+
+	selectPath := func(p string) func(string, string, *restic.Node) (bool, bool) {
+		p = filepath.FromSlash(p)
+		return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			return item == p, fs.HasPathPrefix(item, p) || fs.HasPathPrefix(p, item)
+		}
+	}
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	res.SelectFilter = selectPath("/dir/image")
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, res.RestoreToWriter(context.TODO(), buf))
+	rtest.Equals(t, strings.Join(blobs, ""), buf.String())
+
+	for _, p := range []string{"/dir", "/missing"} {
+		res.SelectFilter = selectPath(p)
+		err = res.RestoreToWriter(context.TODO(), ioutil.Discard)
+		rtest.Assert(t, err != nil, "expected error for path %v", p)
+	}
+
+	// more than one file is selected
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		return node.Type == "file", true
+	}
+	err = res.RestoreToWriter(context.TODO(), ioutil.Discard)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "2 files selected"),
+		"expected error for two selected files, got %v", err)
+}
+
+func TestRestorerPathMapping(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"home": Dir{
+				Nodes: map[string]Node{
+					"alice": Dir{
+						Nodes: map[string]Node{
+							"a.txt": File{Data: "content: a"},
+							"docs": Dir{
+								Nodes: map[string]Node{
+									"b.txt": File{Data: "content: b"},
+									"secret": Dir{
+										Nodes: map[string]Node{
+											"c.txt": File{Data: "content: c"},
+										},
+									},
+								},
+							},
+						},
+					},
+					"carol": Dir{
+						Nodes: map[string]Node{
+							"d.txt": File{Data: "content: d"},
+						},
+					},
+				},
+			},
+			"etc": Dir{
+				Nodes: map[string]Node{
+					"hosts": File{Data: "content: hosts"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	res.PathMappings = []PathMapping{
+		// the longest matching source wins, independent of the order
+		NewPathMapping("/home/alice/docs/secret", "/private"),
+		NewPathMapping("/home/alice", "/home/bob"),
+		// map a single file
+		NewPathMapping("/etc/hosts", "/etc/hosts.old"),
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res.SelectFilter = func(item, dstpath string, node *restic.Node) (bool, bool) {
+		if !fs.HasPathPrefix(tempdir, dstpath) {
+			t.Errorf("would restore %v to %v, which is not within the target dir %v", item, dstpath, tempdir)
+			return false, false
+		}
+		return true, true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"home/bob/a.txt":      "content: a",
+		"home/bob/docs/b.txt": "content: b",
+		"private/c.txt":       "content: c",
+		"home/carol/d.txt":    "content: d",
+		"etc/hosts.old":       "content: hosts",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if err != nil {
+			t.Errorf("unable to read file %v: %v", filename, err)
+			continue
+		}
+
+		if !bytes.Equal(data, []byte(content)) {
+			t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
+		}
+	}
+
+	for _, filename := range []string{"home/alice", "home/bob/docs/secret", "etc/hosts"} {
+		_, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.Assert(t, os.IsNotExist(err), "%v was restored at its original location", filename)
+	}
+
+	count, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, count)
+}
+
+func TestRestorerMapLocation(t *testing.T) {
+	res := &Restorer{
+		PathMappings: []PathMapping{
+			NewPathMapping("/home/alice", "/home/bob"),
+			NewPathMapping("/home/alice/work", "/work"),
+			NewPathMapping("/srv", "/"),
+		},
+	}
+
+	var tests = []struct {
+		location, mapped string
+	}{
+		{"/home", "/home"},
+		{"/home/alice", "/home/bob"},
+		{"/home/alice/file", "/home/bob/file"},
+		{"/home/alicex", "/home/alicex"},
+		{"/home/alice/work", "/work"},
+		{"/home/alice/work/dir/file", "/work/dir/file"},
+		{"/home/alice/workshop", "/home/bob/workshop"},
+		{"/srv/data", "/data"},
+	}
+
+	for _, test := range tests {
+		mapped := res.mapLocation(filepath.FromSlash(test.location))
+		rtest.Equals(t, filepath.FromSlash(test.mapped), mapped)
+	}
+}
+
+func TestRestorerOverwrite(t *testing.T) {
+	snapshotTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	olderTime := snapshotTime.Add(-24 * time.Hour)
+	newerTime := snapshotTime.Add(24 * time.Hour)
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"missing": File{Data: "content: missing", ModTime: snapshotTime},
+			"same":    File{Blobs: []string{"content: ", "same"}, ModTime: snapshotTime},
+			"changed": File{Data: "content: changed", ModTime: snapshotTime},
+			"longer":  File{Data: "content: longer", ModTime: snapshotTime},
+			"newer":   File{Data: "content: newer", ModTime: snapshotTime},
+		},
+	})
+
+	// existing files with their content and modification time
+	var existing = []struct {
+		name, content string
+		modTime       time.Time
+	}{
+		{"same", "content: same", olderTime},
+		{"changed", "CONTENT: CHANGED", olderTime},
+		{"longer", "content: longer, with more data", olderTime},
+		{"newer", "local: newer", newerTime},
+	}
+
+	var tests = []struct {
+		overwrite OverwriteBehavior
+		files     map[string]string
+	}{
+		{
+			overwrite: OverwriteAlways,
+			files: map[string]string{
+				"missing": "content: missing",
+				"same":    "content: same",
+				"changed": "content: changed",
+				"longer":  "content: longer",
+				"newer":   "content: newer",
+			},
+		},
+		{
+			overwrite: OverwriteIfNewer,
+			files: map[string]string{
+				"missing": "content: missing",
+				"same":    "content: same",
+				"changed": "content: changed",
+				"longer":  "content: longer",
+				"newer":   "local: newer",
+			},
+		},
+		{
+			overwrite: OverwriteIfChanged,
+			files: map[string]string{
+				"missing": "content: missing",
+				"same":    "content: same",
+				"changed": "content: changed",
+				"longer":  "content: longer",
+				"newer":   "content: newer",
+			},
+		},
+		{
+			overwrite: OverwriteNever,
+			files: map[string]string{
+				"missing": "content: missing",
+				"same":    "content: same",
+				"changed": "CONTENT: CHANGED",
+				"longer":  "content: longer, with more data",
+				"newer":   "local: newer",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overwrite.String(), func(t *testing.T) {
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			for _, f := range existing {
+				filename := filepath.Join(tempdir, f.name)
+				rtest.OK(t, ioutil.WriteFile(filename, []byte(f.content), 0644))
+				rtest.OK(t, os.Chtimes(filename, f.modTime, f.modTime))
+			}
+
+			res, err := NewRestorer(repo, id)
+			rtest.OK(t, err)
+			res.Overwrite = test.overwrite
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+			for filename, content := range test.files {
+				data, err := ioutil.ReadFile(filepath.Join(tempdir, filename))
+				if err != nil {
+					t.Errorf("unable to read file %v: %v", filename, err)
+					continue
+				}
+
+				if !bytes.Equal(data, []byte(content)) {
+					t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
+				}
+			}
+
+			// the metadata of skipped files is only restored for if-changed
+			fi, err := os.Lstat(filepath.Join(tempdir, "same"))
+			rtest.OK(t, err)
+			wantModTime := snapshotTime
+			if test.overwrite == OverwriteNever {
+				wantModTime = olderTime
+			}
+			if !fi.ModTime().Equal(wantModTime) {
+				t.Errorf("file same has wrong modification time: want %v, got %v", wantModTime, fi.ModTime())
+			}
+		})
+	}
+}
+
+func TestRestorerCheckOverwrite(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"same":    File{Blobs: []string{"content: ", "same"}},
+			"changed": File{Data: "content: changed"},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for name, content := range map[string]string{
+		"same":    "content: same",
+		"changed": "content: chAnged",
+	} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, name), []byte(content), 0644))
+	}
+
+	tree, err := repo.LoadTree(context.TODO(), *res.sn.Tree)
+	rtest.OK(t, err)
+
+	for name, want := range map[string]skipAction{
+		"same":    skipContent,
+		"changed": restoreItem,
+		"missing": restoreItem,
+	} {
+		node := tree.Find(name)
+		if node == nil {
+			node = &restic.Node{Name: name, Type: "file"}
+		}
+
+		action, err := res.checkOverwrite(node, filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		if action != want {
+			t.Errorf("%v: wrong action, want %v, got %v", name, want, action)
+		}
+	}
+}
+
+func TestParseOverwriteBehavior(t *testing.T) {
+	for _, b := range []OverwriteBehavior{OverwriteAlways, OverwriteIfNewer, OverwriteIfChanged, OverwriteNever} {
+		parsed, err := ParseOverwriteBehavior(b.String())
+		rtest.OK(t, err)
+		rtest.Equals(t, b, parsed)
+	}
+
+	_, err := ParseOverwriteBehavior("sometimes")
+	rtest.Assert(t, err != nil, "no error for invalid overwrite behavior")
+}
+
+func TestRestorerModifiedSince(t *testing.T) {
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	older := since.Add(-time.Hour)
+	newer := since.Add(time.Hour)
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"old.txt":   File{Data: "content: old", ModTime: older},
+			"new.txt":   File{Data: "content: new", ModTime: newer},
+			"exact.txt": File{Data: "content: exact", ModTime: since},
+			// the old directory is restored because it contains a new file
+			"olddir": Dir{
+				ModTime: older,
+				Nodes: map[string]Node{
+					"old.txt": File{Data: "content: old in olddir", ModTime: older},
+					"sub": Dir{
+						ModTime: older,
+						Nodes: map[string]Node{
+							"new.txt": File{Data: "content: new in olddir/sub", ModTime: newer},
+						},
+					},
+				},
+			},
+			"newdir": Dir{
+				ModTime: newer,
+				Nodes: map[string]Node{
+					"old.txt": File{Data: "content: old in newdir", ModTime: older},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.ModifiedSince = since
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"new.txt":            "content: new",
+		"exact.txt":          "content: exact",
+		"olddir/sub/new.txt": "content: new in olddir/sub",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if err != nil {
+			t.Errorf("unable to read file %v: %v", filename, err)
+			continue
+		}
+
+		if !bytes.Equal(data, []byte(content)) {
+			t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
+		}
+	}
+
+	for _, filename := range []string{"old.txt", "olddir/old.txt", "newdir/old.txt"} {
+		_, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.Assert(t, os.IsNotExist(err), "%v was restored although it is older than %v", filename, since)
+	}
+
+	// directories are restored independent of their modification time
+	for _, dirname := range []string{"olddir", "olddir/sub", "newdir"} {
+		fi, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(dirname)))
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.IsDir(), "%v is not a directory", dirname)
+	}
+}
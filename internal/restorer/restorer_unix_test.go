@@ -4,11 +4,15 @@ package restorer
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"syscall"
 	"testing"
 
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -59,3 +63,120 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+// inode returns the inode of the file at path.
+func inode(t testing.TB, path string) uint64 {
+	fi, err := os.Stat(path)
+	rtest.OK(t, err)
+	return uint64(fi.Sys().(*syscall.Stat_t).Ino)
+}
+
+func TestRestorerRestoreHardlinks(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	srcdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	archiver.TestCreateFiles(t, srcdir, archiver.TestDir{
+		"file": archiver.TestFile{Content: "hardlinked content"},
+		"dir": archiver.TestDir{
+			"subdir": archiver.TestDir{},
+		},
+		"other": archiver.TestFile{Content: "other content"},
+	})
+	rtest.OK(t, os.Link(filepath.Join(srcdir, "file"), filepath.Join(srcdir, "dir", "link")))
+	rtest.OK(t, os.Link(filepath.Join(srcdir, "file"), filepath.Join(srcdir, "dir", "subdir", "link")))
+
+	back := fs.TestChdir(t, srcdir)
+	archiver.TestSnapshot(t, repo, ".", nil)
+	back()
+
+	id, err := restic.FindLatestSnapshot(context.TODO(), repo, nil, nil, "")
+	rtest.OK(t, err)
+
+	links := []string{"file", "dir/link", "dir/subdir/link"}
+
+	restore := func(t *testing.T) (string, func()) {
+		res, err := NewRestorer(repo, id)
+		rtest.OK(t, err)
+
+		var warnings []string
+		res.Warn = func(msg string) {
+			warnings = append(warnings, msg)
+		}
+
+		tempdir, cleanup := rtest.TempDir(t)
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		for _, name := range links {
+			buf, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.OK(t, err)
+			rtest.Equals(t, "hardlinked content", string(buf))
+		}
+
+		if len(warnings) > 1 {
+			t.Errorf("expected at most one warning, got %v", warnings)
+		}
+
+		return tempdir, cleanup
+	}
+
+	t.Run("link", func(t *testing.T) {
+		tempdir, cleanup := restore(t)
+		defer cleanup()
+
+		want := inode(t, filepath.Join(tempdir, "file"))
+		for _, name := range links {
+			rtest.Equals(t, want, inode(t, filepath.Join(tempdir, filepath.FromSlash(name))))
+		}
+		rtest.Assert(t, inode(t, filepath.Join(tempdir, "other")) != want, "unrelated file is hardlinked")
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		link = func(oldname, newname string) error {
+			return errors.New("hardlinks are not supported")
+		}
+		defer func() {
+			link = fs.Link
+		}()
+
+		tempdir, cleanup := restore(t)
+		defer cleanup()
+
+		seen := make(map[uint64]struct{})
+		for _, name := range links {
+			seen[inode(t, filepath.Join(tempdir, filepath.FromSlash(name)))] = struct{}{}
+		}
+		rtest.Equals(t, len(links), len(seen))
+	})
+}
+
+func TestRestorerHardlinkToExistingFile(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file1": File{Data: "content", Links: 2, Inode: 1},
+			"file2": File{Data: "content", Links: 2, Inode: 1},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// file1 already has the content from the snapshot and is not restored
+	// again, file2 must still be linked to it
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file1"), []byte("content"), 0644))
+	want := inode(t, filepath.Join(tempdir, "file1"))
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	rtest.Equals(t, want, inode(t, filepath.Join(tempdir, "file1")))
+	rtest.Equals(t, want, inode(t, filepath.Join(tempdir, "file2")))
+}
@@ -124,7 +124,11 @@ func (b *Backup) Run(ctx context.Context) error {
 				continue
 			}
 
-			if b.totalCh == nil {
+			// the total is only known after the scan has finished, files may
+			// have grown in the meantime so more bytes than the total may be
+			// processed
+			secondsRemaining = 0
+			if b.totalCh == nil && processed.Bytes > 0 && processed.Bytes < total.Bytes {
 				secs := float64(time.Since(b.start) / time.Second)
 				todo := float64(total.Bytes - processed.Bytes)
 				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
@@ -0,0 +1,212 @@
+// Package eventsocket sends progress events to clients connected to a Unix
+// domain socket.
+package eventsocket
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// clientBuffer is the number of writes buffered for each client. A client
+// which falls behind by more than that is disconnected, so that a slow client
+// does not block the operation which reports its progress.
+const clientBuffer = 1024
+
+// writeTimeout is the time a client has to accept a single write before it is
+// disconnected. closeTimeout is the time Close waits for the clients to
+// receive the remaining events before their connections are closed.
+var (
+	writeTimeout = 10 * time.Second
+	closeTimeout = 5 * time.Second
+)
+
+// Server accepts clients on a Unix domain socket and sends everything written
+// to it to all connected clients. Each call to Write should contain complete
+// events, e.g. a line of JSON.
+type Server struct {
+	l net.Listener
+
+	m         sync.Mutex
+	clients   map[*client]struct{}
+	connected chan struct{}
+	closed    bool
+
+	// senders contains all clients whose connection is still open, including
+	// clients which were disconnected but still have buffered events
+	senders map[*client]struct{}
+
+	wg sync.WaitGroup
+}
+
+type client struct {
+	conn net.Conn
+	ch   chan []byte
+}
+
+// Listen creates the socket at path and starts accepting clients.
+func Listen(path string) (*Server, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Listen")
+	}
+
+	s := &Server{
+		l:         l,
+		clients:   make(map[*client]struct{}),
+		connected: make(chan struct{}),
+		senders:   make(map[*client]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			debug.Log("accept returned error, stopping: %v", err)
+			return
+		}
+
+		c := &client{conn: conn, ch: make(chan []byte, clientBuffer)}
+
+		s.m.Lock()
+		if s.closed {
+			s.m.Unlock()
+			_ = conn.Close()
+			return
+		}
+		if len(s.clients) == 0 {
+			select {
+			case <-s.connected:
+			default:
+				close(s.connected)
+			}
+		}
+		s.clients[c] = struct{}{}
+		s.senders[c] = struct{}{}
+		s.wg.Add(1)
+		s.m.Unlock()
+
+		debug.Log("client connected")
+		go s.send(c)
+	}
+}
+
+// send writes the events for c to its connection until the channel is closed
+// or writing fails. A client which does not accept a write within
+// writeTimeout is disconnected.
+func (s *Server) send(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.m.Lock()
+		delete(s.senders, c)
+		s.m.Unlock()
+		_ = c.conn.Close()
+	}()
+
+	for buf := range c.ch {
+		err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err == nil {
+			_, err = c.conn.Write(buf)
+		}
+		if err != nil {
+			debug.Log("writing to client failed, disconnecting: %v", err)
+			s.remove(c)
+			// drain the channel until it is closed by remove
+			for range c.ch {
+			}
+			return
+		}
+	}
+}
+
+// remove disconnects c, it must not be called with s.m held.
+func (s *Server) remove(c *client) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.ch)
+	}
+}
+
+// WaitForClient blocks until the first client has connected or ctx is
+// cancelled.
+func (s *Server) WaitForClient(ctx context.Context) error {
+	select {
+	case <-s.connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Write sends p to all connected clients. It never returns an error, clients
+// which cannot keep up are disconnected.
+func (s *Server) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c.ch <- buf:
+		default:
+			debug.Log("client does not keep up, disconnecting")
+			delete(s.clients, c)
+			close(c.ch)
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close stops accepting clients, sends the remaining events to the connected
+// clients and closes their connections. The connections of clients which do
+// not receive the remaining events within closeTimeout are closed right away.
+// The socket is removed.
+func (s *Server) Close() error {
+	s.m.Lock()
+	s.closed = true
+	for c := range s.clients {
+		delete(s.clients, c)
+		close(c.ch)
+	}
+	s.m.Unlock()
+
+	err := s.l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		s.m.Lock()
+		for c := range s.senders {
+			debug.Log("client does not receive the remaining events, disconnecting")
+			_ = c.conn.Close()
+		}
+		s.m.Unlock()
+		<-done
+	}
+
+	return errors.Wrap(err, "Close")
+}
@@ -0,0 +1,185 @@
+package eventsocket
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSocketPath(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "restic-eventsocket-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "events.sock"), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// waitForClients waits until n clients are connected to s.
+func waitForClients(t testing.TB, s *Server, n int) {
+	for i := 0; i < 1000; i++ {
+		s.m.Lock()
+		connected := len(s.clients)
+		s.m.Unlock()
+
+		if connected == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d clients did not connect", n)
+}
+
+func TestServerMultipleClients(t *testing.T) {
+	path, cleanup := testSocketPath(t)
+	defer cleanup()
+
+	s, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	err = s.WaitForClient(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, s, 2)
+
+	events := []string{"{\"message_type\":\"status\"}\n", "{\"message_type\":\"summary\"}\n"}
+	for _, ev := range events {
+		_, err = s.Write([]byte(ev))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, conn := range conns {
+		buf, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := events[0] + events[1]
+		if string(buf) != want {
+			t.Errorf("client %d: want %q, got %q", i, want, buf)
+		}
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket was not removed: %v", err)
+	}
+}
+
+func TestServerWaitForClientCancel(t *testing.T) {
+	path, cleanup := testSocketPath(t)
+	defer cleanup()
+
+	s, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.WaitForClient(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// testStuckClient connects a client which never reads to a new server and
+// writes more events than the socket can buffer.
+func testStuckClient(t *testing.T) (*Server, net.Conn, func()) {
+	path, cleanup := testSocketPath(t)
+
+	s, err := Listen(path)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		_ = s.Close()
+		cleanup()
+		t.Fatal(err)
+	}
+	waitForClients(t, s, 1)
+
+	event := make([]byte, 64*1024)
+	for i := 0; i < 200; i++ {
+		_, _ = s.Write(event)
+	}
+
+	return s, conn, func() {
+		_ = conn.Close()
+		cleanup()
+	}
+}
+
+// closeWithin calls s.Close and fails the test if it does not return within d.
+func closeWithin(t *testing.T, s *Server, d time.Duration) {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(d):
+		t.Fatalf("Close did not return within %v", d)
+	}
+}
+
+func TestServerCloseStuckClient(t *testing.T) {
+	defer func(w, c time.Duration) {
+		writeTimeout, closeTimeout = w, c
+	}(writeTimeout, closeTimeout)
+	writeTimeout = time.Hour
+	closeTimeout = 50 * time.Millisecond
+
+	s, _, cleanup := testStuckClient(t)
+	defer cleanup()
+
+	closeWithin(t, s, 10*time.Second)
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	defer func(w, c time.Duration) {
+		writeTimeout, closeTimeout = w, c
+	}(writeTimeout, closeTimeout)
+	writeTimeout = 50 * time.Millisecond
+	closeTimeout = time.Hour
+
+	s, _, cleanup := testStuckClient(t)
+	defer cleanup()
+
+	// the client is disconnected by the write deadline, Close does not need
+	// to wait for closeTimeout
+	closeWithin(t, s, 10*time.Second)
+}
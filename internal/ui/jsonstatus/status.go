@@ -3,6 +3,7 @@ package jsonstatus
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 	"sort"
 	"sync"
@@ -34,7 +35,8 @@ type Backup struct {
 	v     uint
 	start time.Time
 
-	totalBytes uint64
+	// out receives the events instead of the terminal, if set
+	out io.Writer
 
 	totalCh     chan counter
 	processedCh chan counter
@@ -42,6 +44,9 @@ type Backup struct {
 	workerCh    chan fileWorkerMessage
 	finished    chan struct{}
 
+	// statusMutex makes sure that no status is printed after the summary
+	statusMutex sync.Mutex
+
 	summary struct {
 		sync.Mutex
 		Files, Dirs struct {
@@ -49,6 +54,7 @@ type Backup struct {
 			Changed   uint
 			Unchanged uint
 		}
+		ProcessedBytes uint64
 		archiver.ItemStats
 	}
 }
@@ -101,7 +107,6 @@ func (b *Backup) Run(ctx context.Context) error {
 			} else {
 				// scan has finished
 				b.totalCh = nil
-				b.totalBytes = total.Bytes
 			}
 		case s := <-b.processedCh:
 			processed.Files += s.Files
@@ -122,7 +127,11 @@ func (b *Backup) Run(ctx context.Context) error {
 				continue
 			}
 
-			if b.totalCh == nil {
+			// the total is only known after the scan has finished, files may
+			// have grown in the meantime so more bytes than the total may be
+			// processed
+			secondsRemaining = 0
+			if b.totalCh == nil && processed.Bytes > 0 && processed.Bytes < total.Bytes {
 				secs := float64(time.Since(b.start) / time.Second)
 				todo := float64(total.Bytes - processed.Bytes)
 				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
@@ -154,6 +163,9 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 
 	if total.Bytes > 0 {
 		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
+		if status.PercentDone > 1 {
+			status.PercentDone = 1
+		}
 	}
 
 	for filename := range currentFiles {
@@ -161,13 +173,22 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 	}
 	sort.Strings(status.CurrentFiles)
 
-	json.NewEncoder(b.StdioWrapper.Stdout()).Encode(status)
+	b.statusMutex.Lock()
+	defer b.statusMutex.Unlock()
+
+	select {
+	case <-b.finished:
+		return
+	default:
+	}
+
+	json.NewEncoder(b.stdout()).Encode(status)
 }
 
 // ScannerError is the error callback function for the scanner, it prints the
 // error in verbose mode and returns nil.
 func (b *Backup) ScannerError(item string, fi os.FileInfo, err error) error {
-	json.NewEncoder(b.StdioWrapper.Stderr()).Encode(errorUpdate{
+	json.NewEncoder(b.stderr()).Encode(errorUpdate{
 		MessageType: "error",
 		Error:       err,
 		During:      "scan",
@@ -178,7 +199,7 @@ func (b *Backup) ScannerError(item string, fi os.FileInfo, err error) error {
 
 // Error is the error callback function for the archiver, it prints the error and returns nil.
 func (b *Backup) Error(item string, fi os.FileInfo, err error) error {
-	json.NewEncoder(b.StdioWrapper.Stderr()).Encode(errorUpdate{
+	json.NewEncoder(b.stderr()).Encode(errorUpdate{
 		MessageType: "error",
 		Error:       err,
 		During:      "archival",
@@ -205,6 +226,12 @@ func (b *Backup) CompleteBlob(filename string, bytes uint64) {
 func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration) {
 	b.summary.Lock()
 	b.summary.ItemStats.Add(s)
+
+	// for the last item "/", current is nil
+	if current != nil {
+		b.summary.ProcessedBytes += current.Size
+	}
+
 	b.summary.Unlock()
 
 	if current == nil {
@@ -230,7 +257,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 	if current.Type == "dir" {
 		if previous == nil {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType:  "verbose_status",
 					Action:       "new",
 					Item:         item,
@@ -247,7 +274,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 		if previous.Equals(*current) {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "unchanged",
 					Item:        item,
@@ -258,7 +285,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Unlock()
 		} else {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType:  "verbose_status",
 					Action:       "modified",
 					Item:         item,
@@ -281,7 +308,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 		if previous == nil {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "new",
 					Item:        item,
@@ -297,7 +324,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 		if previous.Equals(*current) {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "unchanged",
 					Item:        item,
@@ -308,7 +335,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Unlock()
 		} else {
 			if b.v >= 3 {
-				json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+				json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "modified",
 					Item:        item,
@@ -332,7 +359,7 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 
 	if item == "" {
 		if b.v >= 2 {
-			json.NewEncoder(b.StdioWrapper.Stdout()).Encode(verboseUpdate{
+			json.NewEncoder(b.stdout()).Encode(verboseUpdate{
 				MessageType: "status",
 				Action:      "scan_finished",
 				Duration:    time.Since(b.start).Seconds(),
@@ -347,8 +374,11 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 
 // Finish prints the finishing messages.
 func (b *Backup) Finish(snapshotID restic.ID) {
+	b.statusMutex.Lock()
+	defer b.statusMutex.Unlock()
+
 	close(b.finished)
-	json.NewEncoder(b.StdioWrapper.Stdout()).Encode(summaryOutput{
+	json.NewEncoder(b.stdout()).Encode(summaryOutput{
 		MessageType:         "summary",
 		FilesNew:            b.summary.Files.New,
 		FilesChanged:        b.summary.Files.Changed,
@@ -359,13 +389,34 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		DataBlobs:           b.summary.ItemStats.DataBlobs,
 		TreeBlobs:           b.summary.ItemStats.TreeBlobs,
 		DataAdded:           b.summary.ItemStats.DataSize + b.summary.ItemStats.TreeSize,
+		DataAddedInRepo:     b.summary.ItemStats.DataSizeInRepo + b.summary.ItemStats.TreeSizeInRepo,
 		TotalFilesProcessed: b.summary.Files.New + b.summary.Files.Changed + b.summary.Files.Unchanged,
-		TotalBytesProcessed: b.totalBytes,
+		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
 		SnapshotID:          snapshotID.Str(),
 	})
 }
 
+// SetOutput sends all events to w instead of the terminal. Each event is
+// written with a single call to w.Write.
+func (b *Backup) SetOutput(w io.Writer) {
+	b.out = w
+}
+
+func (b *Backup) stdout() io.Writer {
+	if b.out != nil {
+		return b.out
+	}
+	return b.StdioWrapper.Stdout()
+}
+
+func (b *Backup) stderr() io.Writer {
+	if b.out != nil {
+		return b.out
+	}
+	return b.StdioWrapper.Stderr()
+}
+
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
 // ArchiveProgressReporter interface.
 func (b *Backup) SetMinUpdatePause(d time.Duration) {
@@ -413,6 +464,7 @@ type summaryOutput struct {
 	DataBlobs           int     `json:"data_blobs"`
 	TreeBlobs           int     `json:"tree_blobs"`
 	DataAdded           uint64  `json:"data_added"`
+	DataAddedInRepo     uint64  `json:"data_added_in_repo"` // including the encryption overhead
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
@@ -39,6 +39,13 @@ type WalkFunc func(parentTreeID restic.ID, path string, node *restic.Node, nodeE
 // error, it is passed up the call stack. The trees in ignoreTrees are not
 // walked. If walkFn ignores trees, these are added to the set.
 func Walk(ctx context.Context, repo TreeLoader, root restic.ID, ignoreTrees restic.IDSet, walkFn WalkFunc) error {
+	return walkRoot(ctx, repo, nil, root, ignoreTrees, walkFn)
+}
+
+// walkRoot walks the tree root like Walk. If prefetch is not nil, it is called
+// with the IDs of the subtrees of each tree before the nodes of the tree are
+// walked.
+func walkRoot(ctx context.Context, repo TreeLoader, prefetch func(restic.IDs), root restic.ID, ignoreTrees restic.IDSet, walkFn WalkFunc) error {
 	tree, err := repo.LoadTree(ctx, root)
 	_, err = walkFn(root, "/", nil, err)
 
@@ -53,14 +60,14 @@ func Walk(ctx context.Context, repo TreeLoader, root restic.ID, ignoreTrees rest
 		ignoreTrees = restic.NewIDSet()
 	}
 
-	_, err = walk(ctx, repo, "/", root, tree, ignoreTrees, walkFn)
+	_, err = walk(ctx, repo, prefetch, "/", root, tree, ignoreTrees, walkFn)
 	return err
 }
 
 // walk recursively traverses the tree, ignoring subtrees when the ID of the
 // subtree is in ignoreTrees. If err is nil and ignore is true, the subtree ID
 // will be added to ignoreTrees by walk.
-func walk(ctx context.Context, repo TreeLoader, prefix string, parentTreeID restic.ID, tree *restic.Tree, ignoreTrees restic.IDSet, walkFn WalkFunc) (ignore bool, err error) {
+func walk(ctx context.Context, repo TreeLoader, prefetch func(restic.IDs), prefix string, parentTreeID restic.ID, tree *restic.Tree, ignoreTrees restic.IDSet, walkFn WalkFunc) (ignore bool, err error) {
 	var allNodesIgnored = true
 
 	if len(tree.Nodes) == 0 {
@@ -71,6 +78,16 @@ func walk(ctx context.Context, repo TreeLoader, prefix string, parentTreeID rest
 		return tree.Nodes[i].Name < tree.Nodes[j].Name
 	})
 
+	if prefetch != nil {
+		var subtrees restic.IDs
+		for _, node := range tree.Nodes {
+			if node.Type == "dir" && node.Subtree != nil && !ignoreTrees.Has(*node.Subtree) {
+				subtrees = append(subtrees, *node.Subtree)
+			}
+		}
+		prefetch(subtrees)
+	}
+
 	for _, node := range tree.Nodes {
 		p := path.Join(prefix, node.Name)
 
@@ -124,7 +141,7 @@ func walk(ctx context.Context, repo TreeLoader, prefix string, parentTreeID rest
 			allNodesIgnored = false
 		}
 
-		ignore, err = walk(ctx, repo, p, *node.Subtree, subtree, ignoreTrees, walkFn)
+		ignore, err = walk(ctx, repo, prefetch, p, *node.Subtree, subtree, ignoreTrees, walkFn)
 		if err != nil {
 			return false, err
 		}
@@ -0,0 +1,224 @@
+package walker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// prefetchPerWorker is the number of trees per worker which are loaded by
+// WalkParallel before they are needed.
+const prefetchPerWorker = 4
+
+// WalkParallel is like Walk, but the subtrees of the trees are loaded by a
+// pool of workers goroutines while walkFn is called for the nodes. walkFn is
+// called from the calling goroutine and in the same order as by Walk. At most
+// a few trees per worker are loaded ahead of the walk, so the memory used does
+// not depend on the size of the trees.
+func WalkParallel(ctx context.Context, repo TreeLoader, root restic.ID, ignoreTrees restic.IDSet, workers int, walkFn WalkFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := newTreePrefetcher(ctx, repo, workers, workers*prefetchPerWorker)
+	defer p.Close()
+
+	return walkRoot(ctx, p, p.Prefetch, root, ignoreTrees, walkFn)
+}
+
+// treePrefetcher loads trees in the background in the order in which they
+// will be walked. The queue is kept in depth-first order: the subtrees of a
+// tree are inserted in front of the remaining entries when the tree is
+// walked. When a tree is requested, all entries in front of it belong to trees
+// the walk skipped, these are dropped. A tree may be queued several times if
+// it is referenced by more than one node.
+type treePrefetcher struct {
+	repo   TreeLoader
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	m       sync.Mutex
+	cond    *sync.Cond
+	queue   *list.List
+	entries map[restic.ID]int // number of entries in the queue for each tree
+	max     int
+	active  int // number of trees which are being loaded or were loaded
+	closed  bool
+}
+
+type prefetchEntry struct {
+	id      restic.ID
+	started bool
+	dropped bool
+
+	done chan struct{}
+	tree *restic.Tree
+	err  error
+}
+
+// newTreePrefetcher starts workers goroutines which load trees from repo.
+// At most max trees are loaded before they are requested.
+func newTreePrefetcher(ctx context.Context, repo TreeLoader, workers, max int) *treePrefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+
+	p := &treePrefetcher{
+		repo:    repo,
+		cancel:  cancel,
+		queue:   list.New(),
+		entries: make(map[restic.ID]int),
+		max:     max,
+	}
+	p.cond = sync.NewCond(&p.m)
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx)
+	}
+
+	return p
+}
+
+// Prefetch queues the trees ids, which are walked next in this order.
+func (p *treePrefetcher) Prefetch(ids restic.IDs) {
+	if len(ids) == 0 {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	next := p.queue.Front()
+	for _, id := range ids {
+		e := &prefetchEntry{id: id, done: make(chan struct{})}
+		if next == nil {
+			p.queue.PushBack(e)
+		} else {
+			p.queue.InsertBefore(e, next)
+		}
+		p.entries[id]++
+	}
+
+	p.cond.Broadcast()
+}
+
+// LoadTree returns the tree id. If it was queued, the prefetched tree is
+// returned, otherwise it is loaded from the repository.
+func (p *treePrefetcher) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	p.m.Lock()
+	if p.entries[id] == 0 {
+		p.m.Unlock()
+		return p.repo.LoadTree(ctx, id)
+	}
+
+	for p.queue.Front().Value.(*prefetchEntry).id != id {
+		p.drop(p.queue.Front())
+	}
+
+	e := p.remove(p.queue.Front())
+	p.cond.Broadcast()
+	p.m.Unlock()
+
+	if !e.started {
+		return p.repo.LoadTree(ctx, id)
+	}
+
+	<-e.done
+
+	p.m.Lock()
+	p.active--
+	p.cond.Broadcast()
+	p.m.Unlock()
+
+	return e.tree, e.err
+}
+
+// drop removes the entry el from the queue. If the tree is still being
+// loaded, the worker releases it when it is done. p.m must be held.
+func (p *treePrefetcher) drop(el *list.Element) {
+	e := p.remove(el)
+	debug.Log("dropping prefetched tree %v", e.id.Str())
+
+	if !e.started {
+		return
+	}
+
+	select {
+	case <-e.done:
+		p.active--
+	default:
+		e.dropped = true
+	}
+}
+
+// remove removes the entry el from the queue and returns it. p.m must be held.
+func (p *treePrefetcher) remove(el *list.Element) *prefetchEntry {
+	e := p.queue.Remove(el).(*prefetchEntry)
+	p.entries[e.id]--
+	if p.entries[e.id] == 0 {
+		delete(p.entries, e.id)
+	}
+	return e
+}
+
+// next returns the next entry to be loaded. It blocks until an entry is
+// available and fewer than max trees are loaded. When the prefetcher is
+// closed, nil is returned.
+func (p *treePrefetcher) next() *prefetchEntry {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for {
+		if p.closed {
+			return nil
+		}
+
+		if p.active < p.max {
+			for el := p.queue.Front(); el != nil; el = el.Next() {
+				e := el.Value.(*prefetchEntry)
+				if !e.started {
+					e.started = true
+					p.active++
+					return e
+				}
+			}
+		}
+
+		p.cond.Wait()
+	}
+}
+
+func (p *treePrefetcher) worker(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		e := p.next()
+		if e == nil {
+			return
+		}
+
+		e.tree, e.err = p.repo.LoadTree(ctx, e.id)
+		close(e.done)
+
+		p.m.Lock()
+		if e.dropped {
+			p.active--
+			p.cond.Broadcast()
+		}
+		p.m.Unlock()
+	}
+}
+
+// Close stops the workers and waits for them to finish.
+func (p *treePrefetcher) Close() {
+	p.cancel()
+
+	p.m.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.m.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,171 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// buildTestTree returns a tree with depth levels of fanout subdirs. Some of
+// the subdirs are empty or identical, so that their trees are referenced
+// several times.
+func buildTestTree(depth, fanout int) TestTree {
+	tree := TestTree{}
+	for i := 0; i < fanout; i++ {
+		tree[fmt.Sprintf("file%d", i)] = TestFile{}
+	}
+
+	if depth == 0 {
+		return tree
+	}
+
+	for i := 0; i < fanout; i++ {
+		switch i % 3 {
+		case 0:
+			tree[fmt.Sprintf("dir%d", i)] = TestTree{}
+		case 1:
+			tree[fmt.Sprintf("dir%d", i)] = buildTestTree(depth-1, fanout)
+		case 2:
+			tree[fmt.Sprintf("dir%d", i)] = buildTestTree(depth-1, fanout-1)
+		}
+	}
+
+	return tree
+}
+
+// delayedTreeLoader loads trees from a TreeMap after a delay.
+type delayedTreeLoader struct {
+	trees TreeMap
+	delay func() time.Duration
+}
+
+func (l delayedTreeLoader) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	select {
+	case <-time.After(l.delay()):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return l.trees.LoadTree(ctx, id)
+}
+
+// recordWalk returns a WalkFunc which records the nodes it is called for and
+// skips and ignores some of them.
+func recordWalk(nodes *[]string) WalkFunc {
+	return func(treeID restic.ID, p string, node *restic.Node, err error) (bool, error) {
+		*nodes = append(*nodes, fmt.Sprintf("%v %v %v", treeID.Str(), p, err))
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case strings.HasSuffix(p, "/dir4"):
+			return false, SkipNode
+		case strings.HasSuffix(p, "/dir2/file3"):
+			return false, SkipNode
+		case path.Base(p) == "dir1" && strings.Count(p, "/") > 2:
+			return true, nil
+		}
+		return false, nil
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	trees, root := BuildTreeMap(buildTestTree(4, 6))
+
+	var want []string
+	err := Walk(context.TODO(), trees, root, restic.NewIDSet(), recordWalk(&want))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 5, 20} {
+		t.Run(fmt.Sprintf("workers-%d", workers), func(t *testing.T) {
+			repo := delayedTreeLoader{
+				trees: trees,
+				delay: func() time.Duration {
+					return time.Duration(rand.Intn(200)) * time.Microsecond
+				},
+			}
+
+			var got []string
+			err := WalkParallel(context.TODO(), repo, root, restic.NewIDSet(), workers, recordWalk(&got))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("wrong number of nodes walked, want %d, got %d", len(want), len(got))
+			}
+
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("wrong node at position %d, want %q, got %q", i, want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWalkParallelError(t *testing.T) {
+	trees, root := BuildTreeMap(buildTestTree(3, 5))
+
+	// remove a tree which is referenced deep in the tree
+	var missing restic.ID
+	for id, tree := range trees {
+		if len(tree.Nodes) == 4 {
+			missing = id
+			delete(trees, id)
+			break
+		}
+	}
+	if missing.IsNull() {
+		t.Fatal("no tree to remove found")
+	}
+
+	walkFn := func(treeID restic.ID, p string, node *restic.Node, err error) (bool, error) {
+		return false, err
+	}
+
+	err := WalkParallel(context.TODO(), trees, root, restic.NewIDSet(), 4, walkFn)
+	if err == nil || err.Error() != "tree not found" {
+		t.Fatalf("expected error for missing tree %v, got %v", missing.Str(), err)
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	trees, root := BuildTreeMap(buildTestTree(4, 6))
+	repo := delayedTreeLoader{
+		trees: trees,
+		delay: func() time.Duration { return 100 * time.Microsecond },
+	}
+
+	walkFn := func(treeID restic.ID, p string, node *restic.Node, err error) (bool, error) {
+		return false, err
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := Walk(context.TODO(), repo, root, restic.NewIDSet(), walkFn)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, workers := range []int{2, 8, 32} {
+		b.Run(fmt.Sprintf("parallel-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := WalkParallel(context.TODO(), repo, root, restic.NewIDSet(), workers, walkFn)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}